@@ -0,0 +1,163 @@
+// Command migrator manages the database schema apart from the service:
+//
+//	migrator [-config path] up [N]     apply all or N pending migrations
+//	migrator [-config path] down [N]   roll back N migrations, 1 by default
+//	migrator [-config path] version    print the current version
+//	migrator [-config path] force V    set the version after fixing a failed migration by hand
+//
+// The database and migrations_path come from the same config and environment
+// as the service.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"sso/internal/config"
+	"sso/internal/storage/mysql"
+	"sso/internal/storage/postgres"
+	"sso/migrations"
+	"strconv"
+
+	"github.com/golang-migrate/migrate/v4"
+)
+
+func main() {
+	cfg := config.MustLoad()
+
+	if err := run(cfg, flag.Args()); err != nil {
+		fmt.Fprintln(os.Stderr, "migrator:", err)
+		os.Exit(1)
+	}
+}
+
+type migrator interface {
+	Migrator(source fs.FS) (*migrate.Migrate, error)
+	Close()
+}
+
+func open(cfg *config.Config) (migrator, error) {
+	if cfg.Storage == "memory" {
+		return nil, errors.New("memory storage has no schema to migrate")
+	}
+
+	dsn, err := cfg.Database.DSN(cfg.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Storage == "mysql" {
+		return mysql.New(dsn)
+	}
+
+	return postgres.New(dsn)
+}
+
+func run(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New("command required: up, down, version or force")
+	}
+
+	storage, err := open(cfg)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	m, err := storage.Migrator(migrations.Source(cfg.Storage, cfg.MigrationsPath))
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	cmd, args := args[0], args[1:]
+
+	switch cmd {
+	case "up":
+		n, err := steps(args, 0)
+		if err != nil {
+			return err
+		}
+
+		if n == 0 {
+			err = m.Up()
+		} else {
+			err = m.Steps(n)
+		}
+		if err != nil && !errors.Is(err, migrate.ErrNoChange) {
+			return err
+		}
+	case "down":
+		n, err := steps(args, 1)
+		if err != nil {
+			return err
+		}
+
+		if err := m.Steps(-n); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+			return err
+		}
+	case "version":
+		if len(args) != 0 {
+			return errors.New("usage: version")
+		}
+	case "force":
+		if len(args) != 1 {
+			return errors.New("usage: force V")
+		}
+
+		// -1 означает, что ни одной миграции не применено
+		v, err := strconv.Atoi(args[0])
+		if err != nil || v < -1 {
+			return fmt.Errorf("invalid version %q", args[0])
+		}
+
+		if err := m.Force(v); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+
+	return printVersion(m)
+}
+
+// steps parses the optional number of migrations.
+func steps(args []string, def int) (int, error) {
+	switch len(args) {
+	case 0:
+		return def, nil
+	case 1:
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid number of migrations %q", args[0])
+		}
+
+		return n, nil
+	default:
+		return 0, errors.New("too many arguments")
+	}
+}
+
+func printVersion(m *migrate.Migrate) error {
+	version, dirty, err := m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		fmt.Println("no migrations applied")
+
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if dirty {
+		fmt.Printf("version %d (dirty: the migration failed halfway, fix it and force the version)\n", version)
+
+		return nil
+	}
+
+	fmt.Printf("version %d\n", version)
+
+	return nil
+}
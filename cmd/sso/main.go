@@ -6,7 +6,7 @@ import (
 	"os/signal"
 	"sso/internal/app"
 	"sso/internal/config"
-	"strconv"
+	"sso/internal/lib/logger/sl"
 	"syscall"
 )
 
@@ -20,23 +20,41 @@ func main() {
 	cfg := config.MustLoad()
 
 	log := setupLogger(cfg.Env)
+	if cfg.Region != "" {
+		log = log.With(slog.String("region", cfg.Region))
+	}
 
-	log.Info(strconv.Itoa(cfg.GRPC.Port))
+	log.Info("starting sso", slog.Any("config", cfg))
 
-	application := app.New(log, cfg.GRPC.Port, cfg.TokenTTL)
+	application := app.New(log, cfg)
 
 	go func() {
 		application.GRPCServer.MustRun()
 	}()
 
+	if application.HTTPServer != nil {
+		go func() {
+			if err := application.HTTPServer.MustRun(); err != nil {
+				log.Error("http server failed", sl.Err(err))
+			}
+		}()
+	}
+
+	if application.DebugServer != nil {
+		go func() {
+			if err := application.DebugServer.MustRun(); err != nil {
+				log.Error("debug server failed", sl.Err(err))
+			}
+		}()
+	}
+
 	stop := make(chan os.Signal, 1)
 
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
 
 	<-stop
 
-	application.GRPCServer.Stop()
-	application.Storage.Close()
+	application.Shutdown()
 
 	log.Info("Gracefully stopped")
 
@@ -1,29 +1,605 @@
 package app
 
 import (
+	"context"
+	"crypto/tls"
+	"fmt"
 	"log/slog"
+	"net"
+	"net/http"
 	grpcapp "sso/internal/app/grpc"
+	httpapp "sso/internal/app/http"
+	"sso/internal/config"
+	"sso/internal/domain/models"
+	"sso/internal/grpc/health"
+	"sso/internal/grpc/interceptors"
+	grpcweb "sso/internal/grpc/web"
+	"sso/internal/http/api"
+	"sso/internal/http/debug"
+	"sso/internal/http/gateway"
+	"sso/internal/http/middleware"
+	"sso/internal/http/oauth"
+	"sso/internal/http/scim"
+	"sso/internal/lib/breaker"
+	"sso/internal/lib/captcha"
+	"sso/internal/lib/clientinfo"
+	"sso/internal/lib/events"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/ldap"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/mail"
+	"sso/internal/lib/mtls"
+	"sso/internal/lib/outbox"
+	"sso/internal/lib/passhash"
+	"sso/internal/lib/pwned"
+	"sso/internal/lib/ratelimit"
+	"sso/internal/lib/secret"
+	"sso/internal/lib/sms"
+	"sso/internal/lib/social"
+	"sso/internal/lib/webauthn"
+	"sso/internal/lib/webhook"
 	"sso/internal/services/auth"
-	"sso/internal/storage/postgres"
+	"sso/internal/storage/appcache"
+	"sso/internal/storage/redis"
+	"sso/migrations"
+	"strconv"
+	"strings"
 	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
 )
 
 type App struct {
 	GRPCServer *grpcapp.App
-	Storage    *postgres.Storage
+	// HTTPServer is nil unless http.port is set.
+	HTTPServer *httpapp.App
+	// DebugServer is nil unless debug.port is set.
+	DebugServer *httpapp.App
+	Storage     Storage
+	// Redis is nil unless a feature backed by it is enabled.
+	Redis *redis.Storage
+
+	log             *slog.Logger
+	readiness       *readiness
+	relay           *outbox.Relay
+	webhooks        *webhook.Dispatcher
+	drainDelay      time.Duration
+	shutdownTimeout time.Duration
+	// nats is nil unless events are published to NATS.
+	nats *events.NATS
+}
+
+// State returns the readiness of the instance.
+func (a *App) State() State {
+	return State(a.readiness.state.Load())
+}
+
+// Shutdown marks the instance as not ready, waits for grpc.drain_delay so that
+// load balancers stop sending requests, stops the servers letting calls in
+// progress finish within grpc.shutdown_timeout, waits for events and webhook
+// deliveries in progress and closes the storages.
+func (a *App) Shutdown() {
+	a.log.Info("shutting down", slog.String("state", a.State().String()))
+
+	a.readiness.drain(a.drainDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), a.shutdownTimeout)
+	defer cancel()
+
+	if a.HTTPServer != nil {
+		a.HTTPServer.Stop(ctx)
+	}
+	a.GRPCServer.Stop(ctx)
+	if a.DebugServer != nil {
+		a.DebugServer.Stop(ctx)
+	}
+
+	a.relay.Stop()
+	a.webhooks.Stop()
+	if a.nats != nil {
+		if err := a.nats.Close(); err != nil {
+			a.log.Error("failed to close nats", sl.Err(err))
+		}
+	}
+
+	a.log.Info("servers stopped, closing storages")
+
+	a.Storage.Close()
+	if a.Redis != nil {
+		if err := a.Redis.Close(); err != nil {
+			a.log.Error("failed to close redis", sl.Err(err))
+		}
+	}
 }
 
-func New(log *slog.Logger, grpcPort int, tokenTTL time.Duration) *App {
-	storage, err := postgres.New()
+// Option customizes the service beyond what the config allows.
+type Option func(o *options)
+
+type options struct {
+	enricher jwt.ClaimsEnricher
+}
+
+// WithClaimsEnricher adds claims of the enricher to issued access tokens.
+func WithClaimsEnricher(enricher jwt.ClaimsEnricher) Option {
+	return func(o *options) {
+		o.enricher = enricher
+	}
+}
+
+func New(log *slog.Logger, cfg *config.Config, opts ...Option) *App {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	var storageBreaker *breaker.Breaker
+	if cfg.Storage == "postgres" && cfg.Database.Breaker.Failures > 0 {
+		storageBreaker = breaker.New(log, cfg.Database.Breaker.Failures, cfg.Database.Breaker.OpenFor)
+	}
+
+	storage, err := newStorage(log, cfg, storageBreaker)
+	if err != nil {
+		panic(err)
+	}
+
+	// Миграции из каталога заменяют встроенные, например для отладки новой миграции
+	migrationsFS := migrations.Source(cfg.Storage, cfg.MigrationsPath)
+
+	if cfg.Migrate {
+		version, err := storage.Migrate(migrationsFS)
+		if err != nil {
+			panic(err)
+		}
+
+		log.Info("migrations applied", slog.Uint64("version", uint64(version)))
+	}
+
+	var smsSender sms.Sender = sms.Disabled{}
+	switch cfg.SMS.Provider {
+	case "log":
+		smsSender = sms.NewLogSender(log)
+	case "http":
+		smsSender = sms.NewHTTPSender(cfg.SMS.URL, cfg.SMS.Token, cfg.SMS.Timeout)
+	}
+
+	signingKeys := make(map[int]*jwt.SigningKey, len(cfg.SigningKeys))
+	for appID, path := range cfg.SigningKeys {
+		key, err := jwt.LoadSigningKey(path)
+		if err != nil {
+			panic(fmt.Sprintf("signing key of app %d: %v", appID, err))
+		}
+		signingKeys[appID] = key
+	}
+
+	var mailer mail.Sender = mail.Disabled{}
+	if cfg.Email.Provider == "log" {
+		mailer = mail.NewLogSender(log)
+	}
+
+	var mfaBox *secret.Box
+	if cfg.MFA.EncryptionKey != "" {
+		mfaBox, err = secret.NewBoxFromString(cfg.MFA.EncryptionKey)
+		if err != nil {
+			panic("mfa encryption key: " + err.Error())
+		}
+	}
+
+	// iss в ID токенах должен совпадать с issuer из discovery
+	issuer := strings.TrimSuffix(cfg.HTTP.Issuer, "/")
+
+	socialProviders := make(map[string]social.Provider)
+	for name, pc := range map[string]config.SocialProviderConfig{"google": cfg.Social.Google, "github": cfg.Social.GitHub} {
+		if pc.ClientID == "" {
+			continue
+		}
+		if issuer == "" {
+			panic("social login with " + name + " requires http.issuer")
+		}
+
+		sc := social.Config{
+			ClientID:     pc.ClientID,
+			ClientSecret: pc.ClientSecret,
+			RedirectURL:  issuer + "/oauth/social/" + name + "/callback",
+		}
+		httpClient := &http.Client{Timeout: 10 * time.Second}
+
+		switch name {
+		case "google":
+			socialProviders[name] = social.NewGoogle(sc, httpClient)
+		case "github":
+			socialProviders[name] = social.NewGitHub(sc, httpClient)
+		}
+	}
+
+	directories := make(map[string]auth.Directory)
+	for _, lc := range cfg.LDAP {
+		groupRoles := make([]ldap.GroupRole, 0, len(lc.GroupRoles))
+		for _, gr := range lc.GroupRoles {
+			groupRoles = append(groupRoles, ldap.GroupRole{Group: gr.Group, Role: gr.Role})
+		}
+
+		dir := ldap.New(ldap.Config{
+			URL:          lc.URL,
+			StartTLS:     lc.StartTLS,
+			BindDN:       lc.BindDN,
+			BindPassword: lc.BindPassword,
+			BaseDN:       lc.BaseDN,
+			UserFilter:   lc.UserFilter,
+			GroupRoles:   groupRoles,
+			DefaultRole:  lc.DefaultRole,
+			Timeout:      lc.Timeout,
+		})
+		for _, d := range lc.Domains {
+			directories[strings.ToLower(strings.TrimPrefix(strings.TrimSpace(d), "@"))] = dir
+		}
+	}
+
+	var (
+		publisher events.Publisher = events.Disabled{}
+		natsConn  *events.NATS
+	)
+	switch cfg.Events.Transport {
+	case "":
+	case "nats":
+		natsConn, err = events.NewNATS(cfg.Events.NATS.URL, cfg.Events.NATS.SubjectPrefix)
+		if err != nil {
+			panic(err)
+		}
+		publisher = natsConn
+	default:
+		panic("unknown events transport: " + cfg.Events.Transport)
+	}
+
+	var captchaVerifier captcha.Verifier
+	if cfg.Captcha.Provider != "" {
+		httpClient := &http.Client{Timeout: cfg.Captcha.Timeout}
+
+		switch cfg.Captcha.Provider {
+		case "recaptcha":
+			captchaVerifier = captcha.NewReCAPTCHA(cfg.Captcha.Secret, cfg.Captcha.MinScore, httpClient)
+		case "hcaptcha":
+			captchaVerifier = captcha.NewHCaptcha(cfg.Captcha.Secret, httpClient)
+		case "turnstile":
+			captchaVerifier = captcha.NewTurnstile(cfg.Captcha.Secret, httpClient)
+		default:
+			panic("unknown captcha provider: " + cfg.Captcha.Provider)
+		}
+	}
+
+	peppers := make(map[int][]byte, len(cfg.PasswordHash.Peppers))
+	for version, pepper := range cfg.PasswordHash.Peppers {
+		peppers[version] = []byte(pepper)
+	}
+
+	passwords, err := passhash.New(cfg.PasswordHash.Algorithm, passhash.Argon2Params{
+		Time:    cfg.PasswordHash.Argon2.Time,
+		Memory:  cfg.PasswordHash.Argon2.MemoryKiB,
+		Threads: cfg.PasswordHash.Argon2.Threads,
+	}, peppers)
 	if err != nil {
 		panic(err)
 	}
 
-	authService := auth.New(log, storage, storage, storage, storage, tokenTTL)
+	var breaches auth.BreachPolicy
+	switch cfg.PasswordHash.Breach.Mode {
+	case "":
+	case "reject", "warn":
+		breaches.Checker = pwned.New(cfg.PasswordHash.Breach.URL, &http.Client{Timeout: cfg.PasswordHash.Breach.Timeout})
+		breaches.Reject = cfg.PasswordHash.Breach.Mode == "reject"
+	default:
+		panic("unknown password breach mode: " + cfg.PasswordHash.Breach.Mode)
+	}
+
+	var redisStorage *redis.Storage
+	if cfg.Quota.Enabled || cfg.Lockout.Store == "redis" || cfg.AppCache.Store == "redis" || cfg.Revocation.Denylist == "redis" {
+		redisStorage, err = redis.New(cfg.Redis.Addr, cfg.Redis.Password, cfg.Redis.DB)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	var lockoutStore auth.LockoutStore = storage
+	switch cfg.Lockout.Store {
+	case "postgres":
+	case "redis":
+		lockoutStore = redisStorage
+	default:
+		panic("unknown lockout store: " + cfg.Lockout.Store)
+	}
+
+	var denylist auth.TokenDenylist
+	switch cfg.Revocation.Denylist {
+	case "postgres":
+	case "redis":
+		denylist = redisStorage
+	default:
+		panic("unknown token denylist: " + cfg.Revocation.Denylist)
+	}
 
-	grpcApp := grpcapp.New(log, authService, grpcPort)
+	var appProvider auth.AppProvider = storage
+	switch cfg.AppCache.Store {
+	case "":
+	case "memory":
+		appProvider = appcache.New(log, storage, cfg.AppCache.TTL)
+	case "redis":
+		appProvider = appcache.NewRedis(log, storage, redisStorage, cfg.AppCache.TTL)
+	default:
+		panic("unknown app cache store: " + cfg.AppCache.Store)
+	}
+
+	// Отзывы токенов расходятся между регионами через брокер событий
+	regions := auth.RegionPolicy{Region: cfg.Region, Allowed: cfg.AllowedRegions}
+	var revocations events.Revocations
+	if cfg.Region != "" && natsConn != nil {
+		revocations = events.NewRevocations(natsConn, cfg.Region)
+		regions.Replicator = revocations
+	}
+
+	authService := auth.New(log, auth.Deps{
+		UserSaver:       storage,
+		UserProvider:    storage,
+		AppProvider:     appProvider,
+		RoleManager:     storage,
+		JTIStore:        storage,
+		OTPStore:        storage,
+		TokenStore:      storage,
+		RefreshStore:    storage,
+		RevocationStore: storage,
+		MFAStore:        storage,
+		PasskeyStore:    storage,
+		OAuthStore:      storage,
+		APIKeyStore:     storage,
+		SessionStore:    storage,
+		LoginHistory:    storage,
+		Outbox:          storage,
+		AuditLog:        storage,
+		LockoutStore:    lockoutStore,
+		GroupStore:      storage,
+		OrgStore:        storage,
+		WebhookStore:    storage,
+		Transactor:      storage,
+		Denylist:        denylist,
+		SMS:             smsSender,
+		Mailer:          mailer,
+		Enricher:        o.enricher,
+		Social:          socialProviders,
+		Directories:     directories,
+	}, auth.Options{
+		TokenTTL:        cfg.TokenTTL,
+		RoleTTL:         cfg.RoleTokenTTL,
+		RefreshTTL:      cfg.RefreshTokenTTL,
+		ServiceTokenTTL: cfg.ServiceTokenTTL,
+		TokenLeeway:     cfg.TokenLeeway,
+		AppSecretGrace:  cfg.AppSecretGrace,
+		Lockout:         auth.LockoutPolicy{Threshold: cfg.Lockout.Threshold, Duration: cfg.Lockout.Duration},
+		Captcha:         auth.CaptchaPolicy{Verifier: captchaVerifier, LoginAfter: cfg.Captcha.LoginAfter},
+		Passwords:       passwords,
+		Breaches:        breaches,
+		EmailDomains:    cfg.EmailDomains,
+		Regions:         regions,
+		SigningKeys:     signingKeys,
+		MFABox:          mfaBox,
+		MFAIssuer:       cfg.MFA.Issuer,
+		WebAuthn:        webauthn.Config{RPID: cfg.WebAuthn.RPID, Origins: cfg.WebAuthn.Origins},
+		MagicLinkURL:    cfg.MagicLinkURL,
+		OIDCIssuer:      issuer,
+		Config:          cfg.Effective(),
+	})
+
+	if err := authService.SetRegistrationMode(cfg.Registration.Mode); err != nil {
+		panic(err)
+	}
+
+	if regions.Replicator != nil {
+		apply := revocations.Handler(func(ctx context.Context, revocation models.Revocation) error {
+			if err := authService.ApplyRevocation(ctx, revocation); err != nil {
+				log.Error("failed to apply revocation of another region", slog.String("region", revocation.Region), sl.Err(err))
+
+				return err
+			}
+
+			return nil
+		})
+
+		// Каждый регион читает отзывы своим durable консьюмером
+		if err := natsConn.Consume(context.Background(), events.RevocationEvent, "sso-revocations-"+cfg.Region, apply); err != nil {
+			panic(err)
+		}
+	}
+
+	var extra []grpc.UnaryServerInterceptor
+
+	// Пока база недоступна, вызовы отклоняются до аутентификации, которая тоже ходит в базу
+	if storageBreaker != nil {
+		extra = append(extra, interceptors.BreakerUnaryInterceptor(storageBreaker))
+	}
+
+	// Лимиты по IP идут первыми, в том числе против перебора API ключей
+	// Форма входа OAuth делит с gRPC лимитер и лимит Login, иначе перебор паролей шёл бы через неё
+	// HTTP API делит их так же, по именам методов сервиса
+	limiter := ratelimit.New()
+	var (
+		defaultLimit ratelimit.Limit
+		loginLimit   ratelimit.Limit
+		perMethod    = map[string]ratelimit.Limit{}
+	)
+	if cfg.RateLimit.Enabled {
+		for method, r := range cfg.RateLimit.Methods {
+			perMethod[method] = ratelimit.Limit(r)
+		}
+
+		defaultLimit = ratelimit.Limit(cfg.RateLimit.Default)
+		loginLimit = defaultLimit
+		if r, ok := perMethod["Login"]; ok {
+			loginLimit = r
+		}
+
+		extra = append(extra, interceptors.RateLimitUnaryInterceptor(
+			log, limiter, defaultLimit, perMethod,
+		))
+	}
+
+	// Ключ, сертификат и токен проверяются до квот, чтобы квоты учитывали приложение вызывающего
+	extra = append(extra,
+		interceptors.ClientCertUnaryInterceptor(log, authService),
+		interceptors.APIKeyUnaryInterceptor(log, authService),
+		interceptors.BearerUnaryInterceptor(log, authService, interceptors.AnonymousMethods, cfg.TokenLeeway),
+		interceptors.AdminUnaryInterceptor(log, authService, interceptors.AdminMethods),
+	)
+
+	if cfg.FaultInjection.Enabled {
+		fi := cfg.FaultInjection
+
+		extra = append(extra, interceptors.FaultUnaryInterceptor(log, interceptors.FaultConfig{
+			Latency:             fi.Latency,
+			LatencyPercent:      fi.LatencyPercent,
+			StorageErrorPercent: fi.StorageErrorPercent,
+			SigningErrorPercent: fi.SigningErrorPercent,
+			AllowHeader:         fi.AllowHeader,
+		}))
+	}
+
+	if cfg.Quota.Enabled {
+		perApp := make(map[int]interceptors.Quota, len(cfg.Quota.Apps))
+		for appID, q := range cfg.Quota.Apps {
+			perApp[appID] = interceptors.Quota(q)
+		}
+
+		extra = append(extra, interceptors.QuotaUnaryInterceptor(
+			log, redisStorage, interceptors.Quota(cfg.Quota.Default), interceptors.Quota(cfg.Quota.Anonymous), perApp,
+		))
+	}
+
+	// После квот, чтобы повторы с ключом тоже учитывались
+	if cfg.Idempotency.Enabled {
+		extra = append(extra, interceptors.IdempotencyUnaryInterceptor(
+			log, storage, interceptors.IdempotentMethods, cfg.Idempotency.TTL,
+		))
+	}
+
+	deps := map[string]health.Pinger{cfg.Storage: storage}
+	if redisStorage != nil {
+		deps["redis"] = redisStorage
+	}
+
+	if cfg.GRPC.LogPayloads {
+		if cfg.Env == "prod" {
+			panic("payload logging must not be enabled in prod")
+		}
+
+		log.Warn("payload logging is enabled")
+	}
+
+	var tlsConfig *tls.Config
+	if cfg.GRPC.TLS.CertFile != "" {
+		if cfg.HTTP.Gateway || cfg.GRPC.Web {
+			panic("grpc.tls can't be used with http.gateway or grpc.web")
+		}
+
+		t := cfg.GRPC.TLS
+
+		tlsConfig, err = mtls.ServerConfig(t.CertFile, t.KeyFile, t.ClientCAFile, t.ClientAuth)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	proxies, err := clientinfo.ParseProxies(cfg.TrustedProxies)
+	if err != nil {
+		panic(err)
+	}
+
+	g := cfg.GRPC
+
+	grpcApp := grpcapp.New(log, authService, deps, g.LogPayloads, tlsConfig, proxies, grpcapp.ServerOptions{
+		KeepaliveTime:                g.KeepaliveTime,
+		KeepaliveTimeout:             g.KeepaliveTimeout,
+		KeepaliveMinTime:             g.KeepaliveMinTime,
+		KeepalivePermitWithoutStream: g.KeepalivePermitWithoutStream,
+		MaxConnectionIdle:            g.MaxConnectionIdle,
+		MaxConnectionAge:             g.MaxConnectionAge,
+		MaxConnectionAgeGrace:        g.MaxConnectionAgeGrace,
+		MaxConcurrentStreams:         g.MaxConcurrentStreams,
+		MaxRecvMsgSize:               g.MaxRecvMsgSize,
+		MaxSendMsgSize:               g.MaxSendMsgSize,
+	}, g.Port, extra...)
+
+	// loopback calls the gRPC server of this instance on behalf of HTTP clients.
+	var loopback *grpc.ClientConn
+	if cfg.HTTP.Gateway || cfg.GRPC.Web {
+		loopback, err = grpc.NewClient(fmt.Sprintf("localhost:%d", cfg.GRPC.Port),
+			grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	if cfg.GRPC.Web {
+		grpcApp.ServeWeb(middleware.Chain(grpcweb.New(loopback),
+			middleware.CORS(cfg.HTTP.CORS),
+			middleware.ClientInfo(proxies),
+		))
+	}
+
+	ready, err := newReadiness(log, grpcApp.Health, storage, migrationsFS)
+	if err != nil {
+		panic(err)
+	}
+	ready.start()
+
+	relay := outbox.NewRelay(log, storage, publisher, cfg.Events.PollInterval)
+	relay.Start()
+
+	webhooks := webhook.NewDispatcher(log, storage, &http.Client{Timeout: cfg.Webhooks.Timeout}, cfg.Webhooks.PollInterval)
+	webhooks.Start()
+
+	var httpApp *httpapp.App
+	if cfg.HTTP.Port != 0 {
+		mux := http.NewServeMux()
+		oauth.New(log, authService, issuer, signingKeys, limiter, loginLimit).Register(mux)
+
+		if cfg.HTTP.Gateway {
+			gateway.New(log, loopback).Register(mux)
+		}
+
+		if cfg.HTTP.SCIM {
+			scim.New(log, authService).Register(mux)
+		}
+
+		if cfg.HTTP.API {
+			api.New(log, authService, limiter, defaultLimit, perMethod).Register(mux)
+		}
+
+		httpApp = httpapp.New(log, middleware.Chain(mux,
+			middleware.RequestID,
+			middleware.SecurityHeaders(cfg.HTTP.HSTS),
+			middleware.CORS(cfg.HTTP.CORS),
+			middleware.ClientInfo(proxies),
+		), net.JoinHostPort("", strconv.Itoa(cfg.HTTP.Port)))
+	}
+
+	var debugApp *httpapp.App
+	if cfg.Debug.Port != 0 {
+		addr := net.JoinHostPort(cfg.Debug.Address, strconv.Itoa(cfg.Debug.Port))
+
+		log.Warn("debug server is enabled", slog.String("addr", addr))
+
+		debugApp = httpapp.New(log, debug.Handler(), addr)
+	}
 
 	return &App{
-		GRPCServer: grpcApp,
+		GRPCServer:      grpcApp,
+		HTTPServer:      httpApp,
+		DebugServer:     debugApp,
+		Storage:         storage,
+		Redis:           redisStorage,
+		log:             log,
+		readiness:       ready,
+		relay:           relay,
+		webhooks:        webhooks,
+		nats:            natsConn,
+		drainDelay:      cfg.GRPC.DrainDelay,
+		shutdownTimeout: cfg.GRPC.ShutdownTimeout,
 	}
 }
@@ -2,26 +2,47 @@ package grpcapp
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net"
+	"net/http"
+	"time"
 
 	authgrpc "sso/internal/grpc/auth"
+	"sso/internal/grpc/health"
+	"sso/internal/grpc/interceptors"
+	"sso/internal/lib/clientinfo"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/requestid"
 
 	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/logging"
 	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/recovery"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/status"
 )
 
 type App struct {
 	log        *slog.Logger
 	gRPCServer *grpc.Server
-	port       int
+	// Health is the grpc.health.v1 service checking deps on Check.
+	Health *health.Server
+	port   int
+	// web serves gRPC-Web on the same port if set by ServeWeb.
+	web *http.Server
 }
 
-func New(log *slog.Logger, authService authgrpc.Auth, port int) *App {
+// New creates gRPC server with request id, access log and recovery interceptors
+// followed by the given ones. Requests and responses are logged too if
+// logPayloads is set, which must only be done locally: they carry passwords
+// and tokens. The server uses TLS if tlsConfig is not nil. Besides the auth
+// service it serves health checks, failing while any of deps doesn't respond.
+// Client addresses forwarded by proxies are believed for proxies only.
+func New(log *slog.Logger, authService authgrpc.Auth, deps map[string]health.Pinger, logPayloads bool, tlsConfig *tls.Config, proxies clientinfo.Proxies, serverOpts ServerOptions, port int, extra ...grpc.UnaryServerInterceptor) *App {
 	recoveryOpts := []recovery.Option{
 		recovery.WithRecoveryHandler(func(p interface{}) (err error) {
 
@@ -37,16 +58,32 @@ func New(log *slog.Logger, authService authgrpc.Auth, port int) *App {
 		),
 	}
 
-	gRPCServer := grpc.NewServer(grpc.ChainUnaryInterceptor(
+	chain := []grpc.UnaryServerInterceptor{
+		interceptors.RequestIDUnaryInterceptor(),
+		interceptors.ClientInfoUnaryInterceptor(proxies),
+		interceptors.AccessLogUnaryInterceptor(log),
 		recovery.UnaryServerInterceptor(recoveryOpts...),
-		logging.UnaryServerInterceptor(InterceptorLogger(log), loggingOpts...),
-	))
+	}
+	if logPayloads {
+		chain = append(chain, logging.UnaryServerInterceptor(InterceptorLogger(log), loggingOpts...))
+	}
+
+	opts := append(serverOpts.grpcOptions(), grpc.ChainUnaryInterceptor(append(chain, extra...)...))
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+
+	gRPCServer := grpc.NewServer(opts...)
 
 	authgrpc.Register(gRPCServer, authService)
 
+	healthServer := health.New(log, deps)
+	healthpb.RegisterHealthServer(gRPCServer, healthServer)
+
 	return &App{
 		log:        log,
 		gRPCServer: gRPCServer,
+		Health:     healthServer,
 		port:       port,
 	}
 }
@@ -55,10 +92,19 @@ func New(log *slog.Logger, authService authgrpc.Auth, port int) *App {
 // This code is simple enough to be copied and not imported.
 func InterceptorLogger(l *slog.Logger) logging.Logger {
 	return logging.LoggerFunc(func(ctx context.Context, lvl logging.Level, msg string, fields ...any) {
-		l.Log(ctx, slog.Level(lvl), msg, fields...)
+		requestid.Logger(ctx, l).Log(ctx, slog.Level(lvl), msg, fields...)
 	})
 }
 
+// ServeWeb serves HTTP/1.1 requests to the gRPC port, i.e. gRPC-Web calls of
+// browsers, with handler. Must be called before MustRun.
+func (a *App) ServeWeb(handler http.Handler) {
+	a.web = &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+}
+
 func (a *App) MustRun() error {
 	const op = "grpcapp.MustRun"
 
@@ -67,7 +113,20 @@ func (a *App) MustRun() error {
 		return fmt.Errorf("%s: %w", op, err)
 	}
 
-	a.log.Info("starting grpc server", slog.String("addr", l.Addr().String()))
+	a.log.Info("starting grpc server", slog.String("addr", l.Addr().String()), slog.Bool("grpc_web", a.web != nil))
+
+	if a.web != nil {
+		split := newSplitListener(l)
+		defer split.Close()
+
+		l = split.grpc
+
+		go func() {
+			if err := a.web.Serve(split.web); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				a.log.Error("grpc-web server failed", sl.Err(err))
+			}
+		}()
+	}
 
 	if err := a.gRPCServer.Serve(l); err != nil {
 		return fmt.Errorf("%s: %w", op, err)
@@ -77,10 +136,33 @@ func (a *App) MustRun() error {
 
 }
 
-func (a *App) Stop() {
+// Stop stops accepting calls and waits for the running ones until ctx is
+// done, then closes the remaining connections.
+func (a *App) Stop(ctx context.Context) {
 	const op = "grpcapp.Stop"
 
-	a.log.With("op", op).Info("stopping grpc server", slog.Int("port", a.port))
+	log := a.log.With("op", op)
+	log.Info("stopping grpc server", slog.Int("port", a.port))
 
-	a.gRPCServer.GracefulStop()
+	// gRPC-Web calls are proxied to the gRPC server, so they finish first.
+	if a.web != nil {
+		if err := a.web.Shutdown(ctx); err != nil {
+			log.Error("failed to stop grpc-web server", sl.Err(err))
+		}
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		a.gRPCServer.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		log.Warn("calls didn't finish in time, closing connections")
+
+		a.gRPCServer.Stop()
+		<-stopped
+	}
 }
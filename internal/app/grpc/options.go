@@ -0,0 +1,50 @@
+package grpcapp
+
+import (
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+)
+
+// ServerOptions tune connections of the server; zero values keep gRPC defaults.
+type ServerOptions struct {
+	KeepaliveTime                time.Duration
+	KeepaliveTimeout             time.Duration
+	KeepaliveMinTime             time.Duration
+	KeepalivePermitWithoutStream bool
+	MaxConnectionIdle            time.Duration
+	MaxConnectionAge             time.Duration
+	MaxConnectionAgeGrace        time.Duration
+	MaxConcurrentStreams         uint32
+	MaxRecvMsgSize               int
+	MaxSendMsgSize               int
+}
+
+func (o ServerOptions) grpcOptions() []grpc.ServerOption {
+	opts := []grpc.ServerOption{
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			MaxConnectionIdle:     o.MaxConnectionIdle,
+			MaxConnectionAge:      o.MaxConnectionAge,
+			MaxConnectionAgeGrace: o.MaxConnectionAgeGrace,
+			Time:                  o.KeepaliveTime,
+			Timeout:               o.KeepaliveTimeout,
+		}),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             o.KeepaliveMinTime,
+			PermitWithoutStream: o.KeepalivePermitWithoutStream,
+		}),
+	}
+
+	if o.MaxConcurrentStreams > 0 {
+		opts = append(opts, grpc.MaxConcurrentStreams(o.MaxConcurrentStreams))
+	}
+	if o.MaxRecvMsgSize > 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(o.MaxRecvMsgSize))
+	}
+	if o.MaxSendMsgSize > 0 {
+		opts = append(opts, grpc.MaxSendMsgSize(o.MaxSendMsgSize))
+	}
+
+	return opts
+}
@@ -0,0 +1,125 @@
+package grpcapp
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"sync"
+	"time"
+)
+
+// http2Preface starts every HTTP/2 connection of gRPC clients.
+var http2Preface = []byte("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n")
+
+const sniffTimeout = 10 * time.Second
+
+// splitListener routes accepted connections starting with the HTTP/2 preface
+// to grpc and the rest, HTTP/1.1 requests of browsers, to web.
+type splitListener struct {
+	root net.Listener
+	grpc *connListener
+	web  *connListener
+}
+
+func newSplitListener(root net.Listener) *splitListener {
+	s := &splitListener{
+		root: root,
+		grpc: newConnListener(root.Addr()),
+		web:  newConnListener(root.Addr()),
+	}
+
+	go s.serve()
+
+	return s
+}
+
+func (s *splitListener) serve() {
+	defer s.grpc.Close()
+	defer s.web.Close()
+
+	for {
+		conn, err := s.root.Accept()
+		if err != nil {
+			return
+		}
+
+		go s.route(conn)
+	}
+}
+
+func (s *splitListener) route(conn net.Conn) {
+	_ = conn.SetReadDeadline(time.Now().Add(sniffTimeout))
+
+	r := bufio.NewReader(conn)
+	prefix, err := r.Peek(len(http2Preface))
+
+	_ = conn.SetReadDeadline(time.Time{})
+
+	// Short HTTP/1.1 requests may end before the preface length, so only
+	// a failure to read anything drops the connection.
+	if err != nil && len(prefix) == 0 {
+		_ = conn.Close()
+
+		return
+	}
+
+	peeked := &peekedConn{Conn: conn, r: r}
+	if bytes.Equal(prefix, http2Preface) {
+		s.grpc.push(peeked)
+	} else {
+		s.web.push(peeked)
+	}
+}
+
+func (s *splitListener) Close() error {
+	return s.root.Close()
+}
+
+// connListener hands out connections pushed to it.
+type connListener struct {
+	addr      net.Addr
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newConnListener(addr net.Addr) *connListener {
+	return &connListener{addr: addr, conns: make(chan net.Conn), done: make(chan struct{})}
+}
+
+func (l *connListener) push(conn net.Conn) {
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		_ = conn.Close()
+	}
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *connListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.addr
+}
+
+// peekedConn reads the bytes peeked while routing before the rest of the connection.
+type peekedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *peekedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
@@ -0,0 +1,59 @@
+package httpapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sso/internal/lib/logger/sl"
+	"time"
+)
+
+type App struct {
+	log    *slog.Logger
+	server *http.Server
+	addr   string
+}
+
+// New creates HTTP server serving handler on addr, host:port; an empty
+// host listens on all interfaces.
+func New(log *slog.Logger, handler http.Handler, addr string) *App {
+	return &App{
+		log: log,
+		server: &http.Server{
+			Handler:           handler,
+			ReadHeaderTimeout: 10 * time.Second,
+		},
+		addr: addr,
+	}
+}
+
+func (a *App) MustRun() error {
+	const op = "httpapp.MustRun"
+
+	l, err := net.Listen("tcp", a.addr)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	a.log.Info("starting http server", slog.String("addr", l.Addr().String()))
+
+	if err := a.server.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// Stop stops accepting requests and waits for the running ones until ctx is done.
+func (a *App) Stop(ctx context.Context) {
+	const op = "httpapp.Stop"
+
+	a.log.With("op", op).Info("stopping http server", slog.String("addr", a.addr))
+
+	if err := a.server.Shutdown(ctx); err != nil {
+		a.log.Error("failed to stop http server", sl.Err(err))
+	}
+}
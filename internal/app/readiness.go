@@ -0,0 +1,186 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"sso/internal/grpc/health"
+	"sso/internal/lib/logger/sl"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	ssov1 "github.com/wadt3rr/city-events-auth-protos/gen/go/sso"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// State is the readiness of the instance to serve requests.
+type State int32
+
+const (
+	// Starting until migrations are applied and the database is reachable.
+	Starting State = iota
+	// Ready serves requests.
+	Ready
+	// Draining is shutting down and must get no new requests.
+	Draining
+)
+
+func (s State) String() string {
+	switch s {
+	case Starting:
+		return "starting"
+	case Ready:
+		return "ready"
+	case Draining:
+		return "draining"
+	default:
+		return "unknown"
+	}
+}
+
+const readinessInterval = time.Second
+
+type schemaChecker interface {
+	health.Pinger
+	SchemaVersion(ctx context.Context) (version uint, dirty bool, err error)
+}
+
+// readiness reports NOT_SERVING for the overall service and auth.Auth while
+// the instance is starting or draining; health.Liveness stays SERVING.
+type readiness struct {
+	log    *slog.Logger
+	health *health.Server
+	db     schemaChecker
+	// schema is the version the database must have, 0 to skip the check.
+	schema uint
+	state  atomic.Int32
+	cancel context.CancelFunc
+}
+
+func newReadiness(log *slog.Logger, h *health.Server, db schemaChecker, migrations fs.FS) (*readiness, error) {
+	schema, err := latestMigration(migrations)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &readiness{log: log, health: h, db: db, schema: schema}
+	r.setStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+
+	return r, nil
+}
+
+// start polls the dependencies in the background until they are ready.
+func (r *readiness) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+
+	go func() {
+		ticker := time.NewTicker(readinessInterval)
+		defer ticker.Stop()
+
+		for {
+			err := r.check(ctx)
+			if err == nil {
+				if r.state.CompareAndSwap(int32(Starting), int32(Ready)) {
+					r.setStatus(healthpb.HealthCheckResponse_SERVING)
+					r.log.Info("instance is ready")
+				}
+
+				return
+			}
+
+			r.log.Debug("instance is not ready yet", sl.Err(err))
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (r *readiness) check(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, readinessInterval)
+	defer cancel()
+
+	if err := r.db.Ping(ctx); err != nil {
+		return err
+	}
+
+	if r.schema == 0 {
+		return nil
+	}
+
+	version, dirty, err := r.db.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	if dirty {
+		return fmt.Errorf("migration %d failed halfway", version)
+	}
+
+	if version < r.schema {
+		return fmt.Errorf("schema version %d, want %d", version, r.schema)
+	}
+
+	return nil
+}
+
+// drain reports NOT_SERVING and waits for delay so that load balancers stop
+// sending requests before the servers stop.
+func (r *readiness) drain(delay time.Duration) {
+	if State(r.state.Swap(int32(Draining))) == Draining {
+		return
+	}
+
+	if r.cancel != nil {
+		r.cancel()
+	}
+
+	r.setStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+	r.log.Info("draining", slog.Duration("delay", delay))
+
+	time.Sleep(delay)
+}
+
+func (r *readiness) setStatus(status healthpb.HealthCheckResponse_ServingStatus) {
+	r.health.SetServingStatus("", status)
+	r.health.SetServingStatus(ssov1.Auth_ServiceDesc.ServiceName, status)
+}
+
+// latestMigration returns the highest version among NNN_name.up.sql files.
+func latestMigration(migrations fs.FS) (uint, error) {
+	const op = "app.latestMigration"
+
+	entries, err := fs.ReadDir(migrations, ".")
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var latest uint
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".up.sql") {
+			continue
+		}
+
+		prefix, _, ok := strings.Cut(name, "_")
+		if !ok {
+			continue
+		}
+
+		version, err := strconv.ParseUint(prefix, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		latest = max(latest, uint(version))
+	}
+
+	return latest, nil
+}
@@ -0,0 +1,149 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"sso/internal/config"
+	"sso/internal/grpc/interceptors"
+	"sso/internal/lib/breaker"
+	"sso/internal/lib/outbox"
+	"sso/internal/lib/webhook"
+	"sso/internal/services/auth"
+	"sso/internal/storage/memory"
+	"sso/internal/storage/mysql"
+	"sso/internal/storage/postgres"
+)
+
+// Storage is the database backend chosen by config.Config.Storage.
+type Storage interface {
+	auth.UserSaver
+	auth.UserProvider
+	auth.AppProvider
+	auth.RoleManager
+	auth.JTIStore
+	auth.OTPStore
+	auth.TokenStore
+	auth.RefreshTokenStore
+	auth.RevocationStore
+	auth.MFAStore
+	auth.PasskeyStore
+	auth.OAuthStore
+	auth.APIKeyStore
+	auth.SessionStore
+	auth.LoginHistory
+	auth.EventOutbox
+	auth.AuditLog
+	auth.LockoutStore
+	auth.GroupStore
+	auth.OrgStore
+	auth.WebhookStore
+	auth.Transactor
+	outbox.Store
+	webhook.Store
+	interceptors.IdempotencyStore
+	schemaChecker
+	Migrate(source fs.FS) (uint, error)
+	Close()
+}
+
+// storages open the backends by the name in config.Config.Storage.
+var storages = map[string]func(log *slog.Logger, cfg *config.Config, b *breaker.Breaker) (Storage, error){
+	"postgres": openPostgres,
+	"mysql":    openMySQL,
+	"memory":   openMemory,
+}
+
+// newStorage opens the backend of cfg.Storage. Calls to it fail fast while b
+// is open, if the backend supports it.
+func newStorage(log *slog.Logger, cfg *config.Config, b *breaker.Breaker) (Storage, error) {
+	const op = "app.newStorage"
+
+	open, ok := storages[cfg.Storage]
+	if !ok {
+		return nil, fmt.Errorf("%s: unknown storage %q", op, cfg.Storage)
+	}
+
+	if cfg.FaultInjection.Enabled && cfg.Storage != "postgres" {
+		return nil, fmt.Errorf("%s: fault injection is only supported with postgres storage", op)
+	}
+
+	storage, err := open(log, cfg, b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return storage, nil
+}
+
+func openPostgres(log *slog.Logger, cfg *config.Config, b *breaker.Breaker) (Storage, error) {
+	pool := cfg.Database.Pool
+	opts := []postgres.Option{
+		postgres.WithPoolSize(pool.MinConns, pool.MaxConns),
+		postgres.WithMaxConnLifetime(pool.MaxConnLifetime),
+		postgres.WithHealthCheckPeriod(pool.HealthCheckPeriod),
+		postgres.WithAcquireTimeout(pool.AcquireTimeout),
+		postgres.WithRetry(retryPolicy(cfg.Database.Retry.Read), retryPolicy(cfg.Database.Retry.Write)),
+		postgres.WithBreaker(b),
+	}
+
+	if cfg.Database.ReadURL != "" {
+		var replicaBreaker *breaker.Breaker
+		if b != nil {
+			replicaBreaker = breaker.New(log.With(slog.String("db", "replica")), cfg.Database.Breaker.Failures, cfg.Database.Breaker.OpenFor)
+		}
+
+		opts = append(opts, postgres.WithReplica(cfg.Database.ReadURL, replicaBreaker))
+	}
+
+	if cfg.Database.SlowQueryThreshold > 0 {
+		opts = append(opts, postgres.WithSlowQueryLog(log, cfg.Database.SlowQueryThreshold))
+	}
+
+	if cfg.FaultInjection.Enabled {
+		if cfg.Env == "prod" {
+			return nil, errors.New("fault injection must not be enabled in prod")
+		}
+
+		log.Warn("fault injection is enabled")
+
+		opts = append(opts, postgres.WithFaultInjection())
+	}
+
+	dsn, err := cfg.Database.DSN(cfg.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	storage, err := postgres.New(dsn, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return storage, nil
+}
+
+func retryPolicy(c config.RetryPolicyConfig) postgres.RetryPolicy {
+	return postgres.RetryPolicy{Attempts: c.Attempts, BaseDelay: c.BaseDelay, MaxDelay: c.MaxDelay}
+}
+
+func openMySQL(_ *slog.Logger, cfg *config.Config, _ *breaker.Breaker) (Storage, error) {
+	dsn, err := cfg.Database.DSN(cfg.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	storage, err := mysql.New(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	return storage, nil
+}
+
+func openMemory(log *slog.Logger, _ *config.Config, _ *breaker.Breaker) (Storage, error) {
+	log.Warn("storage is in memory, data is lost on restart")
+
+	return memory.New(), nil
+}
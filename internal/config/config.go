@@ -1,29 +1,543 @@
 package config
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"net/url"
 	"os"
+	"regexp"
+	"slices"
+	"sso/internal/http/middleware"
+	"sso/internal/lib/emaildomain"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/go-sql-driver/mysql"
 	"github.com/ilyakaznacheev/cleanenv"
 	"github.com/joho/godotenv"
 )
 
 type Config struct {
-	Env            string     `yaml:"env" env-default:"local"`
-	GRPC           GRPCConfig `yaml:"grpc"`
-	MigrationsPath string
+	// Path of the loaded config file.
+	Path string `yaml:"-"`
+	Env  string `yaml:"env" env-default:"local"`
+	// Region identifies the data center of this instance when running in several.
+	// AllowedRegions are regions whose tokens are accepted; empty accepts all.
+	Region         string        `yaml:"region" env:"SSO_REGION"`
+	AllowedRegions []string      `yaml:"allowed_regions" env:"SSO_ALLOWED_REGIONS"`
+	GRPC           GRPCConfig    `yaml:"grpc"`
+	Storage        string        `yaml:"storage" env:"STORAGE" env-default:"postgres"`
+	MigrationsPath string        `yaml:"migrations_path" env:"MIGRATIONS_PATH"`
+	Migrate        bool          `yaml:"migrate" env:"MIGRATE"`
 	TokenTTL       time.Duration `yaml:"token_ttl" env-default:"1h"`
+	// RoleTokenTTL overrides TokenTTL for the given roles, e.g. shorter-lived admin tokens.
+	RoleTokenTTL map[string]time.Duration `yaml:"role_token_ttl"`
+	// RefreshTokenTTL is lifetime of refresh tokens issued on login; zero disables them.
+	RefreshTokenTTL time.Duration `yaml:"refresh_token_ttl" env-default:"720h"`
+	// ServiceTokenTTL is lifetime of tokens issued to apps by the client credentials grant.
+	ServiceTokenTTL time.Duration `yaml:"service_token_ttl" env-default:"15m"`
+	// SigningKeys maps app id to PEM file with RSA or ECDSA P-256 private key; tokens of
+	// these apps are signed with RS256/ES256 instead of the app secret.
+	SigningKeys map[int]string `yaml:"signing_keys"`
+	// TokenLeeway is the clock skew tolerated when verifying exp, nbf and iat.
+	TokenLeeway time.Duration `yaml:"token_leeway" env-default:"30s"`
+	// AppSecretGrace is how long the previous app secret still verifies tokens after rotation.
+	AppSecretGrace time.Duration `yaml:"app_secret_grace" env-default:"24h"`
+	Lockout        LockoutConfig `yaml:"lockout"`
+	Captcha        CaptchaConfig `yaml:"captcha"`
+	// PasswordHash is the algorithm of new password hashes. Existing hashes
+	// of another algorithm keep working and are replaced on login.
+	PasswordHash PasswordHashConfig `yaml:"password_hash"`
+	SMS          SMSConfig          `yaml:"sms"`
+	Email        EmailConfig        `yaml:"email"`
+	// MagicLinkURL is the frontend page logging in with ?token=...; empty disables magic links.
+	MagicLinkURL string             `yaml:"magic_link_url"`
+	Registration RegistrationConfig `yaml:"registration"`
+	// EmailDomains restricts email domains allowed on registration.
+	EmailDomains emaildomain.Rules `yaml:"email_domains"`
+	Database     DatabaseConfig    `yaml:"database"`
+	Redis        RedisConfig       `yaml:"redis"`
+	AppCache     AppCacheConfig    `yaml:"app_cache"`
+	Revocation   RevocationConfig  `yaml:"revocation"`
+	Quota        QuotaConfig       `yaml:"quota"`
+	RateLimit    RateLimitConfig   `yaml:"rate_limit"`
+	HTTP         HTTPConfig        `yaml:"http"`
+	MFA          MFAConfig         `yaml:"mfa"`
+	WebAuthn     WebAuthnConfig    `yaml:"webauthn"`
+	Social       SocialConfig      `yaml:"social"`
+	LDAP         []LDAPConfig      `yaml:"ldap"`
+	Webhooks     WebhookConfig     `yaml:"webhooks"`
+	Events       EventsConfig      `yaml:"events"`
+	Idempotency  IdempotencyConfig `yaml:"idempotency"`
+	// FaultInjection is for dev and staging only; the service refuses to start with it in prod.
+	FaultInjection FaultInjectionConfig `yaml:"fault_injection"`
+	// Debug serves pprof and runtime metrics on a separate port.
+	Debug DebugConfig `yaml:"debug"`
+	// TrustedProxies are addresses or CIDRs of reverse proxies in front of the
+	// service. The client address in X-Forwarded-For is believed from them and
+	// the loopback only; for other peers it's their own address.
+	TrustedProxies []string `yaml:"trusted_proxies" env:"TRUSTED_PROXIES"`
 }
 
 type GRPCConfig struct {
 	Port    int           `yaml:"port"`
 	Timeout time.Duration `yaml:"timeout"`
+	// DrainDelay is how long the instance reports not ready before it stops
+	// serving on shutdown, so that load balancers take it out of rotation first.
+	DrainDelay time.Duration `yaml:"drain_delay"`
+	// ShutdownTimeout bounds the wait for calls in progress on shutdown,
+	// after which connections are closed.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env-default:"30s"`
+	// LogPayloads logs requests and responses of every call. They carry
+	// passwords and tokens, so the service refuses to start with it in prod.
+	LogPayloads bool `yaml:"log_payloads"`
+	// Web serves gRPC-Web to browsers on the same port, with CORS of http.cors.
+	Web bool      `yaml:"web"`
+	TLS TLSConfig `yaml:"tls"`
+	// KeepaliveTime is how long a connection may be quiet before the server
+	// pings the client, and KeepaliveTimeout how long it waits for the ack.
+	KeepaliveTime    time.Duration `yaml:"keepalive_time" env-default:"1m"`
+	KeepaliveTimeout time.Duration `yaml:"keepalive_timeout" env-default:"20s"`
+	// KeepaliveMinTime is the shortest interval of client pings; clients
+	// pinging more often are disconnected.
+	KeepaliveMinTime             time.Duration `yaml:"keepalive_min_time" env-default:"30s"`
+	KeepalivePermitWithoutStream bool          `yaml:"keepalive_permit_without_stream"`
+	// MaxConnectionAge closes connections after a while, with MaxConnectionAgeGrace
+	// for calls in progress, so that clients rebalance over new instances.
+	MaxConnectionIdle     time.Duration `yaml:"max_connection_idle" env-default:"15m"`
+	MaxConnectionAge      time.Duration `yaml:"max_connection_age" env-default:"30m"`
+	MaxConnectionAgeGrace time.Duration `yaml:"max_connection_age_grace" env-default:"10s"`
+	MaxConcurrentStreams  uint32        `yaml:"max_concurrent_streams" env-default:"1000"`
+	// MaxRecvMsgSize and MaxSendMsgSize are in bytes.
+	MaxRecvMsgSize int `yaml:"max_recv_msg_size" env-default:"4194304"`
+	MaxSendMsgSize int `yaml:"max_send_msg_size" env-default:"4194304"`
+}
+
+// TLSConfig enables TLS on the gRPC port if CertFile is set. With ClientCAFile,
+// services authenticate with client certificates registered for their apps.
+// The gateway and gRPC-Web call the gRPC port in plaintext and can't be used with TLS.
+type TLSConfig struct {
+	CertFile     string `yaml:"cert_file" env:"GRPC_TLS_CERT_FILE"`
+	KeyFile      string `yaml:"key_file" env:"GRPC_TLS_KEY_FILE"`
+	ClientCAFile string `yaml:"client_ca_file" env:"GRPC_TLS_CLIENT_CA_FILE"`
+	// ClientAuth is "request" to verify certificates of clients presenting one,
+	// or "require" to reject clients without one.
+	ClientAuth string `yaml:"client_auth" env-default:"request"`
+}
+
+// LockoutConfig locks accounts after consecutive failed logins.
+type LockoutConfig struct {
+	// Threshold of failed logins; zero disables lockout.
+	Threshold int           `yaml:"threshold"`
+	Duration  time.Duration `yaml:"duration" env-default:"15m"`
+	// Store of failed login counters: "postgres" or "redis" to share them
+	// between replicas without a write to Postgres on every failure.
+	Store string `yaml:"store" env-default:"postgres"`
+}
+
+// CaptchaConfig requires a captcha on registration and on login after failed attempts.
+type CaptchaConfig struct {
+	// Provider is "recaptcha", "hcaptcha" or "turnstile"; empty disables captcha.
+	Provider string `yaml:"provider"`
+	Secret   string `yaml:"secret" env:"CAPTCHA_SECRET"`
+	// MinScore rejects reCAPTCHA v3 tokens scored lower.
+	MinScore float64 `yaml:"min_score"`
+	// LoginAfter is the number of consecutive failed logins after which login requires a captcha.
+	LoginAfter int           `yaml:"login_after" env-default:"3"`
+	Timeout    time.Duration `yaml:"timeout" env-default:"5s"`
+}
+
+type PasswordHashConfig struct {
+	// Algorithm is "bcrypt" or "argon2id".
+	Algorithm string `yaml:"algorithm" env-default:"bcrypt"`
+	// Argon2 parameters; zero values select the defaults of passhash.DefaultArgon2.
+	Argon2 Argon2Config `yaml:"argon2"`
+	// Peppers are secrets mixed into passwords, by version, e.g. "1:old,2:new"
+	// in env. The highest version is used for new hashes; an old one can be
+	// removed once users logged in and got rehashed, others will have to reset
+	// their passwords.
+	Peppers map[int]string `yaml:"peppers" env:"PASSWORD_PEPPERS"`
+	Breach  BreachConfig   `yaml:"breach"`
+}
+
+// BreachConfig checks new passwords against Have I Been Pwned.
+type BreachConfig struct {
+	// Mode is "reject" to refuse breached passwords, "warn" to only log them; empty disables the check.
+	Mode string `yaml:"mode"`
+	// URL of the range API, e.g. a self-hosted mirror.
+	URL     string        `yaml:"url" env-default:"https://api.pwnedpasswords.com"`
+	Timeout time.Duration `yaml:"timeout" env-default:"3s"`
+}
+
+type Argon2Config struct {
+	Time uint32 `yaml:"time"`
+	// MemoryKiB is memory used per hash, in KiB.
+	MemoryKiB uint32 `yaml:"memory_kib"`
+	Threads   uint8  `yaml:"threads"`
+}
+
+type SMSConfig struct {
+	// Provider is "log" to write messages to the log (local only),
+	// "http" to post them to URL; empty disables sending SMS.
+	Provider string        `yaml:"provider"`
+	URL      string        `yaml:"url" env:"SMS_URL"`
+	Token    string        `yaml:"token" env:"SMS_TOKEN"`
+	Timeout  time.Duration `yaml:"timeout" env-default:"5s"`
+}
+
+type EmailConfig struct {
+	// Provider is "log" to write emails to the log (local only); empty disables sending emails.
+	Provider string `yaml:"provider"`
+}
+
+type RegistrationConfig struct {
+	// Mode is "open", "invite" (invitation holders only) or "closed" (admin-created accounts only).
+	Mode string `yaml:"mode" env:"REGISTRATION_MODE" env-default:"open"`
+}
+
+// DatabaseConfig locates the database of postgres and mysql storage.
+// URL, e.g. DATABASE_URL of older deployments, is used as is instead of the other fields.
+type DatabaseConfig struct {
+	URL  string `yaml:"url" env:"DATABASE_URL"`
+	Host string `yaml:"host" env:"DATABASE_HOST" env-default:"localhost"`
+	// Port defaults to 5432 for postgres and 3306 for mysql.
+	Port int    `yaml:"port" env:"DATABASE_PORT"`
+	Name string `yaml:"name" env:"DATABASE_NAME"`
+	User string `yaml:"user" env:"DATABASE_USER"`
+	// PasswordFile holds the password, e.g. a mounted secret; empty for no password.
+	PasswordFile string `yaml:"password_file" env:"DATABASE_PASSWORD_FILE"`
+	// SSLMode is disable, prefer, require, verify-ca or verify-full, as in libpq.
+	SSLMode string `yaml:"sslmode" env:"DATABASE_SSLMODE" env-default:"prefer"`
+
+	Pool PoolConfig `yaml:"pool"`
+	// ReadURL is a read replica of postgres storage serving lookups of users and apps.
+	ReadURL string `yaml:"read_url" env:"DATABASE_READ_URL"`
+	// SlowQueryThreshold logs queries of postgres storage taking this long or longer; zero disables it.
+	SlowQueryThreshold time.Duration `yaml:"slow_query_threshold" env:"DATABASE_SLOW_QUERY_THRESHOLD"`
+	Retry              RetryConfig   `yaml:"retry"`
+	Breaker            BreakerConfig `yaml:"breaker"`
+}
+
+// BreakerConfig makes calls to postgres storage fail fast with Unavailable for OpenFor
+// after Failures consecutive failures of the database; zero Failures disables it.
+type BreakerConfig struct {
+	Failures int           `yaml:"failures" env:"DATABASE_BREAKER_FAILURES"`
+	OpenFor  time.Duration `yaml:"open_for" env:"DATABASE_BREAKER_OPEN_FOR" env-default:"10s"`
+}
+
+// RetryConfig retries transient failures of postgres storage, such as serialization
+// failures, lost connections and failovers, by the class of the statement.
+type RetryConfig struct {
+	Read  RetryPolicyConfig `yaml:"read" env-prefix:"DATABASE_RETRY_READ_"`
+	Write RetryPolicyConfig `yaml:"write" env-prefix:"DATABASE_RETRY_WRITE_"`
+}
+
+// RetryPolicyConfig backs off exponentially from BaseDelay up to MaxDelay, with jitter.
+type RetryPolicyConfig struct {
+	// Attempts includes the first one; zero or one disables retries.
+	Attempts  int           `yaml:"attempts" env:"ATTEMPTS"`
+	BaseDelay time.Duration `yaml:"base_delay" env:"BASE_DELAY" env-default:"50ms"`
+	MaxDelay  time.Duration `yaml:"max_delay" env:"MAX_DELAY" env-default:"1s"`
+}
+
+// PoolConfig tunes the connection pool of postgres storage; zero keeps the default of pgxpool.
+type PoolConfig struct {
+	MaxConns          int32         `yaml:"max_conns" env:"DATABASE_POOL_MAX_CONNS"`
+	MinConns          int32         `yaml:"min_conns" env:"DATABASE_POOL_MIN_CONNS"`
+	MaxConnLifetime   time.Duration `yaml:"max_conn_lifetime" env:"DATABASE_POOL_MAX_CONN_LIFETIME"`
+	HealthCheckPeriod time.Duration `yaml:"health_check_period" env:"DATABASE_POOL_HEALTH_CHECK_PERIOD"`
+	// AcquireTimeout fails calls waiting this long for a free connection
+	// instead of holding them until their deadline.
+	AcquireTimeout time.Duration `yaml:"acquire_timeout" env:"DATABASE_POOL_ACQUIRE_TIMEOUT"`
+}
+
+// DSN returns the connection string of the storage driver, postgres or mysql.
+func (d DatabaseConfig) DSN(driver string) (string, error) {
+	if d.URL != "" {
+		return d.URL, nil
+	}
+
+	var password string
+	if d.PasswordFile != "" {
+		b, err := os.ReadFile(d.PasswordFile)
+		if err != nil {
+			return "", fmt.Errorf("password_file: %w", err)
+		}
+		password = strings.TrimRight(string(b), "\r\n")
+	}
+
+	port := d.Port
+
+	if driver == "mysql" {
+		if port == 0 {
+			port = 3306
+		}
+
+		cfg := mysql.NewConfig()
+		cfg.User = d.User
+		cfg.Passwd = password
+		cfg.Net = "tcp"
+		cfg.Addr = net.JoinHostPort(d.Host, strconv.Itoa(port))
+		cfg.DBName = d.Name
+		// sslmode в значения параметра tls драйвера go-sql-driver/mysql
+		cfg.TLSConfig = map[string]string{
+			"disable":     "false",
+			"prefer":      "preferred",
+			"require":     "skip-verify",
+			"verify-ca":   "true",
+			"verify-full": "true",
+		}[d.SSLMode]
+
+		return cfg.FormatDSN(), nil
+	}
+
+	if port == 0 {
+		port = 5432
+	}
+
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.User(d.User),
+		Host:     net.JoinHostPort(d.Host, strconv.Itoa(port)),
+		Path:     "/" + d.Name,
+		RawQuery: url.Values{"sslmode": {d.SSLMode}}.Encode(),
+	}
+	if password != "" {
+		u.User = url.UserPassword(d.User, password)
+	}
+
+	return u.String(), nil
+}
+
+func (d DatabaseConfig) redacted() DatabaseConfig {
+	d.URL = redactDSN(d.URL)
+	d.ReadURL = redactDSN(d.ReadURL)
+
+	return d
+}
+
+func (d DatabaseConfig) validate(driver string) error {
+	if d.URL == "" {
+		switch {
+		case d.Host == "":
+			return errors.New("host is required")
+		case d.Port < 0 || d.Port > 65535:
+			return fmt.Errorf("port %d is out of range", d.Port)
+		case d.Name == "":
+			return errors.New("name is required")
+		case d.User == "":
+			return errors.New("user is required")
+		case !slices.Contains([]string{"disable", "prefer", "require", "verify-ca", "verify-full"}, d.SSLMode):
+			return fmt.Errorf("unknown sslmode %q, want disable, prefer, require, verify-ca or verify-full", d.SSLMode)
+		}
+	}
+
+	p := d.Pool
+	switch {
+	case p.MaxConns < 0 || p.MinConns < 0:
+		return errors.New("pool conns must not be negative")
+	case p.MaxConns > 0 && p.MinConns > p.MaxConns:
+		return fmt.Errorf("pool min_conns %d exceed max_conns %d", p.MinConns, p.MaxConns)
+	case p.MaxConnLifetime < 0 || p.HealthCheckPeriod < 0 || p.AcquireTimeout < 0:
+		return errors.New("pool durations must not be negative")
+	case d.SlowQueryThreshold < 0:
+		return errors.New("slow_query_threshold must not be negative")
+	}
+
+	if d.ReadURL != "" && driver != "postgres" {
+		return errors.New("read_url is only supported with postgres storage")
+	}
+
+	if d.Breaker.Failures < 0 || d.Breaker.OpenFor <= 0 {
+		return errors.New("breaker: want failures not negative and open_for positive")
+	}
+
+	for class, r := range map[string]RetryPolicyConfig{"read": d.Retry.Read, "write": d.Retry.Write} {
+		if r.Attempts < 0 || r.BaseDelay < 0 || r.MaxDelay < r.BaseDelay {
+			return fmt.Errorf("retry.%s: want attempts and base_delay not negative, max_delay not below base_delay", class)
+		}
+	}
+
+	_, err := d.DSN(driver)
+
+	return err
+}
+
+type RedisConfig struct {
+	Addr     string `yaml:"addr" env:"REDIS_ADDR" env-default:"localhost:6379"`
+	Password string `yaml:"password" env:"REDIS_PASSWORD"`
+	DB       int    `yaml:"db" env:"REDIS_DB"`
+}
+
+// AppCacheConfig caches apps looked up on every login and token check.
+type AppCacheConfig struct {
+	// Store is "memory" for a cache of each instance or "redis" for one shared
+	// by all; empty disables the cache.
+	Store string        `yaml:"store" env:"APP_CACHE_STORE"`
+	TTL   time.Duration `yaml:"ttl" env:"APP_CACHE_TTL" env-default:"1m"`
+}
+
+// RevocationConfig configures revocation of access tokens before they expire.
+type RevocationConfig struct {
+	// Denylist of ids of revoked tokens: "postgres" or "redis" to check them on
+	// every token validation without a query to the database.
+	Denylist string `yaml:"denylist" env:"REVOCATION_DENYLIST" env-default:"postgres"`
+}
+
+// QuotaConfig limits requests per calling app. Counters are kept in Redis.
+type QuotaConfig struct {
+	Enabled bool        `yaml:"enabled"`
+	Default QuotaLimits `yaml:"default"`
+	// Apps overrides Default by app id.
+	Apps map[int]QuotaLimits `yaml:"apps"`
+	// Anonymous applies to each client IP making calls not authenticated as an app, e.g. Login.
+	Anonymous QuotaLimits `yaml:"anonymous"`
+}
+
+// QuotaLimits of zero mean unlimited.
+type QuotaLimits struct {
+	PerMinute int64 `yaml:"per_minute"`
+	PerDay    int64 `yaml:"per_day"`
+}
+
+// RateLimitConfig limits gRPC calls per client IP and method, in process memory.
+type RateLimitConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	Default RateLimitRule `yaml:"default"`
+	// Methods overrides Default by short method name, e.g. "Login".
+	Methods map[string]RateLimitRule `yaml:"methods"`
+}
+
+// RateLimitRule is a token bucket; zero PerMinute means unlimited.
+type RateLimitRule struct {
+	PerMinute float64 `yaml:"per_minute"`
+	Burst     int     `yaml:"burst"`
+}
+
+// HTTPConfig is shared by HTTP surfaces of the service.
+type HTTPConfig struct {
+	// Port of the HTTP server with OAuth2 endpoints; zero disables it.
+	Port int `yaml:"port"`
+	// Issuer is the public base URL of the HTTP server, used as iss of ID tokens;
+	// empty disables OpenID Connect.
+	Issuer string                `yaml:"issuer"`
+	CORS   middleware.CORSConfig `yaml:"cors"`
+	// HSTS is max-age of Strict-Transport-Security; zero disables the header.
+	HSTS time.Duration `yaml:"hsts"`
+	// Gateway serves the Auth gRPC methods as JSON under /v1/.
+	Gateway bool `yaml:"gateway"`
+	// SCIM serves user provisioning under /scim/v2/ for admin API keys.
+	SCIM bool `yaml:"scim"`
+	// API serves under /v1/ as JSON the Auth methods that have no gRPC RPCs.
+	API bool `yaml:"api"`
+}
+
+type MFAConfig struct {
+	// EncryptionKey is base64 of 32 bytes encrypting TOTP secrets; empty disables enrollment.
+	EncryptionKey string `yaml:"encryption_key" env:"MFA_ENCRYPTION_KEY"`
+	// Issuer is shown in authenticator apps.
+	Issuer string `yaml:"issuer" env-default:"SSO"`
+}
+
+type WebAuthnConfig struct {
+	// RPID is the domain passkeys are scoped to; empty disables passkeys.
+	RPID    string   `yaml:"rp_id"`
+	Origins []string `yaml:"origins"`
+}
+
+// SocialConfig holds OAuth2 clients registered at identity providers.
+// A provider is enabled by its client id and needs http.issuer for the callback URL.
+type SocialConfig struct {
+	Google SocialProviderConfig `yaml:"google" env-prefix:"GOOGLE_"`
+	GitHub SocialProviderConfig `yaml:"github" env-prefix:"GITHUB_"`
+}
+
+type SocialProviderConfig struct {
+	ClientID     string `yaml:"client_id" env:"CLIENT_ID"`
+	ClientSecret string `yaml:"client_secret" env:"CLIENT_SECRET"`
+}
+
+// LDAPConfig is a directory checking passwords of users of the email domains
+// instead of local hashes. Users are created on their first login.
+type LDAPConfig struct {
+	Domains  []string `yaml:"domains"`
+	URL      string   `yaml:"url"`
+	StartTLS bool     `yaml:"start_tls"`
+	// BindDN and BindPassword are the service account searching for users.
+	BindDN       string `yaml:"bind_dn"`
+	BindPassword string `yaml:"bind_password"`
+	BaseDN       string `yaml:"base_dn"`
+	// UserFilter finds the user entry, %s is the email; defaults to a search by mail.
+	UserFilter string `yaml:"user_filter"`
+	// GroupRoles are checked in order, the first group the user is a member of wins.
+	GroupRoles  []LDAPGroupRole `yaml:"group_roles"`
+	DefaultRole string          `yaml:"default_role"`
+	Timeout     time.Duration   `yaml:"timeout"`
+}
+
+type LDAPGroupRole struct {
+	Group string `yaml:"group"`
+	Role  string `yaml:"role"`
+}
+
+// WebhookConfig is the delivery of user events to webhooks registered by apps.
+type WebhookConfig struct {
+	// PollInterval is how often the queue of deliveries is checked.
+	PollInterval time.Duration `yaml:"poll_interval" env-default:"5s"`
+	// Timeout of a delivery request.
+	Timeout time.Duration `yaml:"timeout" env-default:"10s"`
+}
+
+// EventsConfig is the message broker user events are published to, in the
+// envelope of webhook deliveries.
+type EventsConfig struct {
+	// Transport is "nats" to publish to NATS JetStream; empty publishes nothing.
+	Transport string     `yaml:"transport"`
+	NATS      NATSConfig `yaml:"nats"`
+	// PollInterval is how often the outbox is checked for events to send.
+	PollInterval time.Duration `yaml:"poll_interval" env-default:"1s"`
+}
+
+type NATSConfig struct {
+	URL string `yaml:"url" env:"NATS_URL" env-default:"nats://localhost:4222"`
+	// SubjectPrefix is prepended to event names, e.g. "sso.user.registered".
+	// The subjects must be captured by a stream.
+	SubjectPrefix string `yaml:"subject_prefix" env-default:"sso."`
+}
+
+// IdempotencyConfig is for idempotency keys sent by clients with mutating calls.
+type IdempotencyConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// TTL is how long the outcome of a call is returned to its retries.
+	TTL time.Duration `yaml:"ttl" env-default:"24h"`
+}
+
+type FaultInjectionConfig struct {
+	Enabled             bool          `yaml:"enabled"`
+	Latency             time.Duration `yaml:"latency" env-default:"1s"`
+	LatencyPercent      float64       `yaml:"latency_percent"`
+	StorageErrorPercent float64       `yaml:"storage_error_percent"`
+	SigningErrorPercent float64       `yaml:"signing_error_percent"`
+	// AllowHeader lets callers request faults with the x-sso-fault metadata header.
+	AllowHeader bool `yaml:"allow_header"`
+}
+
+// DebugConfig is the listener for profiling; keep its port closed to clients.
+type DebugConfig struct {
+	// Address is the host the debug HTTP server listens on. It has no
+	// authentication, so it defaults to the loopback.
+	Address string `yaml:"address" env:"SSO_DEBUG_ADDRESS" env-default:"127.0.0.1"`
+	// Port of the debug HTTP server; zero disables it.
+	Port int `yaml:"port" env:"SSO_DEBUG_PORT"`
 }
 
 func MustLoad() *Config {
-	configPath := fetchConfig()
+	configPath, migrate := fetchConfig()
 	if configPath == "" {
 		panic("config file path is empty")
 	}
@@ -38,21 +552,168 @@ func MustLoad() *Config {
 		panic("config file read error: " + err.Error())
 	}
 
+	switch config.Storage {
+	case "postgres", "mysql", "memory":
+	default:
+		panic("unknown storage " + config.Storage + ", want postgres, mysql or memory")
+	}
+
+	if config.Storage != "memory" {
+		if err := config.Database.validate(config.Storage); err != nil {
+			panic("database: " + err.Error())
+		}
+	}
+
+	config.Path = configPath
+	// Флаг включает миграции при старте поверх конфига
+	config.Migrate = config.Migrate || migrate
+
 	return &config
 }
 
-func fetchConfig() string {
-	var result string
+// Effective returns the resolved configuration for diagnostics, with secrets redacted.
+func (c *Config) Effective() map[string]any {
+	return map[string]any{
+		"config_path":     c.Path,
+		"env":             c.Env,
+		"region":          c.Region,
+		"allowed_regions": c.AllowedRegions,
+		"grpc_port":       c.GRPC.Port,
+		"grpc_timeout":    c.GRPC.Timeout.String(),
+		"storage":         c.Storage,
+		"database":        c.Database.redacted(),
+		"migrations_path": c.MigrationsPath,
+		"migrate":         c.Migrate,
+		"token_ttl":       c.TokenTTL.String(),
+		"role_token_ttl":  c.RoleTokenTTL,
+		"refresh_ttl":     c.RefreshTokenTTL.String(),
+		"service_ttl":     c.ServiceTokenTTL.String(),
+		"token_leeway":    c.TokenLeeway.String(),
+		"secret_grace":    c.AppSecretGrace.String(),
+		"lockout":         c.Lockout,
+		"captcha":         c.Captcha.Provider,
+		"captcha_secret":  redact(c.Captcha.Secret),
+		"password_hash":   c.PasswordHash.Algorithm,
+		"argon2":          c.PasswordHash.Argon2,
+		"peppers":         slices.Sorted(maps.Keys(c.PasswordHash.Peppers)),
+		"password_breach": c.PasswordHash.Breach,
+		"signing_keys":    c.SigningKeys,
+		"sms_provider":    c.SMS.Provider,
+		"sms_url":         redactQueryURL(c.SMS.URL),
+		"sms_token":       redact(c.SMS.Token),
+		"email_provider":  c.Email.Provider,
+		"magic_link_url":  c.MagicLinkURL,
+		"registration":    c.Registration.Mode,
+		"email_domains":   c.EmailDomains,
+		"redis_addr":      c.Redis.Addr,
+		"redis_password":  redact(c.Redis.Password),
+		"redis_db":        c.Redis.DB,
+		"app_cache":       c.AppCache,
+		"trusted_proxies": c.TrustedProxies,
+		"revocation":      c.Revocation,
+		"quota":           c.Quota,
+		"rate_limit":      c.RateLimit,
+		"http":            c.HTTP,
+		"mfa_key":         redact(c.MFA.EncryptionKey),
+		"mfa_issuer":      c.MFA.Issuer,
+		"webauthn":        c.WebAuthn,
+		"social_google":   c.Social.Google.ClientID,
+		"social_github":   c.Social.GitHub.ClientID,
+		"ldap":            ldapDomains(c.LDAP),
+		"webhooks":        c.Webhooks,
+		"events":          c.Events.Transport,
+		"nats_url":        redactURL(c.Events.NATS.URL),
+		"idempotency":     c.Idempotency,
+		"fault_injection": c.FaultInjection,
+		"debug":           c.Debug,
+	}
+}
+
+// LogValue logs the effective configuration instead of the raw struct holding secrets.
+func (c *Config) LogValue() slog.Value {
+	return slog.AnyValue(c.Effective())
+}
+
+// ldapDomains lists domains by directory URL, leaving out the bind passwords.
+func ldapDomains(dirs []LDAPConfig) map[string][]string {
+	domains := make(map[string][]string, len(dirs))
+	for _, d := range dirs {
+		domains[d.URL] = append(domains[d.URL], d.Domains...)
+	}
+
+	return domains
+}
+
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+
+	return "[REDACTED]"
+}
 
-	if err := godotenv.Load(".env"); err != nil {
-		fmt.Println("⚠️  .env not loaded:", err)
-	} else {
-		fmt.Println("✅ .env loaded successfully")
+func redactURL(raw string) string {
+	if raw == "" {
+		return ""
 	}
 
-	fmt.Println("CONFIG_PATH from env:", os.Getenv("CONFIG_PATH"))
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "[REDACTED]"
+	}
+
+	return u.Redacted()
+}
+
+// redactQueryURL is redactURL also hiding the query values, for URLs of
+// providers taking the token as a query parameter.
+func redactQueryURL(raw string) string {
+	u, err := url.Parse(redactURL(raw))
+	if err != nil || u.RawQuery == "" {
+		return redactURL(raw)
+	}
+
+	q := u.Query()
+	for k := range q {
+		q[k] = []string{"xxxxx"}
+	}
+	u.RawQuery = q.Encode()
+
+	return u.String()
+}
+
+var (
+	// keyValueDSN matches connection strings of libpq keywords, e.g. "host=db user=sso".
+	keyValueDSN = regexp.MustCompile(`^\s*[a-z_]+\s*=`)
+	// dsnPassword matches the password and sslpassword keywords with a quoted or plain value.
+	dsnPassword = regexp.MustCompile(`(?i)((?:^|\s)(?:ssl)?password\s*=\s*)('(?:[^'\\]|\\.)*'|\S*)`)
+)
+
+// redactDSN hides the password of a database connection string: a URL, a
+// keyword/value DSN of libpq or a DSN of the mysql driver.
+func redactDSN(dsn string) string {
+	switch {
+	case dsn == "":
+		return ""
+	case strings.Contains(dsn, "://"):
+		return redactURL(dsn)
+	case keyValueDSN.MatchString(dsn):
+		return dsnPassword.ReplaceAllString(dsn, "${1}xxxxx")
+	default:
+		// DSN драйвера mysql (user:password@tcp(host)/db) скрываем целиком
+		return redact(dsn)
+	}
+}
+
+func fetchConfig() (string, bool) {
+	var result string
+	var migrate bool
+
+	// .env is optional, variables may come from the environment.
+	_ = godotenv.Load(".env")
 
 	flag.StringVar(&result, "config", "", "config file path")
+	flag.BoolVar(&migrate, "migrate", false, "apply pending migrations on startup")
 	flag.Parse()
 
 	if result == "" {
@@ -63,5 +724,5 @@ func fetchConfig() string {
 		result = "./config/local.yaml"
 	}
 
-	return result
+	return result, migrate
 }
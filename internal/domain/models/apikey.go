@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// APIKey authenticates a machine client of the app with the role. The key
+// itself is shown once on creation; only its hash is stored.
+type APIKey struct {
+	ID    int64
+	AppID int
+	Name  string
+	Role  string
+	// Prefix is the beginning of the key, to tell keys apart in lists.
+	Prefix    string
+	CreatedAt time.Time
+	// LastUsedAt and RevokedAt are zero if the key was never used or revoked.
+	LastUsedAt time.Time
+	RevokedAt  time.Time
+	// OrgID is the organization of the key's app.
+	OrgID int64
+}
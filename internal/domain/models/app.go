@@ -1,7 +1,30 @@
 package models
 
+import "time"
+
 type App struct {
 	ID     int
 	Name   string
 	Secret string
+	// PreviousSecret was replaced by rotation, but still verifies tokens
+	// until PreviousSecretExpiresAt.
+	PreviousSecret          string
+	PreviousSecretExpiresAt time.Time
+	// RedirectURIs are allowed as redirect_uri in the OAuth2 authorization code flow.
+	RedirectURIs []string
+	// Public apps (SPA, mobile) can't keep the secret and must use PKCE instead.
+	Public bool
+	// Scopes the app may request for service tokens (client credentials grant).
+	Scopes []string
+	// OrgID is the organization owning the app, zero for apps of the platform.
+	// Only users of the organization can log in to its apps.
+	OrgID int64
+	// TokenTTL overrides the global lifetime of access tokens; zero keeps it.
+	TokenTTL time.Duration
+	// Audience is the aud claim of tokens issued for the app; empty adds none.
+	Audience string
+	// CertSubject is the identity of the client certificate the app presents
+	// over mTLS, a URI SAN or the subject common name; empty if none.
+	CertSubject string
+	CreatedAt   time.Time
 }
@@ -0,0 +1,64 @@
+package models
+
+import "time"
+
+// Actions recorded in the audit log.
+const (
+	AuditRoleChanged        = "role_changed"
+	AuditUserDeleted        = "user_deleted"
+	AuditUserAnonymized     = "user_anonymized"
+	AuditUserSoftDeleted    = "user_soft_deleted"
+	AuditUserRestored       = "user_restored"
+	AuditUserSuspended      = "user_suspended"
+	AuditUserReinstated     = "user_reinstated"
+	AuditUserDataExported   = "user_data_exported"
+	AuditUserProvisioned    = "user_provisioned"
+	AuditUsersMerged        = "users_merged"
+	AuditAPIKeyCreated      = "api_key_created"
+	AuditAPIKeyRevoked      = "api_key_revoked"
+	AuditSessionRevoked     = "session_revoked"
+	AuditUserUnlocked       = "user_unlocked"
+	AuditRoleCreated        = "role_created"
+	AuditRoleUpdated        = "role_updated"
+	AuditRoleDeleted        = "role_deleted"
+	AuditGroupCreated       = "group_created"
+	AuditGroupMemberAdded   = "group_member_added"
+	AuditGroupMemberRemoved = "group_member_removed"
+	AuditOrgCreated         = "org_created"
+	AuditUserOrgChanged     = "user_org_changed"
+	AuditAppCreated         = "app_created"
+	AuditAppUpdated         = "app_updated"
+	AuditAppDeleted         = "app_deleted"
+	AuditAppSecretRotated   = "app_secret_rotated"
+	AuditWebhookCreated     = "webhook_created"
+	AuditWebhookDeleted     = "webhook_deleted"
+	AuditMFAReset           = "mfa_reset"
+)
+
+// AuditEvent is an entry of the append-only audit log of sensitive operations.
+type AuditEvent struct {
+	ID     int64
+	Action string
+	// Actor is who made the request; all zero for unauthenticated calls.
+	ActorUserID   int64
+	ActorAppID    int
+	ActorAPIKeyID int64
+	// TargetUserID is zero for operations on apps.
+	TargetUserID int64
+	TargetAppID  int
+	Reason       string
+	// Details hold action specific values, e.g. the new role.
+	Details   map[string]string
+	CreatedAt time.Time
+}
+
+// AuditFilter selects audit events; zero fields match everything.
+type AuditFilter struct {
+	Action       string
+	ActorUserID  int64
+	TargetUserID int64
+	// AppID matches events of the app as the actor or the target.
+	AppID int
+	Since time.Time
+	Until time.Time
+}
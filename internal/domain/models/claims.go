@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// TokenClaims describe a valid access token to services relying on SSO.
+type TokenClaims struct {
+	JTI string
+	// UserID is zero for service tokens issued to apps, see Scopes.
+	UserID    int64
+	Email     string
+	Role      string
+	AppID     int
+	Region    string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+	// CertThumbprint and KeyThumbprint are set for tokens bound to a client
+	// certificate or DPoP key; the relying service must check the binding.
+	CertThumbprint string
+	KeyThumbprint  string
+	// SessionID is the login session of the user, zero for older and service tokens.
+	SessionID int64
+	// Audience is the aud claim, see models.App.
+	Audience string
+	// Scopes are granted to service tokens, and to user tokens by permissions of the role.
+	Scopes []string
+	// Groups are names of the groups the user is in.
+	Groups []string
+	// OrgID is the organization of the user, zero for users of the platform.
+	OrgID int64
+}
@@ -0,0 +1,62 @@
+package models
+
+import "time"
+
+// Events about users, sent to the broker and to webhooks.
+const (
+	EventUserRegistered  = "user.registered"
+	EventUserRoleChanged = "user.role_changed"
+	EventUserDeleted     = "user.deleted"
+	// EventUserMerged is sent with the id of the merged user, which is gone,
+	// and the id of the user it was merged into.
+	EventUserMerged = "user.merged"
+	// EventUserLoginFailed and EventUserLocked are security events of a login
+	// to one app: a wrong password or code, and the account locked by it.
+	// They go to webhooks of that app only.
+	EventUserLoginFailed = "user.login_failed"
+	EventUserLocked      = "user.locked"
+)
+
+// Events are all events a webhook may subscribe to.
+var Events = []string{
+	EventUserRegistered, EventUserRoleChanged, EventUserDeleted, EventUserMerged,
+	EventUserLoginFailed, EventUserLocked,
+}
+
+// Event is the JSON envelope of events in the broker and in webhook deliveries.
+type Event struct {
+	// ID is the same for the event in the broker and in every webhook delivery.
+	ID        string    `json:"id"`
+	Type      string    `json:"event"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      EventUser `json:"data"`
+}
+
+// EventUser is the data of user events. Deleted users are sent by id only.
+type EventUser struct {
+	UserID int64  `json:"user_id"`
+	Email  string `json:"email,omitempty"`
+	Phone  string `json:"phone,omitempty"`
+	Role   string `json:"role,omitempty"`
+	// IntoID is set by EventUserMerged.
+	IntoID int64 `json:"into_id,omitempty"`
+	// AppID is the app the event is about, if any; see OutboxEvent.AppID.
+	AppID int `json:"app_id,omitempty"`
+	// IP is the client address of login events.
+	IP string `json:"ip,omitempty"`
+	// LockedUntil is set by EventUserLocked.
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
+}
+
+// OutboxEvent is an event written in the transaction of the change it
+// describes and not sent yet.
+type OutboxEvent struct {
+	ID      int64
+	EventID string
+	Type    string
+	Payload []byte
+	// AppID limits webhook deliveries to the webhooks of the app; zero sends
+	// the event to the webhooks of all apps.
+	AppID     int
+	CreatedAt time.Time
+}
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// Group of users, e.g. a team of an event organizer. Names of the groups
+// a user is in are included in tokens.
+type Group struct {
+	ID          int64
+	Name        string
+	Description string
+	CreatedAt   time.Time
+}
+
+// GroupMember is a user in a group.
+type GroupMember struct {
+	UserID  int64
+	Email   string
+	AddedAt time.Time
+}
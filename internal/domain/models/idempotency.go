@@ -0,0 +1,14 @@
+package models
+
+// IdempotencyRecord is the outcome of a call made with an idempotency key.
+type IdempotencyRecord struct {
+	// RequestHash tells a retry from another request sent with the same key.
+	RequestHash []byte
+	// Completed is false while the first call with the key is in progress.
+	Completed bool
+	// Code and Message are the gRPC status of the call.
+	Code    int
+	Message string
+	// Response is the marshalled google.protobuf.Any of a successful call.
+	Response []byte
+}
@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// Results of login attempts.
+const (
+	LoginSucceeded          = "success"
+	LoginInvalidCredentials = "invalid_credentials"
+	LoginMFARequired        = "mfa_required"
+	LoginInvalidCode        = "invalid_code"
+	LoginLocked             = "locked"
+	LoginSuspended          = "suspended"
+)
+
+// LoginAttempt is an entry of the login history of the user.
+type LoginAttempt struct {
+	ID int64
+	// UserID is zero for attempts with an unknown login.
+	UserID int64
+	AppID  int
+	// Login is what the user entered, empty for methods without one.
+	Login     string
+	Method    string
+	Result    string
+	IP        string
+	UserAgent string
+	CreatedAt time.Time
+}
@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// AuthorizationRequest holds parameters of an OAuth2 authorization request.
+type AuthorizationRequest struct {
+	AppID       int
+	RedirectURI string
+	// Scope is space-delimited; "openid" requests an ID token.
+	Scope string
+	// Nonce is copied into the ID token.
+	Nonce string
+	// State is returned to the app with the code; it isn't stored.
+	State string
+	// CodeChallenge and CodeChallengeMethod ("S256" or "plain") are PKCE parameters (RFC 7636).
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+// AuthorizationCode is an OAuth2 authorization code; only its hash is stored.
+type AuthorizationCode struct {
+	Hash        []byte
+	AppID       int
+	UserID      int64
+	RedirectURI string
+	Scope       string
+	Nonce       string
+	// CodeChallenge is empty if PKCE wasn't used.
+	CodeChallenge       string
+	CodeChallengeMethod string
+	ExpiresAt           time.Time
+}
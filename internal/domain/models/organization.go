@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// Organization is a tenant, e.g. an independent event operator. Its users
+// and apps are separated from those of other organizations.
+type Organization struct {
+	ID        int64
+	Name      string
+	CreatedAt time.Time
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// OTP is a short one-time code sent to the user out of band.
+type OTP struct {
+	// Key identifies the recipient, e.g. phone number.
+	Key       string
+	Purpose   string
+	CodeHash  []byte
+	ExpiresAt time.Time
+	Attempts  int
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// Passkey is a WebAuthn credential of the user.
+type Passkey struct {
+	ID     []byte
+	UserID int64
+	// PublicKey is PKIX DER.
+	PublicKey  []byte
+	SignCount  uint32
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// RefreshToken lets the client get a new access token for the app without
+// sending credentials again. Only its hash is stored.
+type RefreshToken struct {
+	Hash   []byte
+	UserID int64
+	AppID  int
+	// SessionID is zero for tokens issued before sessions were tracked.
+	SessionID int64
+	ExpiresAt time.Time
+}
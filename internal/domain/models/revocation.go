@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// Revocation of access tokens made in one region, to be applied in the
+// others. Either JTI with ExpiresAt, for a single token, or UserID with
+// Before, for all tokens of the user issued until then, is set.
+type Revocation struct {
+	Region    string    `json:"region"`
+	JTI       string    `json:"jti,omitempty"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+	UserID    int64     `json:"user_id,omitempty"`
+	Before    time.Time `json:"before,omitzero"`
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// Role groups permissions granted to users and API keys having it.
+type Role struct {
+	Name        string
+	Description string
+	// Rank orders roles by privilege, higher is more privileged.
+	Rank int
+	// SelfAssignable roles may be picked on self-registration.
+	SelfAssignable bool
+	Permissions    []string
+	CreatedAt      time.Time
+}
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// Session is a login of the user on a device, from the first token until
+// logout or its refresh tokens expire. Tokens carry the session id in "sid".
+// Region is the region of the instance that started it, empty for one region.
+type Session struct {
+	ID         int64
+	UserID     int64
+	AppID      int
+	IP         string
+	UserAgent  string
+	Device     string
+	Region     string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	ExpiresAt  time.Time
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// OneTimeToken is a long random token handed to the user, e.g. in a link:
+// password reset, email verification, magic link or invitation.
+// Only its hash is stored.
+type OneTimeToken struct {
+	Hash    []byte
+	Purpose string
+	// Subject is what the token was issued for, e.g. user id or email.
+	Subject    string
+	ExpiresAt  time.Time
+	ConsumedAt time.Time
+}
@@ -0,0 +1,19 @@
+package models
+
+// TOTP is the authenticator app enrolled by the user.
+type TOTP struct {
+	UserID int64
+	// Secret is encrypted.
+	Secret    []byte
+	Confirmed bool
+	// LastStep is the time step of the last accepted code.
+	LastStep int64
+}
+
+// MFAStatus is the second factors of a user as seen by admins. TOTPPending is
+// an authenticator that was enrolled but not confirmed yet.
+type MFAStatus struct {
+	TOTP        bool
+	TOTPPending bool
+	SMS         bool
+}
@@ -1,8 +1,67 @@
 package models
 
+import "time"
+
 type User struct {
 	ID       int64
 	Email    string
-	PassHash []byte
-	Role     string
+	Username string
+	// Phone is E.164 phone number, usable for login once PhoneVerified.
+	Phone         string
+	PhoneVerified bool
+	AvatarURL     string
+	PassHash      []byte
+	Role          string
+	CreatedAt     time.Time
+	// LastLoginAt is zero if the user has never logged in.
+	LastLoginAt time.Time
+	// OrgID is the organization of the user, zero for users of the platform itself.
+	OrgID int64
+	// DeletedAt is set while the user is soft-deleted and can still be restored.
+	DeletedAt time.Time
+	// Status is one of the UserStatus* constants.
+	Status string
+}
+
+// Statuses of user accounts. Suspended and banned users can't log in; the
+// difference is only in intent, suspensions are expected to be lifted.
+const (
+	UserStatusActive    = "active"
+	UserStatusSuspended = "suspended"
+	UserStatusBanned    = "banned"
+)
+
+// UserSort is server-side ordering of user lists.
+// Field is one of the UserSort* constants; empty Field sorts by id.
+type UserSort struct {
+	Field string
+	Desc  bool
+}
+
+const (
+	UserSortID          = "id"
+	UserSortEmail       = "email"
+	UserSortRole        = "role"
+	UserSortCreatedAt   = "created_at"
+	UserSortLastLoginAt = "last_login_at"
+)
+
+// UserCursor is the position after a user in a list ordered by UserSort:
+// Value is the sort field of the user as text, Null if the user has none.
+// Zero ID starts from the first user.
+type UserCursor struct {
+	Value string
+	Null  bool
+	ID    int64
+}
+
+// UserFilter narrows user lists and counts. Zero value matches everyone
+// except soft-deleted users.
+type UserFilter struct {
+	Role        string
+	EmailPrefix string
+	// OrgID limits the list to the organization; zero matches users of any.
+	OrgID int64
+	// Deleted includes soft-deleted users.
+	Deleted bool
 }
@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// Webhook is an endpoint of the app receiving the events it subscribed to.
+type Webhook struct {
+	ID     int64
+	AppID  int
+	URL    string
+	Events []string
+	// Secret signs deliveries; it is only returned on creation.
+	Secret    string
+	CreatedAt time.Time
+}
+
+// WebhookDelivery is an event queued for a webhook, with the outcome of its
+// last attempt. DeliveredAt and FailedAt are zero while it is pending;
+// FailedAt is set when the attempts are exhausted.
+type WebhookDelivery struct {
+	ID            int64
+	WebhookID     int64
+	Event         string
+	Payload       []byte
+	Attempts      int
+	NextAttemptAt time.Time
+	// LastStatus is the HTTP status of the last attempt, zero if there was no response.
+	LastStatus  int
+	LastError   string
+	CreatedAt   time.Time
+	DeliveredAt time.Time
+	FailedAt    time.Time
+}
@@ -6,25 +6,54 @@ import (
 	"sso/internal/domain/models"
 	"sso/internal/services/auth"
 	"sso/internal/storage"
+	"strconv"
+	"strings"
+	"time"
 
 	ssov1 "github.com/wadt3rr/city-events-auth-protos/gen/go/sso"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
+const (
+	inviteCodeHeader = "x-invite-code"
+	// refreshTokenHeader carries the refresh token in Login response headers,
+	// since LoginResponse has no field for it.
+	refreshTokenHeader = "x-refresh-token"
+	// mfaTicketHeader carries the ticket for the second login step when MFA is enabled.
+	mfaTicketHeader = "x-mfa-ticket"
+	// mfaMethodsHeader lists second factors the ticket can be exchanged with.
+	mfaMethodsHeader = "x-mfa-methods"
+	// lockedUntilHeader tells until when (RFC 3339) a locked account can't log in.
+	lockedUntilHeader = "x-locked-until"
+
+	// ListUsersRequest has no fields for paging, filters and order, so
+	// ListUsers takes them from metadata and returns the next page token in headers.
+	pageSizeHeader      = "x-page-size"
+	pageTokenHeader     = "x-page-token"
+	nextPageTokenHeader = "x-next-page-token"
+	roleFilterHeader    = "x-filter-role"
+	emailPrefixHeader   = "x-filter-email-prefix"
+	// deletedFilterHeader set to "true" lists soft-deleted users too.
+	deletedFilterHeader = "x-filter-deleted"
+	// sortHeader is a models.UserSort field, prefixed with "-" for descending order.
+	sortHeader = "x-sort"
+)
+
 type serverAPI struct {
 	ssov1.UnimplementedAuthServer
 	auth Auth
 }
 
 type Auth interface {
-	Login(ctx context.Context, email string, password string, appID int) (token string, err error)
-	RegisterNewUser(ctx context.Context, email string, password string, role string) (userID int64, err error)
+	Login(ctx context.Context, email string, password string, appID int) (token string, refreshToken string, err error)
+	RegisterNewUser(ctx context.Context, email string, password string, role string, inviteCode string) (userID int64, err error)
 
 	GetUserRole(ctx context.Context, userID int64) (role string, err error)
 	UpdateRole(ctx context.Context, userID int64, role string) (err error)
-	ListUsers(ctx context.Context) ([]models.User, error)
+	ListUsers(ctx context.Context, filter models.UserFilter, sort models.UserSort, pageToken string, limit int) ([]models.User, string, error)
 }
 
 func Register(gRPCServer *grpc.Server, auth Auth) {
@@ -46,14 +75,47 @@ func (s *serverAPI) Login(
 		return nil, status.Error(codes.InvalidArgument, "app_id is required")
 	}
 
-	token, err := s.auth.Login(ctx, in.GetEmail(), in.GetPassword(), int(in.GetAppId()))
+	token, refreshToken, err := s.auth.Login(ctx, in.GetEmail(), in.GetPassword(), int(in.GetAppId()))
 	if err != nil {
-		if errors.Is(err, auth.ErrInvalidCredentials) {
+		// Неизвестный логин неотличим от неверного пароля
+		if errors.Is(err, auth.ErrInvalidCredentials) || errors.Is(err, auth.ErrUserNotFound) {
 			return nil, status.Error(codes.Unauthenticated, "invalid email or password")
 		}
+		if errors.Is(err, auth.ErrInvalidDPoPProof) {
+			return nil, status.Error(codes.InvalidArgument, "invalid dpop proof")
+		}
+		var mfaErr *auth.MFARequiredError
+		if errors.As(err, &mfaErr) {
+			md := metadata.Pairs(mfaTicketHeader, mfaErr.Ticket, mfaMethodsHeader, strings.Join(mfaErr.Methods, ","))
+			if err := grpc.SetHeader(ctx, md); err != nil {
+				return nil, status.Error(codes.Internal, "failed to login")
+			}
+
+			return nil, status.Error(codes.FailedPrecondition, "second factor required")
+		}
+		var lockedErr *auth.LockedError
+		if errors.As(err, &lockedErr) {
+			if err := grpc.SetHeader(ctx, metadata.Pairs(lockedUntilHeader, lockedErr.Until.UTC().Format(time.RFC3339))); err != nil {
+				return nil, status.Error(codes.Internal, "failed to login")
+			}
+
+			return nil, status.Error(codes.PermissionDenied, "account is temporarily locked")
+		}
+		if errors.Is(err, auth.ErrAccountSuspended) {
+			return nil, status.Error(codes.PermissionDenied, "account is suspended")
+		}
+		if errors.Is(err, auth.ErrCaptchaRequired) {
+			return nil, status.Error(codes.FailedPrecondition, "captcha required")
+		}
 		return nil, status.Error(codes.Internal, "failed to login")
 	}
 
+	if refreshToken != "" {
+		if err := grpc.SetHeader(ctx, metadata.Pairs(refreshTokenHeader, refreshToken)); err != nil {
+			return nil, status.Error(codes.Internal, "failed to login")
+		}
+	}
+
 	return &ssov1.LoginResponse{Token: token}, nil
 }
 
@@ -68,17 +130,57 @@ func (s *serverAPI) Register(
 		return nil, status.Error(codes.InvalidArgument, "password is required")
 	}
 
-	uid, err := s.auth.RegisterNewUser(ctx, in.GetEmail(), in.GetPassword(), in.GetRole())
+	uid, err := s.auth.RegisterNewUser(ctx, in.GetEmail(), in.GetPassword(), in.GetRole(), inviteCode(ctx))
 	if err != nil {
+		if errors.Is(err, auth.ErrCaptchaRequired) {
+			return nil, status.Error(codes.FailedPrecondition, "captcha required")
+		}
+		if errors.Is(err, auth.ErrRegistrationClosed) {
+			return nil, status.Error(codes.PermissionDenied, "registration is closed")
+		}
+		if errors.Is(err, auth.ErrInvitationRequired) {
+			return nil, status.Error(codes.PermissionDenied, "valid invitation is required")
+		}
+		if errors.Is(err, auth.ErrEmailDomainNotAllowed) {
+			return nil, status.Error(codes.PermissionDenied, "email domain is not allowed")
+		}
+		if errors.Is(err, auth.ErrPasswordBreached) {
+			return nil, status.Error(codes.InvalidArgument, "password appeared in a data breach, choose another one")
+		}
 		if errors.Is(err, storage.ErrUserExists) {
 			return nil, status.Error(codes.AlreadyExists, "user already exists")
 		}
+		if errors.Is(err, auth.ErrInvalidPhone) {
+			return nil, status.Error(codes.InvalidArgument, "invalid phone number")
+		}
+		if errors.Is(err, auth.ErrInvalidRole) {
+			return nil, status.Error(codes.InvalidArgument, "invalid role")
+		}
 		return nil, status.Error(codes.Internal, "failed to register")
 	}
 
 	return &ssov1.RegisterResponse{UserId: uid}, nil
 }
 
+// inviteCode returns invitation code passed in metadata, since RegisterRequest has no field for it.
+func inviteCode(ctx context.Context) string {
+	return header(ctx, inviteCodeHeader)
+}
+
+// header returns the first value of the metadata key, or "".
+func header(ctx context.Context, key string) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	if v := md.Get(key); len(v) > 0 {
+		return v[0]
+	}
+
+	return ""
+}
+
 func (s *serverAPI) GetUserRole(ctx context.Context, in *ssov1.GetUserRoleRequest) (*ssov1.GetUserRoleResponse, error) {
 	role, err := s.auth.GetUserRole(ctx, in.GetUserId())
 	if err != nil {
@@ -96,17 +198,53 @@ func (s *serverAPI) UpdateRole(ctx context.Context, in *ssov1.UpdateUserRoleRequ
 		if errors.Is(err, auth.ErrUserNotFound) {
 			return nil, status.Error(codes.NotFound, "user not found")
 		}
+		if errors.Is(err, auth.ErrInvalidRole) {
+			return nil, status.Error(codes.InvalidArgument, "invalid role")
+		}
 		return nil, status.Error(codes.Internal, "failed to update user")
 	}
 	return &ssov1.UpdateUserRoleResponse{}, nil
 }
 
 func (s *serverAPI) ListUsers(ctx context.Context, request *ssov1.ListUsersRequest) (*ssov1.ListUsersResponse, error) {
-	users, err := s.auth.ListUsers(ctx)
+	limit := 0
+	if v := header(ctx, pageSizeHeader); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, status.Error(codes.InvalidArgument, "invalid page size")
+		}
+		limit = n
+	}
+
+	filter := models.UserFilter{Role: header(ctx, roleFilterHeader), EmailPrefix: header(ctx, emailPrefixHeader)}
+	if v := header(ctx, deletedFilterHeader); v != "" {
+		deleted, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, "invalid deleted filter")
+		}
+		filter.Deleted = deleted
+	}
+
+	var sort models.UserSort
+	sort.Field, sort.Desc = strings.CutPrefix(header(ctx, sortHeader), "-")
+
+	users, next, err := s.auth.ListUsers(ctx, filter, sort, header(ctx, pageTokenHeader), limit)
 	if err != nil {
+		if errors.Is(err, auth.ErrInvalidSort) {
+			return nil, status.Error(codes.InvalidArgument, "invalid sort field")
+		}
+		if errors.Is(err, auth.ErrInvalidPageToken) {
+			return nil, status.Error(codes.InvalidArgument, "invalid page token")
+		}
 		return nil, status.Error(codes.Internal, "failed to list users")
 	}
 
+	if next != "" {
+		if err := grpc.SetHeader(ctx, metadata.Pairs(nextPageTokenHeader, next)); err != nil {
+			return nil, status.Error(codes.Internal, "failed to list users")
+		}
+	}
+
 	resp := &ssov1.ListUsersResponse{}
 	for _, user := range users {
 		resp.Users = append(resp.Users, &ssov1.User{
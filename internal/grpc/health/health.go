@@ -0,0 +1,56 @@
+// Package health implements the standard grpc.health.v1.Health service with
+// a deep check of the dependencies of the service.
+package health
+
+import (
+	"context"
+	"log/slog"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/requestid"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Liveness is the service reporting only that the process is up, without
+// checking dependencies, so that a lost database doesn't restart instances.
+const Liveness = "liveness"
+
+// Pinger checks a dependency the service can't work without.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Server reports statuses set with SetServingStatus, like health.Server.
+// Check of a serving service other than Liveness also pings the dependencies,
+// so probes notice a lost database; Watch and List report the set statuses only.
+type Server struct {
+	*health.Server
+	log  *slog.Logger
+	deps map[string]Pinger
+}
+
+// New returns a server reporting Liveness and the overall service ("") as serving.
+func New(log *slog.Logger, deps map[string]Pinger) *Server {
+	s := &Server{Server: health.NewServer(), log: log, deps: deps}
+	s.SetServingStatus(Liveness, healthpb.HealthCheckResponse_SERVING)
+
+	return s
+}
+
+func (s *Server) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	resp, err := s.Server.Check(ctx, req)
+	if err != nil || resp.GetStatus() != healthpb.HealthCheckResponse_SERVING || req.GetService() == Liveness {
+		return resp, err
+	}
+
+	for name, dep := range s.deps {
+		if err := dep.Ping(ctx); err != nil {
+			requestid.Logger(ctx, s.log).Warn("health check failed", slog.String("dependency", name), sl.Err(err))
+
+			return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_NOT_SERVING}, nil
+		}
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,57 @@
+package interceptors
+
+import (
+	"context"
+	"log/slog"
+	"sso/internal/lib/caller"
+	"sso/internal/lib/clientinfo"
+	"sso/internal/lib/requestid"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// AccessLogUnaryInterceptor logs every call with its method, peer, duration,
+// status code and the authenticated caller. It must run before the
+// authenticating interceptors to see the caller they set.
+func AccessLogUnaryInterceptor(log *slog.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+
+		ctx, authenticated := caller.Track(ctx)
+
+		resp, err := handler(ctx, req)
+
+		code := status.Code(err)
+
+		attrs := []slog.Attr{
+			slog.String("method", info.FullMethod),
+			slog.String("peer", clientinfo.FromContext(ctx).IP),
+			slog.Duration("duration", time.Since(start)),
+			slog.String("code", code.String()),
+		}
+		if c, ok := authenticated(); ok {
+			if c.UserID != 0 {
+				attrs = append(attrs, slog.Int64("uid", c.UserID))
+			}
+			attrs = append(attrs, slog.Int("app_id", c.AppID))
+		}
+
+		requestid.Logger(ctx, log).LogAttrs(ctx, accessLogLevel(code), "call finished", attrs...)
+
+		return resp, err
+	}
+}
+
+// accessLogLevel is Error for failures of the service and Info for the rest,
+// including errors caused by the caller.
+func accessLogLevel(code codes.Code) slog.Level {
+	switch code {
+	case codes.Unknown, codes.Internal, codes.DataLoss, codes.Unavailable, codes.DeadlineExceeded:
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
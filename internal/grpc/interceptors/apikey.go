@@ -0,0 +1,58 @@
+package interceptors
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/caller"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/requestid"
+	"sso/internal/services/auth"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// APIKeyHeader carries the API key of a machine client.
+const APIKeyHeader = "x-api-key"
+
+type APIKeyAuthenticator interface {
+	AuthenticateAPIKey(ctx context.Context, key string) (models.APIKey, error)
+}
+
+// APIKeyUnaryInterceptor authenticates callers presenting an API key and puts
+// the key's app and role into the context, see caller.FromContext. Calls with
+// an invalid key fail with Unauthenticated; calls without one are passed through.
+func APIKeyUnaryInterceptor(log *slog.Logger, keys APIKeyAuthenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		md, ok := metadata.FromIncomingContext(ctx)
+		if !ok {
+			return handler(ctx, req)
+		}
+
+		v := md.Get(APIKeyHeader)
+		if len(v) == 0 {
+			return handler(ctx, req)
+		}
+
+		key, err := keys.AuthenticateAPIKey(ctx, v[0])
+		if err != nil {
+			if errors.Is(err, auth.ErrInvalidAPIKey) {
+				requestid.Logger(ctx, log).Info("invalid api key", slog.String("method", info.FullMethod))
+
+				return nil, status.Error(codes.Unauthenticated, "invalid api key")
+			}
+
+			requestid.Logger(ctx, log).Error("failed to authenticate api key", sl.Err(err))
+
+			return nil, status.Error(codes.Internal, "internal error")
+		}
+
+		ctx = caller.WithCaller(ctx, caller.Caller{AppID: key.AppID, Role: key.Role, APIKeyID: key.ID, OrgID: key.OrgID})
+
+		return handler(ctx, req)
+	}
+}
@@ -0,0 +1,136 @@
+package interceptors
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"sso/internal/domain/models"
+	"sso/internal/lib/caller"
+	"sso/internal/lib/dpop"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/mtls"
+	"sso/internal/lib/requestid"
+	"sso/internal/services/auth"
+	"strings"
+	"time"
+
+	ssov1 "github.com/wadt3rr/city-events-auth-protos/gen/go/sso"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// AuthorizationHeader carries "Bearer <access token>".
+const AuthorizationHeader = "authorization"
+
+// AnonymousMethods may be called without credentials.
+var AnonymousMethods = []string{
+	ssov1.Auth_Register_FullMethodName,
+	ssov1.Auth_Login_FullMethodName,
+	healthpb.Health_Check_FullMethodName,
+	healthpb.Health_List_FullMethodName,
+}
+
+type TokenValidator interface {
+	ValidateToken(ctx context.Context, token string) (models.TokenClaims, error)
+}
+
+// BearerUnaryInterceptor authenticates callers presenting an access token and
+// puts the user into the context, see caller.FromContext. Calls of methods
+// other than anonymous ones fail with Unauthenticated unless the caller is
+// already known from an API key or presents a valid token. An invalid token
+// fails the call even for anonymous methods. leeway is the clock skew tolerated
+// in DPoP proofs of bound tokens.
+func BearerUnaryInterceptor(log *slog.Logger, tokens TokenValidator, anonymous []string, leeway time.Duration) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		// API ключ уже проверен APIKeyUnaryInterceptor
+		if _, ok := caller.FromContext(ctx); ok {
+			return handler(ctx, req)
+		}
+
+		token, ok := bearerToken(ctx)
+		if !ok {
+			if slices.Contains(anonymous, info.FullMethod) {
+				return handler(ctx, req)
+			}
+
+			return nil, status.Error(codes.Unauthenticated, "access token or api key required")
+		}
+
+		claims, err := tokens.ValidateToken(ctx, token)
+		if err != nil {
+			if errors.Is(err, auth.ErrInvalidToken) || errors.Is(err, auth.ErrTokenRevoked) || errors.Is(err, auth.ErrTokenBinding) {
+				requestid.Logger(ctx, log).Info("invalid access token", slog.String("method", info.FullMethod))
+
+				return nil, status.Error(codes.Unauthenticated, "invalid access token")
+			}
+
+			requestid.Logger(ctx, log).Error("failed to validate access token", sl.Err(err))
+
+			return nil, status.Error(codes.Internal, "internal error")
+		}
+
+		// Сами проверяем привязку токена, как любой сервис, принимающий его
+		thumbprint, _ := mtls.PeerThumbprint(ctx)
+		if err := jwt.VerifyCertBinding(claims.CertThumbprint, thumbprint); err != nil {
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+		if err := verifyKeyBinding(ctx, claims.KeyThumbprint, token, leeway); err != nil {
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+
+		// Сервисные токены приложений без пользователя
+		if claims.UserID == 0 {
+			return nil, status.Error(codes.Unauthenticated, "user access token required")
+		}
+
+		ctx = caller.WithCaller(ctx, caller.Caller{UserID: claims.UserID, AppID: claims.AppID, Role: claims.Role, OrgID: claims.OrgID})
+
+		return handler(ctx, req)
+	}
+}
+
+// bearerToken returns the token of the "authorization: Bearer ..." header.
+func bearerToken(ctx context.Context) (string, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", false
+	}
+
+	v := md.Get(AuthorizationHeader)
+	if len(v) == 0 {
+		return "", false
+	}
+
+	scheme, token, ok := strings.Cut(v[0], " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+
+	return token, true
+}
+
+// verifyKeyBinding checks the DPoP proof of the call against the key the
+// token is bound to. Empty jkt means the token isn't bound.
+func verifyKeyBinding(ctx context.Context, jkt string, token string, leeway time.Duration) error {
+	if jkt == "" {
+		return nil
+	}
+
+	var proof *dpop.Proof
+
+	if raw, htu, ok := dpop.FromIncomingContext(ctx); ok {
+		p, err := dpop.Verify(raw, dpop.Method, htu, time.Now(), leeway)
+		if err != nil {
+			return err
+		}
+
+		proof = &p
+	}
+
+	return dpop.VerifyBinding(jkt, token, proof)
+}
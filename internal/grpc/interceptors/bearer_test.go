@@ -0,0 +1,111 @@
+package interceptors
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/dpop"
+	"sso/internal/lib/mtls"
+	"sso/ssotest"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+type fakeValidator struct {
+	claims models.TokenClaims
+}
+
+func (v fakeValidator) ValidateToken(context.Context, string) (models.TokenClaims, error) {
+	return v.claims, nil
+}
+
+func TestBearerUnaryInterceptorCertBinding(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	info := &grpc.UnaryServerInfo{FullMethod: "/auth.Auth/GetUserRole"}
+	handler := func(ctx context.Context, req any) (any, error) { return "ok", nil }
+
+	certA := &x509.Certificate{Raw: []byte("cert-a")}
+	certB := &x509.Certificate{Raw: []byte("cert-b")}
+
+	tests := []struct {
+		name  string
+		bound string
+		cert  *x509.Certificate
+		want  codes.Code
+	}{
+		{name: "unbound without certificate", want: codes.OK},
+		{name: "unbound with certificate", cert: certA, want: codes.OK},
+		{name: "bound with same certificate", bound: mtls.Thumbprint(certA), cert: certA, want: codes.OK},
+		{name: "bound without certificate", bound: mtls.Thumbprint(certA), want: codes.Unauthenticated},
+		{name: "bound with other certificate", bound: mtls.Thumbprint(certA), cert: certB, want: codes.Unauthenticated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens := fakeValidator{claims: models.TokenClaims{UserID: 1, CertThumbprint: tt.bound}}
+			interceptor := BearerUnaryInterceptor(log, tokens, nil, 0)
+
+			ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(AuthorizationHeader, "Bearer token"))
+			if tt.cert != nil {
+				ctx = peer.NewContext(ctx, &peer.Peer{AuthInfo: credentials.TLSInfo{
+					State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{tt.cert}},
+				}})
+			}
+
+			if _, err := interceptor(ctx, nil, info, handler); status.Code(err) != tt.want {
+				t.Errorf("code = %v, want %v", status.Code(err), tt.want)
+			}
+		})
+	}
+}
+
+func TestBearerUnaryInterceptorKeyBinding(t *testing.T) {
+	const token = "token"
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	info := &grpc.UnaryServerInfo{FullMethod: "/auth.Auth/GetUserRole"}
+	handler := func(ctx context.Context, req any) (any, error) { return "ok", nil }
+
+	key := ssotest.NewDPoPKey(t)
+	other := ssotest.NewDPoPKey(t)
+
+	tests := []struct {
+		name  string
+		bound string
+		proof string
+		want  codes.Code
+	}{
+		{name: "unbound without proof", want: codes.OK},
+		{name: "bound with proof", bound: key.JKT(), proof: key.Proof(t, info.FullMethod, token), want: codes.OK},
+		{name: "bound without proof", bound: key.JKT(), want: codes.Unauthenticated},
+		{name: "proof of other key", bound: key.JKT(), proof: other.Proof(t, info.FullMethod, token), want: codes.Unauthenticated},
+		{name: "proof of other token", bound: key.JKT(), proof: key.Proof(t, info.FullMethod, "other"), want: codes.Unauthenticated},
+		{name: "invalid proof", bound: key.JKT(), proof: "invalid", want: codes.Unauthenticated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens := fakeValidator{claims: models.TokenClaims{UserID: 1, KeyThumbprint: tt.bound}}
+			interceptor := BearerUnaryInterceptor(log, tokens, nil, 0)
+
+			md := metadata.Pairs(AuthorizationHeader, "Bearer "+token)
+			if tt.proof != "" {
+				md.Set(dpop.Header, tt.proof)
+			}
+			ctx := ssotest.IncomingContext(context.Background(), info.FullMethod, md)
+
+			if _, err := interceptor(ctx, nil, info, handler); status.Code(err) != tt.want {
+				t.Errorf("code = %v, want %v", status.Code(err), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,24 @@
+package interceptors
+
+import (
+	"context"
+	"sso/internal/lib/breaker"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// BreakerUnaryInterceptor fails calls with Unavailable while the breaker of the
+// storage is open, so that clients retry elsewhere instead of waiting for
+// the storage. Health checks are let through to report the outage themselves.
+func BreakerUnaryInterceptor(b *breaker.Breaker) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if b.Open() && !strings.HasPrefix(info.FullMethod, "/grpc.health.v1.") {
+			return nil, status.Error(codes.Unavailable, "storage is unavailable")
+		}
+
+		return handler(ctx, req)
+	}
+}
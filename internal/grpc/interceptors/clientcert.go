@@ -0,0 +1,60 @@
+package interceptors
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/caller"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/mtls"
+	"sso/internal/lib/requestid"
+	"sso/internal/services/auth"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type CertificateAuthenticator interface {
+	AuthenticateCertificate(ctx context.Context, cert *x509.Certificate) (models.App, error)
+}
+
+// ClientCertUnaryInterceptor authenticates services presenting a client
+// certificate registered for an app and puts the app into the context, see
+// caller.FromContext. The certificate is verified by the TLS handshake already.
+// Calls with an API key or a bearer token, e.g. on behalf of a user, and calls
+// with certificates of no app, such as those binding user tokens, are passed
+// through to the other authenticating interceptors.
+func ClientCertUnaryInterceptor(log *slog.Logger, certs CertificateAuthenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		cert, ok := mtls.PeerCertificate(ctx)
+		if !ok || hasCredentials(ctx) {
+			return handler(ctx, req)
+		}
+
+		app, err := certs.AuthenticateCertificate(ctx, cert)
+		if err != nil {
+			if errors.Is(err, auth.ErrUnknownCertificate) {
+				return handler(ctx, req)
+			}
+
+			requestid.Logger(ctx, log).Error("failed to authenticate client certificate", sl.Err(err))
+
+			return nil, status.Error(codes.Internal, "internal error")
+		}
+
+		ctx = caller.WithCaller(ctx, caller.Caller{AppID: app.ID, OrgID: app.OrgID})
+
+		return handler(ctx, req)
+	}
+}
+
+// hasCredentials reports whether the call carries an API key or a bearer token.
+func hasCredentials(ctx context.Context) bool {
+	md, ok := metadata.FromIncomingContext(ctx)
+
+	return ok && (len(md.Get(APIKeyHeader)) > 0 || len(md.Get(AuthorizationHeader)) > 0)
+}
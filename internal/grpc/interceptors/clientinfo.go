@@ -0,0 +1,17 @@
+package interceptors
+
+import (
+	"context"
+	"sso/internal/lib/clientinfo"
+
+	"google.golang.org/grpc"
+)
+
+// ClientInfoUnaryInterceptor resolves the client of the call, believing
+// x-forwarded-for of trusted proxies only, see clientinfo.Proxies. It must run
+// before the interceptors limiting or logging calls by client address.
+func ClientInfoUnaryInterceptor(proxies clientinfo.Proxies) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		return handler(proxies.WithClient(ctx), req)
+	}
+}
@@ -0,0 +1,100 @@
+package interceptors
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"sso/internal/lib/fault"
+	"sso/internal/lib/requestid"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// FaultHeader lets callers request faults explicitly, e.g. "latency=500ms,storage,signing".
+const FaultHeader = "x-sso-fault"
+
+// FaultConfig sets how often each fault is injected, in percent of requests.
+type FaultConfig struct {
+	Latency             time.Duration
+	LatencyPercent      float64
+	StorageErrorPercent float64
+	SigningErrorPercent float64
+	// AllowHeader enables FaultHeader.
+	AllowHeader bool
+}
+
+// FaultUnaryInterceptor injects latency, storage errors and token signing failures
+// for resilience testing of SSO clients. Must never be enabled in production.
+func FaultUnaryInterceptor(log *slog.Logger, cfg FaultConfig) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		f, ok := headerFaults(ctx, cfg)
+		if !ok {
+			f = fault.Faults{
+				Storage: hit(cfg.StorageErrorPercent),
+				Signing: hit(cfg.SigningErrorPercent),
+			}
+			if hit(cfg.LatencyPercent) {
+				f.Latency = cfg.Latency
+			}
+		}
+
+		if f == (fault.Faults{}) {
+			return handler(ctx, req)
+		}
+
+		requestid.Logger(ctx, log).Debug("injecting faults",
+			slog.String("method", info.FullMethod),
+			slog.Duration("latency", f.Latency),
+			slog.Bool("storage", f.Storage),
+			slog.Bool("signing", f.Signing),
+		)
+
+		if f.Latency > 0 {
+			select {
+			case <-time.After(f.Latency):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		return handler(fault.WithFaults(ctx, f), req)
+	}
+}
+
+func headerFaults(ctx context.Context, cfg FaultConfig) (fault.Faults, bool) {
+	if !cfg.AllowHeader {
+		return fault.Faults{}, false
+	}
+
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok || len(md.Get(FaultHeader)) == 0 {
+		return fault.Faults{}, false
+	}
+
+	var f fault.Faults
+
+	for _, part := range strings.Split(md.Get(FaultHeader)[0], ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+
+		switch name {
+		case "latency":
+			f.Latency = cfg.Latency
+			if d, err := time.ParseDuration(value); err == nil {
+				f.Latency = d
+			}
+		case "storage":
+			f.Storage = true
+		case "signing":
+			f.Signing = true
+		}
+	}
+
+	return f, true
+}
+
+func hit(percent float64) bool {
+	return percent > 0 && rand.Float64()*100 < percent
+}
@@ -0,0 +1,261 @@
+package interceptors
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sso/internal/domain/models"
+	"sso/internal/lib/caller"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/requestid"
+	"sso/internal/storage"
+	"strconv"
+	"time"
+
+	ssov1 "github.com/wadt3rr/city-events-auth-protos/gen/go/sso"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+const (
+	// IdempotencyKeyHeader is a client-generated key, e.g. a UUID, sent again
+	// with retries of the same call.
+	IdempotencyKeyHeader = "idempotency-key"
+	// IdempotentReplayHeader is set on responses replayed from an earlier call.
+	IdempotentReplayHeader = "x-idempotent-replay"
+
+	maxIdempotencyKeyLen = 255
+	// idempotencyLock is how long a call in progress holds its key. A key of
+	// a call that never finished, e.g. the instance died, is reused after it.
+	idempotencyLock = time.Minute
+)
+
+// IdempotentMethods create or change state and accept IdempotencyKeyHeader.
+var IdempotentMethods = []string{
+	ssov1.Auth_Register_FullMethodName,
+	ssov1.Auth_UpdateRole_FullMethodName,
+}
+
+// IdempotencyStore keeps outcomes of calls by key.
+type IdempotencyStore interface {
+	ReserveIdempotencyKey(ctx context.Context, scope string, key string, requestHash []byte, lockedUntil time.Time, expiresAt time.Time) (models.IdempotencyRecord, error)
+	CompleteIdempotencyKey(ctx context.Context, scope string, key string, rec models.IdempotencyRecord) error
+	ReleaseIdempotencyKey(ctx context.Context, scope string, key string) error
+}
+
+// IdempotencyUnaryInterceptor makes calls of methods carrying IdempotencyKeyHeader
+// safe to retry: the outcome of the first call is stored for ttl and returned
+// to retries with the same key instead of calling the handler again.
+// A retry while the first call is in progress fails with Aborted, a key reused
+// with another request fails with InvalidArgument. Transient failures aren't
+// stored, so that a retry runs the call again. Keys are scoped by method and
+// caller. If the store is unavailable, calls are let through.
+func IdempotencyUnaryInterceptor(log *slog.Logger, store IdempotencyStore, methods []string, ttl time.Duration) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if !slices.Contains(methods, info.FullMethod) {
+			return handler(ctx, req)
+		}
+
+		md, _ := metadata.FromIncomingContext(ctx)
+		v := md.Get(IdempotencyKeyHeader)
+		if len(v) == 0 {
+			return handler(ctx, req)
+		}
+
+		key := v[0]
+		if key == "" || len(key) > maxIdempotencyKeyLen {
+			return nil, status.Errorf(codes.InvalidArgument, "%s must be 1 to %d characters", IdempotencyKeyHeader, maxIdempotencyKeyLen)
+		}
+
+		msg, ok := req.(proto.Message)
+		if !ok {
+			return handler(ctx, req)
+		}
+
+		hash, err := requestHash(msg)
+		if err != nil {
+			return nil, status.Error(codes.Internal, "internal error")
+		}
+
+		log := requestid.Logger(ctx, log).With(slog.String("method", info.FullMethod))
+		scope := idempotencyScope(ctx, req, info.FullMethod)
+		now := time.Now()
+
+		rec, err := store.ReserveIdempotencyKey(ctx, scope, key, hash, now.Add(idempotencyLock), now.Add(ttl))
+		switch {
+		case errors.Is(err, storage.ErrIdempotencyKeyExists):
+			return replay(ctx, rec, hash)
+		case err != nil:
+			log.Warn("idempotency store unavailable", sl.Err(err))
+
+			return handler(ctx, req)
+		}
+
+		resp, err := handler(ctx, req)
+
+		// Исход записываем и при отмене вызова клиентом, иначе ключ висит до истечения блокировки
+		ctx = context.WithoutCancel(ctx)
+
+		st := status.Convert(err)
+		rec = models.IdempotencyRecord{Code: int(st.Code()), Message: st.Message()}
+
+		var merr error
+		if err == nil {
+			if rec.Response, merr = marshalResponse(resp); merr != nil {
+				log.Error("failed to marshal response", sl.Err(merr))
+			}
+		}
+
+		if transient(st.Code()) || merr != nil {
+			if err := store.ReleaseIdempotencyKey(ctx, scope, key); err != nil {
+				log.Error("failed to release idempotency key", sl.Err(err))
+			}
+
+			return resp, err
+		}
+
+		if err := store.CompleteIdempotencyKey(ctx, scope, key, rec); err != nil {
+			log.Error("failed to store idempotent outcome", sl.Err(err))
+		}
+
+		return resp, err
+	}
+}
+
+// replay returns the stored outcome of the call made with the key.
+func replay(ctx context.Context, rec models.IdempotencyRecord, hash []byte) (any, error) {
+	if !rec.Completed {
+		return nil, status.Error(codes.Aborted, "a call with this idempotency key is in progress")
+	}
+
+	if string(rec.RequestHash) != string(hash) {
+		return nil, status.Error(codes.InvalidArgument, "idempotency key was used with another request")
+	}
+
+	_ = grpc.SetHeader(ctx, metadata.Pairs(IdempotentReplayHeader, "true"))
+
+	if codes.Code(rec.Code) != codes.OK {
+		return nil, status.Error(codes.Code(rec.Code), rec.Message)
+	}
+
+	var a anypb.Any
+	if err := proto.Unmarshal(rec.Response, &a); err != nil {
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+
+	resp, err := a.UnmarshalNew()
+	if err != nil {
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+
+	return resp, nil
+}
+
+// idempotencyScope keeps keys of different callers and methods apart.
+func idempotencyScope(ctx context.Context, req any, method string) string {
+	if c, ok := caller.FromContext(ctx); ok {
+		switch {
+		case c.UserID != 0:
+			return fmt.Sprintf("%s:user:%d", method, c.UserID)
+		case c.APIKeyID != 0:
+			return fmt.Sprintf("%s:key:%d", method, c.APIKeyID)
+		}
+	}
+
+	if appID, ok := callerAppID(ctx, req); ok {
+		return fmt.Sprintf("%s:app:%d", method, appID)
+	}
+
+	return method
+}
+
+// AppIDHeader identifies the calling application for calls whose request has no app_id.
+const AppIDHeader = "x-app-id"
+
+// callerAppID takes app id from the request (e.g. LoginRequest.app_id),
+// the authenticated caller or metadata. It is a claim of the client, good
+// for keeping its keys apart but not for quotas.
+func callerAppID(ctx context.Context, req any) (int, bool) {
+	if r, ok := req.(interface{ GetAppId() int32 }); ok && r.GetAppId() != 0 {
+		return int(r.GetAppId()), true
+	}
+
+	if c, ok := caller.FromContext(ctx); ok && c.AppID != 0 {
+		return c.AppID, true
+	}
+
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return 0, false
+	}
+
+	v := md.Get(AppIDHeader)
+	if len(v) == 0 {
+		return 0, false
+	}
+
+	appID, err := strconv.Atoi(v[0])
+	if err != nil || appID == 0 {
+		return 0, false
+	}
+
+	return appID, true
+}
+
+// secretFields are left out of the request hash: the hash is stored, and
+// a plain SHA-256 of a password would be brute-forced from a dump of the
+// database much faster than its password hash. A retry with the same key
+// and another password is replayed as the same call.
+var secretFields = []protoreflect.Name{"password"}
+
+func requestHash(req proto.Message) ([]byte, error) {
+	req = proto.Clone(req)
+	fields := req.ProtoReflect().Descriptor().Fields()
+	for _, name := range secretFields {
+		if fd := fields.ByName(name); fd != nil {
+			req.ProtoReflect().Clear(fd)
+		}
+	}
+
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(b)
+
+	return sum[:], nil
+}
+
+func marshalResponse(resp any) ([]byte, error) {
+	msg, ok := resp.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("unexpected response type %T", resp)
+	}
+
+	a, err := anypb.New(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return proto.Marshal(a)
+}
+
+// transient codes mean the call may succeed if retried.
+func transient(code codes.Code) bool {
+	switch code {
+	case codes.Unknown, codes.Internal, codes.Unavailable, codes.DeadlineExceeded,
+		codes.Canceled, codes.Aborted, codes.ResourceExhausted:
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,108 @@
+package interceptors
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"sso/ssotest"
+	"testing"
+	"time"
+
+	ssov1 "github.com/wadt3rr/city-events-auth-protos/gen/go/sso"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestRequestHash(t *testing.T) {
+	base := &ssov1.RegisterRequest{Email: "user@example.com", Password: "correct-password", Role: "user"}
+
+	tests := []struct {
+		name string
+		req  proto.Message
+		want bool
+	}{
+		{name: "same request", req: &ssov1.RegisterRequest{Email: "user@example.com", Password: "correct-password", Role: "user"}, want: true},
+		// Пароль не входит в хеш
+		{name: "other password", req: &ssov1.RegisterRequest{Email: "user@example.com", Password: "other-password", Role: "user"}, want: true},
+		{name: "other email", req: &ssov1.RegisterRequest{Email: "other@example.com", Password: "correct-password", Role: "user"}, want: false},
+		{name: "other role", req: &ssov1.RegisterRequest{Email: "user@example.com", Password: "correct-password", Role: "admin"}, want: false},
+	}
+
+	want, err := requestHash(base)
+	if err != nil {
+		t.Fatalf("requestHash() error = %v", err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := requestHash(tt.req)
+			if err != nil {
+				t.Fatalf("requestHash() error = %v", err)
+			}
+
+			if equal := bytes.Equal(got, want); equal != tt.want {
+				t.Errorf("hash equal = %v, want %v", equal, tt.want)
+			}
+		})
+	}
+
+	if base.GetPassword() == "" {
+		t.Error("requestHash() cleared the password of the request")
+	}
+}
+
+func TestIdempotencyReplay(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	interceptor := IdempotencyUnaryInterceptor(log, ssotest.NewStorage(), IdempotentMethods, time.Hour)
+	info := &grpc.UnaryServerInfo{FullMethod: ssov1.Auth_Register_FullMethodName}
+
+	var calls int64
+	handler := func(context.Context, any) (any, error) {
+		calls++
+
+		return &ssov1.RegisterResponse{UserId: calls}, nil
+	}
+
+	tests := []struct {
+		name      string
+		key       string
+		email     string
+		wantCode  codes.Code
+		wantUser  int64
+		wantCalls int64
+	}{
+		{name: "first call", key: "key-1", email: "user@example.com", wantCode: codes.OK, wantUser: 1, wantCalls: 1},
+		{name: "retry is replayed", key: "key-1", email: "user@example.com", wantCode: codes.OK, wantUser: 1, wantCalls: 1},
+		{name: "key reused with another request", key: "key-1", email: "other@example.com", wantCode: codes.InvalidArgument, wantCalls: 1},
+		{name: "another key", key: "key-2", email: "user@example.com", wantCode: codes.OK, wantUser: 2, wantCalls: 2},
+		{name: "no key", email: "user@example.com", wantCode: codes.OK, wantUser: 3, wantCalls: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.key != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(IdempotencyKeyHeader, tt.key))
+			}
+
+			resp, err := interceptor(ctx, &ssov1.RegisterRequest{Email: tt.email, Password: "correct-password"}, info, handler)
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("code = %v, want %v: %v", code, tt.wantCode, err)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("handler calls = %d, want %d", calls, tt.wantCalls)
+			}
+			if err != nil {
+				return
+			}
+
+			if got := resp.(*ssov1.RegisterResponse).GetUserId(); got != tt.wantUser {
+				t.Errorf("user id = %d, want %d", got, tt.wantUser)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package interceptors
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sso/internal/lib/caller"
+	"sso/internal/lib/clientinfo"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/requestid"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Quota is the number of requests an app may make per minute and per day.
+// Zero means unlimited.
+type Quota struct {
+	PerMinute int64
+	PerDay    int64
+}
+
+// Counter counts hits in fixed time windows.
+type Counter interface {
+	// Incr increments the counter of the window containing now
+	// and returns its new value and the end of the window.
+	Incr(ctx context.Context, key string, window time.Duration) (count int64, resetAt time.Time, err error)
+}
+
+// QuotaUnaryInterceptor enforces per-app request quotas. Calls over quota fail
+// with ResourceExhausted and carry "x-quota-reset" (unix time) and "retry-after"
+// (seconds) headers. If the counter is unavailable, calls are let through.
+//
+// Only authenticated callers are counted against their app: the app_id of a
+// request such as Login is anyone's claim, and counting it would let anyone
+// use up the quota of another app. Other calls get the anonymous quota per
+// client IP, so that one client can't use it up for everyone; calls without
+// a known IP aren't counted.
+func QuotaUnaryInterceptor(log *slog.Logger, counter Counter, def Quota, anonymous Quota, perApp map[int]Quota) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		bucket, quota, appID := quotaBucket(ctx, def, anonymous, perApp)
+		if bucket == "" {
+			return handler(ctx, req)
+		}
+
+		windows := []struct {
+			name   string
+			limit  int64
+			window time.Duration
+		}{
+			{"m", quota.PerMinute, time.Minute},
+			{"d", quota.PerDay, 24 * time.Hour},
+		}
+
+		for _, w := range windows {
+			if w.limit <= 0 {
+				continue
+			}
+
+			count, resetAt, err := counter.Incr(ctx, fmt.Sprintf("quota:%s:%s", bucket, w.name), w.window)
+			if err != nil {
+				requestid.Logger(ctx, log).Warn("quota counter unavailable", slog.Int("app_id", appID), sl.Err(err))
+
+				return handler(ctx, req)
+			}
+
+			if count > w.limit {
+				retryAfter := int64(time.Until(resetAt).Seconds()) + 1
+
+				_ = grpc.SetHeader(ctx, metadata.Pairs(
+					"x-quota-reset", strconv.FormatInt(resetAt.Unix(), 10),
+					"retry-after", strconv.FormatInt(retryAfter, 10),
+				))
+
+				requestid.Logger(ctx, log).Info("app quota exceeded",
+					slog.Int("app_id", appID),
+					slog.String("method", info.FullMethod),
+					slog.String("window", w.name),
+				)
+
+				return nil, status.Errorf(codes.ResourceExhausted, "quota exceeded, retry after %ds", retryAfter)
+			}
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// quotaBucket returns the counter key and quota of the call: the app of an
+// authenticated caller or, for anonymous calls, the client IP. The key is
+// empty if there's neither.
+func quotaBucket(ctx context.Context, def Quota, anonymous Quota, perApp map[int]Quota) (bucket string, quota Quota, appID int) {
+	if c, ok := caller.FromContext(ctx); ok && c.AppID != 0 {
+		quota, ok := perApp[c.AppID]
+		if !ok {
+			quota = def
+		}
+
+		return strconv.Itoa(c.AppID), quota, c.AppID
+	}
+
+	ip := clientinfo.FromContext(ctx).IP
+	if ip == "" {
+		return "", Quota{}, 0
+	}
+
+	return "anon:" + ip, anonymous, 0
+}
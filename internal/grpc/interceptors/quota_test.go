@@ -0,0 +1,116 @@
+package interceptors
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sso/internal/lib/caller"
+	"sso/internal/lib/clientinfo"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type fakeCounter struct {
+	counts map[string]int64
+}
+
+func (c *fakeCounter) Incr(_ context.Context, key string, window time.Duration) (int64, time.Time, error) {
+	c.counts[key]++
+
+	return c.counts[key], time.Now().Add(window), nil
+}
+
+func TestQuotaBucket(t *testing.T) {
+	def := Quota{PerMinute: 10}
+	anonymous := Quota{PerMinute: 5}
+	perApp := map[int]Quota{2: {PerMinute: 100}}
+
+	tests := []struct {
+		name       string
+		ctx        context.Context
+		wantBucket string
+		wantQuota  Quota
+	}{
+		{
+			name:       "app with own quota",
+			ctx:        caller.WithCaller(context.Background(), caller.Caller{AppID: 2}),
+			wantBucket: "2",
+			wantQuota:  Quota{PerMinute: 100},
+		},
+		{
+			name:       "app with default quota",
+			ctx:        caller.WithCaller(context.Background(), caller.Caller{AppID: 3}),
+			wantBucket: "3",
+			wantQuota:  def,
+		},
+		{
+			name:       "app caller wins over client ip",
+			ctx:        caller.WithCaller(clientinfo.WithInfo(context.Background(), clientinfo.Info{IP: "203.0.113.1"}), caller.Caller{AppID: 3}),
+			wantBucket: "3",
+			wantQuota:  def,
+		},
+		{
+			name:       "anonymous by client ip",
+			ctx:        clientinfo.WithInfo(context.Background(), clientinfo.Info{IP: "203.0.113.1"}),
+			wantBucket: "anon:203.0.113.1",
+			wantQuota:  anonymous,
+		},
+		{
+			name:       "user without app",
+			ctx:        caller.WithCaller(clientinfo.WithInfo(context.Background(), clientinfo.Info{IP: "203.0.113.2"}), caller.Caller{UserID: 1}),
+			wantBucket: "anon:203.0.113.2",
+			wantQuota:  anonymous,
+		},
+		{
+			name:       "anonymous without ip",
+			ctx:        context.Background(),
+			wantBucket: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bucket, quota, _ := quotaBucket(tt.ctx, def, anonymous, perApp)
+
+			if bucket != tt.wantBucket {
+				t.Errorf("bucket = %q, want %q", bucket, tt.wantBucket)
+			}
+			if quota != tt.wantQuota {
+				t.Errorf("quota = %+v, want %+v", quota, tt.wantQuota)
+			}
+		})
+	}
+}
+
+func TestQuotaUnaryInterceptorAnonymousPerIP(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	counter := &fakeCounter{counts: map[string]int64{}}
+	interceptor := QuotaUnaryInterceptor(log, counter, Quota{}, Quota{PerMinute: 1}, nil)
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/auth.Auth/Login"}
+	handler := func(ctx context.Context, req any) (any, error) { return "ok", nil }
+
+	call := func(ip string) codes.Code {
+		ctx := clientinfo.WithInfo(context.Background(), clientinfo.Info{IP: ip})
+		_, err := interceptor(ctx, nil, info, handler)
+
+		return status.Code(err)
+	}
+
+	if code := call("203.0.113.1"); code != codes.OK {
+		t.Fatalf("first call: code = %v, want OK", code)
+	}
+	if code := call("203.0.113.1"); code != codes.ResourceExhausted {
+		t.Fatalf("second call from same ip: code = %v, want ResourceExhausted", code)
+	}
+	if code := call("203.0.113.2"); code != codes.OK {
+		t.Fatalf("call from other ip: code = %v, want OK", code)
+	}
+	if code := call(""); code != codes.OK {
+		t.Fatalf("call without ip: code = %v, want OK", code)
+	}
+}
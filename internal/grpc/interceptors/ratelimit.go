@@ -0,0 +1,51 @@
+package interceptors
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"sso/internal/lib/clientinfo"
+	"sso/internal/lib/ratelimit"
+	"sso/internal/lib/requestid"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// RateLimitUnaryInterceptor limits calls per client IP and method with token
+// buckets. perMethod is keyed by the short method name, e.g. "Login"; other
+// methods get def. Limited calls fail with ResourceExhausted and a
+// "retry-after" (seconds) header. Calls without a known IP aren't limited.
+func RateLimitUnaryInterceptor(log *slog.Logger, limiter *ratelimit.Limiter, def ratelimit.Limit, perMethod map[string]ratelimit.Limit) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		method := info.FullMethod[strings.LastIndex(info.FullMethod, "/")+1:]
+
+		limit, ok := perMethod[method]
+		if !ok {
+			limit = def
+		}
+
+		ip := clientinfo.FromContext(ctx).IP
+		if ip == "" || limit.Unlimited() {
+			return handler(ctx, req)
+		}
+
+		allowed, wait := limiter.Allow(method+":"+ip, limit, time.Now())
+		if !allowed {
+			retryAfter := int64(math.Ceil(wait.Seconds()))
+
+			_ = grpc.SetHeader(ctx, metadata.Pairs("retry-after", strconv.FormatInt(retryAfter, 10)))
+
+			requestid.Logger(ctx, log).Info("rate limit exceeded", slog.String("method", method), slog.String("ip", ip))
+
+			return nil, status.Errorf(codes.ResourceExhausted, "too many requests, retry after %ds", retryAfter)
+		}
+
+		return handler(ctx, req)
+	}
+}
@@ -0,0 +1,30 @@
+package interceptors
+
+import (
+	"context"
+	"sso/internal/lib/requestid"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// RequestIDUnaryInterceptor takes the x-request-id of the caller or generates
+// one, puts it into the context and returns it in the response header.
+// It must run before the logging interceptor for request logs to carry the id.
+func RequestIDUnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		var id string
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if v := md.Get(requestid.Header); len(v) > 0 && requestid.Valid(v[0]) {
+				id = v[0]
+			}
+		}
+		if id == "" {
+			id = requestid.New()
+		}
+
+		_ = grpc.SetHeader(ctx, metadata.Pairs(requestid.Header, id))
+
+		return handler(requestid.WithID(ctx, id), req)
+	}
+}
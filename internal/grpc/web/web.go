@@ -0,0 +1,275 @@
+// Package grpcweb serves unary gRPC-Web calls of browsers by proxying them
+// to the gRPC server, so SPAs don't need Envoy in front of the service.
+package grpcweb
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sso/internal/lib/clientinfo"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	contentType     = "application/grpc-web"
+	contentTypeText = "application/grpc-web-text"
+
+	maxMessageSize = 4 << 20
+
+	// trailerFlag marks the frame carrying trailers after the message.
+	trailerFlag = 0x80
+)
+
+// skippedHeaders are HTTP headers not passed to the gRPC server as metadata.
+var skippedHeaders = map[string]bool{
+	"accept":            true,
+	"accept-encoding":   true,
+	"accept-language":   true,
+	"connection":        true,
+	"content-length":    true,
+	"content-type":      true,
+	"cookie":            true,
+	"grpc-timeout":      true,
+	"host":              true,
+	"origin":            true,
+	"referer":           true,
+	"sec-fetch-dest":    true,
+	"sec-fetch-mode":    true,
+	"sec-fetch-site":    true,
+	"te":                true,
+	"transfer-encoding": true,
+	"user-agent":        true,
+	"x-forwarded-for":   true,
+	"x-grpc-web":        true,
+	"x-user-agent":      true,
+}
+
+// IsRequest reports whether r is a gRPC-Web call.
+func IsRequest(r *http.Request) bool {
+	return r.Method == http.MethodPost && strings.HasPrefix(r.Header.Get("Content-Type"), contentType)
+}
+
+type Handler struct {
+	conn grpc.ClientConnInterface
+}
+
+// New creates handler calling methods over conn, usually a loopback
+// connection to the gRPC server of this instance.
+func New(conn grpc.ClientConnInterface) *Handler {
+	return &Handler{conn: conn}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !IsRequest(r) {
+		http.Error(w, "grpc-web request expected", http.StatusUnsupportedMediaType)
+
+		return
+	}
+
+	text := strings.HasPrefix(r.Header.Get("Content-Type"), contentTypeText)
+
+	var body io.Reader = http.MaxBytesReader(w, r.Body, maxMessageSize)
+	if text {
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+
+	req, err := readMessage(body)
+	if err != nil {
+		http.Error(w, "invalid grpc-web request", http.StatusBadRequest)
+
+		return
+	}
+
+	ctx, cancel := h.callContext(r)
+	defer cancel()
+
+	var (
+		resp             []byte
+		header, trailers metadata.MD
+	)
+
+	err = h.conn.Invoke(ctx, r.URL.Path, &req, &resp, grpc.ForceCodec(rawCodec{}), grpc.Header(&header), grpc.Trailer(&trailers))
+	st := status.Convert(err)
+
+	var out bytes.Buffer
+	if err == nil {
+		writeFrame(&out, 0, resp)
+	}
+	writeFrame(&out, trailerFlag, trailerBlock(st, trailers))
+
+	respType := contentType + "+proto"
+	if text {
+		respType = contentTypeText + "+proto"
+	}
+
+	for k, v := range header {
+		for _, s := range v {
+			w.Header().Add(k, s)
+		}
+	}
+	w.Header().Set("Content-Type", respType)
+	w.WriteHeader(http.StatusOK)
+
+	if text {
+		enc := base64.NewEncoder(base64.StdEncoding, w)
+		_, _ = enc.Write(out.Bytes())
+		_ = enc.Close()
+
+		return
+	}
+
+	_, _ = w.Write(out.Bytes())
+}
+
+// callContext passes request headers as metadata and grpc-timeout as deadline.
+func (h *Handler) callContext(r *http.Request) (context.Context, context.CancelFunc) {
+	md := metadata.MD{}
+	for k, v := range r.Header {
+		k = strings.ToLower(k)
+		if !skippedHeaders[k] {
+			md.Set(k, v...)
+		}
+	}
+	if ip := clientinfo.FromContext(r.Context()).IP; ip != "" {
+		md.Set("x-forwarded-for", ip)
+	}
+	if ua := r.Header.Get("User-Agent"); ua != "" {
+		md.Set("user-agent", ua)
+	}
+
+	ctx := metadata.NewOutgoingContext(r.Context(), md)
+
+	if timeout, ok := parseTimeout(r.Header.Get("Grpc-Timeout")); ok {
+		return context.WithTimeout(ctx, timeout)
+	}
+
+	return context.WithCancel(ctx)
+}
+
+// readMessage reads the single message frame of a unary call.
+func readMessage(r io.Reader) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, err
+	}
+
+	if prefix[0] != 0 {
+		return nil, errors.New("compressed and trailer frames are not supported")
+	}
+
+	msg := make([]byte, binary.BigEndian.Uint32(prefix[1:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+func writeFrame(w *bytes.Buffer, flag byte, data []byte) {
+	var prefix [5]byte
+	prefix[0] = flag
+	binary.BigEndian.PutUint32(prefix[1:], uint32(len(data)))
+
+	w.Write(prefix[:])
+	w.Write(data)
+}
+
+// trailerBlock encodes the status and trailers as HTTP/1 header lines.
+func trailerBlock(st *status.Status, trailers metadata.MD) []byte {
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "grpc-status: %d\r\n", st.Code())
+	if st.Message() != "" {
+		fmt.Fprintf(&b, "grpc-message: %s\r\n", encodeMessage(st.Message()))
+	}
+	for k, v := range trailers {
+		for _, s := range v {
+			fmt.Fprintf(&b, "%s: %s\r\n", k, s)
+		}
+	}
+
+	return b.Bytes()
+}
+
+// encodeMessage percent-encodes grpc-message as the gRPC spec requires.
+func encodeMessage(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c >= ' ' && c <= '~' && c != '%' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+// parseTimeout parses grpc-timeout, e.g. "500m" for 500 milliseconds.
+func parseTimeout(v string) (time.Duration, bool) {
+	if len(v) < 2 {
+		return 0, false
+	}
+
+	units := map[byte]time.Duration{
+		'H': time.Hour,
+		'M': time.Minute,
+		'S': time.Second,
+		'm': time.Millisecond,
+		'u': time.Microsecond,
+		'n': time.Nanosecond,
+	}
+
+	unit, ok := units[v[len(v)-1]]
+	if !ok {
+		return 0, false
+	}
+
+	var n int64
+	for _, c := range v[:len(v)-1] {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		n = n*10 + int64(c-'0')
+	}
+
+	return time.Duration(n) * unit, true
+}
+
+// rawCodec passes messages through without decoding them.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v any) ([]byte, error) {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return nil, fmt.Errorf("grpcweb: unexpected message type %T", v)
+	}
+
+	return *b, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v any) error {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("grpcweb: unexpected message type %T", v)
+	}
+
+	*b = append((*b)[:0], data...)
+
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return "proto"
+}
@@ -0,0 +1,645 @@
+// Package api serves as HTTP/JSON the methods of the Auth service that the
+// gRPC protos have no RPCs for. Callers authenticate as gRPC clients do, with
+// "Authorization: Bearer <access token>" or "X-API-Key"; endpoints managing
+// other users require an admin, checked as by AdminUnaryInterceptor.
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"sso/internal/domain/models"
+	"sso/internal/lib/audit"
+	"sso/internal/lib/caller"
+	"sso/internal/lib/captcha"
+	"sso/internal/lib/clientinfo"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/ratelimit"
+	"sso/internal/lib/requestid"
+	"sso/internal/services/auth"
+	"sso/internal/storage"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxBodySize = 1 << 20
+
+	apiKeyHeader = "X-Api-Key"
+	// mfaTicketHeader and mfaMethodsHeader answer logins needing the second
+	// factor, named as the headers of the Login response.
+	mfaTicketHeader  = "X-Mfa-Ticket"
+	mfaMethodsHeader = "X-Mfa-Methods"
+)
+
+// Auth is the part of the Auth service served by the handler.
+type Auth interface {
+	ValidateToken(ctx context.Context, token string) (models.TokenClaims, error)
+	AuthenticateAPIKey(ctx context.Context, key string) (models.APIKey, error)
+	IsAdmin(ctx context.Context, userID int64) (bool, error)
+
+	Refresh(ctx context.Context, refreshToken string) (string, string, error)
+	Logout(ctx context.Context, token string, refreshToken string, all bool) error
+
+	EffectiveConfig() map[string]any
+	RegistrationMode() string
+	SetRegistrationMode(mode string) error
+	CreateInvitation(ctx context.Context, email string) (string, error)
+	CreateUser(ctx context.Context, login string, pass string, role string) (int64, error)
+
+	UserExists(ctx context.Context, email string) (bool, error)
+	GetUser(ctx context.Context, userID int64, email string) (models.User, error)
+	SearchUsers(ctx context.Context, query string, limit int) ([]models.User, error)
+	SetUsername(ctx context.Context, userID int64, username string) error
+	SetAvatar(ctx context.Context, userID int64, avatarURL string) error
+	GetPreferences(ctx context.Context, userID int64) (map[string]string, error)
+	SetPreference(ctx context.Context, userID int64, key string, value string) error
+	GetUserMetadata(ctx context.Context, userID int64, appID int) (json.RawMessage, error)
+	SetUserMetadata(ctx context.Context, userID int64, appID int, data json.RawMessage) error
+	ExportUserData(ctx context.Context, userID int64) ([]byte, error)
+	DeleteUser(ctx context.Context, userID int64, anonymize bool) error
+	SoftDeleteUser(ctx context.Context, userID int64) error
+	RestoreUser(ctx context.Context, userID int64) error
+	UnlockUser(ctx context.Context, userID int64) error
+	SuspendUser(ctx context.Context, userID int64, status string) error
+	MergeUsers(ctx context.Context, fromID int64, intoID int64) error
+	ReinstateUser(ctx context.Context, userID int64) error
+
+	ChangePassword(ctx context.Context, userID int64, oldPassword string, newPassword string) error
+
+	RequestPhoneVerification(ctx context.Context, userID int64, number string) error
+	VerifyPhone(ctx context.Context, userID int64, code string) error
+	RequestLoginCode(ctx context.Context, number string) error
+	LoginWithPhoneCode(ctx context.Context, number string, code string, appID int) (string, error)
+
+	EnrollTOTP(ctx context.Context, userID int64) (string, string, error)
+	ConfirmTOTP(ctx context.Context, userID int64, code string) ([]string, error)
+	VerifyTOTP(ctx context.Context, ticket string, code string) (string, string, error)
+	SetSMSMFA(ctx context.Context, userID int64, enabled bool) error
+	VerifySMSCode(ctx context.Context, ticket string, code string) (string, string, error)
+	MFAStatus(ctx context.Context, userID int64) (models.MFAStatus, error)
+	ResetMFA(ctx context.Context, userID int64) error
+
+	BeginRegisterPasskey(ctx context.Context, userID int64) (string, error)
+	FinishRegisterPasskey(ctx context.Context, userID int64, clientDataJSON []byte, attestationObject []byte) error
+	BeginLoginPasskey(ctx context.Context) (string, error)
+	FinishLoginPasskey(
+		ctx context.Context,
+		credentialID []byte,
+		clientDataJSON []byte,
+		authenticatorData []byte,
+		signature []byte,
+		appID int,
+	) (string, string, error)
+
+	RegenerateRecoveryCodes(ctx context.Context, userID int64) ([]string, error)
+	VerifyRecoveryCode(ctx context.Context, ticket string, code string) (string, string, error)
+
+	RequestMagicLink(ctx context.Context, email string, appID int) error
+	LoginWithMagicLink(ctx context.Context, token string) (string, string, error)
+
+	CreateApp(ctx context.Context, app models.App) (models.App, error)
+	UpdateApp(ctx context.Context, app models.App) error
+	DeleteApp(ctx context.Context, appID int) error
+	RotateAppSecret(ctx context.Context, appID int) (string, error)
+	ListApps(ctx context.Context) ([]models.App, error)
+
+	CreateAPIKey(ctx context.Context, appID int, name string, role string) (string, models.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id int64) error
+	ListAPIKeys(ctx context.Context, appID int) ([]models.APIKey, error)
+
+	CreateWebhook(ctx context.Context, appID int, endpoint string, events []string) (models.Webhook, error)
+	DeleteWebhook(ctx context.Context, id int64) error
+	ListWebhooks(ctx context.Context, appID int) ([]models.Webhook, error)
+	ListWebhookDeliveries(ctx context.Context, webhookID int64, pageToken string, limit int) ([]models.WebhookDelivery, string, error)
+
+	ListSessions(ctx context.Context, userID int64) ([]models.Session, error)
+	RevokeSession(ctx context.Context, userID int64, sessionID int64) error
+
+	GetLoginHistory(ctx context.Context, userID int64, pageToken string, limit int) ([]models.LoginAttempt, string, error)
+
+	QueryAuditLog(ctx context.Context, filter models.AuditFilter, pageToken string, limit int) ([]models.AuditEvent, string, error)
+	ListAppAuditEvents(ctx context.Context, filter models.AuditFilter, pageToken string, limit int) ([]models.AuditEvent, string, error)
+
+	CreateRole(ctx context.Context, role models.Role) error
+	GetRole(ctx context.Context, name string) (models.Role, error)
+	ListRoles(ctx context.Context) ([]models.Role, error)
+	EditRole(ctx context.Context, role models.Role) error
+	DeleteRole(ctx context.Context, name string) error
+
+	CreateGroup(ctx context.Context, name string, description string) (int64, error)
+	AddUserToGroup(ctx context.Context, groupID int64, userID int64) error
+	RemoveUserFromGroup(ctx context.Context, groupID int64, userID int64) error
+	ListGroupMembers(ctx context.Context, groupID int64, pageToken string, limit int) ([]models.GroupMember, string, error)
+
+	CreateOrganization(ctx context.Context, name string) (int64, error)
+	GetOrganization(ctx context.Context, id int64) (models.Organization, error)
+	ListOrganizations(ctx context.Context) ([]models.Organization, error)
+	SetUserOrganization(ctx context.Context, userID int64, orgID int64) error
+}
+
+type Handler struct {
+	log  *slog.Logger
+	auth Auth
+	// limiter throttles endpoints per client IP in the buckets of the gRPC
+	// methods of the same name, with the same limits.
+	limiter   *ratelimit.Limiter
+	def       ratelimit.Limit
+	perMethod map[string]ratelimit.Limit
+}
+
+// New creates handler of the endpoints. Endpoints are limited by perMethod,
+// keyed by the short name of the service method, e.g. "Refresh", or def.
+func New(log *slog.Logger, auth Auth, limiter *ratelimit.Limiter, def ratelimit.Limit, perMethod map[string]ratelimit.Limit) *Handler {
+	return &Handler{log: log, auth: auth, limiter: limiter, def: def, perMethod: perMethod}
+}
+
+// Register adds the endpoints to mux.
+func (h *Handler) Register(mux *http.ServeMux) {
+	mux.HandleFunc("POST /v1/token/refresh", h.limited("Refresh", h.refresh))
+	mux.HandleFunc("POST /v1/logout", h.limited("Logout", h.logout))
+	mux.HandleFunc("POST /v1/token/validate", h.authenticated("ValidateToken", h.validateToken))
+
+	mux.HandleFunc("GET /v1/config", h.admin("GetEffectiveConfig", h.getEffectiveConfig))
+
+	mux.HandleFunc("GET /v1/registration-mode", h.admin("RegistrationMode", h.getRegistrationMode))
+	mux.HandleFunc("PUT /v1/registration-mode", h.admin("SetRegistrationMode", h.setRegistrationMode))
+	mux.HandleFunc("POST /v1/invitations", h.admin("CreateInvitation", h.createInvitation))
+	mux.HandleFunc("POST /v1/users", h.admin("CreateUser", h.createUser))
+
+	mux.HandleFunc("GET /v1/users/{id}", h.selfOrAdmin("GetUser", h.getUser))
+	mux.HandleFunc("GET /v1/users/exists", h.limited("UserExists", h.userExists))
+	mux.HandleFunc("GET /v1/users/lookup", h.admin("GetUser", h.getUserByEmail))
+	mux.HandleFunc("GET /v1/users/search", h.admin("SearchUsers", h.searchUsers))
+	mux.HandleFunc("GET /v1/users/{id}/export", h.selfOrAdmin("ExportUserData", h.exportUserData))
+	mux.HandleFunc("DELETE /v1/users/{id}", h.admin("DeleteUser", h.deleteUser))
+	mux.HandleFunc("POST /v1/users/{id}/restore", h.admin("RestoreUser", h.restoreUser))
+	mux.HandleFunc("POST /v1/users/{id}/unlock", h.admin("UnlockUser", h.unlockUser))
+	mux.HandleFunc("POST /v1/users/{id}/suspend", h.admin("SuspendUser", h.suspendUser(models.UserStatusSuspended)))
+	mux.HandleFunc("POST /v1/users/{id}/ban", h.admin("SuspendUser", h.suspendUser(models.UserStatusBanned)))
+	mux.HandleFunc("POST /v1/users/{id}/reinstate", h.admin("ReinstateUser", h.reinstateUser))
+	mux.HandleFunc("POST /v1/users/{id}/merge", h.admin("MergeUsers", h.mergeUsers))
+	mux.HandleFunc("GET /v1/users/{id}/admin", h.authenticated("IsAdmin", h.userIsAdmin))
+
+	mux.HandleFunc("PUT /v1/users/{id}/username", h.selfOrAdmin("SetUsername", h.setUsername))
+	mux.HandleFunc("PUT /v1/users/{id}/avatar", h.selfOrAdmin("SetAvatar", h.setAvatar))
+	mux.HandleFunc("GET /v1/users/{id}/preferences", h.selfOrAdmin("GetPreferences", h.getPreferences))
+	mux.HandleFunc("PUT /v1/users/{id}/preferences/{key}", h.selfOrAdmin("SetPreference", h.setPreference))
+
+	// Метаданные приложения API ключа, админы указывают app_id
+	mux.HandleFunc("GET /v1/users/{id}/metadata", h.authenticated("GetUserMetadata", h.getUserMetadata))
+	mux.HandleFunc("PUT /v1/users/{id}/metadata", h.authenticated("SetUserMetadata", h.setUserMetadata))
+
+	mux.HandleFunc("POST /v1/me/password", h.user("ChangePassword", h.changePassword))
+
+	// Коды по SMS ограничены по IP, как и вход
+	mux.HandleFunc("POST /v1/me/phone", h.user("RequestPhoneVerification", h.requestPhoneVerification))
+	mux.HandleFunc("POST /v1/me/phone/verify", h.user("VerifyPhone", h.verifyPhone))
+	mux.HandleFunc("POST /v1/phone/code", h.limited("RequestLoginCode", h.requestLoginCode))
+	mux.HandleFunc("POST /v1/phone/login", h.limited("LoginWithPhoneCode", h.loginWithPhoneCode))
+
+	// Второй шаг входа по билету из заголовка X-Mfa-Ticket ответа Login
+	mux.HandleFunc("POST /v1/me/mfa/totp", h.user("EnrollTOTP", h.enrollTOTP))
+	mux.HandleFunc("POST /v1/me/mfa/totp/confirm", h.user("ConfirmTOTP", h.confirmTOTP))
+	mux.HandleFunc("POST /v1/mfa/totp", h.limited("VerifyTOTP", h.verifyTOTP))
+	mux.HandleFunc("PUT /v1/me/mfa/sms", h.user("SetSMSMFA", h.setSMSMFA))
+	mux.HandleFunc("POST /v1/mfa/sms", h.limited("VerifySMSCode", h.verifySMSCode))
+	mux.HandleFunc("GET /v1/users/{id}/mfa", h.admin("MFAStatus", h.mfaStatus))
+	mux.HandleFunc("DELETE /v1/users/{id}/mfa", h.admin("ResetMFA", h.resetMFA))
+
+	mux.HandleFunc("POST /v1/me/passkeys/begin", h.user("BeginRegisterPasskey", h.beginRegisterPasskey))
+	mux.HandleFunc("POST /v1/me/passkeys", h.user("FinishRegisterPasskey", h.finishRegisterPasskey))
+	mux.HandleFunc("POST /v1/passkeys/login/begin", h.limited("BeginLoginPasskey", h.beginLoginPasskey))
+	mux.HandleFunc("POST /v1/passkeys/login", h.limited("FinishLoginPasskey", h.finishLoginPasskey))
+
+	mux.HandleFunc("POST /v1/me/mfa/recovery-codes", h.user("RegenerateRecoveryCodes", h.regenerateRecoveryCodes))
+	mux.HandleFunc("POST /v1/mfa/recovery", h.limited("VerifyRecoveryCode", h.verifyRecoveryCode))
+
+	mux.HandleFunc("POST /v1/magic-link", h.limited("RequestMagicLink", h.requestMagicLink))
+	mux.HandleFunc("POST /v1/magic-link/login", h.limited("LoginWithMagicLink", h.loginWithMagicLink))
+
+	mux.HandleFunc("GET /v1/apps", h.admin("ListApps", h.listApps))
+	mux.HandleFunc("POST /v1/apps", h.admin("CreateApp", h.createApp))
+	mux.HandleFunc("PUT /v1/apps/{id}", h.admin("UpdateApp", h.updateApp))
+	mux.HandleFunc("DELETE /v1/apps/{id}", h.admin("DeleteApp", h.deleteApp))
+	mux.HandleFunc("POST /v1/apps/{id}/secret", h.admin("RotateAppSecret", h.rotateAppSecret))
+
+	mux.HandleFunc("POST /v1/apps/{id}/api-keys", h.admin("CreateAPIKey", h.createAPIKey))
+	mux.HandleFunc("GET /v1/apps/{id}/api-keys", h.admin("ListAPIKeys", h.listAPIKeys))
+	mux.HandleFunc("DELETE /v1/api-keys/{id}", h.admin("RevokeAPIKey", h.revokeAPIKey))
+
+	mux.HandleFunc("POST /v1/apps/{id}/webhooks", h.admin("CreateWebhook", h.createWebhook))
+	mux.HandleFunc("GET /v1/apps/{id}/webhooks", h.admin("ListWebhooks", h.listWebhooks))
+	mux.HandleFunc("DELETE /v1/webhooks/{id}", h.admin("DeleteWebhook", h.deleteWebhook))
+	mux.HandleFunc("GET /v1/webhooks/{id}/deliveries", h.admin("ListWebhookDeliveries", h.listWebhookDeliveries))
+
+	mux.HandleFunc("GET /v1/users/{id}/sessions", h.selfOrAdmin("ListSessions", h.listSessions))
+	mux.HandleFunc("DELETE /v1/users/{id}/sessions/{sid}", h.selfOrAdmin("RevokeSession", h.revokeSession))
+
+	mux.HandleFunc("GET /v1/users/{id}/logins", h.selfOrAdmin("GetLoginHistory", h.loginHistory))
+
+	mux.HandleFunc("GET /v1/audit", h.admin("QueryAuditLog", h.queryAuditLog))
+	mux.HandleFunc("GET /v1/app/audit", h.authenticated("ListAppAuditEvents", h.listAppAuditEvents))
+
+	mux.HandleFunc("GET /v1/roles", h.authenticated("ListRoles", h.listRoles))
+	mux.HandleFunc("GET /v1/roles/{name}", h.authenticated("GetRole", h.getRole))
+	mux.HandleFunc("POST /v1/roles", h.admin("CreateRole", h.createRole))
+	mux.HandleFunc("PUT /v1/roles/{name}", h.admin("EditRole", h.editRole))
+	mux.HandleFunc("DELETE /v1/roles/{name}", h.admin("DeleteRole", h.deleteRole))
+
+	mux.HandleFunc("POST /v1/groups", h.admin("CreateGroup", h.createGroup))
+	mux.HandleFunc("GET /v1/groups/{id}/members", h.admin("ListGroupMembers", h.listGroupMembers))
+	mux.HandleFunc("PUT /v1/groups/{id}/members/{uid}", h.admin("AddUserToGroup", h.addUserToGroup))
+	mux.HandleFunc("DELETE /v1/groups/{id}/members/{uid}", h.admin("RemoveUserFromGroup", h.removeUserFromGroup))
+
+	mux.HandleFunc("GET /v1/orgs", h.admin("ListOrganizations", h.listOrganizations))
+	mux.HandleFunc("POST /v1/orgs", h.admin("CreateOrganization", h.createOrganization))
+	mux.HandleFunc("GET /v1/orgs/{id}", h.admin("GetOrganization", h.getOrganization))
+	mux.HandleFunc("PUT /v1/users/{id}/org", h.admin("SetUserOrganization", h.setUserOrganization))
+}
+
+type tokens struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// limited takes a token of the client IP from the bucket of method and
+// passes the audit reason and captcha token headers into the context.
+// Requests without a known IP aren't limited, as in the gRPC interceptor.
+func (h *Handler) limited(method string, next http.HandlerFunc) http.HandlerFunc {
+	limit, ok := h.perMethod[method]
+	if !ok {
+		limit = h.def
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := clientinfo.FromContext(r.Context()).IP
+		if ip != "" && !limit.Unlimited() {
+			allowed, wait := h.limiter.Allow(method+":"+ip, limit, time.Now())
+			if !allowed {
+				requestid.Logger(r.Context(), h.log).Info("rate limit exceeded", slog.String("method", method), slog.String("ip", ip))
+
+				w.Header().Set("Retry-After", strconv.FormatInt(int64(math.Ceil(wait.Seconds())), 10))
+				writeError(w, http.StatusTooManyRequests, "too many requests")
+
+				return
+			}
+		}
+
+		ctx := r.Context()
+		if reason := r.Header.Get(audit.ReasonHeader); reason != "" {
+			ctx = audit.WithReason(ctx, reason)
+		}
+		if token := r.Header.Get(captcha.TokenHeader); token != "" {
+			ctx = captcha.WithToken(ctx, token)
+		}
+
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// authenticated lets requests through with an API key or a user access
+// token, putting the caller into the context like the gRPC interceptors.
+func (h *Handler) authenticated(method string, next http.HandlerFunc) http.HandlerFunc {
+	return h.limited(method, func(w http.ResponseWriter, r *http.Request) {
+		c, ok := h.caller(w, r)
+		if !ok {
+			return
+		}
+
+		next(w, r.WithContext(caller.WithCaller(r.Context(), c)))
+	})
+}
+
+// admin lets requests through for admins only: API keys of the admin role and
+// users who are admins now, whatever role their token was issued with.
+func (h *Handler) admin(method string, next http.HandlerFunc) http.HandlerFunc {
+	return h.authenticated(method, func(w http.ResponseWriter, r *http.Request) {
+		if !h.isAdmin(w, r) {
+			return
+		}
+
+		next(w, r)
+	})
+}
+
+// selfOrAdmin lets requests about the user of the {id} path value through
+// for that user and for admins.
+func (h *Handler) selfOrAdmin(method string, next http.HandlerFunc) http.HandlerFunc {
+	return h.authenticated(method, func(w http.ResponseWriter, r *http.Request) {
+		id, ok := pathID(w, r, "id")
+		if !ok {
+			return
+		}
+
+		if c, _ := caller.FromContext(r.Context()); c.UserID != id && !h.isAdmin(w, r) {
+			return
+		}
+
+		next(w, r)
+	})
+}
+
+// user lets requests through with a user access token only, for endpoints
+// about the caller, such as enrolling a second factor.
+func (h *Handler) user(method string, next http.HandlerFunc) http.HandlerFunc {
+	return h.authenticated(method, func(w http.ResponseWriter, r *http.Request) {
+		if c, _ := caller.FromContext(r.Context()); c.UserID == 0 {
+			writeError(w, http.StatusForbidden, "user access token required")
+
+			return
+		}
+
+		next(w, r)
+	})
+}
+
+// caller authenticates the API key or access token of the request.
+func (h *Handler) caller(w http.ResponseWriter, r *http.Request) (caller.Caller, bool) {
+	if key := r.Header.Get(apiKeyHeader); key != "" {
+		k, err := h.auth.AuthenticateAPIKey(r.Context(), key)
+		if err != nil {
+			if errors.Is(err, auth.ErrInvalidAPIKey) {
+				writeError(w, http.StatusUnauthorized, "invalid api key")
+			} else {
+				h.internalError(w, r, err)
+			}
+
+			return caller.Caller{}, false
+		}
+
+		return caller.Caller{AppID: k.AppID, Role: k.Role, APIKeyID: k.ID, OrgID: k.OrgID}, true
+	}
+
+	token, ok := bearerToken(r)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "access token or api key required")
+
+		return caller.Caller{}, false
+	}
+
+	claims, err := h.auth.ValidateToken(r.Context(), token)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidToken) || errors.Is(err, auth.ErrTokenRevoked) || errors.Is(err, auth.ErrTokenBinding) {
+			writeError(w, http.StatusUnauthorized, "invalid access token")
+		} else {
+			h.internalError(w, r, err)
+		}
+
+		return caller.Caller{}, false
+	}
+
+	// Сервисные токены приложений без пользователя
+	if claims.UserID == 0 {
+		writeError(w, http.StatusUnauthorized, "user access token required")
+
+		return caller.Caller{}, false
+	}
+
+	return caller.Caller{UserID: claims.UserID, AppID: claims.AppID, Role: claims.Role, OrgID: claims.OrgID}, true
+}
+
+// isAdmin checks the caller of the request, writing the error if it's not an admin.
+func (h *Handler) isAdmin(w http.ResponseWriter, r *http.Request) bool {
+	c, _ := caller.FromContext(r.Context())
+
+	admin := c.Role == auth.AdminRole
+	if c.UserID != 0 {
+		var err error
+
+		admin, err = h.auth.IsAdmin(r.Context(), c.UserID)
+		if err != nil && !errors.Is(err, auth.ErrUserNotFound) {
+			h.internalError(w, r, err)
+
+			return false
+		}
+	}
+
+	if !admin {
+		requestid.Logger(r.Context(), h.log).Warn("admin endpoint called without admin role",
+			slog.String("path", r.URL.Path), slog.Int64("uid", c.UserID), slog.Int64("key_id", c.APIKeyID),
+		)
+
+		writeError(w, http.StatusForbidden, "admin role required")
+
+		return false
+	}
+
+	return true
+}
+
+// errorStatuses map errors of the service to responses; others are internal errors.
+var errorStatuses = []struct {
+	err     error
+	status  int
+	message string
+}{
+	{auth.ErrInvalidRefreshToken, http.StatusUnauthorized, "invalid refresh token"},
+	{auth.ErrInvalidToken, http.StatusUnauthorized, "invalid access token"},
+	{auth.ErrTokenRevoked, http.StatusUnauthorized, "access token revoked"},
+	{auth.ErrTokenBinding, http.StatusUnauthorized, "access token is bound to another certificate or key"},
+	{auth.ErrUserNotFound, http.StatusNotFound, "user not found"},
+	{storage.ErrUserExists, http.StatusConflict, "user already exists"},
+	{auth.ErrInvalidUsername, http.StatusBadRequest, "invalid username"},
+	{auth.ErrUsernameTaken, http.StatusConflict, "username already taken"},
+	{auth.ErrInvalidAvatarURL, http.StatusBadRequest, "invalid avatar url"},
+	{auth.ErrInvalidPreference, http.StatusBadRequest, "invalid preference"},
+	{auth.ErrSelfMerge, http.StatusBadRequest, "cannot merge user into itself"},
+	{auth.ErrInvalidPhone, http.StatusBadRequest, "invalid phone number"},
+	{auth.ErrInvalidRegistrationMode, http.StatusBadRequest, "invalid registration mode"},
+	{auth.ErrAccountSuspended, http.StatusForbidden, "account is suspended"},
+	{auth.ErrInvalidCode, http.StatusBadRequest, "invalid or expired code"},
+	{auth.ErrCodeRecentlySent, http.StatusTooManyRequests, "code was sent recently, try again later"},
+	{auth.ErrPhoneTaken, http.StatusConflict, "phone already taken"},
+	{auth.ErrMFADisabled, http.StatusNotImplemented, "two-factor authentication is not configured"},
+	{auth.ErrMFAAlreadyEnabled, http.StatusConflict, "two-factor authentication is already enabled"},
+	{auth.ErrMFANotEnabled, http.StatusConflict, "two-factor authentication is not enabled"},
+	{auth.ErrPhoneNotVerified, http.StatusConflict, "phone is not verified"},
+	{auth.ErrReasonRequired, http.StatusBadRequest, "audit reason is required"},
+	{auth.ErrPasskeysDisabled, http.StatusNotImplemented, "passkeys are not configured"},
+	{auth.ErrInvalidPasskey, http.StatusBadRequest, "invalid passkey"},
+	{auth.ErrInvalidCredentials, http.StatusUnauthorized, "invalid credentials"},
+	{auth.ErrPasswordBreached, http.StatusBadRequest, "password appeared in a data breach, choose another one"},
+	{auth.ErrMagicLinksDisabled, http.StatusNotImplemented, "magic links are not configured"},
+	{storage.ErrAppNotFound, http.StatusBadRequest, "unknown app"},
+	{auth.ErrInvalidApp, http.StatusBadRequest, "invalid app"},
+	{auth.ErrInvalidRedirectURI, http.StatusBadRequest, "invalid redirect uri"},
+	{auth.ErrInvalidScope, http.StatusBadRequest, "invalid scope"},
+	{auth.ErrAppExists, http.StatusConflict, "app already exists"},
+	{auth.ErrAppNotFound, http.StatusNotFound, "app not found"},
+	{auth.ErrOrgNotFound, http.StatusBadRequest, "organization not found"},
+	{auth.ErrInvalidOrg, http.StatusBadRequest, "invalid organization name"},
+	{auth.ErrOrgExists, http.StatusConflict, "organization already exists"},
+	{auth.ErrOrgScoped, http.StatusForbidden, "not allowed to callers scoped to an organization"},
+	{auth.ErrWebhookNotFound, http.StatusNotFound, "webhook not found"},
+	{auth.ErrInvalidWebhook, http.StatusBadRequest, "invalid webhook url or events"},
+	{auth.ErrAPIKeyNotFound, http.StatusNotFound, "api key not found"},
+	{auth.ErrInvalidRole, http.StatusBadRequest, "invalid role"},
+	{auth.ErrInvalidPermission, http.StatusBadRequest, "invalid permission"},
+	{auth.ErrRoleExists, http.StatusConflict, "role already exists"},
+	{auth.ErrRoleNotFound, http.StatusNotFound, "role not found"},
+	{auth.ErrRoleInUse, http.StatusConflict, "role is assigned to users or api keys"},
+	{auth.ErrProtectedRole, http.StatusConflict, "role can't be deleted"},
+	{auth.ErrInvalidGroup, http.StatusBadRequest, "invalid group name"},
+	{auth.ErrGroupExists, http.StatusConflict, "group already exists"},
+	{auth.ErrGroupNotFound, http.StatusNotFound, "group not found"},
+	{auth.ErrSessionNotFound, http.StatusNotFound, "session not found"},
+	{auth.ErrInvalidPageToken, http.StatusBadRequest, "invalid page token"},
+	{auth.ErrInvalidMetadata, http.StatusBadRequest, "invalid metadata"},
+	{auth.ErrInvalidQuery, http.StatusBadRequest, "invalid search query"},
+	{auth.ErrAppCallerRequired, http.StatusForbidden, "api key of the app required"},
+}
+
+// fail writes the response for the error of the service.
+func (h *Handler) fail(w http.ResponseWriter, r *http.Request, err error) {
+	// Второй шаг входа ждёт билет, как в ответе Login
+	var mfaErr *auth.MFARequiredError
+	if errors.As(err, &mfaErr) {
+		w.Header().Set(mfaTicketHeader, mfaErr.Ticket)
+		w.Header().Set(mfaMethodsHeader, strings.Join(mfaErr.Methods, ","))
+		writeError(w, http.StatusPreconditionFailed, "second factor required")
+
+		return
+	}
+
+	for _, e := range errorStatuses {
+		if errors.Is(err, e.err) {
+			writeError(w, e.status, e.message)
+
+			return
+		}
+	}
+
+	h.internalError(w, r, err)
+}
+
+func (h *Handler) internalError(w http.ResponseWriter, r *http.Request, err error) {
+	requestid.Logger(r.Context(), h.log).Error("api request failed", slog.String("path", r.URL.Path), sl.Err(err))
+
+	writeError(w, http.StatusInternalServerError, "internal error")
+}
+
+// bearerToken returns the token of the "Authorization: Bearer ..." header.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+
+	return token, true
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json body")
+
+		return false
+	}
+
+	return true
+}
+
+// pathID parses the positive id of the path value name.
+func pathID(w http.ResponseWriter, r *http.Request, name string) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue(name), 10, 64)
+	if err != nil || id <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid "+name)
+
+		return 0, false
+	}
+
+	return id, true
+}
+
+// queryBool parses the optional boolean query parameter name.
+func queryBool(w http.ResponseWriter, r *http.Request, name string) (bool, bool) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return false, true
+	}
+
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid "+name)
+
+		return false, false
+	}
+
+	return b, true
+}
+
+// page parses the page_token and page_size query parameters of list
+// endpoints; zero size means the default of the service.
+func page(w http.ResponseWriter, r *http.Request) (token string, size int, ok bool) {
+	q := r.URL.Query()
+
+	if v := q.Get("page_size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "invalid page size")
+
+			return "", 0, false
+		}
+		size = n
+	}
+
+	return q.Get("page_token"), size, true
+}
+
+// queryID parses the optional id query parameter name; zero if it's absent.
+func queryID(w http.ResponseWriter, r *http.Request, name string) (int64, bool) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return 0, true
+	}
+
+	id, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || id <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid "+name)
+
+		return 0, false
+	}
+
+	return id, true
+}
+
+// queryTime parses the optional RFC 3339 query parameter name.
+func queryTime(w http.ResponseWriter, r *http.Request, name string) (time.Time, bool) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return time.Time{}, true
+	}
+
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid "+name)
+
+		return time.Time{}, false
+	}
+
+	return t, true
+}
+
+// optionalTime returns nil for the zero time, omitted from JSON.
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+
+	return &t
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, code, map[string]string{"error": message})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	_ = json.NewEncoder(w).Encode(v)
+}
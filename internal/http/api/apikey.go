@@ -0,0 +1,112 @@
+package api
+
+import (
+	"net/http"
+	"sso/internal/domain/models"
+	"time"
+)
+
+type apiKey struct {
+	ID         int64      `json:"id"`
+	AppID      int        `json:"app_id"`
+	Name       string     `json:"name"`
+	Role       string     `json:"role"`
+	Prefix     string     `json:"prefix"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+func toAPIKey(k models.APIKey) apiKey {
+	return apiKey{
+		ID:         k.ID,
+		AppID:      k.AppID,
+		Name:       k.Name,
+		Role:       k.Role,
+		Prefix:     k.Prefix,
+		CreatedAt:  k.CreatedAt,
+		LastUsedAt: optionalTime(k.LastUsedAt),
+		RevokedAt:  optionalTime(k.RevokedAt),
+	}
+}
+
+type createAPIKeyRequest struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+type createAPIKeyResponse struct {
+	// Key is shown only once, the storage keeps its hash.
+	Key    string `json:"key"`
+	APIKey apiKey `json:"api_key"`
+}
+
+// createAPIKey issues a key for a machine client of the app of the path.
+func (h *Handler) createAPIKey(w http.ResponseWriter, r *http.Request) {
+	appID, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	var req createAPIKeyRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	if req.Name == "" || req.Role == "" {
+		writeError(w, http.StatusBadRequest, "name and role are required")
+
+		return
+	}
+
+	key, k, err := h.auth.CreateAPIKey(r.Context(), int(appID), req.Name, req.Role)
+	if err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, createAPIKeyResponse{Key: key, APIKey: toAPIKey(k)})
+}
+
+type apiKeysResponse struct {
+	APIKeys []apiKey `json:"api_keys"`
+}
+
+// listAPIKeys returns keys of the app of the path, revoked ones included.
+func (h *Handler) listAPIKeys(w http.ResponseWriter, r *http.Request) {
+	appID, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	keys, err := h.auth.ListAPIKeys(r.Context(), int(appID))
+	if err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	resp := apiKeysResponse{APIKeys: make([]apiKey, 0, len(keys))}
+	for _, k := range keys {
+		resp.APIKeys = append(resp.APIKeys, toAPIKey(k))
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// revokeAPIKey makes the key of the path unusable.
+func (h *Handler) revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	if err := h.auth.RevokeAPIKey(r.Context(), id); err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
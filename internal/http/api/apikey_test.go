@@ -0,0 +1,66 @@
+package api_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sso/internal/services/auth"
+	"sso/ssotest"
+	"testing"
+)
+
+func TestAPIKeysRequireAdmin(t *testing.T) {
+	srv := ssotest.NewServer(t)
+	h := newHandler(t, srv)
+
+	userID := saveUser(t, srv, "user@example.com", "correct-password")
+	adminID := saveUser(t, srv, "admin@example.com", "correct-password")
+	if err := srv.Storage.UpdateRole(context.Background(), adminID, auth.AdminRole); err != nil {
+		t.Fatalf("UpdateRole() error = %v", err)
+	}
+
+	adminKey, _, err := srv.Auth.CreateAPIKey(context.Background(), ssotest.AppID, "admin", auth.AdminRole)
+	if err != nil {
+		t.Fatalf("CreateAPIKey() error = %v", err)
+	}
+	userKey, _, err := srv.Auth.CreateAPIKey(context.Background(), ssotest.AppID, "user", "user")
+	if err != nil {
+		t.Fatalf("CreateAPIKey() error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		apiKey string
+		want   int
+	}{
+		{name: "anonymous", want: http.StatusUnauthorized},
+		{name: "invalid api key", apiKey: "sso_invalid", want: http.StatusUnauthorized},
+		{name: "invalid token", token: "invalid", want: http.StatusUnauthorized},
+		{name: "user api key", apiKey: userKey, want: http.StatusForbidden},
+		{name: "admin api key", apiKey: adminKey, want: http.StatusOK},
+		{name: "user", token: ssotest.MustMintToken(t, ssotest.Claims{UserID: userID, Role: "user"}), want: http.StatusForbidden},
+		// Роль в токене не важна, админ проверяется по базе
+		{name: "user with admin claim", token: ssotest.MustMintToken(t, ssotest.Claims{UserID: userID, Role: auth.AdminRole}), want: http.StatusForbidden},
+		{name: "admin", token: ssotest.MustMintToken(t, ssotest.Claims{UserID: adminID, Role: "user"}), want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/v1/apps/1/api-keys", nil)
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			if tt.apiKey != "" {
+				r.Header.Set("X-Api-Key", tt.apiKey)
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
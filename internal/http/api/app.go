@@ -0,0 +1,156 @@
+package api
+
+import (
+	"net/http"
+	"sso/internal/domain/models"
+	"time"
+)
+
+type app struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	// Secret is returned once, on creation; see rotateAppSecret.
+	Secret                  string     `json:"secret,omitempty"`
+	PreviousSecretExpiresAt *time.Time `json:"previous_secret_expires_at,omitempty"`
+	RedirectURIs            []string   `json:"redirect_uris"`
+	Public                  bool       `json:"public"`
+	Scopes                  []string   `json:"scopes"`
+	OrgID                   int64      `json:"org_id,omitempty"`
+	// TokenTTL is in seconds; zero keeps the global lifetime.
+	TokenTTL    int64     `json:"token_ttl,omitempty"`
+	Audience    string    `json:"audience,omitempty"`
+	CertSubject string    `json:"cert_subject,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func toApp(a models.App) app {
+	return app{
+		ID:                      a.ID,
+		Name:                    a.Name,
+		Secret:                  a.Secret,
+		PreviousSecretExpiresAt: optionalTime(a.PreviousSecretExpiresAt),
+		RedirectURIs:            a.RedirectURIs,
+		Public:                  a.Public,
+		Scopes:                  a.Scopes,
+		OrgID:                   a.OrgID,
+		TokenTTL:                int64(a.TokenTTL / time.Second),
+		Audience:                a.Audience,
+		CertSubject:             a.CertSubject,
+		CreatedAt:               a.CreatedAt,
+	}
+}
+
+// model returns the app of the request; the secret, creation time and
+// previous secret are set by the service only.
+func (a app) model() models.App {
+	return models.App{
+		ID:           a.ID,
+		Name:         a.Name,
+		RedirectURIs: a.RedirectURIs,
+		Public:       a.Public,
+		Scopes:       a.Scopes,
+		OrgID:        a.OrgID,
+		TokenTTL:     time.Duration(a.TokenTTL) * time.Second,
+		Audience:     a.Audience,
+		CertSubject:  a.CertSubject,
+	}
+}
+
+type appsResponse struct {
+	Apps []app `json:"apps"`
+}
+
+// listApps returns apps visible to the caller, without secrets.
+func (h *Handler) listApps(w http.ResponseWriter, r *http.Request) {
+	apps, err := h.auth.ListApps(r.Context())
+	if err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	resp := appsResponse{Apps: make([]app, 0, len(apps))}
+	for _, a := range apps {
+		resp.Apps = append(resp.Apps, toApp(a))
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// createApp registers the app of the body and returns it with the generated secret.
+func (h *Handler) createApp(w http.ResponseWriter, r *http.Request) {
+	var req app
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	created, err := h.auth.CreateApp(r.Context(), req.model())
+	if err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, toApp(created))
+}
+
+// updateApp replaces the settings of the app of the path with the body.
+func (h *Handler) updateApp(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	var req app
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	req.ID = int(id)
+
+	if err := h.auth.UpdateApp(r.Context(), req.model()); err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteApp removes the app of the path with its API keys.
+func (h *Handler) deleteApp(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	if err := h.auth.DeleteApp(r.Context(), int(id)); err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type secretResponse struct {
+	Secret string `json:"secret"`
+}
+
+// rotateAppSecret generates a new secret of the app of the path; the
+// previous one verifies tokens for the grace period.
+func (h *Handler) rotateAppSecret(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	secret, err := h.auth.RotateAppSecret(r.Context(), int(id))
+	if err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, secretResponse{Secret: secret})
+}
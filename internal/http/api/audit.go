@@ -0,0 +1,117 @@
+package api
+
+import (
+	"net/http"
+	"sso/internal/domain/models"
+	"time"
+)
+
+type auditEvent struct {
+	ID            int64             `json:"id"`
+	Action        string            `json:"action"`
+	ActorUserID   int64             `json:"actor_user_id,omitempty"`
+	ActorAppID    int               `json:"actor_app_id,omitempty"`
+	ActorAPIKeyID int64             `json:"actor_api_key_id,omitempty"`
+	TargetUserID  int64             `json:"target_user_id,omitempty"`
+	TargetAppID   int               `json:"target_app_id,omitempty"`
+	Reason        string            `json:"reason,omitempty"`
+	Details       map[string]string `json:"details,omitempty"`
+	CreatedAt     time.Time         `json:"created_at"`
+}
+
+type auditEventsResponse struct {
+	Events        []auditEvent `json:"events"`
+	NextPageToken string       `json:"next_page_token,omitempty"`
+}
+
+func toAuditEvents(events []models.AuditEvent, next string) auditEventsResponse {
+	resp := auditEventsResponse{Events: make([]auditEvent, 0, len(events)), NextPageToken: next}
+	for _, e := range events {
+		resp.Events = append(resp.Events, auditEvent{
+			ID:            e.ID,
+			Action:        e.Action,
+			ActorUserID:   e.ActorUserID,
+			ActorAppID:    e.ActorAppID,
+			ActorAPIKeyID: e.ActorAPIKeyID,
+			TargetUserID:  e.TargetUserID,
+			TargetAppID:   e.TargetAppID,
+			Reason:        e.Reason,
+			Details:       e.Details,
+			CreatedAt:     e.CreatedAt,
+		})
+	}
+
+	return resp
+}
+
+// auditFilter parses the action, actor_user_id, target_user_id, app_id, since
+// and until query parameters.
+func auditFilter(w http.ResponseWriter, r *http.Request) (models.AuditFilter, bool) {
+	filter := models.AuditFilter{Action: r.URL.Query().Get("action")}
+
+	var ok bool
+	if filter.ActorUserID, ok = queryID(w, r, "actor_user_id"); !ok {
+		return models.AuditFilter{}, false
+	}
+	if filter.TargetUserID, ok = queryID(w, r, "target_user_id"); !ok {
+		return models.AuditFilter{}, false
+	}
+	appID, ok := queryID(w, r, "app_id")
+	if !ok {
+		return models.AuditFilter{}, false
+	}
+	filter.AppID = int(appID)
+	if filter.Since, ok = queryTime(w, r, "since"); !ok {
+		return models.AuditFilter{}, false
+	}
+	if filter.Until, ok = queryTime(w, r, "until"); !ok {
+		return models.AuditFilter{}, false
+	}
+
+	return filter, true
+}
+
+// queryAuditLog returns audit events, newest first, by the filter of the query.
+func (h *Handler) queryAuditLog(w http.ResponseWriter, r *http.Request) {
+	filter, ok := auditFilter(w, r)
+	if !ok {
+		return
+	}
+
+	pageToken, size, ok := page(w, r)
+	if !ok {
+		return
+	}
+
+	events, next, err := h.auth.QueryAuditLog(r.Context(), filter, pageToken, size)
+	if err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, toAuditEvents(events, next))
+}
+
+// listAppAuditEvents is queryAuditLog for the app of the calling API key,
+// limited to events of the app whatever app_id is.
+func (h *Handler) listAppAuditEvents(w http.ResponseWriter, r *http.Request) {
+	filter, ok := auditFilter(w, r)
+	if !ok {
+		return
+	}
+
+	pageToken, size, ok := page(w, r)
+	if !ok {
+		return
+	}
+
+	events, next, err := h.auth.ListAppAuditEvents(r.Context(), filter, pageToken, size)
+	if err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, toAuditEvents(events, next))
+}
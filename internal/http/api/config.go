@@ -0,0 +1,14 @@
+package api
+
+import "net/http"
+
+// getEffectiveConfig returns the configuration the server was started with,
+// secrets redacted.
+func (h *Handler) getEffectiveConfig(w http.ResponseWriter, r *http.Request) {
+	cfg := h.auth.EffectiveConfig()
+	if cfg == nil {
+		cfg = map[string]any{}
+	}
+
+	writeJSON(w, http.StatusOK, cfg)
+}
@@ -0,0 +1,40 @@
+package api_test
+
+import (
+	"context"
+	"net/http"
+	"sso/internal/services/auth"
+	"sso/ssotest"
+	"testing"
+)
+
+func TestEffectiveConfig(t *testing.T) {
+	srv := ssotest.NewServer(t, func(o *auth.Options) {
+		o.Config = map[string]any{"env": "test", "sms_token": "[REDACTED]"}
+	})
+	h := newHandler(t, srv)
+
+	userID := saveUser(t, srv, "user@example.com", "correct-password")
+	adminID := saveUser(t, srv, "admin@example.com", "correct-password")
+	if err := srv.Storage.UpdateRole(context.Background(), adminID, auth.AdminRole); err != nil {
+		t.Fatalf("UpdateRole() error = %v", err)
+	}
+
+	admin := ssotest.MustMintToken(t, ssotest.Claims{UserID: adminID, Role: auth.AdminRole})
+	user := ssotest.MustMintToken(t, ssotest.Claims{UserID: userID, Role: "user"})
+
+	if code := do(t, h, http.MethodGet, "/v1/config", "", nil, nil); code != http.StatusUnauthorized {
+		t.Errorf("anonymous: status = %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code := do(t, h, http.MethodGet, "/v1/config", user, nil, nil); code != http.StatusForbidden {
+		t.Errorf("user: status = %d, want %d", code, http.StatusForbidden)
+	}
+
+	var cfg map[string]any
+	if code := do(t, h, http.MethodGet, "/v1/config", admin, nil, &cfg); code != http.StatusOK {
+		t.Fatalf("admin: status = %d, want %d", code, http.StatusOK)
+	}
+	if cfg["env"] != "test" || cfg["sms_token"] != "[REDACTED]" {
+		t.Errorf("config = %v", cfg)
+	}
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"net/http"
+	"time"
+)
+
+type createGroupRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type createGroupResponse struct {
+	ID int64 `json:"id"`
+}
+
+// createGroup creates the group of the body.
+func (h *Handler) createGroup(w http.ResponseWriter, r *http.Request) {
+	var req createGroupRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	id, err := h.auth.CreateGroup(r.Context(), req.Name, req.Description)
+	if err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, createGroupResponse{ID: id})
+}
+
+type groupMember struct {
+	UserID  int64     `json:"user_id"`
+	Email   string    `json:"email,omitempty"`
+	AddedAt time.Time `json:"added_at"`
+}
+
+type groupMembersResponse struct {
+	Members       []groupMember `json:"members"`
+	NextPageToken string        `json:"next_page_token,omitempty"`
+}
+
+// listGroupMembers returns members of the group of the path, newest users first.
+func (h *Handler) listGroupMembers(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	pageToken, size, ok := page(w, r)
+	if !ok {
+		return
+	}
+
+	members, next, err := h.auth.ListGroupMembers(r.Context(), id, pageToken, size)
+	if err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	resp := groupMembersResponse{Members: make([]groupMember, 0, len(members)), NextPageToken: next}
+	for _, m := range members {
+		resp.Members = append(resp.Members, groupMember{UserID: m.UserID, Email: m.Email, AddedAt: m.AddedAt})
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// addUserToGroup adds the user {uid} to the group of the path.
+func (h *Handler) addUserToGroup(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	userID, ok := pathID(w, r, "uid")
+	if !ok {
+		return
+	}
+
+	if err := h.auth.AddUserToGroup(r.Context(), id, userID); err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// removeUserFromGroup removes the user {uid} from the group of the path.
+func (h *Handler) removeUserFromGroup(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	userID, ok := pathID(w, r, "uid")
+	if !ok {
+		return
+	}
+
+	if err := h.auth.RemoveUserFromGroup(r.Context(), id, userID); err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
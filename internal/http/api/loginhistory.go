@@ -0,0 +1,59 @@
+package api
+
+import (
+	"net/http"
+	"time"
+)
+
+type loginAttempt struct {
+	ID        int64     `json:"id"`
+	AppID     int       `json:"app_id"`
+	Login     string    `json:"login,omitempty"`
+	Method    string    `json:"method"`
+	Result    string    `json:"result"`
+	IP        string    `json:"ip,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type loginHistoryResponse struct {
+	Logins        []loginAttempt `json:"logins"`
+	NextPageToken string         `json:"next_page_token,omitempty"`
+}
+
+// loginHistory returns login attempts of the user of the path, newest first,
+// by pages of page_size after page_token.
+func (h *Handler) loginHistory(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	pageToken, size, ok := page(w, r)
+	if !ok {
+		return
+	}
+
+	attempts, next, err := h.auth.GetLoginHistory(r.Context(), id, pageToken, size)
+	if err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	resp := loginHistoryResponse{Logins: make([]loginAttempt, 0, len(attempts)), NextPageToken: next}
+	for _, a := range attempts {
+		resp.Logins = append(resp.Logins, loginAttempt{
+			ID:        a.ID,
+			AppID:     a.AppID,
+			Login:     a.Login,
+			Method:    a.Method,
+			Result:    a.Result,
+			IP:        a.IP,
+			UserAgent: a.UserAgent,
+			CreatedAt: a.CreatedAt,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
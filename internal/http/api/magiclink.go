@@ -0,0 +1,67 @@
+package api
+
+import (
+	"net/http"
+)
+
+type magicLinkRequest struct {
+	Email string `json:"email"`
+	AppID int    `json:"app_id"`
+}
+
+// requestMagicLink emails a login link. It answers the same for unknown
+// emails, see Auth.RequestMagicLink.
+func (h *Handler) requestMagicLink(w http.ResponseWriter, r *http.Request) {
+	var req magicLinkRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	if req.Email == "" {
+		writeError(w, http.StatusBadRequest, "email is required")
+
+		return
+	}
+	if req.AppID <= 0 {
+		writeError(w, http.StatusBadRequest, "app_id is required")
+
+		return
+	}
+
+	if err := h.auth.RequestMagicLink(r.Context(), req.Email, req.AppID); err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+type magicLinkLoginRequest struct {
+	// Token is the token query parameter of the link.
+	Token string `json:"token"`
+}
+
+// loginWithMagicLink exchanges the token of the link for tokens. Users with
+// two-factor authentication get the ticket for the second step instead.
+func (h *Handler) loginWithMagicLink(w http.ResponseWriter, r *http.Request) {
+	var req magicLinkLoginRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	if req.Token == "" {
+		writeError(w, http.StatusBadRequest, "token is required")
+
+		return
+	}
+
+	token, refreshToken, err := h.auth.LoginWithMagicLink(r.Context(), req.Token)
+	if err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tokens{Token: token, RefreshToken: refreshToken})
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sso/internal/lib/caller"
+)
+
+// metadataApp returns the app whose metadata the caller works with: the app
+// of the calling API key, or the app_id query parameter for admins.
+func (h *Handler) metadataApp(w http.ResponseWriter, r *http.Request) (int, bool) {
+	if c, _ := caller.FromContext(r.Context()); c.UserID == 0 && c.AppID != 0 {
+		return c.AppID, true
+	}
+
+	if !h.isAdmin(w, r) {
+		return 0, false
+	}
+
+	appID, ok := queryID(w, r, "app_id")
+	if !ok {
+		return 0, false
+	}
+	if appID == 0 {
+		writeError(w, http.StatusBadRequest, "app_id is required")
+
+		return 0, false
+	}
+
+	return int(appID), true
+}
+
+// getUserMetadata returns the JSON object the app attached to the user.
+func (h *Handler) getUserMetadata(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	appID, ok := h.metadataApp(w, r)
+	if !ok {
+		return
+	}
+
+	data, err := h.auth.GetUserMetadata(r.Context(), id, appID)
+	if err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, data)
+}
+
+// setUserMetadata replaces the metadata of the user with the JSON object of
+// the body.
+func (h *Handler) setUserMetadata(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	appID, ok := h.metadataApp(w, r)
+	if !ok {
+		return
+	}
+
+	var data json.RawMessage
+	if !readJSON(w, r, &data) {
+		return
+	}
+
+	if err := h.auth.SetUserMetadata(r.Context(), id, appID, data); err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,217 @@
+package api
+
+import (
+	"net/http"
+	"sso/internal/lib/caller"
+)
+
+type enrollTOTPResponse struct {
+	Secret string `json:"secret"`
+	// URI is the otpauth:// URI to show as a QR code.
+	URI string `json:"uri"`
+}
+
+// enrollTOTP generates an authenticator secret for the caller, see Auth.EnrollTOTP.
+func (h *Handler) enrollTOTP(w http.ResponseWriter, r *http.Request) {
+	c, _ := caller.FromContext(r.Context())
+
+	secret, uri, err := h.auth.EnrollTOTP(r.Context(), c.UserID)
+	if err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, enrollTOTPResponse{Secret: secret, URI: uri})
+}
+
+type codeRequest struct {
+	Code string `json:"code"`
+}
+
+type recoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// confirmTOTP enables the second factor of the caller, returning recovery
+// codes to show once.
+func (h *Handler) confirmTOTP(w http.ResponseWriter, r *http.Request) {
+	var req codeRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	if req.Code == "" {
+		writeError(w, http.StatusBadRequest, "code is required")
+
+		return
+	}
+
+	c, _ := caller.FromContext(r.Context())
+
+	codes, err := h.auth.ConfirmTOTP(r.Context(), c.UserID, req.Code)
+	if err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, recoveryCodesResponse{RecoveryCodes: codes})
+}
+
+type ticketRequest struct {
+	// Ticket is the X-Mfa-Ticket header of the Login response.
+	Ticket string `json:"ticket"`
+	Code   string `json:"code"`
+}
+
+// verifyTOTP is the second login step, exchanging the ticket and a code of
+// the authenticator app for tokens.
+func (h *Handler) verifyTOTP(w http.ResponseWriter, r *http.Request) {
+	var req ticketRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	if req.Ticket == "" || req.Code == "" {
+		writeError(w, http.StatusBadRequest, "ticket and code are required")
+
+		return
+	}
+
+	token, refreshToken, err := h.auth.VerifyTOTP(r.Context(), req.Ticket, req.Code)
+	if err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tokens{Token: token, RefreshToken: refreshToken})
+}
+
+type smsMFARequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+// setSMSMFA turns codes texted to the verified phone of the caller on or off
+// as the second factor.
+func (h *Handler) setSMSMFA(w http.ResponseWriter, r *http.Request) {
+	var req smsMFARequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	c, _ := caller.FromContext(r.Context())
+
+	if err := h.auth.SetSMSMFA(r.Context(), c.UserID, req.Enabled); err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// verifySMSCode is the second login step with the code texted on login.
+func (h *Handler) verifySMSCode(w http.ResponseWriter, r *http.Request) {
+	var req ticketRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	if req.Ticket == "" || req.Code == "" {
+		writeError(w, http.StatusBadRequest, "ticket and code are required")
+
+		return
+	}
+
+	token, refreshToken, err := h.auth.VerifySMSCode(r.Context(), req.Ticket, req.Code)
+	if err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tokens{Token: token, RefreshToken: refreshToken})
+}
+
+// regenerateRecoveryCodes replaces the recovery codes of the caller with new
+// ones to show once.
+func (h *Handler) regenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	c, _ := caller.FromContext(r.Context())
+
+	codes, err := h.auth.RegenerateRecoveryCodes(r.Context(), c.UserID)
+	if err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, recoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// verifyRecoveryCode is the second login step with one of the recovery codes
+// instead of the authenticator app.
+func (h *Handler) verifyRecoveryCode(w http.ResponseWriter, r *http.Request) {
+	var req ticketRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	if req.Ticket == "" || req.Code == "" {
+		writeError(w, http.StatusBadRequest, "ticket and code are required")
+
+		return
+	}
+
+	token, refreshToken, err := h.auth.VerifyRecoveryCode(r.Context(), req.Ticket, req.Code)
+	if err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tokens{Token: token, RefreshToken: refreshToken})
+}
+
+type mfaStatusResponse struct {
+	TOTP bool `json:"totp"`
+	// TOTPPending is an authenticator enrolled but not confirmed yet.
+	TOTPPending bool `json:"totp_pending"`
+	SMS         bool `json:"sms"`
+}
+
+// mfaStatus returns the second factors of the user of the path, for support
+// to see before resetMFA.
+func (h *Handler) mfaStatus(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	status, err := h.auth.MFAStatus(r.Context(), id)
+	if err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, mfaStatusResponse{TOTP: status.TOTP, TOTPPending: status.TOTPPending, SMS: status.SMS})
+}
+
+// resetMFA removes the second factors of the user of the path. The evidence
+// of the user's identity goes in the audit reason header, which is required.
+func (h *Handler) resetMFA(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	if err := h.auth.ResetMFA(r.Context(), id); err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,314 @@
+package api_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sso/internal/http/api"
+	"sso/internal/lib/passhash"
+	"sso/internal/lib/ratelimit"
+	"sso/internal/lib/totp"
+	"sso/internal/services/auth"
+	"sso/ssotest"
+	"testing"
+	"time"
+)
+
+// newHandler serves the api of srv without rate limits.
+func newHandler(t *testing.T, srv *ssotest.Server) http.Handler {
+	t.Helper()
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	mux := http.NewServeMux()
+	api.New(log, srv.Auth, ratelimit.New(), ratelimit.Limit{}, nil).Register(mux)
+
+	return mux
+}
+
+// saveUser creates a user with the password and returns the id.
+func saveUser(t *testing.T, srv *ssotest.Server, email string, password string) int64 {
+	t.Helper()
+
+	hasher, err := passhash.New(passhash.Bcrypt, passhash.Argon2Params{}, nil)
+	if err != nil {
+		t.Fatalf("passhash.New() error = %v", err)
+	}
+	hash, err := hasher.Hash(password)
+	if err != nil {
+		t.Fatalf("Hash() error = %v", err)
+	}
+
+	id, err := srv.Storage.SaveUser(context.Background(), email, hash, "user")
+	if err != nil {
+		t.Fatalf("SaveUser() error = %v", err)
+	}
+
+	return id
+}
+
+// newRequest creates the request with the body as JSON and the access token, if set.
+func newRequest(t *testing.T, method string, path string, token string, body any) *http.Request {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatalf("encode body: %v", err)
+		}
+	}
+
+	r := httptest.NewRequest(method, path, &buf)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return r
+}
+
+// serve records the response of h to r.
+func serve(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	return w
+}
+
+// do sends the request with the body as JSON and decodes the response into out, if set.
+func do(t *testing.T, h http.Handler, method string, path string, token string, body any, out any) int {
+	t.Helper()
+
+	w := serve(h, newRequest(t, method, path, token, body))
+
+	if out != nil && w.Code < 300 {
+		if err := json.NewDecoder(w.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: decode response: %v", method, path, err)
+		}
+	}
+
+	return w.Code
+}
+
+func TestTOTPLogin(t *testing.T) {
+	srv := ssotest.NewServer(t)
+	h := newHandler(t, srv)
+
+	saveUser(t, srv, "user@example.com", "correct-password")
+
+	token, _, err := srv.Auth.Login(context.Background(), "user@example.com", "correct-password", ssotest.AppID)
+	if err != nil {
+		t.Fatalf("Login() error = %v", err)
+	}
+
+	var enrolled struct {
+		Secret string `json:"secret"`
+	}
+	if code := do(t, h, http.MethodPost, "/v1/me/mfa/totp", token, nil, &enrolled); code != http.StatusOK {
+		t.Fatalf("enroll: status = %d, want %d", code, http.StatusOK)
+	}
+
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(enrolled.Secret)
+	if err != nil {
+		t.Fatalf("decode secret: %v", err)
+	}
+	step := totp.Step(time.Now())
+
+	var confirmed struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	if code := do(t, h, http.MethodPost, "/v1/me/mfa/totp/confirm", token, map[string]string{"code": totp.Code(secret, step)}, &confirmed); code != http.StatusOK {
+		t.Fatalf("confirm: status = %d, want %d", code, http.StatusOK)
+	}
+	if len(confirmed.RecoveryCodes) == 0 {
+		t.Fatal("confirm: no recovery codes")
+	}
+
+	// Тот же шаг уже использован, поэтому у каждой попытки свой билет и следующий код
+	login := func() string {
+		_, _, err := srv.Auth.Login(context.Background(), "user@example.com", "correct-password", ssotest.AppID)
+
+		var mfaErr *auth.MFARequiredError
+		if !errors.As(err, &mfaErr) {
+			t.Fatalf("Login() error = %v, want MFARequiredError", err)
+		}
+
+		return mfaErr.Ticket
+	}
+
+	tests := []struct {
+		name   string
+		ticket string
+		code   string
+		want   int
+	}{
+		{name: "wrong code", ticket: login(), code: "000000", want: http.StatusBadRequest},
+		{name: "no ticket", code: totp.Code(secret, step+1), want: http.StatusBadRequest},
+		{name: "unknown ticket", ticket: "unknown", code: totp.Code(secret, step+1), want: http.StatusBadRequest},
+		{name: "valid", ticket: login(), code: totp.Code(secret, step+1), want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tokens struct {
+				Token        string `json:"token"`
+				RefreshToken string `json:"refresh_token"`
+			}
+
+			code := do(t, h, http.MethodPost, "/v1/mfa/totp", "", map[string]string{"ticket": tt.ticket, "code": tt.code}, &tokens)
+			if code != tt.want {
+				t.Fatalf("status = %d, want %d", code, tt.want)
+			}
+			if tt.want == http.StatusOK && (tokens.Token == "" || tokens.RefreshToken == "") {
+				t.Errorf("tokens = %+v, want both", tokens)
+			}
+		})
+	}
+
+	// Код восстановления одноразовый
+	recovery := confirmed.RecoveryCodes[0]
+	for i, want := range []int{http.StatusOK, http.StatusBadRequest} {
+		code := do(t, h, http.MethodPost, "/v1/mfa/recovery", "", map[string]string{"ticket": login(), "code": recovery}, nil)
+		if code != want {
+			t.Errorf("recovery attempt %d: status = %d, want %d", i+1, code, want)
+		}
+	}
+}
+
+func TestSMSLogin(t *testing.T) {
+	const phone = "+14155550100"
+
+	srv := ssotest.NewServer(t)
+	h := newHandler(t, srv)
+
+	userID := saveUser(t, srv, "user@example.com", "correct-password")
+	token := ssotest.MustMintToken(t, ssotest.Claims{UserID: userID, Role: "user"})
+
+	if code := do(t, h, http.MethodPut, "/v1/me/mfa/sms", token, map[string]bool{"enabled": true}, nil); code != http.StatusConflict {
+		t.Errorf("enable without phone: status = %d, want %d", code, http.StatusConflict)
+	}
+
+	if code := do(t, h, http.MethodPost, "/v1/me/phone", token, map[string]string{"phone": phone}, nil); code != http.StatusAccepted {
+		t.Fatalf("request verification: status = %d, want %d", code, http.StatusAccepted)
+	}
+	if code := do(t, h, http.MethodPost, "/v1/me/phone/verify", token, map[string]string{"code": lastCode(t, srv, phone)}, nil); code != http.StatusNoContent {
+		t.Fatalf("verify phone: status = %d, want %d", code, http.StatusNoContent)
+	}
+	if code := do(t, h, http.MethodPut, "/v1/me/mfa/sms", token, map[string]bool{"enabled": true}, nil); code != http.StatusNoContent {
+		t.Fatalf("enable: status = %d, want %d", code, http.StatusNoContent)
+	}
+
+	// Код по SMS отправляется при входе и действует для следующих билетов
+	login := func() string {
+		_, _, err := srv.Auth.Login(context.Background(), "user@example.com", "correct-password", ssotest.AppID)
+
+		var mfaErr *auth.MFARequiredError
+		if !errors.As(err, &mfaErr) {
+			t.Fatalf("Login() error = %v, want MFARequiredError", err)
+		}
+
+		return mfaErr.Ticket
+	}
+	ticket := login()
+	smsCode := lastCode(t, srv, phone)
+
+	tests := []struct {
+		name   string
+		ticket string
+		code   string
+		want   int
+	}{
+		{name: "no ticket", code: smsCode, want: http.StatusBadRequest},
+		{name: "unknown ticket", ticket: "unknown", code: smsCode, want: http.StatusBadRequest},
+		{name: "wrong code", ticket: ticket, code: "000000", want: http.StatusBadRequest},
+		{name: "valid", ticket: login(), code: smsCode, want: http.StatusOK},
+		{name: "code used", ticket: login(), code: smsCode, want: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code := do(t, h, http.MethodPost, "/v1/mfa/sms", "", map[string]string{"ticket": tt.ticket, "code": tt.code}, nil)
+			if code != tt.want {
+				t.Errorf("status = %d, want %d", code, tt.want)
+			}
+		})
+	}
+}
+
+func TestResetMFA(t *testing.T) {
+	srv := ssotest.NewServer(t)
+	h := newHandler(t, srv)
+
+	userID := saveUser(t, srv, "user@example.com", "correct-password")
+	adminID := saveUser(t, srv, "admin@example.com", "correct-password")
+	if err := srv.Storage.UpdateRole(context.Background(), adminID, auth.AdminRole); err != nil {
+		t.Fatalf("UpdateRole() error = %v", err)
+	}
+
+	admin := ssotest.MustMintToken(t, ssotest.Claims{UserID: adminID, Role: auth.AdminRole})
+	user := ssotest.MustMintToken(t, ssotest.Claims{UserID: userID, Role: "user"})
+
+	secret, _, err := srv.Auth.EnrollTOTP(context.Background(), userID)
+	if err != nil {
+		t.Fatalf("EnrollTOTP() error = %v", err)
+	}
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatalf("decode secret: %v", err)
+	}
+	if _, err := srv.Auth.ConfirmTOTP(context.Background(), userID, totp.Code(key, totp.Step(time.Now()))); err != nil {
+		t.Fatalf("ConfirmTOTP() error = %v", err)
+	}
+
+	path := fmt.Sprintf("/v1/users/%d/mfa", userID)
+
+	totpEnabled := func() bool {
+		var resp struct {
+			TOTP bool `json:"totp"`
+		}
+		if code := do(t, h, http.MethodGet, path, admin, nil, &resp); code != http.StatusOK {
+			t.Fatalf("status: status = %d, want %d", code, http.StatusOK)
+		}
+
+		return resp.TOTP
+	}
+
+	if !totpEnabled() {
+		t.Fatal("totp = false before reset, want true")
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		reason string
+		want   int
+	}{
+		{name: "user", token: user, reason: "ticket 42", want: http.StatusForbidden},
+		// Поддержка указывает, чем подтверждена личность пользователя
+		{name: "no reason", token: admin, want: http.StatusBadRequest},
+		{name: "admin", token: admin, reason: "ticket 42", want: http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRequest(t, http.MethodDelete, path, tt.token, nil)
+			if tt.reason != "" {
+				r.Header.Set("X-Audit-Reason", tt.reason)
+			}
+
+			if w := serve(h, r); w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+
+	if totpEnabled() {
+		t.Error("totp = true after reset, want false")
+	}
+}
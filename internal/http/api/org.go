@@ -0,0 +1,115 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"sso/internal/domain/models"
+	"sso/internal/services/auth"
+	"time"
+)
+
+type organization struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func toOrganization(o models.Organization) organization {
+	return organization{ID: o.ID, Name: o.Name, CreatedAt: o.CreatedAt}
+}
+
+type organizationsResponse struct {
+	Organizations []organization `json:"organizations"`
+}
+
+// listOrganizations returns all organizations ordered by name.
+func (h *Handler) listOrganizations(w http.ResponseWriter, r *http.Request) {
+	orgs, err := h.auth.ListOrganizations(r.Context())
+	if err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	resp := organizationsResponse{Organizations: make([]organization, 0, len(orgs))}
+	for _, o := range orgs {
+		resp.Organizations = append(resp.Organizations, toOrganization(o))
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// getOrganization returns the organization of the path.
+func (h *Handler) getOrganization(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	org, err := h.auth.GetOrganization(r.Context(), id)
+	if err != nil {
+		// Организация из пути, а не из тела, поэтому 404
+		if errors.Is(err, auth.ErrOrgNotFound) {
+			writeError(w, http.StatusNotFound, "organization not found")
+
+			return
+		}
+
+		h.fail(w, r, err)
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, toOrganization(org))
+}
+
+type createOrganizationRequest struct {
+	Name string `json:"name"`
+}
+
+type createOrganizationResponse struct {
+	ID int64 `json:"id"`
+}
+
+// createOrganization creates the organization of the body.
+func (h *Handler) createOrganization(w http.ResponseWriter, r *http.Request) {
+	var req createOrganizationRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	id, err := h.auth.CreateOrganization(r.Context(), req.Name)
+	if err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, createOrganizationResponse{ID: id})
+}
+
+type setUserOrganizationRequest struct {
+	// OrgID is zero to move the user back to the platform.
+	OrgID int64 `json:"org_id"`
+}
+
+// setUserOrganization moves the user of the path to the organization of the body.
+func (h *Handler) setUserOrganization(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	var req setUserOrganizationRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	if err := h.auth.SetUserOrganization(r.Context(), id, req.OrgID); err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,68 @@
+package api_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sso/internal/services/auth"
+	"sso/ssotest"
+	"testing"
+)
+
+func TestOrganizations(t *testing.T) {
+	srv := ssotest.NewServer(t)
+	h := newHandler(t, srv)
+
+	userID := saveUser(t, srv, "user@example.com", "correct-password")
+	adminID := saveUser(t, srv, "admin@example.com", "correct-password")
+	if err := srv.Storage.UpdateRole(context.Background(), adminID, auth.AdminRole); err != nil {
+		t.Fatalf("UpdateRole() error = %v", err)
+	}
+
+	admin := ssotest.MustMintToken(t, ssotest.Claims{UserID: adminID, Role: auth.AdminRole})
+	user := ssotest.MustMintToken(t, ssotest.Claims{UserID: userID, Role: "user"})
+
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	if code := do(t, h, http.MethodPost, "/v1/orgs", admin, map[string]string{"name": "Acme"}, &created); code != http.StatusCreated {
+		t.Fatalf("create: status = %d, want %d", code, http.StatusCreated)
+	}
+
+	userPath := fmt.Sprintf("/v1/users/%d/org", userID)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		body   any
+		want   int
+	}{
+		{name: "create by user", method: http.MethodPost, path: "/v1/orgs", token: user, body: map[string]string{"name": "Other"}, want: http.StatusForbidden},
+		{name: "create duplicate", method: http.MethodPost, path: "/v1/orgs", token: admin, body: map[string]string{"name": "Acme"}, want: http.StatusConflict},
+		{name: "create without name", method: http.MethodPost, path: "/v1/orgs", token: admin, body: map[string]string{}, want: http.StatusBadRequest},
+		{name: "get", method: http.MethodGet, path: fmt.Sprintf("/v1/orgs/%d", created.ID), token: admin, want: http.StatusOK},
+		{name: "get unknown", method: http.MethodGet, path: "/v1/orgs/42", token: admin, want: http.StatusNotFound},
+		{name: "list", method: http.MethodGet, path: "/v1/orgs", token: admin, want: http.StatusOK},
+		{name: "move user by user", method: http.MethodPut, path: userPath, token: user, body: map[string]int64{"org_id": created.ID}, want: http.StatusForbidden},
+		{name: "move user to unknown org", method: http.MethodPut, path: userPath, token: admin, body: map[string]int64{"org_id": 42}, want: http.StatusBadRequest},
+		{name: "move user", method: http.MethodPut, path: userPath, token: admin, body: map[string]int64{"org_id": created.ID}, want: http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := do(t, h, tt.method, tt.path, tt.token, tt.body, nil); code != tt.want {
+				t.Errorf("status = %d, want %d", code, tt.want)
+			}
+		})
+	}
+
+	u, err := srv.Auth.GetUser(context.Background(), userID, "")
+	if err != nil {
+		t.Fatalf("GetUser() error = %v", err)
+	}
+	if u.OrgID != created.ID {
+		t.Errorf("OrgID = %d, want %d", u.OrgID, created.ID)
+	}
+}
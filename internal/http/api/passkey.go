@@ -0,0 +1,127 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"sso/internal/lib/caller"
+	"strings"
+)
+
+// base64URL is binary WebAuthn data, sent by browsers as ArrayBuffers, in
+// base64url with or without padding.
+type base64URL []byte
+
+func (b *base64URL) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return err
+	}
+
+	*b = decoded
+
+	return nil
+}
+
+type challengeResponse struct {
+	Challenge string `json:"challenge"`
+}
+
+// beginRegisterPasskey returns a challenge for navigator.credentials.create().
+func (h *Handler) beginRegisterPasskey(w http.ResponseWriter, r *http.Request) {
+	c, _ := caller.FromContext(r.Context())
+
+	challenge, err := h.auth.BeginRegisterPasskey(r.Context(), c.UserID)
+	if err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, challengeResponse{Challenge: challenge})
+}
+
+type registerPasskeyRequest struct {
+	ClientDataJSON    base64URL `json:"client_data_json"`
+	AttestationObject base64URL `json:"attestation_object"`
+}
+
+// finishRegisterPasskey adds the passkey created by the authenticator to the
+// account of the caller.
+func (h *Handler) finishRegisterPasskey(w http.ResponseWriter, r *http.Request) {
+	var req registerPasskeyRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	if len(req.ClientDataJSON) == 0 || len(req.AttestationObject) == 0 {
+		writeError(w, http.StatusBadRequest, "client_data_json and attestation_object are required")
+
+		return
+	}
+
+	c, _ := caller.FromContext(r.Context())
+
+	if err := h.auth.FinishRegisterPasskey(r.Context(), c.UserID, req.ClientDataJSON, req.AttestationObject); err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// beginLoginPasskey returns a challenge for navigator.credentials.get().
+func (h *Handler) beginLoginPasskey(w http.ResponseWriter, r *http.Request) {
+	challenge, err := h.auth.BeginLoginPasskey(r.Context())
+	if err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, challengeResponse{Challenge: challenge})
+}
+
+type loginPasskeyRequest struct {
+	CredentialID      base64URL `json:"credential_id"`
+	ClientDataJSON    base64URL `json:"client_data_json"`
+	AuthenticatorData base64URL `json:"authenticator_data"`
+	Signature         base64URL `json:"signature"`
+	AppID             int       `json:"app_id"`
+}
+
+// finishLoginPasskey logs the owner of the passkey in by the assertion.
+func (h *Handler) finishLoginPasskey(w http.ResponseWriter, r *http.Request) {
+	var req loginPasskeyRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	if len(req.CredentialID) == 0 || len(req.ClientDataJSON) == 0 || len(req.AuthenticatorData) == 0 || len(req.Signature) == 0 {
+		writeError(w, http.StatusBadRequest, "credential_id, client_data_json, authenticator_data and signature are required")
+
+		return
+	}
+	if req.AppID <= 0 {
+		writeError(w, http.StatusBadRequest, "app_id is required")
+
+		return
+	}
+
+	token, refreshToken, err := h.auth.FinishLoginPasskey(
+		r.Context(), req.CredentialID, req.ClientDataJSON, req.AuthenticatorData, req.Signature, req.AppID,
+	)
+	if err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tokens{Token: token, RefreshToken: refreshToken})
+}
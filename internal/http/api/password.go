@@ -0,0 +1,45 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"sso/internal/lib/caller"
+	"sso/internal/services/auth"
+)
+
+type changePasswordRequest struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+// changePassword sets a new password of the caller after checking the
+// current one.
+func (h *Handler) changePassword(w http.ResponseWriter, r *http.Request) {
+	var req changePasswordRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	if req.OldPassword == "" || req.NewPassword == "" {
+		writeError(w, http.StatusBadRequest, "old_password and new_password are required")
+
+		return
+	}
+
+	c, _ := caller.FromContext(r.Context())
+
+	if err := h.auth.ChangePassword(r.Context(), c.UserID, req.OldPassword, req.NewPassword); err != nil {
+		// Не 401: токен действителен, клиент не должен выходить из аккаунта
+		if errors.Is(err, auth.ErrInvalidCredentials) {
+			writeError(w, http.StatusForbidden, "invalid current password")
+
+			return
+		}
+
+		h.fail(w, r, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,55 @@
+package api_test
+
+import (
+	"context"
+	"net/http"
+	"sso/ssotest"
+	"testing"
+)
+
+func TestChangePassword(t *testing.T) {
+	srv := ssotest.NewServer(t)
+	h := newHandler(t, srv)
+
+	userID := saveUser(t, srv, "user@example.com", "correct-password")
+	token := ssotest.MustMintToken(t, ssotest.Claims{UserID: userID, Role: "user"})
+
+	key, _, err := srv.Auth.CreateAPIKey(context.Background(), ssotest.AppID, "admin", "admin")
+	if err != nil {
+		t.Fatalf("CreateAPIKey() error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		apiKey string
+		body   map[string]string
+		want   int
+	}{
+		{name: "anonymous", body: map[string]string{"old_password": "correct-password", "new_password": "new-password"}, want: http.StatusUnauthorized},
+		// Ключ приложения не принадлежит пользователю
+		{name: "api key", apiKey: key, body: map[string]string{"old_password": "correct-password", "new_password": "new-password"}, want: http.StatusForbidden},
+		{name: "no new password", token: token, body: map[string]string{"old_password": "correct-password"}, want: http.StatusBadRequest},
+		{name: "wrong password", token: token, body: map[string]string{"old_password": "wrong-password", "new_password": "new-password"}, want: http.StatusForbidden},
+		{name: "valid", token: token, body: map[string]string{"old_password": "correct-password", "new_password": "new-password"}, want: http.StatusNoContent},
+		{name: "old password changed", token: token, body: map[string]string{"old_password": "correct-password", "new_password": "other-password"}, want: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRequest(t, http.MethodPost, "/v1/me/password", tt.token, tt.body)
+			if tt.apiKey != "" {
+				r.Header.Set("X-Api-Key", tt.apiKey)
+			}
+
+			w := serve(h, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+
+	if _, _, err := srv.Auth.Login(context.Background(), "user@example.com", "new-password", ssotest.AppID); err != nil {
+		t.Errorf("Login() with new password error = %v", err)
+	}
+}
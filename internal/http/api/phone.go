@@ -0,0 +1,117 @@
+package api
+
+import (
+	"net/http"
+	"sso/internal/lib/caller"
+)
+
+type phoneRequest struct {
+	Phone string `json:"phone"`
+}
+
+// requestPhoneVerification sets the phone number of the caller and texts it
+// a code for verifyPhone.
+func (h *Handler) requestPhoneVerification(w http.ResponseWriter, r *http.Request) {
+	var req phoneRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	if req.Phone == "" {
+		writeError(w, http.StatusBadRequest, "phone is required")
+
+		return
+	}
+
+	c, _ := caller.FromContext(r.Context())
+
+	if err := h.auth.RequestPhoneVerification(r.Context(), c.UserID, req.Phone); err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// verifyPhone confirms the phone number of the caller with the texted code.
+func (h *Handler) verifyPhone(w http.ResponseWriter, r *http.Request) {
+	var req codeRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	if req.Code == "" {
+		writeError(w, http.StatusBadRequest, "code is required")
+
+		return
+	}
+
+	c, _ := caller.FromContext(r.Context())
+
+	if err := h.auth.VerifyPhone(r.Context(), c.UserID, req.Code); err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// requestLoginCode texts a login code to the verified phone number. It
+// answers the same for unknown numbers, see Auth.RequestLoginCode.
+func (h *Handler) requestLoginCode(w http.ResponseWriter, r *http.Request) {
+	var req phoneRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	if req.Phone == "" {
+		writeError(w, http.StatusBadRequest, "phone is required")
+
+		return
+	}
+
+	if err := h.auth.RequestLoginCode(r.Context(), req.Phone); err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+type phoneLoginRequest struct {
+	Phone string `json:"phone"`
+	Code  string `json:"code"`
+	AppID int    `json:"app_id"`
+}
+
+// loginWithPhoneCode exchanges the texted code for an access token. Users
+// with two-factor authentication get the ticket for the second step instead.
+func (h *Handler) loginWithPhoneCode(w http.ResponseWriter, r *http.Request) {
+	var req phoneLoginRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	if req.Phone == "" || req.Code == "" {
+		writeError(w, http.StatusBadRequest, "phone and code are required")
+
+		return
+	}
+	if req.AppID <= 0 {
+		writeError(w, http.StatusBadRequest, "app_id is required")
+
+		return
+	}
+
+	token, err := h.auth.LoginWithPhoneCode(r.Context(), req.Phone, req.Code, req.AppID)
+	if err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tokens{Token: token})
+}
@@ -0,0 +1,93 @@
+package api_test
+
+import (
+	"net/http"
+	"sso/ssotest"
+	"strings"
+	"testing"
+)
+
+// lastCode returns the code of the last SMS sent to the phone.
+func lastCode(t *testing.T, srv *ssotest.Server, phone string) string {
+	t.Helper()
+
+	messages := srv.SMS.Messages()
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Phone == phone {
+			return messages[i].Text[strings.LastIndex(messages[i].Text, " ")+1:]
+		}
+	}
+
+	t.Fatalf("no sms sent to %s", phone)
+
+	return ""
+}
+
+func TestPhoneLogin(t *testing.T) {
+	const phone = "+14155550100"
+
+	srv := ssotest.NewServer(t)
+	h := newHandler(t, srv)
+
+	userID := saveUser(t, srv, "user@example.com", "correct-password")
+	token := ssotest.MustMintToken(t, ssotest.Claims{UserID: userID, Role: "user"})
+
+	if code := do(t, h, http.MethodPost, "/v1/me/phone", "", map[string]string{"phone": phone}, nil); code != http.StatusUnauthorized {
+		t.Errorf("anonymous verification: status = %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code := do(t, h, http.MethodPost, "/v1/me/phone", token, map[string]string{"phone": "not a phone"}, nil); code != http.StatusBadRequest {
+		t.Errorf("invalid phone: status = %d, want %d", code, http.StatusBadRequest)
+	}
+
+	// Неподтверждённый номер не годится для входа
+	if code := do(t, h, http.MethodPost, "/v1/me/phone", token, map[string]string{"phone": phone}, nil); code != http.StatusAccepted {
+		t.Fatalf("request verification: status = %d, want %d", code, http.StatusAccepted)
+	}
+	sent := len(srv.SMS.Messages())
+	if code := do(t, h, http.MethodPost, "/v1/phone/code", "", map[string]string{"phone": phone}, nil); code != http.StatusAccepted {
+		t.Errorf("login code of unverified phone: status = %d, want %d", code, http.StatusAccepted)
+	}
+	if got := len(srv.SMS.Messages()); got != sent {
+		t.Errorf("login code sent to unverified phone")
+	}
+
+	if code := do(t, h, http.MethodPost, "/v1/me/phone/verify", token, map[string]string{"code": "000000"}, nil); code != http.StatusBadRequest {
+		t.Errorf("wrong verification code: status = %d, want %d", code, http.StatusBadRequest)
+	}
+	if code := do(t, h, http.MethodPost, "/v1/me/phone/verify", token, map[string]string{"code": lastCode(t, srv, phone)}, nil); code != http.StatusNoContent {
+		t.Fatalf("verify: status = %d, want %d", code, http.StatusNoContent)
+	}
+
+	if code := do(t, h, http.MethodPost, "/v1/phone/code", "", map[string]string{"phone": phone}, nil); code != http.StatusAccepted {
+		t.Fatalf("login code: status = %d, want %d", code, http.StatusAccepted)
+	}
+	loginCode := lastCode(t, srv, phone)
+
+	tests := []struct {
+		name string
+		body map[string]any
+		want int
+	}{
+		{name: "no app", body: map[string]any{"phone": phone, "code": loginCode}, want: http.StatusBadRequest},
+		{name: "wrong code", body: map[string]any{"phone": phone, "code": "000000", "app_id": ssotest.AppID}, want: http.StatusBadRequest},
+		{name: "unknown phone", body: map[string]any{"phone": "+14155550199", "code": loginCode, "app_id": ssotest.AppID}, want: http.StatusBadRequest},
+		{name: "valid", body: map[string]any{"phone": phone, "code": loginCode, "app_id": ssotest.AppID}, want: http.StatusOK},
+		{name: "code used", body: map[string]any{"phone": phone, "code": loginCode, "app_id": ssotest.AppID}, want: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resp struct {
+				Token string `json:"token"`
+			}
+
+			code := do(t, h, http.MethodPost, "/v1/phone/login", "", tt.body, &resp)
+			if code != tt.want {
+				t.Fatalf("status = %d, want %d", code, tt.want)
+			}
+			if code == http.StatusOK && resp.Token == "" {
+				t.Error("no token")
+			}
+		})
+	}
+}
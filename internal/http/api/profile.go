@@ -0,0 +1,110 @@
+package api
+
+import (
+	"net/http"
+)
+
+type usernameRequest struct {
+	// Username is empty to remove the handle.
+	Username string `json:"username"`
+}
+
+// setUsername sets the public handle of the user of the path, usable on
+// login instead of the email.
+func (h *Handler) setUsername(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	var req usernameRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	if err := h.auth.SetUsername(r.Context(), id, req.Username); err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type avatarRequest struct {
+	// AvatarURL is an absolute https URL, empty to remove the avatar.
+	AvatarURL string `json:"avatar_url"`
+}
+
+// setAvatar sets the avatar of the user of the path to an externally hosted
+// image.
+func (h *Handler) setAvatar(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	var req avatarRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	if err := h.auth.SetAvatar(r.Context(), id, req.AvatarURL); err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type preferencesResponse struct {
+	Preferences map[string]string `json:"preferences"`
+}
+
+// getPreferences returns the cross-device settings of the user of the path.
+func (h *Handler) getPreferences(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	prefs, err := h.auth.GetPreferences(r.Context(), id)
+	if err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	if prefs == nil {
+		prefs = map[string]string{}
+	}
+
+	writeJSON(w, http.StatusOK, preferencesResponse{Preferences: prefs})
+}
+
+type preferenceRequest struct {
+	// Value is empty to remove the preference.
+	Value string `json:"value"`
+}
+
+// setPreference sets the preference {key} of the user of the path.
+func (h *Handler) setPreference(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	var req preferenceRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	if err := h.auth.SetPreference(r.Context(), id, r.PathValue("key"), req.Value); err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+)
+
+type registrationMode struct {
+	// Mode is "open", "invite" or "closed".
+	Mode string `json:"mode"`
+}
+
+// getRegistrationMode returns the current registration mode.
+func (h *Handler) getRegistrationMode(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, registrationMode{Mode: h.auth.RegistrationMode()})
+}
+
+// setRegistrationMode switches the registration mode until the restart, when
+// the mode of the config applies again.
+func (h *Handler) setRegistrationMode(w http.ResponseWriter, r *http.Request) {
+	var req registrationMode
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	if err := h.auth.SetRegistrationMode(req.Mode); err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, req)
+}
+
+type createInvitationRequest struct {
+	Email string `json:"email"`
+}
+
+type createInvitationResponse struct {
+	// Code is shown only once; delivering it to the user is up to the caller.
+	Code string `json:"code"`
+}
+
+// createInvitation issues an invitation for the email of the body.
+func (h *Handler) createInvitation(w http.ResponseWriter, r *http.Request) {
+	var req createInvitationRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	if strings.TrimSpace(req.Email) == "" {
+		writeError(w, http.StatusBadRequest, "email is required")
+
+		return
+	}
+
+	code, err := h.auth.CreateInvitation(r.Context(), req.Email)
+	if err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, createInvitationResponse{Code: code})
+}
+
+type createUserRequest struct {
+	// Login is the email or phone number of the user.
+	Login    string `json:"login"`
+	Password string `json:"password"`
+	// Role is "user" if empty.
+	Role string `json:"role"`
+}
+
+type createUserResponse struct {
+	UserID int64 `json:"user_id"`
+}
+
+// createUser creates the account of the body in any registration mode.
+func (h *Handler) createUser(w http.ResponseWriter, r *http.Request) {
+	var req createUserRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	if req.Login == "" {
+		writeError(w, http.StatusBadRequest, "login is required")
+
+		return
+	}
+	if req.Password == "" {
+		writeError(w, http.StatusBadRequest, "password is required")
+
+		return
+	}
+
+	id, err := h.auth.CreateUser(r.Context(), req.Login, req.Password, req.Role)
+	if err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, createUserResponse{UserID: id})
+}
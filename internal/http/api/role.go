@@ -0,0 +1,116 @@
+package api
+
+import (
+	"net/http"
+	"sso/internal/domain/models"
+	"time"
+)
+
+type role struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Rank orders roles by privilege, higher is more privileged.
+	Rank           int       `json:"rank"`
+	SelfAssignable bool      `json:"self_assignable"`
+	Permissions    []string  `json:"permissions"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+func toRole(r models.Role) role {
+	return role{
+		Name:           r.Name,
+		Description:    r.Description,
+		Rank:           r.Rank,
+		SelfAssignable: r.SelfAssignable,
+		Permissions:    r.Permissions,
+		CreatedAt:      r.CreatedAt,
+	}
+}
+
+func (r role) model() models.Role {
+	return models.Role{
+		Name:           r.Name,
+		Description:    r.Description,
+		Rank:           r.Rank,
+		SelfAssignable: r.SelfAssignable,
+		Permissions:    r.Permissions,
+	}
+}
+
+type rolesResponse struct {
+	Roles []role `json:"roles"`
+}
+
+// listRoles returns all roles, most privileged first.
+func (h *Handler) listRoles(w http.ResponseWriter, r *http.Request) {
+	roles, err := h.auth.ListRoles(r.Context())
+	if err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	resp := rolesResponse{Roles: make([]role, 0, len(roles))}
+	for _, ro := range roles {
+		resp.Roles = append(resp.Roles, toRole(ro))
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// getRole returns the role of the path with its permissions.
+func (h *Handler) getRole(w http.ResponseWriter, r *http.Request) {
+	ro, err := h.auth.GetRole(r.Context(), r.PathValue("name"))
+	if err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, toRole(ro))
+}
+
+// createRole adds the role of the body.
+func (h *Handler) createRole(w http.ResponseWriter, r *http.Request) {
+	var req role
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	if err := h.auth.CreateRole(r.Context(), req.model()); err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+// editRole replaces the role of the path with the body; the name can't change.
+func (h *Handler) editRole(w http.ResponseWriter, r *http.Request) {
+	var req role
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	req.Name = r.PathValue("name")
+
+	if err := h.auth.EditRole(r.Context(), req.model()); err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteRole deletes the role of the path unless someone has it.
+func (h *Handler) deleteRole(w http.ResponseWriter, r *http.Request) {
+	if err := h.auth.DeleteRole(r.Context(), r.PathValue("name")); err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"net/http"
+	"time"
+)
+
+type session struct {
+	ID         int64     `json:"id"`
+	AppID      int       `json:"app_id"`
+	IP         string    `json:"ip,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	Device     string    `json:"device,omitempty"`
+	Region     string    `json:"region,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+type sessionsResponse struct {
+	Sessions []session `json:"sessions"`
+}
+
+// listSessions returns active sessions of the user of the path.
+func (h *Handler) listSessions(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	sessions, err := h.auth.ListSessions(r.Context(), id)
+	if err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	resp := sessionsResponse{Sessions: make([]session, 0, len(sessions))}
+	for _, s := range sessions {
+		resp.Sessions = append(resp.Sessions, session{
+			ID:         s.ID,
+			AppID:      s.AppID,
+			IP:         s.IP,
+			UserAgent:  s.UserAgent,
+			Device:     s.Device,
+			Region:     s.Region,
+			CreatedAt:  s.CreatedAt,
+			LastSeenAt: s.LastSeenAt,
+			ExpiresAt:  s.ExpiresAt,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// revokeSession ends the session {sid} of the user of the path.
+func (h *Handler) revokeSession(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	sessionID, ok := pathID(w, r, "sid")
+	if !ok {
+		return
+	}
+
+	if err := h.auth.RevokeSession(r.Context(), id, sessionID); err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
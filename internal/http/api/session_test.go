@@ -0,0 +1,43 @@
+package api_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sso/internal/services/auth"
+	"sso/ssotest"
+	"testing"
+)
+
+func TestSessionsOfSelfOrAdmin(t *testing.T) {
+	srv := ssotest.NewServer(t)
+	h := newHandler(t, srv)
+
+	userID := saveUser(t, srv, "user@example.com", "correct-password")
+	otherID := saveUser(t, srv, "other@example.com", "correct-password")
+	adminID := saveUser(t, srv, "admin@example.com", "correct-password")
+	if err := srv.Storage.UpdateRole(context.Background(), adminID, auth.AdminRole); err != nil {
+		t.Fatalf("UpdateRole() error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		caller int64
+		user   int64
+		want   int
+	}{
+		{name: "own", caller: userID, user: userID, want: http.StatusOK},
+		{name: "of other user", caller: otherID, user: userID, want: http.StatusForbidden},
+		{name: "admin", caller: adminID, user: userID, want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := ssotest.MustMintToken(t, ssotest.Claims{UserID: tt.caller, Role: "user"})
+
+			if code := do(t, h, http.MethodGet, fmt.Sprintf("/v1/users/%d/sessions", tt.user), token, nil, nil); code != tt.want {
+				t.Errorf("status = %d, want %d", code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"net/http"
+	"time"
+)
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// refresh exchanges a refresh token for new tokens, see Auth.Refresh.
+func (h *Handler) refresh(w http.ResponseWriter, r *http.Request) {
+	var req refreshRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	if req.RefreshToken == "" {
+		writeError(w, http.StatusBadRequest, "refresh_token is required")
+
+		return
+	}
+
+	token, refreshToken, err := h.auth.Refresh(r.Context(), req.RefreshToken)
+	if err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tokens{Token: token, RefreshToken: refreshToken})
+}
+
+type logoutRequest struct {
+	// RefreshToken is revoked along with the access token, if set.
+	RefreshToken string `json:"refresh_token"`
+	// All revokes every token of the user, see Auth.Logout.
+	All bool `json:"all"`
+}
+
+// logout revokes the access token of the Authorization header. The body is
+// optional.
+func (h *Handler) logout(w http.ResponseWriter, r *http.Request) {
+	token, ok := bearerToken(r)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "access token required")
+
+		return
+	}
+
+	var req logoutRequest
+	if r.ContentLength != 0 && !readJSON(w, r, &req) {
+		return
+	}
+
+	if err := h.auth.Logout(r.Context(), token, req.RefreshToken, req.All); err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type validateRequest struct {
+	Token string `json:"token"`
+}
+
+type claimsResponse struct {
+	UserID         int64     `json:"user_id"`
+	Email          string    `json:"email,omitempty"`
+	Role           string    `json:"role,omitempty"`
+	AppID          int       `json:"app_id"`
+	OrgID          int64     `json:"org_id,omitempty"`
+	SessionID      int64     `json:"session_id,omitempty"`
+	Audience       string    `json:"audience,omitempty"`
+	Scopes         []string  `json:"scopes,omitempty"`
+	Groups         []string  `json:"groups,omitempty"`
+	Region         string    `json:"region,omitempty"`
+	IssuedAt       time.Time `json:"issued_at"`
+	ExpiresAt      time.Time `json:"expires_at"`
+	CertThumbprint string    `json:"cert_thumbprint,omitempty"`
+	KeyThumbprint  string    `json:"key_thumbprint,omitempty"`
+}
+
+// validateToken returns the claims of a valid access token to services
+// relying on SSO. Bound tokens fail, since the proof of the client can't be
+// checked for a request of another service.
+func (h *Handler) validateToken(w http.ResponseWriter, r *http.Request) {
+	var req validateRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	if req.Token == "" {
+		writeError(w, http.StatusBadRequest, "token is required")
+
+		return
+	}
+
+	claims, err := h.auth.ValidateToken(r.Context(), req.Token)
+	if err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, claimsResponse{
+		UserID:         claims.UserID,
+		Email:          claims.Email,
+		Role:           claims.Role,
+		AppID:          claims.AppID,
+		OrgID:          claims.OrgID,
+		SessionID:      claims.SessionID,
+		Audience:       claims.Audience,
+		Scopes:         claims.Scopes,
+		Groups:         claims.Groups,
+		Region:         claims.Region,
+		IssuedAt:       claims.IssuedAt,
+		ExpiresAt:      claims.ExpiresAt,
+		CertThumbprint: claims.CertThumbprint,
+		KeyThumbprint:  claims.KeyThumbprint,
+	})
+}
@@ -0,0 +1,318 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sso/internal/domain/models"
+	"sso/internal/lib/caller"
+	"strconv"
+	"time"
+)
+
+type user struct {
+	ID            int64      `json:"id"`
+	Email         string     `json:"email,omitempty"`
+	Username      string     `json:"username,omitempty"`
+	Phone         string     `json:"phone,omitempty"`
+	PhoneVerified bool       `json:"phone_verified"`
+	AvatarURL     string     `json:"avatar_url,omitempty"`
+	Role          string     `json:"role"`
+	Status        string     `json:"status,omitempty"`
+	OrgID         int64      `json:"org_id,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	LastLoginAt   *time.Time `json:"last_login_at,omitempty"`
+	DeletedAt     *time.Time `json:"deleted_at,omitempty"`
+}
+
+func toUser(u models.User) user {
+	return user{
+		ID:            u.ID,
+		Email:         u.Email,
+		Username:      u.Username,
+		Phone:         u.Phone,
+		PhoneVerified: u.PhoneVerified,
+		AvatarURL:     u.AvatarURL,
+		Role:          u.Role,
+		Status:        u.Status,
+		OrgID:         u.OrgID,
+		CreatedAt:     u.CreatedAt,
+		LastLoginAt:   optionalTime(u.LastLoginAt),
+		DeletedAt:     optionalTime(u.DeletedAt),
+	}
+}
+
+type usersResponse struct {
+	Users []user `json:"users"`
+}
+
+// getUser returns the user of the path without secrets.
+func (h *Handler) getUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	u, err := h.auth.GetUser(r.Context(), id, "")
+	if err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, toUser(u))
+}
+
+// getUserByEmail returns the user with the email of the query.
+func (h *Handler) getUserByEmail(w http.ResponseWriter, r *http.Request) {
+	email := r.URL.Query().Get("email")
+	if email == "" {
+		writeError(w, http.StatusBadRequest, "email is required")
+
+		return
+	}
+
+	u, err := h.auth.GetUser(r.Context(), 0, email)
+	if err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, toUser(u))
+}
+
+type existsResponse struct {
+	Exists bool `json:"exists"`
+}
+
+// userExists reports whether an account with the email query parameter
+// exists, for registration forms. The route is public, so it's limited per
+// IP to keep it from being used to enumerate accounts.
+func (h *Handler) userExists(w http.ResponseWriter, r *http.Request) {
+	email := r.URL.Query().Get("email")
+	if email == "" {
+		writeError(w, http.StatusBadRequest, "email is required")
+
+		return
+	}
+
+	exists, err := h.auth.UserExists(r.Context(), email)
+	if err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, existsResponse{Exists: exists})
+}
+
+// searchUsers returns up to limit users whose email or username resembles
+// the q query parameter, best matches first.
+func (h *Handler) searchUsers(w http.ResponseWriter, r *http.Request) {
+	limit := 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "invalid limit")
+
+			return
+		}
+		limit = n
+	}
+
+	users, err := h.auth.SearchUsers(r.Context(), r.URL.Query().Get("q"), limit)
+	if err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	resp := usersResponse{Users: make([]user, 0, len(users))}
+	for _, u := range users {
+		resp.Users = append(resp.Users, toUser(u))
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// deleteUser removes the user of the path, or scrubs the account of personal
+// data with "?anonymize=true", see Auth.DeleteUser. With "?soft=true" the user
+// is only hidden until restoreUser, see Auth.SoftDeleteUser.
+func (h *Handler) deleteUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	anonymize, ok := queryBool(w, r, "anonymize")
+	if !ok {
+		return
+	}
+
+	soft, ok := queryBool(w, r, "soft")
+	if !ok {
+		return
+	}
+
+	var err error
+	switch {
+	case soft && anonymize:
+		writeError(w, http.StatusBadRequest, "soft and anonymize are exclusive")
+
+		return
+	case soft:
+		err = h.auth.SoftDeleteUser(r.Context(), id)
+	default:
+		err = h.auth.DeleteUser(r.Context(), id, anonymize)
+	}
+	if err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// restoreUser undoes the soft deletion of the user of the path.
+func (h *Handler) restoreUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	if err := h.auth.RestoreUser(r.Context(), id); err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// unlockUser lifts the lockout of the user of the path after failed logins.
+func (h *Handler) unlockUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	if err := h.auth.UnlockUser(r.Context(), id); err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// suspendUser returns a handler setting the status of the user of the path,
+// models.UserStatusSuspended or models.UserStatusBanned, until reinstateUser.
+func (h *Handler) suspendUser(status string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := pathID(w, r, "id")
+		if !ok {
+			return
+		}
+
+		if err := h.auth.SuspendUser(r.Context(), id, status); err != nil {
+			h.fail(w, r, err)
+
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// reinstateUser makes the suspended or banned user of the path active again.
+func (h *Handler) reinstateUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	if err := h.auth.ReinstateUser(r.Context(), id); err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type mergeUsersRequest struct {
+	IntoID int64 `json:"into_id"`
+}
+
+// mergeUsers moves everything of the user of the path to the user of the
+// body and removes the former, see Auth.MergeUsers.
+func (h *Handler) mergeUsers(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	var req mergeUsersRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	if req.IntoID <= 0 {
+		writeError(w, http.StatusBadRequest, "into_id is required")
+
+		return
+	}
+
+	if err := h.auth.MergeUsers(r.Context(), id, req.IntoID); err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type isAdminResponse struct {
+	Admin bool `json:"admin"`
+}
+
+// userIsAdmin answers whether the user of the path is an admin. Services
+// ask with their API keys; users may ask about themselves, admins about anyone.
+func (h *Handler) userIsAdmin(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	if c, _ := caller.FromContext(r.Context()); c.UserID != 0 && c.UserID != id && !h.isAdmin(w, r) {
+		return
+	}
+
+	admin, err := h.auth.IsAdmin(r.Context(), id)
+	if err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, isAdminResponse{Admin: admin})
+}
+
+// exportUserData returns everything stored about the user of the path as a
+// JSON document, see Auth.ExportUserData.
+func (h *Handler) exportUserData(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	data, err := h.auth.ExportUserData(r.Context(), id)
+	if err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, json.RawMessage(data))
+}
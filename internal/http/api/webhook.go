@@ -0,0 +1,155 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sso/internal/domain/models"
+	"time"
+)
+
+type webhook struct {
+	ID     int64    `json:"id"`
+	AppID  int      `json:"app_id"`
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	// Secret is shown only on creation.
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func toWebhook(w models.Webhook) webhook {
+	return webhook{
+		ID:        w.ID,
+		AppID:     w.AppID,
+		URL:       w.URL,
+		Events:    w.Events,
+		Secret:    w.Secret,
+		CreatedAt: w.CreatedAt,
+	}
+}
+
+type createWebhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+// createWebhook registers the endpoint of the body for the app of the path.
+func (h *Handler) createWebhook(w http.ResponseWriter, r *http.Request) {
+	appID, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	var req createWebhookRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	hook, err := h.auth.CreateWebhook(r.Context(), int(appID), req.URL, req.Events)
+	if err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, toWebhook(hook))
+}
+
+type webhooksResponse struct {
+	Webhooks []webhook `json:"webhooks"`
+}
+
+// listWebhooks returns webhooks of the app of the path without secrets.
+func (h *Handler) listWebhooks(w http.ResponseWriter, r *http.Request) {
+	appID, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	hooks, err := h.auth.ListWebhooks(r.Context(), int(appID))
+	if err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	resp := webhooksResponse{Webhooks: make([]webhook, 0, len(hooks))}
+	for _, hook := range hooks {
+		resp.Webhooks = append(resp.Webhooks, toWebhook(hook))
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// deleteWebhook removes the webhook of the path with its pending deliveries.
+func (h *Handler) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	if err := h.auth.DeleteWebhook(r.Context(), id); err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type webhookDelivery struct {
+	ID            int64           `json:"id"`
+	Event         string          `json:"event"`
+	Payload       json.RawMessage `json:"payload"`
+	Attempts      int             `json:"attempts"`
+	NextAttemptAt *time.Time      `json:"next_attempt_at,omitempty"`
+	LastStatus    int             `json:"last_status,omitempty"`
+	LastError     string          `json:"last_error,omitempty"`
+	CreatedAt     time.Time       `json:"created_at"`
+	DeliveredAt   *time.Time      `json:"delivered_at,omitempty"`
+	FailedAt      *time.Time      `json:"failed_at,omitempty"`
+}
+
+type webhookDeliveriesResponse struct {
+	Deliveries    []webhookDelivery `json:"deliveries"`
+	NextPageToken string            `json:"next_page_token,omitempty"`
+}
+
+// listWebhookDeliveries returns the delivery log of the webhook of the path,
+// newest first, by pages of page_size after page_token.
+func (h *Handler) listWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	pageToken, size, ok := page(w, r)
+	if !ok {
+		return
+	}
+
+	deliveries, next, err := h.auth.ListWebhookDeliveries(r.Context(), id, pageToken, size)
+	if err != nil {
+		h.fail(w, r, err)
+
+		return
+	}
+
+	resp := webhookDeliveriesResponse{Deliveries: make([]webhookDelivery, 0, len(deliveries)), NextPageToken: next}
+	for _, d := range deliveries {
+		resp.Deliveries = append(resp.Deliveries, webhookDelivery{
+			ID:            d.ID,
+			Event:         d.Event,
+			Payload:       d.Payload,
+			Attempts:      d.Attempts,
+			NextAttemptAt: optionalTime(d.NextAttemptAt),
+			LastStatus:    d.LastStatus,
+			LastError:     d.LastError,
+			CreatedAt:     d.CreatedAt,
+			DeliveredAt:   optionalTime(d.DeliveredAt),
+			FailedAt:      optionalTime(d.FailedAt),
+		})
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
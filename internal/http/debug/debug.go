@@ -0,0 +1,57 @@
+// Package debug serves net/http/pprof profiles and expvar runtime metrics.
+// The handler must only be reachable by operators, never by clients.
+package debug
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/metrics"
+	"sync"
+)
+
+var publishOnce sync.Once
+
+// Handler serves /debug/pprof/ and /debug/vars.
+func Handler() http.Handler {
+	publishOnce.Do(func() {
+		expvar.Publish("goroutines", expvar.Func(func() any {
+			return runtime.NumGoroutine()
+		}))
+		expvar.Publish("runtime", expvar.Func(runtimeMetrics))
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	return mux
+}
+
+// runtimeMetrics reads scalar runtime/metrics; histograms are left to pprof.
+func runtimeMetrics() any {
+	descs := metrics.All()
+
+	samples := make([]metrics.Sample, len(descs))
+	for i, d := range descs {
+		samples[i].Name = d.Name
+	}
+	metrics.Read(samples)
+
+	out := make(map[string]any, len(samples))
+	for _, s := range samples {
+		switch s.Value.Kind() {
+		case metrics.KindUint64:
+			out[s.Name] = s.Value.Uint64()
+		case metrics.KindFloat64:
+			out[s.Name] = s.Value.Float64()
+		}
+	}
+
+	return out
+}
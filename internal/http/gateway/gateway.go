@@ -0,0 +1,261 @@
+// Package gateway serves the Auth gRPC service as HTTP/JSON for web frontends.
+// Calls go through the gRPC server, so they pass the same interceptors
+// (authentication, rate limits, quotas) as native gRPC clients.
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/textproto"
+	"sso/internal/lib/clientinfo"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/requestid"
+	"strconv"
+	"strings"
+
+	ssov1 "github.com/wadt3rr/city-events-auth-protos/gen/go/sso"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const maxBodySize = 1 << 20
+
+// forwardedHeaders are passed to the gRPC server as metadata.
+var forwardedHeaders = []string{
+	"authorization",
+	"dpop",
+	"idempotency-key",
+	"x-api-key",
+	"x-app-id",
+	"x-audit-reason",
+	"x-captcha-token",
+	"x-device-name",
+	"x-invite-code",
+	"x-page-size",
+	"x-page-token",
+	"x-filter-role",
+	"x-filter-email-prefix",
+	"x-filter-deleted",
+	"x-sort",
+}
+
+// listUsersParams map query parameters of GET /v1/users to ListUsers metadata.
+var listUsersParams = map[string]string{
+	"page_size":    "x-page-size",
+	"page_token":   "x-page-token",
+	"role":         "x-filter-role",
+	"email_prefix": "x-filter-email-prefix",
+	"deleted":      "x-filter-deleted",
+	"sort":         "x-sort",
+}
+
+var (
+	unmarshalOpts = protojson.UnmarshalOptions{DiscardUnknown: true}
+	marshalOpts   = protojson.MarshalOptions{UseProtoNames: true}
+)
+
+type Gateway struct {
+	log    *slog.Logger
+	client ssov1.AuthClient
+}
+
+// New creates gateway calling the Auth service over conn, usually a loopback
+// connection to the gRPC server of this instance.
+func New(log *slog.Logger, conn grpc.ClientConnInterface) *Gateway {
+	return &Gateway{log: log, client: ssov1.NewAuthClient(conn)}
+}
+
+// Register adds the endpoints to mux.
+func (g *Gateway) Register(mux *http.ServeMux) {
+	mux.HandleFunc("POST /v1/login", g.login)
+	mux.HandleFunc("POST /v1/register", g.register)
+	mux.HandleFunc("GET /v1/users", g.listUsers)
+	mux.HandleFunc("GET /v1/users/{id}/role", g.getUserRole)
+	mux.HandleFunc("PUT /v1/users/{id}/role", g.updateRole)
+}
+
+func (g *Gateway) login(w http.ResponseWriter, r *http.Request) {
+	in := &ssov1.LoginRequest{}
+	if !readBody(w, r, in) {
+		return
+	}
+
+	g.call(w, r, func(ctx context.Context, opts ...grpc.CallOption) (proto.Message, error) {
+		return g.client.Login(ctx, in, opts...)
+	})
+}
+
+func (g *Gateway) register(w http.ResponseWriter, r *http.Request) {
+	in := &ssov1.RegisterRequest{}
+	if !readBody(w, r, in) {
+		return
+	}
+
+	g.call(w, r, func(ctx context.Context, opts ...grpc.CallOption) (proto.Message, error) {
+		return g.client.Register(ctx, in, opts...)
+	})
+}
+
+// listUsers returns the next page token in the X-Next-Page-Token header.
+func (g *Gateway) listUsers(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	for param, header := range listUsersParams {
+		if v := q.Get(param); v != "" {
+			r.Header.Set(header, v)
+		}
+	}
+
+	g.call(w, r, func(ctx context.Context, opts ...grpc.CallOption) (proto.Message, error) {
+		return g.client.ListUsers(ctx, &ssov1.ListUsersRequest{}, opts...)
+	})
+}
+
+func (g *Gateway) getUserRole(w http.ResponseWriter, r *http.Request) {
+	id, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	g.call(w, r, func(ctx context.Context, opts ...grpc.CallOption) (proto.Message, error) {
+		return g.client.GetUserRole(ctx, &ssov1.GetUserRoleRequest{UserId: id}, opts...)
+	})
+}
+
+func (g *Gateway) updateRole(w http.ResponseWriter, r *http.Request) {
+	id, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	in := &ssov1.UpdateUserRoleRequest{}
+	if !readBody(w, r, in) {
+		return
+	}
+	in.UserId = id
+
+	g.call(w, r, func(ctx context.Context, opts ...grpc.CallOption) (proto.Message, error) {
+		return g.client.UpdateRole(ctx, in, opts...)
+	})
+}
+
+// call invokes the method with request headers as metadata and writes its
+// response, or its error translated to an HTTP status. Response metadata
+// such as x-refresh-token is returned as headers.
+func (g *Gateway) call(w http.ResponseWriter, r *http.Request, method func(ctx context.Context, opts ...grpc.CallOption) (proto.Message, error)) {
+	md := metadata.MD{}
+	for _, h := range forwardedHeaders {
+		if v := r.Header.Values(h); len(v) > 0 {
+			md.Set(h, v...)
+		}
+	}
+	if ip := clientinfo.FromContext(r.Context()).IP; ip != "" {
+		md.Set("x-forwarded-for", ip)
+	}
+	if id := requestid.FromContext(r.Context()); id != "" {
+		md.Set(requestid.Header, id)
+	}
+
+	var header metadata.MD
+
+	resp, err := method(metadata.NewOutgoingContext(r.Context(), md), grpc.Header(&header))
+
+	for k, v := range header {
+		if strings.HasPrefix(k, "x-") && k != requestid.Header {
+			for _, s := range v {
+				w.Header().Add(textproto.CanonicalMIMEHeaderKey(k), s)
+			}
+		}
+	}
+
+	if err != nil {
+		st := status.Convert(err)
+		writeError(w, httpStatus(st.Code()), st.Message())
+
+		return
+	}
+
+	body, err := marshalOpts.Marshal(resp)
+	if err != nil {
+		requestid.Logger(r.Context(), g.log).Error("failed to marshal response", sl.Err(err))
+		writeError(w, http.StatusInternalServerError, "internal error")
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(body)
+}
+
+func readBody(w http.ResponseWriter, r *http.Request, in proto.Message) bool {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, "request body is too large")
+
+		return false
+	}
+
+	if err := unmarshalOpts.Unmarshal(body, in); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json body")
+
+		return false
+	}
+
+	return true
+}
+
+func userID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid user id")
+
+		return 0, false
+	}
+
+	return id, true
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+// httpStatus maps gRPC codes the way grpc-gateway does.
+func httpStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499
+	case codes.InvalidArgument, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.FailedPrecondition:
+		return http.StatusPreconditionFailed
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
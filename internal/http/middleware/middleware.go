@@ -0,0 +1,140 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"slices"
+	"sso/internal/lib/clientinfo"
+	"sso/internal/lib/requestid"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig lists origins allowed to call HTTP endpoints from browsers.
+//
+// Origins are global, not per app: a preflight request carries no app id to
+// match them against, and any allowed origin can call the endpoints of every
+// app with the credentials of the browser. List only origins trusted as much
+// as the service itself.
+type CORSConfig struct {
+	AllowedOrigins []string      `yaml:"allowed_origins"`
+	MaxAge         time.Duration `yaml:"max_age" env-default:"10m"`
+}
+
+// corsHeaders are request headers the HTTP endpoints accept from browsers,
+// see gateway.forwardedHeaders and the metadata read by the interceptors.
+const corsHeaders = "Authorization, Content-Type, DPoP, Idempotency-Key, X-Request-Id, X-Api-Key, X-App-Id, " +
+	"X-Invite-Code, X-Captcha-Token, X-Device-Name, X-Audit-Reason, X-Page-Size, X-Page-Token, " +
+	"X-Filter-Role, X-Filter-Email-Prefix, X-Filter-Deleted, X-Sort, X-Grpc-Web, X-User-Agent, Grpc-Timeout"
+
+// corsExposedHeaders are response headers browsers may read.
+const corsExposedHeaders = "X-Request-Id, X-Refresh-Token, X-Mfa-Ticket, X-Mfa-Methods, X-Locked-Until, X-Next-Page-Token, " +
+	"X-Quota-Reset, X-Idempotent-Replay, Retry-After, Grpc-Status, Grpc-Message"
+
+// CORS answers preflight requests and sets CORS headers for allowed origins.
+// Requests from other origins are passed through without CORS headers,
+// so that browsers block reading the response.
+func CORS(cfg CORSConfig) func(http.Handler) http.Handler {
+	allowed := make(map[string]bool)
+	for _, o := range cfg.AllowedOrigins {
+		allowed[strings.TrimSuffix(o, "/")] = true
+	}
+
+	maxAge := strconv.Itoa(int(cfg.MaxAge.Seconds()))
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			w.Header().Add("Vary", "Origin")
+
+			if origin == "" || !allowed[origin] {
+				next.ServeHTTP(w, r)
+
+				return
+			}
+
+			h := w.Header()
+			h.Set("Access-Control-Allow-Origin", origin)
+			h.Set("Access-Control-Allow-Credentials", "true")
+			h.Set("Access-Control-Expose-Headers", corsExposedHeaders)
+
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				h.Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE")
+				h.Set("Access-Control-Allow-Headers", corsHeaders)
+				h.Set("Access-Control-Max-Age", maxAge)
+				w.WriteHeader(http.StatusNoContent)
+
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// SecurityHeaders sets standard hardening headers on every response.
+// HSTS is only sent when hsts is positive, i.e. the listener is served over TLS.
+func SecurityHeaders(hsts time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h := w.Header()
+			h.Set("X-Content-Type-Options", "nosniff")
+			h.Set("X-Frame-Options", "DENY")
+			h.Set("Referrer-Policy", "no-referrer")
+			h.Set("Content-Security-Policy", "default-src 'none'; frame-ancestors 'none'")
+			h.Set("Cache-Control", "no-store")
+
+			if hsts > 0 {
+				h.Set("Strict-Transport-Security", "max-age="+strconv.Itoa(int(hsts.Seconds()))+"; includeSubDomains")
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ClientInfo records the client address and user agent for sessions started
+// over HTTP. X-Forwarded-For is believed for trusted proxies only, as for gRPC.
+func ClientInfo(proxies clientinfo.Proxies) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			remote := r.RemoteAddr
+			if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+				remote = host
+			}
+
+			info := clientinfo.Info{
+				IP:        proxies.ClientIP(remote, r.Header.Values("X-Forwarded-For")),
+				UserAgent: r.UserAgent(),
+				Device:    r.Header.Get(clientinfo.DeviceHeader),
+			}
+
+			next.ServeHTTP(w, r.WithContext(clientinfo.WithInfo(r.Context(), info)))
+		})
+	}
+}
+
+// RequestID takes X-Request-Id of the client or generates one, puts it into
+// the request context and echoes it in the response.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestid.Header)
+		if !requestid.Valid(id) {
+			id = requestid.New()
+		}
+
+		w.Header().Set(requestid.Header, id)
+
+		next.ServeHTTP(w, r.WithContext(requestid.WithID(r.Context(), id)))
+	})
+}
+
+// Chain applies middlewares so that the first one is the outermost.
+func Chain(h http.Handler, mws ...func(http.Handler) http.Handler) http.Handler {
+	for _, mw := range slices.Backward(mws) {
+		h = mw(h)
+	}
+
+	return h
+}
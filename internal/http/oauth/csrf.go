@@ -0,0 +1,44 @@
+package oauth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"net/http"
+)
+
+const (
+	csrfCookie = "sso_csrf"
+	csrfField  = "csrf_token"
+)
+
+// csrfToken returns the token of the login form: the value of the CSRF cookie,
+// set to a new random one on the first visit. Posts of the form must echo it
+// (double submit cookie), so other sites can't post the form for the user.
+func csrfToken(w http.ResponseWriter, r *http.Request) string {
+	if c, err := r.Cookie(csrfCookie); err == nil && c.Value != "" {
+		return c.Value
+	}
+
+	token := rand.Text()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    token,
+		Path:     "/oauth/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return token
+}
+
+// checkCSRF reports whether the posted form carries the token of the CSRF cookie.
+func checkCSRF(r *http.Request) bool {
+	c, err := r.Cookie(csrfCookie)
+	if err != nil || c.Value == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(c.Value), []byte(r.PostForm.Get(csrfField))) == 1
+}
@@ -0,0 +1,429 @@
+// Package oauth serves the OAuth2 authorization code flow (RFC 6749) over HTTP.
+// Apps are OAuth2 clients: client_id is the app id, client_secret is the app secret.
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"sso/internal/domain/models"
+	"sso/internal/lib/captcha"
+	"sso/internal/lib/clientinfo"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/ratelimit"
+	"sso/internal/lib/requestid"
+	"sso/internal/services/auth"
+	"strconv"
+	"time"
+)
+
+type Auth interface {
+	AuthorizeClient(ctx context.Context, req models.AuthorizationRequest) error
+	Authorize(ctx context.Context, login string, password string, req models.AuthorizationRequest) (string, error)
+	AuthorizeMFA(ctx context.Context, ticket string, method string, code string, req models.AuthorizationRequest) (string, error)
+	ExchangeAuthorizationCode(ctx context.Context, appID int, clientSecret string, code string, redirectURI string, codeVerifier string) (string, string, string, error)
+	RefreshForClient(ctx context.Context, appID int, clientSecret string, refreshToken string) (string, string, error)
+	IssueServiceToken(ctx context.Context, appID int, clientSecret string, scope string) (string, string, error)
+	UserInfo(ctx context.Context, token string) (models.User, error)
+	SocialProviders() []string
+	BeginSocialLogin(ctx context.Context, provider string, req models.AuthorizationRequest) (string, error)
+	FinishSocialLogin(ctx context.Context, provider string, state string, code string) (string, models.AuthorizationRequest, error)
+}
+
+type Handler struct {
+	log  *slog.Logger
+	auth Auth
+	// issuer is the public base URL of the server; empty disables OpenID Connect endpoints.
+	issuer string
+	keys   map[int]*jwt.SigningKey
+	// limiter and loginLimit throttle the login form per client IP in the
+	// same buckets as the Login gRPC method.
+	limiter    *ratelimit.Limiter
+	loginLimit ratelimit.Limit
+}
+
+// New creates handler of OAuth2 endpoints and, if issuer is set, OpenID Connect
+// discovery, JWKS with the public keys and userinfo. Posts of the login form
+// are limited by loginLimit, as calls of Login are.
+func New(log *slog.Logger, auth Auth, issuer string, keys map[int]*jwt.SigningKey, limiter *ratelimit.Limiter, loginLimit ratelimit.Limit) *Handler {
+	return &Handler{log: log, auth: auth, issuer: issuer, keys: keys, limiter: limiter, loginLimit: loginLimit}
+}
+
+// Register adds the endpoints to mux.
+func (h *Handler) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /oauth/authorize", h.authorizeForm)
+	mux.HandleFunc("POST /oauth/authorize", h.authorize)
+	mux.HandleFunc("POST /oauth/token", h.token)
+	mux.HandleFunc("POST /oauth/social", h.beginSocial)
+	mux.HandleFunc("GET /oauth/social/{provider}/callback", h.finishSocial)
+
+	if h.issuer != "" {
+		mux.HandleFunc("GET /.well-known/openid-configuration", h.discovery)
+		mux.HandleFunc("GET /.well-known/jwks.json", h.jwks)
+		mux.HandleFunc("GET /oauth/userinfo", h.userInfo)
+		mux.HandleFunc("POST /oauth/userinfo", h.userInfo)
+	}
+}
+
+// authRequest holds parameters of the authorization request, carried
+// through the login and second factor forms as hidden fields.
+type authRequest struct {
+	models.AuthorizationRequest
+	ClientID string
+}
+
+type page struct {
+	Request authRequest
+	Error   string
+	// Ticket and Methods are set on the second factor step.
+	Ticket  string
+	Methods []string
+	// Providers are identity providers offered on the login step.
+	Providers []string
+	// CSRF is echoed by posts of the form, see csrfToken.
+	CSRF string
+}
+
+func (h *Handler) authorizeForm(w http.ResponseWriter, r *http.Request) {
+	req, ok := h.authRequest(w, r, r.URL.Query())
+	if !ok {
+		return
+	}
+
+	if rt := r.URL.Query().Get("response_type"); rt != "code" {
+		redirectError(w, r, req, "unsupported_response_type")
+
+		return
+	}
+
+	h.render(w, r, page{Request: req})
+}
+
+func (h *Handler) authorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+
+		return
+	}
+
+	req, ok := h.authRequest(w, r, r.PostForm)
+	if !ok {
+		return
+	}
+
+	if !checkCSRF(r) {
+		http.Error(w, "invalid csrf token", http.StatusForbidden)
+
+		return
+	}
+
+	if !h.allowLogin(w, r) {
+		h.render(w, r, page{Request: req, Error: "Too many attempts, try again later."})
+
+		return
+	}
+
+	var (
+		code string
+		err  error
+	)
+
+	if ticket := r.PostForm.Get("ticket"); ticket != "" {
+		code, err = h.auth.AuthorizeMFA(r.Context(), ticket, r.PostForm.Get("method"), r.PostForm.Get("code"), req.AuthorizationRequest)
+	} else {
+		ctx := r.Context()
+		if token := captchaToken(r.PostForm); token != "" {
+			ctx = captcha.WithToken(ctx, token)
+		}
+
+		code, err = h.auth.Authorize(ctx, r.PostForm.Get("login"), r.PostForm.Get("password"), req.AuthorizationRequest)
+	}
+
+	h.finishAuthorize(w, r, req, code, err)
+}
+
+// allowLogin takes a token of the client IP for the password or second factor
+// post. Denied, it sets Retry-After and the 429 status for the page.
+func (h *Handler) allowLogin(w http.ResponseWriter, r *http.Request) bool {
+	ip := clientinfo.FromContext(r.Context()).IP
+	if ip == "" {
+		return true
+	}
+
+	allowed, wait := h.limiter.Allow("Login:"+ip, h.loginLimit, time.Now())
+	if allowed {
+		return true
+	}
+
+	requestid.Logger(r.Context(), h.log).Info("rate limit exceeded", slog.String("method", "Login"), slog.String("ip", ip))
+
+	w.Header().Set("Retry-After", strconv.FormatInt(int64(math.Ceil(wait.Seconds())), 10))
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusTooManyRequests)
+
+	return false
+}
+
+// finishAuthorize redirects to the app with the code or shows the next step of the login.
+func (h *Handler) finishAuthorize(w http.ResponseWriter, r *http.Request, req authRequest, code string, err error) {
+	var mfaErr *auth.MFARequiredError
+
+	switch {
+	case err == nil:
+		q := url.Values{"code": {code}}
+		if req.State != "" {
+			q.Set("state", req.State)
+		}
+
+		http.Redirect(w, r, withQuery(req.RedirectURI, q), http.StatusFound)
+	case errors.As(err, &mfaErr):
+		h.render(w, r, page{Request: req, Ticket: mfaErr.Ticket, Methods: mfaErr.Methods})
+	case errors.Is(err, auth.ErrInvalidCredentials), errors.Is(err, auth.ErrUserNotFound):
+		h.render(w, r, page{Request: req, Error: "Invalid login or password."})
+	case errors.Is(err, auth.ErrAccountLocked):
+		h.render(w, r, page{Request: req, Error: "Too many failed attempts, try again later."})
+	case errors.Is(err, auth.ErrAccountSuspended):
+		h.render(w, r, page{Request: req, Error: "The account is suspended."})
+	case errors.Is(err, auth.ErrCaptchaRequired):
+		h.render(w, r, page{Request: req, Error: "Confirm you are not a robot and try again."})
+	case errors.Is(err, auth.ErrInvalidCode):
+		// Тикет уже потрачен, начинаем вход заново
+		h.render(w, r, page{Request: req, Error: "Invalid code, log in again."})
+	case errors.Is(err, auth.ErrEmailNotVerified):
+		h.render(w, r, page{Request: req, Error: "The account has no verified email."})
+	case errors.Is(err, auth.ErrRegistrationClosed), errors.Is(err, auth.ErrEmailDomainNotAllowed):
+		h.render(w, r, page{Request: req, Error: "Registration with this account is not allowed."})
+	default:
+		requestid.Logger(r.Context(), h.log).Error("failed to authorize", sl.Err(err))
+
+		redirectError(w, r, req, "server_error")
+	}
+}
+
+// captchaToken returns the token posted by a captcha widget added to the login page.
+func captchaToken(form url.Values) string {
+	for _, field := range captcha.FormFields {
+		if token := form.Get(field); token != "" {
+			return token
+		}
+	}
+
+	return ""
+}
+
+// authRequest validates client_id and redirect_uri. Errors in them are shown
+// to the user instead of redirecting, as the redirect URI can't be trusted.
+func (h *Handler) authRequest(w http.ResponseWriter, r *http.Request, params url.Values) (authRequest, bool) {
+	req := authRequest{
+		AuthorizationRequest: models.AuthorizationRequest{
+			RedirectURI:         params.Get("redirect_uri"),
+			Scope:               params.Get("scope"),
+			Nonce:               params.Get("nonce"),
+			State:               params.Get("state"),
+			CodeChallenge:       params.Get("code_challenge"),
+			CodeChallengeMethod: params.Get("code_challenge_method"),
+		},
+		ClientID: params.Get("client_id"),
+	}
+
+	appID, err := strconv.Atoi(req.ClientID)
+	if err != nil {
+		http.Error(w, "invalid client_id", http.StatusBadRequest)
+
+		return authRequest{}, false
+	}
+	req.AppID = appID
+
+	if err := h.auth.AuthorizeClient(r.Context(), req.AuthorizationRequest); err != nil {
+		if errors.Is(err, auth.ErrInvalidClient) || errors.Is(err, auth.ErrInvalidRedirectURI) {
+			http.Error(w, "invalid client_id or redirect_uri", http.StatusBadRequest)
+
+			return authRequest{}, false
+		}
+
+		// redirect_uri уже проверен, ошибку можно вернуть приложению
+		if errors.Is(err, auth.ErrPKCERequired) || errors.Is(err, auth.ErrInvalidPKCE) {
+			redirectError(w, r, req, "invalid_request")
+
+			return authRequest{}, false
+		}
+
+		requestid.Logger(r.Context(), h.log).Error("failed to check authorization request", sl.Err(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+
+		return authRequest{}, false
+	}
+
+	return req, true
+}
+
+var pageTmpl = template.Must(template.New("authorize").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Log in</title></head>
+<body>
+{{if .Error}}<p>{{.Error}}</p>{{end}}
+<form method="post" action="/oauth/authorize">
+<input type="hidden" name="csrf_token" value="{{.CSRF}}">
+<input type="hidden" name="client_id" value="{{.Request.ClientID}}">
+<input type="hidden" name="redirect_uri" value="{{.Request.RedirectURI}}">
+<input type="hidden" name="state" value="{{.Request.State}}">
+<input type="hidden" name="scope" value="{{.Request.Scope}}">
+<input type="hidden" name="nonce" value="{{.Request.Nonce}}">
+<input type="hidden" name="code_challenge" value="{{.Request.CodeChallenge}}">
+<input type="hidden" name="code_challenge_method" value="{{.Request.CodeChallengeMethod}}">
+{{if .Ticket}}
+<input type="hidden" name="ticket" value="{{.Ticket}}">
+<p><select name="method">{{range .Methods}}<option value="{{.}}">{{.}}</option>{{end}}</select></p>
+<p><input name="code" autocomplete="one-time-code" placeholder="Code" required></p>
+{{else}}
+<p><input name="login" autocomplete="username" placeholder="Email, username or phone" required></p>
+<p><input name="password" type="password" autocomplete="current-password" placeholder="Password" required></p>
+{{end}}
+<p><button type="submit">Continue</button></p>
+{{if not .Ticket}}{{range .Providers}}
+<p><button type="submit" name="provider" value="{{.}}" formaction="/oauth/social" formnovalidate>Continue with {{.}}</button></p>
+{{end}}{{end}}
+</form>
+</body>
+</html>
+`))
+
+func (h *Handler) render(w http.ResponseWriter, r *http.Request, p page) {
+	p.Providers = h.auth.SocialProviders()
+	p.CSRF = csrfToken(w, r)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	if err := pageTmpl.Execute(w, p); err != nil {
+		h.log.Error("failed to render authorization page", sl.Err(err))
+	}
+}
+
+func redirectError(w http.ResponseWriter, r *http.Request, req authRequest, code string) {
+	q := url.Values{"error": {code}}
+	if req.State != "" {
+		q.Set("state", req.State)
+	}
+
+	http.Redirect(w, r, withQuery(req.RedirectURI, q), http.StatusFound)
+}
+
+// withQuery adds q to the query of the registered redirect URI, keeping its own parameters.
+func withQuery(rawURL string, q url.Values) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+
+	query := u.Query()
+	for k, v := range q {
+		query[k] = v
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func (h *Handler) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid_request"})
+
+		return
+	}
+
+	clientID, clientSecret, basic := clientCredentials(r)
+
+	appID, err := strconv.Atoi(clientID)
+	if err != nil {
+		h.invalidClient(w, basic)
+
+		return
+	}
+
+	var token, refreshToken, idToken, scope string
+
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		token, refreshToken, idToken, err = h.auth.ExchangeAuthorizationCode(
+			r.Context(), appID, clientSecret, r.PostForm.Get("code"), r.PostForm.Get("redirect_uri"), r.PostForm.Get("code_verifier"),
+		)
+	case "refresh_token":
+		token, refreshToken, err = h.auth.RefreshForClient(r.Context(), appID, clientSecret, r.PostForm.Get("refresh_token"))
+	case "client_credentials":
+		token, scope, err = h.auth.IssueServiceToken(r.Context(), appID, clientSecret, r.PostForm.Get("scope"))
+	default:
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "unsupported_grant_type"})
+
+		return
+	}
+
+	switch {
+	case err == nil:
+		// RFC 6749, 5.1: ответы с токенами не кешируются
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Pragma", "no-cache")
+		writeJSON(w, http.StatusOK, tokenResponse{AccessToken: token, TokenType: "Bearer", RefreshToken: refreshToken, IDToken: idToken, Scope: scope})
+	case errors.Is(err, auth.ErrInvalidClient):
+		h.invalidClient(w, basic)
+	case errors.Is(err, auth.ErrInvalidGrant), errors.Is(err, auth.ErrInvalidRefreshToken):
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid_grant"})
+	case errors.Is(err, auth.ErrUnauthorizedClient):
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "unauthorized_client"})
+	case errors.Is(err, auth.ErrInvalidScope):
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid_scope"})
+	default:
+		requestid.Logger(r.Context(), h.log).Error("failed to issue token", sl.Err(err))
+
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: "server_error"})
+	}
+}
+
+// clientCredentials reads client_secret_basic or, failing that, client_secret_post credentials.
+func clientCredentials(r *http.Request) (id string, secret string, basic bool) {
+	if id, secret, ok := r.BasicAuth(); ok {
+		// RFC 6749, 2.3.1: credentials are form-urlencoded before base64
+		if v, err := url.QueryUnescape(id); err == nil {
+			id = v
+		}
+		if v, err := url.QueryUnescape(secret); err == nil {
+			secret = v
+		}
+
+		return id, secret, true
+	}
+
+	return r.PostForm.Get("client_id"), r.PostForm.Get("client_secret"), false
+}
+
+func (h *Handler) invalidClient(w http.ResponseWriter, basic bool) {
+	if basic {
+		w.Header().Set("WWW-Authenticate", `Basic realm="sso"`)
+	}
+
+	writeJSON(w, http.StatusUnauthorized, errorResponse{Error: "invalid_client"})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,341 @@
+package oauth_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"sso/internal/domain/models"
+	"sso/internal/http/oauth"
+	"sso/internal/lib/ratelimit"
+	"sso/ssotest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const redirectURI = "https://app.example.com/callback"
+
+var csrfInput = regexp.MustCompile(`name="csrf_token" value="([^"]+)"`)
+
+func newHandler(t *testing.T) http.Handler {
+	t.Helper()
+
+	srv := ssotest.NewServer(t)
+	srv.Storage.AddApp(models.App{ID: ssotest.AppID, Name: ssotest.AppName, Secret: ssotest.AppSecret, RedirectURIs: []string{redirectURI}})
+
+	if _, err := srv.Auth.RegisterNewUser(context.Background(), "user@example.com", "correct-password", "", ""); err != nil {
+		t.Fatalf("RegisterNewUser() error = %v", err)
+	}
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	mux := http.NewServeMux()
+	oauth.New(log, srv.Auth, "", nil, ratelimit.New(), ratelimit.Limit{}).Register(mux)
+
+	return mux
+}
+
+// authParams are the parameters of the authorization request of the test app.
+func authParams() url.Values {
+	return url.Values{
+		"response_type": {"code"},
+		"client_id":     {strconv.Itoa(ssotest.AppID)},
+		"redirect_uri":  {redirectURI},
+		"state":         {"xyz"},
+	}
+}
+
+// loginForm opens the login page and returns the CSRF cookie and the token of the form.
+func loginForm(t *testing.T, h http.Handler) (*http.Cookie, string) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/oauth/authorize?"+authParams().Encode(), nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("form: status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	m := csrfInput.FindStringSubmatch(w.Body.String())
+	if m == nil {
+		t.Fatal("form has no csrf token")
+	}
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("cookies = %d, want 1", len(cookies))
+	}
+
+	return cookies[0], m[1]
+}
+
+func postForm(h http.Handler, path string, form url.Values, cookie *http.Cookie) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	return w
+}
+
+func TestAuthorizeCSRF(t *testing.T) {
+	h := newHandler(t)
+	cookie, token := loginForm(t, h)
+
+	if cookie.Value != token {
+		t.Errorf("form token %q doesn't match cookie %q", token, cookie.Value)
+	}
+	if !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode {
+		t.Errorf("cookie = %+v, want HttpOnly and SameSite=Lax", cookie)
+	}
+
+	tests := []struct {
+		name   string
+		cookie *http.Cookie
+		token  string
+		want   int
+	}{
+		{name: "no cookie", token: token, want: http.StatusForbidden},
+		{name: "no token", cookie: cookie, want: http.StatusForbidden},
+		{name: "other token", cookie: cookie, token: "forged", want: http.StatusForbidden},
+		{name: "valid", cookie: cookie, token: token, want: http.StatusFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := authParams()
+			form.Set("login", "user@example.com")
+			form.Set("password", "correct-password")
+			if tt.token != "" {
+				form.Set("csrf_token", tt.token)
+			}
+
+			w := postForm(h, "/oauth/authorize", form, tt.cookie)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+
+	t.Run("social", func(t *testing.T) {
+		form := authParams()
+		form.Set("provider", "google")
+
+		if w := postForm(h, "/oauth/social", form, cookie); w.Code != http.StatusForbidden {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusForbidden)
+		}
+	})
+}
+
+func TestTokenNoStore(t *testing.T) {
+	h := newHandler(t)
+	cookie, token := loginForm(t, h)
+
+	form := authParams()
+	form.Set("login", "user@example.com")
+	form.Set("password", "correct-password")
+	form.Set("csrf_token", token)
+
+	w := postForm(h, "/oauth/authorize", form, cookie)
+	if w.Code != http.StatusFound {
+		t.Fatalf("authorize: status = %d, want %d", w.Code, http.StatusFound)
+	}
+
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("parse location: %v", err)
+	}
+
+	w = postForm(h, "/oauth/token", url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {location.Query().Get("code")},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {strconv.Itoa(ssotest.AppID)},
+		"client_secret": {ssotest.AppSecret},
+	}, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("token: status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	if got := w.Header().Get("Cache-Control"); got != "no-store" {
+		t.Errorf("Cache-Control = %q, want %q", got, "no-store")
+	}
+	if got := w.Header().Get("Pragma"); got != "no-cache" {
+		t.Errorf("Pragma = %q, want %q", got, "no-cache")
+	}
+}
+
+func TestAuthorizeRequest(t *testing.T) {
+	h := newHandler(t)
+
+	tests := []struct {
+		name   string
+		params func(url.Values)
+		want   int
+		// wantError is the error sent to the redirect URI, empty if there is no redirect.
+		wantError string
+	}{
+		{name: "valid", params: func(url.Values) {}, want: http.StatusOK},
+		// Ошибки client_id и redirect_uri не отправляются по непроверенному адресу
+		{name: "invalid client_id", params: func(q url.Values) { q.Set("client_id", "abc") }, want: http.StatusBadRequest},
+		{name: "unknown client", params: func(q url.Values) { q.Set("client_id", "999") }, want: http.StatusBadRequest},
+		{name: "unregistered redirect_uri", params: func(q url.Values) { q.Set("redirect_uri", "https://evil.example/callback") }, want: http.StatusBadRequest},
+		{
+			name: "unsupported response_type", params: func(q url.Values) { q.Set("response_type", "token") },
+			want: http.StatusFound, wantError: "unsupported_response_type",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := authParams()
+			tt.params(q)
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/oauth/authorize?"+q.Encode(), nil))
+
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d", w.Code, tt.want)
+			}
+
+			location := w.Header().Get("Location")
+			if tt.wantError == "" {
+				if location != "" {
+					t.Errorf("redirected to %s", location)
+				}
+
+				return
+			}
+
+			u, err := url.Parse(location)
+			if err != nil {
+				t.Fatalf("parse location: %v", err)
+			}
+			if got := u.Scheme + "://" + u.Host + u.Path; got != redirectURI {
+				t.Errorf("redirected to %s, want %s", got, redirectURI)
+			}
+			if u.Query().Get("error") != tt.wantError || u.Query().Get("state") != "xyz" {
+				t.Errorf("query = %v, want error %s and state", u.Query(), tt.wantError)
+			}
+		})
+	}
+}
+
+func TestAuthorizeLogin(t *testing.T) {
+	h := newHandler(t)
+	cookie, token := loginForm(t, h)
+
+	form := authParams()
+	form.Set("csrf_token", token)
+	form.Set("login", "user@example.com")
+	form.Set("password", "wrong-password")
+
+	w := postForm(h, "/oauth/authorize", form, cookie)
+	if w.Code != http.StatusOK || w.Header().Get("Location") != "" {
+		t.Fatalf("wrong password: status = %d, location = %q, want the form again", w.Code, w.Header().Get("Location"))
+	}
+	if !strings.Contains(w.Body.String(), "Invalid login or password.") {
+		t.Error("wrong password: no error on the form")
+	}
+
+	form.Set("password", "correct-password")
+
+	w = postForm(h, "/oauth/authorize", form, cookie)
+	if w.Code != http.StatusFound {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusFound)
+	}
+
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("parse location: %v", err)
+	}
+	if location.Query().Get("code") == "" || location.Query().Get("state") != "xyz" {
+		t.Errorf("location = %s, want code and state", location)
+	}
+}
+
+func TestToken(t *testing.T) {
+	h := newHandler(t)
+
+	// authorize returns a fresh authorization code of the test app.
+	authorize := func() string {
+		t.Helper()
+
+		cookie, token := loginForm(t, h)
+
+		form := authParams()
+		form.Set("csrf_token", token)
+		form.Set("login", "user@example.com")
+		form.Set("password", "correct-password")
+
+		location, err := url.Parse(postForm(h, "/oauth/authorize", form, cookie).Header().Get("Location"))
+		if err != nil {
+			t.Fatalf("parse location: %v", err)
+		}
+
+		return location.Query().Get("code")
+	}
+
+	exchange := func(code string, form url.Values, basic [2]string) *httptest.ResponseRecorder {
+		form.Set("grant_type", "authorization_code")
+		form.Set("code", code)
+		form.Set("redirect_uri", redirectURI)
+
+		r := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if basic[0] != "" {
+			r.SetBasicAuth(basic[0], basic[1])
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		return w
+	}
+
+	appID := strconv.Itoa(ssotest.AppID)
+
+	t.Run("client_secret_basic", func(t *testing.T) {
+		if w := exchange(authorize(), url.Values{}, [2]string{appID, ssotest.AppSecret}); w.Code != http.StatusOK {
+			t.Errorf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+		}
+	})
+
+	t.Run("wrong secret", func(t *testing.T) {
+		w := exchange(authorize(), url.Values{}, [2]string{appID, "wrong"})
+		if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "invalid_client") {
+			t.Errorf("status = %d, body = %s, want 401 invalid_client", w.Code, w.Body)
+		}
+		if w.Header().Get("WWW-Authenticate") == "" {
+			t.Error("no WWW-Authenticate for basic credentials")
+		}
+	})
+
+	t.Run("code reused", func(t *testing.T) {
+		code := authorize()
+		form := func() url.Values { return url.Values{"client_id": {appID}, "client_secret": {ssotest.AppSecret}} }
+
+		if w := exchange(code, form(), [2]string{}); w.Code != http.StatusOK {
+			t.Fatalf("first exchange: status = %d, want %d", w.Code, http.StatusOK)
+		}
+		w := exchange(code, form(), [2]string{})
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "invalid_grant") {
+			t.Errorf("second exchange: status = %d, body = %s, want 400 invalid_grant", w.Code, w.Body)
+		}
+	})
+
+	t.Run("unsupported grant", func(t *testing.T) {
+		w := postForm(h, "/oauth/token", url.Values{"grant_type": {"password"}, "client_id": {appID}}, nil)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "unsupported_grant_type") {
+			t.Errorf("status = %d, body = %s, want 400 unsupported_grant_type", w.Code, w.Body)
+		}
+	})
+}
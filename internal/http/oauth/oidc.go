@@ -0,0 +1,86 @@
+package oauth
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/requestid"
+	"sso/internal/services/auth"
+	"strings"
+)
+
+// discovery serves OpenID Provider metadata (OpenID Connect Discovery 1.0).
+func (h *Handler) discovery(w http.ResponseWriter, _ *http.Request) {
+	algs := []string{"HS256"}
+	for _, k := range h.keys {
+		if alg := k.Method.Alg(); !slices.Contains(algs, alg) {
+			algs = append(algs, alg)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                h.issuer,
+		"authorization_endpoint":                h.issuer + "/oauth/authorize",
+		"token_endpoint":                        h.issuer + "/oauth/token",
+		"userinfo_endpoint":                     h.issuer + "/oauth/userinfo",
+		"jwks_uri":                              h.issuer + "/.well-known/jwks.json",
+		"scopes_supported":                      []string{"openid", "email", "phone", "profile"},
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code", "refresh_token", "client_credentials"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": algs,
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":      []string{"S256", "plain"},
+		"claims_supported": []string{
+			"iss", "sub", "aud", "exp", "iat", "nonce",
+			"email", "preferred_username", "phone_number", "phone_number_verified", "picture",
+		},
+	})
+}
+
+// jwks publishes public keys of apps signing tokens with RS256/ES256.
+// Tokens of other apps are signed with the app secret and have no public key.
+func (h *Handler) jwks(w http.ResponseWriter, _ *http.Request) {
+	keys := make([]jwt.JWK, 0, len(h.keys))
+	for _, k := range h.keys {
+		jwk := k.JWK()
+		// Один ключ может быть настроен для нескольких приложений
+		if !slices.ContainsFunc(keys, func(j jwt.JWK) bool { return j.Kid == jwk.Kid }) {
+			keys = append(keys, jwk)
+		}
+	}
+	slices.SortFunc(keys, func(a, b jwt.JWK) int { return strings.Compare(a.Kid, b.Kid) })
+
+	writeJSON(w, http.StatusOK, map[string]any{"keys": keys})
+}
+
+// userInfo returns claims of the access token owner (RFC 6750 bearer token).
+func (h *Handler) userInfo(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="sso"`)
+		w.WriteHeader(http.StatusUnauthorized)
+
+		return
+	}
+
+	user, err := h.auth.UserInfo(r.Context(), token)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidToken) || errors.Is(err, auth.ErrTokenRevoked) || errors.Is(err, auth.ErrUserNotFound) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="sso", error="invalid_token"`)
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		requestid.Logger(r.Context(), h.log).Error("failed to get user info", sl.Err(err))
+
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: "server_error"})
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, jwt.UserInfo(user))
+}
@@ -0,0 +1,70 @@
+package oauth
+
+import (
+	"errors"
+	"net/http"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/requestid"
+	"sso/internal/services/auth"
+	"strconv"
+)
+
+// beginSocial sends the user to the identity provider chosen on the login page.
+func (h *Handler) beginSocial(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+
+		return
+	}
+
+	req, ok := h.authRequest(w, r, r.PostForm)
+	if !ok {
+		return
+	}
+
+	if !checkCSRF(r) {
+		http.Error(w, "invalid csrf token", http.StatusForbidden)
+
+		return
+	}
+
+	target, err := h.auth.BeginSocialLogin(r.Context(), r.PostForm.Get("provider"), req.AuthorizationRequest)
+	if err != nil {
+		if errors.Is(err, auth.ErrUnknownProvider) {
+			h.render(w, r, page{Request: req, Error: "Unknown identity provider."})
+
+			return
+		}
+
+		requestid.Logger(r.Context(), h.log).Error("failed to start social login", sl.Err(err))
+
+		redirectError(w, r, req, "server_error")
+
+		return
+	}
+
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// finishSocial is the callback registered at identity providers.
+func (h *Handler) finishSocial(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	code, areq, err := h.auth.FinishSocialLogin(r.Context(), r.PathValue("provider"), q.Get("state"), q.Get("code"))
+	if areq.RedirectURI == "" {
+		// Без state не знаем, куда вернуть пользователя
+		http.Error(w, "invalid or expired login, start again", http.StatusBadRequest)
+
+		return
+	}
+
+	req := authRequest{AuthorizationRequest: areq, ClientID: strconv.Itoa(areq.AppID)}
+
+	if q.Get("error") != "" {
+		h.render(w, r, page{Request: req, Error: "Login with the identity provider was cancelled."})
+
+		return
+	}
+
+	h.finishAuthorize(w, r, req, code, err)
+}
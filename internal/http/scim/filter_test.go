@@ -0,0 +1,68 @@
+package scim
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    filter
+		wantErr bool
+	}{
+		{name: "empty", in: "  "},
+		{name: "eq", in: `userName eq "ann@example.com"`, want: filter{eq: "ann@example.com"}},
+		{name: "case insensitive", in: `USERNAME EQ "ann@example.com"`, want: filter{eq: "ann@example.com"}},
+		{name: "emails value", in: `emails.value eq "ann@example.com"`, want: filter{eq: "ann@example.com"}},
+		{name: "sw", in: `userName sw "ann"`, want: filter{prefix: "ann"}},
+		{name: "escaped quote", in: `userName eq "a\"b@example.com"`, want: filter{eq: `a"b@example.com`}},
+		{name: "unicode escape", in: `userName sw "\u0061nn"`, want: filter{prefix: "ann"}},
+		{name: "unsupported operator", in: `userName co "ann"`, wantErr: true},
+		{name: "unsupported attribute", in: `displayName eq "Ann"`, wantErr: true},
+		{name: "logical expression", in: `userName eq "a" or userName eq "b"`, wantErr: true},
+		{name: "unquoted", in: `userName eq ann`, wantErr: true},
+		{name: "bad escape", in: `userName eq "\x"`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseFilter(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseFilter() error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseFilter() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseBool(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    bool
+		wantErr bool
+	}{
+		{in: `true`, want: true},
+		{in: `false`},
+		{in: `"True"`, want: true},
+		{in: `"False"`},
+		{in: `"yes"`, wantErr: true},
+		{in: `1.5`, wantErr: true},
+		{in: `null`},
+	}
+
+	for _, tt := range tests {
+		got, err := parseBool(json.RawMessage(tt.in))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseBool(%s) error = %v, want error %v", tt.in, err, tt.wantErr)
+
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseBool(%s) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,524 @@
+// Package scim serves the Users resource of SCIM 2.0 (RFC 7643, RFC 7644), so
+// that corporate directories like Okta and Azure AD can provision accounts.
+// Clients authenticate with an API key of the admin role as the bearer token.
+package scim
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"sso/internal/domain/models"
+	"sso/internal/lib/caller"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/requestid"
+	"sso/internal/services/auth"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	userSchema     = "urn:ietf:params:scim:schemas:core:2.0:User"
+	listSchema     = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	errorSchema    = "urn:ietf:params:scim:api:messages:2.0:Error"
+	contentType    = "application/scim+json"
+	usersPath      = "/scim/v2/Users"
+	maxListResults = 100
+)
+
+type Auth interface {
+	AuthenticateAPIKey(ctx context.Context, key string) (models.APIKey, error)
+	ProvisionUser(ctx context.Context, email string) (models.User, error)
+	GetUser(ctx context.Context, userID int64, email string) (models.User, error)
+	ListUsers(ctx context.Context, filter models.UserFilter, sort models.UserSort, pageToken string, limit int) ([]models.User, string, error)
+	CountUsers(ctx context.Context, filter models.UserFilter) (int64, error)
+	SuspendUser(ctx context.Context, userID int64, status string) error
+	ReinstateUser(ctx context.Context, userID int64) error
+	DeleteUser(ctx context.Context, userID int64, anonymize bool) error
+}
+
+type Handler struct {
+	log  *slog.Logger
+	auth Auth
+}
+
+func New(log *slog.Logger, auth Auth) *Handler {
+	return &Handler{log: log, auth: auth}
+}
+
+// Register adds the endpoints to mux.
+func (h *Handler) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET "+usersPath, h.authenticated(h.listUsers))
+	mux.HandleFunc("POST "+usersPath, h.authenticated(h.createUser))
+	mux.HandleFunc("GET "+usersPath+"/{id}", h.authenticated(h.getUser))
+	mux.HandleFunc("PATCH "+usersPath+"/{id}", h.authenticated(h.patchUser))
+	mux.HandleFunc("DELETE "+usersPath+"/{id}", h.authenticated(h.deleteUser))
+}
+
+type user struct {
+	Schemas  []string `json:"schemas"`
+	ID       string   `json:"id,omitempty"`
+	UserName string   `json:"userName"`
+	Active   *bool    `json:"active,omitempty"`
+	Emails   []email  `json:"emails,omitempty"`
+	Meta     *meta    `json:"meta,omitempty"`
+}
+
+type email struct {
+	Value   string `json:"value"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type meta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	Location     string    `json:"location"`
+}
+
+type listResponse struct {
+	Schemas      []string `json:"schemas"`
+	TotalResults int64    `json:"totalResults"`
+	StartIndex   int      `json:"startIndex"`
+	ItemsPerPage int      `json:"itemsPerPage"`
+	Resources    []user   `json:"Resources"`
+}
+
+type patchRequest struct {
+	Schemas    []string `json:"schemas"`
+	Operations []struct {
+		Op    string          `json:"op"`
+		Path  string          `json:"path"`
+		Value json.RawMessage `json:"value"`
+	} `json:"Operations"`
+}
+
+// authenticated lets requests through with an API key of the admin role,
+// putting the key into the context as the caller like the gRPC interceptors.
+func (h *Handler) authenticated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			writeError(w, http.StatusUnauthorized, "", "bearer token required")
+
+			return
+		}
+
+		key, err := h.auth.AuthenticateAPIKey(r.Context(), token)
+		if err != nil {
+			if errors.Is(err, auth.ErrInvalidAPIKey) {
+				writeError(w, http.StatusUnauthorized, "", "invalid bearer token")
+
+				return
+			}
+
+			h.internalError(w, r, err)
+
+			return
+		}
+
+		if key.Role != auth.AdminRole {
+			writeError(w, http.StatusForbidden, "", "admin role required")
+
+			return
+		}
+
+		ctx := caller.WithCaller(r.Context(), caller.Caller{AppID: key.AppID, Role: key.Role, APIKeyID: key.ID, OrgID: key.OrgID})
+
+		next(w, r.WithContext(ctx))
+	}
+}
+
+func (h *Handler) listUsers(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	startIndex, ok := queryInt(w, q.Get("startIndex"), 1)
+	if !ok {
+		return
+	}
+	count, ok := queryInt(w, q.Get("count"), maxListResults)
+	if !ok {
+		return
+	}
+	startIndex = max(startIndex, 1)
+	count = min(max(count, 0), maxListResults)
+
+	f, err := parseFilter(q.Get("filter"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalidFilter", err.Error())
+
+		return
+	}
+
+	resp := listResponse{Schemas: []string{listSchema}, StartIndex: startIndex, Resources: []user{}}
+
+	// Точное совпадение userName ищем по email, без обхода списка
+	if f.eq != "" {
+		u, err := h.auth.GetUser(r.Context(), 0, f.eq)
+		switch {
+		case err == nil && u.DeletedAt.IsZero():
+			resp.TotalResults = 1
+			if startIndex == 1 && count > 0 {
+				resp.Resources = append(resp.Resources, resource(u))
+			}
+		case err == nil, errors.Is(err, auth.ErrUserNotFound):
+		default:
+			h.internalError(w, r, err)
+
+			return
+		}
+
+		resp.ItemsPerPage = len(resp.Resources)
+		writeJSON(w, http.StatusOK, resp)
+
+		return
+	}
+
+	filter := models.UserFilter{EmailPrefix: f.prefix}
+
+	resp.TotalResults, err = h.auth.CountUsers(r.Context(), filter)
+	if err != nil {
+		h.internalError(w, r, err)
+
+		return
+	}
+
+	users, err := h.page(r.Context(), filter, startIndex-1, count)
+	if err != nil {
+		h.internalError(w, r, err)
+
+		return
+	}
+	for _, u := range users {
+		resp.Resources = append(resp.Resources, resource(u))
+	}
+
+	resp.ItemsPerPage = len(resp.Resources)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// page returns count users after skipping offset ones. SCIM pages by index,
+// while ListUsers pages by token, so skipped pages are read and dropped.
+func (h *Handler) page(ctx context.Context, filter models.UserFilter, offset int, count int) ([]models.User, error) {
+	var (
+		result []models.User
+		token  string
+	)
+
+	for len(result) < count {
+		users, next, err := h.auth.ListUsers(ctx, filter, models.UserSort{}, token, maxListResults)
+		if err != nil {
+			return nil, err
+		}
+
+		if offset >= len(users) {
+			offset -= len(users)
+		} else {
+			users = users[offset:]
+			offset = 0
+			result = append(result, users[:min(len(users), count-len(result))]...)
+		}
+
+		if next == "" {
+			break
+		}
+		token = next
+	}
+
+	return result, nil
+}
+
+func (h *Handler) createUser(w http.ResponseWriter, r *http.Request) {
+	var req user
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalidSyntax", "invalid json")
+
+		return
+	}
+
+	// userName обычно и есть email, иначе берём основной из emails
+	addr := req.UserName
+	if !strings.Contains(addr, "@") {
+		for _, e := range req.Emails {
+			if e.Primary || !strings.Contains(addr, "@") {
+				addr = e.Value
+			}
+		}
+	}
+
+	u, err := h.auth.ProvisionUser(r.Context(), addr)
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInvalidEmail):
+			writeError(w, http.StatusBadRequest, "invalidValue", "userName must be an email")
+		case errors.Is(err, auth.ErrUserExists):
+			writeError(w, http.StatusConflict, "uniqueness", "user already exists")
+		default:
+			h.internalError(w, r, err)
+		}
+
+		return
+	}
+
+	if req.Active != nil && !*req.Active {
+		if err := h.auth.SuspendUser(r.Context(), u.ID, models.UserStatusSuspended); err != nil {
+			h.internalError(w, r, err)
+
+			return
+		}
+		u.Status = models.UserStatusSuspended
+	}
+
+	w.Header().Set("Location", location(u.ID))
+	writeJSON(w, http.StatusCreated, resource(u))
+}
+
+func (h *Handler) getUser(w http.ResponseWriter, r *http.Request) {
+	u, ok := h.user(w, r)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, resource(u))
+}
+
+// patchUser supports replacing active, which suspends or reinstates the user.
+// Attributes the SSO doesn't keep, like name, are ignored; userName and
+// emails can't be changed.
+func (h *Handler) patchUser(w http.ResponseWriter, r *http.Request) {
+	u, ok := h.user(w, r)
+	if !ok {
+		return
+	}
+
+	var req patchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalidSyntax", "invalid json")
+
+		return
+	}
+
+	var active *bool
+	for _, op := range req.Operations {
+		if !strings.EqualFold(op.Op, "replace") && !strings.EqualFold(op.Op, "add") {
+			writeError(w, http.StatusBadRequest, "invalidPath", fmt.Sprintf("unsupported operation %q", op.Op))
+
+			return
+		}
+
+		attrs := map[string]json.RawMessage{op.Path: op.Value}
+		if op.Path == "" {
+			// Azure AD присылает атрибуты объектом без path
+			if err := json.Unmarshal(op.Value, &attrs); err != nil {
+				writeError(w, http.StatusBadRequest, "invalidValue", "value must be an object without path")
+
+				return
+			}
+		}
+
+		for attr, value := range attrs {
+			switch strings.ToLower(attr) {
+			case "active":
+				v, err := parseBool(value)
+				if err != nil {
+					writeError(w, http.StatusBadRequest, "invalidValue", "active must be a boolean")
+
+					return
+				}
+				active = &v
+			case "username", "emails":
+				writeError(w, http.StatusBadRequest, "mutability", attr+" can't be changed")
+
+				return
+			}
+		}
+	}
+
+	if active != nil && *active != isActive(u) {
+		var err error
+		if *active {
+			err = h.auth.ReinstateUser(r.Context(), u.ID)
+			u.Status = models.UserStatusActive
+		} else {
+			err = h.auth.SuspendUser(r.Context(), u.ID, models.UserStatusSuspended)
+			u.Status = models.UserStatusSuspended
+		}
+		if err != nil {
+			h.internalError(w, r, err)
+
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, resource(u))
+}
+
+func (h *Handler) deleteUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.auth.DeleteUser(r.Context(), id, false); err != nil {
+		if errors.Is(err, auth.ErrUserNotFound) {
+			writeError(w, http.StatusNotFound, "", "user not found")
+
+			return
+		}
+
+		h.internalError(w, r, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// user returns the user of the {id} path value; soft-deleted users are not found.
+func (h *Handler) user(w http.ResponseWriter, r *http.Request) (models.User, bool) {
+	id, ok := userID(w, r)
+	if !ok {
+		return models.User{}, false
+	}
+
+	u, err := h.auth.GetUser(r.Context(), id, "")
+	if err == nil && !u.DeletedAt.IsZero() {
+		err = auth.ErrUserNotFound
+	}
+	if err != nil {
+		if errors.Is(err, auth.ErrUserNotFound) {
+			writeError(w, http.StatusNotFound, "", "user not found")
+		} else {
+			h.internalError(w, r, err)
+		}
+
+		return models.User{}, false
+	}
+
+	return u, true
+}
+
+func (h *Handler) internalError(w http.ResponseWriter, r *http.Request, err error) {
+	requestid.Logger(r.Context(), h.log).Error("scim request failed", slog.String("path", r.URL.Path), sl.Err(err))
+
+	writeError(w, http.StatusInternalServerError, "", "internal error")
+}
+
+func resource(u models.User) user {
+	active := isActive(u)
+
+	res := user{
+		Schemas:  []string{userSchema},
+		ID:       strconv.FormatInt(u.ID, 10),
+		UserName: u.Email,
+		Active:   &active,
+		Meta: &meta{
+			ResourceType: "User",
+			Created:      u.CreatedAt,
+			Location:     location(u.ID),
+		},
+	}
+	if u.Email != "" {
+		res.Emails = []email{{Value: u.Email, Primary: true}}
+	}
+
+	return res
+}
+
+func isActive(u models.User) bool {
+	return u.Status != models.UserStatusSuspended && u.Status != models.UserStatusBanned
+}
+
+func location(id int64) string {
+	return usersPath + "/" + strconv.FormatInt(id, 10)
+}
+
+// filter is a parsed SCIM filter: userName eq or sw a value, the only ones supported.
+type filter struct {
+	eq     string
+	prefix string
+}
+
+var filterRe = regexp.MustCompile(`(?i)^(userName|emails\.value|emails)\s+(eq|sw)\s+"((?:[^"\\]|\\.)*)"$`)
+
+func parseFilter(s string) (filter, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return filter{}, nil
+	}
+
+	m := filterRe.FindStringSubmatch(s)
+	if m == nil {
+		return filter{}, errors.New(`only userName eq "..." and userName sw "..." filters are supported`)
+	}
+
+	var value string
+	if err := json.Unmarshal([]byte(`"`+m[3]+`"`), &value); err != nil {
+		return filter{}, errors.New("invalid filter value")
+	}
+
+	if strings.EqualFold(m[2], "eq") {
+		return filter{eq: value}, nil
+	}
+
+	return filter{prefix: value}, nil
+}
+
+// parseBool accepts JSON booleans and, as Azure AD sends them, strings "True" and "False".
+func parseBool(raw json.RawMessage) (bool, error) {
+	var v bool
+	if err := json.Unmarshal(raw, &v); err == nil {
+		return v, nil
+	}
+
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return false, err
+	}
+
+	return strconv.ParseBool(s)
+}
+
+func queryInt(w http.ResponseWriter, s string, def int) (int, bool) {
+	if s == "" {
+		return def, true
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalidValue", "invalid number "+strconv.Quote(s))
+
+		return 0, false
+	}
+
+	return n, true
+}
+
+func userID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		writeError(w, http.StatusNotFound, "", "user not found")
+
+		return 0, false
+	}
+
+	return id, true
+}
+
+func writeError(w http.ResponseWriter, code int, scimType string, detail string) {
+	writeJSON(w, code, struct {
+		Schemas  []string `json:"schemas"`
+		Status   string   `json:"status"`
+		ScimType string   `json:"scimType,omitempty"`
+		Detail   string   `json:"detail"`
+	}{[]string{errorSchema}, strconv.Itoa(code), scimType, detail})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
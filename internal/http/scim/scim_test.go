@@ -0,0 +1,273 @@
+package scim_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sso/internal/domain/models"
+	"sso/internal/http/scim"
+	"sso/internal/services/auth"
+	"sso/ssotest"
+	"strconv"
+	"testing"
+)
+
+type listResponse struct {
+	TotalResults int64 `json:"totalResults"`
+	ItemsPerPage int   `json:"itemsPerPage"`
+	Resources    []struct {
+		ID       string `json:"id"`
+		UserName string `json:"userName"`
+		Active   bool   `json:"active"`
+	} `json:"Resources"`
+}
+
+type errorResponse struct {
+	ScimType string `json:"scimType"`
+}
+
+// newHandler returns the SCIM endpoints and an API key of the admin role.
+func newHandler(t *testing.T, srv *ssotest.Server) (http.Handler, string) {
+	t.Helper()
+
+	key, _, err := srv.Auth.CreateAPIKey(context.Background(), ssotest.AppID, "scim", auth.AdminRole)
+	if err != nil {
+		t.Fatalf("CreateAPIKey() error = %v", err)
+	}
+
+	mux := http.NewServeMux()
+	scim.New(slog.New(slog.NewTextHandler(io.Discard, nil)), srv.Auth).Register(mux)
+
+	return mux, key
+}
+
+// do sends the request and decodes the response into out, if not nil.
+func do(t *testing.T, h http.Handler, method string, path string, key string, body any, out any) int {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatalf("encode body: %v", err)
+		}
+	}
+
+	r := httptest.NewRequest(method, path, &buf)
+	if key != "" {
+		r.Header.Set("Authorization", "Bearer "+key)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if out != nil {
+		if err := json.NewDecoder(w.Body).Decode(out); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+	}
+
+	return w.Code
+}
+
+// provision creates the user over SCIM and returns the id.
+func provision(t *testing.T, h http.Handler, key string, email string) int64 {
+	t.Helper()
+
+	var created struct {
+		ID string `json:"id"`
+	}
+	if code := do(t, h, http.MethodPost, "/scim/v2/Users", key, map[string]string{"userName": email}, &created); code != http.StatusCreated {
+		t.Fatalf("create %s: status = %d, want %d", email, code, http.StatusCreated)
+	}
+
+	id, err := strconv.ParseInt(created.ID, 10, 64)
+	if err != nil {
+		t.Fatalf("parse id %q: %v", created.ID, err)
+	}
+
+	return id
+}
+
+func TestAuthentication(t *testing.T) {
+	srv := ssotest.NewServer(t)
+	h, _ := newHandler(t, srv)
+
+	userKey, _, err := srv.Auth.CreateAPIKey(context.Background(), ssotest.AppID, "user", "user")
+	if err != nil {
+		t.Fatalf("CreateAPIKey() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		key  string
+		want int
+	}{
+		{name: "no key", want: http.StatusUnauthorized},
+		{name: "unknown key", key: "bogus", want: http.StatusUnauthorized},
+		{name: "not admin", key: userKey, want: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := do(t, h, http.MethodGet, "/scim/v2/Users", tt.key, nil, nil); code != tt.want {
+				t.Errorf("status = %d, want %d", code, tt.want)
+			}
+		})
+	}
+}
+
+func TestListUsersFilter(t *testing.T) {
+	srv := ssotest.NewServer(t)
+	h, key := newHandler(t, srv)
+
+	for _, email := range []string{"ann@example.com", "anna@example.com", "bob@example.com", "an_x@example.com"} {
+		provision(t, h, key, email)
+	}
+
+	tests := []struct {
+		name      string
+		query     url.Values
+		wantTotal int64
+		wantNames []string
+	}{
+		{name: "all", query: url.Values{}, wantTotal: 4},
+		{
+			name:      "eq",
+			query:     url.Values{"filter": {`userName eq "bob@example.com"`}},
+			wantTotal: 1, wantNames: []string{"bob@example.com"},
+		},
+		{name: "eq unknown", query: url.Values{"filter": {`userName eq "nobody@example.com"`}}},
+		{
+			name:      "sw",
+			query:     url.Values{"filter": {`userName sw "ann"`}},
+			wantTotal: 2, wantNames: []string{"ann@example.com", "anna@example.com"},
+		},
+		{
+			// _ в значении не работает как шаблон LIKE
+			name:      "sw wildcard",
+			query:     url.Values{"filter": {`userName sw "an_"`}},
+			wantTotal: 1, wantNames: []string{"an_x@example.com"},
+		},
+		{
+			name:      "page",
+			query:     url.Values{"filter": {`userName sw "ann"`}, "startIndex": {"2"}, "count": {"1"}},
+			wantTotal: 2, wantNames: []string{"anna@example.com"},
+		},
+		{
+			name:      "eq past first page",
+			query:     url.Values{"filter": {`userName eq "bob@example.com"`}, "startIndex": {"2"}},
+			wantTotal: 1, wantNames: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resp listResponse
+			if code := do(t, h, http.MethodGet, "/scim/v2/Users?"+tt.query.Encode(), key, nil, &resp); code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", code, http.StatusOK)
+			}
+
+			if resp.TotalResults != tt.wantTotal {
+				t.Errorf("totalResults = %d, want %d", resp.TotalResults, tt.wantTotal)
+			}
+			if resp.ItemsPerPage != len(resp.Resources) {
+				t.Errorf("itemsPerPage = %d, want %d", resp.ItemsPerPage, len(resp.Resources))
+			}
+
+			if tt.wantNames == nil {
+				return
+			}
+
+			var names []string
+			for _, u := range resp.Resources {
+				names = append(names, u.UserName)
+			}
+			if len(names) != len(tt.wantNames) {
+				t.Fatalf("users = %v, want %v", names, tt.wantNames)
+			}
+			for i := range names {
+				if names[i] != tt.wantNames[i] {
+					t.Errorf("users = %v, want %v", names, tt.wantNames)
+				}
+			}
+		})
+	}
+
+	t.Run("invalid filter", func(t *testing.T) {
+		var resp errorResponse
+		code := do(t, h, http.MethodGet, "/scim/v2/Users?filter="+url.QueryEscape(`displayName co "A"`), key, nil, &resp)
+		if code != http.StatusBadRequest || resp.ScimType != "invalidFilter" {
+			t.Errorf("status, scimType = %d, %q, want %d, %q", code, resp.ScimType, http.StatusBadRequest, "invalidFilter")
+		}
+	})
+}
+
+func TestPatchUser(t *testing.T) {
+	srv := ssotest.NewServer(t)
+	h, key := newHandler(t, srv)
+
+	id := provision(t, h, key, "ann@example.com")
+	path := "/scim/v2/Users/" + strconv.FormatInt(id, 10)
+
+	op := func(op string, path string, value any) map[string]any {
+		return map[string]any{
+			"schemas":    []string{"urn:ietf:params:scim:api:messages:2.0:PatchOp"},
+			"Operations": []map[string]any{{"op": op, "path": path, "value": value}},
+		}
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		body       any
+		want       int
+		wantType   string
+		wantStatus string
+	}{
+		{name: "deactivate", path: path, body: op("replace", "active", false), want: http.StatusOK, wantStatus: models.UserStatusSuspended},
+		{name: "deactivate again", path: path, body: op("replace", "active", false), want: http.StatusOK, wantStatus: models.UserStatusSuspended},
+		{
+			// Azure AD: атрибуты объектом без path, булевы строкой
+			name: "activate without path", path: path, body: op("Replace", "", map[string]any{"active": "True"}),
+			want: http.StatusOK, wantStatus: models.UserStatusActive,
+		},
+		{name: "add active", path: path, body: op("add", "active", false), want: http.StatusOK, wantStatus: models.UserStatusSuspended},
+		{name: "ignored attribute", path: path, body: op("replace", "name.givenName", "Ann"), want: http.StatusOK, wantStatus: models.UserStatusSuspended},
+		{name: "remove", path: path, body: op("remove", "active", nil), want: http.StatusBadRequest, wantType: "invalidPath"},
+		{name: "userName", path: path, body: op("replace", "userName", "bob@example.com"), want: http.StatusBadRequest, wantType: "mutability"},
+		{name: "emails without path", path: path, body: op("replace", "", map[string]any{"emails": []any{}}), want: http.StatusBadRequest, wantType: "mutability"},
+		{name: "invalid active", path: path, body: op("replace", "active", "maybe"), want: http.StatusBadRequest, wantType: "invalidValue"},
+		{name: "value not object", path: path, body: op("replace", "", true), want: http.StatusBadRequest, wantType: "invalidValue"},
+		{name: "unknown user", path: "/scim/v2/Users/999999", body: op("replace", "active", false), want: http.StatusNotFound},
+		{name: "invalid id", path: "/scim/v2/Users/abc", body: op("replace", "active", false), want: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resp errorResponse
+			if code := do(t, h, http.MethodPatch, tt.path, key, tt.body, &resp); code != tt.want {
+				t.Fatalf("status = %d, want %d", code, tt.want)
+			}
+			if resp.ScimType != tt.wantType {
+				t.Errorf("scimType = %q, want %q", resp.ScimType, tt.wantType)
+			}
+
+			if tt.wantStatus == "" {
+				return
+			}
+
+			u, err := srv.Storage.UserByID(context.Background(), id)
+			if err != nil {
+				t.Fatalf("UserByID() error = %v", err)
+			}
+			if u.Status != tt.wantStatus {
+				t.Errorf("status = %q, want %q", u.Status, tt.wantStatus)
+			}
+		})
+	}
+}
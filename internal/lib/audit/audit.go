@@ -0,0 +1,36 @@
+// Package audit carries the reason of sensitive operations to the audit log.
+package audit
+
+import (
+	"context"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// ReasonHeader is the metadata key with the reason of the operation, e.g. a ticket number.
+const ReasonHeader = "x-audit-reason"
+
+type ctxKey struct{}
+
+// WithReason sets the reason for calls made outside gRPC.
+func WithReason(ctx context.Context, reason string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, reason)
+}
+
+// Reason returns the reason set by WithReason or sent in ReasonHeader, if any.
+func Reason(ctx context.Context) string {
+	if reason, ok := ctx.Value(ctxKey{}).(string); ok {
+		return reason
+	}
+
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	if v := md.Get(ReasonHeader); len(v) > 0 {
+		return v[0]
+	}
+
+	return ""
+}
@@ -0,0 +1,101 @@
+// Package breaker stops calls to a failing dependency for a while, so that
+// they fail fast instead of piling up waiting for it.
+package breaker
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// ErrOpen is returned by Allow while calls are stopped.
+var ErrOpen = errors.New("circuit breaker is open")
+
+// Breaker opens after threshold consecutive failures and rejects calls for
+// openFor. Then it lets a single probe through: its success closes the
+// breaker, its failure opens it again. A nil Breaker allows every call.
+type Breaker struct {
+	log       *slog.Logger
+	threshold int
+	openFor   time.Duration
+
+	mu       sync.Mutex
+	failures int
+	// openedAt is zero while the breaker is closed.
+	openedAt time.Time
+	probing  bool
+}
+
+func New(log *slog.Logger, threshold int, openFor time.Duration) *Breaker {
+	return &Breaker{log: log, threshold: threshold, openFor: openFor}
+}
+
+// Allow returns ErrOpen if the call must not be made. Otherwise the outcome
+// of the call must be reported by Done.
+func (b *Breaker) Allow() error {
+	if b == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.openedAt.IsZero() {
+		return nil
+	}
+
+	if b.probing || time.Since(b.openedAt) < b.openFor {
+		return ErrOpen
+	}
+
+	b.probing = true
+
+	return nil
+}
+
+// Done records the outcome of a call let through by Allow.
+func (b *Breaker) Done(failed bool) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	wasProbe := b.probing
+	b.probing = false
+
+	if !failed {
+		if !b.openedAt.IsZero() {
+			b.log.Info("circuit breaker closed")
+		}
+
+		b.failures = 0
+		b.openedAt = time.Time{}
+
+		return
+	}
+
+	b.failures++
+	if wasProbe || b.openedAt.IsZero() && b.failures >= b.threshold {
+		if !wasProbe {
+			b.log.Warn("circuit breaker opened", slog.Int("failures", b.failures), slog.Duration("open_for", b.openFor))
+		}
+
+		b.openedAt = time.Now()
+	}
+}
+
+// Open reports whether calls are rejected now. It is false once a probe may
+// be made, so that callers checking it let the probe through.
+func (b *Breaker) Open() bool {
+	if b == nil {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return !b.openedAt.IsZero() && time.Since(b.openedAt) < b.openFor
+}
@@ -0,0 +1,51 @@
+// Package caller carries the authenticated caller of a request in its context.
+package caller
+
+import "context"
+
+// Caller is who makes the request, as established by an authenticating interceptor.
+type Caller struct {
+	// UserID is zero for machine clients.
+	UserID int64
+	AppID  int
+	Role   string
+	// APIKeyID is set if the caller authenticated with an API key.
+	APIKeyID int64
+	// OrgID limits the caller to users of the organization; zero for platform callers.
+	OrgID int64
+}
+
+type ctxKey struct{}
+
+func WithCaller(ctx context.Context, c Caller) context.Context {
+	if r, ok := ctx.Value(recorderKey{}).(*recorder); ok {
+		r.caller, r.ok = c, true
+	}
+
+	return context.WithValue(ctx, ctxKey{}, c)
+}
+
+type recorderKey struct{}
+
+type recorder struct {
+	caller Caller
+	ok     bool
+}
+
+// Track returns ctx in which the caller set by WithCaller further down the
+// chain is also reported by the returned func, so that outer interceptors,
+// e.g. the access log, know who a request was authenticated as.
+func Track(ctx context.Context) (context.Context, func() (Caller, bool)) {
+	r := &recorder{}
+
+	return context.WithValue(ctx, recorderKey{}, r), func() (Caller, bool) {
+		return r.caller, r.ok
+	}
+}
+
+// FromContext returns the caller, if the request was authenticated.
+func FromContext(ctx context.Context) (Caller, bool) {
+	c, ok := ctx.Value(ctxKey{}).(Caller)
+
+	return c, ok
+}
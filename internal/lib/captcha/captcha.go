@@ -0,0 +1,48 @@
+// Package captcha verifies captcha tokens solved by users on the client.
+package captcha
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// TokenHeader is the metadata key with the captcha token, since requests have no field for it.
+const TokenHeader = "x-captcha-token"
+
+// FormFields are names of the token field in forms posted by the widgets of supported providers.
+var FormFields = []string{"g-recaptcha-response", "h-captcha-response", "cf-turnstile-response"}
+
+// ErrRejected is returned when the provider considers the token invalid, expired or reused.
+var ErrRejected = errors.New("captcha token rejected")
+
+// Verifier checks a captcha token with the provider. remoteIP is optional.
+type Verifier interface {
+	Verify(ctx context.Context, token string, remoteIP string) error
+}
+
+type ctxKey struct{}
+
+// WithToken sets the token for calls made outside gRPC.
+func WithToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, token)
+}
+
+// Token returns the token set by WithToken or sent in TokenHeader, if any.
+func Token(ctx context.Context) string {
+	if token, ok := ctx.Value(ctxKey{}).(string); ok {
+		return token
+	}
+
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	if v := md.Get(TokenHeader); len(v) > 0 {
+		return v[0]
+	}
+
+	return ""
+}
@@ -0,0 +1,103 @@
+package captcha
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// siteVerifier posts the token to a siteverify endpoint. reCAPTCHA, hCaptcha
+// and Turnstile share the same request and response format.
+type siteVerifier struct {
+	name     string
+	url      string
+	secret   string
+	minScore float64
+	http     *http.Client
+}
+
+// NewReCAPTCHA verifies Google reCAPTCHA tokens. For v3 tokens scored below
+// minScore are rejected; zero minScore accepts any score.
+func NewReCAPTCHA(secret string, minScore float64, httpClient *http.Client) Verifier {
+	return &siteVerifier{
+		name:     "recaptcha",
+		url:      "https://www.google.com/recaptcha/api/siteverify",
+		secret:   secret,
+		minScore: minScore,
+		http:     httpClient,
+	}
+}
+
+// NewHCaptcha verifies hCaptcha tokens.
+func NewHCaptcha(secret string, httpClient *http.Client) Verifier {
+	return &siteVerifier{
+		name:   "hcaptcha",
+		url:    "https://api.hcaptcha.com/siteverify",
+		secret: secret,
+		http:   httpClient,
+	}
+}
+
+// NewTurnstile verifies Cloudflare Turnstile tokens.
+func NewTurnstile(secret string, httpClient *http.Client) Verifier {
+	return &siteVerifier{
+		name:   "turnstile",
+		url:    "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+		secret: secret,
+		http:   httpClient,
+	}
+}
+
+func (v *siteVerifier) Verify(ctx context.Context, token string, remoteIP string) error {
+	op := "captcha." + v.name + ".Verify"
+
+	form := url.Values{"secret": {v.secret}, "response": {token}}
+	if remoteIP != "" {
+		form.Set("remoteip", remoteIP)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := v.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: provider responded with %s", op, resp.Status)
+	}
+
+	var result struct {
+		Success    bool     `json:"success"`
+		Score      *float64 `json:"score"`
+		ErrorCodes []string `json:"error-codes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if !result.Success {
+		// Ошибки в секрете — проблема конфигурации, а не токена
+		for _, code := range result.ErrorCodes {
+			if strings.HasSuffix(code, "-input-secret") {
+				return fmt.Errorf("%s: %s", op, strings.Join(result.ErrorCodes, ", "))
+			}
+		}
+
+		return fmt.Errorf("%s: %w: %s", op, ErrRejected, strings.Join(result.ErrorCodes, ", "))
+	}
+
+	if result.Score != nil && *result.Score < v.minScore {
+		return fmt.Errorf("%s: %w: score %.1f", op, ErrRejected, *result.Score)
+	}
+
+	return nil
+}
@@ -0,0 +1,149 @@
+// Package clientinfo describes the client device a request comes from.
+package clientinfo
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// DeviceHeader is the metadata key apps may use to name the device, e.g. "Pixel 8".
+const DeviceHeader = "x-device-name"
+
+// Info is recorded with sessions and login attempts. All fields are best effort.
+type Info struct {
+	IP        string
+	UserAgent string
+	Device    string
+}
+
+type ctxKey struct{}
+
+// WithInfo sets the client of non-gRPC requests, e.g. by HTTP handlers.
+func WithInfo(ctx context.Context, info Info) context.Context {
+	return context.WithValue(ctx, ctxKey{}, info)
+}
+
+// FromContext returns the client set by WithInfo or, failing that, the gRPC
+// peer address and metadata. x-forwarded-for is ignored here, see Proxies.
+func FromContext(ctx context.Context) Info {
+	if info, ok := ctx.Value(ctxKey{}).(Info); ok {
+		return info
+	}
+
+	var info Info
+
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		info.IP = hostOnly(p.Addr.String())
+	}
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get("user-agent"); len(v) > 0 {
+			info.UserAgent = v[0]
+		}
+		if v := md.Get(DeviceHeader); len(v) > 0 {
+			info.Device = v[0]
+		}
+	}
+
+	return info
+}
+
+// Proxies are reverse proxies trusted to report the client address in
+// x-forwarded-for. Loopback addresses are always trusted: the HTTP gateway
+// and gRPC-Web call the gRPC server over the loopback with the address they
+// resolved themselves.
+type Proxies []netip.Prefix
+
+// ParseProxies parses CIDRs or single addresses.
+func ParseProxies(addrs []string) (Proxies, error) {
+	proxies := make(Proxies, 0, len(addrs))
+	for _, a := range addrs {
+		prefix, err := netip.ParsePrefix(a)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(a)
+			if addrErr != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", a, err)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		proxies = append(proxies, prefix.Masked())
+	}
+
+	return proxies, nil
+}
+
+// ClientIP returns the address of the client of a request coming from
+// remote. If remote is a trusted proxy, it's the rightmost address of
+// forwardedFor that isn't: addresses left of it were set by the client and
+// can be anything.
+func (p Proxies) ClientIP(remote string, forwardedFor []string) string {
+	if !p.trusted(remote) {
+		return remote
+	}
+
+	var hops []string
+	for _, v := range forwardedFor {
+		for _, hop := range strings.Split(v, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+
+	ip := remote
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip = hops[i]
+		if !p.trusted(ip) {
+			break
+		}
+	}
+
+	return ip
+}
+
+// WithClient sets the client of a gRPC call from the peer address and
+// metadata, believing x-forwarded-for of trusted proxies only.
+func (p Proxies) WithClient(ctx context.Context) context.Context {
+	info := FromContext(ctx)
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		info.IP = p.ClientIP(info.IP, md.Get("x-forwarded-for"))
+	}
+
+	return WithInfo(ctx, info)
+}
+
+func (p Proxies) trusted(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+
+	if addr.IsLoopback() {
+		return true
+	}
+	for _, prefix := range p {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hostOnly strips the port from a host:port address.
+func hostOnly(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	return host
+}
@@ -0,0 +1,90 @@
+package clientinfo_test
+
+import (
+	"context"
+	"net"
+	"sso/internal/lib/clientinfo"
+	"testing"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+func TestClientIP(t *testing.T) {
+	proxies, err := clientinfo.ParseProxies([]string{"10.0.0.0/8", "192.0.2.1"})
+	if err != nil {
+		t.Fatalf("ParseProxies() error = %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		remote       string
+		forwardedFor []string
+		want         string
+	}{
+		{name: "direct client", remote: "203.0.113.1", want: "203.0.113.1"},
+		// Заголовок от недоверенного адреса подделан клиентом
+		{name: "untrusted remote", remote: "203.0.113.1", forwardedFor: []string{"198.51.100.1"}, want: "203.0.113.1"},
+		{name: "trusted proxy", remote: "10.0.0.1", forwardedFor: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "trusted single address", remote: "192.0.2.1", forwardedFor: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "loopback", remote: "127.0.0.1", forwardedFor: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "ipv4-mapped proxy", remote: "::ffff:10.0.0.1", forwardedFor: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "spoofed hops left of the client", remote: "10.0.0.1", forwardedFor: []string{"1.2.3.4, 198.51.100.1"}, want: "198.51.100.1"},
+		{name: "chain of trusted proxies", remote: "10.0.0.1", forwardedFor: []string{"198.51.100.1, 10.0.0.2", "10.0.0.3"}, want: "198.51.100.1"},
+		{name: "only trusted hops", remote: "10.0.0.1", forwardedFor: []string{"10.0.0.2"}, want: "10.0.0.2"},
+		{name: "trusted proxy without header", remote: "10.0.0.1", want: "10.0.0.1"},
+		{name: "empty hops", remote: "10.0.0.1", forwardedFor: []string{" , 198.51.100.1 ,"}, want: "198.51.100.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := proxies.ClientIP(tt.remote, tt.forwardedFor); got != tt.want {
+				t.Errorf("ClientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseProxies(t *testing.T) {
+	tests := []struct {
+		name    string
+		addrs   []string
+		wantErr bool
+	}{
+		{name: "cidr", addrs: []string{"10.0.0.0/8"}},
+		{name: "address", addrs: []string{"192.0.2.1", "2001:db8::1"}},
+		{name: "invalid", addrs: []string{"proxy.internal"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := clientinfo.ParseProxies(tt.addrs)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseProxies() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWithClient(t *testing.T) {
+	proxies, err := clientinfo.ParseProxies([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatalf("ParseProxies() error = %v", err)
+	}
+
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 50051}})
+	ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("x-forwarded-for", "198.51.100.1", "user-agent", "test-agent"))
+
+	info := clientinfo.FromContext(proxies.WithClient(ctx))
+	if info.IP != "198.51.100.1" {
+		t.Errorf("IP = %q, want %q", info.IP, "198.51.100.1")
+	}
+	if info.UserAgent != "test-agent" {
+		t.Errorf("UserAgent = %q, want %q", info.UserAgent, "test-agent")
+	}
+
+	// Без доверенных прокси заголовок игнорируется
+	if info := clientinfo.FromContext(ctx); info.IP != "10.0.0.1" {
+		t.Errorf("FromContext() IP = %q, want peer address %q", info.IP, "10.0.0.1")
+	}
+}
@@ -0,0 +1,243 @@
+package dpop
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Header is the gRPC metadata key carrying the DPoP proof.
+const Header = "dpop"
+
+// Method is the htm value expected in proofs sent over gRPC (all unary calls are POSTs).
+const Method = "POST"
+
+// MaxProofAge is how far iat of a proof may deviate from the server clock.
+const MaxProofAge = 5 * time.Minute
+
+// minRSABits is the smallest RSA modulus accepted in proof keys.
+const minRSABits = 2048
+
+var (
+	ErrInvalidProof      = errors.New("invalid dpop proof")
+	ErrKeyBindingMissing = errors.New("token is dpop-bound, but no proof was presented")
+	ErrKeyBindingInvalid = errors.New("dpop proof key doesn't match token binding")
+)
+
+// Proof is a verified DPoP proof.
+type Proof struct {
+	// JKT is the JWK SHA-256 thumbprint (RFC 7638) of the proof key.
+	JKT string
+	JTI string
+	ATH string
+}
+
+type claims struct {
+	jwt.RegisteredClaims
+	HTM string `json:"htm"`
+	HTU string `json:"htu"`
+	ATH string `json:"ath,omitempty"`
+}
+
+// FromIncomingContext returns the DPoP proof sent with the gRPC call and
+// the htu it must be bound to (the full method name).
+func FromIncomingContext(ctx context.Context) (proof string, htu string, ok bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", "", false
+	}
+
+	values := md.Get(Header)
+	if len(values) != 1 || values[0] == "" {
+		return "", "", false
+	}
+
+	method, _ := grpc.Method(ctx)
+
+	return values[0], method, true
+}
+
+// Verify checks the proof signature against its embedded public key and
+// validates the htm, htu and iat claims (RFC 9449, section 4.3).
+// leeway is the clock skew tolerated on top of MaxProofAge.
+func Verify(proof string, htm string, htu string, now time.Time, leeway time.Duration) (Proof, error) {
+	var (
+		c claims
+		k jwk
+	)
+
+	_, err := jwt.ParseWithClaims(proof, &c, func(t *jwt.Token) (interface{}, error) {
+		if t.Header["typ"] != "dpop+jwt" {
+			return nil, fmt.Errorf("unexpected typ %v", t.Header["typ"])
+		}
+
+		raw, err := json.Marshal(t.Header["jwk"])
+		if err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal(raw, &k); err != nil {
+			return nil, err
+		}
+
+		return k.publicKey()
+	},
+		jwt.WithValidMethods([]string{"ES256", "RS256", "PS256", "EdDSA"}),
+		jwt.WithTimeFunc(func() time.Time { return now }),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(leeway),
+	)
+	if err != nil {
+		return Proof{}, fmt.Errorf("%w: %w", ErrInvalidProof, err)
+	}
+
+	if c.ID == "" || c.IssuedAt == nil {
+		return Proof{}, fmt.Errorf("%w: jti and iat are required", ErrInvalidProof)
+	}
+
+	if c.HTM != htm || c.HTU != htu {
+		return Proof{}, fmt.Errorf("%w: htm/htu mismatch", ErrInvalidProof)
+	}
+
+	if age := now.Sub(c.IssuedAt.Time); age > MaxProofAge+leeway || age < -leeway {
+		return Proof{}, fmt.Errorf("%w: iat is out of range", ErrInvalidProof)
+	}
+
+	return Proof{JKT: k.thumbprint(), JTI: c.ID, ATH: c.ATH}, nil
+}
+
+// AccessTokenHash returns the ath value of a proof presented with the access token.
+func AccessTokenHash(accessToken string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// VerifyBinding checks a proof presented together with a dpop-bound access token.
+// jkt is the cnf.jkt claim of the token; empty jkt means the token isn't bound.
+func VerifyBinding(jkt string, accessToken string, proof *Proof) error {
+	if jkt == "" {
+		return nil
+	}
+
+	if proof == nil {
+		return ErrKeyBindingMissing
+	}
+
+	if proof.JKT != jkt || proof.ATH != AccessTokenHash(accessToken) {
+		return ErrKeyBindingInvalid
+	}
+
+	return nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	D   string `json:"d,omitempty"`
+}
+
+func (k jwk) publicKey() (interface{}, error) {
+	if k.D != "" {
+		return nil, errors.New("jwk must not contain a private key")
+	}
+
+	switch k.Kty {
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		if !key.Curve.IsOnCurve(x, y) {
+			return nil, errors.New("invalid ec point")
+		}
+
+		return key, nil
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		// Короткие модули подбираются, ими нельзя доказывать владение ключом
+		if n.BitLen() < minRSABits {
+			return nil, fmt.Errorf("rsa key must be at least %d bits", minRSABits)
+		}
+		if e.BitLen() > 31 || e.Int64() < 3 {
+			return nil, errors.New("invalid rsa exponent")
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid ed25519 key")
+		}
+
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// thumbprint computes RFC 7638 thumbprint over the required members in lexicographic order.
+func (k jwk) thumbprint() string {
+	var canonical string
+
+	switch k.Kty {
+	case "EC":
+		canonical = fmt.Sprintf(`{"crv":%q,"kty":%q,"x":%q,"y":%q}`, k.Crv, k.Kty, k.X, k.Y)
+	case "RSA":
+		canonical = fmt.Sprintf(`{"e":%q,"kty":%q,"n":%q}`, k.E, k.Kty, k.N)
+	case "OKP":
+		canonical = fmt.Sprintf(`{"crv":%q,"kty":%q,"x":%q}`, k.Crv, k.Kty, k.X)
+	}
+
+	sum := sha256.Sum256([]byte(canonical))
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("invalid jwk member")
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
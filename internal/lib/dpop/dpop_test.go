@@ -0,0 +1,118 @@
+package dpop_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"sso/internal/lib/dpop"
+	"sso/ssotest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const method = "/auth.Auth/GetUserRole"
+
+func TestVerify(t *testing.T) {
+	key := ssotest.NewDPoPKey(t)
+
+	tests := []struct {
+		name    string
+		htm     string
+		htu     string
+		now     time.Time
+		wantErr error
+	}{
+		{name: "valid", htm: dpop.Method, htu: method, now: time.Now()},
+		{name: "other method", htm: dpop.Method, htu: "/auth.Auth/Login", now: time.Now(), wantErr: dpop.ErrInvalidProof},
+		{name: "other htm", htm: "GET", htu: method, now: time.Now(), wantErr: dpop.ErrInvalidProof},
+		{name: "stale", htm: dpop.Method, htu: method, now: time.Now().Add(dpop.MaxProofAge + time.Minute), wantErr: dpop.ErrInvalidProof},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := dpop.Verify(key.Proof(t, method, "token"), tt.htm, tt.htu, tt.now, 0)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Verify() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if err == nil && p.JKT != key.JKT() {
+				t.Errorf("JKT = %q, want %q", p.JKT, key.JKT())
+			}
+		})
+	}
+}
+
+func TestVerifyBinding(t *testing.T) {
+	const token = "access-token"
+
+	jkt := "key-a"
+
+	tests := []struct {
+		name  string
+		jkt   string
+		proof *dpop.Proof
+		want  error
+	}{
+		{name: "unbound without proof"},
+		{name: "unbound with proof", proof: &dpop.Proof{JKT: jkt, ATH: dpop.AccessTokenHash(token)}},
+		{name: "bound with proof", jkt: jkt, proof: &dpop.Proof{JKT: jkt, ATH: dpop.AccessTokenHash(token)}},
+		{name: "bound without proof", jkt: jkt, want: dpop.ErrKeyBindingMissing},
+		{name: "proof of other key", jkt: jkt, proof: &dpop.Proof{JKT: "key-b", ATH: dpop.AccessTokenHash(token)}, want: dpop.ErrKeyBindingInvalid},
+		{name: "proof of other token", jkt: jkt, proof: &dpop.Proof{JKT: jkt, ATH: dpop.AccessTokenHash("other")}, want: dpop.ErrKeyBindingInvalid},
+		{name: "proof without ath", jkt: jkt, proof: &dpop.Proof{JKT: jkt}, want: dpop.ErrKeyBindingInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := dpop.VerifyBinding(tt.jkt, token, tt.proof); !errors.Is(err, tt.want) {
+				t.Errorf("VerifyBinding() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyRSAKeySize(t *testing.T) {
+	tests := []struct {
+		name    string
+		bits    int
+		wantErr error
+	}{
+		{name: "1024 bits", bits: 1024, wantErr: dpop.ErrInvalidProof},
+		{name: "2048 bits", bits: 2048},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := rsa.GenerateKey(rand.Reader, tt.bits)
+			if err != nil {
+				t.Fatalf("generate key: %v", err)
+			}
+
+			token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+				"jti": rand.Text(),
+				"iat": time.Now().Unix(),
+				"htm": dpop.Method,
+				"htu": method,
+			})
+			token.Header["typ"] = "dpop+jwt"
+			token.Header["jwk"] = map[string]string{
+				"kty": "RSA",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}
+
+			proof, err := token.SignedString(key)
+			if err != nil {
+				t.Fatalf("sign proof: %v", err)
+			}
+
+			if _, err := dpop.Verify(proof, dpop.Method, method, time.Now(), 0); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Verify() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
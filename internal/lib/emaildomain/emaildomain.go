@@ -0,0 +1,71 @@
+package emaildomain
+
+import "strings"
+
+// Policy accepts or rejects email addresses by domain.
+// A domain also matches its subdomains. Empty Allow means any domain not blocked.
+type Policy struct {
+	Allow []string `yaml:"allow"`
+	Block []string `yaml:"block"`
+}
+
+func (p Policy) Permits(email string) bool {
+	domain := Domain(email)
+	if domain == "" {
+		return false
+	}
+
+	for _, d := range p.Block {
+		if matches(domain, d) {
+			return false
+		}
+	}
+
+	if len(p.Allow) == 0 {
+		return true
+	}
+
+	for _, d := range p.Allow {
+		if matches(domain, d) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Rules is a global policy plus stricter per-role policies,
+// e.g. only "city.gov" addresses may self-register as organizers.
+type Rules struct {
+	Policy `yaml:",inline"`
+	Roles  map[string]Policy `yaml:"roles"`
+}
+
+// Permits reports whether email may be used by an account with the role.
+func (r Rules) Permits(email string, role string) bool {
+	if !r.Policy.Permits(email) {
+		return false
+	}
+
+	if p, ok := r.Roles[role]; ok {
+		return p.Permits(email)
+	}
+
+	return true
+}
+
+// Domain returns lowercased domain part of the email, or empty string if there is none.
+func Domain(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 0 || at == len(email)-1 {
+		return ""
+	}
+
+	return strings.ToLower(strings.TrimSpace(email[at+1:]))
+}
+
+func matches(domain string, rule string) bool {
+	rule = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(rule), "@"))
+
+	return domain == rule || strings.HasSuffix(domain, "."+rule)
+}
@@ -0,0 +1,19 @@
+// Package events publishes user events to a message broker for services
+// reacting to SSO changes. Events carry the same JSON envelope as webhook
+// deliveries.
+package events
+
+import "context"
+
+// Publisher sends the event payload to the broker. id identifies the event,
+// so that brokers and consumers can drop duplicates.
+type Publisher interface {
+	Publish(ctx context.Context, event string, id string, payload []byte) error
+}
+
+// Disabled drops every event. Used when no transport is configured.
+type Disabled struct{}
+
+func (Disabled) Publish(context.Context, string, string, []byte) error {
+	return nil
+}
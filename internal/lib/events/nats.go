@@ -0,0 +1,95 @@
+package events
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// NATS publishes events to JetStream under subjectPrefix plus the event name,
+// e.g. "sso.user.registered". A stream capturing the subjects must exist,
+// otherwise publishing fails with no responders.
+type NATS struct {
+	conn          *nats.Conn
+	js            jetstream.JetStream
+	subjectPrefix string
+	consumers     []jetstream.ConsumeContext
+}
+
+func NewNATS(url string, subjectPrefix string) (*NATS, error) {
+	const op = "events.NewNATS"
+
+	conn, err := nats.Connect(url, nats.Name("sso"))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &NATS{conn: conn, js: js, subjectPrefix: subjectPrefix}, nil
+}
+
+// Publish waits for the stream to acknowledge the event. The id is sent as
+// Nats-Msg-Id, so the stream drops duplicates within its window.
+func (p *NATS) Publish(ctx context.Context, event string, id string, payload []byte) error {
+	if _, err := p.js.Publish(ctx, p.subjectPrefix+event, payload, jetstream.WithMsgID(id)); err != nil {
+		return fmt.Errorf("publish %s: %w", event, err)
+	}
+
+	return nil
+}
+
+// Consume passes messages of event to handle through the durable consumer
+// named durable on the stream capturing the event. Messages published while
+// no instance runs are delivered later; instances sharing durable share the
+// messages. Messages handle fails on are redelivered.
+func (p *NATS) Consume(ctx context.Context, event string, durable string, handle func(ctx context.Context, payload []byte) error) error {
+	subject := p.subjectPrefix + event
+
+	stream, err := p.js.StreamNameBySubject(ctx, subject)
+	if err != nil {
+		return fmt.Errorf("find stream of %s: %w", event, err)
+	}
+
+	consumer, err := p.js.CreateOrUpdateConsumer(ctx, stream, jetstream.ConsumerConfig{
+		Durable:       durable,
+		FilterSubject: subject,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+	})
+	if err != nil {
+		return fmt.Errorf("create consumer of %s: %w", event, err)
+	}
+
+	cc, err := consumer.Consume(func(msg jetstream.Msg) {
+		if err := handle(context.Background(), msg.Data()); err != nil {
+			_ = msg.Nak()
+
+			return
+		}
+
+		_ = msg.Ack()
+	})
+	if err != nil {
+		return fmt.Errorf("consume %s: %w", event, err)
+	}
+
+	p.consumers = append(p.consumers, cc)
+
+	return nil
+}
+
+// Close stops consumers, flushes pending messages and closes the connection.
+func (p *NATS) Close() error {
+	for _, cc := range p.consumers {
+		cc.Stop()
+	}
+
+	return p.conn.Drain()
+}
@@ -0,0 +1,57 @@
+package events
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"sso/internal/domain/models"
+)
+
+// RevocationEvent carries revocations of tokens between regions. It isn't a
+// user event: webhooks can't subscribe to it.
+const RevocationEvent = "token.revoked"
+
+// Revocations replicates revocations of tokens between the regions sharing
+// the broker, see auth.RevocationReplicator.
+type Revocations struct {
+	publisher Publisher
+	region    string
+}
+
+// NewRevocations replicates revocations made in region.
+func NewRevocations(publisher Publisher, region string) Revocations {
+	return Revocations{publisher: publisher, region: region}
+}
+
+func (r Revocations) ReplicateRevocation(ctx context.Context, revocation models.Revocation) error {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(revocation)
+	if err != nil {
+		return err
+	}
+
+	return r.publisher.Publish(ctx, RevocationEvent, hex.EncodeToString(b), payload)
+}
+
+// Handler decodes revocations of the other regions for apply, e.g.
+// auth.Auth.ApplyRevocation, and skips those made in this one. Malformed
+// payloads are dropped, as redelivering them won't help.
+func (r Revocations) Handler(apply func(ctx context.Context, revocation models.Revocation) error) func(ctx context.Context, payload []byte) error {
+	return func(ctx context.Context, payload []byte) error {
+		var revocation models.Revocation
+		if err := json.Unmarshal(payload, &revocation); err != nil {
+			return nil
+		}
+
+		if revocation.Region == r.region {
+			return nil
+		}
+
+		return apply(ctx, revocation)
+	}
+}
@@ -0,0 +1,47 @@
+package fault
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrInjected is returned by operations failed on purpose by the fault injector.
+var ErrInjected = errors.New("injected fault")
+
+// Faults to inject into handling of a single request.
+type Faults struct {
+	Latency time.Duration
+	Storage bool
+	Signing bool
+}
+
+type ctxKey struct{}
+
+func WithFaults(ctx context.Context, f Faults) context.Context {
+	return context.WithValue(ctx, ctxKey{}, f)
+}
+
+func FromContext(ctx context.Context) Faults {
+	f, _ := ctx.Value(ctxKey{}).(Faults)
+
+	return f
+}
+
+// StorageError returns ErrInjected if storage calls of the request must fail.
+func StorageError(ctx context.Context) error {
+	if FromContext(ctx).Storage {
+		return ErrInjected
+	}
+
+	return nil
+}
+
+// SigningError returns ErrInjected if token signing for the request must fail.
+func SigningError(ctx context.Context) error {
+	if FromContext(ctx).Signing {
+		return ErrInjected
+	}
+
+	return nil
+}
@@ -0,0 +1,41 @@
+package jwt
+
+import (
+	"context"
+	"sso/internal/domain/models"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ClaimsEnricher adds deployment-specific claims, e.g. subscription tier or
+// whether an organizer is verified, to access tokens issued to users.
+// An error fails issuing the token.
+type ClaimsEnricher interface {
+	EnrichClaims(ctx context.Context, user models.User, app models.App) (map[string]any, error)
+}
+
+// ClaimsEnricherFunc adapts a function to ClaimsEnricher.
+type ClaimsEnricherFunc func(ctx context.Context, user models.User, app models.App) (map[string]any, error)
+
+func (f ClaimsEnricherFunc) EnrichClaims(ctx context.Context, user models.User, app models.App) (map[string]any, error) {
+	return f(ctx, user, app)
+}
+
+// reservedClaims are set by the service and can't be replaced by WithClaims.
+var reservedClaims = map[string]bool{
+	"iss": true, "sub": true, "aud": true, "exp": true, "nbf": true, "iat": true, "jti": true,
+	"uid": true, "email": true, "app_id": true, "role": true, "scope": true, "groups": true,
+	"org_id": true, "sid": true, "region": true, "cnf": true, "nonce": true,
+}
+
+// WithClaims adds extra claims, skipping reserved ones so that an enricher
+// can't change who the token is for or what it grants.
+func WithClaims(extra map[string]any) Option {
+	return func(claims jwt.MapClaims) {
+		for name, value := range extra {
+			if !reservedClaims[name] {
+				claims[name] = value
+			}
+		}
+	}
+}
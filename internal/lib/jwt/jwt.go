@@ -1,26 +1,345 @@
 package jwt
 
 import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
 	"sso/internal/domain/models"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-func NewToken(user models.User, app models.App, duration time.Duration) (string, error) {
-	token := jwt.New(jwt.SigningMethodHS256)
+var (
+	ErrInvalidToken          = errors.New("invalid token")
+	ErrCertBindingMismatch   = errors.New("token is bound to another certificate")
+	ErrCertBindingNotPresent = errors.New("token is bound to a certificate, but none was presented")
+	ErrRegionNotAllowed      = errors.New("token was issued in a region that is not allowed")
+)
+
+// Option adds optional claims to an issued token.
+type Option func(claims jwt.MapClaims)
+
+// WithCertThumbprint binds the token to the client certificate
+// with the given SHA-256 thumbprint (RFC 8705, "cnf.x5t#S256").
+func WithCertThumbprint(thumbprint string) Option {
+	return func(claims jwt.MapClaims) {
+		setConfirmation(claims, "x5t#S256", thumbprint)
+	}
+}
+
+// WithKeyThumbprint binds the token to the DPoP key with the given
+// JWK thumbprint (RFC 9449, "cnf.jkt").
+func WithKeyThumbprint(jkt string) Option {
+	return func(claims jwt.MapClaims) {
+		setConfirmation(claims, "jkt", jkt)
+	}
+}
+
+// WithRegion records the region that issued the token. Empty region adds nothing.
+func WithRegion(region string) Option {
+	return func(claims jwt.MapClaims) {
+		if region != "" {
+			claims["region"] = region
+		}
+	}
+}
+
+// WithSessionID records the login session the token belongs to. Zero adds nothing.
+func WithSessionID(id int64) Option {
+	return func(claims jwt.MapClaims) {
+		if id != 0 {
+			claims["sid"] = id
+		}
+	}
+}
+
+// WithScopes grants scopes to the user token, like those of service tokens,
+// so resource services can authorize by the token alone. No scopes add nothing.
+func WithScopes(scopes []string) Option {
+	return func(claims jwt.MapClaims) {
+		if len(scopes) > 0 {
+			claims["scope"] = strings.Join(scopes, " ")
+		}
+	}
+}
+
+// WithGroups lists names of the groups the user is in. No groups add nothing.
+func WithGroups(groups []string) Option {
+	return func(claims jwt.MapClaims) {
+		if len(groups) > 0 {
+			claims["groups"] = groups
+		}
+	}
+}
+
+// WithOrgID records the organization of the user. Zero adds nothing.
+func WithOrgID(orgID int64) Option {
+	return func(claims jwt.MapClaims) {
+		if orgID != 0 {
+			claims["org_id"] = orgID
+		}
+	}
+}
+
+// NewToken creates access token signed with the app secret (HS256).
+func NewToken(user models.User, app models.App, duration time.Duration, opts ...Option) (string, error) {
+	return NewSignedToken(user, app, duration, nil, opts...)
+}
+
+// NewSignedToken creates access token signed with key, or with the app secret if key is nil.
+func NewSignedToken(user models.User, app models.App, duration time.Duration, key *SigningKey, opts ...Option) (string, error) {
+	method := jwt.SigningMethod(jwt.SigningMethodHS256)
+	if key != nil {
+		method = key.Method
+	}
+
+	token := jwt.New(method)
+
+	now := time.Now()
 
 	claims := token.Claims.(jwt.MapClaims)
+	claims["jti"] = NewID()
 	claims["uid"] = user.ID
 	claims["email"] = user.Email
-	claims["exp"] = time.Now().Add(duration).Unix()
+	claims["iat"] = now.Unix()
+	claims["nbf"] = now.Unix()
+	claims["exp"] = now.Add(duration).Unix()
 	claims["app_id"] = app.ID
 	claims["role"] = user.Role
+	if app.Audience != "" {
+		claims["aud"] = app.Audience
+	}
+
+	for _, opt := range opts {
+		opt(claims)
+	}
 
-	tokenString, err := token.SignedString([]byte(app.Secret))
+	var signingKey interface{} = []byte(app.Secret)
+	if key != nil {
+		token.Header["kid"] = key.KeyID
+		signingKey = key.private
+	}
+
+	tokenString, err := token.SignedString(signingKey)
 	if err != nil {
 		return "", err
 	}
 
 	return tokenString, nil
 }
+
+// NewServiceToken creates token of the app itself, with no user, granting scopes.
+// It's signed like access tokens of the app.
+func NewServiceToken(app models.App, scopes []string, duration time.Duration, key *SigningKey, opts ...Option) (string, error) {
+	method := jwt.SigningMethod(jwt.SigningMethodHS256)
+	if key != nil {
+		method = key.Method
+	}
+
+	token := jwt.New(method)
+
+	now := time.Now()
+
+	claims := token.Claims.(jwt.MapClaims)
+	claims["jti"] = NewID()
+	claims["iat"] = now.Unix()
+	claims["nbf"] = now.Unix()
+	claims["exp"] = now.Add(duration).Unix()
+	claims["app_id"] = app.ID
+	claims["scope"] = strings.Join(scopes, " ")
+	if app.Audience != "" {
+		claims["aud"] = app.Audience
+	}
+
+	for _, opt := range opts {
+		opt(claims)
+	}
+
+	var signingKey interface{} = []byte(app.Secret)
+	if key != nil {
+		token.Header["kid"] = key.KeyID
+		signingKey = key.private
+	}
+
+	return token.SignedString(signingKey)
+}
+
+// Scopes returns scopes granted to the token.
+func Scopes(claims jwt.MapClaims) []string {
+	scope, _ := claims["scope"].(string)
+
+	return strings.Fields(scope)
+}
+
+// Groups returns names of the groups the token's user is in.
+func Groups(claims jwt.MapClaims) []string {
+	list, _ := claims["groups"].([]any)
+
+	groups := make([]string, 0, len(list))
+	for _, g := range list {
+		if name, ok := g.(string); ok {
+			groups = append(groups, name)
+		}
+	}
+
+	return groups
+}
+
+// OrgID returns the organization of the token's user, zero if there is none.
+func OrgID(claims jwt.MapClaims) int64 {
+	orgID, _ := claims["org_id"].(float64)
+
+	return int64(orgID)
+}
+
+// NewID returns a random identifier suitable for the jti claim.
+func NewID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// KeyFunc resolves how tokens of the app are signed: with one of the secrets,
+// or with the key if it isn't nil. Secrets hold the current secret of the app
+// and the previous ones still accepted after rotation.
+type KeyFunc func(appID int) (secrets []string, key *SigningKey, err error)
+
+// Parse verifies the token signature, exp and nbf and returns its claims.
+//
+// The signing secret or key is resolved by app id taken from the token itself;
+// the token must be signed with the algorithm configured for the app.
+// leeway is the clock skew tolerated when checking exp, nbf and iat.
+func Parse(tokenString string, keys KeyFunc, leeway time.Duration) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		appID, ok := claims["app_id"].(float64)
+		if !ok {
+			return nil, fmt.Errorf("app_id claim is missing")
+		}
+
+		secrets, key, err := keys(int(appID))
+		if err != nil {
+			return nil, err
+		}
+
+		if key != nil {
+			if t.Method.Alg() != key.Method.Alg() {
+				return nil, fmt.Errorf("unexpected signing method %s", t.Method.Alg())
+			}
+
+			return key.Public(), nil
+		}
+
+		if t.Method.Alg() != jwt.SigningMethodHS256.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %s", t.Method.Alg())
+		}
+
+		set := jwt.VerificationKeySet{}
+		for _, secret := range secrets {
+			set.Keys = append(set.Keys, []byte(secret))
+		}
+
+		return set, nil
+	},
+		jwt.WithValidMethods([]string{
+			jwt.SigningMethodHS256.Alg(), jwt.SigningMethodRS256.Alg(), jwt.SigningMethodES256.Alg(),
+		}),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(leeway),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	return claims, nil
+}
+
+// VerifyCertBinding checks that a certificate-bound token is presented
+// together with the certificate it was issued to. bound is the thumbprint
+// of the token, see CertBinding; empty bound means the token isn't bound.
+func VerifyCertBinding(bound string, thumbprint string) error {
+	if bound == "" {
+		return nil
+	}
+
+	if thumbprint == "" {
+		return ErrCertBindingNotPresent
+	}
+
+	if bound != thumbprint {
+		return ErrCertBindingMismatch
+	}
+
+	return nil
+}
+
+// CertBinding returns the client certificate thumbprint the token is bound to, if any.
+func CertBinding(claims jwt.MapClaims) string {
+	cnf, ok := claims["cnf"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+
+	x5t, _ := cnf["x5t#S256"].(string)
+
+	return x5t
+}
+
+// KeyBinding returns the DPoP key thumbprint the token is bound to, if any.
+func KeyBinding(claims jwt.MapClaims) string {
+	cnf, ok := claims["cnf"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+
+	jkt, _ := cnf["jkt"].(string)
+
+	return jkt
+}
+
+// Region returns the region that issued the token, if recorded.
+func Region(claims jwt.MapClaims) string {
+	region, _ := claims["region"].(string)
+
+	return region
+}
+
+// SessionID returns the login session of the token, zero if not recorded.
+func SessionID(claims jwt.MapClaims) int64 {
+	sid, _ := claims["sid"].(float64)
+
+	return int64(sid)
+}
+
+// VerifyRegion pins the token to the given regions, e.g. for data residency.
+// Tokens without a region claim and an empty allow list pass.
+func VerifyRegion(claims jwt.MapClaims, allowed []string) error {
+	region := Region(claims)
+	if region == "" || len(allowed) == 0 {
+		return nil
+	}
+
+	for _, r := range allowed {
+		if r == region {
+			return nil
+		}
+	}
+
+	return ErrRegionNotAllowed
+}
+
+func setConfirmation(claims jwt.MapClaims, key string, value string) {
+	cnf, ok := claims["cnf"].(map[string]interface{})
+	if !ok {
+		cnf = map[string]interface{}{}
+		claims["cnf"] = cnf
+	}
+
+	cnf[key] = value
+}
@@ -0,0 +1,72 @@
+package jwt
+
+import (
+	"errors"
+	"sso/internal/domain/models"
+	"testing"
+	"time"
+)
+
+func TestVerifyCertBinding(t *testing.T) {
+	tests := []struct {
+		name       string
+		bound      string
+		thumbprint string
+		want       error
+	}{
+		{
+			name: "unbound without certificate",
+		},
+		{
+			name:       "unbound with certificate",
+			thumbprint: "cert-a",
+		},
+		{
+			name:       "bound with same certificate",
+			bound:      "cert-a",
+			thumbprint: "cert-a",
+		},
+		{
+			name:  "bound without certificate",
+			bound: "cert-a",
+			want:  ErrCertBindingNotPresent,
+		},
+		{
+			name:       "bound with other certificate",
+			bound:      "cert-a",
+			thumbprint: "cert-b",
+			want:       ErrCertBindingMismatch,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := VerifyCertBinding(tt.bound, tt.thumbprint); !errors.Is(err, tt.want) {
+				t.Errorf("VerifyCertBinding() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCertBindingRoundTrip(t *testing.T) {
+	app := models.App{ID: 1, Secret: "secret"}
+
+	token, err := NewToken(models.User{ID: 1, Email: "user@example.com"}, app, time.Hour, WithCertThumbprint("cert-a"))
+	if err != nil {
+		t.Fatalf("NewToken() error = %v", err)
+	}
+
+	claims, err := Parse(token, func(int) ([]string, *SigningKey, error) {
+		return []string{app.Secret}, nil, nil
+	}, 0)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	if got := CertBinding(claims); got != "cert-a" {
+		t.Errorf("CertBinding() = %q, want %q", got, "cert-a")
+	}
+	if got := KeyBinding(claims); got != "" {
+		t.Errorf("KeyBinding() = %q, want empty", got)
+	}
+}
@@ -0,0 +1,97 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var ErrUnsupportedKey = errors.New("unsupported signing key, expected RSA or ECDSA P-256")
+
+// SigningKey signs tokens with RS256 or ES256 instead of the app secret, so that
+// relying services can verify them with the public key alone.
+type SigningKey struct {
+	// KeyID is put into the kid header, see NewSigningKey.
+	KeyID   string
+	Method  jwt.SigningMethod
+	private crypto.Signer
+}
+
+// NewSigningKey picks the algorithm by key type: RS256 for RSA, ES256 for ECDSA P-256.
+// Key id is derived from the public key.
+func NewSigningKey(key crypto.Signer) (*SigningKey, error) {
+	var method jwt.SigningMethod
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		method = jwt.SigningMethodRS256
+	case *ecdsa.PrivateKey:
+		if k.Curve != elliptic.P256() {
+			return nil, ErrUnsupportedKey
+		}
+		method = jwt.SigningMethodES256
+	default:
+		return nil, ErrUnsupportedKey
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(der)
+
+	return &SigningKey{
+		KeyID:   base64.RawURLEncoding.EncodeToString(sum[:]),
+		Method:  method,
+		private: key,
+	}, nil
+}
+
+// LoadSigningKey reads a PEM-encoded private key (PKCS#8, PKCS#1 or SEC 1) from file.
+func LoadSigningKey(path string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", path)
+	}
+
+	var key any
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, ErrUnsupportedKey
+	}
+
+	return NewSigningKey(signer)
+}
+
+// Public returns the public key verifying tokens signed with k.
+func (k *SigningKey) Public() crypto.PublicKey {
+	return k.private.Public()
+}
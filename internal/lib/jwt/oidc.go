@@ -0,0 +1,115 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"sso/internal/domain/models"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// NewIDToken creates OpenID Connect ID token of the user for the app, signed
+// like access tokens: with key, or with the app secret if key is nil.
+func NewIDToken(user models.User, app models.App, issuer string, nonce string, duration time.Duration, key *SigningKey) (string, error) {
+	method := jwt.SigningMethod(jwt.SigningMethodHS256)
+	if key != nil {
+		method = key.Method
+	}
+
+	token := jwt.New(method)
+
+	now := time.Now()
+
+	claims := token.Claims.(jwt.MapClaims)
+	claims["iss"] = issuer
+	claims["sub"] = strconv.FormatInt(user.ID, 10)
+	claims["aud"] = strconv.Itoa(app.ID)
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(duration).Unix()
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+	for k, v := range UserInfo(user) {
+		claims[k] = v
+	}
+
+	var signingKey interface{} = []byte(app.Secret)
+	if key != nil {
+		token.Header["kid"] = key.KeyID
+		signingKey = key.private
+	}
+
+	return token.SignedString(signingKey)
+}
+
+// UserInfo returns standard OpenID Connect claims of the user, omitting empty ones.
+func UserInfo(user models.User) map[string]any {
+	claims := map[string]any{
+		"sub": strconv.FormatInt(user.ID, 10),
+	}
+
+	if user.Email != "" {
+		claims["email"] = user.Email
+	}
+	if user.Username != "" {
+		claims["preferred_username"] = user.Username
+	}
+	if user.Phone != "" {
+		claims["phone_number"] = user.Phone
+		claims["phone_number_verified"] = user.PhoneVerified
+	}
+	if user.AvatarURL != "" {
+		claims["picture"] = user.AvatarURL
+	}
+
+	return claims
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// EC
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWK returns the public key of k for publishing in JWKS.
+func (k *SigningKey) JWK() JWK {
+	jwk := JWK{Kid: k.KeyID, Use: "sig", Alg: k.Method.Alg()}
+
+	switch pub := k.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = b64(pub.N.Bytes())
+		jwk.E = b64(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		// Несжатая точка: 0x04 || X || Y
+		ecdhKey, err := pub.ECDH()
+		if err != nil {
+			break
+		}
+		point := ecdhKey.Bytes()[1:]
+
+		jwk.Kty = "EC"
+		jwk.Crv = pub.Curve.Params().Name
+		jwk.X = b64(point[:len(point)/2])
+		jwk.Y = b64(point[len(point)/2:])
+	}
+
+	return jwk
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,158 @@
+// Package ldap checks passwords against an LDAP directory such as Active
+// Directory: the user entry is found with a service account, then the
+// password is verified by binding as that entry.
+package ldap
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	ldapv3 "github.com/go-ldap/ldap/v3"
+)
+
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// GroupRole maps members of the directory group to the role.
+type GroupRole struct {
+	// Group is the DN of the group, compared case-insensitively.
+	Group string
+	Role  string
+}
+
+// Config of the directory.
+type Config struct {
+	// URL is ldap://host:389 or ldaps://host:636.
+	URL string
+	// StartTLS upgrades an ldap:// connection before binding.
+	StartTLS bool
+	// BindDN and BindPassword are the service account searching for users.
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	// UserFilter finds the user entry, %s is replaced with the escaped login,
+	// e.g. (&(objectClass=user)(userPrincipalName=%s)).
+	UserFilter string
+	// GroupRoles are checked in order, the first group the user is a member of wins.
+	GroupRoles []GroupRole
+	// DefaultRole is for users in none of GroupRoles.
+	DefaultRole string
+	Timeout     time.Duration
+}
+
+// Identity is the user entry the password was verified for.
+type Identity struct {
+	DN string
+	// Groups are DNs from the memberOf attribute.
+	Groups []string
+}
+
+type Directory struct {
+	cfg Config
+}
+
+func New(cfg Config) *Directory {
+	if cfg.UserFilter == "" {
+		cfg.UserFilter = "(&(objectClass=person)(mail=%s))"
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+
+	return &Directory{cfg: cfg}
+}
+
+// Authenticate verifies the password of the user with the login.
+// ErrInvalidCredentials means there is no such user or the password is wrong.
+func (d *Directory) Authenticate(ctx context.Context, login string, password string) (Identity, error) {
+	// Bind с пустым паролем по RFC 4513 проходит как анонимный, его нельзя считать входом
+	if password == "" {
+		return Identity{}, ErrInvalidCredentials
+	}
+
+	conn, err := d.dial(ctx)
+	if err != nil {
+		return Identity{}, err
+	}
+	defer conn.Close()
+
+	if err := conn.Bind(d.cfg.BindDN, d.cfg.BindPassword); err != nil {
+		return Identity{}, fmt.Errorf("bind service account: %w", err)
+	}
+
+	res, err := conn.Search(ldapv3.NewSearchRequest(
+		d.cfg.BaseDN,
+		ldapv3.ScopeWholeSubtree, ldapv3.NeverDerefAliases,
+		2, int(d.cfg.Timeout.Seconds()), false,
+		fmt.Sprintf(d.cfg.UserFilter, ldapv3.EscapeFilter(login)),
+		[]string{"memberOf"},
+		nil,
+	))
+	if err != nil && !ldapv3.IsErrorWithCode(err, ldapv3.LDAPResultSizeLimitExceeded) {
+		return Identity{}, fmt.Errorf("search user: %w", err)
+	}
+	// Неоднозначный логин не пускаем, чтобы не войти чужой записью
+	if res == nil || len(res.Entries) != 1 {
+		return Identity{}, ErrInvalidCredentials
+	}
+
+	entry := res.Entries[0]
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldapv3.IsErrorWithCode(err, ldapv3.LDAPResultInvalidCredentials) {
+			return Identity{}, ErrInvalidCredentials
+		}
+
+		return Identity{}, fmt.Errorf("bind user: %w", err)
+	}
+
+	return Identity{DN: entry.DN, Groups: entry.GetAttributeValues("memberOf")}, nil
+}
+
+// Role returns the role mapped from the groups of the identity.
+func (d *Directory) Role(identity Identity) string {
+	for _, gr := range d.cfg.GroupRoles {
+		for _, g := range identity.Groups {
+			if strings.EqualFold(g, gr.Group) {
+				return gr.Role
+			}
+		}
+	}
+
+	return d.cfg.DefaultRole
+}
+
+func (d *Directory) dial(ctx context.Context) (*ldapv3.Conn, error) {
+	timeout := d.cfg.Timeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = min(timeout, time.Until(deadline))
+	}
+
+	conn, err := ldapv3.DialURL(d.cfg.URL, ldapv3.DialWithDialer(&net.Dialer{Timeout: timeout}))
+	if err != nil {
+		return nil, fmt.Errorf("dial: %w", err)
+	}
+	conn.SetTimeout(timeout)
+
+	if d.cfg.StartTLS {
+		u, err := url.Parse(d.cfg.URL)
+		if err != nil {
+			conn.Close()
+
+			return nil, err
+		}
+
+		if err := conn.StartTLS(&tls.Config{ServerName: u.Hostname()}); err != nil {
+			conn.Close()
+
+			return nil, fmt.Errorf("start tls: %w", err)
+		}
+	}
+
+	return conn, nil
+}
@@ -0,0 +1,267 @@
+package ldap_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sso/internal/lib/ldap"
+	"sync"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	ldapv3 "github.com/go-ldap/ldap/v3"
+)
+
+const (
+	bindDN       = "cn=svc,dc=example,dc=com"
+	bindPassword = "svc-password"
+	baseDN       = "dc=example,dc=com"
+)
+
+type entry struct {
+	dn       string
+	password string
+	groups   []string
+}
+
+// directory is a minimal in-process LDAP server: simple bind, search by the
+// exact filter and unbind.
+type directory struct {
+	// entries found by the filter of the search request
+	entries map[string][]entry
+
+	mu      sync.Mutex
+	filters []string
+}
+
+// serve starts the server and returns its ldap:// URL.
+func (d *directory) serve(t *testing.T) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go d.handle(conn)
+		}
+	}()
+
+	return "ldap://" + l.Addr().String()
+}
+
+func (d *directory) handle(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		req, err := ber.ReadPacket(conn)
+		if err != nil || len(req.Children) < 2 {
+			return
+		}
+
+		id := req.Children[0].Value
+		op := req.Children[1]
+
+		switch op.Tag {
+		case ldapv3.ApplicationBindRequest:
+			code := d.bind(op.Children[1].Data.String(), op.Children[2].Data.String())
+			conn.Write(response(id, ldapv3.ApplicationBindResponse, code).Bytes())
+		case ldapv3.ApplicationSearchRequest:
+			filter, err := ldapv3.DecompileFilter(op.Children[6])
+			if err != nil {
+				return
+			}
+
+			d.mu.Lock()
+			d.filters = append(d.filters, filter)
+			d.mu.Unlock()
+
+			found := d.entries[filter]
+			for _, e := range found {
+				conn.Write(searchEntry(id, e).Bytes())
+			}
+
+			code := uint16(ldapv3.LDAPResultSuccess)
+			if len(found) > 1 {
+				code = ldapv3.LDAPResultSizeLimitExceeded
+			}
+			conn.Write(response(id, ldapv3.ApplicationSearchResultDone, code).Bytes())
+		default:
+			return
+		}
+	}
+}
+
+func (d *directory) bind(dn string, password string) uint16 {
+	if dn == bindDN && password == bindPassword {
+		return ldapv3.LDAPResultSuccess
+	}
+
+	for _, found := range d.entries {
+		for _, e := range found {
+			if e.dn == dn && e.password == password {
+				return ldapv3.LDAPResultSuccess
+			}
+		}
+	}
+
+	return ldapv3.LDAPResultInvalidCredentials
+}
+
+func (d *directory) searched() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return append([]string(nil), d.filters...)
+}
+
+func message(id any, op *ber.Packet) *ber.Packet {
+	p := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	p.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "MessageID"))
+	p.AppendChild(op)
+
+	return p
+}
+
+func response(id any, tag ber.Tag, code uint16) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Response")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "resultCode"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matchedDN"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "diagnosticMessage"))
+
+	return message(id, op)
+}
+
+func searchEntry(id any, e entry) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldapv3.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.dn, "objectName"))
+
+	attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attributes")
+	attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attribute")
+	attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "memberOf", "type"))
+	vals := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "vals")
+	for _, g := range e.groups {
+		vals.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, g, "value"))
+	}
+	attr.AppendChild(vals)
+	attrs.AppendChild(attr)
+	op.AppendChild(attrs)
+
+	return message(id, op)
+}
+
+func TestAuthenticate(t *testing.T) {
+	ann := entry{
+		dn:       "cn=ann,ou=people,dc=example,dc=com",
+		password: "ann-password",
+		groups:   []string{"cn=admins,ou=groups,dc=example,dc=com"},
+	}
+	twin := entry{dn: "cn=twin2,ou=people,dc=example,dc=com", password: "twin-password"}
+
+	d := &directory{entries: map[string][]entry{
+		"(&(objectClass=person)(mail=ann@example.com))":  {ann},
+		"(&(objectClass=person)(mail=twin@example.com))": {{dn: "cn=twin1,ou=people,dc=example,dc=com", password: "twin-password"}, twin},
+	}}
+	addr := d.serve(t)
+
+	dir := ldap.New(ldap.Config{URL: addr, BindDN: bindDN, BindPassword: bindPassword, BaseDN: baseDN, Timeout: time.Second})
+
+	tests := []struct {
+		name     string
+		login    string
+		password string
+		wantErr  error
+		wantDN   string
+	}{
+		{name: "valid", login: "ann@example.com", password: "ann-password", wantDN: ann.dn},
+		{name: "wrong password", login: "ann@example.com", password: "wrong", wantErr: ldap.ErrInvalidCredentials},
+		{name: "empty password", login: "ann@example.com", wantErr: ldap.ErrInvalidCredentials},
+		{name: "unknown user", login: "bob@example.com", password: "ann-password", wantErr: ldap.ErrInvalidCredentials},
+		{name: "ambiguous login", login: "twin@example.com", password: "twin-password", wantErr: ldap.ErrInvalidCredentials},
+		// Спецсимволы логина экранируются и не меняют фильтр
+		{name: "filter injection", login: "*)(mail=ann@example.com", password: "ann-password", wantErr: ldap.ErrInvalidCredentials},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity, err := dir.Authenticate(context.Background(), tt.login, tt.password)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Authenticate() error = %v, want %v", err, tt.wantErr)
+			}
+			if identity.DN != tt.wantDN {
+				t.Errorf("DN = %q, want %q", identity.DN, tt.wantDN)
+			}
+			if tt.wantErr == nil && (len(identity.Groups) != 1 || identity.Groups[0] != ann.groups[0]) {
+				t.Errorf("Groups = %v, want %v", identity.Groups, ann.groups)
+			}
+		})
+	}
+
+	want := `(&(objectClass=person)(mail=\2a\29\28mail=ann@example.com))`
+	if filters := d.searched(); filters[len(filters)-1] != want {
+		t.Errorf("filter = %q, want %q", filters[len(filters)-1], want)
+	}
+}
+
+func TestAuthenticateServiceBind(t *testing.T) {
+	d := &directory{}
+	dir := ldap.New(ldap.Config{URL: d.serve(t), BindDN: bindDN, BindPassword: "wrong", BaseDN: baseDN})
+
+	// Ошибка сервисной учётки — сбой настройки, а не неверный пароль пользователя
+	_, err := dir.Authenticate(context.Background(), "ann@example.com", "ann-password")
+	if err == nil || errors.Is(err, ldap.ErrInvalidCredentials) {
+		t.Errorf("Authenticate() error = %v, want bind failure", err)
+	}
+	if len(d.searched()) != 0 {
+		t.Error("searched without the service account")
+	}
+}
+
+func TestAuthenticateEmptyPassword(t *testing.T) {
+	// Адрес никто не слушает: пустой пароль отклоняется до соединения
+	dir := ldap.New(ldap.Config{URL: "ldap://127.0.0.1:1"})
+
+	if _, err := dir.Authenticate(context.Background(), "ann@example.com", ""); !errors.Is(err, ldap.ErrInvalidCredentials) {
+		t.Errorf("Authenticate() error = %v, want %v", err, ldap.ErrInvalidCredentials)
+	}
+}
+
+func TestRole(t *testing.T) {
+	dir := ldap.New(ldap.Config{
+		GroupRoles: []ldap.GroupRole{
+			{Group: "cn=admins,ou=groups,dc=example,dc=com", Role: "admin"},
+			{Group: "cn=staff,ou=groups,dc=example,dc=com", Role: "staff"},
+		},
+		DefaultRole: "user",
+	})
+
+	tests := []struct {
+		name   string
+		groups []string
+		want   string
+	}{
+		{name: "no groups", want: "user"},
+		{name: "unmapped", groups: []string{"cn=other,ou=groups,dc=example,dc=com"}, want: "user"},
+		{name: "mapped", groups: []string{"cn=staff,ou=groups,dc=example,dc=com"}, want: "staff"},
+		{name: "case insensitive", groups: []string{"CN=Admins,OU=Groups,DC=example,DC=com"}, want: "admin"},
+		// Побеждает первая группа из настроек, а не из memberOf
+		{name: "first mapping wins", groups: []string{"cn=staff,ou=groups,dc=example,dc=com", "cn=admins,ou=groups,dc=example,dc=com"}, want: "admin"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dir.Role(ldap.Identity{Groups: tt.groups}); got != tt.want {
+				t.Errorf("Role() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+)
+
+// Sender delivers plain-text emails.
+type Sender interface {
+	Send(ctx context.Context, to string, subject string, body string) error
+}
+
+// LogSender writes emails to the log instead of sending them.
+// Meant for local development only.
+type LogSender struct {
+	log *slog.Logger
+}
+
+func NewLogSender(log *slog.Logger) *LogSender {
+	return &LogSender{log: log}
+}
+
+func (s *LogSender) Send(_ context.Context, to string, subject string, body string) error {
+	s.log.Info("email", slog.String("to", to), slog.String("subject", subject), slog.String("body", body))
+
+	return nil
+}
+
+var ErrDisabled = errors.New("email sending is disabled")
+
+// Disabled rejects every email. Used when no provider is configured.
+type Disabled struct{}
+
+func (Disabled) Send(context.Context, string, string, string) error {
+	return ErrDisabled
+}
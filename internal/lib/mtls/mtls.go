@@ -0,0 +1,90 @@
+package mtls
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// Thumbprint returns base64url-encoded SHA-256 hash of the DER-encoded certificate,
+// as used in the "x5t#S256" confirmation claim.
+func Thumbprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// PeerCertificate returns the leaf client certificate of the gRPC peer, if any.
+func PeerCertificate(ctx context.Context) (*x509.Certificate, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.PeerCertificates) == 0 {
+		return nil, false
+	}
+
+	return tlsInfo.State.PeerCertificates[0], true
+}
+
+// PeerThumbprint returns the thumbprint of the client certificate of the gRPC peer, if any.
+func PeerThumbprint(ctx context.Context) (string, bool) {
+	cert, ok := PeerCertificate(ctx)
+	if !ok {
+		return "", false
+	}
+
+	return Thumbprint(cert), true
+}
+
+// ServerConfig loads the server certificate and, if clientCAFile is set,
+// the CA of client certificates. clientAuth is "request" to verify
+// certificates of clients presenting one or "require" to reject the rest.
+func ServerConfig(certFile string, keyFile string, clientCAFile string, clientAuth string) (*tls.Config, error) {
+	const op = "mtls.ServerConfig"
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if clientCAFile == "" {
+		return cfg, nil
+	}
+
+	pem, err := os.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	cfg.ClientCAs = x509.NewCertPool()
+	if !cfg.ClientCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s: %w", op, errors.New("no certificates in client CA file"))
+	}
+
+	switch clientAuth {
+	case "request":
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	case "require":
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("%s: unknown client auth %q", op, clientAuth)
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,112 @@
+// Package outbox relays events written to the outbox table in the
+// transaction of the change they describe. Each event is published to the
+// broker, then queued for webhooks and removed, so it is sent at least once.
+package outbox
+
+import (
+	"context"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/events"
+	"sso/internal/lib/logger/sl"
+	"sync"
+	"time"
+)
+
+const (
+	batchSize = 100
+	// lease is how long a claimed event is hidden from other instances; an
+	// event that failed to publish is retried after it.
+	lease = 30 * time.Second
+)
+
+// Store is the outbox table.
+type Store interface {
+	ClaimOutboxEvents(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxEvent, error)
+	CompleteOutboxEvent(ctx context.Context, id int64) error
+}
+
+// Relay polls the outbox and sends the events.
+type Relay struct {
+	log       *slog.Logger
+	store     Store
+	publisher events.Publisher
+	interval  time.Duration
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewRelay(log *slog.Logger, store Store, publisher events.Publisher, interval time.Duration) *Relay {
+	return &Relay{
+		log:       log.With(slog.String("component", "outbox")),
+		store:     store,
+		publisher: publisher,
+		interval:  interval,
+	}
+}
+
+// Start polls in the background until Stop.
+func (r *Relay) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		for {
+			for n := batchSize; n == batchSize; {
+				n = r.relay(ctx)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop waits for the batch in progress.
+func (r *Relay) Stop() {
+	if r.cancel == nil {
+		return
+	}
+
+	r.cancel()
+	r.wg.Wait()
+}
+
+// relay sends one batch and returns its size.
+func (r *Relay) relay(ctx context.Context) int {
+	batch, err := r.store.ClaimOutboxEvents(ctx, batchSize, lease)
+	if err != nil {
+		if ctx.Err() == nil {
+			r.log.Error("failed to claim events", sl.Err(err))
+		}
+
+		return 0
+	}
+
+	for _, e := range batch {
+		log := r.log.With(slog.String("event", e.Type), slog.String("event_id", e.EventID))
+
+		if err := r.publisher.Publish(ctx, e.Type, e.EventID, e.Payload); err != nil {
+			log.Error("failed to publish event, will retry", sl.Err(err))
+
+			continue
+		}
+
+		// Событие уже опубликовано; если не удалить, уйдёт повторно с тем же id
+		if err := r.store.CompleteOutboxEvent(context.WithoutCancel(ctx), e.ID); err != nil {
+			log.Error("failed to complete event", sl.Err(err))
+		}
+	}
+
+	return len(batch)
+}
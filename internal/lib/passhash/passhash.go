@@ -0,0 +1,208 @@
+// Package passhash hashes passwords with bcrypt or Argon2id, optionally
+// peppered, and verifies hashes of either kind.
+package passhash
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	Bcrypt   = "bcrypt"
+	Argon2id = "argon2id"
+)
+
+var (
+	ErrMismatch           = errors.New("password does not match the hash")
+	ErrUnknownAlgorithm   = errors.New("unknown password hash algorithm")
+	ErrUnknownPepper      = errors.New("password hash made with unknown pepper version")
+	errMalformedArgonHash = errors.New("malformed argon2id hash")
+)
+
+// Argon2Params are cost parameters of Argon2id. Memory is in KiB.
+type Argon2Params struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+// DefaultArgon2 are parameters recommended by RFC 9106 for memory-constrained environments.
+var DefaultArgon2 = Argon2Params{Time: 3, Memory: 64 * 1024, Threads: 4}
+
+const (
+	argonSaltLen = 16
+	argonKeyLen  = 32
+)
+
+// Hasher hashes new passwords with Algorithm. Compare accepts hashes of
+// any supported algorithm, so the algorithm can be changed at any time.
+type Hasher struct {
+	Algorithm string
+	Argon2    Argon2Params
+	// peppers by version, the highest version is used for new hashes.
+	peppers map[int][]byte
+	pepper  int
+}
+
+// New returns a hasher for algorithm; zero argon2 params are taken from DefaultArgon2.
+// Passwords are mixed with the pepper of the highest version, see pepper.go.
+func New(algorithm string, params Argon2Params, peppers map[int][]byte) (Hasher, error) {
+	if algorithm != Bcrypt && algorithm != Argon2id {
+		return Hasher{}, fmt.Errorf("%w: %s", ErrUnknownAlgorithm, algorithm)
+	}
+
+	if params.Time == 0 {
+		params.Time = DefaultArgon2.Time
+	}
+	if params.Memory == 0 {
+		params.Memory = DefaultArgon2.Memory
+	}
+	if params.Threads == 0 {
+		params.Threads = DefaultArgon2.Threads
+	}
+
+	h := Hasher{Algorithm: algorithm, Argon2: params, peppers: peppers}
+	for version := range peppers {
+		if version <= 0 {
+			return Hasher{}, fmt.Errorf("pepper version must be positive: %d", version)
+		}
+		h.pepper = max(h.pepper, version)
+	}
+
+	return h, nil
+}
+
+func (h Hasher) Hash(password string) ([]byte, error) {
+	if h.pepper == 0 {
+		return h.hash(password)
+	}
+
+	hash, err := h.hash(pepper(h.peppers[h.pepper], password))
+	if err != nil {
+		return nil, err
+	}
+
+	return append(pepperPrefix(h.pepper), hash...), nil
+}
+
+// Compare returns ErrMismatch if password doesn't match the hash.
+func (h Hasher) Compare(hash []byte, password string) error {
+	version, hash := splitPepper(hash)
+	if version == 0 {
+		return h.compare(hash, password)
+	}
+
+	secret, ok := h.peppers[version]
+	if !ok {
+		return fmt.Errorf("%w: %d", ErrUnknownPepper, version)
+	}
+
+	return h.compare(hash, pepper(secret, password))
+}
+
+// NeedsRehash reports whether the hash was made with another algorithm,
+// Argon2id parameters or pepper than the hasher uses. Bcrypt hashes are not
+// rehashed on cost changes while bcrypt stays selected.
+func (h Hasher) NeedsRehash(hash []byte) bool {
+	version, hash := splitPepper(hash)
+	if version != h.pepper {
+		return true
+	}
+
+	return h.needsRehash(hash)
+}
+
+func (h Hasher) hash(password string) ([]byte, error) {
+	if h.Algorithm != Argon2id {
+		return bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	}
+
+	salt := make([]byte, argonSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	p := h.Argon2
+	key := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, argonKeyLen)
+
+	// Формат PHC, как у reference реализации и большинства библиотек
+	return fmt.Appendf(nil, "$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.Memory, p.Time, p.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (h Hasher) compare(hash []byte, password string) error {
+	if !isArgon2id(hash) {
+		err := bcrypt.CompareHashAndPassword(hash, []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return ErrMismatch
+		}
+
+		return err
+	}
+
+	p, salt, key, err := parseArgon2id(hash)
+	if err != nil {
+		return err
+	}
+
+	other := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return ErrMismatch
+	}
+
+	return nil
+}
+
+func (h Hasher) needsRehash(hash []byte) bool {
+	if !isArgon2id(hash) {
+		return h.Algorithm == Argon2id
+	}
+
+	if h.Algorithm != Argon2id {
+		return true
+	}
+
+	p, _, _, err := parseArgon2id(hash)
+
+	return err != nil || p != h.Argon2
+}
+
+func isArgon2id(hash []byte) bool {
+	return bytes.HasPrefix(hash, []byte("$argon2id$"))
+}
+
+func parseArgon2id(hash []byte) (p Argon2Params, salt []byte, key []byte, err error) {
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, key
+	fields := bytes.Split(hash, []byte("$"))
+	if len(fields) != 6 {
+		return Argon2Params{}, nil, nil, errMalformedArgonHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(string(fields[2]), "v=%d", &version); err != nil || version != argon2.Version {
+		return Argon2Params{}, nil, nil, errMalformedArgonHash
+	}
+
+	if _, err := fmt.Sscanf(string(fields[3]), "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil {
+		return Argon2Params{}, nil, nil, errMalformedArgonHash
+	}
+
+	if salt, err = base64.RawStdEncoding.DecodeString(string(fields[4])); err != nil {
+		return Argon2Params{}, nil, nil, errMalformedArgonHash
+	}
+
+	if key, err = base64.RawStdEncoding.DecodeString(string(fields[5])); err != nil || len(key) == 0 {
+		return Argon2Params{}, nil, nil, errMalformedArgonHash
+	}
+
+	return p, salt, key, nil
+}
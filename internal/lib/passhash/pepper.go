@@ -0,0 +1,49 @@
+package passhash
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+)
+
+// Peppered hashes are prefixed with the pepper version, e.g.
+// "$pepper$v=2$2a$10$..." for a bcrypt hash made with pepper 2. Hashes made
+// with an older pepper keep working while it is configured and are replaced
+// on login, after which the old pepper can be removed.
+const pepperMarker = "$pepper$v="
+
+// pepper mixes the secret into the password. HMAC output is base64 encoded
+// to fit in 72 bytes bcrypt uses and to avoid NUL bytes.
+func pepper(secret []byte, password string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(password))
+
+	return base64.RawStdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func pepperPrefix(version int) []byte {
+	return strconv.AppendInt([]byte(pepperMarker), int64(version), 10)
+}
+
+// splitPepper returns the pepper version of the hash, zero if it isn't
+// peppered, and the hash itself.
+func splitPepper(hash []byte) (int, []byte) {
+	rest, ok := bytes.CutPrefix(hash, []byte(pepperMarker))
+	if !ok {
+		return 0, hash
+	}
+
+	i := bytes.IndexByte(rest, '$')
+	if i < 0 {
+		return 0, hash
+	}
+
+	version, err := strconv.Atoi(string(rest[:i]))
+	if err != nil || version <= 0 {
+		return 0, hash
+	}
+
+	return version, rest[i:]
+}
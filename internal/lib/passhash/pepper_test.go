@@ -0,0 +1,122 @@
+package passhash_test
+
+import (
+	"bytes"
+	"errors"
+	"sso/internal/lib/passhash"
+	"strings"
+	"testing"
+)
+
+// fast keeps Argon2id cheap in tests.
+var fast = passhash.Argon2Params{Time: 1, Memory: 64, Threads: 1}
+
+func newHasher(t *testing.T, algorithm string, peppers map[int][]byte) passhash.Hasher {
+	t.Helper()
+
+	h, err := passhash.New(algorithm, fast, peppers)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	return h
+}
+
+func mustHash(t *testing.T, h passhash.Hasher, password string) []byte {
+	t.Helper()
+
+	hash, err := h.Hash(password)
+	if err != nil {
+		t.Fatalf("Hash() error = %v", err)
+	}
+
+	return hash
+}
+
+func TestPepper(t *testing.T) {
+	for _, algorithm := range []string{passhash.Bcrypt, passhash.Argon2id} {
+		t.Run(algorithm, func(t *testing.T) {
+			plain := newHasher(t, algorithm, nil)
+			v1 := newHasher(t, algorithm, map[int][]byte{1: []byte("old")})
+			v2 := newHasher(t, algorithm, map[int][]byte{1: []byte("old"), 2: []byte("new")})
+			onlyV2 := newHasher(t, algorithm, map[int][]byte{2: []byte("new")})
+			otherV2 := newHasher(t, algorithm, map[int][]byte{2: []byte("other")})
+
+			hash := mustHash(t, v2, "correct-password")
+			if !bytes.HasPrefix(hash, []byte("$pepper$v=2$")) {
+				t.Fatalf("hash = %q, want pepper version 2 prefix", hash)
+			}
+
+			if err := v2.Compare(hash, "correct-password"); err != nil {
+				t.Errorf("Compare() error = %v", err)
+			}
+			if err := v2.Compare(hash, "wrong-password"); !errors.Is(err, passhash.ErrMismatch) {
+				t.Errorf("Compare() of wrong password: error = %v, want %v", err, passhash.ErrMismatch)
+			}
+			// Без перца хеш не проверить, даже зная пароль
+			if err := otherV2.Compare(hash, "correct-password"); !errors.Is(err, passhash.ErrMismatch) {
+				t.Errorf("Compare() with another pepper: error = %v, want %v", err, passhash.ErrMismatch)
+			}
+			if err := v1.Compare(hash, "correct-password"); !errors.Is(err, passhash.ErrUnknownPepper) {
+				t.Errorf("Compare() without the pepper version: error = %v, want %v", err, passhash.ErrUnknownPepper)
+			}
+			if v2.NeedsRehash(hash) {
+				t.Error("NeedsRehash() of a current hash = true")
+			}
+
+			// Хеши со старым перцем и без перца проверяются и подлежат замене
+			old := mustHash(t, v1, "correct-password")
+			if err := v2.Compare(old, "correct-password"); err != nil {
+				t.Errorf("Compare() of old pepper hash: error = %v", err)
+			}
+			if !v2.NeedsRehash(old) {
+				t.Error("NeedsRehash() of old pepper hash = false")
+			}
+			if err := onlyV2.Compare(old, "correct-password"); !errors.Is(err, passhash.ErrUnknownPepper) {
+				t.Errorf("Compare() of removed pepper hash: error = %v, want %v", err, passhash.ErrUnknownPepper)
+			}
+
+			unpeppered := mustHash(t, plain, "correct-password")
+			if err := v2.Compare(unpeppered, "correct-password"); err != nil {
+				t.Errorf("Compare() of unpeppered hash: error = %v", err)
+			}
+			if !v2.NeedsRehash(unpeppered) {
+				t.Error("NeedsRehash() of unpeppered hash = false")
+			}
+			if !plain.NeedsRehash(hash) {
+				t.Error("NeedsRehash() of peppered hash without peppers = false")
+			}
+		})
+	}
+}
+
+func TestPepperLongPassword(t *testing.T) {
+	h := newHasher(t, passhash.Bcrypt, map[int][]byte{1: []byte("secret")})
+
+	// bcrypt отбрасывает всё после 72 байт, HMAC перца учитывает весь пароль
+	long := strings.Repeat("a", 72)
+	hash := mustHash(t, h, long+"1")
+
+	if err := h.Compare(hash, long+"2"); !errors.Is(err, passhash.ErrMismatch) {
+		t.Errorf("Compare() of password differing after 72 bytes: error = %v, want %v", err, passhash.ErrMismatch)
+	}
+}
+
+func TestNewPepperVersion(t *testing.T) {
+	for _, version := range []int{0, -1} {
+		if _, err := passhash.New(passhash.Bcrypt, fast, map[int][]byte{version: []byte("secret")}); err == nil {
+			t.Errorf("New() with pepper version %d: error = nil", version)
+		}
+	}
+}
+
+func TestMalformedPepperPrefix(t *testing.T) {
+	h := newHasher(t, passhash.Bcrypt, map[int][]byte{1: []byte("secret")})
+
+	// Испорченный префикс не принимается за версию перца
+	for _, hash := range []string{"$pepper$v=x$2a$10$abc", "$pepper$v=0$2a$10$abc", "$pepper$v=1"} {
+		if err := h.Compare([]byte(hash), "password"); err == nil {
+			t.Errorf("Compare(%q) error = nil", hash)
+		}
+	}
+}
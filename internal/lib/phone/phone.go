@@ -0,0 +1,48 @@
+package phone
+
+import (
+	"errors"
+	"strings"
+)
+
+var ErrInvalid = errors.New("invalid phone number")
+
+// Normalize converts a phone number to E.164 form ("+" and 8 to 15 digits).
+// Spaces, dashes, dots and parentheses are dropped and a leading "00" is treated as "+".
+func Normalize(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "00") {
+		s = "+" + s[2:]
+	}
+
+	if !strings.HasPrefix(s, "+") {
+		return "", ErrInvalid
+	}
+
+	var b strings.Builder
+	b.WriteByte('+')
+
+	for _, r := range s[1:] {
+		switch {
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == ' ' || r == '-' || r == '.' || r == '(' || r == ')':
+		default:
+			return "", ErrInvalid
+		}
+	}
+
+	e164 := b.String()
+	if digits := len(e164) - 1; digits < 8 || digits > 15 || e164[1] == '0' {
+		return "", ErrInvalid
+	}
+
+	return e164, nil
+}
+
+// Looks reports whether s is meant to be a phone number rather than email or username.
+func Looks(s string) bool {
+	s = strings.TrimSpace(s)
+
+	return strings.HasPrefix(s, "+") || strings.HasPrefix(s, "00")
+}
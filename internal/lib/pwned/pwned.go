@@ -0,0 +1,79 @@
+// Package pwned checks passwords against the Have I Been Pwned range API.
+// Only the first 5 hex digits of the password SHA-1 leave the service
+// (k-anonymity), see https://haveibeenpwned.com/API/v3#PwnedPasswords.
+package pwned
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DefaultURL is the public range API.
+const DefaultURL = "https://api.pwnedpasswords.com"
+
+type Client struct {
+	url  string
+	http *http.Client
+}
+
+// New queries the range API at url, DefaultURL if empty.
+func New(url string, httpClient *http.Client) *Client {
+	if url == "" {
+		url = DefaultURL
+	}
+
+	return &Client{url: strings.TrimSuffix(url, "/"), http: httpClient}
+}
+
+// Count returns how many times the password appeared in known breaches.
+func (c *Client) Count(ctx context.Context, password string) (int, error) {
+	const op = "pwned.Count"
+
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url+"/range/"+prefix, nil)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	// Паддинг скрывает по размеру ответа, какой префикс запрошен
+	req.Header.Set("Add-Padding", "true")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("%s: api responded with %s", op, resp.Status)
+	}
+
+	// Строки вида SUFFIX:COUNT, у паддинга COUNT равен 0
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		s, count, ok := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if !ok || s != suffix {
+			continue
+		}
+
+		n, err := strconv.Atoi(count)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", op, err)
+		}
+
+		return n, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return 0, nil
+}
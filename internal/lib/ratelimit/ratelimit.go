@@ -0,0 +1,98 @@
+// Package ratelimit implements in-process token buckets.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Limit refills the bucket with PerMinute tokens a minute up to Burst.
+// Zero PerMinute means unlimited; zero Burst allows PerMinute at once.
+type Limit struct {
+	PerMinute float64
+	Burst     int
+}
+
+// Unlimited reports whether the limit lets everything through.
+func (l Limit) Unlimited() bool {
+	return l.PerMinute <= 0
+}
+
+func (l Limit) burst() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+
+	return l.PerMinute
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	// full is how long the bucket takes to refill from empty.
+	full time.Duration
+}
+
+// Limiter keeps a bucket per key. Buckets are forgotten once full again,
+// so memory is bounded by keys active within the refill time.
+type Limiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func New() *Limiter {
+	return &Limiter{buckets: make(map[string]*bucket)}
+}
+
+// Allow takes a token from the bucket of key. If there is none, it returns
+// false and how long to wait for the next token.
+func (l *Limiter) Allow(key string, limit Limit, now time.Time) (bool, time.Duration) {
+	if limit.Unlimited() {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	rate := limit.PerMinute / 60 // токенов в секунду
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{
+			tokens: limit.burst(),
+			last:   now,
+			full:   time.Duration(limit.burst() / rate * float64(time.Second)),
+		}
+		l.buckets[key] = b
+	}
+
+	b.tokens = min(limit.burst(), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / rate * float64(time.Second))
+
+		return false, wait
+	}
+
+	b.tokens--
+
+	return true, 0
+}
+
+// sweep drops buckets idle long enough to be full, at most once a minute.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > b.full {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+// Package requestid correlates logs of a single request across layers.
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+)
+
+// Header is the gRPC metadata key and HTTP header carrying the id.
+const Header = "x-request-id"
+
+// maxLen bounds ids supplied by clients, which end up in every log record.
+const maxLen = 128
+
+type ctxKey struct{}
+
+// New generates a random id.
+func New() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// Valid reports whether an id supplied by a client may be used as is.
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLen {
+		return false
+	}
+
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+
+	return true
+}
+
+// WithID returns ctx carrying the request id.
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// FromContext returns the request id, or "" outside of a request.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(ctxKey{}).(string)
+
+	return id
+}
+
+// Logger returns log with the request id of ctx attached, if there is one.
+func Logger(ctx context.Context, log *slog.Logger) *slog.Logger {
+	if id := FromContext(ctx); id != "" {
+		return log.With(slog.String("request_id", id))
+	}
+
+	return log
+}
@@ -0,0 +1,70 @@
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+var ErrDecrypt = errors.New("cannot decrypt secret")
+
+// Box encrypts secrets stored in the database with AES-256-GCM.
+type Box struct {
+	aead cipher.AEAD
+}
+
+// NewBox takes a 32-byte key.
+func NewBox(key []byte) (*Box, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("key must be 32 bytes, got %d", len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Box{aead: aead}, nil
+}
+
+// NewBoxFromString takes a base64-encoded 32-byte key, as kept in config.
+func NewBoxFromString(key string) (*Box, error) {
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("key must be base64: %w", err)
+	}
+
+	return NewBox(raw)
+}
+
+// Seal returns nonce followed by the ciphertext.
+func (b *Box) Seal(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return b.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func (b *Box) Open(sealed []byte) ([]byte, error) {
+	n := b.aead.NonceSize()
+	if len(sealed) < n {
+		return nil, ErrDecrypt
+	}
+
+	plaintext, err := b.aead.Open(nil, sealed[:n], sealed[n:], nil)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+
+	return plaintext, nil
+}
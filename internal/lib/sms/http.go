@@ -0,0 +1,60 @@
+package sms
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// HTTPSender posts messages as JSON {"to": ..., "text": ...} to an SMS gateway.
+// Most providers either accept this directly or sit behind a small adapter.
+type HTTPSender struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+// NewHTTPSender sends to url; a non-empty token is passed as Bearer authorization.
+func NewHTTPSender(url string, token string, timeout time.Duration) *HTTPSender {
+	return &HTTPSender{
+		url:    url,
+		token:  token,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+func (s *HTTPSender) Send(ctx context.Context, phone string, text string) error {
+	const op = "sms.HTTPSender.Send"
+
+	body, err := json.Marshal(map[string]string{"to": phone, "text": text})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer resp.Body.Close()
+
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: gateway responded with %s", op, resp.Status)
+	}
+
+	return nil
+}
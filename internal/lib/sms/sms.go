@@ -0,0 +1,37 @@
+package sms
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+)
+
+// Sender delivers text messages to E.164 phone numbers.
+type Sender interface {
+	Send(ctx context.Context, phone string, text string) error
+}
+
+// LogSender writes messages to the log instead of sending them.
+// Meant for local development only.
+type LogSender struct {
+	log *slog.Logger
+}
+
+func NewLogSender(log *slog.Logger) *LogSender {
+	return &LogSender{log: log}
+}
+
+func (s *LogSender) Send(_ context.Context, phone string, text string) error {
+	s.log.Info("sms", slog.String("phone", phone), slog.String("text", text))
+
+	return nil
+}
+
+var ErrDisabled = errors.New("sms sending is disabled")
+
+// Disabled rejects every message. Used when no provider is configured.
+type Disabled struct{}
+
+func (Disabled) Send(context.Context, string, string) error {
+	return ErrDisabled
+}
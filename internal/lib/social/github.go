@@ -0,0 +1,56 @@
+package social
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+)
+
+type github struct {
+	client
+}
+
+// NewGitHub signs users in with GitHub accounts.
+func NewGitHub(cfg Config, httpClient *http.Client) Provider {
+	return &github{client{
+		cfg:      cfg,
+		http:     httpClient,
+		authURL:  "https://github.com/login/oauth/authorize",
+		tokenURL: "https://github.com/login/oauth/access_token",
+		scopes:   []string{"read:user", "user:email"},
+	}}
+}
+
+func (g *github) Identify(ctx context.Context, code string) (Identity, error) {
+	token, err := g.exchange(ctx, code)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	var user struct {
+		ID int64 `json:"id"`
+	}
+	if err := g.get(ctx, "https://api.github.com/user", token, &user); err != nil {
+		return Identity{}, err
+	}
+
+	// Email в профиле может быть скрыт, берём основной из списка адресов
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := g.get(ctx, "https://api.github.com/user/emails", token, &emails); err != nil {
+		return Identity{}, err
+	}
+
+	id := Identity{Subject: strconv.FormatInt(user.ID, 10)}
+	for _, e := range emails {
+		if e.Primary {
+			id.Email = e.Email
+			id.EmailVerified = e.Verified
+		}
+	}
+
+	return id, nil
+}
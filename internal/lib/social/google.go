@@ -0,0 +1,39 @@
+package social
+
+import (
+	"context"
+	"net/http"
+)
+
+type google struct {
+	client
+}
+
+// NewGoogle signs users in with Google accounts.
+func NewGoogle(cfg Config, httpClient *http.Client) Provider {
+	return &google{client{
+		cfg:      cfg,
+		http:     httpClient,
+		authURL:  "https://accounts.google.com/o/oauth2/v2/auth",
+		tokenURL: "https://oauth2.googleapis.com/token",
+		scopes:   []string{"openid", "email"},
+	}}
+}
+
+func (g *google) Identify(ctx context.Context, code string) (Identity, error) {
+	token, err := g.exchange(ctx, code)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	var info struct {
+		Sub           string `json:"sub"`
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+	}
+	if err := g.get(ctx, "https://openidconnect.googleapis.com/v1/userinfo", token, &info); err != nil {
+		return Identity{}, err
+	}
+
+	return Identity{Subject: info.Sub, Email: info.Email, EmailVerified: info.EmailVerified}, nil
+}
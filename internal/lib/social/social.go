@@ -0,0 +1,131 @@
+// Package social signs users in with external identity providers
+// (Google, GitHub) using the OAuth2 authorization code flow.
+package social
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var ErrExchange = errors.New("identity provider rejected the authorization code")
+
+// Identity is the user as reported by the provider.
+type Identity struct {
+	// Subject is the stable user id at the provider.
+	Subject string
+	Email   string
+	// EmailVerified is true if the provider confirmed the user owns Email.
+	EmailVerified bool
+}
+
+// Provider is an external identity provider.
+type Provider interface {
+	// AuthURL is where the user is sent to log in; state is returned to the callback.
+	AuthURL(state string) string
+	// Identify exchanges the code from the callback and fetches the user.
+	Identify(ctx context.Context, code string) (Identity, error)
+}
+
+// Config of an OAuth2 client registered at the provider.
+type Config struct {
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is our callback registered at the provider.
+	RedirectURL string
+}
+
+// client holds the parts of the flow common to providers.
+type client struct {
+	cfg      Config
+	http     *http.Client
+	authURL  string
+	tokenURL string
+	scopes   []string
+}
+
+func (c *client) AuthURL(state string) string {
+	q := url.Values{
+		"response_type": {"code"},
+		"client_id":     {c.cfg.ClientID},
+		"redirect_uri":  {c.cfg.RedirectURL},
+		"scope":         {strings.Join(c.scopes, " ")},
+		"state":         {state},
+	}
+
+	return c.authURL + "?" + q.Encode()
+}
+
+// exchange returns the access token for the code.
+func (c *client) exchange(ctx context.Context, code string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {c.cfg.RedirectURL},
+		"client_id":     {c.cfg.ClientID},
+		"client_secret": {c.cfg.ClientSecret},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	// GitHub отвечает form-urlencoded без этого заголовка
+	req.Header.Set("Accept", "application/json")
+
+	var resp struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+	}
+	if err := c.do(req, &resp); err != nil {
+		return "", err
+	}
+
+	if resp.AccessToken == "" {
+		return "", fmt.Errorf("%w: %s", ErrExchange, resp.Error)
+	}
+
+	return resp.AccessToken, nil
+}
+
+// get fetches JSON from an API of the provider with the access token.
+func (c *client) get(ctx context.Context, apiURL string, accessToken string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+
+	return c.do(req, v)
+}
+
+func (c *client) do(req *http.Request, v any) error {
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+
+	// Token endpoints report errors in the body with status 400
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusBadRequest {
+		return fmt.Errorf("%s %s: unexpected status %d", req.Method, req.URL.Host, resp.StatusCode)
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("%s %s: %w", req.Method, req.URL.Host, err)
+	}
+
+	return nil
+}
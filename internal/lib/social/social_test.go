@@ -0,0 +1,154 @@
+package social
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// transport answers requests to any host with the handler, so providers can
+// be tested without changing their endpoints.
+type transport struct {
+	h http.Handler
+}
+
+func (t transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	w := httptest.NewRecorder()
+	t.h.ServeHTTP(w, r)
+
+	return w.Result(), nil
+}
+
+var cfg = Config{ClientID: "client", ClientSecret: "secret", RedirectURL: "https://sso.example.com/oauth/callback"}
+
+// newAPI fakes the token endpoint and the user APIs; the token endpoint
+// accepts only the code "good".
+func newAPI(t *testing.T, apis map[string]any) *http.Client {
+	t.Helper()
+
+	mux := http.NewServeMux()
+
+	token := func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("parse token request: %v", err)
+		}
+		if r.PostForm.Get("client_secret") != cfg.ClientSecret || r.PostForm.Get("redirect_uri") != cfg.RedirectURL {
+			t.Errorf("token request = %v", r.PostForm)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if r.PostForm.Get("code") != "good" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "at"})
+	}
+	mux.HandleFunc("POST oauth2.googleapis.com/token", token)
+	mux.HandleFunc("POST github.com/login/oauth/access_token", token)
+
+	for pattern, body := range apis {
+		mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer at" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+
+				return
+			}
+
+			json.NewEncoder(w).Encode(body)
+		})
+	}
+
+	return &http.Client{Transport: transport{h: mux}}
+}
+
+func TestAuthURL(t *testing.T) {
+	u, err := url.Parse(NewGoogle(cfg, nil).AuthURL("st&ate"))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	q := u.Query()
+	if u.Host != "accounts.google.com" || q.Get("state") != "st&ate" || q.Get("client_id") != "client" ||
+		q.Get("redirect_uri") != cfg.RedirectURL || q.Get("scope") != "openid email" || q.Get("response_type") != "code" {
+		t.Errorf("AuthURL() = %s", u)
+	}
+}
+
+func TestGoogle(t *testing.T) {
+	client := newAPI(t, map[string]any{
+		"GET openidconnect.googleapis.com/v1/userinfo": map[string]any{"sub": "123", "email": "ann@example.com", "email_verified": true},
+	})
+	g := NewGoogle(cfg, client)
+
+	identity, err := g.Identify(context.Background(), "good")
+	if err != nil {
+		t.Fatalf("Identify() error = %v", err)
+	}
+	if identity != (Identity{Subject: "123", Email: "ann@example.com", EmailVerified: true}) {
+		t.Errorf("Identify() = %+v", identity)
+	}
+
+	if _, err := g.Identify(context.Background(), "bad"); !errors.Is(err, ErrExchange) {
+		t.Errorf("Identify() with a rejected code: error = %v, want %v", err, ErrExchange)
+	}
+}
+
+func TestGitHub(t *testing.T) {
+	tests := []struct {
+		name   string
+		emails []map[string]any
+		want   Identity
+	}{
+		{
+			name: "primary",
+			emails: []map[string]any{
+				{"email": "other@example.com", "primary": false, "verified": true},
+				{"email": "ann@example.com", "primary": true, "verified": true},
+			},
+			want: Identity{Subject: "42", Email: "ann@example.com", EmailVerified: true},
+		},
+		{
+			// Неподтверждённый основной адрес не подменяется подтверждённым дополнительным
+			name: "unverified primary",
+			emails: []map[string]any{
+				{"email": "ann@example.com", "primary": true, "verified": false},
+				{"email": "other@example.com", "primary": false, "verified": true},
+			},
+			want: Identity{Subject: "42", Email: "ann@example.com"},
+		},
+		{name: "no emails", want: Identity{Subject: "42"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newAPI(t, map[string]any{
+				"GET api.github.com/user":        map[string]any{"id": 42},
+				"GET api.github.com/user/emails": tt.emails,
+			})
+
+			identity, err := NewGitHub(cfg, client).Identify(context.Background(), "good")
+			if err != nil {
+				t.Fatalf("Identify() error = %v", err)
+			}
+			if identity != tt.want {
+				t.Errorf("Identify() = %+v, want %+v", identity, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnexpectedStatus(t *testing.T) {
+	client := &http.Client{Transport: transport{h: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "oops", http.StatusInternalServerError)
+	})}}
+
+	if _, err := NewGoogle(cfg, client).Identify(context.Background(), "good"); err == nil {
+		t.Error("Identify() error = nil")
+	}
+}
@@ -0,0 +1,99 @@
+package totp_test
+
+import (
+	"net/url"
+	"sso/internal/lib/totp"
+	"testing"
+	"time"
+)
+
+// secret of the SHA-1 test vectors in RFC 6238 appendix B.
+var rfcSecret = []byte("12345678901234567890")
+
+func TestCode(t *testing.T) {
+	// Шестизначные коды — младшие цифры восьмизначных из RFC
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1111111111, want: "050471"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+		{unix: 20000000000, want: "353130"},
+	}
+
+	for _, tt := range tests {
+		if got := totp.Code(rfcSecret, totp.Step(time.Unix(tt.unix, 0))); got != tt.want {
+			t.Errorf("Code() at %d = %q, want %q", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := totp.Step(now)
+
+	tests := []struct {
+		name   string
+		code   string
+		wantOK bool
+	}{
+		{name: "current", code: totp.Code(rfcSecret, step), wantOK: true},
+		{name: "previous", code: totp.Code(rfcSecret, step-1), wantOK: true},
+		{name: "next", code: totp.Code(rfcSecret, step+1), wantOK: true},
+		{name: "too old", code: totp.Code(rfcSecret, step-2)},
+		{name: "too new", code: totp.Code(rfcSecret, step+2)},
+		{name: "empty", code: ""},
+		{name: "prefix", code: totp.Code(rfcSecret, step)[:5]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := totp.Verify(rfcSecret, tt.code, now)
+			if ok != tt.wantOK {
+				t.Fatalf("Verify() ok = %v, want %v", ok, tt.wantOK)
+			}
+			// Вызывающий запоминает шаг, поэтому он должен быть шагом кода
+			if ok && totp.Code(rfcSecret, got) != tt.code {
+				t.Errorf("Verify() step = %d doesn't produce the code", got)
+			}
+		})
+	}
+
+	if _, ok := totp.Verify([]byte("another secret"), totp.Code(rfcSecret, step), now); ok {
+		t.Error("Verify() accepted the code of another secret")
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	a, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatalf("GenerateSecret() error = %v", err)
+	}
+	b, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatalf("GenerateSecret() error = %v", err)
+	}
+
+	if len(a) != 20 || string(a) == string(b) {
+		t.Errorf("GenerateSecret() = %x, %x, want two different 20-byte secrets", a, b)
+	}
+}
+
+func TestURI(t *testing.T) {
+	u, err := url.Parse(totp.URI("SSO", "ann@example.com", rfcSecret))
+	if err != nil {
+		t.Fatalf("parse uri: %v", err)
+	}
+
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/SSO:ann@example.com" {
+		t.Errorf("uri = %s", u)
+	}
+
+	q := u.Query()
+	if q.Get("secret") != "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" || q.Get("issuer") != "SSO" || q.Get("digits") != "6" || q.Get("period") != "30" {
+		t.Errorf("query = %v", q)
+	}
+}
@@ -0,0 +1,70 @@
+// Package ttlcache implements an in-process cache of values expiring after a fixed time.
+package ttlcache
+
+import (
+	"sync"
+	"time"
+)
+
+type entry[V any] struct {
+	value     V
+	expiresAt time.Time
+}
+
+// Cache keeps values for ttl since they were set. Expired values are dropped
+// on a sweep every ttl, so memory is bounded by keys set within about 2*ttl.
+type Cache[K comparable, V any] struct {
+	ttl       time.Duration
+	mu        sync.Mutex
+	entries   map[K]entry[V]
+	lastSweep time.Time
+}
+
+func New[K comparable, V any](ttl time.Duration) *Cache[K, V] {
+	return &Cache[K, V]{ttl: ttl, entries: make(map[K]entry[V])}
+}
+
+// Get returns the value of key unless it's missing or expired.
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok || !time.Now().Before(e.expiresAt) {
+		var zero V
+
+		return zero, false
+	}
+
+	return e.value, true
+}
+
+func (c *Cache[K, V]) Set(key K, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	c.sweep(now)
+	c.entries[key] = entry[V]{value: value, expiresAt: now.Add(c.ttl)}
+}
+
+// Delete drops the value of key, e.g. when the source of it changes.
+func (c *Cache[K, V]) Delete(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, key)
+}
+
+func (c *Cache[K, V]) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < c.ttl {
+		return
+	}
+	c.lastSweep = now
+
+	for key, e := range c.entries {
+		if !now.Before(e.expiresAt) {
+			delete(c.entries, key)
+		}
+	}
+}
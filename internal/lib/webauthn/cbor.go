@@ -0,0 +1,114 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var errCBOR = errors.New("malformed cbor")
+
+// decodeCBOR decodes the subset of CBOR used by authenticators: integers,
+// byte and text strings, arrays, maps and simple values of definite length.
+// It returns the value and the number of bytes consumed.
+//
+// Maps are decoded as map[any]any with int64 or string keys.
+func decodeCBOR(data []byte) (any, int, error) {
+	return decodeItem(data, 0)
+}
+
+func decodeItem(data []byte, depth int) (any, int, error) {
+	if len(data) == 0 || depth > 16 {
+		return nil, 0, errCBOR
+	}
+
+	major := data[0] >> 5
+	info := data[0] & 0x1f
+
+	arg, n, err := readArg(data, info)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch major {
+	case 0:
+		return int64(arg), n, nil
+	case 1:
+		return -1 - int64(arg), n, nil
+	case 2, 3:
+		if uint64(len(data)-n) < arg {
+			return nil, 0, errCBOR
+		}
+		b := data[n : n+int(arg)]
+		if major == 3 {
+			return string(b), n + int(arg), nil
+		}
+
+		return b, n + int(arg), nil
+	case 4:
+		arr := make([]any, 0, min(arg, 64))
+		for i := uint64(0); i < arg; i++ {
+			v, m, err := decodeItem(data[n:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			arr = append(arr, v)
+			n += m
+		}
+
+		return arr, n, nil
+	case 5:
+		m := make(map[any]any, min(arg, 64))
+		for i := uint64(0); i < arg; i++ {
+			k, kn, err := decodeItem(data[n:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			n += kn
+
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, 0, errCBOR
+			}
+
+			v, vn, err := decodeItem(data[n:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			n += vn
+
+			m[k] = v
+		}
+
+		return m, n, nil
+	case 7:
+		switch info {
+		case 20:
+			return false, n, nil
+		case 21:
+			return true, n, nil
+		case 22, 23:
+			return nil, n, nil
+		}
+	}
+
+	return nil, 0, errCBOR
+}
+
+// readArg reads the argument of the initial byte; indefinite lengths are not supported.
+func readArg(data []byte, info byte) (uint64, int, error) {
+	switch {
+	case info < 24:
+		return uint64(info), 1, nil
+	case info == 24 && len(data) >= 2:
+		return uint64(data[1]), 2, nil
+	case info == 25 && len(data) >= 3:
+		return uint64(binary.BigEndian.Uint16(data[1:])), 3, nil
+	case info == 26 && len(data) >= 5:
+		return uint64(binary.BigEndian.Uint32(data[1:])), 5, nil
+	case info == 27 && len(data) >= 9:
+		return binary.BigEndian.Uint64(data[1:]), 9, nil
+	}
+
+	return 0, 0, errCBOR
+}
@@ -0,0 +1,270 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+)
+
+var ErrInvalidResponse = errors.New("invalid webauthn response")
+
+// Authenticator data flags.
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttested     = 0x40
+)
+
+// COSE algorithms, see https://www.iana.org/assignments/cose.
+const (
+	algES256 = -7
+	algEdDSA = -8
+	algRS256 = -257
+)
+
+// Config identifies the relying party.
+type Config struct {
+	// RPID is the domain passkeys are scoped to, e.g. "example.com".
+	RPID string
+	// Origins are accepted origins of clientDataJSON, e.g. "https://app.example.com".
+	Origins []string
+}
+
+// Credential is a passkey registered by the user.
+type Credential struct {
+	ID []byte
+	// PublicKey is PKIX DER.
+	PublicKey []byte
+	SignCount uint32
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// NewChallenge returns a random challenge, base64url-encoded as it appears in clientDataJSON.
+func NewChallenge() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Challenge returns the challenge the client answered, to find the ceremony it belongs to.
+func Challenge(clientDataJSON []byte) (string, error) {
+	var cd clientData
+	if err := json.Unmarshal(clientDataJSON, &cd); err != nil || cd.Challenge == "" {
+		return "", ErrInvalidResponse
+	}
+
+	return cd.Challenge, nil
+}
+
+// VerifyRegistration checks the response of navigator.credentials.create()
+// and returns the new credential.
+//
+// Attestation statements aren't verified: passkeys are requested with
+// attestation "none", the authenticator model doesn't matter to us.
+func (c Config) VerifyRegistration(challenge string, clientDataJSON []byte, attestationObject []byte) (Credential, error) {
+	if err := c.verifyClientData(clientDataJSON, "webauthn.create", challenge); err != nil {
+		return Credential{}, err
+	}
+
+	obj, _, err := decodeCBOR(attestationObject)
+	if err != nil {
+		return Credential{}, fmt.Errorf("%w: %w", ErrInvalidResponse, err)
+	}
+
+	m, ok := obj.(map[any]any)
+	if !ok {
+		return Credential{}, ErrInvalidResponse
+	}
+
+	authData, ok := m["authData"].([]byte)
+	if !ok {
+		return Credential{}, ErrInvalidResponse
+	}
+
+	flags, signCount, err := c.verifyAuthData(authData)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	if flags&flagAttested == 0 || len(authData) < 55 {
+		return Credential{}, fmt.Errorf("%w: no attested credential", ErrInvalidResponse)
+	}
+
+	// aaguid (16 bytes), credential id length (2 bytes), credential id, COSE key
+	idLen := int(binary.BigEndian.Uint16(authData[53:55]))
+	if len(authData) < 55+idLen {
+		return Credential{}, ErrInvalidResponse
+	}
+	id := authData[55 : 55+idLen]
+
+	coseKey, _, err := decodeCBOR(authData[55+idLen:])
+	if err != nil {
+		return Credential{}, fmt.Errorf("%w: %w", ErrInvalidResponse, err)
+	}
+
+	pub, err := parseCOSEKey(coseKey)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return Credential{}, fmt.Errorf("%w: %w", ErrInvalidResponse, err)
+	}
+
+	return Credential{ID: slices.Clone(id), PublicKey: der, SignCount: signCount}, nil
+}
+
+// VerifyAssertion checks the response of navigator.credentials.get() against the
+// stored public key and returns the new signature counter of the authenticator.
+func (c Config) VerifyAssertion(
+	challenge string,
+	publicKey []byte,
+	clientDataJSON []byte,
+	authenticatorData []byte,
+	signature []byte,
+) (signCount uint32, err error) {
+	if err := c.verifyClientData(clientDataJSON, "webauthn.get", challenge); err != nil {
+		return 0, err
+	}
+
+	_, signCount, err = c.verifyAuthData(authenticatorData)
+	if err != nil {
+		return 0, err
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(publicKey)
+	if err != nil {
+		return 0, err
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(slices.Clone(authenticatorData), clientDataHash[:]...)
+
+	if !verifySignature(pub, signed, signature) {
+		return 0, fmt.Errorf("%w: bad signature", ErrInvalidResponse)
+	}
+
+	return signCount, nil
+}
+
+func (c Config) verifyClientData(clientDataJSON []byte, typ string, challenge string) error {
+	var cd clientData
+	if err := json.Unmarshal(clientDataJSON, &cd); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidResponse, err)
+	}
+
+	if cd.Type != typ {
+		return fmt.Errorf("%w: unexpected type %q", ErrInvalidResponse, cd.Type)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(cd.Challenge), []byte(challenge)) != 1 {
+		return fmt.Errorf("%w: challenge mismatch", ErrInvalidResponse)
+	}
+
+	if !slices.Contains(c.Origins, cd.Origin) {
+		return fmt.Errorf("%w: origin %q is not allowed", ErrInvalidResponse, cd.Origin)
+	}
+
+	return nil
+}
+
+// verifyAuthData checks RP ID hash and requires user presence and verification,
+// since passkeys replace both password and second factor.
+func (c Config) verifyAuthData(authData []byte) (flags byte, signCount uint32, err error) {
+	if len(authData) < 37 {
+		return 0, 0, ErrInvalidResponse
+	}
+
+	rpIDHash := sha256.Sum256([]byte(c.RPID))
+	if subtle.ConstantTimeCompare(authData[:32], rpIDHash[:]) != 1 {
+		return 0, 0, fmt.Errorf("%w: rp id mismatch", ErrInvalidResponse)
+	}
+
+	flags = authData[32]
+	if flags&flagUserPresent == 0 || flags&flagUserVerified == 0 {
+		return 0, 0, fmt.Errorf("%w: user not verified", ErrInvalidResponse)
+	}
+
+	return flags, binary.BigEndian.Uint32(authData[33:37]), nil
+}
+
+func parseCOSEKey(v any) (crypto.PublicKey, error) {
+	m, ok := v.(map[any]any)
+	if !ok {
+		return nil, ErrInvalidResponse
+	}
+
+	alg, _ := m[int64(3)].(int64)
+
+	switch alg {
+	case algES256:
+		x, _ := m[int64(-2)].([]byte)
+		y, _ := m[int64(-3)].([]byte)
+		if crv, _ := m[int64(-1)].(int64); crv != 1 || len(x) != 32 || len(y) != 32 {
+			return nil, fmt.Errorf("%w: bad EC2 key", ErrInvalidResponse)
+		}
+
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, fmt.Errorf("%w: bad EC2 key", ErrInvalidResponse)
+		}
+
+		return pub, nil
+	case algRS256:
+		n, _ := m[int64(-1)].([]byte)
+		e, _ := m[int64(-2)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("%w: bad RSA key", ErrInvalidResponse)
+		}
+
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case algEdDSA:
+		x, _ := m[int64(-2)].([]byte)
+		if crv, _ := m[int64(-1)].(int64); crv != 6 || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("%w: bad OKP key", ErrInvalidResponse)
+		}
+
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, fmt.Errorf("%w: unsupported algorithm %d", ErrInvalidResponse, alg)
+}
+
+func verifySignature(pub crypto.PublicKey, signed []byte, signature []byte) bool {
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		sum := sha256.Sum256(signed)
+
+		return ecdsa.VerifyASN1(k, sum[:], signature)
+	case *rsa.PublicKey:
+		sum := sha256.Sum256(signed)
+
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, sum[:], signature) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(k, signed, signature)
+	}
+
+	return false
+}
@@ -0,0 +1,148 @@
+package webauthn_test
+
+import (
+	"errors"
+	"sso/internal/lib/webauthn"
+	"sso/ssotest"
+	"testing"
+)
+
+const (
+	rpID   = "example.com"
+	origin = "https://app.example.com"
+)
+
+var config = webauthn.Config{RPID: rpID, Origins: []string{origin}}
+
+func register(t *testing.T, a *ssotest.Authenticator) webauthn.Credential {
+	t.Helper()
+
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		t.Fatalf("NewChallenge() error = %v", err)
+	}
+
+	clientDataJSON, attestationObject := a.Register(t, challenge)
+
+	cred, err := config.VerifyRegistration(challenge, clientDataJSON, attestationObject)
+	if err != nil {
+		t.Fatalf("VerifyRegistration() error = %v", err)
+	}
+
+	return cred
+}
+
+func TestVerifyAssertion(t *testing.T) {
+	algs := []struct {
+		name string
+		alg  int
+	}{
+		{name: "ES256", alg: ssotest.ES256},
+		{name: "RS256", alg: ssotest.RS256},
+		{name: "EdDSA", alg: ssotest.EdDSA},
+	}
+
+	for _, alg := range algs {
+		t.Run(alg.name, func(t *testing.T) {
+			a := ssotest.NewAuthenticator(t, alg.alg, rpID, origin)
+			cred := register(t, a)
+
+			if string(cred.ID) != string(a.CredentialID) {
+				t.Errorf("ID = %q, want %q", cred.ID, a.CredentialID)
+			}
+
+			challenge, _ := webauthn.NewChallenge()
+			clientDataJSON, authData, sig := a.Assert(t, challenge)
+
+			signCount, err := config.VerifyAssertion(challenge, cred.PublicKey, clientDataJSON, authData, sig)
+			if err != nil {
+				t.Fatalf("VerifyAssertion() error = %v", err)
+			}
+			if signCount != a.SignCount {
+				t.Errorf("signCount = %d, want %d", signCount, a.SignCount)
+			}
+
+			sig[len(sig)-1] ^= 0xff
+			if _, err := config.VerifyAssertion(challenge, cred.PublicKey, clientDataJSON, authData, sig); !errors.Is(err, webauthn.ErrInvalidResponse) {
+				t.Errorf("tampered signature: error = %v, want %v", err, webauthn.ErrInvalidResponse)
+			}
+		})
+	}
+}
+
+func TestVerifyAssertionRejects(t *testing.T) {
+	a := ssotest.NewAuthenticator(t, ssotest.ES256, rpID, origin)
+	cred := register(t, a)
+
+	tests := []struct {
+		name    string
+		prepare func(a *ssotest.Authenticator)
+		// answered is the challenge the authenticator signs instead of the issued one.
+		answered string
+	}{
+		{name: "rp id mismatch", prepare: func(a *ssotest.Authenticator) { a.RPID = "evil.example" }},
+		{name: "origin not allowed", prepare: func(a *ssotest.Authenticator) { a.Origin = "https://evil.example" }},
+		{name: "user not verified", prepare: func(a *ssotest.Authenticator) { a.Flags = ssotest.FlagUserPresent }},
+		{name: "user not present", prepare: func(a *ssotest.Authenticator) { a.Flags = ssotest.FlagUserVerified }},
+		{name: "other challenge", answered: "other-challenge"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := *a
+			if tt.prepare != nil {
+				tt.prepare(&a)
+			}
+
+			challenge, _ := webauthn.NewChallenge()
+			answered := challenge
+			if tt.answered != "" {
+				answered = tt.answered
+			}
+
+			clientDataJSON, authData, sig := a.Assert(t, answered)
+
+			if _, err := config.VerifyAssertion(challenge, cred.PublicKey, clientDataJSON, authData, sig); !errors.Is(err, webauthn.ErrInvalidResponse) {
+				t.Errorf("error = %v, want %v", err, webauthn.ErrInvalidResponse)
+			}
+		})
+	}
+}
+
+func TestVerifyRegistrationRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		prepare func(a *ssotest.Authenticator)
+	}{
+		{name: "rp id mismatch", prepare: func(a *ssotest.Authenticator) { a.RPID = "evil.example" }},
+		{name: "user not verified", prepare: func(a *ssotest.Authenticator) { a.Flags = ssotest.FlagUserPresent }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := ssotest.NewAuthenticator(t, ssotest.ES256, rpID, origin)
+			tt.prepare(a)
+
+			challenge, _ := webauthn.NewChallenge()
+			clientDataJSON, attestationObject := a.Register(t, challenge)
+
+			if _, err := config.VerifyRegistration(challenge, clientDataJSON, attestationObject); !errors.Is(err, webauthn.ErrInvalidResponse) {
+				t.Errorf("error = %v, want %v", err, webauthn.ErrInvalidResponse)
+			}
+		})
+	}
+}
+
+func TestVerifyRegistrationMalformed(t *testing.T) {
+	a := ssotest.NewAuthenticator(t, ssotest.ES256, rpID, origin)
+
+	challenge, _ := webauthn.NewChallenge()
+	clientDataJSON, attestationObject := a.Register(t, challenge)
+
+	// Обрезанный объект не должен ни паниковать, ни проходить проверку
+	for n := range len(attestationObject) {
+		if _, err := config.VerifyRegistration(challenge, clientDataJSON, attestationObject[:n]); err == nil {
+			t.Fatalf("truncated to %d bytes: no error", n)
+		}
+	}
+}
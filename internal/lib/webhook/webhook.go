@@ -0,0 +1,223 @@
+// Package webhook delivers queued events to webhooks of apps. Requests are
+// signed with the webhook secret and retried with backoff until they succeed
+// or the attempts are exhausted.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sso/internal/domain/models"
+	"sso/internal/lib/logger/sl"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Headers of delivery requests.
+const (
+	EventHeader    = "X-SSO-Event"
+	DeliveryHeader = "X-SSO-Delivery"
+	// SignatureHeader is "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>">".
+	SignatureHeader = "X-SSO-Signature"
+)
+
+const (
+	// MaxAttempts after which a delivery is given up.
+	MaxAttempts = 10
+
+	batchSize = 50
+	// lease is how long a claimed delivery is hidden from other instances.
+	lease      = time.Minute
+	minBackoff = 30 * time.Second
+	maxBackoff = 6 * time.Hour
+	// maxErrorLen bounds the response body kept in the delivery log.
+	maxErrorLen = 512
+)
+
+// Store is the queue of deliveries.
+type Store interface {
+	ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]models.WebhookDelivery, error)
+	UpdateWebhookDelivery(ctx context.Context, d models.WebhookDelivery) error
+	Webhook(ctx context.Context, id int64) (models.Webhook, error)
+}
+
+// Sign returns the SignatureHeader value of the payload sent at t.
+func Sign(secret string, t time.Time, payload []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Dispatcher polls the store and sends due deliveries.
+type Dispatcher struct {
+	log      *slog.Logger
+	store    Store
+	client   *http.Client
+	interval time.Duration
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewDispatcher(log *slog.Logger, store Store, client *http.Client, interval time.Duration) *Dispatcher {
+	return &Dispatcher{
+		log:      log.With(slog.String("component", "webhook")),
+		store:    store,
+		client:   client,
+		interval: interval,
+	}
+}
+
+// Start polls in the background until Stop.
+func (d *Dispatcher) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	d.cancel = cancel
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+
+		ticker := time.NewTicker(d.interval)
+		defer ticker.Stop()
+
+		for {
+			// Полная пачка значит, что в очереди есть ещё, не ждём следующего тика
+			for n := batchSize; n == batchSize; {
+				n = d.dispatch(ctx)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop waits for the deliveries in progress. Claimed but unsent ones are
+// picked up again after the lease.
+func (d *Dispatcher) Stop() {
+	if d.cancel == nil {
+		return
+	}
+
+	d.cancel()
+	d.wg.Wait()
+}
+
+// dispatch sends one batch and returns its size.
+func (d *Dispatcher) dispatch(ctx context.Context) int {
+	deliveries, err := d.store.ClaimWebhookDeliveries(ctx, batchSize, lease)
+	if err != nil {
+		if ctx.Err() == nil {
+			d.log.Error("failed to claim deliveries", sl.Err(err))
+		}
+
+		return 0
+	}
+
+	hooks := make(map[int64]models.Webhook)
+	for _, delivery := range deliveries {
+		hook, ok := hooks[delivery.WebhookID]
+		if !ok {
+			hook, err = d.store.Webhook(ctx, delivery.WebhookID)
+			if err != nil {
+				// Вебхук удалён вместе с доставками, либо БД недоступна до истечения аренды
+				d.log.Warn("failed to get webhook", slog.Int64("webhook_id", delivery.WebhookID), sl.Err(err))
+
+				continue
+			}
+			hooks[hook.ID] = hook
+		}
+
+		d.record(ctx, delivery, d.send(ctx, hook, delivery))
+	}
+
+	return len(deliveries)
+}
+
+// attempt is the outcome of one request.
+type attempt struct {
+	status int
+	err    error
+}
+
+func (d *Dispatcher) send(ctx context.Context, hook models.Webhook, delivery models.WebhookDelivery) attempt {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return attempt{err: err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(SignatureHeader, Sign(hook.Secret, time.Now(), delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return attempt{err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		_, _ = io.Copy(io.Discard, resp.Body)
+
+		return attempt{status: resp.StatusCode}
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorLen))
+
+	return attempt{status: resp.StatusCode, err: fmt.Errorf("unexpected status %d: %s", resp.StatusCode, body)}
+}
+
+func (d *Dispatcher) record(ctx context.Context, delivery models.WebhookDelivery, a attempt) {
+	log := d.log.With(slog.Int64("delivery_id", delivery.ID), slog.Int64("webhook_id", delivery.WebhookID))
+
+	now := time.Now()
+
+	delivery.Attempts++
+	delivery.LastStatus = a.status
+	delivery.LastError = ""
+
+	switch {
+	case a.err == nil:
+		delivery.DeliveredAt = now
+	case delivery.Attempts >= MaxAttempts:
+		delivery.LastError = a.err.Error()
+		delivery.FailedAt = now
+
+		log.Warn("webhook delivery failed, giving up", slog.Int("attempts", delivery.Attempts), sl.Err(a.err))
+	default:
+		delivery.LastError = a.err.Error()
+		delivery.NextAttemptAt = now.Add(backoff(delivery.Attempts))
+
+		log.Info("webhook delivery failed, will retry", slog.Int("attempts", delivery.Attempts), sl.Err(a.err))
+	}
+
+	// Результат записываем даже при остановке, иначе доставка уйдёт повторно
+	if err := d.store.UpdateWebhookDelivery(context.WithoutCancel(ctx), delivery); err != nil {
+		log.Error("failed to record delivery", sl.Err(err))
+	}
+}
+
+// backoff doubles the delay after every failed attempt, up to maxBackoff.
+func backoff(attempts int) time.Duration {
+	delay := minBackoff
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+
+	return min(delay, maxBackoff)
+}
@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/storage"
+	"time"
+)
+
+// accessCacheTTL bounds how long other instances may answer IsAdmin and
+// GetUserRole by a role changed on this one; this instance forgets the answer
+// on the change itself.
+const accessCacheTTL = 30 * time.Second
+
+// userAccess is what IsAdmin and GetUserRole need to know about the user.
+type userAccess struct {
+	role  string
+	orgID int64
+}
+
+// IsAdmin reports whether the user has the admin role, so that services
+// don't have to compare role names themselves. Answers are cached.
+func (a *Auth) IsAdmin(ctx context.Context, userID int64) (bool, error) {
+	const op = "Auth.IsAdmin"
+
+	access, err := a.userAccess(ctx, userID)
+	if err != nil {
+		if !errors.Is(err, storage.ErrUserNotFound) {
+			a.logger(ctx).Error("failed to get user", slog.String("op", op), sl.Err(err))
+		}
+
+		return false, fmt.Errorf("%s: %w", op, userErr(err))
+	}
+
+	if orgID := callerOrg(ctx); orgID != 0 && orgID != access.orgID {
+		return false, fmt.Errorf("%s: %w", op, ErrUserNotFound)
+	}
+
+	return access.role == AdminRole, nil
+}
+
+// userAccess returns the cached role and organization of the user or looks
+// them up. Changes of them must drop the user from a.access.
+func (a *Auth) userAccess(ctx context.Context, userID int64) (userAccess, error) {
+	if access, ok := a.access.Get(userID); ok {
+		return access, nil
+	}
+
+	user, err := a.usrProvider.UserByID(ctx, userID)
+	if err != nil {
+		return userAccess{}, err
+	}
+
+	access := userAccess{role: user.Role, orgID: user.OrgID}
+	a.access.Set(userID, access)
+
+	return access, nil
+}
+
+// EffectiveConfig returns the configuration the service was started with,
+// secrets redacted, for diagnostics by admins.
+func (a *Auth) EffectiveConfig() map[string]any {
+	return a.config
+}
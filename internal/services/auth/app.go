@@ -0,0 +1,259 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"slices"
+	"sso/internal/domain/models"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/storage"
+	"strings"
+	"time"
+)
+
+// CreateApp registers an app with a generated secret and returns it with the
+// secret, the only time the secret is shown. Callers scoped to an organization
+// create apps of their organization.
+func (a *Auth) CreateApp(ctx context.Context, app models.App) (models.App, error) {
+	const op = "Auth.CreateApp"
+
+	log := a.logger(ctx).With(slog.String("op", op), slog.String("app", app.Name))
+	log.Info("creating app")
+
+	app, err := normalizeApp(app)
+	if err != nil {
+		return models.App{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if orgID := callerOrg(ctx); orgID != 0 {
+		app.OrgID = orgID
+	}
+
+	if app.Secret, err = newAppSecret(); err != nil {
+		return models.App{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	app.ID, err = a.appProvider.SaveApp(ctx, app)
+	if err != nil {
+		if !errors.Is(err, storage.ErrAppExists) && !errors.Is(err, storage.ErrOrgNotFound) {
+			log.Error("failed to save app", sl.Err(err))
+		}
+
+		return models.App{}, fmt.Errorf("%s: %w", op, orgErr(appErr(err)))
+	}
+
+	a.audit(ctx, models.AuditEvent{
+		Action:      models.AuditAppCreated,
+		TargetAppID: app.ID,
+		Details:     map[string]string{"app": app.Name},
+	})
+
+	log.Info("app created", slog.Int("app_id", app.ID))
+
+	return app, nil
+}
+
+// UpdateApp changes name, redirect URIs, scopes, token lifetime and audience
+// and whether the app is public. The secret and organization of the app can't
+// be changed here.
+func (a *Auth) UpdateApp(ctx context.Context, app models.App) error {
+	const op = "Auth.UpdateApp"
+
+	log := a.logger(ctx).With(slog.String("op", op), slog.Int("app_id", app.ID))
+	log.Info("updating app")
+
+	app, err := normalizeApp(app)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err := a.callerApp(ctx, app.ID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.appProvider.UpdateApp(ctx, app); err != nil {
+		if !errors.Is(err, storage.ErrAppExists) && !errors.Is(err, storage.ErrAppNotFound) {
+			log.Error("failed to update app", sl.Err(err))
+		}
+
+		return fmt.Errorf("%s: %w", op, appErr(err))
+	}
+
+	a.audit(ctx, models.AuditEvent{
+		Action:      models.AuditAppUpdated,
+		TargetAppID: app.ID,
+		Details:     map[string]string{"app": app.Name},
+	})
+
+	return nil
+}
+
+// DeleteApp removes the app with its API keys. Tokens already issued for the
+// app stop validating since its secret is gone.
+func (a *Auth) DeleteApp(ctx context.Context, appID int) error {
+	const op = "Auth.DeleteApp"
+
+	log := a.logger(ctx).With(slog.String("op", op), slog.Int("app_id", appID))
+	log.Info("deleting app")
+
+	if _, err := a.callerApp(ctx, appID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.appProvider.DeleteApp(ctx, appID); err != nil {
+		if !errors.Is(err, storage.ErrAppNotFound) {
+			log.Error("failed to delete app", sl.Err(err))
+		}
+
+		return fmt.Errorf("%s: %w", op, appErr(err))
+	}
+
+	a.audit(ctx, models.AuditEvent{Action: models.AuditAppDeleted, TargetAppID: appID})
+
+	log.Info("app deleted")
+
+	return nil
+}
+
+// RotateAppSecret generates a new secret of the app and returns it. Tokens
+// signed with the previous secret keep validating for the configured grace
+// period, so services verifying them can switch over without downtime.
+func (a *Auth) RotateAppSecret(ctx context.Context, appID int) (string, error) {
+	const op = "Auth.RotateAppSecret"
+
+	log := a.logger(ctx).With(slog.String("op", op), slog.Int("app_id", appID))
+	log.Info("rotating app secret")
+
+	if _, err := a.callerApp(ctx, appID); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	secret, err := newAppSecret()
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	expiresAt := time.Now().Add(a.appSecretGrace)
+
+	if err := a.appProvider.RotateAppSecret(ctx, appID, secret, expiresAt); err != nil {
+		if !errors.Is(err, storage.ErrAppNotFound) {
+			log.Error("failed to rotate app secret", sl.Err(err))
+		}
+
+		return "", fmt.Errorf("%s: %w", op, appErr(err))
+	}
+
+	a.audit(ctx, models.AuditEvent{
+		Action:      models.AuditAppSecretRotated,
+		TargetAppID: appID,
+		Details:     map[string]string{"previous_expires_at": expiresAt.UTC().Format(time.RFC3339)},
+	})
+
+	log.Info("app secret rotated")
+
+	return secret, nil
+}
+
+// ListApps returns apps visible to the caller ordered by id, without secrets.
+func (a *Auth) ListApps(ctx context.Context) ([]models.App, error) {
+	const op = "Auth.ListApps"
+
+	apps, err := a.appProvider.Apps(ctx, callerOrg(ctx))
+	if err != nil {
+		a.logger(ctx).Error("failed to list apps", slog.String("op", op), sl.Err(err))
+
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	for i := range apps {
+		apps[i].Secret = ""
+		apps[i].PreviousSecret = ""
+	}
+
+	return apps, nil
+}
+
+// callerApp returns the app if the caller may manage it. Apps of other
+// organizations look nonexistent to callers scoped to an organization.
+func (a *Auth) callerApp(ctx context.Context, appID int) (models.App, error) {
+	app, err := a.appProvider.App(ctx, appID)
+	if err != nil {
+		return models.App{}, appErr(err)
+	}
+
+	if orgID := callerOrg(ctx); orgID != 0 && orgID != app.OrgID {
+		return models.App{}, ErrAppNotFound
+	}
+
+	return app, nil
+}
+
+// normalizeApp validates the app: a name, absolute redirect URIs, scopes
+// without spaces, since they are joined into the scope claim, and token
+// lifetime of whole seconds.
+func normalizeApp(app models.App) (models.App, error) {
+	app.Name = strings.TrimSpace(app.Name)
+	if app.Name == "" || len(app.Name) > 128 {
+		return models.App{}, ErrInvalidApp
+	}
+
+	for _, uri := range app.RedirectURIs {
+		u, err := url.Parse(uri)
+		if err != nil || u.Scheme == "" || u.Host == "" || u.Fragment != "" {
+			return models.App{}, ErrInvalidRedirectURI
+		}
+	}
+
+	for _, scope := range app.Scopes {
+		if scope == "" || strings.ContainsAny(scope, " \t\n") {
+			return models.App{}, ErrInvalidScope
+		}
+	}
+
+	if app.TokenTTL < 0 || app.TokenTTL%time.Second != 0 {
+		return models.App{}, ErrInvalidApp
+	}
+
+	app.Audience = strings.TrimSpace(app.Audience)
+	app.CertSubject = strings.TrimSpace(app.CertSubject)
+
+	app.RedirectURIs = sortedSet(app.RedirectURIs)
+	app.Scopes = sortedSet(app.Scopes)
+
+	return app, nil
+}
+
+// sortedSet returns sorted list without duplicates, never nil: nil slices are
+// stored as NULL, and the array columns of apps are NOT NULL.
+func sortedSet(list []string) []string {
+	set := append([]string{}, list...)
+	slices.Sort(set)
+
+	return slices.Compact(set)
+}
+
+func newAppSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// appErr maps storage errors of apps to the service errors.
+func appErr(err error) error {
+	switch {
+	case errors.Is(err, storage.ErrAppNotFound):
+		return ErrAppNotFound
+	case errors.Is(err, storage.ErrAppExists):
+		return ErrAppExists
+	}
+
+	return err
+}
@@ -0,0 +1,86 @@
+package auth_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sso/internal/domain/models"
+	"sso/internal/lib/caller"
+	"sso/internal/services/auth"
+	"sso/ssotest"
+	"testing"
+)
+
+func TestListAppAuditEvents(t *testing.T) {
+	srv := ssotest.NewServer(t)
+
+	seed := []models.AuditEvent{
+		{Action: "by_app_1", ActorAppID: 1},
+		{Action: "about_app_1", ActorUserID: 5, TargetAppID: 1},
+		{Action: "by_app_2", ActorAppID: 2},
+		{Action: "about_app_2", ActorAppID: 3, TargetAppID: 2},
+		{Action: "by_user", ActorUserID: 5, TargetUserID: 6},
+	}
+	for _, e := range seed {
+		if err := srv.Storage.SaveAuditEvent(context.Background(), e); err != nil {
+			t.Fatalf("SaveAuditEvent() error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		caller  *caller.Caller
+		filter  models.AuditFilter
+		want    []string
+		wantErr error
+	}{
+		{
+			name:   "api key of the app",
+			caller: &caller.Caller{AppID: 1, APIKeyID: 10},
+			want:   []string{"about_app_1", "by_app_1"},
+		},
+		{
+			name:   "filter of other app is ignored",
+			caller: &caller.Caller{AppID: 2, APIKeyID: 11},
+			filter: models.AuditFilter{AppID: 1},
+			want:   []string{"about_app_2", "by_app_2"},
+		},
+		{
+			name:   "filter by action",
+			caller: &caller.Caller{AppID: 1, APIKeyID: 10},
+			filter: models.AuditFilter{Action: "by_app_1"},
+			want:   []string{"by_app_1"},
+		},
+		{
+			name:    "user of the app",
+			caller:  &caller.Caller{UserID: 5, AppID: 1},
+			wantErr: auth.ErrAppCallerRequired,
+		},
+		{
+			name:    "anonymous",
+			wantErr: auth.ErrAppCallerRequired,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.caller != nil {
+				ctx = caller.WithCaller(ctx, *tt.caller)
+			}
+
+			events, _, err := srv.Auth.ListAppAuditEvents(ctx, tt.filter, "", 0)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ListAppAuditEvents() error = %v, want %v", err, tt.wantErr)
+			}
+
+			var got []string
+			for _, e := range events {
+				got = append(got, e.Action)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("actions = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
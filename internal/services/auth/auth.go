@@ -2,22 +2,121 @@ package auth
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
+	"math"
+	"regexp"
 	"sso/internal/domain/models"
+	"sso/internal/lib/dpop"
+	"sso/internal/lib/emaildomain"
+	"sso/internal/lib/fault"
 	"sso/internal/lib/jwt"
+	"sso/internal/lib/ldap"
 	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/mail"
+	"sso/internal/lib/mtls"
+	"sso/internal/lib/passhash"
+	"sso/internal/lib/phone"
+	"sso/internal/lib/requestid"
+	"sso/internal/lib/secret"
+	"sso/internal/lib/sms"
+	"sso/internal/lib/social"
+	"sso/internal/lib/ttlcache"
+	"sso/internal/lib/webauthn"
 	"sso/internal/storage"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
 var (
 	ErrUserNotFound       = errors.New("user not found")
+	ErrUserExists         = errors.New("user already exists")
+	ErrInvalidEmail       = errors.New("invalid email")
 	ErrInvalidCredentials = errors.New("invalid credentials")
 	ErrInvalidRole        = errors.New("invalid role")
+	ErrInvalidPermission  = errors.New("invalid permission")
+	ErrRoleExists         = errors.New("role already exists")
+	ErrRoleNotFound       = errors.New("role not found")
+	ErrRoleInUse          = errors.New("role is assigned to users or api keys")
+	ErrProtectedRole      = errors.New("role can't be deleted")
+	ErrInvalidGroup       = errors.New("invalid group name")
+	ErrGroupExists        = errors.New("group already exists")
+	ErrGroupNotFound      = errors.New("group not found")
+	ErrInvalidOrg         = errors.New("invalid organization name")
+	ErrOrgExists          = errors.New("organization already exists")
+	ErrOrgNotFound        = errors.New("organization not found")
+	ErrOrgScoped          = errors.New("not allowed to callers scoped to an organization")
+	ErrInvalidDPoPProof   = errors.New("invalid dpop proof")
+	ErrTokenReplayed      = errors.New("token already used")
+	ErrSelfMerge          = errors.New("cannot merge user into itself")
+	ErrInvalidUsername    = errors.New("invalid username")
+	ErrUsernameTaken      = errors.New("username already taken")
+	ErrInvalidPhone       = errors.New("invalid phone number")
+	ErrPhoneTaken         = errors.New("phone already taken")
+	ErrInvalidCode        = errors.New("invalid or expired code")
+	ErrCodeRecentlySent   = errors.New("code was sent recently, try again later")
+	ErrInvalidAvatarURL   = errors.New("invalid avatar url")
+	ErrInvalidPreference  = errors.New("invalid preference")
+	ErrInvalidMetadata    = errors.New("invalid metadata")
+	ErrInvalidSort        = errors.New("invalid sort field")
+	ErrInvalidQuery       = errors.New("invalid search query")
+
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrInvalidToken        = errors.New("invalid token")
+	ErrTokenRevoked        = errors.New("token revoked")
+	ErrTokenBinding        = errors.New("token is bound to another certificate or key")
+
+	ErrMFARequired       = errors.New("second factor required")
+	ErrMFADisabled       = errors.New("two-factor authentication is not configured")
+	ErrMFAAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrMFANotEnabled     = errors.New("two-factor authentication is not enabled")
+	ErrPhoneNotVerified  = errors.New("phone is not verified")
+
+	ErrMagicLinksDisabled = errors.New("magic links are not configured")
+
+	ErrInvalidClient      = errors.New("invalid client credentials")
+	ErrInvalidRedirectURI = errors.New("redirect uri is not registered for the app")
+	ErrInvalidGrant       = errors.New("invalid, expired or already used authorization code")
+	ErrPKCERequired       = errors.New("public apps must use PKCE with S256")
+	ErrInvalidPKCE        = errors.New("invalid code challenge")
+	ErrUnauthorizedClient = errors.New("app is not allowed to use the grant")
+	ErrInvalidScope       = errors.New("scope is not allowed for the app")
+	ErrInvalidApp         = errors.New("invalid app")
+	ErrAppExists          = errors.New("app already exists")
+	ErrAppNotFound        = errors.New("app not found")
+	ErrUnknownCertificate = errors.New("client certificate is not registered for any app")
+	ErrAppCallerRequired  = errors.New("caller must authenticate as an app")
+
+	ErrAPIKeyNotFound = errors.New("api key not found")
+	ErrInvalidAPIKey  = errors.New("invalid or revoked api key")
+
+	ErrWebhookNotFound = errors.New("webhook not found")
+	ErrInvalidWebhook  = errors.New("invalid webhook url or events")
+
+	ErrSessionNotFound  = errors.New("session not found")
+	ErrInvalidPageToken = errors.New("invalid page token")
+	ErrAccountLocked    = errors.New("account is temporarily locked")
+	ErrAccountSuspended = errors.New("account is suspended")
+	ErrInvalidStatus    = errors.New("invalid account status")
+	ErrCaptchaRequired  = errors.New("captcha required")
+	ErrPasswordBreached = errors.New("password appeared in a data breach")
+	ErrReasonRequired   = errors.New("audit reason is required")
+
+	ErrUnknownProvider  = errors.New("unknown identity provider")
+	ErrEmailNotVerified = errors.New("identity provider did not verify the email")
+	ErrPasskeysDisabled = errors.New("passkeys are not configured")
+	ErrInvalidPasskey   = errors.New("invalid passkey")
+
+	ErrInvalidRegistrationMode = errors.New("invalid registration mode")
+	ErrRegistrationClosed      = errors.New("registration is closed")
+	ErrInvitationRequired      = errors.New("valid invitation is required")
+	ErrEmailDomainNotAllowed   = errors.New("email domain is not allowed")
 )
 
 type UserSaver interface {
@@ -32,134 +131,869 @@ type UserSaver interface {
 		uid int64,
 		role string,
 	) (err error)
+	MergeUsers(
+		ctx context.Context,
+		fromID int64,
+		intoID int64,
+		role string,
+	) (err error)
+	SetUsername(
+		ctx context.Context,
+		uid int64,
+		username string,
+	) (err error)
+	SavePhoneUser(
+		ctx context.Context,
+		phone string,
+		passHash []byte,
+		role string,
+	) (uid int64, err error)
+	SetPhone(ctx context.Context, uid int64, phone string) (err error)
+	MarkPhoneVerified(ctx context.Context, uid int64, phone string) (err error)
+	SetAvatarURL(ctx context.Context, uid int64, url string) (err error)
+	SetPreference(ctx context.Context, uid int64, key string, value string) (err error)
+	SetUserMetadata(ctx context.Context, uid int64, appID int, data json.RawMessage) (err error)
+	TouchLogin(ctx context.Context, uid int64) (err error)
+	UpdatePassHash(ctx context.Context, uid int64, passHash []byte) (err error)
+	DeleteUser(ctx context.Context, uid int64) (err error)
+	AnonymizeUser(ctx context.Context, uid int64) (err error)
+	SoftDeleteUser(ctx context.Context, uid int64) (err error)
+	RestoreUser(ctx context.Context, uid int64) (err error)
+	SetUserStatus(ctx context.Context, uid int64, status string) (err error)
+	SaveIdentity(ctx context.Context, uid int64, provider string, subject string) (err error)
 }
 
 type UserProvider interface {
 	User(ctx context.Context, email string) (models.User, error)
 	UserByID(ctx context.Context, uid int64) (models.User, error)
-	ListUsers(ctx context.Context) ([]models.User, error)
+	UserByUsername(ctx context.Context, username string) (models.User, error)
+	UserByPhone(ctx context.Context, phone string) (models.User, error)
+	UserByIdentity(ctx context.Context, provider string, subject string) (models.User, error)
+	Preferences(ctx context.Context, uid int64) (map[string]string, error)
+	UserMetadata(ctx context.Context, uid int64, appID int) (json.RawMessage, error)
+	UserExists(ctx context.Context, email string) (bool, error)
+	CountUsers(ctx context.Context, filter models.UserFilter) (int64, error)
+	ListUsers(ctx context.Context, filter models.UserFilter, sort models.UserSort, after models.UserCursor, limit int) ([]models.User, error)
+	SearchUsers(ctx context.Context, query string, orgID int64, limit int) ([]models.User, error)
 	GetUserRole(ctx context.Context, userID int64) (string, error)
 }
 
+// AppProvider keeps registered apps. Apps with zero orgID returns apps of all organizations.
 type AppProvider interface {
 	App(ctx context.Context, appID int) (models.App, error)
+	AppByCertSubject(ctx context.Context, subject string) (models.App, error)
+	Apps(ctx context.Context, orgID int64) ([]models.App, error)
+	SaveApp(ctx context.Context, app models.App) (int, error)
+	UpdateApp(ctx context.Context, app models.App) error
+	DeleteApp(ctx context.Context, appID int) error
+	RotateAppSecret(ctx context.Context, appID int, secret string, previousExpiresAt time.Time) error
 }
 
+// RoleManager keeps roles and their permissions.
 type RoleManager interface {
-	UpdateRole(ctx context.Context, userID int64, role string) error
+	Role(ctx context.Context, name string) (models.Role, error)
+	Roles(ctx context.Context) ([]models.Role, error)
+	SaveRole(ctx context.Context, role models.Role) error
+	EditRole(ctx context.Context, role models.Role) error
+	DeleteRole(ctx context.Context, name string) error
+}
+
+// JTIStore remembers ids of one-time tokens until they expire.
+type JTIStore interface {
+	UseJTI(ctx context.Context, jti string, expiresAt time.Time) error
+}
+
+// OTPStore keeps short one-time codes sent to users out of band.
+type OTPStore interface {
+	// SaveOTP replaces the code of the key and purpose, attempts included.
+	SaveOTP(ctx context.Context, otp models.OTP) error
+	OTP(ctx context.Context, key string, purpose string) (models.OTP, error)
+	IncrementOTPAttempts(ctx context.Context, key string, purpose string) error
+	DeleteOTP(ctx context.Context, key string, purpose string) error
+}
+
+// TokenStore keeps long one-time tokens, see models.OneTimeToken.
+type TokenStore interface {
+	SaveOneTimeToken(ctx context.Context, token models.OneTimeToken) error
+	ConsumeOneTimeToken(ctx context.Context, hash []byte, purpose string) (models.OneTimeToken, error)
+}
+
+// RefreshTokenStore keeps refresh tokens issued on login.
+type RefreshTokenStore interface {
+	SaveRefreshToken(ctx context.Context, token models.RefreshToken) error
+	UseRefreshToken(ctx context.Context, hash []byte) (models.RefreshToken, error)
+}
+
+// APIKeyStore keeps hashed API keys of machine clients.
+type APIKeyStore interface {
+	SaveAPIKey(ctx context.Context, key models.APIKey, hash []byte) (int64, error)
+	UseAPIKey(ctx context.Context, hash []byte) (models.APIKey, error)
+	APIKey(ctx context.Context, id int64) (models.APIKey, error)
+	APIKeys(ctx context.Context, appID int) ([]models.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id int64) error
+}
+
+// SessionStore keeps login sessions of users. Revoking a session revokes
+// its refresh tokens; access tokens of revoked sessions are reported by TokenRevoked.
+type SessionStore interface {
+	SaveSession(ctx context.Context, session models.Session) (int64, error)
+	TouchSession(ctx context.Context, id int64, ip string, expiresAt time.Time) error
+	Sessions(ctx context.Context, userID int64) ([]models.Session, error)
+	RevokeSession(ctx context.Context, userID int64, id int64) error
+}
+
+// LoginHistory keeps login attempts. LoginAttempts returns up to limit
+// attempts of the user with id below beforeID (any if zero), newest first.
+type LoginHistory interface {
+	SaveLoginAttempt(ctx context.Context, attempt models.LoginAttempt) error
+	LoginAttempts(ctx context.Context, userID int64, beforeID int64, limit int) ([]models.LoginAttempt, error)
+}
+
+// EventOutbox queues events that aren't part of a change of the storage,
+// such as failed logins, for the broker and webhooks.
+type EventOutbox interface {
+	EnqueueEvent(ctx context.Context, eventType string, data models.EventUser) error
+}
+
+// AuditLog is append-only. AuditEvents pages like LoginHistory.LoginAttempts.
+type AuditLog interface {
+	SaveAuditEvent(ctx context.Context, event models.AuditEvent) error
+	AuditEvents(ctx context.Context, filter models.AuditFilter, beforeID int64, limit int) ([]models.AuditEvent, error)
+}
+
+// GroupStore keeps groups of users. GroupMembers pages by user id like
+// LoginHistory.LoginAttempts; UserGroups returns names of the user's groups.
+type GroupStore interface {
+	SaveGroup(ctx context.Context, group models.Group) (int64, error)
+	Group(ctx context.Context, id int64) (models.Group, error)
+	AddGroupMember(ctx context.Context, groupID int64, userID int64) error
+	RemoveGroupMember(ctx context.Context, groupID int64, userID int64) error
+	GroupMembers(ctx context.Context, groupID int64, beforeUserID int64, limit int) ([]models.GroupMember, error)
+	UserGroups(ctx context.Context, userID int64) ([]string, error)
+}
+
+// OrgStore keeps organizations. SetUserOrg with zero orgID moves the user
+// back to the platform.
+type OrgStore interface {
+	SaveOrganization(ctx context.Context, org models.Organization) (int64, error)
+	Organization(ctx context.Context, id int64) (models.Organization, error)
+	Organizations(ctx context.Context) ([]models.Organization, error)
+	SetUserOrg(ctx context.Context, userID int64, orgID int64) error
+}
+
+// WebhookStore keeps webhooks of apps and the log of their deliveries.
+type WebhookStore interface {
+	SaveWebhook(ctx context.Context, hook models.Webhook) (int64, error)
+	Webhook(ctx context.Context, id int64) (models.Webhook, error)
+	Webhooks(ctx context.Context, appID int) ([]models.Webhook, error)
+	DeleteWebhook(ctx context.Context, id int64) error
+	WebhookDeliveries(ctx context.Context, webhookID int64, beforeID int64, limit int) ([]models.WebhookDelivery, error)
+}
+
+// Transactor runs fn in a transaction: calls of the stores made with the ctx
+// passed to fn commit together if fn returns nil and roll back otherwise.
+type Transactor interface {
+	InTx(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// LockoutStore counts consecutive failed logins. FailLogin locks the account
+// until lockUntil and resets the counter when it reaches threshold, unless
+// threshold is zero.
+type LockoutStore interface {
+	FailLogin(ctx context.Context, userID int64, threshold int, lockUntil time.Time) (locked bool, err error)
+	FailedLogins(ctx context.Context, userID int64) (int, error)
+	ResetFailedLogins(ctx context.Context, userID int64) error
+	LockedUntil(ctx context.Context, userID int64) (time.Time, error)
+	// Failed logins of no user are counted by the hash of the login,
+	// so that they are locked and need a captcha like those of users.
+	FailUnknownLogin(ctx context.Context, loginHash []byte, threshold int, lockUntil time.Time) (locked bool, err error)
+	UnknownLoginFailures(ctx context.Context, loginHash []byte) (failures int, lockedUntil time.Time, err error)
+}
+
+// OAuthStore keeps OAuth2 authorization codes.
+type OAuthStore interface {
+	SaveAuthorizationCode(ctx context.Context, code models.AuthorizationCode) error
+	ConsumeAuthorizationCode(ctx context.Context, hash []byte) (models.AuthorizationCode, error)
+}
+
+// RevocationStore remembers revoked access tokens until they expire.
+type RevocationStore interface {
+	RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error
+	RevokeUserTokens(ctx context.Context, userID int64, before time.Time) error
+	TokenRevoked(ctx context.Context, userID int64, sessionID int64, jti string, issuedAt time.Time) (bool, error)
+}
+
+// TokenDenylist keeps ids of access tokens revoked one by one outside of the
+// RevocationStore, e.g. in Redis. Without it they are kept in the RevocationStore.
+type TokenDenylist interface {
+	DenyToken(ctx context.Context, jti string, expiresAt time.Time) error
+	TokenDenied(ctx context.Context, jti string) (bool, error)
+}
+
+// MFAStore keeps authenticator secrets of users.
+type MFAStore interface {
+	SaveTOTP(ctx context.Context, userID int64, secret []byte) error
+	TOTP(ctx context.Context, userID int64) (models.TOTP, error)
+	UseTOTPStep(ctx context.Context, userID int64, step int64) error
+	ConfirmTOTP(ctx context.Context, userID int64) error
+	ReplaceRecoveryCodes(ctx context.Context, userID int64, hashes [][]byte) error
+	UseRecoveryCode(ctx context.Context, userID int64, hash []byte) error
+	SetSMSMFA(ctx context.Context, userID int64, enabled bool) error
+	SMSMFA(ctx context.Context, userID int64) (bool, error)
+	ResetMFA(ctx context.Context, userID int64) error
+}
+
+// PasskeyStore keeps WebAuthn credentials of users.
+type PasskeyStore interface {
+	SavePasskey(ctx context.Context, passkey models.Passkey) error
+	Passkey(ctx context.Context, id []byte) (models.Passkey, error)
+	TouchPasskey(ctx context.Context, id []byte, signCount uint32) error
+}
+
+// Directory checks passwords in an external directory such as LDAP and maps
+// the user's groups to a role.
+type Directory interface {
+	Authenticate(ctx context.Context, login string, password string) (ldap.Identity, error)
+	Role(identity ldap.Identity) string
 }
 
 type Auth struct {
-	log         *slog.Logger
-	usrSaver    UserSaver
-	usrProvider UserProvider
-	appProvider AppProvider
-	roleMgr     RoleManager
-	tokenTTL    time.Duration
+	log             *slog.Logger
+	usrSaver        UserSaver
+	usrProvider     UserProvider
+	appProvider     AppProvider
+	roleMgr         RoleManager
+	jtiStore        JTIStore
+	otpStore        OTPStore
+	tokenStore      TokenStore
+	refreshStore    RefreshTokenStore
+	revocationStore RevocationStore
+	mfaStore        MFAStore
+	passkeyStore    PasskeyStore
+	oauthStore      OAuthStore
+	apiKeyStore     APIKeyStore
+	sessionStore    SessionStore
+	loginHistory    LoginHistory
+	outbox          EventOutbox
+	auditLog        AuditLog
+	lockoutStore    LockoutStore
+	groupStore      GroupStore
+	orgStore        OrgStore
+	webhookStore    WebhookStore
+	transactor      Transactor
+	denylist        TokenDenylist
+	smsSender       sms.Sender
+	mailer          mail.Sender
+	tokenTTL        time.Duration
+	// roleTTL overrides tokenTTL for privileged roles.
+	roleTTL map[string]time.Duration
+	// refreshTTL of zero disables refresh tokens.
+	refreshTTL time.Duration
+	// serviceTokenTTL is lifetime of tokens issued to apps by the client credentials grant.
+	serviceTokenTTL time.Duration
+	tokenLeeway     time.Duration
+	// appSecretGrace is how long the previous secret of an app verifies tokens after rotation.
+	appSecretGrace time.Duration
+	lockout        LockoutPolicy
+	captcha        CaptchaPolicy
+	// passwords hashes new passwords; hashes made otherwise are rehashed on login.
+	passwords passhash.Hasher
+	// dummyHash is compared with passwords of unknown logins, see failUnknownLogin.
+	dummyHash func() []byte
+	breaches  BreachPolicy
+	// emailDomains restricts which addresses may self-register, per role.
+	emailDomains emaildomain.Rules
+	// regions records the region of this instance and replicates revocations.
+	regions RegionPolicy
+	// signingKeys sign tokens of the apps with RS256/ES256 instead of the app secret.
+	signingKeys map[int]*jwt.SigningKey
+	// enricher adds deployment-specific claims to access tokens; nil adds none.
+	enricher jwt.ClaimsEnricher
+	// mfaBox encrypts TOTP secrets; nil disables enrollment.
+	mfaBox    *secret.Box
+	mfaIssuer string
+	// webauthn with empty RPID disables passkeys.
+	webauthn webauthn.Config
+	// magicLinkURL is the frontend page receiving the token; empty disables magic links.
+	magicLinkURL string
+	// oidcIssuer is the iss of ID tokens; empty disables OpenID Connect.
+	oidcIssuer string
+	// social are external identity providers by name.
+	social map[string]social.Provider
+	// directories check passwords of users by lowercased email domain.
+	directories map[string]Directory
+	// config is the effective configuration shown to admins, secrets redacted.
+	config map[string]any
+
+	registrationMode atomic.Value
+	// access caches roles and organizations of users by id, see admin.go.
+	access *ttlcache.Cache[int64, userAccess]
+}
+
+// Deps are the storages and outside services Auth works with.
+type Deps struct {
+	UserSaver       UserSaver
+	UserProvider    UserProvider
+	AppProvider     AppProvider
+	RoleManager     RoleManager
+	JTIStore        JTIStore
+	OTPStore        OTPStore
+	TokenStore      TokenStore
+	RefreshStore    RefreshTokenStore
+	RevocationStore RevocationStore
+	MFAStore        MFAStore
+	PasskeyStore    PasskeyStore
+	OAuthStore      OAuthStore
+	APIKeyStore     APIKeyStore
+	SessionStore    SessionStore
+	LoginHistory    LoginHistory
+	Outbox          EventOutbox
+	AuditLog        AuditLog
+	LockoutStore    LockoutStore
+	GroupStore      GroupStore
+	OrgStore        OrgStore
+	WebhookStore    WebhookStore
+	Transactor      Transactor
+	// Denylist keeps revoked token ids instead of RevocationStore; nil uses RevocationStore.
+	Denylist TokenDenylist
+	SMS      sms.Sender
+	Mailer   mail.Sender
+	// Enricher adds deployment-specific claims to access tokens; nil adds none.
+	Enricher jwt.ClaimsEnricher
+	// Social are external identity providers by name.
+	Social map[string]social.Provider
+	// Directories check passwords of users by lowercased email domain.
+	Directories map[string]Directory
 }
 
-func New(log *slog.Logger, userSaver UserSaver, userProvider UserProvider, appProvider AppProvider, roleMgr RoleManager, tokenTTL time.Duration) *Auth {
-	return &Auth{
-		log:         log,
-		usrSaver:    userSaver,
-		usrProvider: userProvider,
-		appProvider: appProvider,
-		roleMgr:     roleMgr,
-		tokenTTL:    tokenTTL,
+// Options are the settings of Auth.
+type Options struct {
+	TokenTTL time.Duration
+	// RoleTTL overrides TokenTTL for privileged roles.
+	RoleTTL map[string]time.Duration
+	// RefreshTTL of zero disables refresh tokens.
+	RefreshTTL time.Duration
+	// ServiceTokenTTL is lifetime of tokens issued to apps by the client credentials grant.
+	ServiceTokenTTL time.Duration
+	TokenLeeway     time.Duration
+	// AppSecretGrace is how long the previous secret of an app verifies tokens after rotation.
+	AppSecretGrace time.Duration
+	Lockout        LockoutPolicy
+	Captcha        CaptchaPolicy
+	Passwords      passhash.Hasher
+	Breaches       BreachPolicy
+	EmailDomains   emaildomain.Rules
+	Regions        RegionPolicy
+	// SigningKeys sign tokens of the apps with RS256/ES256 instead of the app secret.
+	SigningKeys map[int]*jwt.SigningKey
+	// MFABox encrypts TOTP secrets; nil disables enrollment.
+	MFABox    *secret.Box
+	MFAIssuer string
+	// WebAuthn with empty RPID disables passkeys.
+	WebAuthn webauthn.Config
+	// MagicLinkURL is the frontend page receiving the token; empty disables magic links.
+	MagicLinkURL string
+	// OIDCIssuer is the iss of ID tokens; empty disables OpenID Connect.
+	OIDCIssuer string
+	// Config is the effective configuration shown to admins, secrets redacted.
+	Config map[string]any
+}
+
+func New(log *slog.Logger, deps Deps, opts Options) *Auth {
+	a := &Auth{
+		log:             log,
+		usrSaver:        deps.UserSaver,
+		usrProvider:     deps.UserProvider,
+		appProvider:     deps.AppProvider,
+		roleMgr:         deps.RoleManager,
+		jtiStore:        deps.JTIStore,
+		otpStore:        deps.OTPStore,
+		tokenStore:      deps.TokenStore,
+		refreshStore:    deps.RefreshStore,
+		revocationStore: deps.RevocationStore,
+		mfaStore:        deps.MFAStore,
+		passkeyStore:    deps.PasskeyStore,
+		oauthStore:      deps.OAuthStore,
+		apiKeyStore:     deps.APIKeyStore,
+		sessionStore:    deps.SessionStore,
+		loginHistory:    deps.LoginHistory,
+		outbox:          deps.Outbox,
+		auditLog:        deps.AuditLog,
+		lockoutStore:    deps.LockoutStore,
+		groupStore:      deps.GroupStore,
+		orgStore:        deps.OrgStore,
+		webhookStore:    deps.WebhookStore,
+		transactor:      deps.Transactor,
+		denylist:        deps.Denylist,
+		smsSender:       deps.SMS,
+		mailer:          deps.Mailer,
+		enricher:        deps.Enricher,
+		social:          deps.Social,
+		directories:     deps.Directories,
+
+		tokenTTL:        opts.TokenTTL,
+		roleTTL:         opts.RoleTTL,
+		refreshTTL:      opts.RefreshTTL,
+		serviceTokenTTL: opts.ServiceTokenTTL,
+		tokenLeeway:     opts.TokenLeeway,
+		appSecretGrace:  opts.AppSecretGrace,
+		lockout:         opts.Lockout,
+		captcha:         opts.Captcha,
+		passwords:       opts.Passwords,
+		breaches:        opts.Breaches,
+		emailDomains:    opts.EmailDomains,
+		regions:         opts.Regions,
+		signingKeys:     opts.SigningKeys,
+		mfaBox:          opts.MFABox,
+		mfaIssuer:       opts.MFAIssuer,
+		webauthn:        opts.WebAuthn,
+		magicLinkURL:    opts.MagicLinkURL,
+		oidcIssuer:      opts.OIDCIssuer,
+		config:          opts.Config,
 	}
+
+	a.registrationMode.Store(RegistrationOpen)
+	a.access = ttlcache.New[int64, userAccess](accessCacheTTL)
+	a.dummyHash = sync.OnceValue(func() []byte {
+		hash, _ := a.passwords.Hash(rand.Text())
+
+		return hash
+	})
+
+	return a
 }
 
-func (a *Auth) RegisterNewUser(ctx context.Context, email string, pass string, role string) (int64, error) {
+// RegisterNewUser creates a user identified by email or, if login looks like
+// a phone number, by phone. Phone users are sent a verification code.
+//
+// Depending on registration mode, registration may be closed or require
+// an invitation code issued for the login. A captcha is required if enabled,
+// and the password is checked against known breaches.
+func (a *Auth) RegisterNewUser(ctx context.Context, login string, pass string, role string, inviteCode string) (int64, error) {
 	const op = "Auth.RegisterNewUser"
 
-	log := a.log.With(slog.String("op", op))
+	log := a.logger(ctx).With(slog.String("op", op))
 	log.Info("registering new user")
 
-	passHash, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.DefaultCost)
+	if err := a.verifyCaptcha(ctx); err != nil {
+		if errors.Is(err, ErrCaptchaRequired) {
+			log.Info("captcha required")
+		} else {
+			log.Error("failed to verify captcha", sl.Err(err))
+		}
+
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.checkBreached(ctx, pass); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	invite, err := a.checkRegistration(inviteCode)
 	if err != nil {
-		log.Error("failed to hash password", sl.Err(err))
+		log.Info("registration rejected", sl.Err(err))
 
 		return 0, fmt.Errorf("%s: %w", op, err)
 	}
 
 	if role == "" {
-		role = "user"
+		role = defaultRole
 	} else {
-		validRoles := map[string]bool{
-			"user":      true,
-			"organizer": true,
-			"admin":     false,
+		r, err := a.lookupRole(ctx, role)
+		if err != nil {
+			log.Error("failed to check role", sl.Err(err))
+
+			return 0, fmt.Errorf("%s: %w", op, err)
 		}
-		if !validRoles[role] {
-			log.Error("invalid role")
+		if !r.SelfAssignable {
+			log.Warn("role is not self-assignable", slog.String("role", role))
 
 			return 0, fmt.Errorf("%s: %w", op, ErrInvalidRole)
 		}
 	}
 
-	id, err := a.usrSaver.SaveUser(ctx, email, passHash, role)
+	if !phone.Looks(login) && !a.emailDomains.Permits(login, role) {
+		log.Info("email domain is not allowed", slog.String("domain", emaildomain.Domain(login)))
+
+		return 0, fmt.Errorf("%s: %w", op, ErrEmailDomainNotAllowed)
+	}
+
+	id, err := a.saveUser(ctx, login, pass, role, invite)
 	if err != nil {
-		log.Error("failed to save user", sl.Err(err))
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return id, nil
+}
+
+// saveUser creates the user and spends the invitation, if not empty, in the
+// same transaction, see useInvitation.
+func (a *Auth) saveUser(ctx context.Context, login string, pass string, role string, invite string) (int64, error) {
+	const op = "Auth.saveUser"
+
+	passHash, err := a.passwords.Hash(pass)
+	if err != nil {
+		a.logger(ctx).Error("failed to hash password", sl.Err(err))
 
 		return 0, fmt.Errorf("%s: %w", op, err)
 	}
 
+	// Номер проверяем до транзакции, а код отправляем после неё
+	var e164 string
+	if phone.Looks(login) {
+		if e164, err = phone.Normalize(login); err != nil {
+			return 0, fmt.Errorf("%s: %w", op, ErrInvalidPhone)
+		}
+	}
+
+	var id int64
+	err = a.transactor.InTx(ctx, func(ctx context.Context) error {
+		var err error
+		if e164 != "" {
+			id, err = a.usrSaver.SavePhoneUser(ctx, e164, passHash, role)
+		} else {
+			id, err = a.usrSaver.SaveUser(ctx, login, passHash, role)
+		}
+		if err != nil {
+			a.logger(ctx).Error("failed to save user", sl.Err(err))
+
+			return err
+		}
+
+		// Пользователи, созданные от имени организации, попадают в неё
+		if orgID := callerOrg(ctx); orgID != 0 {
+			if err := a.orgStore.SetUserOrg(ctx, id, orgID); err != nil {
+				a.logger(ctx).Error("failed to set user organization", sl.Err(err))
+
+				return err
+			}
+		}
+
+		// Приглашение тратится последним, чтобы ошибка выше его не сожгла
+		if invite != "" {
+			if err := a.useInvitation(ctx, login, invite); err != nil {
+				a.logger(ctx).Info("invitation rejected", sl.Err(err))
+
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if e164 != "" {
+		// The account exists already, the user can ask for another code later.
+		if err := a.sendOTP(ctx, e164, purposePhoneVerify, "Your verification code: %s"); err != nil {
+			a.logger(ctx).Warn("failed to send verification code", sl.Err(err))
+		}
+	}
+
 	return id, nil
 }
 
-// Login checks if user with given credentials exists in the system and returns access token.
+// Login checks if user with given credentials exists in the system and returns access token
+// and refresh token, see Refresh. login is email, username or verified phone number of the user.
 //
 // If user exists, but password is incorrect, returns error.
 // If user doesn't exist, returns error.
 func (a *Auth) Login(
 	ctx context.Context,
-	email string,
+	login string,
 	password string,
 	appID int,
-) (string, error) {
+) (token string, refreshToken string, err error) {
 	const op = "Auth.Login"
 
-	log := a.log.With(
+	log := a.logger(ctx).With(
 		slog.String("op", op),
-		slog.String("username", email),
+		slog.String("username", login),
 		// pass не логируем
 	)
 
 	log.Info("attempting to login user")
 
+	user, err := a.checkPassword(ctx, login, password, appID)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Второй фактор: вместо токена возвращаем тикет для VerifyTOTP
+	if err := a.requireMFA(ctx, user, appID); err != nil {
+		a.recordFailedLogin(ctx, user.ID, appID, login, loginMethodPassword, err)
+
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	token, refreshToken, err = a.completeLogin(ctx, user, appID, loginMethodPassword)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("user logged in successfully")
+
+	return token, refreshToken, nil
+}
+
+// checkPassword finds the user by login and checks the password.
+// Failures are recorded in the login history and may lock the account.
+func (a *Auth) checkPassword(ctx context.Context, login string, password string, appID int) (models.User, error) {
+	var (
+		user models.User
+		err  error
+	)
+
+	// Пользователи доменов с каталогом входят с паролем из LDAP
+	if dir, ok := a.directories[emaildomain.Domain(login)]; ok {
+		return a.checkDirectoryPassword(ctx, dir, login, password, appID)
+	}
+
 	// Достаём пользователя из БД
-	user, err := a.usrProvider.User(ctx, email)
+	switch {
+	case strings.Contains(login, "@"):
+		user, err = a.usrProvider.User(ctx, login)
+	case phone.Looks(login):
+		user, err = a.userByVerifiedPhone(ctx, login)
+	default:
+		user, err = a.usrProvider.UserByUsername(ctx, strings.ToLower(login))
+	}
 	if err != nil {
 		if errors.Is(err, storage.ErrUserNotFound) {
-			a.log.Warn("user not found", sl.Err(err))
+			a.logger(ctx).Warn("user not found", sl.Err(err))
 
-			return "", fmt.Errorf("%s: %w", op, ErrUserNotFound)
+			return models.User{}, a.failUnknownLogin(ctx, login, password, appID)
 		}
 
-		a.log.Error("failed to get user", sl.Err(err))
+		a.logger(ctx).Error("failed to get user", sl.Err(err))
 
-		return "", fmt.Errorf("%s: %w", op, err)
+		return models.User{}, err
+	}
+
+	if err := a.checkLoginAllowed(ctx, user, appID, login); err != nil {
+		return models.User{}, err
 	}
 
 	// Проверяем корректность полученного пароля
-	if err := bcrypt.CompareHashAndPassword(user.PassHash, []byte(password)); err != nil {
-		a.log.Info("invalid credentials", sl.Err(err))
+	if err := a.passwords.Compare(user.PassHash, password); err != nil {
+		if !errors.Is(err, passhash.ErrMismatch) {
+			a.logger(ctx).Error("failed to compare password hash", sl.Err(err))
+		}
 
-		return "", fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+		a.logger(ctx).Info("invalid credentials", sl.Err(err))
+		a.recordLogin(ctx, user.ID, appID, login, loginMethodPassword, models.LoginInvalidCredentials)
+
+		if err := a.failLogin(ctx, user.ID, appID); err != nil {
+			if errors.Is(err, ErrAccountLocked) {
+				return models.User{}, err
+			}
+
+			a.logger(ctx).Error("failed to count failed login", sl.Err(err))
+		}
+
+		return models.User{}, ErrInvalidCredentials
+	}
+
+	if err := a.checkLoginActive(ctx, user, appID, login); err != nil {
+		return models.User{}, err
+	}
+
+	a.succeedLogin(ctx, user.ID)
+	a.rehashPassword(ctx, user, password)
+
+	return user, nil
+}
+
+// checkLoginAllowed rejects users who may not try a password now, before it is
+// checked. The account status is checked after the password, see checkLoginActive.
+func (a *Auth) checkLoginAllowed(ctx context.Context, user models.User, appID int, login string) error {
+	if err := a.checkLocked(ctx, user.ID); err != nil {
+		if errors.Is(err, ErrAccountLocked) {
+			a.logger(ctx).Info("account is locked", slog.Int64("uid", user.ID))
+			a.recordLogin(ctx, user.ID, appID, login, loginMethodPassword, models.LoginLocked)
+		}
+
+		return err
+	}
+
+	if err := a.checkLoginCaptcha(ctx, user.ID); err != nil {
+		if !errors.Is(err, ErrCaptchaRequired) {
+			a.logger(ctx).Error("failed to check captcha", sl.Err(err))
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// checkLoginActive rejects suspended and banned users once their password is
+// checked, so that the status isn't told to whoever merely knows the login.
+func (a *Auth) checkLoginActive(ctx context.Context, user models.User, appID int, login string) error {
+	if err := checkActive(user); err != nil {
+		a.logger(ctx).Info("account is suspended", slog.Int64("uid", user.ID))
+		a.recordLogin(ctx, user.ID, appID, login, loginMethodPassword, models.LoginSuspended)
+
+		return err
 	}
 
+	return nil
+}
+
+// rehashPassword replaces the hash made with another algorithm or parameters,
+// so users migrate to the configured ones as they log in.
+func (a *Auth) rehashPassword(ctx context.Context, user models.User, password string) {
+	if !a.passwords.NeedsRehash(user.PassHash) {
+		return
+	}
+
+	log := a.logger(ctx).With(slog.Int64("uid", user.ID))
+
+	passHash, err := a.passwords.Hash(password)
+	if err != nil {
+		log.Warn("failed to rehash password", sl.Err(err))
+
+		return
+	}
+
+	// Старый хеш остаётся рабочим, так что ошибка не мешает входу
+	if err := a.usrSaver.UpdatePassHash(ctx, user.ID, passHash); err != nil {
+		log.Warn("failed to save rehashed password", sl.Err(err))
+
+		return
+	}
+
+	log.Info("password rehashed", slog.String("algorithm", a.passwords.Algorithm))
+}
+
+// completeLogin issues tokens to the user authenticated by method and records the login.
+func (a *Auth) completeLogin(ctx context.Context, user models.User, appID int, method string) (token string, refreshToken string, err error) {
+	if err := a.checkCanLogin(ctx, user); err != nil {
+		return "", "", err
+	}
+
+	sessionID, err := a.startSession(ctx, user, appID)
+	if err != nil {
+		a.logger(ctx).Error("failed to start session", sl.Err(err))
+
+		return "", "", err
+	}
+
+	token, err = a.issueToken(ctx, user, appID, sessionID)
+	if err != nil {
+		return "", "", err
+	}
+
+	refreshToken, err = a.issueRefreshToken(ctx, user.ID, appID, sessionID)
+	if err != nil {
+		a.logger(ctx).Error("failed to issue refresh token", sl.Err(err))
+
+		return "", "", err
+	}
+
+	if err := a.usrSaver.TouchLogin(ctx, user.ID); err != nil {
+		a.logger(ctx).Warn("failed to record login time", sl.Err(err))
+	}
+
+	a.recordLogin(ctx, user.ID, appID, "", method, models.LoginSucceeded)
+
+	return token, refreshToken, nil
+}
+
+// issueToken creates access token of the user for the app in the session, bound
+// to the client certificate or DPoP key presented with the request, if any.
+func (a *Auth) issueToken(ctx context.Context, user models.User, appID int, sessionID int64) (string, error) {
+	const op = "Auth.issueToken"
+
 	// Получаем информацию о приложении
 	app, err := a.appProvider.App(ctx, appID)
 	if err != nil {
 		return "", fmt.Errorf("%s: %w", op, err)
 	}
 
-	log.Info("user logged in successfully")
+	if err := a.checkCanLogin(ctx, user); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	// В приложения организации входят только её пользователи
+	if app.OrgID != 0 && app.OrgID != user.OrgID {
+		a.logger(ctx).Info("user is not in the organization of the app", slog.Int64("org_id", app.OrgID))
+
+		return "", fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+	}
+
+	// Права роли попадают в scope, чтобы сервисам не нужно было спрашивать роль
+	role, err := a.roleMgr.Role(ctx, user.Role)
+	if err != nil && !errors.Is(err, storage.ErrRoleNotFound) {
+		a.logger(ctx).Error("failed to get role permissions", sl.Err(err))
+
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	groups, err := a.groupStore.UserGroups(ctx, user.ID)
+	if err != nil {
+		a.logger(ctx).Error("failed to get user groups", sl.Err(err))
+
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	opts := []jwt.Option{
+		jwt.WithRegion(a.regions.Region),
+		jwt.WithSessionID(sessionID),
+		jwt.WithScopes(role.Permissions),
+		jwt.WithGroups(groups),
+		jwt.WithOrgID(user.OrgID),
+	}
+
+	if a.enricher != nil {
+		extra, err := a.enricher.EnrichClaims(ctx, user, app)
+		if err != nil {
+			a.logger(ctx).Error("failed to enrich token claims", sl.Err(err))
+
+			return "", fmt.Errorf("%s: %w", op, err)
+		}
+
+		opts = append(opts, jwt.WithClaims(extra))
+	}
+
+	// Привязываем токен к клиентскому сертификату, если он был предъявлен (RFC 8705)
+	if thumbprint, ok := mtls.PeerThumbprint(ctx); ok {
+		opts = append(opts, jwt.WithCertThumbprint(thumbprint))
+	}
+
+	// Привязываем токен к ключу клиента, если передан DPoP proof (RFC 9449)
+	if proof, htu, ok := dpop.FromIncomingContext(ctx); ok {
+		p, err := dpop.Verify(proof, dpop.Method, htu, time.Now(), a.tokenLeeway)
+		if err != nil {
+			a.logger(ctx).Info("invalid dpop proof", sl.Err(err))
+
+			return "", fmt.Errorf("%s: %w", op, ErrInvalidDPoPProof)
+		}
+
+		if err := a.useJTI(ctx, p.JTI, time.Now().Add(dpop.MaxProofAge+a.tokenLeeway)); err != nil {
+			if errors.Is(err, ErrTokenReplayed) {
+				a.logger(ctx).Info("dpop proof replayed", sl.Err(err))
+
+				return "", fmt.Errorf("%s: %w", op, ErrInvalidDPoPProof)
+			}
+
+			return "", fmt.Errorf("%s: %w", op, err)
+		}
+
+		opts = append(opts, jwt.WithKeyThumbprint(p.JKT))
+	}
+
+	if err := fault.SigningError(ctx); err != nil {
+		a.logger(ctx).Error("failed to generate token", sl.Err(err))
+
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
 
 	// Создаём токен авторизации
-	token, err := jwt.NewToken(user, app, a.tokenTTL)
+	token, err := jwt.NewSignedToken(user, app, a.appTTL(app, user.Role), a.signingKeys[app.ID], opts...)
 	if err != nil {
-		a.log.Error("failed to generate token", sl.Err(err))
+		a.logger(ctx).Error("failed to generate token", sl.Err(err))
 
 		return "", fmt.Errorf("%s: %w", op, err)
 	}
@@ -170,17 +1004,24 @@ func (a *Auth) Login(
 func (a *Auth) UpdateRole(ctx context.Context, userID int64, role string) error {
 	const op = "Auth.AssignRole"
 
-	log := a.log.With(slog.String("op", op), slog.String("role", role))
+	log := a.logger(ctx).With(slog.String("op", op), slog.String("role", role))
 	log.Info("attempting to assign role")
 
-	if role != "user" && role != "organizer" && role != "admin" {
-		return fmt.Errorf("%s: invalid role: %q", op, role)
+	if _, err := a.lookupRole(ctx, role); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.checkUserOrg(ctx, userID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
 	}
 
 	err := a.usrSaver.UpdateRole(ctx, userID, role)
 	if err != nil {
+		if errors.Is(err, storage.ErrRoleNotFound) {
+			return fmt.Errorf("%s: %w", op, ErrInvalidRole)
+		}
 		if errors.Is(err, storage.ErrUserNotFound) {
-			a.log.Warn("user not found", sl.Err(err))
+			a.logger(ctx).Warn("user not found", sl.Err(err))
 
 			return fmt.Errorf("%s: %w", op, ErrUserNotFound)
 
@@ -188,42 +1029,439 @@ func (a *Auth) UpdateRole(ctx context.Context, userID int64, role string) error
 		return fmt.Errorf("%s: %w", op, err)
 	}
 
-	a.log.Info("updated role")
+	a.access.Delete(userID)
+
+	a.audit(ctx, models.AuditEvent{
+		Action:       models.AuditRoleChanged,
+		TargetUserID: userID,
+		Details:      map[string]string{"role": role},
+	})
+
+	a.logger(ctx).Info("updated role")
 	return nil
 }
 
 func (a *Auth) GetUserRole(ctx context.Context, userID int64) (string, error) {
 	const op = "Auth.GetRole"
 
-	log := a.log.With(slog.String("op", op), slog.Int64("uid", userID))
+	log := a.logger(ctx).With(slog.String("op", op), slog.Int64("uid", userID))
 	log.Info("attempting to get role")
 
-	role, err := a.usrProvider.GetUserRole(ctx, userID)
+	access, err := a.userAccess(ctx, userID)
 	if err != nil {
 		if errors.Is(err, storage.ErrUserNotFound) {
-			a.log.Warn("user not found", sl.Err(err))
+			a.logger(ctx).Warn("user not found", sl.Err(err))
 			return "", fmt.Errorf("%s: %w", op, ErrUserNotFound)
 		}
-		a.log.Error("failed to get user role", sl.Err(err))
+		a.logger(ctx).Error("failed to get user role", sl.Err(err))
 		return "", fmt.Errorf("%s: %w", op, err)
 	}
 
+	if orgID := callerOrg(ctx); orgID != 0 && orgID != access.orgID {
+		return "", fmt.Errorf("%s: %w", op, ErrUserNotFound)
+	}
+
 	log.Info("role retrieved successfully")
 
-	return role, nil
+	return access.role, nil
+}
+
+// GetUser returns the user by id or, if userID is zero, by email, without the
+// password hash. Users outside the caller's organization are ErrUserNotFound.
+func (a *Auth) GetUser(ctx context.Context, userID int64, email string) (models.User, error) {
+	const op = "Auth.GetUser"
+
+	log := a.logger(ctx).With(slog.String("op", op), slog.Int64("uid", userID))
+	log.Info("attempting to get user")
+
+	var (
+		user models.User
+		err  error
+	)
+	switch {
+	case userID != 0:
+		user, err = a.usrProvider.UserByID(ctx, userID)
+	case email != "":
+		user, err = a.usrProvider.User(ctx, email)
+	default:
+		err = storage.ErrUserNotFound
+	}
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("user not found", sl.Err(err))
+			return models.User{}, fmt.Errorf("%s: %w", op, ErrUserNotFound)
+		}
+		log.Error("failed to get user", sl.Err(err))
+		return models.User{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if !inCallerOrg(ctx, user) {
+		return models.User{}, fmt.Errorf("%s: %w", op, ErrUserNotFound)
+	}
+
+	user.PassHash = nil
+
+	log.Info("user retrieved successfully")
+	return user, nil
 }
 
-func (a *Auth) ListUsers(ctx context.Context) ([]models.User, error) {
+// ListUsers returns a page of users of the caller's organization matching
+// the filter, and the token of the next page, empty on the last one.
+func (a *Auth) ListUsers(ctx context.Context, filter models.UserFilter, sort models.UserSort, pageToken string, limit int) (users []models.User, next string, err error) {
 	const op = "Auth.ListUsers"
-	log := a.log.With(slog.String("op", op))
+	log := a.logger(ctx).With(slog.String("op", op))
 	log.Info("attempting to list users")
 
-	users, err := a.usrProvider.ListUsers(ctx)
+	after, err := parseUserPageToken(pageToken, sort)
 	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	filter.OrgID = callerOrg(ctx)
+	size := pageSize(limit)
+
+	users, err = a.usrProvider.ListUsers(ctx, filter, sort, after, size+1)
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidSort) {
+			return nil, "", fmt.Errorf("%s: %w", op, ErrInvalidSort)
+		}
+
 		log.Error("failed to list users", sl.Err(err))
-		return nil, fmt.Errorf("%s: %w", op, err)
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if len(users) > size {
+		users = users[:size]
+		next = userPageToken(users[size-1], sort)
 	}
 
 	log.Info("users listed successfully")
+	return users, next, nil
+}
+
+// maxSearchQuery is the longest search query in bytes.
+const maxSearchQuery = 256
+
+// SearchUsers returns up to limit users of the caller's organization whose
+// email or username contains the query or resembles it, best matches first.
+func (a *Auth) SearchUsers(ctx context.Context, query string, limit int) ([]models.User, error) {
+	const op = "Auth.SearchUsers"
+	log := a.logger(ctx).With(slog.String("op", op))
+	log.Info("attempting to search users")
+
+	query = strings.TrimSpace(query)
+	if query == "" || len(query) > maxSearchQuery {
+		return nil, fmt.Errorf("%s: %w", op, ErrInvalidQuery)
+	}
+
+	users, err := a.usrProvider.SearchUsers(ctx, query, callerOrg(ctx), pageSize(limit))
+	if err != nil {
+		log.Error("failed to search users", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("users searched successfully", slog.Int("found", len(users)))
 	return users, nil
 }
+
+// MergeUsers consolidates two accounts of the same person: everything owned by fromID
+// is moved to intoID, which keeps the more privileged of both roles.
+// fromID is removed, but lookups by it are redirected to intoID.
+func (a *Auth) MergeUsers(ctx context.Context, fromID int64, intoID int64) error {
+	const op = "Auth.MergeUsers"
+
+	log := a.logger(ctx).With(slog.String("op", op), slog.Int64("from_uid", fromID), slog.Int64("into_uid", intoID))
+	log.Info("attempting to merge users")
+
+	if fromID == intoID {
+		return fmt.Errorf("%s: %w", op, ErrSelfMerge)
+	}
+
+	from, err := a.usrProvider.UserByID(ctx, fromID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, userErr(err))
+	}
+
+	into, err := a.usrProvider.UserByID(ctx, intoID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, userErr(err))
+	}
+
+	// fromID may already be a redirect to intoID
+	if from.ID == into.ID {
+		return fmt.Errorf("%s: %w", op, ErrSelfMerge)
+	}
+
+	if !inCallerOrg(ctx, from) || !inCallerOrg(ctx, into) {
+		return fmt.Errorf("%s: %w", op, ErrUserNotFound)
+	}
+
+	role := into.Role
+	if a.roleRank(ctx, from.Role) > a.roleRank(ctx, into.Role) {
+		role = from.Role
+	}
+
+	if err := a.usrSaver.MergeUsers(ctx, from.ID, into.ID, role); err != nil {
+		log.Error("failed to merge users", sl.Err(err))
+
+		return fmt.Errorf("%s: %w", op, userErr(err))
+	}
+
+	a.access.Delete(from.ID)
+	a.access.Delete(into.ID)
+
+	a.audit(ctx, models.AuditEvent{
+		Action:       models.AuditUsersMerged,
+		TargetUserID: into.ID,
+		Details:      map[string]string{"from_uid": strconv.FormatInt(from.ID, 10), "role": role},
+	})
+
+	log.Info("users merged")
+
+	return nil
+}
+
+// DeleteUser removes the account with its credentials, refresh tokens and
+// pending codes. Access tokens already issued to the user stop validating.
+// With anonymize the account is scrubbed of personal data instead, keeping
+// an opaque record for services that reference the user id.
+func (a *Auth) DeleteUser(ctx context.Context, userID int64, anonymize bool) error {
+	const op = "Auth.DeleteUser"
+
+	log := a.logger(ctx).With(slog.String("op", op), slog.Int64("uid", userID), slog.Bool("anonymize", anonymize))
+	log.Info("attempting to delete user")
+
+	if err := a.checkUserOrg(ctx, userID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	action := models.AuditUserDeleted
+	deleteUser := a.usrSaver.DeleteUser
+	if anonymize {
+		action = models.AuditUserAnonymized
+		deleteUser = a.usrSaver.AnonymizeUser
+	}
+
+	if err := deleteUser(ctx, userID); err != nil {
+		if !errors.Is(err, storage.ErrUserNotFound) {
+			log.Error("failed to delete user", sl.Err(err))
+		}
+
+		return fmt.Errorf("%s: %w", op, userErr(err))
+	}
+
+	// Строка анонимизированного пользователя остаётся, поэтому токены отзываем явно
+	if anonymize {
+		if err := a.revokeUserTokens(ctx, userID); err != nil {
+			log.Error("failed to revoke user tokens", sl.Err(err))
+
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	a.access.Delete(userID)
+
+	a.audit(ctx, models.AuditEvent{Action: action, TargetUserID: userID})
+
+	log.Info("user deleted")
+
+	return nil
+}
+
+// SoftDeleteUser hides the user from lookups and stops the user from logging in,
+// revoking issued tokens, until RestoreUser. Admins still see the user by id.
+func (a *Auth) SoftDeleteUser(ctx context.Context, userID int64) error {
+	const op = "Auth.SoftDeleteUser"
+
+	log := a.logger(ctx).With(slog.String("op", op), slog.Int64("uid", userID))
+	log.Info("attempting to soft delete user")
+
+	if err := a.checkUserOrg(ctx, userID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.usrSaver.SoftDeleteUser(ctx, userID); err != nil {
+		if !errors.Is(err, storage.ErrUserNotFound) {
+			log.Error("failed to soft delete user", sl.Err(err))
+		}
+
+		return fmt.Errorf("%s: %w", op, userErr(err))
+	}
+
+	if err := a.revokeUserTokens(ctx, userID); err != nil {
+		log.Error("failed to revoke user tokens", sl.Err(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	a.access.Delete(userID)
+
+	a.audit(ctx, models.AuditEvent{Action: models.AuditUserSoftDeleted, TargetUserID: userID})
+
+	log.Info("user soft deleted")
+
+	return nil
+}
+
+// RestoreUser undoes SoftDeleteUser. Tokens revoked on deletion stay revoked.
+func (a *Auth) RestoreUser(ctx context.Context, userID int64) error {
+	const op = "Auth.RestoreUser"
+
+	log := a.logger(ctx).With(slog.String("op", op), slog.Int64("uid", userID))
+	log.Info("attempting to restore user")
+
+	if err := a.checkUserOrg(ctx, userID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.usrSaver.RestoreUser(ctx, userID); err != nil {
+		if !errors.Is(err, storage.ErrUserNotFound) {
+			log.Error("failed to restore user", sl.Err(err))
+		}
+
+		return fmt.Errorf("%s: %w", op, userErr(err))
+	}
+
+	a.audit(ctx, models.AuditEvent{Action: models.AuditUserRestored, TargetUserID: userID})
+
+	log.Info("user restored")
+
+	return nil
+}
+
+// usernameRe allows public handles like "city_events.org": lowercase, 3 to 32 chars,
+// no "@" so that they can't be confused with emails on Login.
+var usernameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_.]{2,31}$`)
+
+// SetUsername sets the public handle of the user, usable on Login instead of email.
+// Empty username removes the handle.
+func (a *Auth) SetUsername(ctx context.Context, userID int64, username string) error {
+	const op = "Auth.SetUsername"
+
+	log := a.logger(ctx).With(slog.String("op", op), slog.Int64("uid", userID))
+	log.Info("attempting to set username")
+
+	username = strings.ToLower(strings.TrimSpace(username))
+	if username != "" && !usernameRe.MatchString(username) {
+		return fmt.Errorf("%s: %w", op, ErrInvalidUsername)
+	}
+
+	if err := a.checkUserOrg(ctx, userID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.usrSaver.SetUsername(ctx, userID, username); err != nil {
+		if errors.Is(err, storage.ErrUsernameTaken) {
+			return fmt.Errorf("%s: %w", op, ErrUsernameTaken)
+		}
+
+		log.Error("failed to set username", sl.Err(err))
+
+		return fmt.Errorf("%s: %w", op, userErr(err))
+	}
+
+	log.Info("username set")
+
+	return nil
+}
+
+// roleRank orders roles by privilege. Unknown roles rank lowest.
+func (a *Auth) roleRank(ctx context.Context, name string) int {
+	role, err := a.roleMgr.Role(ctx, name)
+	if err != nil {
+		a.logger(ctx).Warn("failed to get role rank", slog.String("role", name), sl.Err(err))
+
+		return math.MinInt
+	}
+
+	return role.Rank
+}
+
+// userErr maps storage "not found" to the service error.
+func userErr(err error) error {
+	if errors.Is(err, storage.ErrUserNotFound) {
+		return ErrUserNotFound
+	}
+
+	return err
+}
+
+// ttlFor returns access token lifetime for the given role.
+func (a *Auth) ttlFor(role string) time.Duration {
+	if ttl, ok := a.roleTTL[role]; ok && ttl > 0 {
+		return ttl
+	}
+
+	return a.tokenTTL
+}
+
+// appTTL returns access token lifetime for the role in the app. The app
+// lifetime replaces the global one, but lifetimes of roles still cap it.
+func (a *Auth) appTTL(app models.App, role string) time.Duration {
+	if app.TokenTTL <= 0 {
+		return a.ttlFor(role)
+	}
+
+	if ttl, ok := a.roleTTL[role]; ok && ttl > 0 {
+		return min(app.TokenTTL, ttl)
+	}
+
+	return app.TokenTTL
+}
+
+// UserExists is a cheap check for registration forms whether the email is taken.
+func (a *Auth) UserExists(ctx context.Context, email string) (bool, error) {
+	const op = "Auth.UserExists"
+
+	exists, err := a.usrProvider.UserExists(ctx, email)
+	if err != nil {
+		a.logger(ctx).Error("failed to check user existence", slog.String("op", op), sl.Err(err))
+
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return exists, nil
+}
+
+// CountUsers returns number of users matching the filter.
+func (a *Auth) CountUsers(ctx context.Context, filter models.UserFilter) (int64, error) {
+	const op = "Auth.CountUsers"
+
+	if orgID := callerOrg(ctx); orgID != 0 {
+		filter.OrgID = orgID
+	}
+
+	count, err := a.usrProvider.CountUsers(ctx, filter)
+	if err != nil {
+		a.logger(ctx).Error("failed to count users", slog.String("op", op), sl.Err(err))
+
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return count, nil
+}
+
+// useJTI consumes the id of a one-time token (reset, magic link, device code, dpop proof).
+// A second attempt to use the same id before it expires fails with ErrTokenReplayed.
+func (a *Auth) useJTI(ctx context.Context, jti string, expiresAt time.Time) error {
+	const op = "Auth.useJTI"
+
+	if jti == "" {
+		return fmt.Errorf("%s: %w", op, ErrTokenReplayed)
+	}
+
+	if err := a.jtiStore.UseJTI(ctx, jti, expiresAt); err != nil {
+		if errors.Is(err, storage.ErrJTIUsed) {
+			return fmt.Errorf("%s: %w", op, ErrTokenReplayed)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// logger returns the service logger with the request id of ctx attached.
+func (a *Auth) logger(ctx context.Context) *slog.Logger {
+	return requestid.Logger(ctx, a.log)
+}
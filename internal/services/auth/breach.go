@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"context"
+	"log/slog"
+	"sso/internal/lib/logger/sl"
+)
+
+// BreachChecker tells how many times a password appeared in known data breaches.
+type BreachChecker interface {
+	Count(ctx context.Context, password string) (int, error)
+}
+
+// BreachPolicy checks new passwords against known breaches. Nil Checker disables the check.
+type BreachPolicy struct {
+	Checker BreachChecker
+	// Reject fails with ErrPasswordBreached; otherwise breached passwords are only
+	// logged, e.g. to see how many users are affected before enforcing the check.
+	Reject bool
+}
+
+// checkBreached returns ErrPasswordBreached for breached passwords if the policy rejects them.
+// Failures of the checker don't block users.
+func (a *Auth) checkBreached(ctx context.Context, password string) error {
+	if a.breaches.Checker == nil {
+		return nil
+	}
+
+	count, err := a.breaches.Checker.Count(ctx, password)
+	if err != nil {
+		a.logger(ctx).Warn("failed to check password against breaches", sl.Err(err))
+
+		return nil
+	}
+
+	if count == 0 {
+		return nil
+	}
+
+	a.logger(ctx).Warn("password found in breaches", slog.Int("count", count), slog.Bool("rejected", a.breaches.Reject))
+
+	if a.breaches.Reject {
+		return ErrPasswordBreached
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sso/internal/lib/captcha"
+	"sso/internal/lib/clientinfo"
+	"sso/internal/lib/logger/sl"
+)
+
+// CaptchaPolicy requires a solved captcha on registration and on login after
+// LoginAfter consecutive failed logins of the account. Nil Verifier disables captcha.
+type CaptchaPolicy struct {
+	Verifier   captcha.Verifier
+	LoginAfter int
+}
+
+func (p CaptchaPolicy) enabled() bool {
+	return p.Verifier != nil
+}
+
+// verifyCaptcha checks the token passed with the request, returning
+// ErrCaptchaRequired if there is none or the provider rejected it.
+func (a *Auth) verifyCaptcha(ctx context.Context) error {
+	if !a.captcha.enabled() {
+		return nil
+	}
+
+	token := captcha.Token(ctx)
+	if token == "" {
+		return ErrCaptchaRequired
+	}
+
+	if err := a.captcha.Verifier.Verify(ctx, token, clientinfo.FromContext(ctx).IP); err != nil {
+		if errors.Is(err, captcha.ErrRejected) {
+			a.logger(ctx).Info("captcha rejected", sl.Err(err))
+
+			return ErrCaptchaRequired
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// checkLoginCaptcha requires a captcha once the account has enough failed logins.
+func (a *Auth) checkLoginCaptcha(ctx context.Context, userID int64) error {
+	if !a.captcha.enabled() {
+		return nil
+	}
+
+	failures, err := a.lockoutStore.FailedLogins(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if failures < a.captcha.LoginAfter {
+		return nil
+	}
+
+	a.logger(ctx).Debug("captcha required for login", slog.Int64("uid", userID), slog.Int("failures", failures))
+
+	return a.verifyCaptcha(ctx)
+}
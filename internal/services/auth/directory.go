@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/ldap"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/storage"
+)
+
+// checkDirectoryPassword checks the password in the directory instead of the
+// local hash. A user seen for the first time gets a local record with a random
+// password; the role follows the directory groups on every login.
+func (a *Auth) checkDirectoryPassword(ctx context.Context, dir Directory, login string, password string, appID int) (models.User, error) {
+	user, err := a.usrProvider.User(ctx, login)
+	if err != nil && !errors.Is(err, storage.ErrUserNotFound) {
+		a.logger(ctx).Error("failed to get user", sl.Err(err))
+
+		return models.User{}, err
+	}
+	known := err == nil
+
+	if known {
+		if err := a.checkLoginAllowed(ctx, user, appID, login); err != nil {
+			return models.User{}, err
+		}
+	}
+
+	identity, err := dir.Authenticate(ctx, login, password)
+	if err != nil {
+		if !errors.Is(err, ldap.ErrInvalidCredentials) {
+			a.logger(ctx).Error("failed to authenticate in directory", sl.Err(err))
+
+			return models.User{}, err
+		}
+
+		a.logger(ctx).Info("invalid credentials", sl.Err(err))
+		a.recordLogin(ctx, user.ID, appID, login, loginMethodPassword, models.LoginInvalidCredentials)
+
+		if known {
+			if err := a.failLogin(ctx, user.ID, appID); err != nil {
+				if errors.Is(err, ErrAccountLocked) {
+					return models.User{}, err
+				}
+
+				a.logger(ctx).Error("failed to count failed login", sl.Err(err))
+			}
+		}
+
+		return models.User{}, ErrInvalidCredentials
+	}
+
+	if known {
+		if err := a.checkLoginActive(ctx, user, appID, login); err != nil {
+			return models.User{}, err
+		}
+	}
+
+	role := dir.Role(identity)
+	if role == "" {
+		role = defaultRole
+	}
+
+	if !known {
+		if user, err = a.provisionDirectoryUser(ctx, login, role); err != nil {
+			return models.User{}, err
+		}
+	} else if user.Role != role {
+		if err := a.usrSaver.UpdateRole(ctx, user.ID, role); err != nil {
+			a.logger(ctx).Error("failed to sync role from directory", sl.Err(err))
+
+			return models.User{}, err
+		}
+
+		a.access.Delete(user.ID)
+		a.audit(ctx, models.AuditEvent{
+			Action:       models.AuditRoleChanged,
+			TargetUserID: user.ID,
+			Details:      map[string]string{"role": role, "source": "ldap"},
+		})
+
+		user.Role = role
+	}
+
+	a.succeedLogin(ctx, user.ID)
+
+	return user, nil
+}
+
+// provisionDirectoryUser creates the local record of a directory user.
+// The random password is never used: the directory checks passwords.
+func (a *Auth) provisionDirectoryUser(ctx context.Context, email string, role string) (models.User, error) {
+	pass, err := randomPassword()
+	if err != nil {
+		return models.User{}, err
+	}
+
+	var id int64
+	err = a.transactor.InTx(ctx, func(ctx context.Context) error {
+		if id, err = a.saveUser(ctx, email, pass, role, ""); err != nil {
+			return err
+		}
+
+		err := a.recordAudit(ctx, models.AuditEvent{
+			Action:       models.AuditUserProvisioned,
+			TargetUserID: id,
+			Details:      map[string]string{"source": "ldap"},
+		})
+		if err != nil {
+			a.logger(ctx).Error("failed to record audit event", sl.Err(err))
+		}
+
+		return err
+	})
+	if err != nil {
+		return models.User{}, err
+	}
+
+	a.logger(ctx).Info("user provisioned from directory", slog.Int64("uid", id))
+
+	return a.usrProvider.UserByID(ctx, id)
+}
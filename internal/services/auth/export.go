@@ -0,0 +1,238 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/logger/sl"
+	"strconv"
+	"time"
+)
+
+// exportBatch is how many login attempts and audit events are read at once.
+const exportBatch = 500
+
+// userExport is the JSON document of ExportUserData. Secrets (password hash,
+// authenticator secrets, token hashes) are never exported.
+type userExport struct {
+	ExportedAt   time.Time                  `json:"exported_at"`
+	Profile      exportedProfile            `json:"profile"`
+	Preferences  map[string]string          `json:"preferences"`
+	Metadata     map[string]json.RawMessage `json:"metadata"`
+	Groups       []string                   `json:"groups"`
+	Sessions     []exportedSession          `json:"sessions"`
+	LoginHistory []exportedLoginAttempt     `json:"login_history"`
+	AuditEntries []exportedAuditEvent       `json:"audit_entries"`
+}
+
+type exportedProfile struct {
+	ID            int64      `json:"id"`
+	Email         string     `json:"email,omitempty"`
+	Username      string     `json:"username,omitempty"`
+	Phone         string     `json:"phone,omitempty"`
+	PhoneVerified bool       `json:"phone_verified"`
+	AvatarURL     string     `json:"avatar_url,omitempty"`
+	Role          string     `json:"role"`
+	Status        string     `json:"status"`
+	OrgID         int64      `json:"org_id,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	LastLoginAt   *time.Time `json:"last_login_at,omitempty"`
+	DeletedAt     *time.Time `json:"deleted_at,omitempty"`
+}
+
+type exportedSession struct {
+	ID         int64     `json:"id"`
+	AppID      int       `json:"app_id"`
+	IP         string    `json:"ip,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	Device     string    `json:"device,omitempty"`
+	Region     string    `json:"region,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+type exportedLoginAttempt struct {
+	AppID     int       `json:"app_id,omitempty"`
+	Login     string    `json:"login,omitempty"`
+	Method    string    `json:"method"`
+	Result    string    `json:"result"`
+	IP        string    `json:"ip,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type exportedAuditEvent struct {
+	ID           int64             `json:"id"`
+	Action       string            `json:"action"`
+	ActorUserID  int64             `json:"actor_user_id,omitempty"`
+	TargetUserID int64             `json:"target_user_id,omitempty"`
+	Reason       string            `json:"reason,omitempty"`
+	Details      map[string]string `json:"details,omitempty"`
+	CreatedAt    time.Time         `json:"created_at"`
+}
+
+// ExportUserData returns everything stored about the user as a JSON document,
+// for subject access requests: profile, preferences, app metadata, groups,
+// sessions, login history and audit entries by or about the user.
+func (a *Auth) ExportUserData(ctx context.Context, userID int64) ([]byte, error) {
+	const op = "Auth.ExportUserData"
+
+	log := a.logger(ctx).With(slog.String("op", op), slog.Int64("uid", userID))
+	log.Info("attempting to export user data")
+
+	user, err := a.usrProvider.UserByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, userErr(err))
+	}
+	if !inCallerOrg(ctx, user) {
+		return nil, fmt.Errorf("%s: %w", op, ErrUserNotFound)
+	}
+
+	export, err := a.collectUserData(ctx, user)
+	if err != nil {
+		log.Error("failed to collect user data", sl.Err(err))
+
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	data, err := json.Marshal(export)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	a.audit(ctx, models.AuditEvent{Action: models.AuditUserDataExported, TargetUserID: user.ID})
+
+	log.Info("user data exported", slog.Int("bytes", len(data)))
+
+	return data, nil
+}
+
+func (a *Auth) collectUserData(ctx context.Context, user models.User) (userExport, error) {
+	export := userExport{
+		ExportedAt: time.Now().UTC(),
+		Profile: exportedProfile{
+			ID:            user.ID,
+			Email:         user.Email,
+			Username:      user.Username,
+			Phone:         user.Phone,
+			PhoneVerified: user.PhoneVerified,
+			AvatarURL:     user.AvatarURL,
+			Role:          user.Role,
+			Status:        user.Status,
+			OrgID:         user.OrgID,
+			CreatedAt:     user.CreatedAt,
+			LastLoginAt:   optionalTime(user.LastLoginAt),
+			DeletedAt:     optionalTime(user.DeletedAt),
+		},
+		Metadata: make(map[string]json.RawMessage),
+	}
+
+	var err error
+
+	if export.Preferences, err = a.usrProvider.Preferences(ctx, user.ID); err != nil {
+		return userExport{}, err
+	}
+
+	// Метаданные хранятся по приложениям, пустые объекты не выгружаем
+	apps, err := a.appProvider.Apps(ctx, 0)
+	if err != nil {
+		return userExport{}, err
+	}
+	for _, app := range apps {
+		data, err := a.usrProvider.UserMetadata(ctx, user.ID, app.ID)
+		if err != nil {
+			return userExport{}, err
+		}
+		if string(data) != "{}" {
+			export.Metadata[strconv.Itoa(app.ID)] = data
+		}
+	}
+
+	if export.Groups, err = a.groupStore.UserGroups(ctx, user.ID); err != nil {
+		return userExport{}, err
+	}
+
+	sessions, err := a.sessionStore.Sessions(ctx, user.ID)
+	if err != nil {
+		return userExport{}, err
+	}
+	for _, s := range sessions {
+		export.Sessions = append(export.Sessions, exportedSession{
+			ID:         s.ID,
+			AppID:      s.AppID,
+			IP:         s.IP,
+			UserAgent:  s.UserAgent,
+			Device:     s.Device,
+			Region:     s.Region,
+			CreatedAt:  s.CreatedAt,
+			LastSeenAt: s.LastSeenAt,
+			ExpiresAt:  s.ExpiresAt,
+		})
+	}
+
+	for beforeID := int64(0); ; {
+		attempts, err := a.loginHistory.LoginAttempts(ctx, user.ID, beforeID, exportBatch)
+		if err != nil {
+			return userExport{}, err
+		}
+		for _, la := range attempts {
+			export.LoginHistory = append(export.LoginHistory, exportedLoginAttempt{
+				AppID:     la.AppID,
+				Login:     la.Login,
+				Method:    la.Method,
+				Result:    la.Result,
+				IP:        la.IP,
+				UserAgent: la.UserAgent,
+				CreatedAt: la.CreatedAt,
+			})
+		}
+		if len(attempts) < exportBatch {
+			break
+		}
+		beforeID = attempts[len(attempts)-1].ID
+	}
+
+	// События, где пользователь и исполнитель, и цель, попадают в выгрузку один раз
+	seen := make(map[int64]bool)
+	for _, filter := range []models.AuditFilter{{TargetUserID: user.ID}, {ActorUserID: user.ID}} {
+		for beforeID := int64(0); ; {
+			events, err := a.auditLog.AuditEvents(ctx, filter, beforeID, exportBatch)
+			if err != nil {
+				return userExport{}, err
+			}
+			for _, e := range events {
+				if seen[e.ID] {
+					continue
+				}
+				seen[e.ID] = true
+
+				export.AuditEntries = append(export.AuditEntries, exportedAuditEvent{
+					ID:           e.ID,
+					Action:       e.Action,
+					ActorUserID:  e.ActorUserID,
+					TargetUserID: e.TargetUserID,
+					Reason:       e.Reason,
+					Details:      e.Details,
+					CreatedAt:    e.CreatedAt,
+				})
+			}
+			if len(events) < exportBatch {
+				break
+			}
+			beforeID = events[len(events)-1].ID
+		}
+	}
+
+	return export, nil
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+
+	return &t
+}
@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/clientinfo"
+	"sso/internal/lib/logger/sl"
+	"strings"
+	"time"
+)
+
+// LockoutPolicy locks the account for Duration after Threshold consecutive
+// failed logins. Zero Threshold disables lockout.
+type LockoutPolicy struct {
+	Threshold int
+	Duration  time.Duration
+}
+
+// LockedError is returned on login while the account is locked.
+type LockedError struct {
+	Until time.Time
+}
+
+func (e *LockedError) Error() string {
+	return ErrAccountLocked.Error()
+}
+
+func (e *LockedError) Is(target error) bool {
+	return target == ErrAccountLocked
+}
+
+// UnlockUser lifts the lock and resets the failed login counter of the user.
+func (a *Auth) UnlockUser(ctx context.Context, userID int64) error {
+	const op = "Auth.UnlockUser"
+
+	log := a.logger(ctx).With(slog.String("op", op), slog.Int64("uid", userID))
+	log.Info("attempting to unlock user")
+
+	user, err := a.usrProvider.UserByID(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, userErr(err))
+	}
+	if !inCallerOrg(ctx, user) {
+		return fmt.Errorf("%s: %w", op, ErrUserNotFound)
+	}
+
+	if err := a.lockoutStore.ResetFailedLogins(ctx, userID); err != nil {
+		log.Error("failed to unlock user", sl.Err(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	a.audit(ctx, models.AuditEvent{Action: models.AuditUserUnlocked, TargetUserID: userID})
+
+	log.Info("user unlocked")
+
+	return nil
+}
+
+// checkLocked returns LockedError if the account is locked.
+func (a *Auth) checkLocked(ctx context.Context, userID int64) error {
+	if a.lockout.Threshold <= 0 {
+		return nil
+	}
+
+	until, err := a.lockoutStore.LockedUntil(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if until.After(time.Now()) {
+		return &LockedError{Until: until}
+	}
+
+	return nil
+}
+
+// countsFailedLogins reports whether failed logins are needed by lockout or captcha.
+func (a *Auth) countsFailedLogins() bool {
+	return a.lockout.Threshold > 0 || a.captcha.enabled()
+}
+
+// failLogin counts the failed login to the app, returning LockedError if it
+// locked the account. The lock is sent to webhooks of the app as EventUserLocked.
+func (a *Auth) failLogin(ctx context.Context, userID int64, appID int) error {
+	if !a.countsFailedLogins() {
+		return nil
+	}
+
+	until := time.Now().Add(a.lockout.Duration)
+
+	// Нулевой порог только считает неудачи для капчи, не блокируя аккаунт
+	locked, err := a.lockoutStore.FailLogin(ctx, userID, max(a.lockout.Threshold, 0), until)
+	if err != nil {
+		return err
+	}
+
+	if locked {
+		a.logger(ctx).Warn("account locked after failed logins", slog.Int64("uid", userID), slog.Time("until", until))
+
+		a.enqueueEvent(ctx, models.EventUserLocked, models.EventUser{
+			UserID:      userID,
+			AppID:       appID,
+			IP:          clientinfo.FromContext(ctx).IP,
+			LockedUntil: &until,
+		})
+
+		return &LockedError{Until: until}
+	}
+
+	return nil
+}
+
+// failUnknownLogin answers the password login of no user as checkPassword
+// answers a wrong password of a user: the lock and captcha are checked, a
+// dummy hash is compared so that the answer takes as long, and the failure is
+// counted by the login. Returns ErrUserNotFound unless locked or refused.
+func (a *Auth) failUnknownLogin(ctx context.Context, login string, password string, appID int) error {
+	key := hashCode(strings.ToLower(login))
+
+	if a.countsFailedLogins() {
+		failures, lockedUntil, err := a.lockoutStore.UnknownLoginFailures(ctx, key)
+		if err != nil {
+			a.logger(ctx).Error("failed to get failed logins", sl.Err(err))
+
+			return err
+		}
+
+		if a.lockout.Threshold > 0 && lockedUntil.After(time.Now()) {
+			a.logger(ctx).Info("unknown login is locked")
+			a.recordLogin(ctx, 0, appID, login, loginMethodPassword, models.LoginLocked)
+
+			return &LockedError{Until: lockedUntil}
+		}
+
+		if a.captcha.enabled() && failures >= a.captcha.LoginAfter {
+			if err := a.verifyCaptcha(ctx); err != nil {
+				if !errors.Is(err, ErrCaptchaRequired) {
+					a.logger(ctx).Error("failed to check captcha", sl.Err(err))
+				}
+
+				return err
+			}
+		}
+	}
+
+	// Результат не важен, сравнение нужно только ради времени ответа
+	_ = a.passwords.Compare(a.dummyHash(), password)
+
+	a.recordLogin(ctx, 0, appID, login, loginMethodPassword, models.LoginInvalidCredentials)
+
+	if a.countsFailedLogins() {
+		until := time.Now().Add(a.lockout.Duration)
+
+		locked, err := a.lockoutStore.FailUnknownLogin(ctx, key, max(a.lockout.Threshold, 0), until)
+		if err != nil {
+			a.logger(ctx).Error("failed to count failed login", sl.Err(err))
+		}
+
+		if locked {
+			return &LockedError{Until: until}
+		}
+	}
+
+	return ErrUserNotFound
+}
+
+// succeedLogin resets the failed login counter.
+func (a *Auth) succeedLogin(ctx context.Context, userID int64) {
+	if !a.countsFailedLogins() {
+		return
+	}
+
+	if err := a.lockoutStore.ResetFailedLogins(ctx, userID); err != nil {
+		a.logger(ctx).Warn("failed to reset failed logins", sl.Err(err))
+	}
+}
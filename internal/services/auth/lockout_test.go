@@ -0,0 +1,138 @@
+package auth_test
+
+import (
+	"context"
+	"errors"
+	"sso/internal/lib/captcha"
+	"sso/internal/lib/passhash"
+	"sso/internal/services/auth"
+	"sso/ssotest"
+	"testing"
+	"time"
+)
+
+type fakeCaptcha struct{}
+
+func (fakeCaptcha) Verify(_ context.Context, token string, _ string) error {
+	if token != "solved" {
+		return captcha.ErrRejected
+	}
+
+	return nil
+}
+
+// loginAnswer is what a client learns from a failed login.
+func loginAnswer(err error) string {
+	switch {
+	case errors.Is(err, auth.ErrAccountLocked):
+		return "locked"
+	case errors.Is(err, auth.ErrCaptchaRequired):
+		return "captcha"
+	case errors.Is(err, auth.ErrInvalidCredentials), errors.Is(err, auth.ErrUserNotFound):
+		return "invalid"
+	default:
+		return err.Error()
+	}
+}
+
+func TestLoginFailuresOfUnknownUsers(t *testing.T) {
+	tests := []struct {
+		name   string
+		opt    ssotest.Option
+		tokens []string
+		want   []string
+	}{
+		{
+			name: "lockout",
+			opt: func(o *auth.Options) {
+				o.Lockout = auth.LockoutPolicy{Threshold: 3, Duration: time.Minute}
+			},
+			tokens: []string{"", "", "", ""},
+			want:   []string{"invalid", "invalid", "locked", "locked"},
+		},
+		{
+			name: "captcha",
+			opt: func(o *auth.Options) {
+				o.Captcha = auth.CaptchaPolicy{Verifier: fakeCaptcha{}, LoginAfter: 2}
+			},
+			tokens: []string{"", "", "", "solved"},
+			want:   []string{"invalid", "invalid", "captcha", "invalid"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := ssotest.NewServer(t, tt.opt)
+
+			hasher, err := passhash.New(passhash.Bcrypt, passhash.Argon2Params{}, nil)
+			if err != nil {
+				t.Fatalf("passhash.New() error = %v", err)
+			}
+			hash, err := hasher.Hash("correct-password")
+			if err != nil {
+				t.Fatalf("Hash() error = %v", err)
+			}
+			if _, err := srv.Storage.SaveUser(context.Background(), "user@example.com", hash, "user"); err != nil {
+				t.Fatalf("SaveUser() error = %v", err)
+			}
+
+			for _, login := range []string{"user@example.com", "nobody@example.com"} {
+				for i, token := range tt.tokens {
+					ctx := captcha.WithToken(context.Background(), token)
+
+					_, _, err := srv.Auth.Login(ctx, login, "wrong-password", ssotest.AppID)
+					if got := loginAnswer(err); got != tt.want[i] {
+						t.Errorf("%s attempt %d: answer = %q, want %q", login, i+1, got, tt.want[i])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestUnlockUser(t *testing.T) {
+	srv := ssotest.NewServer(t, func(o *auth.Options) {
+		o.Lockout = auth.LockoutPolicy{Threshold: 2, Duration: time.Hour}
+	})
+
+	hasher, err := passhash.New(passhash.Bcrypt, passhash.Argon2Params{}, nil)
+	if err != nil {
+		t.Fatalf("passhash.New() error = %v", err)
+	}
+	hash, err := hasher.Hash("correct-password")
+	if err != nil {
+		t.Fatalf("Hash() error = %v", err)
+	}
+	userID, err := srv.Storage.SaveUser(context.Background(), "user@example.com", hash, "user")
+	if err != nil {
+		t.Fatalf("SaveUser() error = %v", err)
+	}
+
+	for range 2 {
+		_, _, _ = srv.Auth.Login(context.Background(), "user@example.com", "wrong-password", ssotest.AppID)
+	}
+	if _, _, err := srv.Auth.Login(context.Background(), "user@example.com", "correct-password", ssotest.AppID); !errors.Is(err, auth.ErrAccountLocked) {
+		t.Fatalf("Login() while locked error = %v, want %v", err, auth.ErrAccountLocked)
+	}
+
+	tests := []struct {
+		name    string
+		userID  int64
+		wantErr error
+	}{
+		{name: "locked user", userID: userID},
+		{name: "unknown user", userID: userID + 100, wantErr: auth.ErrUserNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := srv.Auth.UnlockUser(context.Background(), tt.userID); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("UnlockUser() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, _, err := srv.Auth.Login(context.Background(), "user@example.com", "correct-password", ssotest.AppID); err != nil {
+		t.Errorf("Login() after unlock error = %v", err)
+	}
+}
@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/clientinfo"
+	"sso/internal/lib/logger/sl"
+)
+
+// Login methods recorded in the history besides MFAMethod* of the second step.
+const (
+	loginMethodPassword  = "password"
+	loginMethodPhoneCode = "phone_code"
+	loginMethodMagicLink = "magic_link"
+	loginMethodPasskey   = "passkey"
+	loginMethodOAuth     = "oauth"
+)
+
+// GetLoginHistory returns login attempts of the user, newest first. pageToken
+// is empty for the first page and next of the previous one after that;
+// next is empty on the last page. limit is capped at 100.
+func (a *Auth) GetLoginHistory(ctx context.Context, userID int64, pageToken string, limit int) (attempts []models.LoginAttempt, next string, err error) {
+	const op = "Auth.GetLoginHistory"
+
+	beforeID, err := parsePageToken(pageToken)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	size := pageSize(limit)
+
+	attempts, err = a.loginHistory.LoginAttempts(ctx, userID, beforeID, size+1)
+	if err != nil {
+		a.logger(ctx).Error("failed to get login history", slog.String("op", op), sl.Err(err))
+
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if len(attempts) > size {
+		attempts = attempts[:size]
+		next = pageTokenAfter(attempts[size-1].ID)
+	}
+
+	return attempts, next, nil
+}
+
+// recordLogin adds the attempt to the login history with the client it comes
+// from. Failing to record doesn't fail the login. Wrong passwords and codes of
+// known users are sent to webhooks of the app as EventUserLoginFailed.
+func (a *Auth) recordLogin(ctx context.Context, userID int64, appID int, login string, method string, result string) {
+	info := clientinfo.FromContext(ctx)
+
+	err := a.loginHistory.SaveLoginAttempt(ctx, models.LoginAttempt{
+		UserID:    userID,
+		AppID:     appID,
+		Login:     login,
+		Method:    method,
+		Result:    result,
+		IP:        info.IP,
+		UserAgent: info.UserAgent,
+	})
+	if err != nil {
+		a.logger(ctx).Warn("failed to record login attempt", sl.Err(err))
+	}
+
+	if userID != 0 && (result == models.LoginInvalidCredentials || result == models.LoginInvalidCode) {
+		a.enqueueEvent(ctx, models.EventUserLoginFailed, models.EventUser{UserID: userID, AppID: appID, IP: info.IP})
+	}
+}
+
+// recordFailedLogin records err if it's a failed check of credentials or a code.
+func (a *Auth) recordFailedLogin(ctx context.Context, userID int64, appID int, login string, method string, err error) {
+	switch {
+	case errors.Is(err, ErrInvalidCredentials), errors.Is(err, ErrUserNotFound):
+		a.recordLogin(ctx, userID, appID, login, method, models.LoginInvalidCredentials)
+	case errors.Is(err, ErrInvalidCode):
+		a.recordLogin(ctx, userID, appID, login, method, models.LoginInvalidCode)
+	case errors.Is(err, ErrMFARequired):
+		a.recordLogin(ctx, userID, appID, login, method, models.LoginMFARequired)
+	}
+}
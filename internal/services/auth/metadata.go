@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sso/internal/lib/logger/sl"
+)
+
+// maxMetadataSize is the largest metadata object an app may attach to a user.
+const maxMetadataSize = 16 << 10
+
+// GetUserMetadata returns the custom attributes the app attached to the user
+// as a JSON object, "{}" if there are none.
+func (a *Auth) GetUserMetadata(ctx context.Context, userID int64, appID int) (json.RawMessage, error) {
+	const op = "Auth.GetUserMetadata"
+
+	log := a.logger(ctx).With(slog.String("op", op), slog.Int64("uid", userID), slog.Int("app_id", appID))
+
+	if _, err := a.appProvider.App(ctx, appID); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, appErr(err))
+	}
+
+	if err := a.checkUserOrg(ctx, userID); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	data, err := a.usrProvider.UserMetadata(ctx, userID, appID)
+	if err != nil {
+		log.Error("failed to get user metadata", sl.Err(err))
+
+		return nil, fmt.Errorf("%s: %w", op, userErr(err))
+	}
+
+	return data, nil
+}
+
+// SetUserMetadata replaces the custom attributes the app attached to the user.
+// data must be a JSON object; an empty object or null removes them.
+func (a *Auth) SetUserMetadata(ctx context.Context, userID int64, appID int, data json.RawMessage) error {
+	const op = "Auth.SetUserMetadata"
+
+	log := a.logger(ctx).With(slog.String("op", op), slog.Int64("uid", userID), slog.Int("app_id", appID))
+	log.Info("attempting to set user metadata")
+
+	data, err := normalizeMetadata(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err := a.appProvider.App(ctx, appID); err != nil {
+		return fmt.Errorf("%s: %w", op, appErr(err))
+	}
+
+	if err := a.checkUserOrg(ctx, userID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.usrSaver.SetUserMetadata(ctx, userID, appID, data); err != nil {
+		log.Error("failed to set user metadata", sl.Err(err))
+
+		return fmt.Errorf("%s: %w", op, userErr(err))
+	}
+
+	log.Info("user metadata set")
+
+	return nil
+}
+
+// normalizeMetadata checks that data is a JSON object within the size limit
+// and returns it compacted, or nil if it's empty.
+func normalizeMetadata(data json.RawMessage) (json.RawMessage, error) {
+	if len(data) > maxMetadataSize {
+		return nil, ErrInvalidMetadata
+	}
+
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || bytes.Equal(data, []byte("null")) {
+		return nil, nil
+	}
+
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(data, &obj); err != nil || obj == nil {
+		return nil, ErrInvalidMetadata
+	}
+	if len(obj) == 0 {
+		return nil, nil
+	}
+
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, data); err != nil {
+		return nil, ErrInvalidMetadata
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,122 @@
+package auth_test
+
+import (
+	"context"
+	"encoding/base32"
+	"errors"
+	"sso/internal/lib/totp"
+	"sso/internal/services/auth"
+	"sso/ssotest"
+	"testing"
+	"time"
+)
+
+// enrollTOTP enables TOTP for a new user and returns the decoded secret.
+func enrollTOTP(t *testing.T, srv *ssotest.Server, email string) []byte {
+	t.Helper()
+
+	ctx := context.Background()
+
+	userID, err := srv.Auth.RegisterNewUser(ctx, email, "correct-password", "", "")
+	if err != nil {
+		t.Fatalf("RegisterNewUser() error = %v", err)
+	}
+
+	encoded, _, err := srv.Auth.EnrollTOTP(ctx, userID)
+	if err != nil {
+		t.Fatalf("EnrollTOTP() error = %v", err)
+	}
+
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(encoded)
+	if err != nil {
+		t.Fatalf("decode secret: %v", err)
+	}
+
+	// Шаг раньше текущего, чтобы текущий код остался свободным для входа
+	if _, err := srv.Auth.ConfirmTOTP(ctx, userID, totp.Code(secret, totp.Step(time.Now())-1)); err != nil {
+		t.Fatalf("ConfirmTOTP() error = %v", err)
+	}
+
+	return secret
+}
+
+// mfaTicket logs in with the password and returns the ticket for the second step.
+func mfaTicket(t *testing.T, srv *ssotest.Server, email string) string {
+	t.Helper()
+
+	_, _, err := srv.Auth.Login(context.Background(), email, "correct-password", ssotest.AppID)
+
+	var mfaErr *auth.MFARequiredError
+	if !errors.As(err, &mfaErr) {
+		t.Fatalf("Login() error = %v, want MFARequiredError", err)
+	}
+
+	return mfaErr.Ticket
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	ctx := context.Background()
+	srv := ssotest.NewServer(t)
+
+	secret := enrollTOTP(t, srv, "user@example.com")
+	step := totp.Step(time.Now())
+
+	t.Run("confirm code reused", func(t *testing.T) {
+		_, _, err := srv.Auth.VerifyTOTP(ctx, mfaTicket(t, srv, "user@example.com"), totp.Code(secret, step-1))
+		if !errors.Is(err, auth.ErrInvalidCode) {
+			t.Errorf("VerifyTOTP() error = %v, want %v", err, auth.ErrInvalidCode)
+		}
+	})
+
+	t.Run("too old", func(t *testing.T) {
+		_, _, err := srv.Auth.VerifyTOTP(ctx, mfaTicket(t, srv, "user@example.com"), totp.Code(secret, step-3))
+		if !errors.Is(err, auth.ErrInvalidCode) {
+			t.Errorf("VerifyTOTP() error = %v, want %v", err, auth.ErrInvalidCode)
+		}
+	})
+
+	t.Run("wrong code spends ticket", func(t *testing.T) {
+		ticket := mfaTicket(t, srv, "user@example.com")
+
+		if _, _, err := srv.Auth.VerifyTOTP(ctx, ticket, "000000"); !errors.Is(err, auth.ErrInvalidCode) {
+			t.Fatalf("VerifyTOTP() error = %v, want %v", err, auth.ErrInvalidCode)
+		}
+		// Перебор кодов по одному билету невозможен
+		if _, _, err := srv.Auth.VerifyTOTP(ctx, ticket, totp.Code(secret, step+1)); err == nil {
+			t.Error("VerifyTOTP() with a spent ticket: error = nil")
+		}
+	})
+
+	code := totp.Code(secret, step+1)
+
+	t.Run("valid", func(t *testing.T) {
+		token, refreshToken, err := srv.Auth.VerifyTOTP(ctx, mfaTicket(t, srv, "user@example.com"), code)
+		if err != nil {
+			t.Fatalf("VerifyTOTP() error = %v", err)
+		}
+		if token == "" || refreshToken == "" {
+			t.Errorf("VerifyTOTP() = %q, %q, want both tokens", token, refreshToken)
+		}
+	})
+
+	t.Run("replay", func(t *testing.T) {
+		_, _, err := srv.Auth.VerifyTOTP(ctx, mfaTicket(t, srv, "user@example.com"), code)
+		if !errors.Is(err, auth.ErrInvalidCode) {
+			t.Errorf("VerifyTOTP() error = %v, want %v", err, auth.ErrInvalidCode)
+		}
+	})
+}
+
+func TestVerifyTOTPOtherUser(t *testing.T) {
+	ctx := context.Background()
+	srv := ssotest.NewServer(t)
+
+	enrollTOTP(t, srv, "ann@example.com")
+	other := enrollTOTP(t, srv, "bob@example.com")
+
+	// Код из приложения другого пользователя не подходит
+	_, _, err := srv.Auth.VerifyTOTP(ctx, mfaTicket(t, srv, "ann@example.com"), totp.Code(other, totp.Step(time.Now())))
+	if !errors.Is(err, auth.ErrInvalidCode) {
+		t.Errorf("VerifyTOTP() error = %v, want %v", err, auth.ErrInvalidCode)
+	}
+}
@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"sso/internal/domain/models"
+	"sso/internal/storage"
+)
+
+// AuthenticateCertificate returns the app a verified client certificate
+// belongs to. The identity of the certificate is its first URI SAN
+// registered for an app, e.g. a SPIFFE ID, or else its subject common name.
+func (a *Auth) AuthenticateCertificate(ctx context.Context, cert *x509.Certificate) (models.App, error) {
+	const op = "Auth.AuthenticateCertificate"
+
+	for _, subject := range certSubjects(cert) {
+		app, err := a.appProvider.AppByCertSubject(ctx, subject)
+		if err != nil {
+			if errors.Is(err, storage.ErrAppNotFound) {
+				continue
+			}
+
+			return models.App{}, fmt.Errorf("%s: %w", op, err)
+		}
+
+		return app, nil
+	}
+
+	return models.App{}, fmt.Errorf("%s: %w", op, ErrUnknownCertificate)
+}
+
+// certSubjects lists identities of the certificate in the order of precedence.
+func certSubjects(cert *x509.Certificate) []string {
+	var subjects []string
+	for _, uri := range cert.URIs {
+		subjects = append(subjects, uri.String())
+	}
+
+	if cert.Subject.CommonName != "" {
+		subjects = append(subjects, cert.Subject.CommonName)
+	}
+
+	return subjects
+}
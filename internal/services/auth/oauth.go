@@ -0,0 +1,363 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/storage"
+	"strings"
+	"time"
+)
+
+const authorizationCodeTTL = time.Minute
+
+// AuthorizeClient checks the authorization request before the user is asked
+// to log in: the app must exist and have the redirect URI registered.
+func (a *Auth) AuthorizeClient(ctx context.Context, req models.AuthorizationRequest) error {
+	const op = "Auth.AuthorizeClient"
+
+	if err := a.checkAuthorizationRequest(ctx, req); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// Authorize logs the user in on the authorization endpoint and returns an
+// authorization code for the app. Users with two-factor authentication get
+// MFARequiredError, the code is then returned by AuthorizeMFA.
+func (a *Auth) Authorize(ctx context.Context, login string, password string, req models.AuthorizationRequest) (string, error) {
+	const op = "Auth.Authorize"
+
+	log := a.logger(ctx).With(
+		slog.String("op", op),
+		slog.String("username", login),
+		slog.Int("app_id", req.AppID),
+	)
+	log.Info("attempting to authorize app")
+
+	if err := a.checkAuthorizationRequest(ctx, req); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	user, err := a.checkPassword(ctx, login, password, req.AppID)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.requireMFA(ctx, user, req.AppID); err != nil {
+		a.recordFailedLogin(ctx, user.ID, req.AppID, login, loginMethodPassword, err)
+
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	code, err := a.issueAuthorizationCode(ctx, user.ID, req)
+	if err != nil {
+		log.Error("failed to issue authorization code", sl.Err(err))
+
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("app authorized", slog.Int64("uid", user.ID))
+
+	return code, nil
+}
+
+// AuthorizeMFA is the second step of Authorize: it spends the ticket from
+// MFARequiredError and checks the code of one of its methods.
+func (a *Auth) AuthorizeMFA(ctx context.Context, ticket string, method string, code string, req models.AuthorizationRequest) (string, error) {
+	const op = "Auth.AuthorizeMFA"
+
+	log := a.logger(ctx).With(slog.String("op", op), slog.String("method", method))
+	log.Info("attempting to verify second factor")
+
+	userID, appID, err := a.useMFATicket(ctx, ticket)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Тикет выдан для входа в другое приложение
+	if appID != req.AppID {
+		return "", fmt.Errorf("%s: %w", op, ErrInvalidCode)
+	}
+
+	if err := a.checkAuthorizationRequest(ctx, req); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	user, err := a.usrProvider.UserByID(ctx, userID)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, userErr(err))
+	}
+
+	switch method {
+	case MFAMethodTOTP:
+		err = a.checkTOTP(ctx, user.ID, code)
+	case MFAMethodSMS:
+		if user.Phone == "" {
+			err = ErrInvalidCode
+		} else {
+			err = a.checkOTP(ctx, user.Phone, purposeMFASMS, code)
+		}
+	case MFAMethodRecovery:
+		err = a.mfaStore.UseRecoveryCode(ctx, user.ID, hashCode(normalizeRecoveryCode(code)))
+		if errors.Is(err, storage.ErrRecoveryCodeNotFound) {
+			err = ErrInvalidCode
+		}
+	default:
+		err = ErrInvalidCode
+	}
+	if err != nil {
+		a.recordFailedLogin(ctx, user.ID, req.AppID, "", method, err)
+
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	authCode, err := a.issueAuthorizationCode(ctx, user.ID, req)
+	if err != nil {
+		log.Error("failed to issue authorization code", sl.Err(err))
+
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("app authorized", slog.Int64("uid", user.ID))
+
+	return authCode, nil
+}
+
+// ExchangeAuthorizationCode implements the authorization_code grant of the token
+// endpoint. The app authenticates with its secret, the code can be used once
+// and only with the redirect URI it was issued for. codeVerifier is required
+// if the code was requested with PKCE. idToken is issued if OpenID Connect is
+// enabled and the "openid" scope was requested.
+func (a *Auth) ExchangeAuthorizationCode(
+	ctx context.Context,
+	appID int,
+	clientSecret string,
+	code string,
+	redirectURI string,
+	codeVerifier string,
+) (token string, refreshToken string, idToken string, err error) {
+	const op = "Auth.ExchangeAuthorizationCode"
+
+	log := a.logger(ctx).With(slog.String("op", op), slog.Int("app_id", appID))
+	log.Info("attempting to exchange authorization code")
+
+	app, err := a.authenticateClient(ctx, appID, clientSecret)
+	if err != nil {
+		return "", "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	ac, err := a.oauthStore.ConsumeAuthorizationCode(ctx, hashCode(code))
+	if err != nil {
+		if errors.Is(err, storage.ErrTokenNotFound) {
+			return "", "", "", fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+		}
+
+		return "", "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if ac.AppID != appID || ac.RedirectURI != redirectURI {
+		log.Warn("authorization code presented with another app or redirect uri")
+
+		return "", "", "", fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+	}
+
+	if !verifyCodeChallenge(ac.CodeChallenge, ac.CodeChallengeMethod, codeVerifier) {
+		log.Warn("invalid code verifier")
+
+		return "", "", "", fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+	}
+
+	user, err := a.usrProvider.UserByID(ctx, ac.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return "", "", "", fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+		}
+
+		return "", "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	token, refreshToken, err = a.completeLogin(ctx, user, appID, loginMethodOAuth)
+	if err != nil {
+		return "", "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if a.oidcIssuer != "" && slices.Contains(strings.Fields(ac.Scope), "openid") {
+		idToken, err = jwt.NewIDToken(user, app, a.oidcIssuer, ac.Nonce, a.appTTL(app, user.Role), a.signingKeys[app.ID])
+		if err != nil {
+			log.Error("failed to generate id token", sl.Err(err))
+
+			return "", "", "", fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	log.Info("authorization code exchanged", slog.Int64("uid", user.ID))
+
+	return token, refreshToken, idToken, nil
+}
+
+// RefreshForClient implements the refresh_token grant of the token endpoint:
+// like Refresh, but only the app the token was issued to can use it.
+func (a *Auth) RefreshForClient(ctx context.Context, appID int, clientSecret string, refreshToken string) (token string, newRefreshToken string, err error) {
+	const op = "Auth.RefreshForClient"
+
+	if _, err := a.authenticateClient(ctx, appID, clientSecret); err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	token, newRefreshToken, err = a.refresh(ctx, refreshToken, appID)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return token, newRefreshToken, nil
+}
+
+// IssueServiceToken implements the client_credentials grant: the app exchanges
+// its secret for a token of its own, with no user, for calls between services.
+// Requested scopes must be allowed for the app; empty scope grants all of them.
+// Public apps can't keep the secret and get ErrUnauthorizedClient.
+func (a *Auth) IssueServiceToken(ctx context.Context, appID int, clientSecret string, scope string) (token string, grantedScope string, err error) {
+	const op = "Auth.IssueServiceToken"
+
+	log := a.logger(ctx).With(slog.String("op", op), slog.Int("app_id", appID))
+	log.Info("attempting to issue service token")
+
+	app, err := a.authenticateClient(ctx, appID, clientSecret)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if app.Public {
+		return "", "", fmt.Errorf("%s: %w", op, ErrUnauthorizedClient)
+	}
+
+	scopes := strings.Fields(scope)
+	if len(scopes) == 0 {
+		scopes = app.Scopes
+	}
+
+	for _, s := range scopes {
+		if !slices.Contains(app.Scopes, s) {
+			log.Warn("scope is not allowed", slog.String("scope", s))
+
+			return "", "", fmt.Errorf("%s: %w", op, ErrInvalidScope)
+		}
+	}
+
+	token, err = jwt.NewServiceToken(app, scopes, a.serviceTokenTTL, a.signingKeys[app.ID], jwt.WithRegion(a.regions.Region))
+	if err != nil {
+		log.Error("failed to generate service token", sl.Err(err))
+
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("service token issued")
+
+	return token, strings.Join(scopes, " "), nil
+}
+
+// authenticateClient checks the app secret. Public apps have no secret and are
+// let through: their codes are protected by PKCE, refresh tokens by binding to the app.
+func (a *Auth) authenticateClient(ctx context.Context, appID int, clientSecret string) (models.App, error) {
+	app, err := a.appProvider.App(ctx, appID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return models.App{}, ErrInvalidClient
+		}
+
+		return models.App{}, err
+	}
+
+	if app.Public {
+		return app, nil
+	}
+
+	if subtle.ConstantTimeCompare([]byte(app.Secret), []byte(clientSecret)) != 1 {
+		a.logger(ctx).Warn("invalid client secret", slog.Int("app_id", appID))
+
+		return models.App{}, ErrInvalidClient
+	}
+
+	return app, nil
+}
+
+// checkAuthorizationRequest requires the redirect URI to exactly match one of
+// the registered ones and validates PKCE parameters, mandatory for public apps.
+// Public apps must use S256: a plain challenge is the verifier itself and
+// doesn't protect codes intercepted on the device.
+func (a *Auth) checkAuthorizationRequest(ctx context.Context, req models.AuthorizationRequest) error {
+	app, err := a.appProvider.App(ctx, req.AppID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return ErrInvalidClient
+		}
+
+		return err
+	}
+
+	if req.RedirectURI == "" || !slices.Contains(app.RedirectURIs, req.RedirectURI) {
+		return ErrInvalidRedirectURI
+	}
+
+	if app.Public && (req.CodeChallenge == "" || codeChallengeMethod(req) != PKCEMethodS256) {
+		return ErrPKCERequired
+	}
+
+	if req.CodeChallenge == "" {
+		return nil
+	}
+
+	return checkCodeChallenge(req.CodeChallenge, req.CodeChallengeMethod)
+}
+
+func (a *Auth) issueAuthorizationCode(ctx context.Context, userID int64, req models.AuthorizationRequest) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	code := base64.RawURLEncoding.EncodeToString(b)
+
+	err := a.oauthStore.SaveAuthorizationCode(ctx, models.AuthorizationCode{
+		Hash:                hashCode(code),
+		AppID:               req.AppID,
+		UserID:              userID,
+		RedirectURI:         req.RedirectURI,
+		Scope:               req.Scope,
+		Nonce:               req.Nonce,
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: codeChallengeMethod(req),
+		ExpiresAt:           time.Now().Add(authorizationCodeTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return code, nil
+}
+
+// UserInfo returns the owner of a valid access token, for the OpenID Connect userinfo endpoint.
+func (a *Auth) UserInfo(ctx context.Context, token string) (models.User, error) {
+	const op = "Auth.UserInfo"
+
+	claims, err := a.ValidateToken(ctx, token)
+	if err != nil {
+		return models.User{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	user, err := a.usrProvider.UserByID(ctx, claims.UserID)
+	if err != nil {
+		return models.User{}, fmt.Errorf("%s: %w", op, userErr(err))
+	}
+
+	return user, nil
+}
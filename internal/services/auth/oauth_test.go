@@ -0,0 +1,223 @@
+package auth_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"sso/internal/domain/models"
+	"sso/internal/services/auth"
+	"sso/ssotest"
+	"strings"
+	"testing"
+)
+
+const (
+	publicAppID  = 2
+	redirectURI  = "https://app.example.com/callback"
+	codeVerifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+)
+
+// s256 returns the S256 code challenge of the verifier.
+func s256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// newOAuthServer starts the service with a user, a confidential app (ssotest.AppID)
+// and a public app (publicAppID), both allowed to redirect to redirectURI.
+func newOAuthServer(t *testing.T) *ssotest.Server {
+	t.Helper()
+
+	srv := ssotest.NewServer(t)
+	srv.Storage.AddApp(models.App{ID: ssotest.AppID, Name: ssotest.AppName, Secret: ssotest.AppSecret, RedirectURIs: []string{redirectURI}})
+	srv.Storage.AddApp(models.App{ID: publicAppID, Name: "spa", Public: true, RedirectURIs: []string{redirectURI}})
+
+	if _, err := srv.Auth.RegisterNewUser(context.Background(), "user@example.com", "correct-password", "", ""); err != nil {
+		t.Fatalf("RegisterNewUser() error = %v", err)
+	}
+
+	return srv
+}
+
+func TestAuthorizePKCE(t *testing.T) {
+	ctx := context.Background()
+	srv := newOAuthServer(t)
+
+	tests := []struct {
+		name    string
+		req     models.AuthorizationRequest
+		wantErr error
+	}{
+		{
+			name: "confidential without pkce",
+			req:  models.AuthorizationRequest{AppID: ssotest.AppID, RedirectURI: redirectURI},
+		},
+		{
+			name: "confidential plain",
+			req:  models.AuthorizationRequest{AppID: ssotest.AppID, RedirectURI: redirectURI, CodeChallenge: codeVerifier, CodeChallengeMethod: auth.PKCEMethodPlain},
+		},
+		{
+			name: "public s256",
+			req:  models.AuthorizationRequest{AppID: publicAppID, RedirectURI: redirectURI, CodeChallenge: s256(codeVerifier), CodeChallengeMethod: auth.PKCEMethodS256},
+		},
+		{
+			name:    "public without pkce",
+			req:     models.AuthorizationRequest{AppID: publicAppID, RedirectURI: redirectURI},
+			wantErr: auth.ErrPKCERequired,
+		},
+		{
+			name:    "public plain",
+			req:     models.AuthorizationRequest{AppID: publicAppID, RedirectURI: redirectURI, CodeChallenge: codeVerifier, CodeChallengeMethod: auth.PKCEMethodPlain},
+			wantErr: auth.ErrPKCERequired,
+		},
+		{
+			// Без метода challenge считается plain
+			name:    "public default method",
+			req:     models.AuthorizationRequest{AppID: publicAppID, RedirectURI: redirectURI, CodeChallenge: codeVerifier},
+			wantErr: auth.ErrPKCERequired,
+		},
+		{
+			name:    "unknown method",
+			req:     models.AuthorizationRequest{AppID: ssotest.AppID, RedirectURI: redirectURI, CodeChallenge: codeVerifier, CodeChallengeMethod: "S512"},
+			wantErr: auth.ErrInvalidPKCE,
+		},
+		{
+			name:    "short challenge",
+			req:     models.AuthorizationRequest{AppID: publicAppID, RedirectURI: redirectURI, CodeChallenge: "short", CodeChallengeMethod: auth.PKCEMethodS256},
+			wantErr: auth.ErrInvalidPKCE,
+		},
+		{
+			name:    "unregistered redirect uri",
+			req:     models.AuthorizationRequest{AppID: publicAppID, RedirectURI: "https://evil.example/callback", CodeChallenge: s256(codeVerifier), CodeChallengeMethod: auth.PKCEMethodS256},
+			wantErr: auth.ErrInvalidRedirectURI,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := srv.Auth.AuthorizeClient(ctx, tt.req); !errors.Is(err, tt.wantErr) {
+				t.Errorf("AuthorizeClient() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExchangeAuthorizationCode(t *testing.T) {
+	ctx := context.Background()
+	srv := newOAuthServer(t)
+
+	public := models.AuthorizationRequest{
+		AppID:               publicAppID,
+		RedirectURI:         redirectURI,
+		CodeChallenge:       s256(codeVerifier),
+		CodeChallengeMethod: auth.PKCEMethodS256,
+	}
+	confidential := models.AuthorizationRequest{AppID: ssotest.AppID, RedirectURI: redirectURI}
+
+	tests := []struct {
+		name        string
+		req         models.AuthorizationRequest
+		appID       int
+		secret      string
+		redirectURI string
+		verifier    string
+		wantErr     error
+	}{
+		{name: "public", req: public, appID: publicAppID, redirectURI: redirectURI, verifier: codeVerifier},
+		{name: "confidential", req: confidential, appID: ssotest.AppID, secret: ssotest.AppSecret, redirectURI: redirectURI},
+		{
+			name: "wrong verifier", req: public, appID: publicAppID, redirectURI: redirectURI,
+			verifier: strings.Repeat("a", 43), wantErr: auth.ErrInvalidGrant,
+		},
+		{name: "no verifier", req: public, appID: publicAppID, redirectURI: redirectURI, wantErr: auth.ErrInvalidGrant},
+		{
+			// Код без PKCE нельзя обменять с verifier
+			name: "unexpected verifier", req: confidential, appID: ssotest.AppID, secret: ssotest.AppSecret,
+			redirectURI: redirectURI, verifier: codeVerifier, wantErr: auth.ErrInvalidGrant,
+		},
+		{
+			name: "wrong secret", req: confidential, appID: ssotest.AppID, secret: "wrong",
+			redirectURI: redirectURI, wantErr: auth.ErrInvalidClient,
+		},
+		{
+			name: "other app", req: confidential, appID: publicAppID,
+			redirectURI: redirectURI, wantErr: auth.ErrInvalidGrant,
+		},
+		{
+			name: "other redirect uri", req: public, appID: publicAppID,
+			redirectURI: "https://app.example.com/other", verifier: codeVerifier, wantErr: auth.ErrInvalidGrant,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := srv.Auth.Authorize(ctx, "user@example.com", "correct-password", tt.req)
+			if err != nil {
+				t.Fatalf("Authorize() error = %v", err)
+			}
+
+			token, _, _, err := srv.Auth.ExchangeAuthorizationCode(ctx, tt.appID, tt.secret, code, tt.redirectURI, tt.verifier)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ExchangeAuthorizationCode() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && token == "" {
+				t.Error("empty token")
+			}
+		})
+	}
+}
+
+func TestAuthorizationCodeConsumed(t *testing.T) {
+	ctx := context.Background()
+	srv := newOAuthServer(t)
+
+	req := models.AuthorizationRequest{
+		AppID:               publicAppID,
+		RedirectURI:         redirectURI,
+		CodeChallenge:       s256(codeVerifier),
+		CodeChallengeMethod: auth.PKCEMethodS256,
+	}
+
+	t.Run("used once", func(t *testing.T) {
+		code, err := srv.Auth.Authorize(ctx, "user@example.com", "correct-password", req)
+		if err != nil {
+			t.Fatalf("Authorize() error = %v", err)
+		}
+
+		if _, _, _, err := srv.Auth.ExchangeAuthorizationCode(ctx, publicAppID, "", code, redirectURI, codeVerifier); err != nil {
+			t.Fatalf("first exchange: error = %v", err)
+		}
+
+		_, _, _, err = srv.Auth.ExchangeAuthorizationCode(ctx, publicAppID, "", code, redirectURI, codeVerifier)
+		if !errors.Is(err, auth.ErrInvalidGrant) {
+			t.Errorf("second exchange: error = %v, want %v", err, auth.ErrInvalidGrant)
+		}
+	})
+
+	t.Run("spent by a failed exchange", func(t *testing.T) {
+		code, err := srv.Auth.Authorize(ctx, "user@example.com", "correct-password", req)
+		if err != nil {
+			t.Fatalf("Authorize() error = %v", err)
+		}
+
+		// Перехвативший код без verifier не может и потом обменять его правильно
+		_, _, _, err = srv.Auth.ExchangeAuthorizationCode(ctx, publicAppID, "", code, redirectURI, strings.Repeat("a", 43))
+		if !errors.Is(err, auth.ErrInvalidGrant) {
+			t.Fatalf("wrong verifier: error = %v, want %v", err, auth.ErrInvalidGrant)
+		}
+
+		_, _, _, err = srv.Auth.ExchangeAuthorizationCode(ctx, publicAppID, "", code, redirectURI, codeVerifier)
+		if !errors.Is(err, auth.ErrInvalidGrant) {
+			t.Errorf("right verifier after failure: error = %v, want %v", err, auth.ErrInvalidGrant)
+		}
+	})
+
+	t.Run("unknown code", func(t *testing.T) {
+		_, _, _, err := srv.Auth.ExchangeAuthorizationCode(ctx, publicAppID, "", "bogus", redirectURI, codeVerifier)
+		if !errors.Is(err, auth.ErrInvalidGrant) {
+			t.Errorf("error = %v, want %v", err, auth.ErrInvalidGrant)
+		}
+	})
+}
@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"sso/internal/domain/models"
+	"sso/internal/storage"
+	"time"
+)
+
+// issueOneTimeToken stores a new random token for purpose and subject and returns it.
+// Password reset, email verification, magic links and invitations all go through here.
+func (a *Auth) issueOneTimeToken(ctx context.Context, purpose string, subject string, ttl time.Duration) (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	err := a.tokenStore.SaveOneTimeToken(ctx, models.OneTimeToken{
+		Hash:      hashCode(token),
+		Purpose:   purpose,
+		Subject:   subject,
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// consumeOneTimeToken uses up the token and returns its subject. A token is
+// accepted at most once, even under concurrent requests; unknown, expired and
+// already used tokens give ErrInvalidCode.
+func (a *Auth) consumeOneTimeToken(ctx context.Context, purpose string, token string) (string, error) {
+	t, err := a.tokenStore.ConsumeOneTimeToken(ctx, hashCode(token), purpose)
+	if err != nil {
+		if errors.Is(err, storage.ErrTokenNotFound) {
+			return "", ErrInvalidCode
+		}
+
+		return "", err
+	}
+
+	return t.Subject, nil
+}
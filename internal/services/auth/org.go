@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/caller"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/storage"
+	"strconv"
+	"strings"
+)
+
+// CreateOrganization creates an organization and returns its id.
+// Only platform callers may manage organizations.
+func (a *Auth) CreateOrganization(ctx context.Context, name string) (int64, error) {
+	const op = "Auth.CreateOrganization"
+
+	log := a.logger(ctx).With(slog.String("op", op), slog.String("org", name))
+	log.Info("creating organization")
+
+	if callerOrg(ctx) != 0 {
+		return 0, fmt.Errorf("%s: %w", op, ErrOrgScoped)
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 128 {
+		return 0, fmt.Errorf("%s: %w", op, ErrInvalidOrg)
+	}
+
+	id, err := a.orgStore.SaveOrganization(ctx, models.Organization{Name: name})
+	if err != nil {
+		if errors.Is(err, storage.ErrOrgExists) {
+			return 0, fmt.Errorf("%s: %w", op, ErrOrgExists)
+		}
+
+		log.Error("failed to save organization", sl.Err(err))
+
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	a.audit(ctx, models.AuditEvent{
+		Action:  models.AuditOrgCreated,
+		Details: map[string]string{"org_id": strconv.FormatInt(id, 10), "org": name},
+	})
+
+	log.Info("organization created", slog.Int64("org_id", id))
+
+	return id, nil
+}
+
+// GetOrganization returns the organization. Callers scoped to an organization
+// only see their own.
+func (a *Auth) GetOrganization(ctx context.Context, id int64) (models.Organization, error) {
+	const op = "Auth.GetOrganization"
+
+	if orgID := callerOrg(ctx); orgID != 0 && orgID != id {
+		return models.Organization{}, fmt.Errorf("%s: %w", op, ErrOrgNotFound)
+	}
+
+	org, err := a.orgStore.Organization(ctx, id)
+	if err != nil {
+		return models.Organization{}, fmt.Errorf("%s: %w", op, orgErr(err))
+	}
+
+	return org, nil
+}
+
+// ListOrganizations returns all organizations ordered by name.
+func (a *Auth) ListOrganizations(ctx context.Context) ([]models.Organization, error) {
+	const op = "Auth.ListOrganizations"
+
+	if callerOrg(ctx) != 0 {
+		return nil, fmt.Errorf("%s: %w", op, ErrOrgScoped)
+	}
+
+	orgs, err := a.orgStore.Organizations(ctx)
+	if err != nil {
+		a.logger(ctx).Error("failed to list organizations", slog.String("op", op), sl.Err(err))
+
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return orgs, nil
+}
+
+// SetUserOrganization moves the user to the organization; zero orgID moves
+// the user back to the platform. Tokens already issued keep the old org_id.
+func (a *Auth) SetUserOrganization(ctx context.Context, userID int64, orgID int64) error {
+	const op = "Auth.SetUserOrganization"
+
+	log := a.logger(ctx).With(slog.String("op", op), slog.Int64("uid", userID), slog.Int64("org_id", orgID))
+	log.Info("setting user organization")
+
+	if callerOrg(ctx) != 0 {
+		return fmt.Errorf("%s: %w", op, ErrOrgScoped)
+	}
+
+	if err := a.orgStore.SetUserOrg(ctx, userID, orgID); err != nil {
+		if !errors.Is(err, storage.ErrUserNotFound) && !errors.Is(err, storage.ErrOrgNotFound) {
+			log.Error("failed to set user organization", sl.Err(err))
+		}
+
+		return fmt.Errorf("%s: %w", op, userErr(orgErr(err)))
+	}
+
+	a.access.Delete(userID)
+
+	a.audit(ctx, models.AuditEvent{
+		Action:       models.AuditUserOrgChanged,
+		TargetUserID: userID,
+		Details:      map[string]string{"org_id": strconv.FormatInt(orgID, 10)},
+	})
+
+	return nil
+}
+
+// callerOrg returns the organization the caller is scoped to, zero for platform callers.
+func callerOrg(ctx context.Context) int64 {
+	c, _ := caller.FromContext(ctx)
+
+	return c.OrgID
+}
+
+// inCallerOrg reports whether the caller may manage the user.
+func inCallerOrg(ctx context.Context, user models.User) bool {
+	orgID := callerOrg(ctx)
+
+	return orgID == 0 || orgID == user.OrgID
+}
+
+// checkUserOrg returns ErrUserNotFound if the caller is scoped to an
+// organization the user is not in, so other tenants' users look nonexistent.
+func (a *Auth) checkUserOrg(ctx context.Context, userID int64) error {
+	if callerOrg(ctx) == 0 {
+		return nil
+	}
+
+	user, err := a.usrProvider.UserByID(ctx, userID)
+	if err != nil {
+		return userErr(err)
+	}
+	if !inCallerOrg(ctx, user) {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// orgErr maps storage "not found" to the service error.
+func orgErr(err error) error {
+	if errors.Is(err, storage.ErrOrgNotFound) {
+		return ErrOrgNotFound
+	}
+
+	return err
+}
@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"sso/internal/domain/models"
+	"strconv"
+	"time"
+)
+
+// Lists are paged by id, newest first: storage is asked for one item more than
+// the page size to know whether there is a next page.
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// parsePageToken returns the id the list continues below, zero for the first page.
+func parsePageToken(token string) (int64, error) {
+	if token == "" {
+		return 0, nil
+	}
+
+	id, err := strconv.ParseInt(token, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, ErrInvalidPageToken
+	}
+
+	return id, nil
+}
+
+func pageSize(size int) int {
+	if size <= 0 {
+		return defaultPageSize
+	}
+
+	return min(size, maxPageSize)
+}
+
+// pageTokenAfter continues the list below the item with id.
+func pageTokenAfter(id int64) string {
+	return strconv.FormatInt(id, 10)
+}
+
+// Users are listed in any order of models.UserSort, so their pages continue
+// after the sort value and id of the last user instead.
+type userPageCursor struct {
+	Sort  models.UserSort `json:"s"`
+	Value string          `json:"v,omitempty"`
+	Null  bool            `json:"n,omitempty"`
+	ID    int64           `json:"id"`
+}
+
+// userPageToken continues the list in the given order after the user.
+func userPageToken(user models.User, sort models.UserSort) string {
+	c := userPageCursor{Sort: sort, ID: user.ID}
+
+	switch sort.Field {
+	case models.UserSortEmail:
+		c.Value = user.Email
+	case models.UserSortRole:
+		c.Value = user.Role
+	case models.UserSortCreatedAt:
+		c.Value = user.CreatedAt.Format(time.RFC3339Nano)
+	case models.UserSortLastLoginAt:
+		if user.LastLoginAt.IsZero() {
+			c.Null = true
+		} else {
+			c.Value = user.LastLoginAt.Format(time.RFC3339Nano)
+		}
+	}
+
+	b, _ := json.Marshal(c)
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// parseUserPageToken returns the cursor the list continues after, zero for
+// the first page. Tokens are only valid for the order they were issued in.
+func parseUserPageToken(token string, sort models.UserSort) (models.UserCursor, error) {
+	if token == "" {
+		return models.UserCursor{}, nil
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return models.UserCursor{}, ErrInvalidPageToken
+	}
+
+	var c userPageCursor
+	if err := json.Unmarshal(b, &c); err != nil || c.Sort != sort || c.ID <= 0 {
+		return models.UserCursor{}, ErrInvalidPageToken
+	}
+
+	return models.UserCursor{Value: c.Value, Null: c.Null, ID: c.ID}, nil
+}
@@ -0,0 +1,189 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/webauthn"
+	"sso/internal/storage"
+	"strconv"
+	"time"
+)
+
+const (
+	passkeyChallengeTTL    = 5 * time.Minute
+	purposePasskeyRegister = "passkey_register"
+	purposePasskeyLogin    = "passkey_login"
+)
+
+// BeginRegisterPasskey returns a challenge for navigator.credentials.create()
+// adding a passkey to the account.
+func (a *Auth) BeginRegisterPasskey(ctx context.Context, userID int64) (challenge string, err error) {
+	const op = "Auth.BeginRegisterPasskey"
+
+	if a.webauthn.RPID == "" {
+		return "", fmt.Errorf("%s: %w", op, ErrPasskeysDisabled)
+	}
+
+	user, err := a.usrProvider.UserByID(ctx, userID)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, userErr(err))
+	}
+
+	challenge, err = a.issueOneTimeToken(ctx, purposePasskeyRegister, strconv.FormatInt(user.ID, 10), passkeyChallengeTTL)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return challenge, nil
+}
+
+// FinishRegisterPasskey verifies the authenticator response and stores the passkey.
+func (a *Auth) FinishRegisterPasskey(ctx context.Context, userID int64, clientDataJSON []byte, attestationObject []byte) error {
+	const op = "Auth.FinishRegisterPasskey"
+
+	log := a.logger(ctx).With(slog.String("op", op), slog.Int64("uid", userID))
+	log.Info("registering passkey")
+
+	challenge, subject, err := a.passkeyChallenge(ctx, purposePasskeyRegister, clientDataJSON)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	user, err := a.usrProvider.UserByID(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, userErr(err))
+	}
+
+	if subject != strconv.FormatInt(user.ID, 10) {
+		return fmt.Errorf("%s: %w", op, ErrInvalidPasskey)
+	}
+
+	cred, err := a.webauthn.VerifyRegistration(challenge, clientDataJSON, attestationObject)
+	if err != nil {
+		log.Info("invalid passkey registration", sl.Err(err))
+
+		return fmt.Errorf("%s: %w", op, ErrInvalidPasskey)
+	}
+
+	err = a.passkeyStore.SavePasskey(ctx, models.Passkey{
+		ID:        cred.ID,
+		UserID:    user.ID,
+		PublicKey: cred.PublicKey,
+		SignCount: cred.SignCount,
+	})
+	if err != nil {
+		if errors.Is(err, storage.ErrPasskeyExists) {
+			return fmt.Errorf("%s: %w", op, ErrInvalidPasskey)
+		}
+
+		log.Error("failed to save passkey", sl.Err(err))
+
+		return fmt.Errorf("%s: %w", op, userErr(err))
+	}
+
+	log.Info("passkey registered")
+
+	return nil
+}
+
+// BeginLoginPasskey returns a challenge for navigator.credentials.get().
+// The user isn't known yet: passkeys are discoverable credentials.
+func (a *Auth) BeginLoginPasskey(ctx context.Context) (challenge string, err error) {
+	const op = "Auth.BeginLoginPasskey"
+
+	if a.webauthn.RPID == "" {
+		return "", fmt.Errorf("%s: %w", op, ErrPasskeysDisabled)
+	}
+
+	challenge, err = a.issueOneTimeToken(ctx, purposePasskeyLogin, "", passkeyChallengeTTL)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return challenge, nil
+}
+
+// FinishLoginPasskey verifies the assertion and logs the owner of the passkey in.
+// User verification by the authenticator counts as the second factor.
+func (a *Auth) FinishLoginPasskey(
+	ctx context.Context,
+	credentialID []byte,
+	clientDataJSON []byte,
+	authenticatorData []byte,
+	signature []byte,
+	appID int,
+) (token string, refreshToken string, err error) {
+	const op = "Auth.FinishLoginPasskey"
+
+	log := a.logger(ctx).With(slog.String("op", op))
+	log.Info("attempting to login user by passkey")
+
+	challenge, _, err := a.passkeyChallenge(ctx, purposePasskeyLogin, clientDataJSON)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	passkey, err := a.passkeyStore.Passkey(ctx, credentialID)
+	if err != nil {
+		if errors.Is(err, storage.ErrPasskeyNotFound) {
+			return "", "", fmt.Errorf("%s: %w", op, ErrInvalidPasskey)
+		}
+
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	signCount, err := a.webauthn.VerifyAssertion(challenge, passkey.PublicKey, clientDataJSON, authenticatorData, signature)
+	if err != nil {
+		log.Info("invalid passkey assertion", sl.Err(err))
+
+		return "", "", fmt.Errorf("%s: %w", op, ErrInvalidPasskey)
+	}
+
+	// Счётчик не растёт — возможно, ключ склонирован
+	if (signCount != 0 || passkey.SignCount != 0) && signCount <= passkey.SignCount {
+		log.Warn("passkey signature counter went back", slog.Int64("uid", passkey.UserID))
+
+		return "", "", fmt.Errorf("%s: %w", op, ErrInvalidPasskey)
+	}
+
+	if err := a.passkeyStore.TouchPasskey(ctx, passkey.ID, signCount); err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	user, err := a.usrProvider.UserByID(ctx, passkey.UserID)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, userErr(err))
+	}
+
+	token, refreshToken, err = a.completeLogin(ctx, user, appID, loginMethodPasskey)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("user logged in successfully", slog.Int64("uid", user.ID))
+
+	return token, refreshToken, nil
+}
+
+// passkeyChallenge consumes the challenge answered in clientDataJSON.
+func (a *Auth) passkeyChallenge(ctx context.Context, purpose string, clientDataJSON []byte) (challenge string, subject string, err error) {
+	challenge, err = webauthn.Challenge(clientDataJSON)
+	if err != nil {
+		return "", "", ErrInvalidPasskey
+	}
+
+	subject, err = a.consumeOneTimeToken(ctx, purpose, challenge)
+	if err != nil {
+		if errors.Is(err, ErrInvalidCode) {
+			return "", "", ErrInvalidPasskey
+		}
+
+		return "", "", err
+	}
+
+	return challenge, subject, nil
+}
@@ -0,0 +1,157 @@
+package auth_test
+
+import (
+	"context"
+	"errors"
+	"sso/internal/domain/models"
+	"sso/internal/services/auth"
+	"sso/ssotest"
+	"testing"
+)
+
+// registerPasskey creates a user with a passkey of the authenticator and returns the user id.
+func registerPasskey(t *testing.T, srv *ssotest.Server, email string) (int64, *ssotest.Authenticator) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	userID, err := srv.Auth.RegisterNewUser(ctx, email, "correct-password", "", "")
+	if err != nil {
+		t.Fatalf("RegisterNewUser() error = %v", err)
+	}
+
+	a := ssotest.NewAuthenticator(t, ssotest.ES256, "localhost", "http://localhost")
+
+	challenge, err := srv.Auth.BeginRegisterPasskey(ctx, userID)
+	if err != nil {
+		t.Fatalf("BeginRegisterPasskey() error = %v", err)
+	}
+
+	clientDataJSON, attestationObject := a.Register(t, challenge)
+	if err := srv.Auth.FinishRegisterPasskey(ctx, userID, clientDataJSON, attestationObject); err != nil {
+		t.Fatalf("FinishRegisterPasskey() error = %v", err)
+	}
+
+	return userID, a
+}
+
+// loginPasskey answers a fresh login challenge with the authenticator.
+func loginPasskey(t *testing.T, srv *ssotest.Server, a *ssotest.Authenticator) error {
+	t.Helper()
+
+	ctx := context.Background()
+
+	challenge, err := srv.Auth.BeginLoginPasskey(ctx)
+	if err != nil {
+		t.Fatalf("BeginLoginPasskey() error = %v", err)
+	}
+
+	clientDataJSON, authData, sig := a.Assert(t, challenge)
+	_, _, err = srv.Auth.FinishLoginPasskey(ctx, a.CredentialID, clientDataJSON, authData, sig, ssotest.AppID)
+
+	return err
+}
+
+func TestFinishLoginPasskey(t *testing.T) {
+	ctx := context.Background()
+	srv := ssotest.NewServer(t)
+
+	_, a := registerPasskey(t, srv, "user@example.com")
+
+	if err := loginPasskey(t, srv, a); err != nil {
+		t.Fatalf("FinishLoginPasskey() error = %v", err)
+	}
+
+	t.Run("challenge replay", func(t *testing.T) {
+		challenge, err := srv.Auth.BeginLoginPasskey(ctx)
+		if err != nil {
+			t.Fatalf("BeginLoginPasskey() error = %v", err)
+		}
+
+		clientDataJSON, authData, sig := a.Assert(t, challenge)
+		if _, _, err := srv.Auth.FinishLoginPasskey(ctx, a.CredentialID, clientDataJSON, authData, sig, ssotest.AppID); err != nil {
+			t.Fatalf("FinishLoginPasskey() error = %v", err)
+		}
+
+		// Тот же ответ второй раз: челлендж уже потрачен
+		_, _, err = srv.Auth.FinishLoginPasskey(ctx, a.CredentialID, clientDataJSON, authData, sig, ssotest.AppID)
+		if !errors.Is(err, auth.ErrInvalidPasskey) {
+			t.Errorf("replay: error = %v, want %v", err, auth.ErrInvalidPasskey)
+		}
+	})
+
+	t.Run("counter regression", func(t *testing.T) {
+		a.SignCount -= 2
+
+		if err := loginPasskey(t, srv, a); !errors.Is(err, auth.ErrInvalidPasskey) {
+			t.Errorf("error = %v, want %v", err, auth.ErrInvalidPasskey)
+		}
+	})
+
+	t.Run("unknown credential", func(t *testing.T) {
+		other := ssotest.NewAuthenticator(t, ssotest.ES256, "localhost", "http://localhost")
+
+		if err := loginPasskey(t, srv, other); !errors.Is(err, auth.ErrInvalidPasskey) {
+			t.Errorf("error = %v, want %v", err, auth.ErrInvalidPasskey)
+		}
+	})
+
+	t.Run("user not verified", func(t *testing.T) {
+		b := *a
+		b.Flags = ssotest.FlagUserPresent
+		b.SignCount += 10
+
+		if err := loginPasskey(t, srv, &b); !errors.Is(err, auth.ErrInvalidPasskey) {
+			t.Errorf("error = %v, want %v", err, auth.ErrInvalidPasskey)
+		}
+	})
+}
+
+func TestFinishLoginPasskeyInactiveUser(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		disable func(srv *ssotest.Server, userID int64) error
+		wantErr error
+	}{
+		{
+			name: "suspended",
+			disable: func(srv *ssotest.Server, userID int64) error {
+				return srv.Auth.SuspendUser(ctx, userID, models.UserStatusSuspended)
+			},
+			wantErr: auth.ErrAccountSuspended,
+		},
+		{
+			name: "deleted",
+			disable: func(srv *ssotest.Server, userID int64) error {
+				return srv.Auth.SoftDeleteUser(ctx, userID)
+			},
+			wantErr: auth.ErrInvalidCredentials,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := ssotest.NewServer(t)
+			userID, a := registerPasskey(t, srv, "user@example.com")
+
+			if err := tt.disable(srv, userID); err != nil {
+				t.Fatalf("disable user: %v", err)
+			}
+
+			if err := loginPasskey(t, srv, a); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("FinishLoginPasskey() error = %v, want %v", err, tt.wantErr)
+			}
+
+			// Сессия не должна появиться до проверки статуса
+			sessions, err := srv.Storage.Sessions(ctx, userID)
+			if err != nil {
+				t.Fatalf("Sessions() error = %v", err)
+			}
+			if len(sessions) != 0 {
+				t.Errorf("sessions = %d, want 0", len(sessions))
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/passhash"
+)
+
+// ChangePassword sets a new password of the user after checking the current one.
+func (a *Auth) ChangePassword(ctx context.Context, userID int64, oldPassword string, newPassword string) error {
+	const op = "Auth.ChangePassword"
+
+	log := a.logger(ctx).With(slog.String("op", op), slog.Int64("uid", userID))
+	log.Info("attempting to change password")
+
+	user, err := a.usrProvider.UserByID(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, userErr(err))
+	}
+
+	if err := a.passwords.Compare(user.PassHash, oldPassword); err != nil {
+		if errors.Is(err, passhash.ErrMismatch) {
+			log.Info("invalid current password")
+
+			return fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+		}
+
+		log.Error("failed to compare password hash", sl.Err(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.checkBreached(ctx, newPassword); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	passHash, err := a.passwords.Hash(newPassword)
+	if err != nil {
+		log.Error("failed to hash password", sl.Err(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.usrSaver.UpdatePassHash(ctx, userID, passHash); err != nil {
+		log.Error("failed to save password", sl.Err(err))
+
+		return fmt.Errorf("%s: %w", op, userErr(err))
+	}
+
+	log.Info("password changed")
+
+	return nil
+}
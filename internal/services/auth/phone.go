@@ -0,0 +1,313 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"sso/internal/domain/models"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/phone"
+	"sso/internal/storage"
+	"time"
+)
+
+const (
+	otpTTL         = 5 * time.Minute
+	otpMaxAttempts = 5
+	// otpResendInterval is how often a new code can be sent to the same key.
+	otpResendInterval = 30 * time.Second
+
+	purposePhoneVerify = "phone_verify"
+	purposePhoneLogin  = "phone_login"
+)
+
+// RequestPhoneVerification sets phone number of the user and sends a code confirming it.
+// The number can't be used for login until VerifyPhone succeeds.
+func (a *Auth) RequestPhoneVerification(ctx context.Context, userID int64, number string) error {
+	const op = "Auth.RequestPhoneVerification"
+
+	log := a.logger(ctx).With(slog.String("op", op), slog.Int64("uid", userID))
+	log.Info("attempting to set phone")
+
+	e164, err := phone.Normalize(number)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, ErrInvalidPhone)
+	}
+
+	user, err := a.usrProvider.UserByID(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, userErr(err))
+	}
+
+	// Другие пользователи могли указать номер, но не подтвердить: он достанется подтвердившему
+	holder, err := a.usrProvider.UserByPhone(ctx, e164)
+	switch {
+	case err == nil && holder.PhoneVerified && holder.ID != user.ID:
+		return fmt.Errorf("%s: %w", op, ErrPhoneTaken)
+	case err != nil && !errors.Is(err, storage.ErrUserNotFound):
+		log.Error("failed to get user by phone", sl.Err(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if user.Phone != e164 {
+		if err := a.usrSaver.SetPhone(ctx, user.ID, e164); err != nil {
+			log.Error("failed to set phone", sl.Err(err))
+
+			return fmt.Errorf("%s: %w", op, userErr(err))
+		}
+	}
+
+	if err := a.sendOTP(ctx, e164, purposePhoneVerify, "Your verification code: %s"); err != nil {
+		log.Error("failed to send verification code", sl.Err(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// VerifyPhone confirms the phone number of the user with the code sent to it.
+func (a *Auth) VerifyPhone(ctx context.Context, userID int64, code string) error {
+	const op = "Auth.VerifyPhone"
+
+	log := a.logger(ctx).With(slog.String("op", op), slog.Int64("uid", userID))
+	log.Info("attempting to verify phone")
+
+	user, err := a.usrProvider.UserByID(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, userErr(err))
+	}
+
+	if user.Phone == "" {
+		return fmt.Errorf("%s: %w", op, ErrInvalidCode)
+	}
+
+	if err := a.checkOTP(ctx, user.Phone, purposePhoneVerify, code); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.usrSaver.MarkPhoneVerified(ctx, user.ID, user.Phone); err != nil {
+		if errors.Is(err, storage.ErrPhoneTaken) {
+			return fmt.Errorf("%s: %w", op, ErrPhoneTaken)
+		}
+
+		log.Error("failed to mark phone verified", sl.Err(err))
+
+		return fmt.Errorf("%s: %w", op, userErr(err))
+	}
+
+	log.Info("phone verified")
+
+	return nil
+}
+
+// RequestLoginCode sends a login code to the verified phone number.
+// Unknown numbers are silently ignored so that callers can't probe for accounts.
+func (a *Auth) RequestLoginCode(ctx context.Context, number string) error {
+	const op = "Auth.RequestLoginCode"
+
+	log := a.logger(ctx).With(slog.String("op", op))
+	log.Info("attempting to send login code")
+
+	user, err := a.userByVerifiedPhone(ctx, number)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			log.Info("no user with this phone")
+
+			return nil
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.sendOTP(ctx, user.Phone, purposePhoneLogin, "Your login code: %s"); err != nil {
+		// Как и для неизвестных номеров, вызывающий не узнает, что код не отправлен
+		if errors.Is(err, ErrCodeRecentlySent) {
+			log.Info("login code sent recently")
+
+			return nil
+		}
+
+		log.Error("failed to send login code", sl.Err(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// LoginWithPhoneCode exchanges a code sent by RequestLoginCode for an access token.
+func (a *Auth) LoginWithPhoneCode(ctx context.Context, number string, code string, appID int) (string, error) {
+	const op = "Auth.LoginWithPhoneCode"
+
+	log := a.logger(ctx).With(slog.String("op", op))
+	log.Info("attempting to login user by phone code")
+
+	user, err := a.userByVerifiedPhone(ctx, number)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return "", fmt.Errorf("%s: %w", op, ErrInvalidCode)
+		}
+
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.checkOTP(ctx, user.Phone, purposePhoneLogin, code); err != nil {
+		a.recordFailedLogin(ctx, user.ID, appID, number, loginMethodPhoneCode, err)
+
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.requireMFA(ctx, user, appID); err != nil {
+		a.recordFailedLogin(ctx, user.ID, appID, number, loginMethodPhoneCode, err)
+
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.checkCanLogin(ctx, user); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	sessionID, err := a.startSession(ctx, user, appID)
+	if err != nil {
+		log.Error("failed to start session", sl.Err(err))
+
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	token, err := a.issueToken(ctx, user, appID, sessionID)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	a.recordLogin(ctx, user.ID, appID, "", loginMethodPhoneCode, models.LoginSucceeded)
+
+	log.Info("user logged in successfully")
+
+	return token, nil
+}
+
+// userByVerifiedPhone returns ErrUserNotFound for unknown and for not yet verified numbers.
+func (a *Auth) userByVerifiedPhone(ctx context.Context, number string) (models.User, error) {
+	e164, err := phone.Normalize(number)
+	if err != nil {
+		return models.User{}, ErrUserNotFound
+	}
+
+	user, err := a.usrProvider.UserByPhone(ctx, e164)
+	if err != nil {
+		return models.User{}, userErr(err)
+	}
+
+	if !user.PhoneVerified {
+		return models.User{}, ErrUserNotFound
+	}
+
+	return user, nil
+}
+
+// sendOTP generates a new code for key and purpose and sends it by SMS.
+// text must contain a single %s for the code.
+//
+// A new code replaces the previous one, but while that one is still valid it
+// keeps its failed attempts, so that resending doesn't give more guesses, and
+// can't be sent more often than otpResendInterval: ErrCodeRecentlySent.
+func (a *Auth) sendOTP(ctx context.Context, key string, purpose string, text string) error {
+	now := time.Now()
+
+	var attempts int
+
+	prev, err := a.otpStore.OTP(ctx, key, purpose)
+	switch {
+	case err == nil && now.Before(prev.ExpiresAt):
+		if sentAt := prev.ExpiresAt.Add(-otpTTL); now.Before(sentAt.Add(otpResendInterval)) {
+			return ErrCodeRecentlySent
+		}
+
+		attempts = prev.Attempts
+	case err != nil && !errors.Is(err, storage.ErrOTPNotFound):
+		return err
+	}
+
+	code, err := generateCode()
+	if err != nil {
+		return err
+	}
+
+	err = a.otpStore.SaveOTP(ctx, models.OTP{
+		Key:       key,
+		Purpose:   purpose,
+		CodeHash:  hashCode(code),
+		ExpiresAt: now.Add(otpTTL),
+		Attempts:  attempts,
+	})
+	if err != nil {
+		return err
+	}
+
+	return a.smsSender.Send(ctx, key, fmt.Sprintf(text, code))
+}
+
+// checkOTP consumes the code if it matches. Every mismatch counts as an attempt;
+// after otpMaxAttempts codes are refused until the last one sent expires, as
+// the attempts carry over to codes sent meanwhile, see sendOTP.
+func (a *Auth) checkOTP(ctx context.Context, key string, purpose string, code string) error {
+	otp, err := a.otpStore.OTP(ctx, key, purpose)
+	if err != nil {
+		if errors.Is(err, storage.ErrOTPNotFound) {
+			return ErrInvalidCode
+		}
+
+		return err
+	}
+
+	if time.Now().After(otp.ExpiresAt) {
+		_ = a.otpStore.DeleteOTP(ctx, key, purpose)
+
+		return ErrInvalidCode
+	}
+
+	if otp.Attempts >= otpMaxAttempts {
+		return ErrInvalidCode
+	}
+
+	if subtle.ConstantTimeCompare(otp.CodeHash, hashCode(code)) != 1 {
+		if err := a.otpStore.IncrementOTPAttempts(ctx, key, purpose); err != nil {
+			return err
+		}
+
+		return ErrInvalidCode
+	}
+
+	// Only one of concurrent requests with the same code gets to delete it.
+	if err := a.otpStore.DeleteOTP(ctx, key, purpose); err != nil {
+		if errors.Is(err, storage.ErrOTPNotFound) {
+			return ErrInvalidCode
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func generateCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+func hashCode(code string) []byte {
+	sum := sha256.Sum256([]byte(code))
+
+	return sum[:]
+}
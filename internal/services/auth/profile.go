@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"sso/internal/lib/logger/sl"
+)
+
+const (
+	maxAvatarURLLen    = 2048
+	maxPreferenceValue = 1024
+)
+
+// preferenceKeyRe allows namespaced keys like "notifications.email".
+var preferenceKeyRe = regexp.MustCompile(`^[a-z0-9_.-]{1,64}$`)
+
+// SetAvatar sets avatar of the user to an externally hosted image.
+// Only absolute https URLs are accepted; empty url removes the avatar.
+func (a *Auth) SetAvatar(ctx context.Context, userID int64, avatarURL string) error {
+	const op = "Auth.SetAvatar"
+
+	log := a.logger(ctx).With(slog.String("op", op), slog.Int64("uid", userID))
+	log.Info("attempting to set avatar")
+
+	if avatarURL != "" && !validAvatarURL(avatarURL) {
+		return fmt.Errorf("%s: %w", op, ErrInvalidAvatarURL)
+	}
+
+	if err := a.checkUserOrg(ctx, userID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.usrSaver.SetAvatarURL(ctx, userID, avatarURL); err != nil {
+		log.Error("failed to set avatar", sl.Err(err))
+
+		return fmt.Errorf("%s: %w", op, userErr(err))
+	}
+
+	log.Info("avatar set")
+
+	return nil
+}
+
+func validAvatarURL(raw string) bool {
+	if len(raw) > maxAvatarURLLen {
+		return false
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+
+	return u.Scheme == "https" && u.Host != "" && u.User == nil
+}
+
+// GetPreferences returns cross-device settings (locale, notification opt-ins, ...) of the user.
+func (a *Auth) GetPreferences(ctx context.Context, userID int64) (map[string]string, error) {
+	const op = "Auth.GetPreferences"
+
+	log := a.logger(ctx).With(slog.String("op", op), slog.Int64("uid", userID))
+
+	user, err := a.usrProvider.UserByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, userErr(err))
+	}
+	if !inCallerOrg(ctx, user) {
+		return nil, fmt.Errorf("%s: %w", op, ErrUserNotFound)
+	}
+
+	prefs, err := a.usrProvider.Preferences(ctx, userID)
+	if err != nil {
+		log.Error("failed to get preferences", sl.Err(err))
+
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return prefs, nil
+}
+
+// SetPreference sets a single preference of the user; empty value removes it.
+func (a *Auth) SetPreference(ctx context.Context, userID int64, key string, value string) error {
+	const op = "Auth.SetPreference"
+
+	log := a.logger(ctx).With(slog.String("op", op), slog.Int64("uid", userID), slog.String("key", key))
+	log.Info("attempting to set preference")
+
+	if !preferenceKeyRe.MatchString(key) || len(value) > maxPreferenceValue {
+		return fmt.Errorf("%s: %w", op, ErrInvalidPreference)
+	}
+
+	if err := a.checkUserOrg(ctx, userID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.usrSaver.SetPreference(ctx, userID, key, value); err != nil {
+		log.Error("failed to set preference", sl.Err(err))
+
+		return fmt.Errorf("%s: %w", op, userErr(err))
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/storage"
+	"strings"
+)
+
+// ProvisionUser creates the user on behalf of an admin, e.g. from a corporate
+// directory over SCIM. Registration mode, invitations and email domain rules
+// don't apply. The user gets a random password and logs in by a password
+// reset, magic link or identity provider.
+func (a *Auth) ProvisionUser(ctx context.Context, email string) (models.User, error) {
+	const op = "Auth.ProvisionUser"
+
+	log := a.logger(ctx).With(slog.String("op", op))
+	log.Info("attempting to provision user")
+
+	email = strings.TrimSpace(email)
+	if !strings.Contains(email, "@") {
+		return models.User{}, fmt.Errorf("%s: %w", op, ErrInvalidEmail)
+	}
+
+	pass, err := randomPassword()
+	if err != nil {
+		return models.User{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var id int64
+	err = a.transactor.InTx(ctx, func(ctx context.Context) error {
+		if id, err = a.saveUser(ctx, email, pass, defaultRole, ""); err != nil {
+			return err
+		}
+
+		if err := a.recordAudit(ctx, models.AuditEvent{Action: models.AuditUserProvisioned, TargetUserID: id}); err != nil {
+			log.Error("failed to record audit event", sl.Err(err))
+
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, storage.ErrUserExists) {
+			return models.User{}, fmt.Errorf("%s: %w", op, ErrUserExists)
+		}
+
+		return models.User{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("user provisioned", slog.Int64("uid", id))
+
+	user, err := a.usrProvider.UserByID(ctx, id)
+	if err != nil {
+		log.Error("failed to get provisioned user", sl.Err(err))
+
+		return models.User{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return user, nil
+}
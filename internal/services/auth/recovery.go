@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"sso/internal/domain/models"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/storage"
+	"strings"
+)
+
+const (
+	recoveryCodeCount = 10
+	// recoveryAlphabet has no look-alike characters; 10 of them give 50 bits.
+	recoveryAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+)
+
+// RegenerateRecoveryCodes replaces all recovery codes of the user with new ones.
+func (a *Auth) RegenerateRecoveryCodes(ctx context.Context, userID int64) ([]string, error) {
+	const op = "Auth.RegenerateRecoveryCodes"
+
+	log := a.logger(ctx).With(slog.String("op", op), slog.Int64("uid", userID))
+	log.Info("regenerating recovery codes")
+
+	user, err := a.usrProvider.UserByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, userErr(err))
+	}
+
+	methods, err := a.mfaMethods(ctx, user)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("%s: %w", op, ErrMFANotEnabled)
+	}
+
+	codes, err := a.newRecoveryCodes(ctx, user.ID)
+	if err != nil {
+		log.Error("failed to save recovery codes", sl.Err(err))
+
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return codes, nil
+}
+
+// VerifyRecoveryCode is the second login step for users who lost the
+// authenticator: like VerifyTOTP, but spends one of the recovery codes.
+func (a *Auth) VerifyRecoveryCode(ctx context.Context, ticket string, code string) (token string, refreshToken string, err error) {
+	const op = "Auth.VerifyRecoveryCode"
+
+	log := a.logger(ctx).With(slog.String("op", op))
+	log.Info("attempting to verify recovery code")
+
+	userID, appID, err := a.useMFATicket(ctx, ticket)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.mfaStore.UseRecoveryCode(ctx, userID, hashCode(normalizeRecoveryCode(code))); err != nil {
+		if errors.Is(err, storage.ErrRecoveryCodeNotFound) {
+			a.recordLogin(ctx, userID, appID, "", MFAMethodRecovery, models.LoginInvalidCode)
+
+			return "", "", fmt.Errorf("%s: %w", op, ErrInvalidCode)
+		}
+
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	user, err := a.usrProvider.UserByID(ctx, userID)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, userErr(err))
+	}
+
+	token, refreshToken, err = a.completeLogin(ctx, user, appID, MFAMethodRecovery)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("user logged in with recovery code", slog.Int64("uid", user.ID))
+
+	return token, refreshToken, nil
+}
+
+// newRecoveryCodes generates recovery codes formatted as "xxxxx-xxxxx"; only hashes are stored.
+func (a *Auth) newRecoveryCodes(ctx context.Context, userID int64) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([][]byte, recoveryCodeCount)
+
+	for i := range codes {
+		b := make([]byte, 10)
+		for j := range b {
+			n, err := rand.Int(rand.Reader, big.NewInt(int64(len(recoveryAlphabet))))
+			if err != nil {
+				return nil, err
+			}
+			b[j] = recoveryAlphabet[n.Int64()]
+		}
+
+		codes[i] = string(b[:5]) + "-" + string(b[5:])
+		hashes[i] = hashCode(string(b))
+	}
+
+	if err := a.mfaStore.ReplaceRecoveryCodes(ctx, userID, hashes); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// normalizeRecoveryCode accepts codes typed with any case, spaces or dashes.
+func normalizeRecoveryCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+
+		return r
+	}, strings.ToLower(code))
+}
@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/storage"
+	"time"
+)
+
+// Refresh exchanges a refresh token for a new access token and a new refresh token.
+// Refresh tokens are rotated: the presented one can't be used again.
+func (a *Auth) Refresh(ctx context.Context, refreshToken string) (token string, newRefreshToken string, err error) {
+	const op = "Auth.Refresh"
+
+	log := a.logger(ctx).With(slog.String("op", op))
+	log.Info("attempting to refresh token")
+
+	token, newRefreshToken, err = a.refresh(ctx, refreshToken, 0)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return token, newRefreshToken, nil
+}
+
+// refresh rotates the refresh token. appID, unless zero, must be the app the
+// token was issued to; a token presented by another app is spent anyway.
+func (a *Auth) refresh(ctx context.Context, refreshToken string, appID int) (token string, newRefreshToken string, err error) {
+	rt, err := a.refreshStore.UseRefreshToken(ctx, hashCode(refreshToken))
+	if err != nil {
+		if errors.Is(err, storage.ErrTokenNotFound) {
+			return "", "", ErrInvalidRefreshToken
+		}
+
+		a.logger(ctx).Error("failed to use refresh token", sl.Err(err))
+
+		return "", "", err
+	}
+
+	if appID != 0 && rt.AppID != appID {
+		a.logger(ctx).Warn("refresh token presented by another app", slog.Int("app_id", appID))
+
+		return "", "", ErrInvalidRefreshToken
+	}
+
+	user, err := a.usrProvider.UserByID(ctx, rt.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return "", "", ErrInvalidRefreshToken
+		}
+
+		return "", "", err
+	}
+
+	if rt.SessionID != 0 {
+		if err := a.touchSession(ctx, user, rt.SessionID); err != nil {
+			if errors.Is(err, storage.ErrSessionNotFound) {
+				return "", "", ErrInvalidRefreshToken
+			}
+
+			a.logger(ctx).Error("failed to update session", sl.Err(err))
+
+			return "", "", err
+		}
+	}
+
+	token, err = a.issueToken(ctx, user, rt.AppID, rt.SessionID)
+	if err != nil {
+		return "", "", err
+	}
+
+	newRefreshToken, err = a.issueRefreshToken(ctx, user.ID, rt.AppID, rt.SessionID)
+	if err != nil {
+		a.logger(ctx).Error("failed to issue refresh token", sl.Err(err))
+
+		return "", "", err
+	}
+
+	a.logger(ctx).Info("token refreshed", slog.Int64("uid", user.ID))
+
+	return token, newRefreshToken, nil
+}
+
+// issueRefreshToken returns an empty token if refresh tokens are disabled.
+func (a *Auth) issueRefreshToken(ctx context.Context, userID int64, appID int, sessionID int64) (string, error) {
+	if a.refreshTTL <= 0 {
+		return "", nil
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	err := a.refreshStore.SaveRefreshToken(ctx, models.RefreshToken{
+		Hash:      hashCode(token),
+		UserID:    userID,
+		AppID:     appID,
+		SessionID: sessionID,
+		ExpiresAt: time.Now().Add(a.refreshTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"context"
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/logger/sl"
+	"time"
+
+	jwtlib "github.com/golang-jwt/jwt/v5"
+)
+
+// RegionPolicy runs the service in several regions (data centers) at once.
+// The zero value is a single region.
+type RegionPolicy struct {
+	// Region of this instance, recorded in issued tokens and in sessions.
+	Region string
+	// Allowed are regions whose tokens ValidateToken accepts, e.g. for data
+	// residency; empty accepts all. Tokens without a region are accepted.
+	Allowed []string
+	// Replicator, if not nil, passes revocations made here to other regions.
+	Replicator RevocationReplicator
+}
+
+// RevocationReplicator passes revocations of tokens to the other regions,
+// which apply them with ApplyRevocation. Sessions aren't replicated: they
+// belong to the region that started them, see models.Session.
+type RevocationReplicator interface {
+	ReplicateRevocation(ctx context.Context, revocation models.Revocation) error
+}
+
+// ApplyRevocation applies a revocation made in another region, without
+// replicating it again.
+func (a *Auth) ApplyRevocation(ctx context.Context, revocation models.Revocation) error {
+	if revocation.JTI != "" {
+		if err := a.denyToken(ctx, revocation.JTI, revocation.ExpiresAt); err != nil {
+			return err
+		}
+	}
+
+	if revocation.UserID != 0 {
+		if err := a.revocationStore.RevokeUserTokens(ctx, revocation.UserID, revocation.Before); err != nil {
+			return userErr(err)
+		}
+	}
+
+	return nil
+}
+
+// revokeUserTokens revokes tokens of the user issued until now, here and in
+// the other regions.
+func (a *Auth) revokeUserTokens(ctx context.Context, userID int64) error {
+	before := time.Now()
+
+	if err := a.revocationStore.RevokeUserTokens(ctx, userID, before); err != nil {
+		return err
+	}
+
+	a.replicate(ctx, models.Revocation{UserID: userID, Before: before})
+
+	return nil
+}
+
+// replicate passes the revocation to the other regions. Failing that, the
+// revocation holds here only, so the error is logged and not returned: the
+// local revocation has been made and retrying it won't help.
+func (a *Auth) replicate(ctx context.Context, revocation models.Revocation) {
+	if a.regions.Replicator == nil {
+		return
+	}
+
+	revocation.Region = a.regions.Region
+
+	if err := a.regions.Replicator.ReplicateRevocation(ctx, revocation); err != nil {
+		a.logger(ctx).Error("failed to replicate revocation", sl.Err(err))
+	}
+}
+
+// checkRegion rejects tokens of regions not allowed by the policy.
+func (a *Auth) checkRegion(ctx context.Context, claims jwtlib.MapClaims) error {
+	if err := jwt.VerifyRegion(claims, a.regions.Allowed); err != nil {
+		a.logger(ctx).Info("token of another region", sl.Err(err))
+
+		return ErrInvalidToken
+	}
+
+	return nil
+}
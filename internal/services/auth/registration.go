@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/lib/logger/sl"
+	"strings"
+	"time"
+)
+
+// Registration modes.
+const (
+	// RegistrationOpen lets anyone register.
+	RegistrationOpen = "open"
+	// RegistrationInvite requires an invitation issued by CreateInvitation.
+	RegistrationInvite = "invite"
+	// RegistrationClosed disables Register; accounts are created by admins with CreateUser.
+	RegistrationClosed = "closed"
+)
+
+const (
+	invitationTTL     = 7 * 24 * time.Hour
+	purposeInvitation = "invitation"
+)
+
+// SetRegistrationMode switches registration mode at runtime.
+func (a *Auth) SetRegistrationMode(mode string) error {
+	const op = "Auth.SetRegistrationMode"
+
+	switch mode {
+	case RegistrationOpen, RegistrationInvite, RegistrationClosed:
+	default:
+		return fmt.Errorf("%s: %w", op, ErrInvalidRegistrationMode)
+	}
+
+	a.registrationMode.Store(mode)
+
+	a.log.Info("registration mode changed", slog.String("op", op), slog.String("mode", mode))
+
+	return nil
+}
+
+// RegistrationMode returns the current registration mode.
+func (a *Auth) RegistrationMode() string {
+	return a.registrationMode.Load().(string)
+}
+
+// CreateInvitation issues an invitation code allowing the email to register
+// while registration is invite-only. Delivering the code is up to the caller.
+func (a *Auth) CreateInvitation(ctx context.Context, email string) (string, error) {
+	const op = "Auth.CreateInvitation"
+
+	log := a.logger(ctx).With(slog.String("op", op))
+	log.Info("creating invitation")
+
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return "", fmt.Errorf("%s: email is required", op)
+	}
+
+	code, err := a.issueOneTimeToken(ctx, purposeInvitation, strings.ToLower(email), invitationTTL)
+	if err != nil {
+		log.Error("failed to save invitation", sl.Err(err))
+
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return code, nil
+}
+
+// CreateUser creates an account on behalf of an admin. Unlike RegisterNewUser it
+// works in every registration mode and may assign any role.
+func (a *Auth) CreateUser(ctx context.Context, login string, pass string, role string) (int64, error) {
+	const op = "Auth.CreateUser"
+
+	if role == "" {
+		role = defaultRole
+	}
+
+	if _, err := a.lookupRole(ctx, role); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	id, err := a.saveUser(ctx, login, pass, role, "")
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return id, nil
+}
+
+// checkRegistration enforces registration mode for self-registration. It
+// returns the invitation to be spent by saveUser in invite-only mode.
+func (a *Auth) checkRegistration(inviteCode string) (string, error) {
+	switch a.RegistrationMode() {
+	case RegistrationClosed:
+		return "", ErrRegistrationClosed
+	case RegistrationInvite:
+		if inviteCode == "" {
+			return "", ErrInvitationRequired
+		}
+
+		return inviteCode, nil
+	}
+
+	return "", nil
+}
+
+// useInvitation consumes the invitation for login. It is called in the
+// transaction saving the user, so that the invitation is spent only if the
+// user is saved, and a mismatched email doesn't spend it either.
+func (a *Auth) useInvitation(ctx context.Context, login string, inviteCode string) error {
+	email, err := a.consumeOneTimeToken(ctx, purposeInvitation, inviteCode)
+	if err != nil {
+		if errors.Is(err, ErrInvalidCode) {
+			return ErrInvitationRequired
+		}
+
+		return err
+	}
+
+	if email != strings.ToLower(strings.TrimSpace(login)) {
+		return ErrInvitationRequired
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+package auth_test
+
+import (
+	"context"
+	"errors"
+	"sso/internal/services/auth"
+	"sso/internal/storage"
+	"sso/ssotest"
+	"testing"
+)
+
+func TestRegisterWithInvitation(t *testing.T) {
+	ctx := context.Background()
+	srv := ssotest.NewServer(t)
+
+	if err := srv.Auth.SetRegistrationMode(auth.RegistrationInvite); err != nil {
+		t.Fatalf("SetRegistrationMode() error = %v", err)
+	}
+
+	invite, err := srv.Auth.CreateInvitation(ctx, "invited@example.com")
+	if err != nil {
+		t.Fatalf("CreateInvitation() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		email   string
+		invite  string
+		wantErr error
+	}{
+		{name: "no invitation", email: "nobody@example.com", wantErr: auth.ErrInvitationRequired},
+		{name: "unknown invitation", email: "nobody@example.com", invite: "bogus", wantErr: auth.ErrInvitationRequired},
+		// Приглашение другого адреса не тратится
+		{name: "invitation of another email", email: "nobody@example.com", invite: invite, wantErr: auth.ErrInvitationRequired},
+		{name: "valid", email: "invited@example.com", invite: invite},
+		{name: "invitation used", email: "again@example.com", invite: invite, wantErr: auth.ErrInvitationRequired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := srv.Auth.RegisterNewUser(ctx, tt.email, "correct-password", "", tt.invite)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("RegisterNewUser() error = %v, want %v", err, tt.wantErr)
+			}
+
+			// Отклонённая регистрация не оставляет аккаунт
+			_, err = srv.Storage.User(ctx, tt.email)
+			if tt.wantErr != nil && !errors.Is(err, storage.ErrUserNotFound) {
+				t.Errorf("User() error = %v, want %v", err, storage.ErrUserNotFound)
+			}
+			if tt.wantErr == nil && err != nil {
+				t.Errorf("User() error = %v", err)
+			}
+		})
+	}
+}
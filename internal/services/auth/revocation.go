@@ -0,0 +1,266 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/dpop"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/mtls"
+	"sso/internal/storage"
+	"time"
+
+	jwtlib "github.com/golang-jwt/jwt/v5"
+)
+
+// Logout revokes the access token and, if given, the refresh token issued with it,
+// ending the session of the token. With all set, every session of the user ends.
+func (a *Auth) Logout(ctx context.Context, token string, refreshToken string, all bool) error {
+	const op = "Auth.Logout"
+
+	log := a.logger(ctx).With(slog.String("op", op))
+	log.Info("attempting to logout")
+
+	claims, err := a.parseToken(ctx, token)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	uid, _ := claims["uid"].(float64)
+	jti, _ := claims["jti"].(string)
+
+	exp, err := claims.GetExpirationTime()
+	if err != nil || exp == nil {
+		return fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+
+	if jti != "" {
+		if err := a.revokeToken(ctx, jti, exp.Time); err != nil {
+			log.Error("failed to revoke token", sl.Err(err))
+
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if refreshToken != "" {
+		if _, err := a.refreshStore.UseRefreshToken(ctx, hashCode(refreshToken)); err != nil && !errors.Is(err, storage.ErrTokenNotFound) {
+			log.Error("failed to revoke refresh token", sl.Err(err))
+
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	// Выход завершает сессию токена вместе с её refresh токенами
+	if sid := jwt.SessionID(claims); sid != 0 && !all {
+		if err := a.sessionStore.RevokeSession(ctx, int64(uid), sid); err != nil && !errors.Is(err, storage.ErrSessionNotFound) {
+			log.Error("failed to revoke session", sl.Err(err))
+
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if all {
+		if err := a.revokeUserTokens(ctx, int64(uid)); err != nil {
+			log.Error("failed to revoke user tokens", sl.Err(err))
+
+			return fmt.Errorf("%s: %w", op, userErr(err))
+		}
+	}
+
+	log.Info("user logged out", slog.Int64("uid", int64(uid)), slog.Bool("all", all))
+
+	return nil
+}
+
+// ValidateToken verifies the access token for other services: signature,
+// expiry, revocation and, for bound tokens, the client certificate or
+// DPoP proof of the call.
+func (a *Auth) ValidateToken(ctx context.Context, token string) (models.TokenClaims, error) {
+	const op = "Auth.ValidateToken"
+
+	claims, err := a.parseToken(ctx, token)
+	if err != nil {
+		return models.TokenClaims{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.checkRegion(ctx, claims); err != nil {
+		return models.TokenClaims{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.checkRevoked(ctx, claims); err != nil {
+		if !errors.Is(err, ErrTokenRevoked) && !errors.Is(err, ErrInvalidToken) {
+			a.logger(ctx).Error("failed to check token revocation", slog.String("op", op), sl.Err(err))
+		}
+
+		return models.TokenClaims{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.checkBinding(ctx, token, claims); err != nil {
+		if !errors.Is(err, ErrTokenBinding) {
+			a.logger(ctx).Error("failed to check token binding", slog.String("op", op), sl.Err(err))
+		}
+
+		return models.TokenClaims{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	uid, _ := claims["uid"].(float64)
+	appID, _ := claims["app_id"].(float64)
+	jti, _ := claims["jti"].(string)
+	email, _ := claims["email"].(string)
+	role, _ := claims["role"].(string)
+	aud, _ := claims["aud"].(string)
+
+	// exp и iat уже проверены в parseToken и checkRevoked
+	exp, _ := claims.GetExpirationTime()
+	iat, _ := claims.GetIssuedAt()
+
+	return models.TokenClaims{
+		JTI:            jti,
+		UserID:         int64(uid),
+		Email:          email,
+		Role:           role,
+		AppID:          int(appID),
+		Audience:       aud,
+		Region:         jwt.Region(claims),
+		IssuedAt:       iat.Time,
+		ExpiresAt:      exp.Time,
+		CertThumbprint: jwt.CertBinding(claims),
+		KeyThumbprint:  jwt.KeyBinding(claims),
+		SessionID:      jwt.SessionID(claims),
+		Scopes:         jwt.Scopes(claims),
+		Groups:         jwt.Groups(claims),
+		OrgID:          jwt.OrgID(claims),
+	}, nil
+}
+
+// parseToken verifies the access token with the secret of the app it was issued for.
+func (a *Auth) parseToken(ctx context.Context, token string) (jwtlib.MapClaims, error) {
+	claims, err := jwt.Parse(token, func(appID int) ([]string, *jwt.SigningKey, error) {
+		app, err := a.appProvider.App(ctx, appID)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		secrets := []string{app.Secret}
+		if app.PreviousSecret != "" && time.Now().Before(app.PreviousSecretExpiresAt) {
+			secrets = append(secrets, app.PreviousSecret)
+		}
+
+		return secrets, a.signingKeys[appID], nil
+	}, a.tokenLeeway)
+	if err != nil {
+		a.logger(ctx).Info("invalid token", sl.Err(err))
+
+		return nil, ErrInvalidToken
+	}
+
+	return claims, nil
+}
+
+// checkBinding returns ErrTokenBinding if the token is bound to a client
+// certificate or DPoP key (RFC 8705, RFC 9449) the call doesn't present.
+func (a *Auth) checkBinding(ctx context.Context, token string, claims jwtlib.MapClaims) error {
+	thumbprint, _ := mtls.PeerThumbprint(ctx)
+	if err := jwt.VerifyCertBinding(jwt.CertBinding(claims), thumbprint); err != nil {
+		a.logger(ctx).Info("token certificate binding mismatch", sl.Err(err))
+
+		return ErrTokenBinding
+	}
+
+	jkt := jwt.KeyBinding(claims)
+	if jkt == "" {
+		return nil
+	}
+
+	var proof *dpop.Proof
+
+	if raw, htu, ok := dpop.FromIncomingContext(ctx); ok {
+		p, err := dpop.Verify(raw, dpop.Method, htu, time.Now(), a.tokenLeeway)
+		if err != nil {
+			a.logger(ctx).Info("invalid dpop proof", sl.Err(err))
+
+			return ErrTokenBinding
+		}
+
+		proof = &p
+	}
+
+	if err := dpop.VerifyBinding(jkt, token, proof); err != nil {
+		a.logger(ctx).Info("token key binding mismatch", sl.Err(err))
+
+		return ErrTokenBinding
+	}
+
+	// Proof нельзя предъявить повторно
+	if err := a.useJTI(ctx, proof.JTI, time.Now().Add(dpop.MaxProofAge+a.tokenLeeway)); err != nil {
+		if errors.Is(err, ErrTokenReplayed) {
+			a.logger(ctx).Info("dpop proof replayed", sl.Err(err))
+
+			return ErrTokenBinding
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// revokeToken denies the access token with the given id until it expires,
+// here and in the other regions.
+func (a *Auth) revokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	if err := a.denyToken(ctx, jti, expiresAt); err != nil {
+		return err
+	}
+
+	a.replicate(ctx, models.Revocation{JTI: jti, ExpiresAt: expiresAt})
+
+	return nil
+}
+
+// denyToken denies the access token in this region.
+func (a *Auth) denyToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	if a.denylist == nil {
+		return a.revocationStore.RevokeToken(ctx, jti, expiresAt)
+	}
+
+	// Токен принимается ещё tokenLeeway после exp
+	return a.denylist.DenyToken(ctx, jti, expiresAt.Add(a.tokenLeeway))
+}
+
+// checkRevoked returns ErrTokenRevoked if the token was revoked by Logout.
+func (a *Auth) checkRevoked(ctx context.Context, claims jwtlib.MapClaims) error {
+	uid, _ := claims["uid"].(float64)
+	jti, _ := claims["jti"].(string)
+
+	iat, err := claims.GetIssuedAt()
+	if err != nil || iat == nil {
+		return ErrInvalidToken
+	}
+
+	if a.denylist != nil && jti != "" {
+		denied, err := a.denylist.TokenDenied(ctx, jti)
+		if err != nil {
+			return err
+		}
+
+		if denied {
+			return ErrTokenRevoked
+		}
+	}
+
+	// Tokens issued in the same second as "logout everywhere" are revoked too,
+	// iat has no finer precision.
+	revoked, err := a.revocationStore.TokenRevoked(ctx, int64(uid), jwt.SessionID(claims), jti, iat.Time)
+	if err != nil {
+		return err
+	}
+
+	if revoked {
+		return ErrTokenRevoked
+	}
+
+	return nil
+}
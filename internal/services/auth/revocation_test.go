@@ -0,0 +1,170 @@
+package auth_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"sso/internal/lib/dpop"
+	"sso/internal/lib/mtls"
+	"sso/internal/services/auth"
+	"sso/ssotest"
+	"testing"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// withPeerCert returns ctx of a gRPC call made with the client certificate.
+func withPeerCert(ctx context.Context, cert *x509.Certificate) context.Context {
+	return peer.NewContext(ctx, &peer.Peer{AuthInfo: credentials.TLSInfo{
+		State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}},
+	}})
+}
+
+func TestValidateTokenCertBinding(t *testing.T) {
+	srv := ssotest.NewServer(t)
+
+	uid, err := srv.Storage.SaveUser(context.Background(), "user@example.com", []byte("hash"), "user")
+	if err != nil {
+		t.Fatalf("SaveUser() error = %v", err)
+	}
+
+	certA := &x509.Certificate{Raw: []byte("cert-a")}
+	certB := &x509.Certificate{Raw: []byte("cert-b")}
+
+	tests := []struct {
+		name    string
+		bound   string
+		ctx     context.Context
+		wantErr error
+	}{
+		{
+			name: "unbound without certificate",
+			ctx:  context.Background(),
+		},
+		{
+			name: "unbound with certificate",
+			ctx:  withPeerCert(context.Background(), certA),
+		},
+		{
+			name:  "bound with same certificate",
+			bound: mtls.Thumbprint(certA),
+			ctx:   withPeerCert(context.Background(), certA),
+		},
+		{
+			name:    "bound without certificate",
+			bound:   mtls.Thumbprint(certA),
+			ctx:     context.Background(),
+			wantErr: auth.ErrTokenBinding,
+		},
+		{
+			name:    "bound with other certificate",
+			bound:   mtls.Thumbprint(certA),
+			ctx:     withPeerCert(context.Background(), certB),
+			wantErr: auth.ErrTokenBinding,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := ssotest.MustMintToken(t, ssotest.Claims{UserID: uid, CertThumbprint: tt.bound})
+
+			claims, err := srv.Auth.ValidateToken(tt.ctx, token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ValidateToken() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if err == nil && claims.CertThumbprint != tt.bound {
+				t.Errorf("CertThumbprint = %q, want %q", claims.CertThumbprint, tt.bound)
+			}
+		})
+	}
+}
+
+func TestValidateTokenKeyBinding(t *testing.T) {
+	const method = "/auth.Auth/GetUserRole"
+
+	srv := ssotest.NewServer(t)
+
+	uid, err := srv.Storage.SaveUser(context.Background(), "user@example.com", []byte("hash"), "user")
+	if err != nil {
+		t.Fatalf("SaveUser() error = %v", err)
+	}
+
+	key := ssotest.NewDPoPKey(t)
+	other := ssotest.NewDPoPKey(t)
+
+	bound := ssotest.MustMintToken(t, ssotest.Claims{UserID: uid, KeyThumbprint: key.JKT()})
+	unbound := ssotest.MustMintToken(t, ssotest.Claims{UserID: uid})
+	replayed := key.Proof(t, method, bound)
+
+	if _, err := srv.Auth.ValidateToken(withProof(method, replayed), bound); err != nil {
+		t.Fatalf("ValidateToken() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		proof   string
+		wantErr error
+	}{
+		{
+			name:  "unbound without proof",
+			token: unbound,
+		},
+		{
+			name:  "bound with proof",
+			token: bound,
+			proof: key.Proof(t, method, bound),
+		},
+		{
+			name:    "bound without proof",
+			token:   bound,
+			wantErr: auth.ErrTokenBinding,
+		},
+		{
+			name:    "proof of other key",
+			token:   bound,
+			proof:   other.Proof(t, method, bound),
+			wantErr: auth.ErrTokenBinding,
+		},
+		{
+			name:    "proof without ath",
+			token:   bound,
+			proof:   key.Proof(t, method, ""),
+			wantErr: auth.ErrTokenBinding,
+		},
+		{
+			name:    "proof of other method",
+			token:   bound,
+			proof:   key.Proof(t, "/auth.Auth/ListUsers", bound),
+			wantErr: auth.ErrTokenBinding,
+		},
+		{
+			name:    "replayed proof",
+			token:   bound,
+			proof:   replayed,
+			wantErr: auth.ErrTokenBinding,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := srv.Auth.ValidateToken(withProof(method, tt.proof), tt.token); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ValidateToken() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// withProof returns ctx of a gRPC call of method sending the DPoP proof, if any.
+func withProof(method string, proof string) context.Context {
+	md := metadata.MD{}
+	if proof != "" {
+		md.Set(dpop.Header, proof)
+	}
+
+	return ssotest.IncomingContext(context.Background(), method, md)
+}
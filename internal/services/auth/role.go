@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"sso/internal/domain/models"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/storage"
+	"strings"
+)
+
+// defaultRole is given on registration without a role. It and AdminRole can't be deleted.
+const (
+	defaultRole = "user"
+	// AdminRole is required for privileged calls, see IsAdmin.
+	AdminRole = "admin"
+)
+
+var roleNameRe = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,63}$`)
+
+// CreateRole adds a role with its permissions.
+func (a *Auth) CreateRole(ctx context.Context, role models.Role) error {
+	const op = "Auth.CreateRole"
+
+	log := a.logger(ctx).With(slog.String("op", op), slog.String("role", role.Name))
+	log.Info("creating role")
+
+	role, err := normalizeRole(role)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.roleMgr.SaveRole(ctx, role); err != nil {
+		if errors.Is(err, storage.ErrRoleExists) {
+			return fmt.Errorf("%s: %w", op, ErrRoleExists)
+		}
+
+		log.Error("failed to save role", sl.Err(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	a.audit(ctx, models.AuditEvent{
+		Action:  models.AuditRoleCreated,
+		Details: map[string]string{"role": role.Name, "permissions": strings.Join(role.Permissions, " ")},
+	})
+
+	log.Info("role created")
+
+	return nil
+}
+
+// GetRole returns the role with its permissions.
+func (a *Auth) GetRole(ctx context.Context, name string) (models.Role, error) {
+	const op = "Auth.GetRole"
+
+	role, err := a.roleMgr.Role(ctx, name)
+	if err != nil {
+		return models.Role{}, fmt.Errorf("%s: %w", op, roleErr(err))
+	}
+
+	return role, nil
+}
+
+// ListRoles returns all roles, most privileged first.
+func (a *Auth) ListRoles(ctx context.Context) ([]models.Role, error) {
+	const op = "Auth.ListRoles"
+
+	roles, err := a.roleMgr.Roles(ctx)
+	if err != nil {
+		a.logger(ctx).Error("failed to list roles", sl.Err(err))
+
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return roles, nil
+}
+
+// EditRole replaces the description, rank, self-assignability and permissions of the role.
+// Tokens already issued keep the permissions they were issued with.
+func (a *Auth) EditRole(ctx context.Context, role models.Role) error {
+	const op = "Auth.EditRole"
+
+	log := a.logger(ctx).With(slog.String("op", op), slog.String("role", role.Name))
+	log.Info("updating role")
+
+	role, err := normalizeRole(role)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.roleMgr.EditRole(ctx, role); err != nil {
+		if !errors.Is(err, storage.ErrRoleNotFound) {
+			log.Error("failed to update role", sl.Err(err))
+		}
+
+		return fmt.Errorf("%s: %w", op, roleErr(err))
+	}
+
+	a.audit(ctx, models.AuditEvent{
+		Action:  models.AuditRoleUpdated,
+		Details: map[string]string{"role": role.Name, "permissions": strings.Join(role.Permissions, " ")},
+	})
+
+	log.Info("role updated")
+
+	return nil
+}
+
+// DeleteRole deletes a role no user or API key has.
+func (a *Auth) DeleteRole(ctx context.Context, name string) error {
+	const op = "Auth.DeleteRole"
+
+	log := a.logger(ctx).With(slog.String("op", op), slog.String("role", name))
+	log.Info("deleting role")
+
+	if name == defaultRole || name == AdminRole {
+		return fmt.Errorf("%s: %w", op, ErrProtectedRole)
+	}
+
+	if err := a.roleMgr.DeleteRole(ctx, name); err != nil {
+		if errors.Is(err, storage.ErrRoleInUse) {
+			return fmt.Errorf("%s: %w", op, ErrRoleInUse)
+		}
+		if !errors.Is(err, storage.ErrRoleNotFound) {
+			log.Error("failed to delete role", sl.Err(err))
+		}
+
+		return fmt.Errorf("%s: %w", op, roleErr(err))
+	}
+
+	a.audit(ctx, models.AuditEvent{Action: models.AuditRoleDeleted, Details: map[string]string{"role": name}})
+
+	log.Info("role deleted")
+
+	return nil
+}
+
+// lookupRole returns the role to be assigned, ErrInvalidRole if there is no such role.
+func (a *Auth) lookupRole(ctx context.Context, name string) (models.Role, error) {
+	role, err := a.roleMgr.Role(ctx, name)
+	if err != nil {
+		if errors.Is(err, storage.ErrRoleNotFound) {
+			return models.Role{}, ErrInvalidRole
+		}
+
+		return models.Role{}, err
+	}
+
+	return role, nil
+}
+
+// normalizeRole validates the name and permissions, sorting permissions and dropping duplicates.
+func normalizeRole(role models.Role) (models.Role, error) {
+	if !roleNameRe.MatchString(role.Name) {
+		return models.Role{}, ErrInvalidRole
+	}
+
+	// Права попадают в scope токена, поэтому без пробелов
+	for _, p := range role.Permissions {
+		if p == "" || strings.ContainsAny(p, " \t\n") {
+			return models.Role{}, ErrInvalidPermission
+		}
+	}
+
+	role.Permissions = slices.Compact(slices.Sorted(slices.Values(role.Permissions)))
+
+	return role, nil
+}
+
+// roleErr maps storage "not found" to the service error.
+func roleErr(err error) error {
+	if errors.Is(err, storage.ErrRoleNotFound) {
+		return ErrRoleNotFound
+	}
+
+	return err
+}
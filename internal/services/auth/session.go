@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/clientinfo"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/storage"
+	"strconv"
+	"time"
+)
+
+// ListSessions returns active sessions of the user, most recently seen first.
+func (a *Auth) ListSessions(ctx context.Context, userID int64) ([]models.Session, error) {
+	const op = "Auth.ListSessions"
+
+	sessions, err := a.sessionStore.Sessions(ctx, userID)
+	if err != nil {
+		a.logger(ctx).Error("failed to list sessions", slog.String("op", op), sl.Err(err))
+
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession ends the session of the user: its refresh tokens can't be
+// used anymore and its access tokens fail validation.
+func (a *Auth) RevokeSession(ctx context.Context, userID int64, sessionID int64) error {
+	const op = "Auth.RevokeSession"
+
+	log := a.logger(ctx).With(slog.String("op", op), slog.Int64("uid", userID), slog.Int64("session_id", sessionID))
+	log.Info("attempting to revoke session")
+
+	if err := a.sessionStore.RevokeSession(ctx, userID, sessionID); err != nil {
+		if errors.Is(err, storage.ErrSessionNotFound) {
+			return fmt.Errorf("%s: %w", op, ErrSessionNotFound)
+		}
+
+		log.Error("failed to revoke session", sl.Err(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	a.audit(ctx, models.AuditEvent{
+		Action:       models.AuditSessionRevoked,
+		TargetUserID: userID,
+		Details:      map[string]string{"session_id": strconv.FormatInt(sessionID, 10)},
+	})
+
+	log.Info("session revoked")
+
+	return nil
+}
+
+// startSession records the login with the client it comes from.
+func (a *Auth) startSession(ctx context.Context, user models.User, appID int) (int64, error) {
+	info := clientinfo.FromContext(ctx)
+
+	return a.sessionStore.SaveSession(ctx, models.Session{
+		UserID:    user.ID,
+		AppID:     appID,
+		IP:        info.IP,
+		UserAgent: info.UserAgent,
+		Device:    info.Device,
+		Region:    a.regions.Region,
+		ExpiresAt: time.Now().Add(a.sessionTTL(user.Role)),
+	})
+}
+
+// touchSession extends the session on refresh and records the address it was seen from.
+func (a *Auth) touchSession(ctx context.Context, user models.User, sessionID int64) error {
+	return a.sessionStore.TouchSession(ctx, sessionID, clientinfo.FromContext(ctx).IP, time.Now().Add(a.sessionTTL(user.Role)))
+}
+
+// sessionTTL is how long the session lasts without activity: until the last
+// refresh token or, without them, the access token expires.
+func (a *Auth) sessionTTL(role string) time.Duration {
+	return max(a.refreshTTL, a.ttlFor(role))
+}
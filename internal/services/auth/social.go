@@ -0,0 +1,196 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sso/internal/domain/models"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/social"
+	"sso/internal/storage"
+	"time"
+)
+
+const (
+	purposeSocialState = "social_state"
+	socialStateTTL     = 10 * time.Minute
+)
+
+// socialState is kept in the one-time token passed to the provider as state.
+type socialState struct {
+	Provider string                      `json:"provider"`
+	Request  models.AuthorizationRequest `json:"request"`
+}
+
+// SocialProviders returns names of configured identity providers, sorted.
+func (a *Auth) SocialProviders() []string {
+	names := make([]string, 0, len(a.social))
+	for name := range a.social {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+// BeginSocialLogin starts logging in with an external identity provider on the
+// authorization endpoint and returns the URL to send the user to.
+func (a *Auth) BeginSocialLogin(ctx context.Context, provider string, req models.AuthorizationRequest) (string, error) {
+	const op = "Auth.BeginSocialLogin"
+
+	p, ok := a.social[provider]
+	if !ok {
+		return "", fmt.Errorf("%s: %w", op, ErrUnknownProvider)
+	}
+
+	if err := a.checkAuthorizationRequest(ctx, req); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	subject, err := json.Marshal(socialState{Provider: provider, Request: req})
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	state, err := a.issueOneTimeToken(ctx, purposeSocialState, string(subject), socialStateTTL)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return p.AuthURL(state), nil
+}
+
+// FinishSocialLogin handles the callback from the provider and returns an
+// authorization code for the request the login was started with.
+//
+// On first login the identity is linked to the user with the same email, if
+// the provider verified it, or a new user is created if registration is open.
+// Users with two-factor authentication get MFARequiredError as on Authorize.
+func (a *Auth) FinishSocialLogin(ctx context.Context, provider string, state string, code string) (string, models.AuthorizationRequest, error) {
+	const op = "Auth.FinishSocialLogin"
+
+	log := a.logger(ctx).With(slog.String("op", op), slog.String("provider", provider))
+	log.Info("attempting to login user with identity provider")
+
+	subject, err := a.consumeOneTimeToken(ctx, purposeSocialState, state)
+	if err != nil {
+		return "", models.AuthorizationRequest{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var st socialState
+	if err := json.Unmarshal([]byte(subject), &st); err != nil || st.Provider != provider {
+		return "", models.AuthorizationRequest{}, fmt.Errorf("%s: %w", op, ErrInvalidCode)
+	}
+	req := st.Request
+
+	p, ok := a.social[provider]
+	if !ok {
+		return "", req, fmt.Errorf("%s: %w", op, ErrUnknownProvider)
+	}
+
+	identity, err := p.Identify(ctx, code)
+	if err != nil {
+		log.Warn("failed to identify user", sl.Err(err))
+
+		return "", req, fmt.Errorf("%s: %w", op, err)
+	}
+
+	user, err := a.socialUser(ctx, provider, identity)
+	if err != nil {
+		return "", req, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.requireMFA(ctx, user, req.AppID); err != nil {
+		return "", req, fmt.Errorf("%s: %w", op, err)
+	}
+
+	authCode, err := a.issueAuthorizationCode(ctx, user.ID, req)
+	if err != nil {
+		log.Error("failed to issue authorization code", sl.Err(err))
+
+		return "", req, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("user logged in with identity provider", slog.Int64("uid", user.ID))
+
+	return authCode, req, nil
+}
+
+// socialUser finds the user linked to the identity, linking or creating one on first login.
+func (a *Auth) socialUser(ctx context.Context, provider string, identity social.Identity) (models.User, error) {
+	user, err := a.usrProvider.UserByIdentity(ctx, provider, identity.Subject)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, storage.ErrUserNotFound) {
+		return models.User{}, err
+	}
+
+	// Без подтверждённого email привязка позволила бы захватить чужой аккаунт
+	if identity.Email == "" || !identity.EmailVerified {
+		return models.User{}, ErrEmailNotVerified
+	}
+
+	user, err = a.usrProvider.User(ctx, identity.Email)
+	switch {
+	case err == nil:
+		a.logger(ctx).Info("linking identity to existing user", slog.Int64("uid", user.ID))
+	case errors.Is(err, storage.ErrUserNotFound):
+		if user, err = a.saveSocialUser(ctx, identity.Email); err != nil {
+			return models.User{}, err
+		}
+	default:
+		return models.User{}, err
+	}
+
+	if err := a.usrSaver.SaveIdentity(ctx, user.ID, provider, identity.Subject); err != nil {
+		// Параллельный первый вход уже привязал аккаунт
+		if errors.Is(err, storage.ErrIdentityExists) {
+			return a.usrProvider.UserByIdentity(ctx, provider, identity.Subject)
+		}
+
+		return models.User{}, err
+	}
+
+	return user, nil
+}
+
+// randomPassword returns a password nobody knows, for users created without one.
+func randomPassword() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// saveSocialUser registers the user with a random password, which can be reset later.
+func (a *Auth) saveSocialUser(ctx context.Context, email string) (models.User, error) {
+	if a.RegistrationMode() != RegistrationOpen {
+		return models.User{}, ErrRegistrationClosed
+	}
+
+	if !a.emailDomains.Permits(email, defaultRole) {
+		return models.User{}, ErrEmailDomainNotAllowed
+	}
+
+	pass, err := randomPassword()
+	if err != nil {
+		return models.User{}, err
+	}
+
+	id, err := a.saveUser(ctx, email, pass, defaultRole, "")
+	if err != nil {
+		return models.User{}, err
+	}
+
+	a.logger(ctx).Info("user registered with identity provider", slog.Int64("uid", id))
+
+	return a.usrProvider.UserByID(ctx, id)
+}
@@ -0,0 +1,192 @@
+package auth_test
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sso/internal/domain/models"
+	"sso/internal/lib/social"
+	"sso/internal/services/auth"
+	"sso/ssotest"
+	"testing"
+)
+
+// provider is an identity provider answering codes with fixed identities.
+type provider map[string]social.Identity
+
+func (p provider) AuthURL(state string) string {
+	return "https://idp.example/auth?" + url.Values{"state": {state}}.Encode()
+}
+
+func (p provider) Identify(_ context.Context, code string) (social.Identity, error) {
+	identity, ok := p[code]
+	if !ok {
+		return social.Identity{}, social.ErrExchange
+	}
+
+	return identity, nil
+}
+
+// newSocialServer starts the service with providers "idp" and "other" and an
+// existing user@example.com.
+func newSocialServer(t *testing.T) (*ssotest.Server, int64) {
+	t.Helper()
+
+	idp := provider{
+		"new":        {Subject: "1", Email: "new@example.com", EmailVerified: true},
+		"existing":   {Subject: "2", Email: "user@example.com", EmailVerified: true},
+		"unverified": {Subject: "3", Email: "user@example.com"},
+		"no email":   {Subject: "4", EmailVerified: true},
+		"stranger":   {Subject: "5", Email: "stranger@example.com", EmailVerified: true},
+	}
+
+	srv := ssotest.NewServerWithDeps(t, func(d *auth.Deps) {
+		d.Social = map[string]social.Provider{"idp": idp, "other": idp}
+	})
+	srv.Storage.AddApp(models.App{ID: ssotest.AppID, Name: ssotest.AppName, Secret: ssotest.AppSecret, RedirectURIs: []string{redirectURI}})
+
+	userID, err := srv.Auth.RegisterNewUser(context.Background(), "user@example.com", "correct-password", "", "")
+	if err != nil {
+		t.Fatalf("RegisterNewUser() error = %v", err)
+	}
+
+	return srv, userID
+}
+
+// beginSocial starts the login with the provider and returns the state sent to it.
+func beginSocial(t *testing.T, srv *ssotest.Server, name string) string {
+	t.Helper()
+
+	redirect, err := srv.Auth.BeginSocialLogin(context.Background(), name, models.AuthorizationRequest{AppID: ssotest.AppID, RedirectURI: redirectURI})
+	if err != nil {
+		t.Fatalf("BeginSocialLogin() error = %v", err)
+	}
+
+	u, err := url.Parse(redirect)
+	if err != nil {
+		t.Fatalf("parse redirect: %v", err)
+	}
+
+	return u.Query().Get("state")
+}
+
+func TestFinishSocialLogin(t *testing.T) {
+	ctx := context.Background()
+	srv, userID := newSocialServer(t)
+
+	tests := []struct {
+		name    string
+		code    string
+		wantErr error
+		subject string
+		// wantUser is the id of the user linked to the subject, zero for a new user.
+		wantUser int64
+	}{
+		{name: "new user", code: "new", subject: "1"},
+		{name: "link verified email", code: "existing", subject: "2", wantUser: userID},
+		{name: "linked identity", code: "existing", subject: "2", wantUser: userID},
+		// Иначе чужой аккаунт захватывается через провайдер без проверки email
+		{name: "unverified email", code: "unverified", subject: "3", wantErr: auth.ErrEmailNotVerified},
+		{name: "no email", code: "no email", subject: "4", wantErr: auth.ErrEmailNotVerified},
+		{name: "rejected code", code: "forged", wantErr: social.ErrExchange},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, req, err := srv.Auth.FinishSocialLogin(ctx, "idp", beginSocial(t, srv, "idp"), tt.code)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("FinishSocialLogin() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr != nil {
+				if tt.subject != "" {
+					if _, err := srv.Storage.UserByIdentity(ctx, "idp", tt.subject); err == nil {
+						t.Error("identity linked despite the error")
+					}
+				}
+
+				return
+			}
+
+			if req.AppID != ssotest.AppID || req.RedirectURI != redirectURI {
+				t.Errorf("request = %+v, want the one login started with", req)
+			}
+
+			if _, _, _, err := srv.Auth.ExchangeAuthorizationCode(ctx, ssotest.AppID, ssotest.AppSecret, code, redirectURI, ""); err != nil {
+				t.Errorf("ExchangeAuthorizationCode() error = %v", err)
+			}
+
+			user, err := srv.Storage.UserByIdentity(ctx, "idp", tt.subject)
+			if err != nil {
+				t.Fatalf("UserByIdentity() error = %v", err)
+			}
+			if tt.wantUser == 0 && (user.ID == userID || user.Email != "new@example.com") {
+				t.Errorf("linked user = %+v, want a new one", user)
+			}
+			if tt.wantUser != 0 && user.ID != tt.wantUser {
+				t.Errorf("linked user = %d, want %d", user.ID, tt.wantUser)
+			}
+		})
+	}
+}
+
+func TestFinishSocialLoginState(t *testing.T) {
+	ctx := context.Background()
+	srv, _ := newSocialServer(t)
+
+	t.Run("used once", func(t *testing.T) {
+		state := beginSocial(t, srv, "idp")
+
+		if _, _, err := srv.Auth.FinishSocialLogin(ctx, "idp", state, "existing"); err != nil {
+			t.Fatalf("FinishSocialLogin() error = %v", err)
+		}
+		if _, _, err := srv.Auth.FinishSocialLogin(ctx, "idp", state, "existing"); err == nil {
+			t.Error("FinishSocialLogin() with a used state: error = nil")
+		}
+	})
+
+	t.Run("forged", func(t *testing.T) {
+		if _, _, err := srv.Auth.FinishSocialLogin(ctx, "idp", "forged", "existing"); err == nil {
+			t.Error("FinishSocialLogin() with a forged state: error = nil")
+		}
+	})
+
+	t.Run("other provider", func(t *testing.T) {
+		state := beginSocial(t, srv, "other")
+
+		if _, _, err := srv.Auth.FinishSocialLogin(ctx, "idp", state, "existing"); !errors.Is(err, auth.ErrInvalidCode) {
+			t.Errorf("FinishSocialLogin() error = %v, want %v", err, auth.ErrInvalidCode)
+		}
+	})
+
+	t.Run("unknown provider", func(t *testing.T) {
+		_, err := srv.Auth.BeginSocialLogin(ctx, "unknown", models.AuthorizationRequest{AppID: ssotest.AppID, RedirectURI: redirectURI})
+		if !errors.Is(err, auth.ErrUnknownProvider) {
+			t.Errorf("BeginSocialLogin() error = %v, want %v", err, auth.ErrUnknownProvider)
+		}
+	})
+
+	t.Run("unregistered redirect uri", func(t *testing.T) {
+		_, err := srv.Auth.BeginSocialLogin(ctx, "idp", models.AuthorizationRequest{AppID: ssotest.AppID, RedirectURI: "https://evil.example/callback"})
+		if !errors.Is(err, auth.ErrInvalidRedirectURI) {
+			t.Errorf("BeginSocialLogin() error = %v, want %v", err, auth.ErrInvalidRedirectURI)
+		}
+	})
+}
+
+func TestFinishSocialLoginRegistrationClosed(t *testing.T) {
+	ctx := context.Background()
+	srv, _ := newSocialServer(t)
+
+	if err := srv.Auth.SetRegistrationMode(auth.RegistrationClosed); err != nil {
+		t.Fatalf("SetRegistrationMode() error = %v", err)
+	}
+
+	// Новых пользователей провайдер не создаёт, существующих по-прежнему пускает
+	if _, _, err := srv.Auth.FinishSocialLogin(ctx, "idp", beginSocial(t, srv, "idp"), "stranger"); !errors.Is(err, auth.ErrRegistrationClosed) {
+		t.Errorf("FinishSocialLogin() of new user: error = %v, want %v", err, auth.ErrRegistrationClosed)
+	}
+	if _, _, err := srv.Auth.FinishSocialLogin(ctx, "idp", beginSocial(t, srv, "idp"), "existing"); err != nil {
+		t.Errorf("FinishSocialLogin() of existing user: error = %v", err)
+	}
+}
@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"slices"
+	"sso/internal/domain/models"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/storage"
+	"strconv"
+)
+
+// webhookSecretPrefix marks webhook secrets, like apiKeyPrefix.
+const webhookSecretPrefix = "whsec_"
+
+// CreateWebhook registers an endpoint of the app notified about the events.
+// The returned webhook holds the secret signing deliveries; it isn't shown again.
+func (a *Auth) CreateWebhook(ctx context.Context, appID int, endpoint string, events []string) (models.Webhook, error) {
+	const op = "Auth.CreateWebhook"
+
+	log := a.logger(ctx).With(slog.String("op", op), slog.Int("app_id", appID))
+	log.Info("creating webhook")
+
+	if !validWebhookURL(endpoint) || len(events) == 0 {
+		return models.Webhook{}, fmt.Errorf("%s: %w", op, ErrInvalidWebhook)
+	}
+	for _, e := range events {
+		if !slices.Contains(models.Events, e) {
+			return models.Webhook{}, fmt.Errorf("%s: %w", op, ErrInvalidWebhook)
+		}
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return models.Webhook{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	hook := models.Webhook{
+		AppID:  appID,
+		URL:    endpoint,
+		Events: slices.Compact(slices.Sorted(slices.Values(events))),
+		Secret: webhookSecretPrefix + base64.RawURLEncoding.EncodeToString(b),
+	}
+
+	id, err := a.webhookStore.SaveWebhook(ctx, hook)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return models.Webhook{}, fmt.Errorf("%s: %w", op, ErrAppNotFound)
+		}
+
+		log.Error("failed to save webhook", sl.Err(err))
+
+		return models.Webhook{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	hook, err = a.webhookStore.Webhook(ctx, id)
+	if err != nil {
+		return models.Webhook{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	a.audit(ctx, models.AuditEvent{
+		Action:      models.AuditWebhookCreated,
+		TargetAppID: appID,
+		Details:     map[string]string{"webhook_id": strconv.FormatInt(id, 10), "url": endpoint},
+	})
+
+	log.Info("webhook created", slog.Int64("webhook_id", id))
+
+	return hook, nil
+}
+
+// DeleteWebhook removes the webhook. Its pending deliveries are dropped.
+func (a *Auth) DeleteWebhook(ctx context.Context, id int64) error {
+	const op = "Auth.DeleteWebhook"
+
+	log := a.logger(ctx).With(slog.String("op", op), slog.Int64("webhook_id", id))
+	log.Info("deleting webhook")
+
+	hook, err := a.webhookStore.Webhook(ctx, id)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, webhookErr(err))
+	}
+
+	if err := a.webhookStore.DeleteWebhook(ctx, id); err != nil {
+		return fmt.Errorf("%s: %w", op, webhookErr(err))
+	}
+
+	a.audit(ctx, models.AuditEvent{
+		Action:      models.AuditWebhookDeleted,
+		TargetAppID: hook.AppID,
+		Details:     map[string]string{"webhook_id": strconv.FormatInt(id, 10)},
+	})
+
+	log.Info("webhook deleted")
+
+	return nil
+}
+
+// ListWebhooks returns webhooks of the app, newest first, without secrets.
+func (a *Auth) ListWebhooks(ctx context.Context, appID int) ([]models.Webhook, error) {
+	const op = "Auth.ListWebhooks"
+
+	hooks, err := a.webhookStore.Webhooks(ctx, appID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+
+	return hooks, nil
+}
+
+// ListWebhookDeliveries returns the delivery log of the webhook, newest first.
+// Paging is the same as in GetLoginHistory.
+func (a *Auth) ListWebhookDeliveries(ctx context.Context, webhookID int64, pageToken string, limit int) (deliveries []models.WebhookDelivery, next string, err error) {
+	const op = "Auth.ListWebhookDeliveries"
+
+	beforeID, err := parsePageToken(pageToken)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err := a.webhookStore.Webhook(ctx, webhookID); err != nil {
+		return nil, "", fmt.Errorf("%s: %w", op, webhookErr(err))
+	}
+
+	size := pageSize(limit)
+
+	deliveries, err = a.webhookStore.WebhookDeliveries(ctx, webhookID, beforeID, size+1)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if len(deliveries) > size {
+		deliveries = deliveries[:size]
+		next = pageTokenAfter(deliveries[size-1].ID)
+	}
+
+	return deliveries, next, nil
+}
+
+func validWebhookURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+
+	return (u.Scheme == "https" || u.Scheme == "http") && u.Host != "" && u.User == nil
+}
+
+func webhookErr(err error) error {
+	if errors.Is(err, storage.ErrWebhookNotFound) {
+		return ErrWebhookNotFound
+	}
+
+	return err
+}
+
+// enqueueEvent queues an event that isn't part of a change of the storage.
+// What it describes has already happened, so failing to queue it is only logged.
+func (a *Auth) enqueueEvent(ctx context.Context, eventType string, data models.EventUser) {
+	if err := a.outbox.EnqueueEvent(ctx, eventType, data); err != nil {
+		a.logger(ctx).Warn("failed to enqueue event", slog.String("event", eventType), sl.Err(err))
+	}
+}
@@ -0,0 +1,85 @@
+package auth_test
+
+import (
+	"context"
+	"slices"
+	"sso/internal/domain/models"
+	"sso/internal/services/auth"
+	"sso/ssotest"
+	"testing"
+	"time"
+)
+
+func TestLoginFailureWebhooks(t *testing.T) {
+	ctx := context.Background()
+	srv := ssotest.NewServer(t, func(o *auth.Options) {
+		o.Lockout = auth.LockoutPolicy{Threshold: 2, Duration: time.Minute}
+	})
+	srv.Storage.AddApp(models.App{ID: 2, Name: "other"})
+
+	if _, err := srv.Auth.RegisterNewUser(ctx, "user@example.com", "correct-password", "", ""); err != nil {
+		t.Fatalf("RegisterNewUser() error = %v", err)
+	}
+
+	security := []string{models.EventUserLoginFailed, models.EventUserLocked}
+	hooks := make(map[string]int64)
+	for name, hook := range map[string]struct {
+		appID  int
+		events []string
+	}{
+		"app":              {appID: ssotest.AppID, events: security},
+		"other app":        {appID: 2, events: security},
+		"other event type": {appID: ssotest.AppID, events: []string{models.EventUserDeleted}},
+	} {
+		created, err := srv.Auth.CreateWebhook(ctx, hook.appID, "https://example.com/hook", hook.events)
+		if err != nil {
+			t.Fatalf("CreateWebhook() error = %v", err)
+		}
+		hooks[name] = created.ID
+	}
+
+	for range 2 {
+		if _, _, err := srv.Auth.Login(ctx, "user@example.com", "wrong-password", ssotest.AppID); err == nil {
+			t.Fatal("Login() error = nil, want failure")
+		}
+	}
+
+	// Как релей: события outbox превращаются в доставки вебхукам
+	events, err := srv.Storage.ClaimOutboxEvents(ctx, 100, time.Minute)
+	if err != nil {
+		t.Fatalf("ClaimOutboxEvents() error = %v", err)
+	}
+	for _, e := range events {
+		if err := srv.Storage.CompleteOutboxEvent(ctx, e.ID); err != nil {
+			t.Fatalf("CompleteOutboxEvent() error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		hook string
+		want []string
+	}{
+		{hook: "app", want: []string{models.EventUserLocked, models.EventUserLoginFailed, models.EventUserLoginFailed}},
+		// Событие входа в одно приложение не уходит вебхукам другого
+		{hook: "other app", want: nil},
+		{hook: "other event type", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.hook, func(t *testing.T) {
+			deliveries, _, err := srv.Auth.ListWebhookDeliveries(ctx, hooks[tt.hook], "", 0)
+			if err != nil {
+				t.Fatalf("ListWebhookDeliveries() error = %v", err)
+			}
+
+			var got []string
+			for _, d := range deliveries {
+				got = append(got, d.Event)
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("delivered events = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+// Package appcache caches apps of the storage, which are looked up on every
+// login and token check, in process memory or in Redis.
+package appcache
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/ttlcache"
+	"sso/internal/storage"
+	"time"
+)
+
+// AppProvider is the storage of apps, auth.AppProvider.
+type AppProvider interface {
+	App(ctx context.Context, appID int) (models.App, error)
+	AppByCertSubject(ctx context.Context, subject string) (models.App, error)
+	Apps(ctx context.Context, orgID int64) ([]models.App, error)
+	SaveApp(ctx context.Context, app models.App) (int, error)
+	UpdateApp(ctx context.Context, app models.App) error
+	DeleteApp(ctx context.Context, appID int) error
+	RotateAppSecret(ctx context.Context, appID int, secret string, previousExpiresAt time.Time) error
+}
+
+// RedisStore is redis.Storage.
+type RedisStore interface {
+	CachedApp(ctx context.Context, appID int) (models.App, error)
+	CacheApp(ctx context.Context, app models.App, ttl time.Duration) error
+	UncacheApp(ctx context.Context, appID int) error
+}
+
+// store keeps cached apps, CachedApp returns storage.ErrAppNotFound on a miss.
+type store interface {
+	CachedApp(ctx context.Context, appID int) (models.App, error)
+	CacheApp(ctx context.Context, app models.App) error
+	UncacheApp(ctx context.Context, appID int) error
+}
+
+// Provider is AppProvider caching App. Changes made through it drop the
+// cached app at once; changes made through another instance are seen after
+// the ttl unless the cache is in Redis. Other methods aren't cached.
+type Provider struct {
+	AppProvider
+	log   *slog.Logger
+	cache store
+}
+
+// New caches apps in process memory of this instance for ttl.
+func New(log *slog.Logger, provider AppProvider, ttl time.Duration) *Provider {
+	return &Provider{AppProvider: provider, log: log, cache: memoryStore{ttlcache.New[int, models.App](ttl)}}
+}
+
+// NewRedis caches apps in Redis for ttl, shared by all instances.
+func NewRedis(log *slog.Logger, provider AppProvider, r RedisStore, ttl time.Duration) *Provider {
+	return &Provider{AppProvider: provider, log: log, cache: redisStore{r, ttl}}
+}
+
+// App returns the cached app or looks it up and caches it. The cache failing
+// falls back to the storage. Unknown apps aren't cached. Apps are looked up
+// on the primary: a replica lagging behind a change would refill the cache
+// with the app just dropped from it, to be served until the ttl.
+func (p *Provider) App(ctx context.Context, appID int) (models.App, error) {
+	app, err := p.cache.CachedApp(ctx, appID)
+	if err == nil {
+		return app, nil
+	}
+	if !errors.Is(err, storage.ErrAppNotFound) {
+		p.log.Warn("failed to get cached app", slog.Int("app_id", appID), sl.Err(err))
+	}
+
+	app, err = p.AppProvider.App(storage.WithPrimary(ctx), appID)
+	if err != nil {
+		return models.App{}, err
+	}
+
+	if err := p.cache.CacheApp(ctx, app); err != nil {
+		p.log.Warn("failed to cache app", slog.Int("app_id", appID), sl.Err(err))
+	}
+
+	return app, nil
+}
+
+// UpdateApp drops the cached app, even on error: the update may have been done.
+func (p *Provider) UpdateApp(ctx context.Context, app models.App) error {
+	defer p.uncache(ctx, app.ID)
+
+	return p.AppProvider.UpdateApp(ctx, app)
+}
+
+// DeleteApp drops the cached app like UpdateApp.
+func (p *Provider) DeleteApp(ctx context.Context, appID int) error {
+	defer p.uncache(ctx, appID)
+
+	return p.AppProvider.DeleteApp(ctx, appID)
+}
+
+// RotateAppSecret drops the cached app like UpdateApp.
+func (p *Provider) RotateAppSecret(ctx context.Context, appID int, secret string, previousExpiresAt time.Time) error {
+	defer p.uncache(ctx, appID)
+
+	return p.AppProvider.RotateAppSecret(ctx, appID, secret, previousExpiresAt)
+}
+
+// uncache drops the app; failing that, the stale app is served until the ttl.
+func (p *Provider) uncache(ctx context.Context, appID int) {
+	if err := p.cache.UncacheApp(ctx, appID); err != nil {
+		p.log.Error("failed to drop cached app", slog.Int("app_id", appID), sl.Err(err))
+	}
+}
+
+type memoryStore struct {
+	apps *ttlcache.Cache[int, models.App]
+}
+
+func (s memoryStore) CachedApp(_ context.Context, appID int) (models.App, error) {
+	app, ok := s.apps.Get(appID)
+	if !ok {
+		return models.App{}, storage.ErrAppNotFound
+	}
+
+	return app, nil
+}
+
+func (s memoryStore) CacheApp(_ context.Context, app models.App) error {
+	s.apps.Set(app.ID, app)
+
+	return nil
+}
+
+func (s memoryStore) UncacheApp(_ context.Context, appID int) error {
+	s.apps.Delete(appID)
+
+	return nil
+}
+
+type redisStore struct {
+	RedisStore
+	ttl time.Duration
+}
+
+func (s redisStore) CacheApp(ctx context.Context, app models.App) error {
+	return s.RedisStore.CacheApp(ctx, app, s.ttl)
+}
@@ -0,0 +1,1971 @@
+// Package memory keeps everything in process memory and loses it on exit.
+// It needs no database, for integration tests and demos.
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"io/fs"
+	"maps"
+	"slices"
+	"sort"
+	"sso/internal/domain/models"
+	"sso/internal/storage"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Storage is an in-memory implementation of the storage used by the service.
+// It is safe for concurrent use.
+type Storage struct {
+	mu sync.Mutex
+	state
+}
+
+// state is everything the storage keeps, copied by InTx to roll back.
+type state struct {
+	nextID    int64
+	users     map[int64]models.User
+	redirects map[int64]int64
+	prefs     map[int64]map[string]string
+	metadata  map[int64]map[int]json.RawMessage
+	apps      map[int]models.App
+	jtis      map[string]time.Time
+	otps      map[[2]string]models.OTP
+	tokens    map[string]models.OneTimeToken
+	refresh   map[string]models.RefreshToken
+	revoked   map[string]time.Time
+	revokedBy map[int64]time.Time
+	totps     map[int64]models.TOTP
+	passkeys  map[string]models.Passkey
+	recovery  map[int64]map[string]bool
+	smsMFA    map[int64]bool
+	codes     map[string]models.AuthorizationCode
+	// identities maps provider and subject to user id.
+	identities map[[2]string]int64
+	// anonymized are ids of users scrubbed by AnonymizeUser.
+	anonymized map[int64]bool
+	apiKeys    map[int64]models.APIKey
+	// apiKeyIDs maps key hash to key id.
+	apiKeyIDs map[string]int64
+	sessions  map[int64]models.Session
+	// revokedSessions outlive the sessions, like revoked_at in Postgres.
+	revokedSessions    map[int64]bool
+	lastSessionID      int64
+	loginAttempts      []models.LoginAttempt
+	lastLoginAttemptID int64
+	auditEvents        []models.AuditEvent
+	loginFailures      map[int64]int
+	lockedUntil        map[int64]time.Time
+	unknownFailures    map[string]int
+	unknownLocked      map[string]time.Time
+	roles              map[string]models.Role
+	groups             map[int64]models.Group
+	lastGroupID        int64
+	// groupMembers maps group id and user id to the time the user was added.
+	groupMembers map[[2]int64]time.Time
+	orgs         map[int64]models.Organization
+	lastOrgID    int64
+
+	webhooks      map[int64]models.Webhook
+	lastWebhookID int64
+	// deliveries are in id order; the id of a delivery is its index plus one.
+	deliveries []models.WebhookDelivery
+	// outbox holds events until CompleteOutboxEvent.
+	outbox       []models.OutboxEvent
+	lastOutboxID int64
+	// idempotency maps scope and key to the call made with them.
+	idempotency map[[2]string]idempotencyKey
+}
+
+type idempotencyKey struct {
+	rec         models.IdempotencyRecord
+	lockedUntil time.Time
+	expiresAt   time.Time
+}
+
+func New() *Storage {
+	return &Storage{state: state{
+		users:           make(map[int64]models.User),
+		redirects:       make(map[int64]int64),
+		prefs:           make(map[int64]map[string]string),
+		metadata:        make(map[int64]map[int]json.RawMessage),
+		anonymized:      make(map[int64]bool),
+		apps:            make(map[int]models.App),
+		jtis:            make(map[string]time.Time),
+		otps:            make(map[[2]string]models.OTP),
+		tokens:          make(map[string]models.OneTimeToken),
+		refresh:         make(map[string]models.RefreshToken),
+		revoked:         make(map[string]time.Time),
+		revokedBy:       make(map[int64]time.Time),
+		totps:           make(map[int64]models.TOTP),
+		passkeys:        make(map[string]models.Passkey),
+		recovery:        make(map[int64]map[string]bool),
+		smsMFA:          make(map[int64]bool),
+		codes:           make(map[string]models.AuthorizationCode),
+		identities:      make(map[[2]string]int64),
+		apiKeys:         make(map[int64]models.APIKey),
+		apiKeyIDs:       make(map[string]int64),
+		sessions:        make(map[int64]models.Session),
+		revokedSessions: make(map[int64]bool),
+		loginFailures:   make(map[int64]int),
+		lockedUntil:     make(map[int64]time.Time),
+		unknownFailures: make(map[string]int),
+		unknownLocked:   make(map[string]time.Time),
+		groups:          make(map[int64]models.Group),
+		groupMembers:    make(map[[2]int64]time.Time),
+		orgs:            make(map[int64]models.Organization),
+		webhooks:        make(map[int64]models.Webhook),
+		idempotency:     make(map[[2]string]idempotencyKey),
+		// Роли из миграции 027_roles
+		roles: map[string]models.Role{
+			"user":      {Name: "user", Rank: 0, SelfAssignable: true},
+			"organizer": {Name: "organizer", Rank: 1, SelfAssignable: true},
+			"admin":     {Name: "admin", Rank: 2},
+		},
+	}}
+}
+
+// AddApp registers an app, replacing one with the same id.
+func (s *Storage) AddApp(app models.App) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.apps[app.ID] = app
+}
+
+func (s *Storage) SaveUser(ctx context.Context, email string, passHash []byte, role string) (int64, error) {
+	return s.insert(ctx, models.User{Email: email, PassHash: passHash, Role: role})
+}
+
+func (s *Storage) SavePhoneUser(ctx context.Context, phone string, passHash []byte, role string) (int64, error) {
+	return s.insert(ctx, models.User{Phone: phone, PassHash: passHash, Role: role})
+}
+
+func (s *Storage) insert(ctx context.Context, user models.User) (int64, error) {
+	defer s.lock(ctx)()
+
+	for _, u := range s.users {
+		if (user.Email != "" && u.Email == user.Email) || (user.Phone != "" && u.Phone == user.Phone && u.PhoneVerified) {
+			return 0, storage.ErrUserExists
+		}
+	}
+
+	s.nextID++
+	user.ID = s.nextID
+	user.CreatedAt = time.Now()
+	user.Status = models.UserStatusActive
+	s.users[user.ID] = user
+	s.enqueueLocked(models.EventUserRegistered, models.EventUser{UserID: user.ID, Email: user.Email, Phone: user.Phone, Role: user.Role})
+
+	return user.ID, nil
+}
+
+func (s *Storage) User(ctx context.Context, email string) (models.User, error) {
+	return s.find(ctx, func(u models.User) bool { return u.Email == email })
+}
+
+func (s *Storage) UserByUsername(ctx context.Context, username string) (models.User, error) {
+	return s.find(ctx, func(u models.User) bool { return u.Username != "" && u.Username == username })
+}
+
+// UserByPhone prefers the user who verified the number, like the SQL storages.
+func (s *Storage) UserByPhone(ctx context.Context, phone string) (models.User, error) {
+	if user, err := s.find(ctx, func(u models.User) bool { return u.Phone == phone && u.PhoneVerified }); err == nil {
+		return user, nil
+	}
+
+	return s.find(ctx, func(u models.User) bool { return u.Phone != "" && u.Phone == phone })
+}
+
+func (s *Storage) UserByID(ctx context.Context, userID int64) (models.User, error) {
+	defer s.lock(ctx)()
+
+	u, ok := s.users[s.resolve(userID)]
+	if !ok {
+		return models.User{}, storage.ErrUserNotFound
+	}
+
+	return u, nil
+}
+
+func (s *Storage) GetUserRole(ctx context.Context, userID int64) (string, error) {
+	u, err := s.UserByID(ctx, userID)
+
+	return u.Role, err
+}
+
+// ListUsers continues after the user with the cursor's id rather than its
+// sort value, which is enough for lists not changing between pages.
+func (s *Storage) ListUsers(ctx context.Context, filter models.UserFilter, order models.UserSort, after models.UserCursor, limit int) ([]models.User, error) {
+	defer s.lock(ctx)()
+
+	users := make([]models.User, 0, len(s.users))
+	for _, u := range s.users {
+		if matchUser(u, filter) {
+			users = append(users, u)
+		}
+	}
+
+	var less func(a, b models.User) bool
+	switch order.Field {
+	case "", models.UserSortID:
+		less = func(a, b models.User) bool { return a.ID < b.ID }
+	case models.UserSortEmail:
+		less = func(a, b models.User) bool { return a.Email < b.Email }
+	case models.UserSortRole:
+		less = func(a, b models.User) bool { return a.Role < b.Role }
+	case models.UserSortCreatedAt:
+		less = func(a, b models.User) bool { return a.CreatedAt.Before(b.CreatedAt) }
+	case models.UserSortLastLoginAt:
+		less = func(a, b models.User) bool { return a.LastLoginAt.Before(b.LastLoginAt) }
+	default:
+		return nil, storage.ErrInvalidSort
+	}
+
+	sort.SliceStable(users, func(i, j int) bool {
+		if order.Desc {
+			return less(users[j], users[i])
+		}
+		return less(users[i], users[j])
+	})
+
+	if after.ID != 0 {
+		i := slices.IndexFunc(users, func(u models.User) bool { return u.ID == after.ID })
+		if i < 0 {
+			return nil, nil
+		}
+		users = users[i+1:]
+	}
+	if limit > 0 && len(users) > limit {
+		users = users[:limit]
+	}
+
+	return users, nil
+}
+
+// SearchUsers matches substrings of email and username ignoring case,
+// without the similarity ranking of postgres.
+func (s *Storage) SearchUsers(ctx context.Context, query string, orgID int64, limit int) ([]models.User, error) {
+	defer s.lock(ctx)()
+
+	query = strings.ToLower(query)
+
+	var users []models.User
+	for _, u := range s.users {
+		if (orgID != 0 && u.OrgID != orgID) || !u.DeletedAt.IsZero() {
+			continue
+		}
+		if strings.Contains(strings.ToLower(u.Email), query) || strings.Contains(strings.ToLower(u.Username), query) {
+			users = append(users, u)
+		}
+	}
+
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	if limit > 0 && len(users) > limit {
+		users = users[:limit]
+	}
+
+	return users, nil
+}
+
+func (s *Storage) UserExists(ctx context.Context, email string) (bool, error) {
+	_, err := s.User(ctx, email)
+
+	return err == nil, nil
+}
+
+func (s *Storage) CountUsers(ctx context.Context, filter models.UserFilter) (int64, error) {
+	defer s.lock(ctx)()
+
+	var n int64
+	for _, u := range s.users {
+		if matchUser(u, filter) {
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+func matchUser(u models.User, filter models.UserFilter) bool {
+	return (filter.Role == "" || u.Role == filter.Role) &&
+		(filter.EmailPrefix == "" || strings.HasPrefix(u.Email, filter.EmailPrefix)) &&
+		(filter.OrgID == 0 || u.OrgID == filter.OrgID) &&
+		(filter.Deleted || u.DeletedAt.IsZero())
+}
+
+func (s *Storage) UpdateRole(ctx context.Context, userID int64, role string) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.roles[role]; !ok {
+		return storage.ErrRoleNotFound
+	}
+
+	if err := s.updateLocked(userID, func(u *models.User) error { u.Role = role; return nil }); err != nil {
+		return err
+	}
+	s.enqueueLocked(models.EventUserRoleChanged, models.EventUser{UserID: userID, Role: role})
+
+	return nil
+}
+
+func (s *Storage) Role(ctx context.Context, name string) (models.Role, error) {
+	defer s.lock(ctx)()
+
+	role, ok := s.roles[name]
+	if !ok {
+		return models.Role{}, storage.ErrRoleNotFound
+	}
+	role.Permissions = slices.Clone(role.Permissions)
+
+	return role, nil
+}
+
+func (s *Storage) Roles(ctx context.Context) ([]models.Role, error) {
+	defer s.lock(ctx)()
+
+	roles := make([]models.Role, 0, len(s.roles))
+	for _, role := range s.roles {
+		role.Permissions = slices.Clone(role.Permissions)
+		roles = append(roles, role)
+	}
+	sort.Slice(roles, func(i, j int) bool {
+		if roles[i].Rank != roles[j].Rank {
+			return roles[i].Rank > roles[j].Rank
+		}
+		return roles[i].Name < roles[j].Name
+	})
+
+	return roles, nil
+}
+
+func (s *Storage) SaveRole(ctx context.Context, role models.Role) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.roles[role.Name]; ok {
+		return storage.ErrRoleExists
+	}
+	role.Permissions = slices.Clone(role.Permissions)
+	role.CreatedAt = time.Now()
+	s.roles[role.Name] = role
+
+	return nil
+}
+
+func (s *Storage) EditRole(ctx context.Context, role models.Role) error {
+	defer s.lock(ctx)()
+
+	old, ok := s.roles[role.Name]
+	if !ok {
+		return storage.ErrRoleNotFound
+	}
+	role.Permissions = slices.Clone(role.Permissions)
+	role.CreatedAt = old.CreatedAt
+	s.roles[role.Name] = role
+
+	return nil
+}
+
+func (s *Storage) DeleteRole(ctx context.Context, name string) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.roles[name]; !ok {
+		return storage.ErrRoleNotFound
+	}
+	for _, u := range s.users {
+		if u.Role == name {
+			return storage.ErrRoleInUse
+		}
+	}
+	for _, k := range s.apiKeys {
+		if k.Role == name {
+			return storage.ErrRoleInUse
+		}
+	}
+	delete(s.roles, name)
+
+	return nil
+}
+
+func (s *Storage) SetUsername(ctx context.Context, userID int64, username string) error {
+	defer s.lock(ctx)()
+
+	for id, u := range s.users {
+		if username != "" && u.Username == username && id != userID {
+			return storage.ErrUsernameTaken
+		}
+	}
+
+	return s.updateLocked(userID, func(u *models.User) error { u.Username = username; return nil })
+}
+
+func (s *Storage) SetPhone(ctx context.Context, userID int64, phone string) error {
+	return s.update(ctx, userID, func(u *models.User) error {
+		u.Phone = phone
+		u.PhoneVerified = false
+		return nil
+	})
+}
+
+func (s *Storage) MarkPhoneVerified(ctx context.Context, userID int64, phone string) error {
+	defer s.lock(ctx)()
+
+	for id, u := range s.users {
+		if u.Phone == phone && u.PhoneVerified && id != userID {
+			return storage.ErrPhoneTaken
+		}
+	}
+
+	err := s.updateLocked(userID, func(u *models.User) error {
+		if u.Phone != phone {
+			return storage.ErrUserNotFound
+		}
+		u.PhoneVerified = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for id, u := range s.users {
+		if u.Phone == phone && !u.PhoneVerified {
+			u.Phone = ""
+			s.users[id] = u
+		}
+	}
+
+	return nil
+}
+
+func (s *Storage) SetAvatarURL(ctx context.Context, userID int64, url string) error {
+	return s.update(ctx, userID, func(u *models.User) error { u.AvatarURL = url; return nil })
+}
+
+func (s *Storage) UpdatePassHash(ctx context.Context, userID int64, passHash []byte) error {
+	return s.update(ctx, userID, func(u *models.User) error { u.PassHash = passHash; return nil })
+}
+
+func (s *Storage) TouchLogin(ctx context.Context, userID int64) error {
+	return s.update(ctx, userID, func(u *models.User) error { u.LastLoginAt = time.Now(); return nil })
+}
+
+func (s *Storage) MergeUsers(ctx context.Context, fromID int64, intoID int64, role string) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.users[fromID]; !ok {
+		return storage.ErrUserNotFound
+	}
+
+	if err := s.updateLocked(intoID, func(u *models.User) error { u.Role = role; return nil }); err != nil {
+		return err
+	}
+
+	for old, id := range s.redirects {
+		if id == fromID {
+			s.redirects[old] = intoID
+		}
+	}
+	s.redirects[fromID] = intoID
+	for k, id := range s.identities {
+		if id == fromID {
+			s.identities[k] = intoID
+		}
+	}
+	for k, addedAt := range s.groupMembers {
+		if k[1] == fromID {
+			delete(s.groupMembers, k)
+			if _, ok := s.groupMembers[[2]int64{k[0], intoID}]; !ok {
+				s.groupMembers[[2]int64{k[0], intoID}] = addedAt
+			}
+		}
+	}
+	for i, a := range s.loginAttempts {
+		if a.UserID == fromID {
+			s.loginAttempts[i].UserID = intoID
+		}
+	}
+	for id, session := range s.sessions {
+		if session.UserID == fromID {
+			session.UserID = intoID
+			s.sessions[id] = session
+		}
+	}
+	for hash, t := range s.refresh {
+		if t.UserID == fromID {
+			t.UserID = intoID
+			s.refresh[hash] = t
+		}
+	}
+	for hash, code := range s.codes {
+		if code.UserID == fromID {
+			code.UserID = intoID
+			s.codes[hash] = code
+		}
+	}
+	for id, p := range s.passkeys {
+		if p.UserID == fromID {
+			p.UserID = intoID
+			s.passkeys[id] = p
+		}
+	}
+	// Recovery codes belong to the authenticator and move with it.
+	if totp, ok := s.totps[fromID]; ok {
+		if _, ok := s.totps[intoID]; !ok {
+			totp.UserID = intoID
+			s.totps[intoID] = totp
+			s.recovery[intoID] = s.recovery[fromID]
+		}
+	}
+	for key, value := range s.prefs[fromID] {
+		if _, ok := s.prefs[intoID][key]; !ok {
+			if s.prefs[intoID] == nil {
+				s.prefs[intoID] = map[string]string{}
+			}
+			s.prefs[intoID][key] = value
+		}
+	}
+	for appID, data := range s.metadata[fromID] {
+		if _, ok := s.metadata[intoID][appID]; !ok {
+			if s.metadata[intoID] == nil {
+				s.metadata[intoID] = map[int]json.RawMessage{}
+			}
+			s.metadata[intoID][appID] = data
+		}
+	}
+	// Токены fromID теперь проверяются по отзывам intoID
+	if s.revokedBy[fromID].After(s.revokedBy[intoID]) {
+		s.revokedBy[intoID] = s.revokedBy[fromID]
+	}
+
+	s.enqueueLocked(models.EventUserMerged, models.EventUser{UserID: fromID, IntoID: intoID})
+
+	delete(s.users, fromID)
+	delete(s.prefs, fromID)
+	delete(s.metadata, fromID)
+	delete(s.totps, fromID)
+	delete(s.recovery, fromID)
+	delete(s.revokedBy, fromID)
+	delete(s.smsMFA, fromID)
+	delete(s.loginFailures, fromID)
+	delete(s.lockedUntil, fromID)
+
+	return nil
+}
+
+func (s *Storage) SetUserStatus(ctx context.Context, userID int64, status string) error {
+	return s.update(ctx, userID, func(u *models.User) error { u.Status = status; return nil })
+}
+
+func (s *Storage) SoftDeleteUser(ctx context.Context, userID int64) error {
+	defer s.lock(ctx)()
+
+	return s.updateLocked(userID, func(u *models.User) error {
+		if u.DeletedAt.IsZero() {
+			u.DeletedAt = time.Now()
+			s.enqueueLocked(models.EventUserDeleted, models.EventUser{UserID: userID})
+		}
+		return nil
+	})
+}
+
+func (s *Storage) RestoreUser(ctx context.Context, userID int64) error {
+	defer s.lock(ctx)()
+
+	if s.anonymized[userID] {
+		return storage.ErrUserNotFound
+	}
+
+	return s.updateLocked(userID, func(u *models.User) error { u.DeletedAt = time.Time{}; return nil })
+}
+
+func (s *Storage) DeleteUser(ctx context.Context, userID int64) error {
+	defer s.lock(ctx)()
+
+	u, ok := s.users[userID]
+	if !ok {
+		return storage.ErrUserNotFound
+	}
+
+	for old, id := range s.redirects {
+		if id == userID {
+			delete(s.redirects, old)
+		}
+	}
+	s.deleteOwnedLocked(u)
+	delete(s.users, userID)
+	delete(s.revokedBy, userID)
+	delete(s.anonymized, userID)
+	s.enqueueLocked(models.EventUserDeleted, models.EventUser{UserID: userID})
+
+	return nil
+}
+
+// AnonymizeUser keeps the user with id, role, org and timestamps only.
+func (s *Storage) AnonymizeUser(ctx context.Context, userID int64) error {
+	defer s.lock(ctx)()
+
+	u, ok := s.users[userID]
+	if !ok {
+		return storage.ErrUserNotFound
+	}
+
+	s.deleteOwnedLocked(u)
+
+	deletedAt := u.DeletedAt
+	if deletedAt.IsZero() {
+		deletedAt = time.Now()
+	}
+	s.users[userID] = models.User{
+		ID:          u.ID,
+		Role:        u.Role,
+		Status:      u.Status,
+		OrgID:       u.OrgID,
+		CreatedAt:   u.CreatedAt,
+		LastLoginAt: u.LastLoginAt,
+		DeletedAt:   deletedAt,
+	}
+	s.anonymized[userID] = true
+	s.enqueueLocked(models.EventUserDeleted, models.EventUser{UserID: userID})
+
+	return nil
+}
+
+// deleteOwnedLocked removes credentials, tokens, history and everything else
+// the user owns, but not the user.
+func (s *Storage) deleteOwnedLocked(u models.User) {
+	userID := u.ID
+
+	for hash, t := range s.refresh {
+		if t.UserID == userID {
+			delete(s.refresh, hash)
+		}
+	}
+	for hash, c := range s.codes {
+		if c.UserID == userID {
+			delete(s.codes, hash)
+		}
+	}
+	for id, session := range s.sessions {
+		if session.UserID == userID {
+			delete(s.sessions, id)
+		}
+	}
+	s.loginAttempts = slices.DeleteFunc(s.loginAttempts, func(a models.LoginAttempt) bool { return a.UserID == userID })
+	for k := range s.groupMembers {
+		if k[1] == userID {
+			delete(s.groupMembers, k)
+		}
+	}
+	for k, id := range s.identities {
+		if id == userID {
+			delete(s.identities, k)
+		}
+	}
+	for k := range s.otps {
+		if k[0] == u.Email || k[0] == u.Phone {
+			delete(s.otps, k)
+		}
+	}
+	delete(s.prefs, userID)
+	delete(s.metadata, userID)
+	delete(s.totps, userID)
+	delete(s.recovery, userID)
+	delete(s.smsMFA, userID)
+	delete(s.loginFailures, userID)
+	delete(s.lockedUntil, userID)
+	for id, p := range s.passkeys {
+		if p.UserID == userID {
+			delete(s.passkeys, id)
+		}
+	}
+}
+
+func (s *Storage) Preferences(ctx context.Context, userID int64) (map[string]string, error) {
+	defer s.lock(ctx)()
+
+	prefs := make(map[string]string, len(s.prefs[userID]))
+	for k, v := range s.prefs[userID] {
+		prefs[k] = v
+	}
+
+	return prefs, nil
+}
+
+func (s *Storage) SetPreference(ctx context.Context, userID int64, key string, value string) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.users[userID]; !ok {
+		return storage.ErrUserNotFound
+	}
+
+	if value == "" {
+		delete(s.prefs[userID], key)
+		return nil
+	}
+
+	if s.prefs[userID] == nil {
+		s.prefs[userID] = make(map[string]string)
+	}
+	s.prefs[userID][key] = value
+
+	return nil
+}
+
+func (s *Storage) UserMetadata(ctx context.Context, userID int64, appID int) (json.RawMessage, error) {
+	defer s.lock(ctx)()
+
+	if _, ok := s.users[userID]; !ok {
+		return nil, storage.ErrUserNotFound
+	}
+
+	if data, ok := s.metadata[userID][appID]; ok {
+		return data, nil
+	}
+
+	return json.RawMessage(`{}`), nil
+}
+
+func (s *Storage) SetUserMetadata(ctx context.Context, userID int64, appID int, data json.RawMessage) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.users[userID]; !ok {
+		return storage.ErrUserNotFound
+	}
+
+	if data == nil {
+		delete(s.metadata[userID], appID)
+		return nil
+	}
+
+	if s.metadata[userID] == nil {
+		s.metadata[userID] = make(map[int]json.RawMessage)
+	}
+	s.metadata[userID][appID] = data
+
+	return nil
+}
+
+func (s *Storage) App(ctx context.Context, appID int) (models.App, error) {
+	defer s.lock(ctx)()
+
+	app, ok := s.apps[appID]
+	if !ok {
+		return models.App{}, storage.ErrAppNotFound
+	}
+
+	return app, nil
+}
+
+func (s *Storage) AppByCertSubject(ctx context.Context, subject string) (models.App, error) {
+	defer s.lock(ctx)()
+
+	for _, app := range s.apps {
+		if app.CertSubject != "" && app.CertSubject == subject {
+			return app, nil
+		}
+	}
+
+	return models.App{}, storage.ErrAppNotFound
+}
+
+func (s *Storage) Apps(ctx context.Context, orgID int64) ([]models.App, error) {
+	defer s.lock(ctx)()
+
+	var apps []models.App
+	for _, app := range s.apps {
+		if orgID == 0 || app.OrgID == orgID {
+			apps = append(apps, app)
+		}
+	}
+	sort.Slice(apps, func(i, j int) bool { return apps[i].ID < apps[j].ID })
+
+	return apps, nil
+}
+
+func (s *Storage) SaveApp(ctx context.Context, app models.App) (int, error) {
+	defer s.lock(ctx)()
+
+	for _, other := range s.apps {
+		if other.Name == app.Name || other.Secret == app.Secret || app.CertSubject != "" && other.CertSubject == app.CertSubject {
+			return 0, storage.ErrAppExists
+		}
+		app.ID = max(app.ID, other.ID)
+	}
+	if _, ok := s.orgs[app.OrgID]; app.OrgID != 0 && !ok {
+		return 0, storage.ErrOrgNotFound
+	}
+	app.ID++
+	app.CreatedAt = time.Now()
+	s.apps[app.ID] = app
+
+	return app.ID, nil
+}
+
+func (s *Storage) UpdateApp(ctx context.Context, app models.App) error {
+	defer s.lock(ctx)()
+
+	old, ok := s.apps[app.ID]
+	if !ok {
+		return storage.ErrAppNotFound
+	}
+	for _, other := range s.apps {
+		if other.ID != app.ID && (other.Name == app.Name || app.CertSubject != "" && other.CertSubject == app.CertSubject) {
+			return storage.ErrAppExists
+		}
+	}
+	old.Name, old.RedirectURIs, old.Public, old.Scopes = app.Name, app.RedirectURIs, app.Public, app.Scopes
+	old.TokenTTL, old.Audience, old.CertSubject = app.TokenTTL, app.Audience, app.CertSubject
+	s.apps[app.ID] = old
+
+	return nil
+}
+
+func (s *Storage) RotateAppSecret(ctx context.Context, appID int, secret string, previousExpiresAt time.Time) error {
+	defer s.lock(ctx)()
+
+	app, ok := s.apps[appID]
+	if !ok {
+		return storage.ErrAppNotFound
+	}
+	app.PreviousSecret, app.PreviousSecretExpiresAt = app.Secret, previousExpiresAt
+	app.Secret = secret
+	s.apps[appID] = app
+
+	return nil
+}
+
+func (s *Storage) DeleteApp(ctx context.Context, appID int) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.apps[appID]; !ok {
+		return storage.ErrAppNotFound
+	}
+	delete(s.apps, appID)
+
+	// Как ON DELETE CASCADE в Postgres
+	for id, key := range s.apiKeys {
+		if key.AppID == appID {
+			delete(s.apiKeys, id)
+		}
+	}
+	for hash, code := range s.codes {
+		if code.AppID == appID {
+			delete(s.codes, hash)
+		}
+	}
+
+	return nil
+}
+
+func (s *Storage) UseJTI(ctx context.Context, jti string, expiresAt time.Time) error {
+	defer s.lock(ctx)()
+
+	if exp, ok := s.jtis[jti]; ok && exp.After(time.Now()) {
+		return storage.ErrJTIUsed
+	}
+	s.jtis[jti] = expiresAt
+
+	return nil
+}
+
+func (s *Storage) SaveOTP(ctx context.Context, otp models.OTP) error {
+	defer s.lock(ctx)()
+
+	s.otps[[2]string{otp.Key, otp.Purpose}] = otp
+
+	return nil
+}
+
+func (s *Storage) OTP(ctx context.Context, key string, purpose string) (models.OTP, error) {
+	defer s.lock(ctx)()
+
+	otp, ok := s.otps[[2]string{key, purpose}]
+	if !ok {
+		return models.OTP{}, storage.ErrOTPNotFound
+	}
+
+	return otp, nil
+}
+
+func (s *Storage) IncrementOTPAttempts(ctx context.Context, key string, purpose string) error {
+	defer s.lock(ctx)()
+
+	k := [2]string{key, purpose}
+	if otp, ok := s.otps[k]; ok {
+		otp.Attempts++
+		s.otps[k] = otp
+	}
+
+	return nil
+}
+
+func (s *Storage) DeleteOTP(ctx context.Context, key string, purpose string) error {
+	defer s.lock(ctx)()
+
+	k := [2]string{key, purpose}
+	if _, ok := s.otps[k]; !ok {
+		return storage.ErrOTPNotFound
+	}
+	delete(s.otps, k)
+
+	return nil
+}
+
+func (s *Storage) SaveOneTimeToken(ctx context.Context, token models.OneTimeToken) error {
+	defer s.lock(ctx)()
+
+	s.tokens[string(token.Hash)] = token
+
+	return nil
+}
+
+func (s *Storage) ConsumeOneTimeToken(ctx context.Context, hash []byte, purpose string) (models.OneTimeToken, error) {
+	defer s.lock(ctx)()
+
+	token, ok := s.tokens[string(hash)]
+	if !ok || token.Purpose != purpose || !token.ConsumedAt.IsZero() || !token.ExpiresAt.After(time.Now()) {
+		return models.OneTimeToken{}, storage.ErrTokenNotFound
+	}
+	token.ConsumedAt = time.Now()
+	s.tokens[string(hash)] = token
+
+	return token, nil
+}
+
+func (s *Storage) UserByIdentity(ctx context.Context, provider string, subject string) (models.User, error) {
+	defer s.lock(ctx)()
+
+	id, ok := s.identities[[2]string{provider, subject}]
+	if !ok {
+		return models.User{}, storage.ErrUserNotFound
+	}
+
+	u, ok := s.users[id]
+	if !ok || !u.DeletedAt.IsZero() {
+		return models.User{}, storage.ErrUserNotFound
+	}
+
+	return u, nil
+}
+
+func (s *Storage) SaveIdentity(ctx context.Context, userID int64, provider string, subject string) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.users[userID]; !ok {
+		return storage.ErrUserNotFound
+	}
+
+	k := [2]string{provider, subject}
+	if _, ok := s.identities[k]; ok {
+		return storage.ErrIdentityExists
+	}
+	s.identities[k] = userID
+
+	return nil
+}
+
+func (s *Storage) SaveAPIKey(ctx context.Context, key models.APIKey, hash []byte) (int64, error) {
+	defer s.lock(ctx)()
+
+	if _, ok := s.apps[key.AppID]; !ok {
+		return 0, storage.ErrAppNotFound
+	}
+
+	key.ID = int64(len(s.apiKeys) + 1)
+	key.CreatedAt = time.Now()
+	s.apiKeys[key.ID] = key
+	s.apiKeyIDs[string(hash)] = key.ID
+
+	return key.ID, nil
+}
+
+func (s *Storage) UseAPIKey(ctx context.Context, hash []byte) (models.APIKey, error) {
+	defer s.lock(ctx)()
+
+	key, ok := s.apiKeys[s.apiKeyIDs[string(hash)]]
+	if !ok || !key.RevokedAt.IsZero() {
+		return models.APIKey{}, storage.ErrAPIKeyNotFound
+	}
+	key.LastUsedAt = time.Now()
+	s.apiKeys[key.ID] = key
+	key.OrgID = s.apps[key.AppID].OrgID
+
+	return key, nil
+}
+
+func (s *Storage) APIKey(ctx context.Context, id int64) (models.APIKey, error) {
+	defer s.lock(ctx)()
+
+	key, ok := s.apiKeys[id]
+	if !ok {
+		return models.APIKey{}, storage.ErrAPIKeyNotFound
+	}
+
+	return key, nil
+}
+
+func (s *Storage) APIKeys(ctx context.Context, appID int) ([]models.APIKey, error) {
+	defer s.lock(ctx)()
+
+	var keys []models.APIKey
+	for _, key := range s.apiKeys {
+		if key.AppID == appID {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID > keys[j].ID })
+
+	return keys, nil
+}
+
+func (s *Storage) RevokeAPIKey(ctx context.Context, id int64) error {
+	defer s.lock(ctx)()
+
+	key, ok := s.apiKeys[id]
+	if !ok || !key.RevokedAt.IsZero() {
+		return storage.ErrAPIKeyNotFound
+	}
+	key.RevokedAt = time.Now()
+	s.apiKeys[id] = key
+
+	return nil
+}
+
+func (s *Storage) SaveAuthorizationCode(ctx context.Context, code models.AuthorizationCode) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.users[code.UserID]; !ok {
+		return storage.ErrUserNotFound
+	}
+
+	s.codes[string(code.Hash)] = code
+
+	return nil
+}
+
+func (s *Storage) ConsumeAuthorizationCode(ctx context.Context, hash []byte) (models.AuthorizationCode, error) {
+	defer s.lock(ctx)()
+
+	code, ok := s.codes[string(hash)]
+	if !ok || !code.ExpiresAt.After(time.Now()) {
+		return models.AuthorizationCode{}, storage.ErrTokenNotFound
+	}
+	delete(s.codes, string(hash))
+
+	return code, nil
+}
+
+func (s *Storage) SaveRefreshToken(ctx context.Context, token models.RefreshToken) error {
+	defer s.lock(ctx)()
+
+	s.refresh[string(token.Hash)] = token
+
+	return nil
+}
+
+func (s *Storage) UseRefreshToken(ctx context.Context, hash []byte) (models.RefreshToken, error) {
+	defer s.lock(ctx)()
+
+	token, ok := s.refresh[string(hash)]
+	if !ok || !token.ExpiresAt.After(time.Now()) {
+		return models.RefreshToken{}, storage.ErrTokenNotFound
+	}
+	delete(s.refresh, string(hash))
+
+	return token, nil
+}
+
+func (s *Storage) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	defer s.lock(ctx)()
+
+	s.revoked[jti] = expiresAt
+
+	return nil
+}
+
+func (s *Storage) RevokeUserTokens(ctx context.Context, userID int64, before time.Time) error {
+	defer s.lock(ctx)()
+
+	userID = s.resolve(userID)
+	if before.After(s.revokedBy[userID]) {
+		s.revokedBy[userID] = before
+	}
+	for hash, t := range s.refresh {
+		if s.resolve(t.UserID) == userID {
+			delete(s.refresh, hash)
+		}
+	}
+	for id, session := range s.sessions {
+		if s.resolve(session.UserID) == userID {
+			s.revokedSessions[id] = true
+		}
+	}
+
+	return nil
+}
+
+func (s *Storage) TokenRevoked(ctx context.Context, userID int64, sessionID int64, jti string, issuedAt time.Time) (bool, error) {
+	defer s.lock(ctx)()
+
+	if _, ok := s.revoked[jti]; ok || s.revokedSessions[sessionID] {
+		return true, nil
+	}
+	if userID == 0 {
+		return false, nil
+	}
+	userID = s.resolve(userID)
+	if _, ok := s.users[userID]; !ok {
+		return true, nil
+	}
+	before, ok := s.revokedBy[userID]
+
+	return ok && !issuedAt.After(before), nil
+}
+
+func (s *Storage) SaveSession(ctx context.Context, session models.Session) (int64, error) {
+	defer s.lock(ctx)()
+
+	if _, ok := s.users[session.UserID]; !ok {
+		return 0, storage.ErrUserNotFound
+	}
+
+	s.lastSessionID++
+	session.ID = s.lastSessionID
+	session.CreatedAt = time.Now()
+	session.LastSeenAt = session.CreatedAt
+	s.sessions[session.ID] = session
+
+	return session.ID, nil
+}
+
+func (s *Storage) TouchSession(ctx context.Context, id int64, ip string, expiresAt time.Time) error {
+	defer s.lock(ctx)()
+
+	session, ok := s.activeSession(id)
+	if !ok {
+		return storage.ErrSessionNotFound
+	}
+	session.LastSeenAt = time.Now()
+	if ip != "" {
+		session.IP = ip
+	}
+	if expiresAt.After(session.ExpiresAt) {
+		session.ExpiresAt = expiresAt
+	}
+	s.sessions[id] = session
+
+	return nil
+}
+
+func (s *Storage) Sessions(ctx context.Context, userID int64) ([]models.Session, error) {
+	defer s.lock(ctx)()
+
+	userID = s.resolve(userID)
+
+	var sessions []models.Session
+	for id, session := range s.sessions {
+		if _, ok := s.activeSession(id); ok && s.resolve(session.UserID) == userID {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].LastSeenAt.Equal(sessions[j].LastSeenAt) {
+			return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+		}
+
+		return sessions[i].ID > sessions[j].ID
+	})
+
+	return sessions, nil
+}
+
+func (s *Storage) RevokeSession(ctx context.Context, userID int64, id int64) error {
+	defer s.lock(ctx)()
+
+	session, ok := s.activeSession(id)
+	if !ok || s.resolve(session.UserID) != s.resolve(userID) {
+		return storage.ErrSessionNotFound
+	}
+	s.revokedSessions[id] = true
+	for hash, t := range s.refresh {
+		if t.SessionID == id {
+			delete(s.refresh, hash)
+		}
+	}
+
+	return nil
+}
+
+func (s *Storage) activeSession(id int64) (models.Session, bool) {
+	session, ok := s.sessions[id]
+	if !ok || s.revokedSessions[id] || !session.ExpiresAt.After(time.Now()) {
+		return models.Session{}, false
+	}
+
+	return session, true
+}
+
+func (s *Storage) SaveLoginAttempt(ctx context.Context, attempt models.LoginAttempt) error {
+	defer s.lock(ctx)()
+
+	s.lastLoginAttemptID++
+	attempt.ID = s.lastLoginAttemptID
+	attempt.CreatedAt = time.Now()
+	s.loginAttempts = append(s.loginAttempts, attempt)
+
+	return nil
+}
+
+func (s *Storage) LoginAttempts(ctx context.Context, userID int64, beforeID int64, limit int) ([]models.LoginAttempt, error) {
+	defer s.lock(ctx)()
+
+	userID = s.resolve(userID)
+
+	var attempts []models.LoginAttempt
+	for _, a := range slices.Backward(s.loginAttempts) {
+		if len(attempts) == limit {
+			break
+		}
+		if a.UserID != 0 && s.resolve(a.UserID) == userID && (beforeID == 0 || a.ID < beforeID) {
+			attempts = append(attempts, a)
+		}
+	}
+
+	return attempts, nil
+}
+
+func (s *Storage) SaveAuditEvent(ctx context.Context, event models.AuditEvent) error {
+	defer s.lock(ctx)()
+
+	event.ID = int64(len(s.auditEvents) + 1)
+	event.CreatedAt = time.Now()
+	s.auditEvents = append(s.auditEvents, event)
+
+	return nil
+}
+
+func (s *Storage) AuditEvents(ctx context.Context, filter models.AuditFilter, beforeID int64, limit int) ([]models.AuditEvent, error) {
+	defer s.lock(ctx)()
+
+	var events []models.AuditEvent
+	for _, e := range slices.Backward(s.auditEvents) {
+		if len(events) == limit {
+			break
+		}
+		if (filter.Action != "" && e.Action != filter.Action) ||
+			(filter.ActorUserID != 0 && e.ActorUserID != filter.ActorUserID) ||
+			(filter.TargetUserID != 0 && e.TargetUserID != filter.TargetUserID) ||
+			(filter.AppID != 0 && e.ActorAppID != filter.AppID && e.TargetAppID != filter.AppID) ||
+			(!filter.Since.IsZero() && e.CreatedAt.Before(filter.Since)) ||
+			(!filter.Until.IsZero() && !e.CreatedAt.Before(filter.Until)) ||
+			(beforeID != 0 && e.ID >= beforeID) {
+			continue
+		}
+		events = append(events, e)
+	}
+
+	return events, nil
+}
+
+func (s *Storage) FailLogin(ctx context.Context, userID int64, threshold int, lockUntil time.Time) (bool, error) {
+	defer s.lock(ctx)()
+
+	if _, ok := s.users[userID]; !ok {
+		return false, storage.ErrUserNotFound
+	}
+	s.loginFailures[userID]++
+	if threshold <= 0 || s.loginFailures[userID] < threshold {
+		return false, nil
+	}
+	s.loginFailures[userID] = 0
+	s.lockedUntil[userID] = lockUntil
+
+	return true, nil
+}
+
+func (s *Storage) FailedLogins(ctx context.Context, userID int64) (int, error) {
+	defer s.lock(ctx)()
+
+	return s.loginFailures[userID], nil
+}
+
+func (s *Storage) ResetFailedLogins(ctx context.Context, userID int64) error {
+	defer s.lock(ctx)()
+
+	delete(s.loginFailures, userID)
+	delete(s.lockedUntil, userID)
+
+	return nil
+}
+
+func (s *Storage) LockedUntil(ctx context.Context, userID int64) (time.Time, error) {
+	defer s.lock(ctx)()
+
+	return s.lockedUntil[userID], nil
+}
+
+func (s *Storage) FailUnknownLogin(ctx context.Context, loginHash []byte, threshold int, lockUntil time.Time) (bool, error) {
+	defer s.lock(ctx)()
+
+	key := string(loginHash)
+	s.unknownFailures[key]++
+	if threshold <= 0 || s.unknownFailures[key] < threshold {
+		return false, nil
+	}
+	s.unknownFailures[key] = 0
+	s.unknownLocked[key] = lockUntil
+
+	return true, nil
+}
+
+func (s *Storage) UnknownLoginFailures(ctx context.Context, loginHash []byte) (int, time.Time, error) {
+	defer s.lock(ctx)()
+
+	return s.unknownFailures[string(loginHash)], s.unknownLocked[string(loginHash)], nil
+}
+
+func (s *Storage) SaveTOTP(ctx context.Context, userID int64, secret []byte) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.users[userID]; !ok {
+		return storage.ErrUserNotFound
+	}
+	s.totps[userID] = models.TOTP{UserID: userID, Secret: secret}
+
+	return nil
+}
+
+func (s *Storage) TOTP(ctx context.Context, userID int64) (models.TOTP, error) {
+	defer s.lock(ctx)()
+
+	totp, ok := s.totps[userID]
+	if !ok {
+		return models.TOTP{}, storage.ErrTOTPNotFound
+	}
+
+	return totp, nil
+}
+
+func (s *Storage) UseTOTPStep(ctx context.Context, userID int64, step int64) error {
+	defer s.lock(ctx)()
+
+	totp, ok := s.totps[userID]
+	if !ok || totp.LastStep >= step {
+		return storage.ErrTOTPStepUsed
+	}
+	totp.LastStep = step
+	s.totps[userID] = totp
+
+	return nil
+}
+
+func (s *Storage) ConfirmTOTP(ctx context.Context, userID int64) error {
+	defer s.lock(ctx)()
+
+	totp, ok := s.totps[userID]
+	if !ok {
+		return storage.ErrTOTPNotFound
+	}
+	totp.Confirmed = true
+	s.totps[userID] = totp
+
+	return nil
+}
+
+func (s *Storage) SetSMSMFA(ctx context.Context, userID int64, enabled bool) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.users[userID]; !ok {
+		return storage.ErrUserNotFound
+	}
+	s.smsMFA[userID] = enabled
+
+	return nil
+}
+
+func (s *Storage) SMSMFA(ctx context.Context, userID int64) (bool, error) {
+	defer s.lock(ctx)()
+
+	if _, ok := s.users[userID]; !ok {
+		return false, storage.ErrUserNotFound
+	}
+
+	return s.smsMFA[userID], nil
+}
+
+func (s *Storage) ReplaceRecoveryCodes(ctx context.Context, userID int64, hashes [][]byte) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.users[userID]; !ok {
+		return storage.ErrUserNotFound
+	}
+
+	// false means unused
+	codes := make(map[string]bool, len(hashes))
+	for _, h := range hashes {
+		codes[string(h)] = false
+	}
+	s.recovery[userID] = codes
+
+	return nil
+}
+
+func (s *Storage) ResetMFA(ctx context.Context, userID int64) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.users[userID]; !ok {
+		return storage.ErrUserNotFound
+	}
+	delete(s.totps, userID)
+	delete(s.recovery, userID)
+	s.smsMFA[userID] = false
+
+	return nil
+}
+
+func (s *Storage) UseRecoveryCode(ctx context.Context, userID int64, hash []byte) error {
+	defer s.lock(ctx)()
+
+	used, ok := s.recovery[userID][string(hash)]
+	if !ok || used {
+		return storage.ErrRecoveryCodeNotFound
+	}
+	s.recovery[userID][string(hash)] = true
+
+	return nil
+}
+
+func (s *Storage) SavePasskey(ctx context.Context, passkey models.Passkey) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.users[passkey.UserID]; !ok {
+		return storage.ErrUserNotFound
+	}
+	if _, ok := s.passkeys[string(passkey.ID)]; ok {
+		return storage.ErrPasskeyExists
+	}
+	passkey.CreatedAt = time.Now()
+	s.passkeys[string(passkey.ID)] = passkey
+
+	return nil
+}
+
+func (s *Storage) Passkey(ctx context.Context, id []byte) (models.Passkey, error) {
+	defer s.lock(ctx)()
+
+	passkey, ok := s.passkeys[string(id)]
+	if !ok {
+		return models.Passkey{}, storage.ErrPasskeyNotFound
+	}
+
+	return passkey, nil
+}
+
+func (s *Storage) TouchPasskey(ctx context.Context, id []byte, signCount uint32) error {
+	defer s.lock(ctx)()
+
+	passkey, ok := s.passkeys[string(id)]
+	if !ok {
+		return storage.ErrPasskeyNotFound
+	}
+	passkey.SignCount = signCount
+	passkey.LastUsedAt = time.Now()
+	s.passkeys[string(id)] = passkey
+
+	return nil
+}
+
+func (s *Storage) find(ctx context.Context, match func(models.User) bool) (models.User, error) {
+	defer s.lock(ctx)()
+
+	for _, u := range s.users {
+		if u.DeletedAt.IsZero() && match(u) {
+			return u, nil
+		}
+	}
+
+	return models.User{}, storage.ErrUserNotFound
+}
+
+func (s *Storage) update(ctx context.Context, userID int64, fn func(*models.User) error) error {
+	defer s.lock(ctx)()
+
+	return s.updateLocked(userID, fn)
+}
+
+func (s *Storage) updateLocked(userID int64, fn func(*models.User) error) error {
+	u, ok := s.users[userID]
+	if !ok {
+		return storage.ErrUserNotFound
+	}
+
+	if err := fn(&u); err != nil {
+		return err
+	}
+	s.users[userID] = u
+
+	return nil
+}
+
+func (s *Storage) resolve(userID int64) int64 {
+	if id, ok := s.redirects[userID]; ok {
+		return id
+	}
+
+	return userID
+}
+
+func (s *Storage) SaveGroup(ctx context.Context, group models.Group) (int64, error) {
+	defer s.lock(ctx)()
+
+	for _, g := range s.groups {
+		if g.Name == group.Name {
+			return 0, storage.ErrGroupExists
+		}
+	}
+	s.lastGroupID++
+	group.ID = s.lastGroupID
+	group.CreatedAt = time.Now()
+	s.groups[group.ID] = group
+
+	return group.ID, nil
+}
+
+func (s *Storage) Group(ctx context.Context, id int64) (models.Group, error) {
+	defer s.lock(ctx)()
+
+	group, ok := s.groups[id]
+	if !ok {
+		return models.Group{}, storage.ErrGroupNotFound
+	}
+
+	return group, nil
+}
+
+func (s *Storage) AddGroupMember(ctx context.Context, groupID int64, userID int64) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.groups[groupID]; !ok {
+		return storage.ErrGroupNotFound
+	}
+	if _, ok := s.users[userID]; !ok {
+		return storage.ErrUserNotFound
+	}
+	if _, ok := s.groupMembers[[2]int64{groupID, userID}]; !ok {
+		s.groupMembers[[2]int64{groupID, userID}] = time.Now()
+	}
+
+	return nil
+}
+
+func (s *Storage) RemoveGroupMember(ctx context.Context, groupID int64, userID int64) error {
+	defer s.lock(ctx)()
+
+	delete(s.groupMembers, [2]int64{groupID, userID})
+
+	return nil
+}
+
+func (s *Storage) GroupMembers(ctx context.Context, groupID int64, beforeUserID int64, limit int) ([]models.GroupMember, error) {
+	defer s.lock(ctx)()
+
+	var members []models.GroupMember
+	for k, addedAt := range s.groupMembers {
+		if k[0] != groupID || (beforeUserID != 0 && k[1] >= beforeUserID) {
+			continue
+		}
+		members = append(members, models.GroupMember{UserID: k[1], Email: s.users[k[1]].Email, AddedAt: addedAt})
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].UserID > members[j].UserID })
+
+	return members[:min(limit, len(members))], nil
+}
+
+func (s *Storage) UserGroups(ctx context.Context, userID int64) ([]string, error) {
+	defer s.lock(ctx)()
+
+	var groups []string
+	for k := range s.groupMembers {
+		if k[1] == userID {
+			groups = append(groups, s.groups[k[0]].Name)
+		}
+	}
+	slices.Sort(groups)
+
+	return groups, nil
+}
+
+func (s *Storage) SaveOrganization(ctx context.Context, org models.Organization) (int64, error) {
+	defer s.lock(ctx)()
+
+	for _, o := range s.orgs {
+		if o.Name == org.Name {
+			return 0, storage.ErrOrgExists
+		}
+	}
+	s.lastOrgID++
+	org.ID = s.lastOrgID
+	org.CreatedAt = time.Now()
+	s.orgs[org.ID] = org
+
+	return org.ID, nil
+}
+
+func (s *Storage) Organization(ctx context.Context, id int64) (models.Organization, error) {
+	defer s.lock(ctx)()
+
+	org, ok := s.orgs[id]
+	if !ok {
+		return models.Organization{}, storage.ErrOrgNotFound
+	}
+
+	return org, nil
+}
+
+func (s *Storage) Organizations(ctx context.Context) ([]models.Organization, error) {
+	defer s.lock(ctx)()
+
+	orgs := make([]models.Organization, 0, len(s.orgs))
+	for _, o := range s.orgs {
+		orgs = append(orgs, o)
+	}
+	sort.Slice(orgs, func(i, j int) bool { return orgs[i].Name < orgs[j].Name })
+
+	return orgs, nil
+}
+
+func (s *Storage) SetUserOrg(ctx context.Context, userID int64, orgID int64) error {
+	defer s.lock(ctx)()
+
+	u, ok := s.users[userID]
+	if !ok {
+		return storage.ErrUserNotFound
+	}
+	if _, ok := s.orgs[orgID]; orgID != 0 && !ok {
+		return storage.ErrOrgNotFound
+	}
+	u.OrgID = orgID
+	s.users[userID] = u
+
+	return nil
+}
+
+func (s *Storage) SaveWebhook(ctx context.Context, hook models.Webhook) (int64, error) {
+	defer s.lock(ctx)()
+
+	if _, ok := s.apps[hook.AppID]; !ok {
+		return 0, storage.ErrAppNotFound
+	}
+
+	s.lastWebhookID++
+	hook.ID = s.lastWebhookID
+	hook.CreatedAt = time.Now()
+	s.webhooks[hook.ID] = hook
+
+	return hook.ID, nil
+}
+
+func (s *Storage) Webhook(ctx context.Context, id int64) (models.Webhook, error) {
+	defer s.lock(ctx)()
+
+	hook, ok := s.webhooks[id]
+	if !ok {
+		return models.Webhook{}, storage.ErrWebhookNotFound
+	}
+
+	return hook, nil
+}
+
+func (s *Storage) Webhooks(ctx context.Context, appID int) ([]models.Webhook, error) {
+	defer s.lock(ctx)()
+
+	var hooks []models.Webhook
+	for _, hook := range s.webhooks {
+		if hook.AppID == appID {
+			hooks = append(hooks, hook)
+		}
+	}
+	sort.Slice(hooks, func(i, j int) bool { return hooks[i].ID > hooks[j].ID })
+
+	return hooks, nil
+}
+
+// DeleteWebhook keeps the deliveries of the webhook, but they are never claimed again.
+func (s *Storage) DeleteWebhook(ctx context.Context, id int64) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.webhooks[id]; !ok {
+		return storage.ErrWebhookNotFound
+	}
+	delete(s.webhooks, id)
+
+	return nil
+}
+
+// enqueueLocked writes the event to the outbox, like enqueueEvent in Postgres.
+func (s *Storage) enqueueLocked(eventType string, data models.EventUser) {
+	s.lastOutboxID++
+
+	event := models.Event{ID: strconv.FormatInt(s.lastOutboxID, 10), Type: eventType, CreatedAt: time.Now().UTC(), Data: data}
+	payload, _ := json.Marshal(event)
+
+	s.outbox = append(s.outbox, models.OutboxEvent{
+		ID:        s.lastOutboxID,
+		EventID:   event.ID,
+		Type:      eventType,
+		Payload:   payload,
+		AppID:     data.AppID,
+		CreatedAt: event.CreatedAt,
+	})
+}
+
+func (s *Storage) EnqueueEvent(ctx context.Context, eventType string, data models.EventUser) error {
+	defer s.lock(ctx)()
+
+	s.enqueueLocked(eventType, data)
+
+	return nil
+}
+
+// ClaimOutboxEvents returns unsent events; there is a single relay, so no lease is needed.
+func (s *Storage) ClaimOutboxEvents(ctx context.Context, limit int, _ time.Duration) ([]models.OutboxEvent, error) {
+	defer s.lock(ctx)()
+
+	return slices.Clone(s.outbox[:min(limit, len(s.outbox))]), nil
+}
+
+func (s *Storage) CompleteOutboxEvent(ctx context.Context, id int64) error {
+	defer s.lock(ctx)()
+
+	i := slices.IndexFunc(s.outbox, func(e models.OutboxEvent) bool { return e.ID == id })
+	if i < 0 {
+		return nil
+	}
+	e := s.outbox[i]
+	s.outbox = slices.Delete(s.outbox, i, i+1)
+
+	for hookID := int64(1); hookID <= s.lastWebhookID; hookID++ {
+		hook, ok := s.webhooks[hookID]
+		if !ok || !slices.Contains(hook.Events, e.Type) || (e.AppID != 0 && e.AppID != hook.AppID) {
+			continue
+		}
+
+		now := time.Now()
+		s.deliveries = append(s.deliveries, models.WebhookDelivery{
+			ID:            int64(len(s.deliveries) + 1),
+			WebhookID:     hookID,
+			Event:         e.Type,
+			Payload:       e.Payload,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		})
+	}
+
+	return nil
+}
+
+func (s *Storage) WebhookDeliveries(ctx context.Context, webhookID int64, beforeID int64, limit int) ([]models.WebhookDelivery, error) {
+	defer s.lock(ctx)()
+
+	var deliveries []models.WebhookDelivery
+	for i := len(s.deliveries) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		d := s.deliveries[i]
+		if d.WebhookID == webhookID && (beforeID == 0 || d.ID < beforeID) {
+			deliveries = append(deliveries, d)
+		}
+	}
+
+	return deliveries, nil
+}
+
+func (s *Storage) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]models.WebhookDelivery, error) {
+	defer s.lock(ctx)()
+
+	now := time.Now()
+
+	var claimed []models.WebhookDelivery
+	for i := range s.deliveries {
+		d := &s.deliveries[i]
+		if len(claimed) == limit {
+			break
+		}
+		if _, ok := s.webhooks[d.WebhookID]; !ok || !d.DeliveredAt.IsZero() || !d.FailedAt.IsZero() || d.NextAttemptAt.After(now) {
+			continue
+		}
+
+		d.NextAttemptAt = now.Add(lease)
+		claimed = append(claimed, *d)
+	}
+
+	return claimed, nil
+}
+
+func (s *Storage) UpdateWebhookDelivery(ctx context.Context, d models.WebhookDelivery) error {
+	defer s.lock(ctx)()
+
+	if d.ID < 1 || d.ID > int64(len(s.deliveries)) {
+		return nil
+	}
+	s.deliveries[d.ID-1] = d
+
+	return nil
+}
+
+// Ping always succeeds, there is nothing to connect to.
+func (s *Storage) Ping(_ context.Context) error {
+	return nil
+}
+
+// SchemaVersion is 0, the storage has no schema.
+func (s *Storage) SchemaVersion(_ context.Context) (uint, bool, error) {
+	return 0, false, nil
+}
+
+// Migrate does nothing, the storage has no schema.
+func (s *Storage) Migrate(_ fs.FS) (uint, error) {
+	return 0, nil
+}
+
+func (s *Storage) Close() {}
+
+type txKey struct{}
+
+// lock locks the storage for a call, unless ctx is of InTx, which holds the
+// lock already.
+func (s *Storage) lock(ctx context.Context) (unlock func()) {
+	if tx, _ := ctx.Value(txKey{}).(*Storage); tx == s {
+		return func() {}
+	}
+
+	s.mu.Lock()
+
+	return s.mu.Unlock
+}
+
+// InTx runs fn holding the storage locked: the calls made with the ctx passed
+// to fn are rolled back together if fn returns an error. Calls made with
+// another ctx wait for InTx to return. InTx called inside fn joins the
+// transaction.
+func (s *Storage) InTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if tx, _ := ctx.Value(txKey{}).(*Storage); tx == s {
+		return fn(ctx)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	saved := s.state.clone()
+	if err := fn(context.WithValue(ctx, txKey{}, s)); err != nil {
+		s.state = saved
+
+		return err
+	}
+
+	return nil
+}
+
+// clone copies the state deep enough that changes made to s don't show in
+// the copy: values of the maps and slices are replaced, never changed in place.
+func (st *state) clone() state {
+	c := *st
+
+	c.users = maps.Clone(st.users)
+	c.redirects = maps.Clone(st.redirects)
+	c.prefs = make(map[int64]map[string]string, len(st.prefs))
+	for id, prefs := range st.prefs {
+		c.prefs[id] = maps.Clone(prefs)
+	}
+	c.metadata = make(map[int64]map[int]json.RawMessage, len(st.metadata))
+	for id, metadata := range st.metadata {
+		c.metadata[id] = maps.Clone(metadata)
+	}
+	c.apps = maps.Clone(st.apps)
+	c.jtis = maps.Clone(st.jtis)
+	c.otps = maps.Clone(st.otps)
+	c.tokens = maps.Clone(st.tokens)
+	c.refresh = maps.Clone(st.refresh)
+	c.revoked = maps.Clone(st.revoked)
+	c.revokedBy = maps.Clone(st.revokedBy)
+	c.totps = maps.Clone(st.totps)
+	c.passkeys = maps.Clone(st.passkeys)
+	c.recovery = make(map[int64]map[string]bool, len(st.recovery))
+	for id, codes := range st.recovery {
+		c.recovery[id] = maps.Clone(codes)
+	}
+	c.smsMFA = maps.Clone(st.smsMFA)
+	c.codes = maps.Clone(st.codes)
+	c.identities = maps.Clone(st.identities)
+	c.anonymized = maps.Clone(st.anonymized)
+	c.apiKeys = maps.Clone(st.apiKeys)
+	c.apiKeyIDs = maps.Clone(st.apiKeyIDs)
+	c.sessions = maps.Clone(st.sessions)
+	c.revokedSessions = maps.Clone(st.revokedSessions)
+	c.loginAttempts = slices.Clone(st.loginAttempts)
+	c.auditEvents = slices.Clone(st.auditEvents)
+	c.loginFailures = maps.Clone(st.loginFailures)
+	c.lockedUntil = maps.Clone(st.lockedUntil)
+	c.unknownFailures = maps.Clone(st.unknownFailures)
+	c.unknownLocked = maps.Clone(st.unknownLocked)
+	c.roles = maps.Clone(st.roles)
+	c.groups = maps.Clone(st.groups)
+	c.groupMembers = maps.Clone(st.groupMembers)
+	c.orgs = maps.Clone(st.orgs)
+	c.webhooks = maps.Clone(st.webhooks)
+	c.deliveries = slices.Clone(st.deliveries)
+	c.outbox = slices.Clone(st.outbox)
+	c.idempotency = maps.Clone(st.idempotency)
+
+	return c
+}
+
+// ReserveIdempotencyKey takes the key unless it's held by a call in progress
+// or completed and not yet expired; then it returns the record of that call
+// and storage.ErrIdempotencyKeyExists.
+func (s *Storage) ReserveIdempotencyKey(ctx context.Context, scope string, key string, requestHash []byte, lockedUntil time.Time, expiresAt time.Time) (models.IdempotencyRecord, error) {
+	defer s.lock(ctx)()
+
+	now := time.Now()
+
+	k, ok := s.idempotency[[2]string{scope, key}]
+	if ok && k.expiresAt.After(now) && (k.rec.Completed || k.lockedUntil.After(now)) {
+		return k.rec, storage.ErrIdempotencyKeyExists
+	}
+
+	s.idempotency[[2]string{scope, key}] = idempotencyKey{
+		rec:         models.IdempotencyRecord{RequestHash: slices.Clone(requestHash)},
+		lockedUntil: lockedUntil,
+		expiresAt:   expiresAt,
+	}
+
+	return models.IdempotencyRecord{}, nil
+}
+
+func (s *Storage) CompleteIdempotencyKey(ctx context.Context, scope string, key string, rec models.IdempotencyRecord) error {
+	defer s.lock(ctx)()
+
+	k, ok := s.idempotency[[2]string{scope, key}]
+	if !ok {
+		return nil
+	}
+
+	k.rec.Completed = true
+	k.rec.Code = rec.Code
+	k.rec.Message = rec.Message
+	k.rec.Response = slices.Clone(rec.Response)
+	s.idempotency[[2]string{scope, key}] = k
+
+	return nil
+}
+
+func (s *Storage) ReleaseIdempotencyKey(ctx context.Context, scope string, key string) error {
+	defer s.lock(ctx)()
+
+	if k, ok := s.idempotency[[2]string{scope, key}]; ok && !k.rec.Completed {
+		delete(s.idempotency, [2]string{scope, key})
+	}
+
+	return nil
+}
@@ -0,0 +1,383 @@
+package memory_test
+
+import (
+	"context"
+	"errors"
+	"sso/internal/domain/models"
+	"sso/internal/storage"
+	"sso/internal/storage/memory"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUsers(t *testing.T) {
+	ctx := context.Background()
+	s := memory.New()
+
+	id, err := s.SaveUser(ctx, "ann@example.com", []byte("hash"), "user")
+	if err != nil {
+		t.Fatalf("SaveUser() error = %v", err)
+	}
+
+	if _, err := s.SaveUser(ctx, "ann@example.com", []byte("hash"), "user"); !errors.Is(err, storage.ErrUserExists) {
+		t.Errorf("SaveUser() of taken email: error = %v, want %v", err, storage.ErrUserExists)
+	}
+
+	user, err := s.User(ctx, "ann@example.com")
+	if err != nil {
+		t.Fatalf("User() error = %v", err)
+	}
+	if user.ID != id || string(user.PassHash) != "hash" || user.Role != "user" || user.Status != models.UserStatusActive {
+		t.Errorf("User() = %+v", user)
+	}
+
+	if err := s.UpdateRole(ctx, id, "admin"); err != nil {
+		t.Fatalf("UpdateRole() error = %v", err)
+	}
+	if role, err := s.GetUserRole(ctx, id); err != nil || role != "admin" {
+		t.Errorf("GetUserRole() = %q, %v, want admin", role, err)
+	}
+
+	if _, err := s.User(ctx, "bob@example.com"); !errors.Is(err, storage.ErrUserNotFound) {
+		t.Errorf("User() of unknown email: error = %v, want %v", err, storage.ErrUserNotFound)
+	}
+	if err := s.UpdateRole(ctx, id+1, "admin"); !errors.Is(err, storage.ErrUserNotFound) {
+		t.Errorf("UpdateRole() of unknown user: error = %v, want %v", err, storage.ErrUserNotFound)
+	}
+}
+
+func TestSaveUserConcurrent(t *testing.T) {
+	ctx := context.Background()
+	s := memory.New()
+
+	// Уникальность email держится и при одновременной регистрации
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		created int
+	)
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if _, err := s.SaveUser(ctx, "ann@example.com", []byte("hash"), "user"); err == nil {
+				mu.Lock()
+				created++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if created != 1 {
+		t.Errorf("created %d users with the same email, want 1", created)
+	}
+}
+
+func TestInTx(t *testing.T) {
+	ctx := context.Background()
+	errAbort := errors.New("abort")
+
+	t.Run("rollback", func(t *testing.T) {
+		s := memory.New()
+
+		existing, err := s.SaveUser(ctx, "ann@example.com", []byte("hash"), "user")
+		if err != nil {
+			t.Fatalf("SaveUser() error = %v", err)
+		}
+
+		err = s.InTx(ctx, func(ctx context.Context) error {
+			if _, err := s.SaveUser(ctx, "bob@example.com", []byte("hash"), "user"); err != nil {
+				return err
+			}
+			if err := s.UpdateRole(ctx, existing, "admin"); err != nil {
+				return err
+			}
+			if err := s.SetPreference(ctx, existing, "theme", "dark"); err != nil {
+				return err
+			}
+
+			return errAbort
+		})
+		if !errors.Is(err, errAbort) {
+			t.Fatalf("InTx() error = %v, want %v", err, errAbort)
+		}
+
+		if _, err := s.User(ctx, "bob@example.com"); !errors.Is(err, storage.ErrUserNotFound) {
+			t.Errorf("User() after rollback: error = %v, want %v", err, storage.ErrUserNotFound)
+		}
+		if role, _ := s.GetUserRole(ctx, existing); role != "user" {
+			t.Errorf("role after rollback = %q, want %q", role, "user")
+		}
+		if prefs, _ := s.Preferences(ctx, existing); len(prefs) != 0 {
+			t.Errorf("preferences after rollback = %v, want none", prefs)
+		}
+
+		// Событие о регистрации откатывается вместе с пользователем
+		events, err := s.ClaimOutboxEvents(ctx, 10, time.Minute)
+		if err != nil || len(events) != 1 {
+			t.Errorf("ClaimOutboxEvents() = %d events, %v, want only the one of ann", len(events), err)
+		}
+	})
+
+	t.Run("commit", func(t *testing.T) {
+		s := memory.New()
+
+		err := s.InTx(ctx, func(ctx context.Context) error {
+			_, err := s.SaveUser(ctx, "ann@example.com", []byte("hash"), "user")
+
+			return err
+		})
+		if err != nil {
+			t.Fatalf("InTx() error = %v", err)
+		}
+
+		if _, err := s.User(ctx, "ann@example.com"); err != nil {
+			t.Errorf("User() after commit: error = %v", err)
+		}
+	})
+
+	t.Run("nested", func(t *testing.T) {
+		s := memory.New()
+
+		err := s.InTx(ctx, func(ctx context.Context) error {
+			// Вложенный InTx присоединяется к внешнему, а не ждёт его блокировку
+			err := s.InTx(ctx, func(ctx context.Context) error {
+				_, err := s.SaveUser(ctx, "ann@example.com", []byte("hash"), "user")
+
+				return err
+			})
+			if err != nil {
+				return err
+			}
+
+			return errAbort
+		})
+		if !errors.Is(err, errAbort) {
+			t.Fatalf("InTx() error = %v, want %v", err, errAbort)
+		}
+
+		if _, err := s.User(ctx, "ann@example.com"); !errors.Is(err, storage.ErrUserNotFound) {
+			t.Errorf("User() after outer rollback: error = %v, want %v", err, storage.ErrUserNotFound)
+		}
+	})
+
+	t.Run("isolation", func(t *testing.T) {
+		s := memory.New()
+
+		inside := make(chan struct{})
+		done := make(chan error)
+
+		go func() {
+			done <- s.InTx(ctx, func(ctx context.Context) error {
+				if _, err := s.SaveUser(ctx, "ann@example.com", []byte("hash"), "user"); err != nil {
+					return err
+				}
+				close(inside)
+				time.Sleep(50 * time.Millisecond)
+
+				return errAbort
+			})
+		}()
+
+		<-inside
+		// Вызов с другим ctx ждёт конца транзакции и не видит её изменений
+		if _, err := s.User(ctx, "ann@example.com"); !errors.Is(err, storage.ErrUserNotFound) {
+			t.Errorf("User() during rolled back tx: error = %v, want %v", err, storage.ErrUserNotFound)
+		}
+		if err := <-done; !errors.Is(err, errAbort) {
+			t.Errorf("InTx() error = %v, want %v", err, errAbort)
+		}
+	})
+}
+
+func TestUseJTI(t *testing.T) {
+	ctx := context.Background()
+	s := memory.New()
+
+	if err := s.UseJTI(ctx, "jti", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("UseJTI() error = %v", err)
+	}
+	if err := s.UseJTI(ctx, "jti", time.Now().Add(time.Hour)); !errors.Is(err, storage.ErrJTIUsed) {
+		t.Errorf("UseJTI() again: error = %v, want %v", err, storage.ErrJTIUsed)
+	}
+
+	if err := s.UseJTI(ctx, "expired", time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("UseJTI() error = %v", err)
+	}
+	if err := s.UseJTI(ctx, "expired", time.Now().Add(time.Hour)); err != nil {
+		t.Errorf("UseJTI() of expired jti: error = %v", err)
+	}
+}
+
+func TestConsumeOneTimeToken(t *testing.T) {
+	ctx := context.Background()
+	s := memory.New()
+
+	save := func(hash string, ttl time.Duration) {
+		t.Helper()
+
+		token := models.OneTimeToken{Hash: []byte(hash), Purpose: "reset", Subject: "1", ExpiresAt: time.Now().Add(ttl)}
+		if err := s.SaveOneTimeToken(ctx, token); err != nil {
+			t.Fatalf("SaveOneTimeToken() error = %v", err)
+		}
+	}
+	save("valid", time.Hour)
+	save("expired", -time.Minute)
+	save("other purpose", time.Hour)
+
+	tests := []struct {
+		name    string
+		hash    string
+		purpose string
+		wantErr error
+	}{
+		{name: "valid", hash: "valid", purpose: "reset"},
+		{name: "consumed", hash: "valid", purpose: "reset", wantErr: storage.ErrTokenNotFound},
+		{name: "expired", hash: "expired", purpose: "reset", wantErr: storage.ErrTokenNotFound},
+		// Токен одного назначения не подходит для другого
+		{name: "other purpose", hash: "other purpose", purpose: "verify", wantErr: storage.ErrTokenNotFound},
+		{name: "unknown", hash: "unknown", purpose: "reset", wantErr: storage.ErrTokenNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := s.ConsumeOneTimeToken(ctx, []byte(tt.hash), tt.purpose)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ConsumeOneTimeToken() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && token.Subject != "1" {
+				t.Errorf("ConsumeOneTimeToken() = %+v", token)
+			}
+		})
+	}
+}
+
+func TestConsumeAuthorizationCode(t *testing.T) {
+	ctx := context.Background()
+	s := memory.New()
+
+	userID, err := s.SaveUser(ctx, "ann@example.com", []byte("hash"), "user")
+	if err != nil {
+		t.Fatalf("SaveUser() error = %v", err)
+	}
+
+	code := models.AuthorizationCode{Hash: []byte("code"), AppID: 1, UserID: userID, ExpiresAt: time.Now().Add(time.Minute)}
+	if err := s.SaveAuthorizationCode(ctx, code); err != nil {
+		t.Fatalf("SaveAuthorizationCode() error = %v", err)
+	}
+
+	if got, err := s.ConsumeAuthorizationCode(ctx, code.Hash); err != nil || got.UserID != userID {
+		t.Fatalf("ConsumeAuthorizationCode() = %+v, %v", got, err)
+	}
+	if _, err := s.ConsumeAuthorizationCode(ctx, code.Hash); !errors.Is(err, storage.ErrTokenNotFound) {
+		t.Errorf("ConsumeAuthorizationCode() again: error = %v, want %v", err, storage.ErrTokenNotFound)
+	}
+
+	expired := code
+	expired.Hash = []byte("expired")
+	expired.ExpiresAt = time.Now().Add(-time.Minute)
+	if err := s.SaveAuthorizationCode(ctx, expired); err != nil {
+		t.Fatalf("SaveAuthorizationCode() error = %v", err)
+	}
+	if _, err := s.ConsumeAuthorizationCode(ctx, expired.Hash); !errors.Is(err, storage.ErrTokenNotFound) {
+		t.Errorf("ConsumeAuthorizationCode() of expired code: error = %v, want %v", err, storage.ErrTokenNotFound)
+	}
+
+	unknownUser := code
+	unknownUser.UserID = userID + 1
+	if err := s.SaveAuthorizationCode(ctx, unknownUser); !errors.Is(err, storage.ErrUserNotFound) {
+		t.Errorf("SaveAuthorizationCode() of unknown user: error = %v, want %v", err, storage.ErrUserNotFound)
+	}
+}
+
+func TestUseRefreshToken(t *testing.T) {
+	ctx := context.Background()
+	s := memory.New()
+
+	for hash, ttl := range map[string]time.Duration{"valid": time.Hour, "expired": -time.Minute} {
+		if err := s.SaveRefreshToken(ctx, models.RefreshToken{Hash: []byte(hash), UserID: 1, ExpiresAt: time.Now().Add(ttl)}); err != nil {
+			t.Fatalf("SaveRefreshToken() error = %v", err)
+		}
+	}
+
+	if _, err := s.UseRefreshToken(ctx, []byte("valid")); err != nil {
+		t.Fatalf("UseRefreshToken() error = %v", err)
+	}
+	// Токен одноразовый: повтор означает, что он украден
+	if _, err := s.UseRefreshToken(ctx, []byte("valid")); !errors.Is(err, storage.ErrTokenNotFound) {
+		t.Errorf("UseRefreshToken() again: error = %v, want %v", err, storage.ErrTokenNotFound)
+	}
+	if _, err := s.UseRefreshToken(ctx, []byte("expired")); !errors.Is(err, storage.ErrTokenNotFound) {
+		t.Errorf("UseRefreshToken() of expired token: error = %v, want %v", err, storage.ErrTokenNotFound)
+	}
+}
+
+func TestUseTOTPStep(t *testing.T) {
+	ctx := context.Background()
+	s := memory.New()
+
+	userID, err := s.SaveUser(ctx, "ann@example.com", []byte("hash"), "user")
+	if err != nil {
+		t.Fatalf("SaveUser() error = %v", err)
+	}
+
+	if err := s.SaveTOTP(ctx, userID, []byte("secret")); err != nil {
+		t.Fatalf("SaveTOTP() error = %v", err)
+	}
+
+	for i, tt := range []struct {
+		step    int64
+		wantErr error
+	}{
+		{step: 100},
+		{step: 100, wantErr: storage.ErrTOTPStepUsed},
+		// Код предыдущего шага после использования следующего тоже отклоняется
+		{step: 99, wantErr: storage.ErrTOTPStepUsed},
+		{step: 101},
+	} {
+		if err := s.UseTOTPStep(ctx, userID, tt.step); !errors.Is(err, tt.wantErr) {
+			t.Errorf("UseTOTPStep() #%d of step %d: error = %v, want %v", i, tt.step, err, tt.wantErr)
+		}
+	}
+
+	if err := s.UseTOTPStep(ctx, userID+1, 100); !errors.Is(err, storage.ErrTOTPStepUsed) {
+		t.Errorf("UseTOTPStep() without totp: error = %v, want %v", err, storage.ErrTOTPStepUsed)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	ctx := context.Background()
+	s := memory.New()
+
+	userID, err := s.SaveUser(ctx, "ann@example.com", []byte("hash"), "user")
+	if err != nil {
+		t.Fatalf("SaveUser() error = %v", err)
+	}
+
+	id, err := s.SaveSession(ctx, models.Session{UserID: userID, AppID: 1, IP: "192.0.2.1", ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("SaveSession() error = %v", err)
+	}
+
+	sessions, err := s.Sessions(ctx, userID)
+	if err != nil || len(sessions) != 1 || sessions[0].ID != id {
+		t.Fatalf("Sessions() = %+v, %v, want session %d", sessions, err, id)
+	}
+
+	if err := s.RevokeSession(ctx, userID+1, id); !errors.Is(err, storage.ErrSessionNotFound) {
+		t.Errorf("RevokeSession() of another user: error = %v, want %v", err, storage.ErrSessionNotFound)
+	}
+	if err := s.RevokeSession(ctx, userID, id); err != nil {
+		t.Fatalf("RevokeSession() error = %v", err)
+	}
+
+	if sessions, err := s.Sessions(ctx, userID); err != nil || len(sessions) != 0 {
+		t.Errorf("Sessions() after revoke = %+v, %v, want none", sessions, err)
+	}
+	if revoked, err := s.TokenRevoked(ctx, userID, id, "jti", time.Now()); err != nil || !revoked {
+		t.Errorf("TokenRevoked() of revoked session = %v, %v, want true", revoked, err)
+	}
+}
@@ -0,0 +1,3462 @@
+// Package mysql is the storage on MySQL 8.0 or MariaDB 10.6 and later, an
+// alternative to the postgres package with the same behavior. Its schema is
+// in migrations/mysql.
+package mysql
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+	"sso/internal/domain/models"
+	"sso/internal/storage"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/golang-migrate/migrate/v4"
+	mysqlmigrate "github.com/golang-migrate/migrate/v4/database/mysql"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+)
+
+// Error numbers of the server, see
+// https://dev.mysql.com/doc/mysql-errors/8.0/en/server-error-reference.html
+const (
+	errDupEntry        = 1062
+	errNoSuchTable     = 1146
+	errRowIsReferenced = 1451
+	errNoReferencedRow = 1452
+)
+
+// resolveUserID follows the redirect left by MergeUsers for the user id
+// passed twice.
+const resolveUserID = `COALESCE((SELECT new_id FROM user_redirects WHERE old_id = ?), ?)`
+
+// userColumns are selected by every query returning models.User, see scanUser.
+const userColumns = `id, COALESCE(email, ''), COALESCE(username, ''), COALESCE(phone, ''), phone_verified,
+	COALESCE(avatar_url, ''), pass_hash, role, created_at, last_login_at, COALESCE(org_id, 0), deleted_at, status`
+
+type Storage struct {
+	db  *sql.DB
+	cfg *mysql.Config
+}
+
+// scanner is *sql.Row or *sql.Rows.
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// New connects to the database at dsn of go-sql-driver/mysql:
+// user:password@tcp(host:3306)/sso.
+func New(dsn string) (*Storage, error) {
+	const op = "storage.mysql.New"
+
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid dsn: %w", op, err)
+	}
+
+	// Время храним в UTC, как timestamptz в Postgres
+	cfg.ParseTime = true
+	cfg.Loc = time.UTC
+	if cfg.Params == nil {
+		cfg.Params = map[string]string{}
+	}
+	cfg.Params["time_zone"] = "'+00:00'"
+	// RowsAffected считает найденные строки, а не изменённые, как в Postgres
+	cfg.ClientFoundRows = true
+
+	connector, err := mysql.NewConnector(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid dsn: %w", op, err)
+	}
+
+	db := sql.OpenDB(connector)
+	if err := db.Ping(); err != nil {
+		db.Close()
+
+		return nil, fmt.Errorf("%s: cannot connect to db: %w", op, err)
+	}
+
+	return &Storage{db: db, cfg: cfg}, nil
+}
+
+func (s *Storage) Close() {
+	s.db.Close()
+}
+
+// Ping checks that the database is reachable.
+func (s *Storage) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// SchemaVersion returns the version of the last migration applied by
+// golang-migrate and whether it failed halfway. Zero means no migrations yet.
+func (s *Storage) SchemaVersion(ctx context.Context) (version uint, dirty bool, err error) {
+	const op = "storage.mysql.SchemaVersion"
+
+	err = s.conn(ctx).QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) || isErr(err, errNoSuchTable) {
+			return 0, false, nil
+		}
+
+		return 0, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return version, dirty, nil
+}
+
+// Migrator returns the golang-migrate instance applying migrations of source
+// to the database. Instances migrating at once wait for each other on a
+// named lock. It must be closed.
+func (s *Storage) Migrator(source fs.FS) (*migrate.Migrate, error) {
+	const op = "storage.mysql.Migrator"
+
+	src, err := iofs.New(source, ".")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Миграция может состоять из нескольких запросов, для сервиса это отключено
+	cfg := s.cfg.Clone()
+	cfg.MultiStatements = true
+
+	connector, err := mysql.NewConnector(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Закрывается вместе с migrate.Migrate
+	driver, err := mysqlmigrate.WithInstance(sql.OpenDB(connector), &mysqlmigrate.Config{})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	m, err := migrate.NewWithInstance("iofs", src, "mysql", driver)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return m, nil
+}
+
+// Migrate applies the up migrations of source the database doesn't have yet
+// and returns the resulting version.
+func (s *Storage) Migrate(source fs.FS) (uint, error) {
+	const op = "storage.mysql.Migrate"
+
+	m, err := s.Migrator(source)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	defer m.Close()
+
+	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	version, _, err := m.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return version, nil
+}
+
+func (s *Storage) SaveUser(
+	ctx context.Context,
+	email string,
+	passHash []byte,
+	role string,
+) (int64, error) {
+	const op = "storage.mysql.SaveUser"
+
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		`INSERT INTO users(email, pass_hash, role, metadata) VALUES (?, ?, ?, '{}')`,
+		email, passHash, role,
+	)
+	if err != nil {
+		if isErr(err, errDupEntry) {
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrUserExists)
+		}
+
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := enqueueEvent(ctx, tx, models.EventUserRegistered, models.EventUser{UserID: id, Email: email, Role: role}); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return id, nil
+}
+
+// SavePhoneUser creates user identified by phone number instead of email.
+func (s *Storage) SavePhoneUser(
+	ctx context.Context,
+	phone string,
+	passHash []byte,
+	role string,
+) (int64, error) {
+	const op = "storage.mysql.SavePhoneUser"
+
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		`INSERT INTO users(phone, pass_hash, role, metadata) VALUES (?, ?, ?, '{}')`,
+		phone, passHash, role,
+	)
+	if err != nil {
+		if isErr(err, errDupEntry) {
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrUserExists)
+		}
+
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := enqueueEvent(ctx, tx, models.EventUserRegistered, models.EventUser{UserID: id, Phone: phone, Role: role}); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return id, nil
+}
+
+func (s *Storage) User(ctx context.Context, email string) (models.User, error) {
+	const op = "storage.mysql.User"
+
+	user, err := scanUser(s.conn(ctx).QueryRowContext(ctx,
+		`SELECT `+userColumns+` FROM users WHERE email = ? AND deleted_at IS NULL`,
+		email,
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+
+		return user, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return user, nil
+}
+
+func (s *Storage) UserByID(ctx context.Context, userID int64) (models.User, error) {
+	const op = "storage.mysql.User"
+
+	user, err := scanUser(s.conn(ctx).QueryRowContext(ctx,
+		`SELECT `+userColumns+` FROM users WHERE id = `+resolveUserID,
+		userID, userID,
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+
+		return user, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return user, nil
+}
+
+// UserByIdentity returns user linked to the account at an external identity provider.
+func (s *Storage) UserByIdentity(ctx context.Context, provider string, subject string) (models.User, error) {
+	const op = "storage.mysql.UserByIdentity"
+
+	user, err := scanUser(s.conn(ctx).QueryRowContext(ctx,
+		`SELECT `+userColumns+` FROM users
+			WHERE id = (SELECT user_id FROM user_identities WHERE provider = ? AND subject = ?)
+				AND deleted_at IS NULL`,
+		provider, subject,
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+
+		return user, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return user, nil
+}
+
+// SaveIdentity links the account at an external identity provider to the user.
+func (s *Storage) SaveIdentity(ctx context.Context, userID int64, provider string, subject string) error {
+	const op = "storage.mysql.SaveIdentity"
+
+	_, err := s.conn(ctx).ExecContext(ctx,
+		`INSERT INTO user_identities(provider, subject, user_id) VALUES (?, ?, ?)`,
+		provider, subject, userID,
+	)
+	if err != nil {
+		switch {
+		case isErr(err, errDupEntry):
+			return fmt.Errorf("%s: %w", op, storage.ErrIdentityExists)
+		case isErr(err, errNoReferencedRow):
+			return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// UserByUsername returns user by its public handle.
+func (s *Storage) UserByUsername(ctx context.Context, username string) (models.User, error) {
+	const op = "storage.mysql.UserByUsername"
+
+	user, err := scanUser(s.conn(ctx).QueryRowContext(ctx,
+		`SELECT `+userColumns+` FROM users WHERE username = ? AND deleted_at IS NULL`,
+		username,
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+
+		return models.User{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return user, nil
+}
+
+// SetUsername sets or clears (empty username) the public handle of the user.
+func (s *Storage) SetUsername(ctx context.Context, userID int64, username string) error {
+	const op = "storage.mysql.SetUsername"
+
+	res, err := s.conn(ctx).ExecContext(ctx,
+		`UPDATE users SET username = NULLIF(?, '') WHERE id = ?`, username, userID,
+	)
+	if err != nil {
+		if isErr(err, errDupEntry) {
+			return fmt.Errorf("%s: %w", op, storage.ErrUsernameTaken)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := expectRow(res, storage.ErrUserNotFound); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// UserByPhone returns user by E.164 phone number. Unverified numbers may be
+// set on several users; the one who verified the number comes first.
+func (s *Storage) UserByPhone(ctx context.Context, phone string) (models.User, error) {
+	const op = "storage.mysql.UserByPhone"
+
+	user, err := scanUser(s.conn(ctx).QueryRowContext(ctx,
+		`SELECT `+userColumns+` FROM users WHERE phone = ? AND deleted_at IS NULL
+			ORDER BY phone_verified DESC, id LIMIT 1`,
+		phone,
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+
+		return models.User{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return user, nil
+}
+
+// SetPhone sets a new, not yet verified, phone number of the user.
+// Unverified numbers don't reserve them, see MarkPhoneVerified.
+func (s *Storage) SetPhone(ctx context.Context, userID int64, phone string) error {
+	const op = "storage.mysql.SetPhone"
+
+	res, err := s.conn(ctx).ExecContext(ctx,
+		`UPDATE users SET phone = ?, phone_verified = FALSE WHERE id = ?`, phone, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := expectRow(res, storage.ErrUserNotFound); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// MarkPhoneVerified marks phone of the user as verified, if it is still the
+// given one, and takes the number from other users who haven't verified it.
+// It fails with storage.ErrPhoneTaken if another user has verified it.
+func (s *Storage) MarkPhoneVerified(ctx context.Context, userID int64, phone string) error {
+	const op = "storage.mysql.MarkPhoneVerified"
+
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		`UPDATE users SET phone_verified = TRUE WHERE id = ? AND phone = ?`, userID, phone,
+	)
+	if err != nil {
+		if isErr(err, errDupEntry) {
+			return fmt.Errorf("%s: %w", op, storage.ErrPhoneTaken)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := expectRow(res, storage.ErrUserNotFound); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE users SET phone = NULL WHERE phone = ? AND NOT phone_verified`, phone,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// SetAvatarURL sets or clears (empty url) the avatar of the user.
+func (s *Storage) SetAvatarURL(ctx context.Context, userID int64, url string) error {
+	const op = "storage.mysql.SetAvatarURL"
+
+	res, err := s.conn(ctx).ExecContext(ctx,
+		`UPDATE users SET avatar_url = NULLIF(?, '') WHERE id = ?`, url, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := expectRow(res, storage.ErrUserNotFound); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// UpdatePassHash replaces the password hash of the user.
+func (s *Storage) UpdatePassHash(ctx context.Context, userID int64, passHash []byte) error {
+	const op = "storage.mysql.UpdatePassHash"
+
+	res, err := s.conn(ctx).ExecContext(ctx, `UPDATE users SET pass_hash = ? WHERE id = ?`, passHash, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := expectRow(res, storage.ErrUserNotFound); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// Preferences returns all preferences of the user.
+func (s *Storage) Preferences(ctx context.Context, userID int64) (map[string]string, error) {
+	const op = "storage.mysql.Preferences"
+
+	rows, err := s.conn(ctx).QueryContext(ctx,
+		"SELECT `key`, value FROM user_preferences WHERE user_id = ?", userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	prefs := make(map[string]string)
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		prefs[key] = value
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return prefs, nil
+}
+
+// SetPreference sets preference of the user; empty value removes it.
+func (s *Storage) SetPreference(ctx context.Context, userID int64, key string, value string) error {
+	const op = "storage.mysql.SetPreference"
+
+	if value == "" {
+		if _, err := s.conn(ctx).ExecContext(ctx,
+			"DELETE FROM user_preferences WHERE user_id = ? AND `key` = ?", userID, key,
+		); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		return nil
+	}
+
+	_, err := s.conn(ctx).ExecContext(ctx,
+		"INSERT INTO user_preferences(user_id, `key`, value) VALUES (?, ?, ?)"+
+			` ON DUPLICATE KEY UPDATE value = VALUES(value), updated_at = CURRENT_TIMESTAMP(6)`,
+		userID, key, value,
+	)
+	if err != nil {
+		if isErr(err, errNoReferencedRow) {
+			return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// UserMetadata returns the metadata object the app attached to the user, "{}" if none.
+func (s *Storage) UserMetadata(ctx context.Context, userID int64, appID int) (json.RawMessage, error) {
+	const op = "storage.mysql.UserMetadata"
+
+	var data []byte
+
+	err := s.conn(ctx).QueryRowContext(ctx,
+		`SELECT COALESCE(JSON_EXTRACT(metadata, ?), JSON_OBJECT()) FROM users WHERE id = ?`, metadataPath(appID), userID,
+	).Scan(&data)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return data, nil
+}
+
+// SetUserMetadata replaces the metadata object of the app on the user; nil data removes it.
+func (s *Storage) SetUserMetadata(ctx context.Context, userID int64, appID int, data json.RawMessage) error {
+	const op = "storage.mysql.SetUserMetadata"
+
+	query := `UPDATE users SET metadata = JSON_SET(metadata, ?, CAST(? AS JSON)) WHERE id = ?`
+	args := []any{metadataPath(appID), string(data), userID}
+	if data == nil {
+		query = `UPDATE users SET metadata = JSON_REMOVE(metadata, ?) WHERE id = ?`
+		args = []any{metadataPath(appID), userID}
+	}
+
+	res, err := s.conn(ctx).ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := expectRow(res, storage.ErrUserNotFound); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// metadataPath is the JSON path of the app's object in users.metadata.
+func metadataPath(appID int) string {
+	return fmt.Sprintf(`$."%d"`, appID)
+}
+
+// UserExists reports whether a user with the email is registered.
+func (s *Storage) UserExists(ctx context.Context, email string) (bool, error) {
+	const op = "storage.mysql.UserExists"
+
+	var exists bool
+
+	err := s.conn(ctx).QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM users WHERE email = ?)`, email,
+	).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return exists, nil
+}
+
+func (s *Storage) CountUsers(ctx context.Context, filter models.UserFilter) (int64, error) {
+	const op = "storage.mysql.CountUsers"
+
+	where, args := userWhere(filter)
+
+	var count int64
+
+	if err := s.conn(ctx).QueryRowContext(ctx, `SELECT COUNT(*) FROM users`+where, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return count, nil
+}
+
+// TouchLogin records successful login time of the user.
+func (s *Storage) TouchLogin(ctx context.Context, userID int64) error {
+	const op = "storage.mysql.TouchLogin"
+
+	if _, err := s.conn(ctx).ExecContext(ctx,
+		`UPDATE users SET last_login_at = CURRENT_TIMESTAMP(6) WHERE id = ?`, userID,
+	); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) UpdateRole(ctx context.Context, userID int64, role string) error {
+	const op = "storage.mysql.UpdateUserRole"
+
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		`UPDATE users SET role = ? WHERE id = ?`, role, userID,
+	)
+	if err != nil {
+		if isErr(err, errNoReferencedRow) {
+			return fmt.Errorf("%s: %w", op, storage.ErrRoleNotFound)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := expectRow(res, storage.ErrUserNotFound); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := enqueueEvent(ctx, tx, models.EventUserRoleChanged, models.EventUser{UserID: userID, Role: role}); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// roleColumns are selected by every query returning models.Role, see scanRole.
+const roleColumns = "r.name, r.description, r.`rank`, r.self_assignable, r.created_at," +
+	` (SELECT JSON_ARRAYAGG(permission) FROM role_permissions WHERE role = r.name)`
+
+// Role returns the role with its permissions.
+func (s *Storage) Role(ctx context.Context, name string) (models.Role, error) {
+	const op = "storage.mysql.Role"
+
+	role, err := scanRole(s.conn(ctx).QueryRowContext(ctx,
+		`SELECT `+roleColumns+` FROM roles r WHERE r.name = ?`, name,
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Role{}, fmt.Errorf("%s: %w", op, storage.ErrRoleNotFound)
+		}
+
+		return models.Role{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return role, nil
+}
+
+// Roles returns all roles, most privileged first.
+func (s *Storage) Roles(ctx context.Context) ([]models.Role, error) {
+	const op = "storage.mysql.Roles"
+
+	rows, err := s.conn(ctx).QueryContext(ctx,
+		`SELECT `+roleColumns+" FROM roles r ORDER BY r.`rank` DESC, r.name",
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var roles []models.Role
+	for rows.Next() {
+		role, err := scanRole(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		roles = append(roles, role)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return roles, nil
+}
+
+// SaveRole creates the role with its permissions.
+func (s *Storage) SaveRole(ctx context.Context, role models.Role) error {
+	const op = "storage.mysql.SaveRole"
+
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO roles(name, description, `rank`, self_assignable) VALUES (?, ?, ?, ?)",
+		role.Name, role.Description, role.Rank, role.SelfAssignable,
+	)
+	if err != nil {
+		if isErr(err, errDupEntry) {
+			return fmt.Errorf("%s: %w", op, storage.ErrRoleExists)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := setPermissions(ctx, tx, role.Name, role.Permissions); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// EditRole replaces the description, rank, self-assignability and permissions of the role.
+func (s *Storage) EditRole(ctx context.Context, role models.Role) error {
+	const op = "storage.mysql.EditRole"
+
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		"UPDATE roles SET description = ?, `rank` = ?, self_assignable = ? WHERE name = ?",
+		role.Description, role.Rank, role.SelfAssignable, role.Name,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if err := expectRow(res, storage.ErrRoleNotFound); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM role_permissions WHERE role = ?`, role.Name); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := setPermissions(ctx, tx, role.Name, role.Permissions); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func setPermissions(ctx context.Context, tx *txn, role string, permissions []string) error {
+	if len(permissions) == 0 {
+		return nil
+	}
+
+	args := make([]any, 0, 2*len(permissions))
+	for _, p := range permissions {
+		args = append(args, role, p)
+	}
+
+	_, err := tx.ExecContext(ctx,
+		`INSERT IGNORE INTO role_permissions(role, permission) VALUES `+placeholders(len(permissions), "(?, ?)"),
+		args...,
+	)
+
+	return err
+}
+
+// DeleteRole deletes the role unless it is assigned to users or api keys.
+func (s *Storage) DeleteRole(ctx context.Context, name string) error {
+	const op = "storage.mysql.DeleteRole"
+
+	res, err := s.conn(ctx).ExecContext(ctx, `DELETE FROM roles WHERE name = ?`, name)
+	if err != nil {
+		if isErr(err, errRowIsReferenced) {
+			return fmt.Errorf("%s: %w", op, storage.ErrRoleInUse)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := expectRow(res, storage.ErrRoleNotFound); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// appColumns are selected by every query returning models.App, see scanApp.
+const appColumns = `id, name, secret, redirect_uris, public, scopes, COALESCE(org_id, 0), created_at,
+	COALESCE(previous_secret, ''), previous_secret_expires_at, token_ttl_seconds, audience, COALESCE(client_cert_subject, '')`
+
+func (s *Storage) App(ctx context.Context, appID int) (models.App, error) {
+	const op = "storage.mysql.App"
+
+	app, err := scanApp(s.conn(ctx).QueryRowContext(ctx, `SELECT `+appColumns+` FROM apps WHERE id = ?`, appID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.App{}, fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+		}
+
+		return app, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return app, nil
+}
+
+// AppByCertSubject returns the app whose client certificate has the identity.
+func (s *Storage) AppByCertSubject(ctx context.Context, subject string) (models.App, error) {
+	const op = "storage.mysql.AppByCertSubject"
+
+	app, err := scanApp(s.conn(ctx).QueryRowContext(ctx, `SELECT `+appColumns+` FROM apps WHERE client_cert_subject = ?`, subject))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.App{}, fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+		}
+
+		return app, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return app, nil
+}
+
+// Apps returns apps of the organization, or all apps if orgID is zero, ordered by id.
+func (s *Storage) Apps(ctx context.Context, orgID int64) ([]models.App, error) {
+	const op = "storage.mysql.Apps"
+
+	rows, err := s.conn(ctx).QueryContext(ctx,
+		`SELECT `+appColumns+` FROM apps WHERE ? = 0 OR org_id = ? ORDER BY id`, orgID, orgID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var apps []models.App
+	for rows.Next() {
+		app, err := scanApp(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		apps = append(apps, app)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return apps, nil
+}
+
+// SaveApp registers the app and returns its id.
+func (s *Storage) SaveApp(ctx context.Context, app models.App) (int, error) {
+	const op = "storage.mysql.SaveApp"
+
+	res, err := s.conn(ctx).ExecContext(ctx,
+		`INSERT INTO apps(name, secret, redirect_uris, public, scopes, org_id, token_ttl_seconds, audience, client_cert_subject)
+			VALUES (?, ?, ?, ?, ?, NULLIF(?, 0), ?, ?, NULLIF(?, ''))`,
+		app.Name, app.Secret, jsonArray(app.RedirectURIs), app.Public, jsonArray(app.Scopes), app.OrgID,
+		int(app.TokenTTL.Seconds()), app.Audience, app.CertSubject,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, appErr(err))
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return int(id), nil
+}
+
+// UpdateApp changes settings of the app. The secret and organization stay.
+func (s *Storage) UpdateApp(ctx context.Context, app models.App) error {
+	const op = "storage.mysql.UpdateApp"
+
+	res, err := s.conn(ctx).ExecContext(ctx,
+		`UPDATE apps SET name = ?, redirect_uris = ?, public = ?, scopes = ?, token_ttl_seconds = ?, audience = ?,
+			client_cert_subject = NULLIF(?, '') WHERE id = ?`,
+		app.Name, jsonArray(app.RedirectURIs), app.Public, jsonArray(app.Scopes), int(app.TokenTTL.Seconds()), app.Audience,
+		app.CertSubject, app.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, appErr(err))
+	}
+
+	if err := expectRow(res, storage.ErrAppNotFound); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// RotateAppSecret replaces the secret of the app, keeping the current one as
+// the previous secret until previousExpiresAt.
+func (s *Storage) RotateAppSecret(ctx context.Context, appID int, secret string, previousExpiresAt time.Time) error {
+	const op = "storage.mysql.RotateAppSecret"
+
+	// MySQL присваивает по порядку: previous_secret получает ещё старый secret
+	res, err := s.conn(ctx).ExecContext(ctx,
+		`UPDATE apps SET previous_secret = secret, previous_secret_expires_at = ?, secret = ? WHERE id = ?`,
+		previousExpiresAt, secret, appID,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, appErr(err))
+	}
+
+	if err := expectRow(res, storage.ErrAppNotFound); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// DeleteApp removes the app with its API keys and authorization codes.
+func (s *Storage) DeleteApp(ctx context.Context, appID int) error {
+	const op = "storage.mysql.DeleteApp"
+
+	res, err := s.conn(ctx).ExecContext(ctx, `DELETE FROM apps WHERE id = ?`, appID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := expectRow(res, storage.ErrAppNotFound); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// appErr maps unique violations of app name, secret or certificate subject to storage.ErrAppExists
+// and a missing organization to storage.ErrOrgNotFound.
+func appErr(err error) error {
+	switch {
+	case isErr(err, errDupEntry):
+		return storage.ErrAppExists
+	case isErr(err, errNoReferencedRow):
+		return storage.ErrOrgNotFound
+	}
+
+	return err
+}
+
+func (s *Storage) GetUserRole(ctx context.Context, userID int64) (string, error) {
+	const op = "storage.mysql.GetUserRole"
+	var role string
+
+	err := s.conn(ctx).QueryRowContext(ctx, `SELECT role FROM users WHERE id = `+resolveUserID, userID, userID).Scan(&role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return role, nil
+}
+
+// ListUsers returns up to limit users matching the filter after the cursor
+// in the order of sort; zero limit returns all of them, see the postgres
+// storage for the paging.
+func (s *Storage) ListUsers(ctx context.Context, filter models.UserFilter, sort models.UserSort, after models.UserCursor, limit int) ([]models.User, error) {
+	const op = "storage.mysql.ListUsers"
+
+	order, err := orderBy(sort)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	where, args := userWhere(filter)
+
+	if after.ID != 0 {
+		cond, condArgs, err := userKeyset(sort, after)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		if where == "" {
+			where = ` WHERE ` + cond
+		} else {
+			where += ` AND ` + cond
+		}
+		args = append(args, condArgs...)
+	}
+
+	if limit > 0 {
+		args = append(args, limit)
+		order += ` LIMIT ?`
+	}
+
+	rows, err := s.conn(ctx).QueryContext(ctx, `SELECT `+userColumns+` FROM users`+where+order, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	users, err := collectUsers(rows)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return users, nil
+}
+
+// SearchUsers returns up to limit users whose email or username contains
+// query, exact matches first, then prefix matches. Zero orgID searches all
+// organizations. Unlike the postgres storage, doesn't find similar spellings.
+func (s *Storage) SearchUsers(ctx context.Context, query string, orgID int64, limit int) ([]models.User, error) {
+	const op = "storage.mysql.SearchUsers"
+
+	q := strings.ToLower(query)
+	contains := "%" + likePrefix(q)
+
+	args := []any{contains, contains}
+	org := ""
+	if orgID != 0 {
+		args = append(args, orgID)
+		org = ` AND org_id = ?`
+	}
+	args = append(args, q, q, likePrefix(q), likePrefix(q), limit)
+
+	rows, err := s.conn(ctx).QueryContext(ctx,
+		`SELECT `+userColumns+` FROM users
+			WHERE (LOWER(email) LIKE ? OR LOWER(username) LIKE ?)
+				AND deleted_at IS NULL`+org+`
+			ORDER BY LOWER(email) = ? OR LOWER(username) = ? DESC,
+				LOWER(email) LIKE ? OR LOWER(username) LIKE ? DESC, id
+			LIMIT ?`,
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	users, err := collectUsers(rows)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return users, nil
+}
+
+// UseJTI records a one-time token id until it expires.
+// Returns storage.ErrJTIUsed if the id was already recorded and hasn't expired yet.
+func (s *Storage) UseJTI(ctx context.Context, jti string, expiresAt time.Time) error {
+	const op = "storage.mysql.UseJTI"
+
+	_, err := s.conn(ctx).ExecContext(ctx,
+		`INSERT INTO used_jtis(jti, expires_at) VALUES (?, ?)`, jti, expiresAt,
+	)
+	if err == nil {
+		return nil
+	}
+	if !isErr(err, errDupEntry) {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Истёкшая запись освобождает id
+	res, err := s.conn(ctx).ExecContext(ctx,
+		`UPDATE used_jtis SET expires_at = ? WHERE jti = ? AND expires_at < CURRENT_TIMESTAMP(6)`,
+		expiresAt, jti,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := expectRow(res, storage.ErrJTIUsed); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ReserveIdempotencyKey records the key as taken by a call in progress until
+// lockedUntil and keeps it until expiresAt. If the key is already recorded,
+// hasn't expired and isn't left by a call past lockedUntil, returns its record
+// and storage.ErrIdempotencyKeyExists.
+func (s *Storage) ReserveIdempotencyKey(ctx context.Context, scope string, key string, requestHash []byte, lockedUntil time.Time, expiresAt time.Time) (models.IdempotencyRecord, error) {
+	const op = "storage.mysql.ReserveIdempotencyKey"
+
+	_, err := s.conn(ctx).ExecContext(ctx,
+		"INSERT INTO idempotency_keys(scope, `key`, request_hash, message, locked_until, expires_at) VALUES (?, ?, ?, '', ?, ?)",
+		scope, key, requestHash, lockedUntil, expiresAt,
+	)
+	if err == nil {
+		return models.IdempotencyRecord{}, nil
+	}
+	if !isErr(err, errDupEntry) {
+		return models.IdempotencyRecord{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	res, err := s.conn(ctx).ExecContext(ctx,
+		`UPDATE idempotency_keys SET
+				request_hash = ?, code = NULL, message = '', response = NULL,
+				created_at = CURRENT_TIMESTAMP(6), completed_at = NULL,
+				locked_until = ?, expires_at = ?`+
+			" WHERE scope = ? AND `key` = ?"+
+			` AND (expires_at < CURRENT_TIMESTAMP(6) OR completed_at IS NULL AND locked_until < CURRENT_TIMESTAMP(6))`,
+		requestHash, lockedUntil, expiresAt, scope, key,
+	)
+	if err != nil {
+		return models.IdempotencyRecord{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 1 {
+		return models.IdempotencyRecord{}, nil
+	}
+
+	var rec models.IdempotencyRecord
+	err = s.conn(ctx).QueryRowContext(ctx,
+		`SELECT request_hash, completed_at IS NOT NULL, COALESCE(code, 0), message, response
+			FROM idempotency_keys WHERE scope = ? AND `+"`key`"+` = ?`,
+		scope, key,
+	).Scan(&rec.RequestHash, &rec.Completed, &rec.Code, &rec.Message, &rec.Response)
+	// Ключ мог освободиться между запросами, тогда отвечаем как для вызова в процессе
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return models.IdempotencyRecord{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return rec, fmt.Errorf("%s: %w", op, storage.ErrIdempotencyKeyExists)
+}
+
+// CompleteIdempotencyKey stores the outcome of the call holding the key.
+func (s *Storage) CompleteIdempotencyKey(ctx context.Context, scope string, key string, rec models.IdempotencyRecord) error {
+	const op = "storage.mysql.CompleteIdempotencyKey"
+
+	_, err := s.conn(ctx).ExecContext(ctx,
+		`UPDATE idempotency_keys SET code = ?, message = ?, response = ?, completed_at = CURRENT_TIMESTAMP(6)
+			WHERE scope = ? AND `+"`key`"+` = ?`,
+		rec.Code, rec.Message, rec.Response, scope, key,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ReleaseIdempotencyKey forgets the key of a call that didn't complete, so that
+// a retry runs it again.
+func (s *Storage) ReleaseIdempotencyKey(ctx context.Context, scope string, key string) error {
+	const op = "storage.mysql.ReleaseIdempotencyKey"
+
+	_, err := s.conn(ctx).ExecContext(ctx,
+		"DELETE FROM idempotency_keys WHERE scope = ? AND `key` = ? AND completed_at IS NULL",
+		scope, key,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// MergeUsers moves everything owned by user fromID to user intoID, sets role of the
+// resulting account, removes fromID and leaves a redirect record for it.
+func (s *Storage) MergeUsers(ctx context.Context, fromID int64, intoID int64, role string) error {
+	const op = "storage.mysql.MergeUsers"
+
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `UPDATE users SET role = ? WHERE id = ?`, role, intoID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if err := expectRow(res, storage.ErrUserNotFound); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Earlier redirects to the merged account now point to the surviving one.
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE user_redirects SET new_id = ? WHERE new_id = ?`, intoID, fromID,
+	); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO user_redirects(old_id, new_id) VALUES (?, ?)`, fromID, intoID,
+	); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Всё остальное переносим, иначе удаление fromID каскадно удалит это.
+	// Если строка может быть только одна, остаётся строка intoID.
+	merges := []struct {
+		query string
+		args  []any
+	}{
+		// Social logins of the merged account keep working.
+		{`UPDATE user_identities SET user_id = ? WHERE user_id = ?`, []any{intoID, fromID}},
+		{`UPDATE login_attempts SET user_id = ? WHERE user_id = ?`, []any{intoID, fromID}},
+		// Group memberships move to the surviving account.
+		{`INSERT IGNORE INTO group_members(group_id, user_id, added_at)
+			SELECT group_id, ?, added_at FROM group_members WHERE user_id = ?`, []any{intoID, fromID}},
+		{`UPDATE sessions SET user_id = ? WHERE user_id = ?`, []any{intoID, fromID}},
+		{`UPDATE refresh_tokens SET user_id = ? WHERE user_id = ?`, []any{intoID, fromID}},
+		{`UPDATE authorization_codes SET user_id = ? WHERE user_id = ?`, []any{intoID, fromID}},
+		{`UPDATE passkeys SET user_id = ? WHERE user_id = ?`, []any{intoID, fromID}},
+		{`UPDATE IGNORE user_totp SET user_id = ? WHERE user_id = ?`, []any{intoID, fromID}},
+		// Recovery codes go with the authenticator, if it moved.
+		{`UPDATE recovery_codes SET user_id = ?
+			WHERE user_id = ? AND NOT EXISTS (SELECT 1 FROM user_totp WHERE user_id = ?)`, []any{intoID, fromID, fromID}},
+		{`UPDATE IGNORE user_preferences SET user_id = ? WHERE user_id = ?`, []any{intoID, fromID}},
+		{`UPDATE users u JOIN users f ON f.id = ?
+			SET u.metadata = JSON_MERGE_PATCH(f.metadata, u.metadata) WHERE u.id = ?`, []any{fromID, intoID}},
+		// Tokens of fromID are checked against the revocations of intoID from
+		// now on, so those revoked stay revoked, at the cost of earlier tokens of intoID.
+		{`UPDATE user_token_revocations i JOIN user_token_revocations f ON f.user_id = ?
+			SET i.revoked_before = GREATEST(i.revoked_before, f.revoked_before) WHERE i.user_id = ?`, []any{fromID, intoID}},
+		{`UPDATE IGNORE user_token_revocations SET user_id = ? WHERE user_id = ?`, []any{intoID, fromID}},
+	}
+	for _, m := range merges {
+		if _, err := tx.ExecContext(ctx, m.query, m.args...); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err := enqueueEvent(ctx, tx, models.EventUserMerged, models.EventUser{UserID: fromID, IntoID: intoID}); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	res, err = tx.ExecContext(ctx, `DELETE FROM users WHERE id = ?`, fromID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if err := expectRow(res, storage.ErrUserNotFound); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// SetUserStatus sets one of the models.UserStatus* statuses of the user.
+func (s *Storage) SetUserStatus(ctx context.Context, userID int64, status string) error {
+	const op = "storage.mysql.SetUserStatus"
+
+	res, err := s.conn(ctx).ExecContext(ctx, `UPDATE users SET status = ? WHERE id = ?`, status, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := expectRow(res, storage.ErrUserNotFound); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// SoftDeleteUser marks the user deleted, keeping the record for RestoreUser.
+// Deleting an already deleted user keeps the original time.
+func (s *Storage) SoftDeleteUser(ctx context.Context, userID int64) error {
+	const op = "storage.mysql.SoftDeleteUser"
+
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	var deletedAt sql.NullTime
+
+	err = tx.QueryRowContext(ctx,
+		`SELECT deleted_at FROM users WHERE id = ? FOR UPDATE`, userID,
+	).Scan(&deletedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Повторное удаление уже удалённого пользователя событием не считается
+	if deletedAt.Valid {
+		return nil
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE users SET deleted_at = CURRENT_TIMESTAMP(6) WHERE id = ?`, userID,
+	); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := enqueueEvent(ctx, tx, models.EventUserDeleted, models.EventUser{UserID: userID}); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// RestoreUser undoes SoftDeleteUser. Anonymized users are not found.
+func (s *Storage) RestoreUser(ctx context.Context, userID int64) error {
+	const op = "storage.mysql.RestoreUser"
+
+	res, err := s.conn(ctx).ExecContext(ctx, `UPDATE users SET deleted_at = NULL WHERE id = ? AND anonymized_at IS NULL`, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := expectRow(res, storage.ErrUserNotFound); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// DeleteUser removes the user together with credentials and everything else
+// the user owns. Tables referencing users are cleaned up by ON DELETE CASCADE;
+// codes and tokens keyed by email or phone are removed explicitly.
+func (s *Storage) DeleteUser(ctx context.Context, userID int64) error {
+	const op = "storage.mysql.DeleteUser"
+
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	email, phone, err := lockUser(ctx, tx, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM users WHERE id = ?`, userID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := deleteUserCodes(ctx, tx, userID, email, phone); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := enqueueEvent(ctx, tx, models.EventUserDeleted, models.EventUser{UserID: userID}); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// AnonymizeUser scrubs personal data of the user but keeps the row, so that
+// other services referencing the user id stay consistent. Credentials,
+// sessions, login history and everything else the user owns are removed;
+// the audit log is kept. Anonymized users can't be restored.
+func (s *Storage) AnonymizeUser(ctx context.Context, userID int64) error {
+	const op = "storage.mysql.AnonymizeUser"
+
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	email, phone, err := lockUser(ctx, tx, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE users SET email = NULL, username = NULL, phone = NULL, phone_verified = FALSE,
+			avatar_url = NULL, pass_hash = '', metadata = '{}',
+			deleted_at = COALESCE(deleted_at, CURRENT_TIMESTAMP(6)), anonymized_at = CURRENT_TIMESTAMP(6)
+			WHERE id = ?`,
+		userID,
+	); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, table := range []string{
+		"user_preferences", "refresh_tokens", "user_totp", "passkeys", "recovery_codes",
+		"authorization_codes", "user_identities", "sessions", "login_attempts",
+		"login_failures", "group_members",
+	} {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE user_id = ?`, userID); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err := deleteUserCodes(ctx, tx, userID, email, phone); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := enqueueEvent(ctx, tx, models.EventUserDeleted, models.EventUser{UserID: userID}); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// lockUser locks the user row till the end of tx and returns its email and phone.
+func lockUser(ctx context.Context, tx *txn, userID int64) (email string, phone string, err error) {
+	err = tx.QueryRowContext(ctx,
+		`SELECT COALESCE(email, ''), COALESCE(phone, '') FROM users WHERE id = ? FOR UPDATE`, userID,
+	).Scan(&email, &phone)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", "", storage.ErrUserNotFound
+	}
+
+	return email, phone, err
+}
+
+// deleteUserCodes removes one-time codes and tokens keyed by email or phone of the user.
+func deleteUserCodes(ctx context.Context, tx *txn, userID int64, email string, phone string) error {
+	if _, err := tx.ExecContext(ctx,
+		"DELETE FROM otp_codes WHERE `key` IN (?, ?)", email, phone,
+	); err != nil {
+		return err
+	}
+
+	_, err := tx.ExecContext(ctx,
+		`DELETE FROM one_time_tokens WHERE subject IN (?, ?)`, strings.ToLower(email), strconv.FormatInt(userID, 10),
+	)
+
+	return err
+}
+
+// SaveTOTP stores a new unconfirmed authenticator secret of the user, replacing the previous one.
+func (s *Storage) SaveTOTP(ctx context.Context, userID int64, secret []byte) error {
+	const op = "storage.mysql.SaveTOTP"
+
+	_, err := s.conn(ctx).ExecContext(ctx,
+		`INSERT INTO user_totp(user_id, secret) VALUES (?, ?)
+			ON DUPLICATE KEY UPDATE secret = VALUES(secret), confirmed_at = NULL, last_step = 0`,
+		userID, secret,
+	)
+	if err != nil {
+		if isErr(err, errNoReferencedRow) {
+			return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) TOTP(ctx context.Context, userID int64) (models.TOTP, error) {
+	const op = "storage.mysql.TOTP"
+
+	totp := models.TOTP{UserID: userID}
+
+	err := s.conn(ctx).QueryRowContext(ctx,
+		`SELECT secret, confirmed_at IS NOT NULL, last_step FROM user_totp WHERE user_id = ?`, userID,
+	).Scan(&totp.Secret, &totp.Confirmed, &totp.LastStep)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.TOTP{}, fmt.Errorf("%s: %w", op, storage.ErrTOTPNotFound)
+		}
+
+		return models.TOTP{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return totp, nil
+}
+
+// UseTOTPStep records the time step of an accepted code. Returns storage.ErrTOTPStepUsed
+// if this or a later step was already used, so every code is accepted once.
+func (s *Storage) UseTOTPStep(ctx context.Context, userID int64, step int64) error {
+	const op = "storage.mysql.UseTOTPStep"
+
+	res, err := s.conn(ctx).ExecContext(ctx,
+		`UPDATE user_totp SET last_step = ? WHERE user_id = ? AND last_step < ?`, step, userID, step,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := expectRow(res, storage.ErrTOTPStepUsed); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) ConfirmTOTP(ctx context.Context, userID int64) error {
+	const op = "storage.mysql.ConfirmTOTP"
+
+	res, err := s.conn(ctx).ExecContext(ctx,
+		`UPDATE user_totp SET confirmed_at = CURRENT_TIMESTAMP(6) WHERE user_id = ?`, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := expectRow(res, storage.ErrTOTPNotFound); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) SetSMSMFA(ctx context.Context, userID int64, enabled bool) error {
+	const op = "storage.mysql.SetSMSMFA"
+
+	res, err := s.conn(ctx).ExecContext(ctx, `UPDATE users SET sms_mfa = ? WHERE id = ?`, enabled, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := expectRow(res, storage.ErrUserNotFound); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) SMSMFA(ctx context.Context, userID int64) (bool, error) {
+	const op = "storage.mysql.SMSMFA"
+
+	var enabled bool
+
+	err := s.conn(ctx).QueryRowContext(ctx, `SELECT sms_mfa FROM users WHERE id = ?`, userID).Scan(&enabled)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return enabled, nil
+}
+
+// ReplaceRecoveryCodes drops all recovery codes of the user and stores new ones.
+func (s *Storage) ReplaceRecoveryCodes(ctx context.Context, userID int64, hashes [][]byte) error {
+	const op = "storage.mysql.ReplaceRecoveryCodes"
+
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, hash := range hashes {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO recovery_codes(user_id, code_hash) VALUES (?, ?)`, userID, hash,
+		); err != nil {
+			if isErr(err, errNoReferencedRow) {
+				return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+			}
+
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ResetMFA drops the authenticator and recovery codes of the user and turns
+// off SMS codes, so the next login needs only the password.
+func (s *Storage) ResetMFA(ctx context.Context, userID int64) error {
+	const op = "storage.mysql.ResetMFA"
+
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `UPDATE users SET sms_mfa = FALSE WHERE id = ?`, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := expectRow(res, storage.ErrUserNotFound); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_totp WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// UseRecoveryCode marks the code used. Returns storage.ErrRecoveryCodeNotFound
+// for unknown and already used codes.
+func (s *Storage) UseRecoveryCode(ctx context.Context, userID int64, hash []byte) error {
+	const op = "storage.mysql.UseRecoveryCode"
+
+	res, err := s.conn(ctx).ExecContext(ctx,
+		`UPDATE recovery_codes SET used_at = CURRENT_TIMESTAMP(6) WHERE user_id = ? AND code_hash = ? AND used_at IS NULL`,
+		userID, hash,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := expectRow(res, storage.ErrRecoveryCodeNotFound); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) SavePasskey(ctx context.Context, passkey models.Passkey) error {
+	const op = "storage.mysql.SavePasskey"
+
+	_, err := s.conn(ctx).ExecContext(ctx,
+		`INSERT INTO passkeys(id, user_id, public_key, sign_count) VALUES (?, ?, ?, ?)`,
+		passkey.ID, passkey.UserID, passkey.PublicKey, int64(passkey.SignCount),
+	)
+	if err != nil {
+		switch {
+		case isErr(err, errDupEntry):
+			return fmt.Errorf("%s: %w", op, storage.ErrPasskeyExists)
+		case isErr(err, errNoReferencedRow):
+			return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) Passkey(ctx context.Context, id []byte) (models.Passkey, error) {
+	const op = "storage.mysql.Passkey"
+
+	var (
+		passkey    = models.Passkey{ID: id}
+		signCount  int64
+		lastUsedAt sql.NullTime
+	)
+
+	err := s.conn(ctx).QueryRowContext(ctx,
+		`SELECT user_id, public_key, sign_count, created_at, last_used_at FROM passkeys WHERE id = ?`, id,
+	).Scan(&passkey.UserID, &passkey.PublicKey, &signCount, &passkey.CreatedAt, &lastUsedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Passkey{}, fmt.Errorf("%s: %w", op, storage.ErrPasskeyNotFound)
+		}
+
+		return models.Passkey{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	passkey.SignCount = uint32(signCount)
+	passkey.LastUsedAt = lastUsedAt.Time
+
+	return passkey, nil
+}
+
+// TouchPasskey records use of the passkey with the new signature counter.
+func (s *Storage) TouchPasskey(ctx context.Context, id []byte, signCount uint32) error {
+	const op = "storage.mysql.TouchPasskey"
+
+	res, err := s.conn(ctx).ExecContext(ctx,
+		`UPDATE passkeys SET sign_count = ?, last_used_at = CURRENT_TIMESTAMP(6) WHERE id = ?`, int64(signCount), id,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := expectRow(res, storage.ErrPasskeyNotFound); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// SaveOTP stores a one-time code, replacing the previous one for the same key and purpose.
+func (s *Storage) SaveOTP(ctx context.Context, otp models.OTP) error {
+	const op = "storage.mysql.SaveOTP"
+
+	_, err := s.conn(ctx).ExecContext(ctx,
+		"INSERT INTO otp_codes(`key`, purpose, code_hash, expires_at, attempts) VALUES (?, ?, ?, ?, ?)"+
+			` ON DUPLICATE KEY UPDATE code_hash = VALUES(code_hash), expires_at = VALUES(expires_at), attempts = VALUES(attempts)`,
+		otp.Key, otp.Purpose, otp.CodeHash, otp.ExpiresAt, otp.Attempts,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) OTP(ctx context.Context, key string, purpose string) (models.OTP, error) {
+	const op = "storage.mysql.OTP"
+
+	otp := models.OTP{Key: key, Purpose: purpose}
+
+	err := s.conn(ctx).QueryRowContext(ctx,
+		"SELECT code_hash, expires_at, attempts FROM otp_codes WHERE `key` = ? AND purpose = ?",
+		key, purpose,
+	).Scan(&otp.CodeHash, &otp.ExpiresAt, &otp.Attempts)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.OTP{}, fmt.Errorf("%s: %w", op, storage.ErrOTPNotFound)
+		}
+
+		return models.OTP{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return otp, nil
+}
+
+func (s *Storage) IncrementOTPAttempts(ctx context.Context, key string, purpose string) error {
+	const op = "storage.mysql.IncrementOTPAttempts"
+
+	_, err := s.conn(ctx).ExecContext(ctx,
+		"UPDATE otp_codes SET attempts = attempts + 1 WHERE `key` = ? AND purpose = ?", key, purpose,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// DeleteOTP removes the code. Returns storage.ErrOTPNotFound if it was already removed,
+// which lets concurrent consumers of the same code detect the loser.
+func (s *Storage) DeleteOTP(ctx context.Context, key string, purpose string) error {
+	const op = "storage.mysql.DeleteOTP"
+
+	res, err := s.conn(ctx).ExecContext(ctx,
+		"DELETE FROM otp_codes WHERE `key` = ? AND purpose = ?", key, purpose,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := expectRow(res, storage.ErrOTPNotFound); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) SaveOneTimeToken(ctx context.Context, token models.OneTimeToken) error {
+	const op = "storage.mysql.SaveOneTimeToken"
+
+	_, err := s.conn(ctx).ExecContext(ctx,
+		`INSERT INTO one_time_tokens(token_hash, purpose, subject, expires_at) VALUES (?, ?, ?, ?)`,
+		token.Hash, token.Purpose, token.Subject, token.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ConsumeOneTimeToken marks the token consumed and returns it. The update is
+// conditional, so of concurrent consumers exactly one succeeds; the rest and
+// expired tokens get storage.ErrTokenNotFound.
+func (s *Storage) ConsumeOneTimeToken(ctx context.Context, hash []byte, purpose string) (models.OneTimeToken, error) {
+	const op = "storage.mysql.ConsumeOneTimeToken"
+
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return models.OneTimeToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		`UPDATE one_time_tokens SET consumed_at = CURRENT_TIMESTAMP(6)
+			WHERE token_hash = ? AND purpose = ? AND consumed_at IS NULL AND expires_at > CURRENT_TIMESTAMP(6)`,
+		hash, purpose,
+	)
+	if err != nil {
+		return models.OneTimeToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if err := expectRow(res, storage.ErrTokenNotFound); err != nil {
+		return models.OneTimeToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	token := models.OneTimeToken{Hash: hash, Purpose: purpose}
+
+	// Строка заблокирована обновлением до конца транзакции
+	err = tx.QueryRowContext(ctx,
+		`SELECT subject, expires_at, consumed_at FROM one_time_tokens WHERE token_hash = ?`, hash,
+	).Scan(&token.Subject, &token.ExpiresAt, &token.ConsumedAt)
+	if err != nil {
+		return models.OneTimeToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return models.OneTimeToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return token, nil
+}
+
+func (s *Storage) SaveAuthorizationCode(ctx context.Context, code models.AuthorizationCode) error {
+	const op = "storage.mysql.SaveAuthorizationCode"
+
+	_, err := s.conn(ctx).ExecContext(ctx,
+		`INSERT INTO authorization_codes(code_hash, app_id, user_id, redirect_uri, scope, nonce,
+			code_challenge, code_challenge_method, expires_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		code.Hash, code.AppID, code.UserID, code.RedirectURI, code.Scope, code.Nonce,
+		code.CodeChallenge, code.CodeChallengeMethod, code.ExpiresAt,
+	)
+	if err != nil {
+		if isErr(err, errNoReferencedRow) {
+			return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ConsumeAuthorizationCode marks the code consumed and returns it, like ConsumeOneTimeToken.
+func (s *Storage) ConsumeAuthorizationCode(ctx context.Context, hash []byte) (models.AuthorizationCode, error) {
+	const op = "storage.mysql.ConsumeAuthorizationCode"
+
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return models.AuthorizationCode{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		`UPDATE authorization_codes SET consumed_at = CURRENT_TIMESTAMP(6)
+			WHERE code_hash = ? AND consumed_at IS NULL AND expires_at > CURRENT_TIMESTAMP(6)`,
+		hash,
+	)
+	if err != nil {
+		return models.AuthorizationCode{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if err := expectRow(res, storage.ErrTokenNotFound); err != nil {
+		return models.AuthorizationCode{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	code := models.AuthorizationCode{Hash: hash}
+
+	err = tx.QueryRowContext(ctx,
+		`SELECT app_id, user_id, redirect_uri, scope, nonce, code_challenge, code_challenge_method, expires_at
+			FROM authorization_codes WHERE code_hash = ?`,
+		hash,
+	).Scan(&code.AppID, &code.UserID, &code.RedirectURI, &code.Scope, &code.Nonce,
+		&code.CodeChallenge, &code.CodeChallengeMethod, &code.ExpiresAt)
+	if err != nil {
+		return models.AuthorizationCode{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return models.AuthorizationCode{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return code, nil
+}
+
+func (s *Storage) SaveRefreshToken(ctx context.Context, token models.RefreshToken) error {
+	const op = "storage.mysql.SaveRefreshToken"
+
+	_, err := s.conn(ctx).ExecContext(ctx,
+		`INSERT INTO refresh_tokens(token_hash, user_id, app_id, session_id, expires_at) VALUES (?, ?, ?, NULLIF(?, 0), ?)`,
+		token.Hash, token.UserID, token.AppID, token.SessionID, token.ExpiresAt,
+	)
+	if err != nil {
+		if isErr(err, errNoReferencedRow) {
+			return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// UseRefreshToken revokes the token and returns it, so that every refresh token
+// is exchanged at most once. Unknown, expired and revoked tokens give storage.ErrTokenNotFound.
+func (s *Storage) UseRefreshToken(ctx context.Context, hash []byte) (models.RefreshToken, error) {
+	const op = "storage.mysql.UseRefreshToken"
+
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return models.RefreshToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		`UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP(6)
+			WHERE token_hash = ? AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP(6)`,
+		hash,
+	)
+	if err != nil {
+		return models.RefreshToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if err := expectRow(res, storage.ErrTokenNotFound); err != nil {
+		return models.RefreshToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	token := models.RefreshToken{Hash: hash}
+
+	err = tx.QueryRowContext(ctx,
+		`SELECT user_id, app_id, COALESCE(session_id, 0), expires_at FROM refresh_tokens WHERE token_hash = ?`, hash,
+	).Scan(&token.UserID, &token.AppID, &token.SessionID, &token.ExpiresAt)
+	if err != nil {
+		return models.RefreshToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return models.RefreshToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return token, nil
+}
+
+// RevokeToken revokes a single access token until it expires.
+func (s *Storage) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	const op = "storage.mysql.RevokeToken"
+
+	_, err := s.conn(ctx).ExecContext(ctx,
+		`INSERT IGNORE INTO revoked_tokens(jti, expires_at) VALUES (?, ?)`,
+		jti, expiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// RevokeUserTokens revokes access tokens of the user issued up to before
+// and all of the user's refresh tokens and sessions.
+func (s *Storage) RevokeUserTokens(ctx context.Context, userID int64, before time.Time) error {
+	const op = "storage.mysql.RevokeUserTokens"
+
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO user_token_revocations(user_id, revoked_before) VALUES (`+resolveUserID+`, ?)
+			ON DUPLICATE KEY UPDATE revoked_before = GREATEST(revoked_before, VALUES(revoked_before))`,
+		userID, userID, before,
+	)
+	if err != nil {
+		if isErr(err, errNoReferencedRow) {
+			return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP(6) WHERE user_id = `+resolveUserID+` AND revoked_at IS NULL`,
+		userID, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP(6) WHERE user_id = `+resolveUserID+` AND revoked_at IS NULL`,
+		userID, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// TokenRevoked reports whether the access token with the given id, issued
+// to the user at issuedAt, was revoked by RevokeToken, RevokeUserTokens or
+// with its session. Tokens of deleted users are revoked as well. userID is zero
+// for service tokens, which have no user and are only checked by id.
+func (s *Storage) TokenRevoked(ctx context.Context, userID int64, sessionID int64, jti string, issuedAt time.Time) (bool, error) {
+	const op = "storage.mysql.TokenRevoked"
+
+	var revoked bool
+
+	err := s.conn(ctx).QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = ?)
+			OR EXISTS (SELECT 1 FROM user_token_revocations
+				WHERE user_id = `+resolveUserID+` AND revoked_before >= ?)
+			OR (? <> 0 AND NOT EXISTS (SELECT 1 FROM users WHERE id = `+resolveUserID+`))
+			OR EXISTS (SELECT 1 FROM sessions WHERE id = ? AND revoked_at IS NOT NULL)`,
+		jti, userID, userID, issuedAt, userID, userID, userID, sessionID,
+	).Scan(&revoked)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return revoked, nil
+}
+
+// sessionColumns are selected by every query returning models.Session, see scanSession.
+const sessionColumns = `id, user_id, app_id, ip, user_agent, device, region, created_at, last_seen_at, expires_at`
+
+func (s *Storage) SaveSession(ctx context.Context, session models.Session) (int64, error) {
+	const op = "storage.mysql.SaveSession"
+
+	res, err := s.conn(ctx).ExecContext(ctx,
+		`INSERT INTO sessions(user_id, app_id, ip, user_agent, device, region, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		session.UserID, session.AppID, session.IP, session.UserAgent, session.Device, session.Region, session.ExpiresAt,
+	)
+	if err != nil {
+		if isErr(err, errNoReferencedRow) {
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return id, nil
+}
+
+// TouchSession records activity of an active session, giving storage.ErrSessionNotFound otherwise.
+func (s *Storage) TouchSession(ctx context.Context, id int64, ip string, expiresAt time.Time) error {
+	const op = "storage.mysql.TouchSession"
+
+	res, err := s.conn(ctx).ExecContext(ctx,
+		`UPDATE sessions SET last_seen_at = CURRENT_TIMESTAMP(6), ip = COALESCE(NULLIF(?, ''), ip), expires_at = GREATEST(expires_at, ?)
+			WHERE id = ? AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP(6)`,
+		ip, expiresAt, id,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := expectRow(res, storage.ErrSessionNotFound); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// Sessions returns active sessions of the user, most recently seen first.
+func (s *Storage) Sessions(ctx context.Context, userID int64) ([]models.Session, error) {
+	const op = "storage.mysql.Sessions"
+
+	rows, err := s.conn(ctx).QueryContext(ctx,
+		`SELECT `+sessionColumns+` FROM sessions
+			WHERE user_id = `+resolveUserID+` AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP(6)
+			ORDER BY last_seen_at DESC, id DESC`,
+		userID, userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var sessions []models.Session
+
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession revokes the active session of the user with its refresh tokens.
+func (s *Storage) RevokeSession(ctx context.Context, userID int64, id int64) error {
+	const op = "storage.mysql.RevokeSession"
+
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		`UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP(6)
+			WHERE id = ? AND user_id = `+resolveUserID+` AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP(6)`,
+		id, userID, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := expectRow(res, storage.ErrSessionNotFound); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP(6) WHERE session_id = ? AND revoked_at IS NULL`, id,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func scanSession(row scanner) (models.Session, error) {
+	var session models.Session
+
+	err := row.Scan(
+		&session.ID, &session.UserID, &session.AppID, &session.IP, &session.UserAgent, &session.Device, &session.Region,
+		&session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt,
+	)
+
+	return session, err
+}
+
+// SaveLoginAttempt records the attempt; zero UserID is stored as NULL.
+func (s *Storage) SaveLoginAttempt(ctx context.Context, attempt models.LoginAttempt) error {
+	const op = "storage.mysql.SaveLoginAttempt"
+
+	_, err := s.conn(ctx).ExecContext(ctx,
+		`INSERT INTO login_attempts(user_id, app_id, login, method, result, ip, user_agent)
+			VALUES (NULLIF(?, 0), ?, ?, ?, ?, ?, ?)`,
+		attempt.UserID, attempt.AppID, attempt.Login, attempt.Method, attempt.Result, attempt.IP, attempt.UserAgent,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) LoginAttempts(ctx context.Context, userID int64, beforeID int64, limit int) ([]models.LoginAttempt, error) {
+	const op = "storage.mysql.LoginAttempts"
+
+	rows, err := s.conn(ctx).QueryContext(ctx,
+		`SELECT id, user_id, app_id, login, method, result, ip, user_agent, created_at FROM login_attempts
+			WHERE user_id = `+resolveUserID+` AND (? = 0 OR id < ?)
+			ORDER BY id DESC LIMIT ?`,
+		userID, userID, beforeID, beforeID, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var attempts []models.LoginAttempt
+
+	for rows.Next() {
+		var a models.LoginAttempt
+
+		err := rows.Scan(&a.ID, &a.UserID, &a.AppID, &a.Login, &a.Method, &a.Result, &a.IP, &a.UserAgent, &a.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		attempts = append(attempts, a)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return attempts, nil
+}
+
+// SaveAuditEvent appends the event; zero actor and target ids are stored as NULL.
+func (s *Storage) SaveAuditEvent(ctx context.Context, event models.AuditEvent) error {
+	const op = "storage.mysql.SaveAuditEvent"
+
+	details := event.Details
+	if details == nil {
+		details = map[string]string{}
+	}
+
+	b, err := json.Marshal(details)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = s.conn(ctx).ExecContext(ctx,
+		`INSERT INTO audit_log(action, actor_user_id, actor_app_id, actor_api_key_id, target_user_id, target_app_id, reason, details)
+			VALUES (?, NULLIF(?, 0), NULLIF(?, 0), NULLIF(?, 0), NULLIF(?, 0), NULLIF(?, 0), ?, ?)`,
+		event.Action, event.ActorUserID, event.ActorAppID, event.ActorAPIKeyID,
+		event.TargetUserID, event.TargetAppID, event.Reason, string(b),
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) AuditEvents(ctx context.Context, filter models.AuditFilter, beforeID int64, limit int) ([]models.AuditEvent, error) {
+	const op = "storage.mysql.AuditEvents"
+
+	var since, until sql.NullTime
+	if !filter.Since.IsZero() {
+		since = sql.NullTime{Time: filter.Since, Valid: true}
+	}
+	if !filter.Until.IsZero() {
+		until = sql.NullTime{Time: filter.Until, Valid: true}
+	}
+
+	rows, err := s.conn(ctx).QueryContext(ctx,
+		`SELECT id, action, COALESCE(actor_user_id, 0), COALESCE(actor_app_id, 0), COALESCE(actor_api_key_id, 0),
+				COALESCE(target_user_id, 0), COALESCE(target_app_id, 0), reason, details, created_at
+			FROM audit_log
+			WHERE (? = '' OR action = ?)
+				AND (? = 0 OR actor_user_id = ?)
+				AND (? = 0 OR target_user_id = ?)
+				AND (? IS NULL OR created_at >= ?)
+				AND (? IS NULL OR created_at < ?)
+				AND (? = 0 OR id < ?)
+				AND (? = 0 OR actor_app_id = ? OR target_app_id = ?)
+			ORDER BY id DESC LIMIT ?`,
+		filter.Action, filter.Action, filter.ActorUserID, filter.ActorUserID, filter.TargetUserID, filter.TargetUserID,
+		since, since, until, until, beforeID, beforeID, filter.AppID, filter.AppID, filter.AppID, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var events []models.AuditEvent
+
+	for rows.Next() {
+		var (
+			e       models.AuditEvent
+			details []byte
+		)
+
+		err := rows.Scan(
+			&e.ID, &e.Action, &e.ActorUserID, &e.ActorAppID, &e.ActorAPIKeyID,
+			&e.TargetUserID, &e.TargetAppID, &e.Reason, &details, &e.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		if err := json.Unmarshal(details, &e.Details); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		events = append(events, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return events, nil
+}
+
+// FailLogin counts a failed login of the user. The failure reaching non-zero
+// threshold locks the account until lockUntil and starts counting anew.
+func (s *Storage) FailLogin(ctx context.Context, userID int64, threshold int, lockUntil time.Time) (bool, error) {
+	const op = "storage.mysql.FailLogin"
+
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO login_failures(user_id, failures) VALUES (?, 1)
+			ON DUPLICATE KEY UPDATE failures = failures + 1`,
+		userID,
+	)
+	if err != nil {
+		if isErr(err, errNoReferencedRow) {
+			return false, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var failures int
+
+	err = tx.QueryRowContext(ctx, `SELECT failures FROM login_failures WHERE user_id = ?`, userID).Scan(&failures)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	locked := threshold > 0 && failures >= threshold
+	if locked {
+		_, err = tx.ExecContext(ctx,
+			`UPDATE login_failures SET failures = 0, locked_until = ? WHERE user_id = ?`,
+			lockUntil, userID,
+		)
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return locked, nil
+}
+
+// FailedLogins returns the number of failed logins since the last success or lock.
+func (s *Storage) FailedLogins(ctx context.Context, userID int64) (int, error) {
+	const op = "storage.mysql.FailedLogins"
+
+	var failures int
+
+	err := s.conn(ctx).QueryRowContext(ctx, `SELECT failures FROM login_failures WHERE user_id = ?`, userID).Scan(&failures)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, nil
+		}
+
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return failures, nil
+}
+
+// ResetFailedLogins clears the counter and the lock of the user.
+func (s *Storage) ResetFailedLogins(ctx context.Context, userID int64) error {
+	const op = "storage.mysql.ResetFailedLogins"
+
+	if _, err := s.conn(ctx).ExecContext(ctx, `DELETE FROM login_failures WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// LockedUntil returns zero time if the user was never locked.
+func (s *Storage) LockedUntil(ctx context.Context, userID int64) (time.Time, error) {
+	const op = "storage.mysql.LockedUntil"
+
+	var until sql.NullTime
+
+	err := s.conn(ctx).QueryRowContext(ctx, `SELECT locked_until FROM login_failures WHERE user_id = ?`, userID).Scan(&until)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return time.Time{}, nil
+		}
+
+		return time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return until.Time, nil
+}
+
+// FailUnknownLogin counts a failed login of no user by the hash of the login,
+// locking it like FailLogin.
+func (s *Storage) FailUnknownLogin(ctx context.Context, loginHash []byte, threshold int, lockUntil time.Time) (bool, error) {
+	const op = "storage.mysql.FailUnknownLogin"
+
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO unknown_login_failures(login_hash, failures) VALUES (?, 1)
+			ON DUPLICATE KEY UPDATE failures = failures + 1`,
+		loginHash,
+	)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var failures int
+
+	err = tx.QueryRowContext(ctx, `SELECT failures FROM unknown_login_failures WHERE login_hash = ?`, loginHash).Scan(&failures)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	locked := threshold > 0 && failures >= threshold
+	if locked {
+		_, err = tx.ExecContext(ctx,
+			`UPDATE unknown_login_failures SET failures = 0, locked_until = ? WHERE login_hash = ?`,
+			lockUntil, loginHash,
+		)
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return locked, nil
+}
+
+// UnknownLoginFailures returns the counter and the lock of the login of no user.
+func (s *Storage) UnknownLoginFailures(ctx context.Context, loginHash []byte) (int, time.Time, error) {
+	const op = "storage.mysql.UnknownLoginFailures"
+
+	var (
+		failures int
+		until    sql.NullTime
+	)
+
+	err := s.conn(ctx).QueryRowContext(ctx,
+		`SELECT failures, locked_until FROM unknown_login_failures WHERE login_hash = ?`,
+		loginHash,
+	).Scan(&failures, &until)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, time.Time{}, nil
+		}
+
+		return 0, time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return failures, until.Time, nil
+}
+
+// SaveGroup creates the group and returns its id.
+func (s *Storage) SaveGroup(ctx context.Context, group models.Group) (int64, error) {
+	const op = "storage.mysql.SaveGroup"
+
+	res, err := s.conn(ctx).ExecContext(ctx,
+		"INSERT INTO `groups`(name, description) VALUES (?, ?)",
+		group.Name, group.Description,
+	)
+	if err != nil {
+		if isErr(err, errDupEntry) {
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrGroupExists)
+		}
+
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return id, nil
+}
+
+// Group returns the group by id.
+func (s *Storage) Group(ctx context.Context, id int64) (models.Group, error) {
+	const op = "storage.mysql.Group"
+
+	var group models.Group
+
+	err := s.conn(ctx).QueryRowContext(ctx,
+		"SELECT id, name, description, created_at FROM `groups` WHERE id = ?", id,
+	).Scan(&group.ID, &group.Name, &group.Description, &group.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Group{}, fmt.Errorf("%s: %w", op, storage.ErrGroupNotFound)
+		}
+
+		return models.Group{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return group, nil
+}
+
+// AddGroupMember adds the user to the group; adding a member again does nothing.
+func (s *Storage) AddGroupMember(ctx context.Context, groupID int64, userID int64) error {
+	const op = "storage.mysql.AddGroupMember"
+
+	_, err := s.conn(ctx).ExecContext(ctx,
+		`INSERT IGNORE INTO group_members(group_id, user_id) VALUES (?, ?)`,
+		groupID, userID,
+	)
+	if err != nil {
+		var myErr *mysql.MySQLError
+
+		// Имя нарушенного ограничения есть только в тексте ошибки
+		if errors.As(err, &myErr) && myErr.Number == errNoReferencedRow {
+			if strings.Contains(myErr.Message, "group_members_group_id_fkey") {
+				return fmt.Errorf("%s: %w", op, storage.ErrGroupNotFound)
+			}
+
+			return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// RemoveGroupMember removes the user from the group; removing a non-member does nothing.
+func (s *Storage) RemoveGroupMember(ctx context.Context, groupID int64, userID int64) error {
+	const op = "storage.mysql.RemoveGroupMember"
+
+	_, err := s.conn(ctx).ExecContext(ctx,
+		`DELETE FROM group_members WHERE group_id = ? AND user_id = ?`, groupID, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// GroupMembers returns up to limit members of the group with user id below
+// beforeUserID (any if zero), newest users first.
+func (s *Storage) GroupMembers(ctx context.Context, groupID int64, beforeUserID int64, limit int) ([]models.GroupMember, error) {
+	const op = "storage.mysql.GroupMembers"
+
+	rows, err := s.conn(ctx).QueryContext(ctx,
+		`SELECT m.user_id, COALESCE(u.email, ''), m.added_at
+			FROM group_members m JOIN users u ON u.id = m.user_id
+			WHERE m.group_id = ? AND (? = 0 OR m.user_id < ?)
+			ORDER BY m.user_id DESC
+			LIMIT ?`,
+		groupID, beforeUserID, beforeUserID, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var members []models.GroupMember
+	for rows.Next() {
+		var m models.GroupMember
+		if err := rows.Scan(&m.UserID, &m.Email, &m.AddedAt); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		members = append(members, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return members, nil
+}
+
+// UserGroups returns names of the groups the user is in, sorted.
+func (s *Storage) UserGroups(ctx context.Context, userID int64) ([]string, error) {
+	const op = "storage.mysql.UserGroups"
+
+	rows, err := s.conn(ctx).QueryContext(ctx,
+		"SELECT g.name FROM group_members m JOIN `groups` g ON g.id = m.group_id"+
+			` WHERE m.user_id = ? ORDER BY g.name`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer rows.Close()
+
+	var groups []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		groups = append(groups, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return groups, nil
+}
+
+// SaveOrganization creates the organization and returns its id.
+func (s *Storage) SaveOrganization(ctx context.Context, org models.Organization) (int64, error) {
+	const op = "storage.mysql.SaveOrganization"
+
+	res, err := s.conn(ctx).ExecContext(ctx, `INSERT INTO organizations(name) VALUES (?)`, org.Name)
+	if err != nil {
+		if isErr(err, errDupEntry) {
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrOrgExists)
+		}
+
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return id, nil
+}
+
+// Organization returns the organization by id.
+func (s *Storage) Organization(ctx context.Context, id int64) (models.Organization, error) {
+	const op = "storage.mysql.Organization"
+
+	var org models.Organization
+
+	err := s.conn(ctx).QueryRowContext(ctx,
+		`SELECT id, name, created_at FROM organizations WHERE id = ?`, id,
+	).Scan(&org.ID, &org.Name, &org.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Organization{}, fmt.Errorf("%s: %w", op, storage.ErrOrgNotFound)
+		}
+
+		return models.Organization{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return org, nil
+}
+
+// Organizations returns all organizations ordered by name.
+func (s *Storage) Organizations(ctx context.Context) ([]models.Organization, error) {
+	const op = "storage.mysql.Organizations"
+
+	rows, err := s.conn(ctx).QueryContext(ctx, `SELECT id, name, created_at FROM organizations ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var orgs []models.Organization
+	for rows.Next() {
+		var org models.Organization
+		if err := rows.Scan(&org.ID, &org.Name, &org.CreatedAt); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		orgs = append(orgs, org)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return orgs, nil
+}
+
+// SetUserOrg moves the user to the organization; zero orgID moves it back to the platform.
+func (s *Storage) SetUserOrg(ctx context.Context, userID int64, orgID int64) error {
+	const op = "storage.mysql.SetUserOrg"
+
+	res, err := s.conn(ctx).ExecContext(ctx,
+		`UPDATE users SET org_id = NULLIF(?, 0) WHERE id = ?`, orgID, userID,
+	)
+	if err != nil {
+		if isErr(err, errNoReferencedRow) {
+			return fmt.Errorf("%s: %w", op, storage.ErrOrgNotFound)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := expectRow(res, storage.ErrUserNotFound); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// apiKeyColumns are selected by every query returning models.APIKey, see scanAPIKey.
+const apiKeyColumns = `id, app_id, name, role, prefix, created_at, last_used_at, revoked_at,
+	COALESCE((SELECT org_id FROM apps WHERE apps.id = api_keys.app_id), 0)`
+
+func (s *Storage) SaveAPIKey(ctx context.Context, key models.APIKey, hash []byte) (int64, error) {
+	const op = "storage.mysql.SaveAPIKey"
+
+	res, err := s.conn(ctx).ExecContext(ctx,
+		`INSERT INTO api_keys(app_id, name, role, key_hash, prefix) VALUES (?, ?, ?, ?, ?)`,
+		key.AppID, key.Name, key.Role, hash, key.Prefix,
+	)
+	if err != nil {
+		if isErr(err, errNoReferencedRow) {
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+		}
+
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return id, nil
+}
+
+// UseAPIKey returns the key that isn't revoked and records its use.
+func (s *Storage) UseAPIKey(ctx context.Context, hash []byte) (models.APIKey, error) {
+	const op = "storage.mysql.UseAPIKey"
+
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return models.APIKey{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		`UPDATE api_keys SET last_used_at = CURRENT_TIMESTAMP(6) WHERE key_hash = ? AND revoked_at IS NULL`, hash,
+	)
+	if err != nil {
+		return models.APIKey{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if err := expectRow(res, storage.ErrAPIKeyNotFound); err != nil {
+		return models.APIKey{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	key, err := scanAPIKey(tx.QueryRowContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE key_hash = ?`, hash))
+	if err != nil {
+		return models.APIKey{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return models.APIKey{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return key, nil
+}
+
+func (s *Storage) APIKey(ctx context.Context, id int64) (models.APIKey, error) {
+	const op = "storage.mysql.APIKey"
+
+	key, err := scanAPIKey(s.conn(ctx).QueryRowContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE id = ?`, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.APIKey{}, fmt.Errorf("%s: %w", op, storage.ErrAPIKeyNotFound)
+		}
+
+		return models.APIKey{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return key, nil
+}
+
+// APIKeys returns keys of the app, including revoked ones, newest first.
+func (s *Storage) APIKeys(ctx context.Context, appID int) ([]models.APIKey, error) {
+	const op = "storage.mysql.APIKeys"
+
+	rows, err := s.conn(ctx).QueryContext(ctx,
+		`SELECT `+apiKeyColumns+` FROM api_keys WHERE app_id = ? ORDER BY id DESC`, appID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var keys []models.APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return keys, nil
+}
+
+func (s *Storage) RevokeAPIKey(ctx context.Context, id int64) error {
+	const op = "storage.mysql.RevokeAPIKey"
+
+	res, err := s.conn(ctx).ExecContext(ctx,
+		`UPDATE api_keys SET revoked_at = CURRENT_TIMESTAMP(6) WHERE id = ? AND revoked_at IS NULL`, id,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := expectRow(res, storage.ErrAPIKeyNotFound); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func scanAPIKey(row scanner) (models.APIKey, error) {
+	var (
+		key                   models.APIKey
+		lastUsedAt, revokedAt sql.NullTime
+	)
+
+	err := row.Scan(&key.ID, &key.AppID, &key.Name, &key.Role, &key.Prefix, &key.CreatedAt, &lastUsedAt, &revokedAt, &key.OrgID)
+	if err != nil {
+		return models.APIKey{}, err
+	}
+
+	key.LastUsedAt = lastUsedAt.Time
+	key.RevokedAt = revokedAt.Time
+
+	return key, nil
+}
+
+// webhookColumns are selected by every query returning models.Webhook, see scanWebhook.
+const webhookColumns = `id, app_id, url, events, secret, created_at`
+
+func (s *Storage) SaveWebhook(ctx context.Context, hook models.Webhook) (int64, error) {
+	const op = "storage.mysql.SaveWebhook"
+
+	res, err := s.conn(ctx).ExecContext(ctx,
+		`INSERT INTO webhooks(app_id, url, events, secret) VALUES (?, ?, ?, ?)`,
+		hook.AppID, hook.URL, jsonArray(hook.Events), hook.Secret,
+	)
+	if err != nil {
+		if isErr(err, errNoReferencedRow) {
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+		}
+
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return id, nil
+}
+
+func (s *Storage) Webhook(ctx context.Context, id int64) (models.Webhook, error) {
+	const op = "storage.mysql.Webhook"
+
+	hook, err := scanWebhook(s.conn(ctx).QueryRowContext(ctx, `SELECT `+webhookColumns+` FROM webhooks WHERE id = ?`, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Webhook{}, fmt.Errorf("%s: %w", op, storage.ErrWebhookNotFound)
+		}
+
+		return models.Webhook{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return hook, nil
+}
+
+// Webhooks returns webhooks of the app, newest first.
+func (s *Storage) Webhooks(ctx context.Context, appID int) ([]models.Webhook, error) {
+	const op = "storage.mysql.Webhooks"
+
+	rows, err := s.conn(ctx).QueryContext(ctx,
+		`SELECT `+webhookColumns+` FROM webhooks WHERE app_id = ? ORDER BY id DESC`, appID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var hooks []models.Webhook
+	for rows.Next() {
+		hook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		hooks = append(hooks, hook)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return hooks, nil
+}
+
+// DeleteWebhook removes the webhook with its deliveries, pending ones included.
+func (s *Storage) DeleteWebhook(ctx context.Context, id int64) error {
+	const op = "storage.mysql.DeleteWebhook"
+
+	res, err := s.conn(ctx).ExecContext(ctx, `DELETE FROM webhooks WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := expectRow(res, storage.ErrWebhookNotFound); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// enqueueEvent writes the event to the outbox in the transaction of the change
+// it describes, so that the event is sent if and only if the change is committed.
+func enqueueEvent(ctx context.Context, tx *txn, eventType string, data models.EventUser) error {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+
+	event := models.Event{ID: hex.EncodeToString(b), Type: eventType, CreatedAt: time.Now().UTC(), Data: data}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO outbox(event_id, event, payload, app_id) VALUES (?, ?, ?, NULLIF(?, 0))`,
+		event.ID, event.Type, string(payload), data.AppID,
+	)
+
+	return err
+}
+
+// EnqueueEvent writes an event that isn't part of a change of the storage,
+// such as a failed login, to the outbox.
+func (s *Storage) EnqueueEvent(ctx context.Context, eventType string, data models.EventUser) error {
+	const op = "storage.mysql.EnqueueEvent"
+
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	if err := enqueueEvent(ctx, tx, eventType, data); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ClaimOutboxEvents returns up to limit unsent events, oldest first, and
+// postpones them by lease, so that other instances don't send them meanwhile.
+func (s *Storage) ClaimOutboxEvents(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxEvent, error) {
+	const op = "storage.mysql.ClaimOutboxEvents"
+
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT id, event_id, event, payload, COALESCE(app_id, 0), created_at FROM outbox
+			WHERE next_attempt_at <= CURRENT_TIMESTAMP(6)
+			ORDER BY id LIMIT ?
+			FOR UPDATE SKIP LOCKED`,
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var (
+		events []models.OutboxEvent
+		ids    []int64
+	)
+	for rows.Next() {
+		var e models.OutboxEvent
+
+		if err := rows.Scan(&e.ID, &e.EventID, &e.Type, &e.Payload, &e.AppID, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		events = append(events, e)
+		ids = append(ids, e.ID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := postpone(ctx, tx, "outbox", ids, time.Now().Add(lease)); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return events, nil
+}
+
+// CompleteOutboxEvent queues deliveries of the sent event to every webhook
+// subscribed to it, of its app if it has one, and removes the event from the outbox.
+func (s *Storage) CompleteOutboxEvent(ctx context.Context, id int64) error {
+	const op = "storage.mysql.CompleteOutboxEvent"
+
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO webhook_deliveries(webhook_id, event, payload, last_error)
+			SELECT w.id, o.event, o.payload, '' FROM outbox o JOIN webhooks w ON JSON_CONTAINS(w.events, JSON_QUOTE(o.event))
+			WHERE o.id = ? AND (o.app_id IS NULL OR o.app_id = w.app_id)`,
+		id,
+	); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM outbox WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// webhookDeliveryColumns are selected by every query returning models.WebhookDelivery,
+// see collectWebhookDeliveries.
+const webhookDeliveryColumns = `id, webhook_id, event, payload, attempts, next_attempt_at,
+	last_status, last_error, created_at, delivered_at, failed_at`
+
+// WebhookDeliveries returns deliveries of the webhook, newest first, with ids below
+// beforeID unless it is zero.
+func (s *Storage) WebhookDeliveries(ctx context.Context, webhookID int64, beforeID int64, limit int) ([]models.WebhookDelivery, error) {
+	const op = "storage.mysql.WebhookDeliveries"
+
+	rows, err := s.conn(ctx).QueryContext(ctx,
+		`SELECT `+webhookDeliveryColumns+` FROM webhook_deliveries
+			WHERE webhook_id = ? AND (? = 0 OR id < ?)
+			ORDER BY id DESC LIMIT ?`,
+		webhookID, beforeID, beforeID, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	deliveries, err := collectWebhookDeliveries(rows)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return deliveries, nil
+}
+
+// ClaimWebhookDeliveries returns up to limit pending deliveries that are due and
+// postpones them by lease, so that other instances don't send them meanwhile.
+func (s *Storage) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]models.WebhookDelivery, error) {
+	const op = "storage.mysql.ClaimWebhookDeliveries"
+
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT `+webhookDeliveryColumns+` FROM webhook_deliveries
+			WHERE delivered_at IS NULL AND failed_at IS NULL AND next_attempt_at <= CURRENT_TIMESTAMP(6)
+			ORDER BY next_attempt_at LIMIT ?
+			FOR UPDATE SKIP LOCKED`,
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	deliveries, err := collectWebhookDeliveries(rows)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	next := time.Now().Add(lease)
+	ids := make([]int64, 0, len(deliveries))
+	for i := range deliveries {
+		deliveries[i].NextAttemptAt = next
+		ids = append(ids, deliveries[i].ID)
+	}
+
+	if err := postpone(ctx, tx, "webhook_deliveries", ids, next); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return deliveries, nil
+}
+
+// postpone sets next_attempt_at of the claimed rows of the table.
+func postpone(ctx context.Context, tx *txn, table string, ids []int64, next time.Time) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	args := make([]any, 0, len(ids)+1)
+	args = append(args, next)
+	for _, id := range ids {
+		args = append(args, id)
+	}
+
+	_, err := tx.ExecContext(ctx,
+		`UPDATE `+table+` SET next_attempt_at = ? WHERE id IN (`+placeholders(len(ids), "?")+`)`,
+		args...,
+	)
+
+	return err
+}
+
+// UpdateWebhookDelivery records the outcome of an attempt.
+func (s *Storage) UpdateWebhookDelivery(ctx context.Context, d models.WebhookDelivery) error {
+	const op = "storage.mysql.UpdateWebhookDelivery"
+
+	_, err := s.conn(ctx).ExecContext(ctx,
+		`UPDATE webhook_deliveries SET attempts = ?, next_attempt_at = ?, last_status = ?, last_error = ?,
+			delivered_at = ?, failed_at = ?
+			WHERE id = ?`,
+		d.Attempts, d.NextAttemptAt, d.LastStatus, d.LastError,
+		nullTime(d.DeliveredAt), nullTime(d.FailedAt), d.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func scanWebhook(row scanner) (models.Webhook, error) {
+	var (
+		hook   models.Webhook
+		events []byte
+	)
+
+	err := row.Scan(&hook.ID, &hook.AppID, &hook.URL, &events, &hook.Secret, &hook.CreatedAt)
+	if err != nil {
+		return models.Webhook{}, err
+	}
+
+	if err := json.Unmarshal(events, &hook.Events); err != nil {
+		return models.Webhook{}, err
+	}
+
+	return hook, nil
+}
+
+func collectWebhookDeliveries(rows *sql.Rows) ([]models.WebhookDelivery, error) {
+	defer rows.Close()
+
+	var deliveries []models.WebhookDelivery
+	for rows.Next() {
+		var (
+			d                     models.WebhookDelivery
+			deliveredAt, failedAt sql.NullTime
+		)
+
+		err := rows.Scan(&d.ID, &d.WebhookID, &d.Event, &d.Payload, &d.Attempts, &d.NextAttemptAt,
+			&d.LastStatus, &d.LastError, &d.CreatedAt, &deliveredAt, &failedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		d.DeliveredAt = deliveredAt.Time
+		d.FailedAt = failedAt.Time
+
+		deliveries = append(deliveries, d)
+	}
+
+	return deliveries, rows.Err()
+}
+
+func scanRole(row scanner) (models.Role, error) {
+	var (
+		role        models.Role
+		permissions []byte
+	)
+
+	err := row.Scan(&role.Name, &role.Description, &role.Rank, &role.SelfAssignable, &role.CreatedAt, &permissions)
+	if err != nil {
+		return models.Role{}, err
+	}
+
+	// JSON_ARRAYAGG даёт NULL для роли без прав и не сортирует
+	role.Permissions = []string{}
+	if permissions != nil {
+		if err := json.Unmarshal(permissions, &role.Permissions); err != nil {
+			return models.Role{}, err
+		}
+	}
+	slices.Sort(role.Permissions)
+
+	return role, nil
+}
+
+func scanApp(row scanner) (models.App, error) {
+	var (
+		app                  models.App
+		redirectURIs, scopes []byte
+		expiresAt            sql.NullTime
+		ttlSeconds           int
+	)
+
+	err := row.Scan(
+		&app.ID, &app.Name, &app.Secret, &redirectURIs, &app.Public, &scopes, &app.OrgID, &app.CreatedAt,
+		&app.PreviousSecret, &expiresAt, &ttlSeconds, &app.Audience, &app.CertSubject,
+	)
+	if err != nil {
+		return app, err
+	}
+
+	if err := json.Unmarshal(redirectURIs, &app.RedirectURIs); err != nil {
+		return app, err
+	}
+	if err := json.Unmarshal(scopes, &app.Scopes); err != nil {
+		return app, err
+	}
+	app.PreviousSecretExpiresAt = expiresAt.Time
+	app.TokenTTL = time.Duration(ttlSeconds) * time.Second
+
+	return app, nil
+}
+
+func scanUser(row scanner) (models.User, error) {
+	var (
+		user        models.User
+		lastLoginAt sql.NullTime
+		deletedAt   sql.NullTime
+	)
+
+	err := row.Scan(
+		&user.ID, &user.Email, &user.Username, &user.Phone, &user.PhoneVerified,
+		&user.AvatarURL, &user.PassHash, &user.Role, &user.CreatedAt, &lastLoginAt, &user.OrgID, &deletedAt, &user.Status,
+	)
+	user.LastLoginAt = lastLoginAt.Time
+	user.DeletedAt = deletedAt.Time
+
+	return user, err
+}
+
+func collectUsers(rows *sql.Rows) ([]models.User, error) {
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+
+	return users, rows.Err()
+}
+
+// userWhere builds WHERE clause for the filter.
+func userWhere(filter models.UserFilter) (string, []any) {
+	var (
+		conds []string
+		args  []any
+	)
+
+	if filter.Role != "" {
+		args = append(args, filter.Role)
+		conds = append(conds, "role = ?")
+	}
+
+	if filter.EmailPrefix != "" {
+		args = append(args, likePrefix(filter.EmailPrefix))
+		conds = append(conds, "email LIKE ?")
+	}
+
+	if filter.OrgID != 0 {
+		args = append(args, filter.OrgID)
+		conds = append(conds, "org_id = ?")
+	}
+
+	if !filter.Deleted {
+		conds = append(conds, "deleted_at IS NULL")
+	}
+
+	if len(conds) == 0 {
+		return "", nil
+	}
+
+	return ` WHERE ` + strings.Join(conds, " AND "), args
+}
+
+// likePrefix escapes LIKE wildcards in s and turns it into a prefix pattern.
+func likePrefix(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+	return r.Replace(s) + "%"
+}
+
+// userSortColumns maps models.UserSort fields to ORDER BY expressions.
+// Only these are ever interpolated into queries.
+var userSortColumns = map[string]string{
+	"":                         "id",
+	models.UserSortID:          "id",
+	models.UserSortEmail:       "email",
+	models.UserSortRole:        "role",
+	models.UserSortCreatedAt:   "created_at",
+	models.UserSortLastLoginAt: "last_login_at",
+}
+
+// userSortTimes are the sort columns holding time, whose cursor values are RFC 3339.
+var userSortTimes = map[string]bool{
+	"created_at":    true,
+	"last_login_at": true,
+}
+
+// userKeyset builds the condition selecting users after the cursor in the
+// order of orderBy, where NULLs come last.
+func userKeyset(sort models.UserSort, c models.UserCursor) (string, []any, error) {
+	column := userSortColumns[sort.Field]
+
+	cmp := ">"
+	if sort.Desc {
+		cmp = "<"
+	}
+
+	if column == "id" {
+		return "id " + cmp + " ?", []any{c.ID}, nil
+	}
+
+	if c.Null {
+		return fmt.Sprintf("(%s IS NULL AND id %s ?)", column, cmp), []any{c.ID}, nil
+	}
+
+	var value any = c.Value
+	if userSortTimes[column] {
+		t, err := time.Parse(time.RFC3339Nano, c.Value)
+		if err != nil {
+			return "", nil, err
+		}
+		value = t
+	}
+
+	return fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?) OR %[1]s IS NULL)", column, cmp),
+		[]any{value, value, c.ID}, nil
+}
+
+func orderBy(sort models.UserSort) (string, error) {
+	column, ok := userSortColumns[sort.Field]
+	if !ok {
+		return "", storage.ErrInvalidSort
+	}
+
+	dir := "ASC"
+	if sort.Desc {
+		dir = "DESC"
+	}
+
+	if column == "id" {
+		return ` ORDER BY id ` + dir, nil
+	}
+
+	// NULLS LAST: в MySQL NULL меньше любого значения
+	return ` ORDER BY ` + column + ` IS NULL, ` + column + ` ` + dir + `, id ` + dir, nil
+}
+
+// isErr reports whether err is the server error with the number.
+func isErr(err error, number uint16) bool {
+	var myErr *mysql.MySQLError
+
+	return errors.As(err, &myErr) && myErr.Number == number
+}
+
+// expectRow returns notFound if the statement matched no rows.
+func expectRow(res sql.Result, notFound error) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return notFound
+	}
+
+	return nil
+}
+
+// jsonArray encodes ss for a JSON column, nil as an empty array.
+func jsonArray(ss []string) string {
+	if ss == nil {
+		return "[]"
+	}
+
+	b, _ := json.Marshal(ss)
+
+	return string(b)
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+// placeholders returns n comma-separated copies of the placeholder group.
+func placeholders(n int, group string) string {
+	return strings.TrimSuffix(strings.Repeat(group+", ", n), ", ")
+}
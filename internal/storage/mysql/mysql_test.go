@@ -0,0 +1,224 @@
+package mysql_test
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"os"
+	"sso/internal/domain/models"
+	"sso/internal/storage"
+	"sso/internal/storage/mysql"
+	"sso/migrations"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dsnEnv names the variable with the DSN of an empty MySQL database for the
+// tests, e.g. "root:secret@tcp(localhost:3306)/sso_test"; they are skipped without it.
+const dsnEnv = "SSO_TEST_MYSQL_DSN"
+
+func newStorage(t *testing.T) *mysql.Storage {
+	t.Helper()
+
+	dsn := os.Getenv(dsnEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", dsnEnv)
+	}
+
+	s, err := mysql.New(dsn)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	t.Cleanup(s.Close)
+
+	if _, err := s.Migrate(migrations.Source("mysql", "")); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+
+	return s
+}
+
+// uniqueEmail keeps tests independent of data left by earlier runs.
+func uniqueEmail() string {
+	return strings.ToLower(rand.Text()) + "@example.com"
+}
+
+func TestUsers(t *testing.T) {
+	ctx := context.Background()
+	s := newStorage(t)
+
+	email := uniqueEmail()
+
+	id, err := s.SaveUser(ctx, email, []byte("hash"), "user")
+	if err != nil {
+		t.Fatalf("SaveUser() error = %v", err)
+	}
+
+	if _, err := s.SaveUser(ctx, email, []byte("hash"), "user"); !errors.Is(err, storage.ErrUserExists) {
+		t.Errorf("SaveUser() of taken email: error = %v, want %v", err, storage.ErrUserExists)
+	}
+
+	user, err := s.User(ctx, email)
+	if err != nil {
+		t.Fatalf("User() error = %v", err)
+	}
+	if user.ID != id || user.Email != email || string(user.PassHash) != "hash" || user.Role != "user" {
+		t.Errorf("User() = %+v", user)
+	}
+
+	if err := s.UpdateRole(ctx, id, "admin"); err != nil {
+		t.Fatalf("UpdateRole() error = %v", err)
+	}
+	if user, err = s.UserByID(ctx, id); err != nil || user.Role != "admin" {
+		t.Errorf("UserByID() = %+v, %v, want role admin", user, err)
+	}
+
+	exists, err := s.UserExists(ctx, email)
+	if err != nil || !exists {
+		t.Errorf("UserExists() = %v, %v, want true", exists, err)
+	}
+	exists, err = s.UserExists(ctx, uniqueEmail())
+	if err != nil || exists {
+		t.Errorf("UserExists() of unknown email = %v, %v, want false", exists, err)
+	}
+
+	if _, err := s.User(ctx, uniqueEmail()); !errors.Is(err, storage.ErrUserNotFound) {
+		t.Errorf("User() of unknown email: error = %v, want %v", err, storage.ErrUserNotFound)
+	}
+	if err := s.UpdateRole(ctx, -1, "admin"); !errors.Is(err, storage.ErrUserNotFound) {
+		t.Errorf("UpdateRole() of unknown user: error = %v, want %v", err, storage.ErrUserNotFound)
+	}
+}
+
+func TestInTxRollback(t *testing.T) {
+	ctx := context.Background()
+	s := newStorage(t)
+
+	email := uniqueEmail()
+	errAbort := errors.New("abort")
+
+	err := s.InTx(ctx, func(ctx context.Context) error {
+		if _, err := s.SaveUser(ctx, email, []byte("hash"), "user"); err != nil {
+			return err
+		}
+
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("InTx() error = %v, want %v", err, errAbort)
+	}
+
+	if _, err := s.User(ctx, email); !errors.Is(err, storage.ErrUserNotFound) {
+		t.Errorf("User() after rollback: error = %v, want %v", err, storage.ErrUserNotFound)
+	}
+}
+
+func TestUseJTI(t *testing.T) {
+	ctx := context.Background()
+	s := newStorage(t)
+
+	jti := rand.Text()
+
+	if err := s.UseJTI(ctx, jti, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("UseJTI() error = %v", err)
+	}
+	if err := s.UseJTI(ctx, jti, time.Now().Add(time.Hour)); !errors.Is(err, storage.ErrJTIUsed) {
+		t.Errorf("UseJTI() again: error = %v, want %v", err, storage.ErrJTIUsed)
+	}
+
+	// Истёкшая запись не мешает использовать id снова
+	expired := rand.Text()
+	if err := s.UseJTI(ctx, expired, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("UseJTI() error = %v", err)
+	}
+	if err := s.UseJTI(ctx, expired, time.Now().Add(time.Hour)); err != nil {
+		t.Errorf("UseJTI() of expired jti: error = %v", err)
+	}
+}
+
+func TestConsumeAuthorizationCode(t *testing.T) {
+	ctx := context.Background()
+	s := newStorage(t)
+
+	userID, err := s.SaveUser(ctx, uniqueEmail(), []byte("hash"), "user")
+	if err != nil {
+		t.Fatalf("SaveUser() error = %v", err)
+	}
+
+	appID, err := s.SaveApp(ctx, models.App{Name: "app-" + rand.Text(), Secret: "secret"})
+	if err != nil {
+		t.Fatalf("SaveApp() error = %v", err)
+	}
+
+	code := models.AuthorizationCode{
+		Hash:                []byte(rand.Text()),
+		AppID:               appID,
+		UserID:              userID,
+		RedirectURI:         "https://app.example.com/callback",
+		CodeChallenge:       "challenge",
+		CodeChallengeMethod: "S256",
+		ExpiresAt:           time.Now().Add(time.Minute),
+	}
+	if err := s.SaveAuthorizationCode(ctx, code); err != nil {
+		t.Fatalf("SaveAuthorizationCode() error = %v", err)
+	}
+
+	got, err := s.ConsumeAuthorizationCode(ctx, code.Hash)
+	if err != nil {
+		t.Fatalf("ConsumeAuthorizationCode() error = %v", err)
+	}
+	if got.UserID != userID || got.AppID != appID || got.RedirectURI != code.RedirectURI || got.CodeChallengeMethod != "S256" {
+		t.Errorf("ConsumeAuthorizationCode() = %+v", got)
+	}
+
+	if _, err := s.ConsumeAuthorizationCode(ctx, code.Hash); !errors.Is(err, storage.ErrTokenNotFound) {
+		t.Errorf("ConsumeAuthorizationCode() again: error = %v, want %v", err, storage.ErrTokenNotFound)
+	}
+
+	expired := code
+	expired.Hash = []byte(rand.Text())
+	expired.ExpiresAt = time.Now().Add(-time.Minute)
+	if err := s.SaveAuthorizationCode(ctx, expired); err != nil {
+		t.Fatalf("SaveAuthorizationCode() error = %v", err)
+	}
+	if _, err := s.ConsumeAuthorizationCode(ctx, expired.Hash); !errors.Is(err, storage.ErrTokenNotFound) {
+		t.Errorf("ConsumeAuthorizationCode() of expired code: error = %v, want %v", err, storage.ErrTokenNotFound)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	ctx := context.Background()
+	s := newStorage(t)
+
+	userID, err := s.SaveUser(ctx, uniqueEmail(), []byte("hash"), "user")
+	if err != nil {
+		t.Fatalf("SaveUser() error = %v", err)
+	}
+
+	appID, err := s.SaveApp(ctx, models.App{Name: "app-" + rand.Text(), Secret: "secret"})
+	if err != nil {
+		t.Fatalf("SaveApp() error = %v", err)
+	}
+
+	id, err := s.SaveSession(ctx, models.Session{UserID: userID, AppID: appID, IP: "192.0.2.1", ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("SaveSession() error = %v", err)
+	}
+
+	sessions, err := s.Sessions(ctx, userID)
+	if err != nil || len(sessions) != 1 || sessions[0].ID != id {
+		t.Fatalf("Sessions() = %+v, %v, want session %d", sessions, err, id)
+	}
+
+	if err := s.RevokeSession(ctx, userID+1, id); !errors.Is(err, storage.ErrSessionNotFound) {
+		t.Errorf("RevokeSession() of another user: error = %v, want %v", err, storage.ErrSessionNotFound)
+	}
+	if err := s.RevokeSession(ctx, userID, id); err != nil {
+		t.Fatalf("RevokeSession() error = %v", err)
+	}
+
+	if sessions, err := s.Sessions(ctx, userID); err != nil || len(sessions) != 0 {
+		t.Errorf("Sessions() after revoke = %+v, %v, want none", sessions, err)
+	}
+}
@@ -0,0 +1,195 @@
+package mysql
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sso/internal/domain/models"
+	"sso/internal/storage"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestUserWhere(t *testing.T) {
+	tests := []struct {
+		name     string
+		filter   models.UserFilter
+		wantSQL  string
+		wantArgs []any
+	}{
+		{name: "zero", wantSQL: " WHERE deleted_at IS NULL"},
+		{name: "deleted", filter: models.UserFilter{Deleted: true}, wantSQL: ""},
+		{
+			name:     "all",
+			filter:   models.UserFilter{Role: "admin", EmailPrefix: "a_b%", OrgID: 7},
+			wantSQL:  " WHERE role = ? AND email LIKE ? AND org_id = ? AND deleted_at IS NULL",
+			wantArgs: []any{"admin", `a\_b\%%`, int64(7)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sql, args := userWhere(tt.filter)
+			if sql != tt.wantSQL {
+				t.Errorf("sql = %q, want %q", sql, tt.wantSQL)
+			}
+			if !slices.Equal(args, tt.wantArgs) {
+				t.Errorf("args = %v, want %v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestLikePrefix(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "ann", want: "ann%"},
+		{in: "100%", want: `100\%%`},
+		{in: "a_b", want: `a\_b%`},
+		{in: `c:\d`, want: `c:\\d%`},
+	}
+
+	for _, tt := range tests {
+		if got := likePrefix(tt.in); got != tt.want {
+			t.Errorf("likePrefix(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestOrderBy(t *testing.T) {
+	tests := []struct {
+		name    string
+		sort    models.UserSort
+		want    string
+		wantErr error
+	}{
+		{name: "default", want: " ORDER BY id ASC"},
+		{name: "id desc", sort: models.UserSort{Field: models.UserSortID, Desc: true}, want: " ORDER BY id DESC"},
+		{
+			name: "nulls last",
+			sort: models.UserSort{Field: models.UserSortLastLoginAt},
+			want: " ORDER BY last_login_at IS NULL, last_login_at ASC, id ASC",
+		},
+		// Поле сортировки никогда не попадает в запрос как есть
+		{name: "injection", sort: models.UserSort{Field: "id; DROP TABLE users"}, wantErr: storage.ErrInvalidSort},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := orderBy(tt.sort)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("orderBy() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("orderBy() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUserKeyset(t *testing.T) {
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		sort     models.UserSort
+		cursor   models.UserCursor
+		wantSQL  string
+		wantArgs []any
+		wantErr  bool
+	}{
+		{
+			name:     "id",
+			cursor:   models.UserCursor{ID: 10},
+			wantSQL:  "id > ?",
+			wantArgs: []any{int64(10)},
+		},
+		{
+			name:     "email desc",
+			sort:     models.UserSort{Field: models.UserSortEmail, Desc: true},
+			cursor:   models.UserCursor{Value: "b@example.com", ID: 10},
+			wantSQL:  "(email < ? OR (email = ? AND id < ?) OR email IS NULL)",
+			wantArgs: []any{"b@example.com", "b@example.com", int64(10)},
+		},
+		{
+			name:     "null",
+			sort:     models.UserSort{Field: models.UserSortLastLoginAt},
+			cursor:   models.UserCursor{Null: true, ID: 10},
+			wantSQL:  "(last_login_at IS NULL AND id > ?)",
+			wantArgs: []any{int64(10)},
+		},
+		{
+			name:     "time",
+			sort:     models.UserSort{Field: models.UserSortCreatedAt},
+			cursor:   models.UserCursor{Value: at.Format(time.RFC3339Nano), ID: 10},
+			wantSQL:  "(created_at > ? OR (created_at = ? AND id > ?) OR created_at IS NULL)",
+			wantArgs: []any{at, at, int64(10)},
+		},
+		{
+			name:    "bad time",
+			sort:    models.UserSort{Field: models.UserSortCreatedAt},
+			cursor:  models.UserCursor{Value: "yesterday", ID: 10},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sql, args, err := userKeyset(tt.sort, tt.cursor)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("userKeyset() error = %v, want error %v", err, tt.wantErr)
+			}
+			if sql != tt.wantSQL {
+				t.Errorf("sql = %q, want %q", sql, tt.wantSQL)
+			}
+			if fmt.Sprint(args) != fmt.Sprint(tt.wantArgs) {
+				t.Errorf("args = %v, want %v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestPlaceholders(t *testing.T) {
+	tests := []struct {
+		n     int
+		group string
+		want  string
+	}{
+		{n: 0, group: "?", want: ""},
+		{n: 1, group: "?", want: "?"},
+		{n: 3, group: "(?, ?)", want: "(?, ?), (?, ?), (?, ?)"},
+	}
+
+	for _, tt := range tests {
+		if got := placeholders(tt.n, tt.group); got != tt.want {
+			t.Errorf("placeholders(%d, %q) = %q, want %q", tt.n, tt.group, got, tt.want)
+		}
+	}
+}
+
+func TestJSONArray(t *testing.T) {
+	if got := jsonArray(nil); got != "[]" {
+		t.Errorf("jsonArray(nil) = %q, want %q", got, "[]")
+	}
+	if got, want := jsonArray([]string{"a", `"b"`}), `["a","\"b\""]`; got != want {
+		t.Errorf("jsonArray() = %q, want %q", got, want)
+	}
+}
+
+func TestIsErr(t *testing.T) {
+	dup := fmt.Errorf("insert: %w", &mysql.MySQLError{Number: errDupEntry, Message: "Duplicate entry"})
+
+	if !isErr(dup, errDupEntry) {
+		t.Error("wrapped duplicate entry is not recognized")
+	}
+	if isErr(dup, errNoSuchTable) {
+		t.Error("duplicate entry is taken for another error")
+	}
+	if isErr(errors.New("Duplicate entry"), errDupEntry) {
+		t.Error("non-server error is taken for a duplicate entry")
+	}
+}
@@ -0,0 +1,112 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+type txKey struct{}
+
+// txn is a transaction of the storage. Begun inside InTx it is a savepoint
+// of the transaction of InTx: Commit releases it, Rollback rolls back to it.
+type txn struct {
+	*sql.Tx
+	ctx       context.Context
+	savepoint string
+	depth     int
+	done      bool
+}
+
+func (tx *txn) Commit() error {
+	if tx.savepoint == "" {
+		return tx.Tx.Commit()
+	}
+
+	if _, err := tx.Tx.ExecContext(tx.ctx, "RELEASE SAVEPOINT "+tx.savepoint); err != nil {
+		return err
+	}
+	tx.done = true
+
+	return nil
+}
+
+func (tx *txn) Rollback() error {
+	if tx.savepoint == "" {
+		return tx.Tx.Rollback()
+	}
+
+	if tx.done {
+		return sql.ErrTxDone
+	}
+	tx.done = true
+
+	_, err := tx.Tx.ExecContext(tx.ctx, "ROLLBACK TO SAVEPOINT "+tx.savepoint)
+
+	return err
+}
+
+// conn is *sql.DB or *sql.Tx.
+type conn interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// conn runs statements of a call: on the transaction of InTx carried by ctx,
+// otherwise on the pool.
+func (s *Storage) conn(ctx context.Context) conn {
+	if tx, ok := ctx.Value(txKey{}).(*txn); ok {
+		return tx.Tx
+	}
+
+	return s.db
+}
+
+// begin starts a transaction, or a savepoint inside InTx.
+func (s *Storage) begin(ctx context.Context) (*txn, error) {
+	outer, ok := ctx.Value(txKey{}).(*txn)
+	if !ok {
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		return &txn{Tx: tx, ctx: ctx}, nil
+	}
+
+	depth := outer.depth + 1
+	savepoint := fmt.Sprintf("sp%d", depth)
+	if _, err := outer.Tx.ExecContext(ctx, "SAVEPOINT "+savepoint); err != nil {
+		return nil, err
+	}
+
+	return &txn{Tx: outer.Tx, ctx: ctx, savepoint: savepoint, depth: depth}, nil
+}
+
+// InTx runs fn in a transaction: the calls made with the ctx passed to fn
+// commit together if fn returns nil and roll back otherwise. InTx called
+// inside fn joins the transaction.
+func (s *Storage) InTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	const op = "storage.mysql.InTx"
+
+	if _, ok := ctx.Value(txKey{}).(*txn); ok {
+		return fn(ctx)
+	}
+
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(context.WithValue(ctx, txKey{}, tx)); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,306 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sso/internal/lib/breaker"
+	"sso/internal/storage"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// pool bounds the wait for a free connection by acquireTimeout and retries
+// transient failures of statements run outside of transactions. pgxpool
+// waits until the context of the query is done, so an exhausted pool holds
+// calls for their whole deadline.
+type pool struct {
+	*pgxpool.Pool
+	// acquireTimeout is zero to wait as pgxpool does.
+	acquireTimeout time.Duration
+	// read retries SELECT statements and Begin, write the other statements.
+	read  RetryPolicy
+	write RetryPolicy
+	// breaker is nil unless calls fail fast while the database is failing.
+	breaker *breaker.Breaker
+}
+
+// errAcquireTimeout means the pool stayed exhausted for acquireTimeout.
+var errAcquireTimeout = errors.New("timed out waiting for a free connection")
+
+func (p *pool) acquire(ctx context.Context) (*pgxpool.Conn, error) {
+	acquireCtx, cancel := context.WithTimeout(ctx, p.acquireTimeout)
+	defer cancel()
+
+	c, err := p.Pool.Acquire(acquireCtx)
+	if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+		return nil, errAcquireTimeout
+	}
+	if err != nil {
+		return nil, fmt.Errorf("acquire connection: %w", err)
+	}
+
+	return c, nil
+}
+
+// do runs fn by the retry policy unless the breaker is open, and reports
+// to the breaker whether the database failed.
+func (p *pool) do(ctx context.Context, policy RetryPolicy, read bool, fn func() error) error {
+	if err := p.breaker.Allow(); err != nil {
+		return storage.ErrUnavailable
+	}
+
+	err := policy.do(ctx, read, fn)
+	p.breaker.Done(unavailable(err))
+
+	return err
+}
+
+// unavailable tells failures of the database itself, which trip the breaker,
+// from errors of statements and calls cancelled by the caller.
+func unavailable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	if errors.Is(err, errAcquireTimeout) {
+		return true
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// connection_exception и operator_intervention: сервер недоступен или останавливается
+		return strings.HasPrefix(pgErr.Code, "08") || strings.HasPrefix(pgErr.Code, "57P")
+	}
+
+	var (
+		connectErr *pgconn.ConnectError
+		netErr     net.Error
+	)
+
+	return errors.As(err, &connectErr) || errors.As(err, &netErr) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET)
+}
+
+// policy returns the retry policy of the statement and whether it only reads.
+func (p *pool) policy(sql string) (RetryPolicy, bool) {
+	sql = strings.TrimSpace(sql)
+	if len(sql) >= 6 && strings.EqualFold(sql[:6], "SELECT") {
+		return p.read, true
+	}
+
+	return p.write, false
+}
+
+func (p *pool) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	policy, read := p.policy(sql)
+
+	var tag pgconn.CommandTag
+	err := p.do(ctx, policy, read, func() error {
+		var err error
+		tag, err = p.exec(ctx, sql, args...)
+
+		return err
+	})
+
+	return tag, err
+}
+
+func (p *pool) exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	if p.acquireTimeout == 0 {
+		return p.Pool.Exec(ctx, sql, args...)
+	}
+
+	c, err := p.acquire(ctx)
+	if err != nil {
+		return pgconn.CommandTag{}, err
+	}
+	defer c.Release()
+
+	return c.Exec(ctx, sql, args...)
+}
+
+// Query retries failures of the call only; errors met while reading
+// the rows are returned as is.
+func (p *pool) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	policy, read := p.policy(sql)
+
+	var rows pgx.Rows
+	err := p.do(ctx, policy, read, func() error {
+		var err error
+		rows, err = p.query(ctx, sql, args...)
+
+		return err
+	})
+
+	return rows, err
+}
+
+func (p *pool) query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	if p.acquireTimeout == 0 {
+		return p.Pool.Query(ctx, sql, args...)
+	}
+
+	c, err := p.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := c.Query(ctx, sql, args...)
+	if err != nil {
+		c.Release()
+
+		return nil, err
+	}
+
+	return &connRows{Rows: rows, conn: c}, nil
+}
+
+// QueryRow runs the statement on Scan, so that failures found there are retried too.
+func (p *pool) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return retryRow{p: p, ctx: ctx, sql: sql, args: args}
+}
+
+// Begin is retried with the read policy, nothing is written before it succeeds.
+func (p *pool) Begin(ctx context.Context) (pgx.Tx, error) {
+	var tx pgx.Tx
+	err := p.do(ctx, p.read, true, func() error {
+		var err error
+		tx, err = p.begin(ctx)
+
+		return err
+	})
+
+	return tx, err
+}
+
+func (p *pool) begin(ctx context.Context) (pgx.Tx, error) {
+	if p.acquireTimeout == 0 {
+		return p.Pool.Begin(ctx)
+	}
+
+	c, err := p.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := c.Begin(ctx)
+	if err != nil {
+		c.Release()
+
+		return nil, err
+	}
+
+	return &connTx{Tx: tx, conn: c}, nil
+}
+
+type retryRow struct {
+	p    *pool
+	ctx  context.Context
+	sql  string
+	args []any
+}
+
+func (r retryRow) Scan(dest ...any) error {
+	policy, read := r.p.policy(r.sql)
+
+	return r.p.do(r.ctx, policy, read, func() error {
+		if r.p.acquireTimeout == 0 {
+			return r.p.Pool.QueryRow(r.ctx, r.sql, r.args...).Scan(dest...)
+		}
+
+		rows, err := r.p.query(r.ctx, r.sql, r.args...)
+
+		return connRow{rows: rows, err: err}.Scan(dest...)
+	})
+}
+
+// connRows returns the connection to the pool once the rows are read or closed.
+type connRows struct {
+	pgx.Rows
+	conn *pgxpool.Conn
+}
+
+func (r *connRows) Next() bool {
+	if r.Rows.Next() {
+		return true
+	}
+
+	r.release()
+
+	return false
+}
+
+func (r *connRows) Close() {
+	r.Rows.Close()
+	r.release()
+}
+
+func (r *connRows) release() {
+	if r.conn != nil {
+		r.conn.Release()
+		r.conn = nil
+	}
+}
+
+// connRow is pgx.Row over connRows, scanning the first row like pgx does.
+type connRow struct {
+	rows pgx.Rows
+	err  error
+}
+
+func (r connRow) Scan(dest ...any) error {
+	if r.err != nil {
+		return r.err
+	}
+	defer r.rows.Close()
+
+	if !r.rows.Next() {
+		if err := r.rows.Err(); err != nil {
+			return err
+		}
+
+		return pgx.ErrNoRows
+	}
+
+	if err := r.rows.Scan(dest...); err != nil {
+		return err
+	}
+	r.rows.Close()
+
+	return r.rows.Err()
+}
+
+// connTx returns the connection to the pool on commit or rollback.
+type connTx struct {
+	pgx.Tx
+	conn *pgxpool.Conn
+}
+
+func (t *connTx) Commit(ctx context.Context) error {
+	err := t.Tx.Commit(ctx)
+	t.release()
+
+	return err
+}
+
+func (t *connTx) Rollback(ctx context.Context) error {
+	err := t.Tx.Rollback(ctx)
+	t.release()
+
+	return err
+}
+
+func (t *connTx) release() {
+	if t.conn != nil {
+		t.conn.Release()
+		t.conn = nil
+	}
+}
@@ -1,40 +1,237 @@
 package postgres
 
 import (
+	"cmp"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"os"
+	"io/fs"
+	"log/slog"
+	"slices"
 	"sso/internal/domain/models"
+	"sso/internal/lib/breaker"
+	"sso/internal/lib/fault"
 	"sso/internal/storage"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/golang-migrate/migrate/v4"
+	pgxmigrate "github.com/golang-migrate/migrate/v4/database/pgx/v5"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
 )
 
+// resolveUserID follows the redirect left by MergeUsers for user id $1.
+const resolveUserID = `COALESCE((SELECT new_id FROM user_redirects WHERE old_id = $1), $1)`
+
+// userColumns are selected by every query returning models.User, see scanUser.
+const userColumns = `id, COALESCE(email, ''), COALESCE(username, ''), COALESCE(phone, ''), phone_verified,
+	COALESCE(avatar_url, ''), pass_hash, role, created_at, last_login_at, COALESCE(org_id, 0), deleted_at, status`
+
 type Storage struct {
-	pool *pgxpool.Pool
+	pool *pool
+	// replica is nil unless set by WithReplica, see reader.
+	replica *pool
+}
+
+// Option adjusts pool configuration.
+type Option func(cfg *config)
+
+type config struct {
+	*pgxpool.Config
+	acquireTimeout time.Duration
+	read           RetryPolicy
+	write          RetryPolicy
+	breaker        *breaker.Breaker
+	replicaDSN     string
+	replicaBreaker *breaker.Breaker
+}
+
+// WithFaultInjection fails queries of requests marked by the fault injector.
+func WithFaultInjection() Option {
+	return func(cfg *config) {
+		cfg.PrepareConn = func(ctx context.Context, _ *pgx.Conn) (bool, error) {
+			return true, fault.StorageError(ctx)
+		}
+	}
+}
+
+// WithPoolSize keeps at least minConns and at most maxConns connections;
+// zero keeps the default of pgxpool.
+func WithPoolSize(minConns int32, maxConns int32) Option {
+	return func(cfg *config) {
+		if minConns > 0 {
+			cfg.MinConns = minConns
+		}
+		if maxConns > 0 {
+			cfg.MaxConns = maxConns
+		}
+	}
+}
+
+// WithMaxConnLifetime closes connections older than d, so that the pool
+// follows failovers and DNS changes; zero keeps the default of pgxpool.
+func WithMaxConnLifetime(d time.Duration) Option {
+	return func(cfg *config) {
+		if d > 0 {
+			cfg.MaxConnLifetime = d
+		}
+	}
+}
+
+// WithHealthCheckPeriod checks idle connections every d; zero keeps the default of pgxpool.
+func WithHealthCheckPeriod(d time.Duration) Option {
+	return func(cfg *config) {
+		if d > 0 {
+			cfg.HealthCheckPeriod = d
+		}
+	}
+}
+
+// WithAcquireTimeout fails queries waiting for a free connection longer than d
+// instead of waiting until the deadline of the call.
+func WithAcquireTimeout(d time.Duration) Option {
+	return func(cfg *config) {
+		cfg.acquireTimeout = d
+	}
+}
+
+// WithSlowQueryLog logs queries taking threshold or longer with the method
+// running them and the request id.
+func WithSlowQueryLog(log *slog.Logger, threshold time.Duration) Option {
+	return func(cfg *config) {
+		cfg.ConnConfig.Tracer = &slowQueryTracer{log: log, threshold: threshold}
+	}
 }
 
-func New() (*Storage, error) {
+// WithBreaker fails calls with storage.ErrUnavailable while b is open.
+// Lost connections and an exhausted pool count as failures of the database.
+func WithBreaker(b *breaker.Breaker) Option {
+	return func(cfg *config) {
+		cfg.breaker = b
+	}
+}
+
+// New connects to the database at dsn, a URL or key=value string of libpq.
+func New(dsn string, opts ...Option) (*Storage, error) {
 	const op = "storage.postgres.New"
 
-	dsn := os.Getenv("DATABASE_URL")
+	poolCfg, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid dsn: %w", op, err)
+	}
+
+	cfg := &config{Config: poolCfg}
+	for _, opt := range opts {
+		opt(cfg)
+	}
 
-	if dsn == "" {
-		return nil, fmt.Errorf("%s: DATABASE_URL isn't set", op)
+	if cfg.MinConns > cfg.MaxConns {
+		return nil, fmt.Errorf("%s: min conns %d exceed max conns %d", op, cfg.MinConns, cfg.MaxConns)
 	}
 
-	pool, err := pgxpool.New(context.Background(), dsn)
+	p, err := pgxpool.NewWithConfig(context.Background(), cfg.Config)
 	if err != nil {
 		return nil, fmt.Errorf("%s: cannot connect to db: %w", op, err)
 	}
-	return &Storage{pool: pool}, nil
+	s := &Storage{pool: &pool{Pool: p, acquireTimeout: cfg.acquireTimeout, read: cfg.read, write: cfg.write, breaker: cfg.breaker}}
+
+	if cfg.replicaDSN != "" {
+		s.replica, err = newReplica(cfg)
+		if err != nil {
+			p.Close()
+
+			return nil, fmt.Errorf("%s: cannot connect to replica: %w", op, err)
+		}
+	}
+
+	return s, nil
 }
 
 func (s *Storage) Close() {
 	s.pool.Close()
+	if s.replica != nil {
+		s.replica.Close()
+	}
+}
+
+// Ping checks that the database is reachable.
+func (s *Storage) Ping(ctx context.Context) error {
+	return s.pool.Ping(ctx)
+}
+
+// SchemaVersion returns the version of the last migration applied by
+// golang-migrate and whether it failed halfway. Zero means no migrations yet.
+func (s *Storage) SchemaVersion(ctx context.Context) (version uint, dirty bool, err error) {
+	const op = "storage.postgres.SchemaVersion"
+
+	err = s.db(ctx).QueryRow(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	if err != nil {
+		var pgErr *pgconn.PgError
+
+		if errors.Is(err, pgx.ErrNoRows) || errors.As(err, &pgErr) && pgErr.Code == "42P01" {
+			return 0, false, nil
+		}
+
+		return 0, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return version, dirty, nil
+}
+
+// Migrator returns the golang-migrate instance applying migrations of source
+// to the database. Instances migrating at once wait for each other on an
+// advisory lock. It must be closed.
+func (s *Storage) Migrator(source fs.FS) (*migrate.Migrate, error) {
+	const op = "storage.postgres.Migrator"
+
+	src, err := iofs.New(source, ".")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Закрытие db не закрывает пул
+	driver, err := pgxmigrate.WithInstance(stdlib.OpenDBFromPool(s.pool.Pool), &pgxmigrate.Config{})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	m, err := migrate.NewWithInstance("iofs", src, "pgx5", driver)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return m, nil
+}
+
+// Migrate applies the up migrations of source the database doesn't have yet
+// and returns the resulting version.
+func (s *Storage) Migrate(source fs.FS) (uint, error) {
+	const op = "storage.postgres.Migrate"
+
+	m, err := s.Migrator(source)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	defer m.Close()
+
+	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	version, _, err := m.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return version, nil
 }
 
 func (s *Storage) SaveUser(
@@ -45,8 +242,14 @@ func (s *Storage) SaveUser(
 ) (int64, error) {
 	const op = "storage.postgres.SaveUser"
 
+	tx, err := s.db(ctx).Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback(ctx)
+
 	var id int64
-	err := s.pool.QueryRow(ctx,
+	err = tx.QueryRow(ctx,
 		`INSERT INTO users(email, pass_hash, role) 
 			VALUES ($1, $2, $3) 
 			RETURNING id`,
@@ -62,20 +265,67 @@ func (s *Storage) SaveUser(
 		return 0, fmt.Errorf("%s: %w", op, err)
 	}
 
+	if err := enqueueEvent(ctx, tx, models.EventUserRegistered, models.EventUser{UserID: id, Email: email, Role: role}); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return id, nil
+}
+
+// SavePhoneUser creates user identified by phone number instead of email.
+func (s *Storage) SavePhoneUser(
+	ctx context.Context,
+	phone string,
+	passHash []byte,
+	role string,
+) (int64, error) {
+	const op = "storage.postgres.SavePhoneUser"
+
+	tx, err := s.db(ctx).Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback(ctx)
+
+	var id int64
+	err = tx.QueryRow(ctx,
+		`INSERT INTO users(phone, pass_hash, role)
+			VALUES ($1, $2, $3)
+			RETURNING id`,
+		phone, passHash, role,
+	).Scan(&id)
+	if err != nil {
+		var pgErr *pgconn.PgError
+
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrUserExists)
+		}
+
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := enqueueEvent(ctx, tx, models.EventUserRegistered, models.EventUser{UserID: id, Phone: phone, Role: role}); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
 	return id, nil
 }
 
 func (s *Storage) User(ctx context.Context, email string) (models.User, error) {
 	const op = "storage.postgres.User"
 
-	var user models.User
-
-	err := s.pool.QueryRow(ctx,
-		`SELECT id, email, pass_hash, role 
-			FROM users WHERE email = $1`,
+	user, err := scanUser(s.reader(ctx).QueryRow(ctx,
+		`SELECT `+userColumns+` FROM users WHERE email = $1 AND deleted_at IS NULL`,
 		email,
-	).Scan(&user.ID, &user.Email, &user.PassHash, &user.Role)
-
+	))
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return models.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
@@ -90,14 +340,31 @@ func (s *Storage) User(ctx context.Context, email string) (models.User, error) {
 func (s *Storage) UserByID(ctx context.Context, userID int64) (models.User, error) {
 	const op = "storage.postgres.User"
 
-	var user models.User
-
-	err := s.pool.QueryRow(ctx,
-		`SELECT id, email, pass_hash, role 
-			FROM users WHERE id = $1`,
+	user, err := scanUser(s.db(ctx).QueryRow(ctx,
+		`SELECT `+userColumns+` FROM users WHERE id = `+resolveUserID,
 		userID,
-	).Scan(&user.ID, &user.Email, &user.PassHash, &user.Role)
+	))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+
+		return user, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return user, nil
+}
 
+// UserByIdentity returns user linked to the account at an external identity provider.
+func (s *Storage) UserByIdentity(ctx context.Context, provider string, subject string) (models.User, error) {
+	const op = "storage.postgres.UserByIdentity"
+
+	user, err := scanUser(s.db(ctx).QueryRow(ctx,
+		`SELECT `+userColumns+` FROM users
+			WHERE id = (SELECT user_id FROM user_identities WHERE provider = $1 AND subject = $2)
+				AND deleted_at IS NULL`,
+		provider, subject,
+	))
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return models.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
@@ -109,22 +376,65 @@ func (s *Storage) UserByID(ctx context.Context, userID int64) (models.User, erro
 	return user, nil
 }
 
-func (s *Storage) UpdateRole(ctx context.Context, userID int64, role string) error {
-	const op = "storage.postgres.UpdateUserRole"
+// SaveIdentity links the account at an external identity provider to the user.
+func (s *Storage) SaveIdentity(ctx context.Context, userID int64, provider string, subject string) error {
+	const op = "storage.postgres.SaveIdentity"
+
+	_, err := s.db(ctx).Exec(ctx,
+		`INSERT INTO user_identities(provider, subject, user_id) VALUES ($1, $2, $3)`,
+		provider, subject, userID,
+	)
+	if err != nil {
+		var pgErr *pgconn.PgError
+
+		if errors.As(err, &pgErr) {
+			switch pgErr.Code {
+			case "23505":
+				return fmt.Errorf("%s: %w", op, storage.ErrIdentityExists)
+			case "23503":
+				return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+			}
+		}
 
-	validRoles := map[string]bool{
-		"admin":     true,
-		"user":      true,
-		"organizer": true,
+		return fmt.Errorf("%s: %w", op, err)
 	}
-	if !validRoles[role] {
-		return fmt.Errorf("%s: invalid role: %s", op, role)
+
+	return nil
+}
+
+// UserByUsername returns user by its public handle.
+func (s *Storage) UserByUsername(ctx context.Context, username string) (models.User, error) {
+	const op = "storage.postgres.UserByUsername"
+
+	user, err := scanUser(s.db(ctx).QueryRow(ctx,
+		`SELECT `+userColumns+` FROM users WHERE username = $1 AND deleted_at IS NULL`,
+		username,
+	))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+
+		return models.User{}, fmt.Errorf("%s: %w", op, err)
 	}
 
-	res, err := s.pool.Exec(ctx,
-		`UPDATE users SET role = $1 WHERE id = $2`, role, userID,
+	return user, nil
+}
+
+// SetUsername sets or clears (empty username) the public handle of the user.
+func (s *Storage) SetUsername(ctx context.Context, userID int64, username string) error {
+	const op = "storage.postgres.SetUsername"
+
+	res, err := s.db(ctx).Exec(ctx,
+		`UPDATE users SET username = NULLIF($1, '') WHERE id = $2`, username, userID,
 	)
 	if err != nil {
+		var pgErr *pgconn.PgError
+
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return fmt.Errorf("%s: %w", op, storage.ErrUsernameTaken)
+		}
+
 		return fmt.Errorf("%s: %w", op, err)
 	}
 
@@ -135,63 +445,2876 @@ func (s *Storage) UpdateRole(ctx context.Context, userID int64, role string) err
 	return nil
 }
 
-func (s *Storage) App(ctx context.Context, appID int) (models.App, error) {
-	const op = "storage.postgres.App"
-
-	var app models.App
+// UserByPhone returns user by E.164 phone number. Unverified numbers may be
+// set on several users; the one who verified the number comes first.
+func (s *Storage) UserByPhone(ctx context.Context, phone string) (models.User, error) {
+	const op = "storage.postgres.UserByPhone"
 
-	err := s.pool.QueryRow(ctx, `SELECT id, name, secret FROM apps WHERE id = $1`, appID).Scan(&app.ID, &app.Name, &app.Secret)
+	user, err := scanUser(s.db(ctx).QueryRow(ctx,
+		`SELECT `+userColumns+` FROM users WHERE phone = $1 AND deleted_at IS NULL
+			ORDER BY phone_verified DESC, id LIMIT 1`,
+		phone,
+	))
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			return models.App{}, fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+			return models.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
 		}
 
-		return app, fmt.Errorf("%s: %w", op, err)
+		return models.User{}, fmt.Errorf("%s: %w", op, err)
 	}
 
-	return app, nil
+	return user, nil
+}
+
+// SetPhone sets a new, not yet verified, phone number of the user.
+// Unverified numbers don't reserve them, see MarkPhoneVerified.
+func (s *Storage) SetPhone(ctx context.Context, userID int64, phone string) error {
+	const op = "storage.postgres.SetPhone"
 
+	res, err := s.db(ctx).Exec(ctx,
+		`UPDATE users SET phone = $1, phone_verified = FALSE WHERE id = $2`, phone, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
 }
 
-func (s *Storage) GetUserRole(ctx context.Context, userID int64) (string, error) {
-	const op = "storage.postgres.GetUserRole"
-	var role string
+// MarkPhoneVerified marks phone of the user as verified, if it is still the
+// given one, and takes the number from other users who haven't verified it.
+// It fails with storage.ErrPhoneTaken if another user has verified it.
+func (s *Storage) MarkPhoneVerified(ctx context.Context, userID int64, phone string) error {
+	const op = "storage.postgres.MarkPhoneVerified"
 
-	err := s.pool.QueryRow(ctx, `SELECT role FROM users WHERE id = $1`, userID).Scan(&role)
+	tx, err := s.db(ctx).Begin(ctx)
 	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return "", fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback(ctx)
+
+	res, err := tx.Exec(ctx,
+		`UPDATE users SET phone_verified = TRUE WHERE id = $1 AND phone = $2`, userID, phone,
+	)
+	if err != nil {
+		var pgErr *pgconn.PgError
+
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return fmt.Errorf("%s: %w", op, storage.ErrPhoneTaken)
 		}
 
-		return "", fmt.Errorf("%s: %w", op, err)
+		return fmt.Errorf("%s: %w", op, err)
 	}
 
-	return role, nil
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	_, err = tx.Exec(ctx,
+		`UPDATE users SET phone = NULL WHERE phone = $1 AND NOT phone_verified`, phone,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
 }
 
-func (s *Storage) ListUsers(ctx context.Context) ([]models.User, error) {
-	const op = "storage.postgres.ListUsers"
+// SetAvatarURL sets or clears (empty url) the avatar of the user.
+func (s *Storage) SetAvatarURL(ctx context.Context, userID int64, url string) error {
+	const op = "storage.postgres.SetAvatarURL"
+
+	res, err := s.db(ctx).Exec(ctx,
+		`UPDATE users SET avatar_url = NULLIF($1, '') WHERE id = $2`, url, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+// UpdatePassHash replaces the password hash of the user.
+func (s *Storage) UpdatePassHash(ctx context.Context, userID int64, passHash []byte) error {
+	const op = "storage.postgres.UpdatePassHash"
+
+	res, err := s.db(ctx).Exec(ctx, `UPDATE users SET pass_hash = $1 WHERE id = $2`, passHash, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
 
-	rows, err := s.pool.Query(ctx, `SELECT id, email, pass_hash, role FROM users`)
+// Preferences returns all preferences of the user.
+func (s *Storage) Preferences(ctx context.Context, userID int64) (map[string]string, error) {
+	const op = "storage.postgres.Preferences"
+
+	rows, err := s.db(ctx).Query(ctx,
+		`SELECT key, value FROM user_preferences WHERE user_id = $1`, userID,
+	)
 	if err != nil {
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 	defer rows.Close()
 
-	var users []models.User
+	prefs := make(map[string]string)
 	for rows.Next() {
-		var u models.User
-		err = rows.Scan(&u.ID, &u.Email, &u.PassHash, &u.Role)
-		if err != nil {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
 			return nil, fmt.Errorf("%s: %w", op, err)
 		}
-		users = append(users, u)
+		prefs[key] = value
 	}
 
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	return users, nil
+	return prefs, nil
+}
+
+// SetPreference sets preference of the user; empty value removes it.
+func (s *Storage) SetPreference(ctx context.Context, userID int64, key string, value string) error {
+	const op = "storage.postgres.SetPreference"
+
+	if value == "" {
+		if _, err := s.db(ctx).Exec(ctx,
+			`DELETE FROM user_preferences WHERE user_id = $1 AND key = $2`, userID, key,
+		); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		return nil
+	}
+
+	_, err := s.db(ctx).Exec(ctx,
+		`INSERT INTO user_preferences(user_id, key, value)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (user_id, key) DO UPDATE SET value = EXCLUDED.value, updated_at = now()`,
+		userID, key, value,
+	)
+	if err != nil {
+		var pgErr *pgconn.PgError
+
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// UserMetadata returns the metadata object the app attached to the user, "{}" if none.
+func (s *Storage) UserMetadata(ctx context.Context, userID int64, appID int) (json.RawMessage, error) {
+	const op = "storage.postgres.UserMetadata"
+
+	var data json.RawMessage
+
+	err := s.db(ctx).QueryRow(ctx,
+		`SELECT COALESCE(metadata -> $2::text, '{}') FROM users WHERE id = $1`, userID, strconv.Itoa(appID),
+	).Scan(&data)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return data, nil
+}
+
+// SetUserMetadata replaces the metadata object of the app on the user; nil data removes it.
+func (s *Storage) SetUserMetadata(ctx context.Context, userID int64, appID int, data json.RawMessage) error {
+	const op = "storage.postgres.SetUserMetadata"
+
+	query := `UPDATE users SET metadata = jsonb_set(metadata, ARRAY[$2::text], $3::jsonb) WHERE id = $1`
+	args := []any{userID, strconv.Itoa(appID), string(data)}
+	if data == nil {
+		query = `UPDATE users SET metadata = metadata - $2::text WHERE id = $1`
+		args = args[:2]
+	}
+
+	res, err := s.db(ctx).Exec(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+// UserExists reports whether a user with the email is registered.
+func (s *Storage) UserExists(ctx context.Context, email string) (bool, error) {
+	const op = "storage.postgres.UserExists"
+
+	var exists bool
+
+	err := s.db(ctx).QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM users WHERE email = $1)`, email,
+	).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return exists, nil
+}
+
+func (s *Storage) CountUsers(ctx context.Context, filter models.UserFilter) (int64, error) {
+	const op = "storage.postgres.CountUsers"
+
+	where, args := userWhere(filter, 0)
+
+	var count int64
+
+	if err := s.db(ctx).QueryRow(ctx, `SELECT count(*) FROM users`+where, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return count, nil
+}
+
+// TouchLogin records successful login time of the user.
+func (s *Storage) TouchLogin(ctx context.Context, userID int64) error {
+	const op = "storage.postgres.TouchLogin"
+
+	if _, err := s.db(ctx).Exec(ctx,
+		`UPDATE users SET last_login_at = now() WHERE id = $1`, userID,
+	); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) UpdateRole(ctx context.Context, userID int64, role string) error {
+	const op = "storage.postgres.UpdateUserRole"
+
+	tx, err := s.db(ctx).Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback(ctx)
+
+	res, err := tx.Exec(ctx,
+		`UPDATE users SET role = $1 WHERE id = $2`, role, userID,
+	)
+	if err != nil {
+		var pgErr *pgconn.PgError
+
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			return fmt.Errorf("%s: %w", op, storage.ErrRoleNotFound)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	if err := enqueueEvent(ctx, tx, models.EventUserRoleChanged, models.EventUser{UserID: userID, Role: role}); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// Role returns the role with its permissions.
+func (s *Storage) Role(ctx context.Context, name string) (models.Role, error) {
+	const op = "storage.postgres.Role"
+
+	var role models.Role
+
+	err := s.db(ctx).QueryRow(ctx,
+		`SELECT r.name, r.description, r.rank, r.self_assignable, r.created_at,
+				ARRAY(SELECT permission FROM role_permissions WHERE role = r.name ORDER BY permission)
+			FROM roles r WHERE r.name = $1`,
+		name,
+	).Scan(&role.Name, &role.Description, &role.Rank, &role.SelfAssignable, &role.CreatedAt, &role.Permissions)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.Role{}, fmt.Errorf("%s: %w", op, storage.ErrRoleNotFound)
+		}
+
+		return models.Role{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return role, nil
+}
+
+// Roles returns all roles, most privileged first.
+func (s *Storage) Roles(ctx context.Context) ([]models.Role, error) {
+	const op = "storage.postgres.Roles"
+
+	rows, err := s.db(ctx).Query(ctx,
+		`SELECT r.name, r.description, r.rank, r.self_assignable, r.created_at,
+				ARRAY(SELECT permission FROM role_permissions WHERE role = r.name ORDER BY permission)
+			FROM roles r ORDER BY r.rank DESC, r.name`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var roles []models.Role
+	for rows.Next() {
+		var role models.Role
+		if err := rows.Scan(&role.Name, &role.Description, &role.Rank, &role.SelfAssignable, &role.CreatedAt, &role.Permissions); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		roles = append(roles, role)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return roles, nil
+}
+
+// SaveRole creates the role with its permissions.
+func (s *Storage) SaveRole(ctx context.Context, role models.Role) error {
+	const op = "storage.postgres.SaveRole"
+
+	tx, err := s.db(ctx).Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO roles(name, description, rank, self_assignable) VALUES ($1, $2, $3, $4)`,
+		role.Name, role.Description, role.Rank, role.SelfAssignable,
+	)
+	if err != nil {
+		var pgErr *pgconn.PgError
+
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return fmt.Errorf("%s: %w", op, storage.ErrRoleExists)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := setPermissions(ctx, tx, role.Name, role.Permissions); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// EditRole replaces the description, rank, self-assignability and permissions of the role.
+func (s *Storage) EditRole(ctx context.Context, role models.Role) error {
+	const op = "storage.postgres.EditRole"
+
+	tx, err := s.db(ctx).Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback(ctx)
+
+	res, err := tx.Exec(ctx,
+		`UPDATE roles SET description = $2, rank = $3, self_assignable = $4 WHERE name = $1`,
+		role.Name, role.Description, role.Rank, role.SelfAssignable,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrRoleNotFound)
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM role_permissions WHERE role = $1`, role.Name); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := setPermissions(ctx, tx, role.Name, role.Permissions); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func setPermissions(ctx context.Context, tx pgx.Tx, role string, permissions []string) error {
+	_, err := tx.Exec(ctx,
+		`INSERT INTO role_permissions(role, permission) SELECT $1, unnest($2::TEXT[])
+			ON CONFLICT DO NOTHING`,
+		role, permissions,
+	)
+
+	return err
+}
+
+// DeleteRole deletes the role unless it is assigned to users or api keys.
+func (s *Storage) DeleteRole(ctx context.Context, name string) error {
+	const op = "storage.postgres.DeleteRole"
+
+	res, err := s.db(ctx).Exec(ctx, `DELETE FROM roles WHERE name = $1`, name)
+	if err != nil {
+		var pgErr *pgconn.PgError
+
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			return fmt.Errorf("%s: %w", op, storage.ErrRoleInUse)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrRoleNotFound)
+	}
+
+	return nil
+}
+
+// appColumns are selected by every query returning models.App, see scanApp.
+const appColumns = `id, name, secret, redirect_uris, public, scopes, COALESCE(org_id, 0), created_at,
+	COALESCE(previous_secret, ''), previous_secret_expires_at, token_ttl_seconds, audience, COALESCE(client_cert_subject, '')`
+
+func (s *Storage) App(ctx context.Context, appID int) (models.App, error) {
+	const op = "storage.postgres.App"
+
+	app, err := scanApp(s.reader(ctx).QueryRow(ctx, `SELECT `+appColumns+` FROM apps WHERE id = $1`, appID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.App{}, fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+		}
+
+		return app, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return app, nil
+
+}
+
+// AppByCertSubject returns the app whose client certificate has the identity.
+func (s *Storage) AppByCertSubject(ctx context.Context, subject string) (models.App, error) {
+	const op = "storage.postgres.AppByCertSubject"
+
+	app, err := scanApp(s.db(ctx).QueryRow(ctx, `SELECT `+appColumns+` FROM apps WHERE client_cert_subject = $1`, subject))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.App{}, fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+		}
+
+		return app, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return app, nil
+}
+
+// Apps returns apps of the organization, or all apps if orgID is zero, ordered by id.
+func (s *Storage) Apps(ctx context.Context, orgID int64) ([]models.App, error) {
+	const op = "storage.postgres.Apps"
+
+	rows, err := s.db(ctx).Query(ctx,
+		`SELECT `+appColumns+` FROM apps WHERE $1 = 0 OR org_id = $1 ORDER BY id`, orgID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var apps []models.App
+	for rows.Next() {
+		app, err := scanApp(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		apps = append(apps, app)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return apps, nil
+}
+
+// SaveApp registers the app and returns its id.
+func (s *Storage) SaveApp(ctx context.Context, app models.App) (int, error) {
+	const op = "storage.postgres.SaveApp"
+
+	var id int
+
+	err := s.db(ctx).QueryRow(ctx,
+		`INSERT INTO apps(name, secret, redirect_uris, public, scopes, org_id, token_ttl_seconds, audience, client_cert_subject)
+			VALUES ($1, $2, $3, $4, $5, NULLIF($6, 0), $7, $8, NULLIF($9, '')) RETURNING id`,
+		app.Name, app.Secret, app.RedirectURIs, app.Public, app.Scopes, app.OrgID, int(app.TokenTTL.Seconds()), app.Audience, app.CertSubject,
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, appErr(err))
+	}
+
+	return id, nil
+}
+
+// UpdateApp changes settings of the app. The secret and organization stay.
+func (s *Storage) UpdateApp(ctx context.Context, app models.App) error {
+	const op = "storage.postgres.UpdateApp"
+
+	res, err := s.db(ctx).Exec(ctx,
+		`UPDATE apps SET name = $1, redirect_uris = $2, public = $3, scopes = $4, token_ttl_seconds = $5, audience = $6,
+			client_cert_subject = NULLIF($7, '') WHERE id = $8`,
+		app.Name, app.RedirectURIs, app.Public, app.Scopes, int(app.TokenTTL.Seconds()), app.Audience, app.CertSubject, app.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, appErr(err))
+	}
+
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+	}
+
+	return nil
+}
+
+// RotateAppSecret replaces the secret of the app, keeping the current one as
+// the previous secret until previousExpiresAt.
+func (s *Storage) RotateAppSecret(ctx context.Context, appID int, secret string, previousExpiresAt time.Time) error {
+	const op = "storage.postgres.RotateAppSecret"
+
+	res, err := s.db(ctx).Exec(ctx,
+		`UPDATE apps SET previous_secret = secret, previous_secret_expires_at = $3, secret = $2 WHERE id = $1`,
+		appID, secret, previousExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, appErr(err))
+	}
+
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+	}
+
+	return nil
+}
+
+// DeleteApp removes the app with its API keys and authorization codes.
+func (s *Storage) DeleteApp(ctx context.Context, appID int) error {
+	const op = "storage.postgres.DeleteApp"
+
+	res, err := s.db(ctx).Exec(ctx, `DELETE FROM apps WHERE id = $1`, appID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+	}
+
+	return nil
+}
+
+// appErr maps unique violations of app name, secret or certificate subject to storage.ErrAppExists
+// and a missing organization to storage.ErrOrgNotFound.
+func appErr(err error) error {
+	var pgErr *pgconn.PgError
+
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "23505":
+			return storage.ErrAppExists
+		case "23503":
+			return storage.ErrOrgNotFound
+		}
+	}
+
+	return err
+}
+
+func (s *Storage) GetUserRole(ctx context.Context, userID int64) (string, error) {
+	const op = "storage.postgres.GetUserRole"
+	var role string
+
+	err := s.reader(ctx).QueryRow(ctx, `SELECT role FROM users WHERE id = `+resolveUserID, userID).Scan(&role)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return role, nil
+}
+
+// ListUsers returns up to limit users matching the filter after the cursor
+// in the order of sort; zero limit returns all of them. Paging by the sort
+// value and id of the last user (keyset) keeps pages consistent while users
+// are added and doesn't slow down on far pages as OFFSET would.
+func (s *Storage) ListUsers(ctx context.Context, filter models.UserFilter, sort models.UserSort, after models.UserCursor, limit int) ([]models.User, error) {
+	const op = "storage.postgres.ListUsers"
+
+	order, err := orderBy(sort)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	where, args := userWhere(filter, 0)
+
+	if after.ID != 0 {
+		cond, condArgs := userKeyset(sort, after, len(args))
+		if where == "" {
+			where = ` WHERE ` + cond
+		} else {
+			where += ` AND ` + cond
+		}
+		args = append(args, condArgs...)
+	}
+
+	if limit > 0 {
+		args = append(args, limit)
+		order += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	rows, err := s.reader(ctx).Query(ctx, `SELECT `+userColumns+` FROM users`+where+order, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		users = append(users, u)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return users, nil
+}
+
+// SearchUsers returns up to limit users whose email or username contains query
+// or is similar to it by trigrams, most similar first. Zero orgID searches all
+// organizations.
+func (s *Storage) SearchUsers(ctx context.Context, query string, orgID int64, limit int) ([]models.User, error) {
+	const op = "storage.postgres.SearchUsers"
+
+	args := []any{"%" + likePrefix(query), query, limit}
+	org := ""
+	if orgID != 0 {
+		args = append(args, orgID)
+		org = ` AND org_id = $4`
+	}
+
+	// Both ILIKE and % are served by the trigram indexes on email and username.
+	rows, err := s.db(ctx).Query(ctx,
+		`SELECT `+userColumns+` FROM users
+			WHERE (email ILIKE $1 OR username ILIKE $1 OR email % $2 OR username % $2)
+				AND deleted_at IS NULL`+org+`
+			ORDER BY GREATEST(similarity(email, $2), similarity(COALESCE(username, ''), $2)) DESC, id
+			LIMIT $3`,
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		users = append(users, u)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return users, nil
+}
+
+// UseJTI records a one-time token id until it expires.
+// Returns storage.ErrJTIUsed if the id was already recorded and hasn't expired yet.
+func (s *Storage) UseJTI(ctx context.Context, jti string, expiresAt time.Time) error {
+	const op = "storage.postgres.UseJTI"
+
+	res, err := s.db(ctx).Exec(ctx,
+		`INSERT INTO used_jtis(jti, expires_at)
+			VALUES ($1, $2)
+			ON CONFLICT (jti) DO UPDATE SET expires_at = EXCLUDED.expires_at
+			WHERE used_jtis.expires_at < now()`,
+		jti, expiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrJTIUsed)
+	}
+
+	return nil
+}
+
+// ReserveIdempotencyKey records the key as taken by a call in progress until
+// lockedUntil and keeps it until expiresAt. If the key is already recorded,
+// hasn't expired and isn't left by a call past lockedUntil, returns its record
+// and storage.ErrIdempotencyKeyExists.
+func (s *Storage) ReserveIdempotencyKey(ctx context.Context, scope string, key string, requestHash []byte, lockedUntil time.Time, expiresAt time.Time) (models.IdempotencyRecord, error) {
+	const op = "storage.postgres.ReserveIdempotencyKey"
+
+	res, err := s.db(ctx).Exec(ctx,
+		`INSERT INTO idempotency_keys(scope, key, request_hash, locked_until, expires_at)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (scope, key) DO UPDATE SET
+				request_hash = EXCLUDED.request_hash, code = NULL, message = '', response = NULL,
+				created_at = now(), completed_at = NULL,
+				locked_until = EXCLUDED.locked_until, expires_at = EXCLUDED.expires_at
+			WHERE idempotency_keys.expires_at < now()
+				OR idempotency_keys.completed_at IS NULL AND idempotency_keys.locked_until < now()`,
+		scope, key, requestHash, lockedUntil, expiresAt,
+	)
+	if err != nil {
+		return models.IdempotencyRecord{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if res.RowsAffected() == 1 {
+		return models.IdempotencyRecord{}, nil
+	}
+
+	var rec models.IdempotencyRecord
+	err = s.db(ctx).QueryRow(ctx,
+		`SELECT request_hash, completed_at IS NOT NULL, COALESCE(code, 0), message, response
+			FROM idempotency_keys WHERE scope = $1 AND key = $2`,
+		scope, key,
+	).Scan(&rec.RequestHash, &rec.Completed, &rec.Code, &rec.Message, &rec.Response)
+	// Ключ мог освободиться между запросами, тогда отвечаем как для вызова в процессе
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return models.IdempotencyRecord{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return rec, fmt.Errorf("%s: %w", op, storage.ErrIdempotencyKeyExists)
+}
+
+// CompleteIdempotencyKey stores the outcome of the call holding the key.
+func (s *Storage) CompleteIdempotencyKey(ctx context.Context, scope string, key string, rec models.IdempotencyRecord) error {
+	const op = "storage.postgres.CompleteIdempotencyKey"
+
+	_, err := s.db(ctx).Exec(ctx,
+		`UPDATE idempotency_keys SET code = $3, message = $4, response = $5, completed_at = now()
+			WHERE scope = $1 AND key = $2`,
+		scope, key, rec.Code, rec.Message, rec.Response,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ReleaseIdempotencyKey forgets the key of a call that didn't complete, so that
+// a retry runs it again.
+func (s *Storage) ReleaseIdempotencyKey(ctx context.Context, scope string, key string) error {
+	const op = "storage.postgres.ReleaseIdempotencyKey"
+
+	_, err := s.db(ctx).Exec(ctx,
+		`DELETE FROM idempotency_keys WHERE scope = $1 AND key = $2 AND completed_at IS NULL`,
+		scope, key,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// mergeUserQueries move everything of user $2 to user $1 before MergeUsers
+// deletes $2, which would cascade to it. Where both users have a row and
+// only one may stay, the row of $1 is kept.
+var mergeUserQueries = []string{
+	// Social logins of the merged account keep working.
+	`UPDATE user_identities SET user_id = $1 WHERE user_id = $2`,
+	`UPDATE login_attempts SET user_id = $1 WHERE user_id = $2`,
+	// Group memberships move to the surviving account.
+	`INSERT INTO group_members(group_id, user_id, added_at)
+		SELECT group_id, $1, added_at FROM group_members WHERE user_id = $2
+		ON CONFLICT DO NOTHING`,
+	`UPDATE sessions SET user_id = $1 WHERE user_id = $2`,
+	`UPDATE refresh_tokens SET user_id = $1 WHERE user_id = $2`,
+	`UPDATE authorization_codes SET user_id = $1 WHERE user_id = $2`,
+	`UPDATE passkeys SET user_id = $1 WHERE user_id = $2`,
+	// Recovery codes belong to the authenticator and move before it.
+	`UPDATE recovery_codes SET user_id = $1
+		WHERE user_id = $2 AND NOT EXISTS (SELECT 1 FROM user_totp WHERE user_id = $1)`,
+	`UPDATE user_totp SET user_id = $1
+		WHERE user_id = $2 AND NOT EXISTS (SELECT 1 FROM user_totp WHERE user_id = $1)`,
+	`INSERT INTO user_preferences(user_id, key, value, updated_at)
+		SELECT $1, key, value, updated_at FROM user_preferences WHERE user_id = $2
+		ON CONFLICT DO NOTHING`,
+	`UPDATE users u SET metadata = f.metadata || u.metadata FROM users f WHERE u.id = $1 AND f.id = $2`,
+	// Tokens of $2 are checked against the revocations of $1 from now on,
+	// so those revoked stay revoked, at the cost of earlier tokens of $1.
+	`INSERT INTO user_token_revocations(user_id, revoked_before)
+		SELECT $1, revoked_before FROM user_token_revocations WHERE user_id = $2
+		ON CONFLICT (user_id) DO UPDATE
+		SET revoked_before = GREATEST(user_token_revocations.revoked_before, EXCLUDED.revoked_before)`,
+}
+
+// MergeUsers moves everything owned by user fromID to user intoID, sets role of the
+// resulting account, removes fromID and leaves a redirect record for it.
+func (s *Storage) MergeUsers(ctx context.Context, fromID int64, intoID int64, role string) error {
+	const op = "storage.postgres.MergeUsers"
+
+	tx, err := s.db(ctx).Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback(ctx)
+
+	res, err := tx.Exec(ctx, `UPDATE users SET role = $1 WHERE id = $2`, role, intoID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	// Earlier redirects to the merged account now point to the surviving one.
+	if _, err := tx.Exec(ctx,
+		`UPDATE user_redirects SET new_id = $1 WHERE new_id = $2`, intoID, fromID,
+	); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err := tx.Exec(ctx,
+		`INSERT INTO user_redirects(old_id, new_id) VALUES ($1, $2)`, fromID, intoID,
+	); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, query := range mergeUserQueries {
+		if _, err := tx.Exec(ctx, query, intoID, fromID); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err := enqueueEvent(ctx, tx, models.EventUserMerged, models.EventUser{UserID: fromID, IntoID: intoID}); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	res, err = tx.Exec(ctx, `DELETE FROM users WHERE id = $1`, fromID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// SetUserStatus sets one of the models.UserStatus* statuses of the user.
+func (s *Storage) SetUserStatus(ctx context.Context, userID int64, status string) error {
+	const op = "storage.postgres.SetUserStatus"
+
+	res, err := s.db(ctx).Exec(ctx, `UPDATE users SET status = $2 WHERE id = $1`, userID, status)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+// SoftDeleteUser marks the user deleted, keeping the record for RestoreUser.
+// Deleting an already deleted user keeps the original time.
+func (s *Storage) SoftDeleteUser(ctx context.Context, userID int64) error {
+	const op = "storage.postgres.SoftDeleteUser"
+
+	tx, err := s.db(ctx).Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback(ctx)
+
+	var alreadyDeleted bool
+
+	err = tx.QueryRow(ctx,
+		`UPDATE users SET deleted_at = COALESCE(deleted_at, now()) WHERE id = $1
+			RETURNING deleted_at < now()`,
+		userID,
+	).Scan(&alreadyDeleted)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Повторное удаление уже удалённого пользователя событием не считается
+	if !alreadyDeleted {
+		if err := enqueueEvent(ctx, tx, models.EventUserDeleted, models.EventUser{UserID: userID}); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// RestoreUser undoes SoftDeleteUser. Anonymized users are not found.
+func (s *Storage) RestoreUser(ctx context.Context, userID int64) error {
+	const op = "storage.postgres.RestoreUser"
+
+	res, err := s.db(ctx).Exec(ctx, `UPDATE users SET deleted_at = NULL WHERE id = $1 AND anonymized_at IS NULL`, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+// DeleteUser removes the user together with credentials and everything else
+// the user owns. Tables referencing users are cleaned up by ON DELETE CASCADE;
+// codes and tokens keyed by email or phone are removed explicitly.
+func (s *Storage) DeleteUser(ctx context.Context, userID int64) error {
+	const op = "storage.postgres.DeleteUser"
+
+	tx, err := s.db(ctx).Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback(ctx)
+
+	var email, phone string
+
+	err = tx.QueryRow(ctx,
+		`DELETE FROM users WHERE id = $1 RETURNING COALESCE(email, ''), COALESCE(phone, '')`, userID,
+	).Scan(&email, &phone)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err := tx.Exec(ctx,
+		`DELETE FROM otp_codes WHERE key IN ($1, $2)`, email, phone,
+	); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err := tx.Exec(ctx,
+		`DELETE FROM one_time_tokens WHERE subject IN ($1, $2::text)`, strings.ToLower(email), userID,
+	); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := enqueueEvent(ctx, tx, models.EventUserDeleted, models.EventUser{UserID: userID}); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// AnonymizeUser scrubs personal data of the user but keeps the row, so that
+// other services referencing the user id stay consistent. Credentials,
+// sessions, login history and everything else the user owns are removed;
+// the audit log is kept. Anonymized users can't be restored.
+func (s *Storage) AnonymizeUser(ctx context.Context, userID int64) error {
+	const op = "storage.postgres.AnonymizeUser"
+
+	tx, err := s.db(ctx).Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback(ctx)
+
+	var email, phone string
+
+	err = tx.QueryRow(ctx,
+		`SELECT COALESCE(email, ''), COALESCE(phone, '') FROM users WHERE id = $1 FOR UPDATE`, userID,
+	).Scan(&email, &phone)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err := tx.Exec(ctx,
+		`UPDATE users SET email = NULL, username = NULL, phone = NULL, phone_verified = FALSE,
+			avatar_url = NULL, pass_hash = '', metadata = '{}',
+			deleted_at = COALESCE(deleted_at, now()), anonymized_at = now()
+			WHERE id = $1`,
+		userID,
+	); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, table := range []string{
+		"user_preferences", "refresh_tokens", "user_totp", "passkeys", "recovery_codes",
+		"authorization_codes", "user_identities", "sessions", "login_attempts",
+		"login_failures", "group_members",
+	} {
+		if _, err := tx.Exec(ctx, `DELETE FROM `+table+` WHERE user_id = $1`, userID); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if _, err := tx.Exec(ctx,
+		`DELETE FROM otp_codes WHERE key IN ($1, $2)`, email, phone,
+	); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err := tx.Exec(ctx,
+		`DELETE FROM one_time_tokens WHERE subject IN ($1, $2::text)`, strings.ToLower(email), userID,
+	); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := enqueueEvent(ctx, tx, models.EventUserDeleted, models.EventUser{UserID: userID}); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// SaveTOTP stores a new unconfirmed authenticator secret of the user, replacing the previous one.
+func (s *Storage) SaveTOTP(ctx context.Context, userID int64, secret []byte) error {
+	const op = "storage.postgres.SaveTOTP"
+
+	_, err := s.db(ctx).Exec(ctx,
+		`INSERT INTO user_totp(user_id, secret) VALUES ($1, $2)
+			ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, confirmed_at = NULL, last_step = 0`,
+		userID, secret,
+	)
+	if err != nil {
+		var pgErr *pgconn.PgError
+
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) TOTP(ctx context.Context, userID int64) (models.TOTP, error) {
+	const op = "storage.postgres.TOTP"
+
+	totp := models.TOTP{UserID: userID}
+
+	err := s.db(ctx).QueryRow(ctx,
+		`SELECT secret, confirmed_at IS NOT NULL, last_step FROM user_totp WHERE user_id = $1`, userID,
+	).Scan(&totp.Secret, &totp.Confirmed, &totp.LastStep)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.TOTP{}, fmt.Errorf("%s: %w", op, storage.ErrTOTPNotFound)
+		}
+
+		return models.TOTP{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return totp, nil
+}
+
+// UseTOTPStep records the time step of an accepted code. Returns storage.ErrTOTPStepUsed
+// if this or a later step was already used, so every code is accepted once.
+func (s *Storage) UseTOTPStep(ctx context.Context, userID int64, step int64) error {
+	const op = "storage.postgres.UseTOTPStep"
+
+	res, err := s.db(ctx).Exec(ctx,
+		`UPDATE user_totp SET last_step = $2 WHERE user_id = $1 AND last_step < $2`, userID, step,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrTOTPStepUsed)
+	}
+
+	return nil
+}
+
+func (s *Storage) ConfirmTOTP(ctx context.Context, userID int64) error {
+	const op = "storage.postgres.ConfirmTOTP"
+
+	res, err := s.db(ctx).Exec(ctx,
+		`UPDATE user_totp SET confirmed_at = now() WHERE user_id = $1`, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrTOTPNotFound)
+	}
+
+	return nil
+}
+
+func (s *Storage) SetSMSMFA(ctx context.Context, userID int64, enabled bool) error {
+	const op = "storage.postgres.SetSMSMFA"
+
+	res, err := s.db(ctx).Exec(ctx, `UPDATE users SET sms_mfa = $2 WHERE id = $1`, userID, enabled)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+func (s *Storage) SMSMFA(ctx context.Context, userID int64) (bool, error) {
+	const op = "storage.postgres.SMSMFA"
+
+	var enabled bool
+
+	err := s.db(ctx).QueryRow(ctx, `SELECT sms_mfa FROM users WHERE id = $1`, userID).Scan(&enabled)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return enabled, nil
+}
+
+// ReplaceRecoveryCodes drops all recovery codes of the user and stores new ones.
+func (s *Storage) ReplaceRecoveryCodes(ctx context.Context, userID int64, hashes [][]byte) error {
+	const op = "storage.postgres.ReplaceRecoveryCodes"
+
+	tx, err := s.db(ctx).Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, hash := range hashes {
+		if _, err := tx.Exec(ctx,
+			`INSERT INTO recovery_codes(user_id, code_hash) VALUES ($1, $2)`, userID, hash,
+		); err != nil {
+			var pgErr *pgconn.PgError
+
+			if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+				return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+			}
+
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ResetMFA drops the authenticator and recovery codes of the user and turns
+// off SMS codes, so the next login needs only the password.
+func (s *Storage) ResetMFA(ctx context.Context, userID int64) error {
+	const op = "storage.postgres.ResetMFA"
+
+	tx, err := s.db(ctx).Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback(ctx)
+
+	res, err := tx.Exec(ctx, `UPDATE users SET sms_mfa = false WHERE id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM user_totp WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// UseRecoveryCode marks the code used. Returns storage.ErrRecoveryCodeNotFound
+// for unknown and already used codes.
+func (s *Storage) UseRecoveryCode(ctx context.Context, userID int64, hash []byte) error {
+	const op = "storage.postgres.UseRecoveryCode"
+
+	res, err := s.db(ctx).Exec(ctx,
+		`UPDATE recovery_codes SET used_at = now() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`,
+		userID, hash,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrRecoveryCodeNotFound)
+	}
+
+	return nil
+}
+
+func (s *Storage) SavePasskey(ctx context.Context, passkey models.Passkey) error {
+	const op = "storage.postgres.SavePasskey"
+
+	_, err := s.db(ctx).Exec(ctx,
+		`INSERT INTO passkeys(id, user_id, public_key, sign_count) VALUES ($1, $2, $3, $4)`,
+		passkey.ID, passkey.UserID, passkey.PublicKey, int64(passkey.SignCount),
+	)
+	if err != nil {
+		var pgErr *pgconn.PgError
+
+		if errors.As(err, &pgErr) {
+			switch pgErr.Code {
+			case "23505":
+				return fmt.Errorf("%s: %w", op, storage.ErrPasskeyExists)
+			case "23503":
+				return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+			}
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) Passkey(ctx context.Context, id []byte) (models.Passkey, error) {
+	const op = "storage.postgres.Passkey"
+
+	var (
+		passkey    = models.Passkey{ID: id}
+		signCount  int64
+		lastUsedAt *time.Time
+	)
+
+	err := s.db(ctx).QueryRow(ctx,
+		`SELECT user_id, public_key, sign_count, created_at, last_used_at FROM passkeys WHERE id = $1`, id,
+	).Scan(&passkey.UserID, &passkey.PublicKey, &signCount, &passkey.CreatedAt, &lastUsedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.Passkey{}, fmt.Errorf("%s: %w", op, storage.ErrPasskeyNotFound)
+		}
+
+		return models.Passkey{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	passkey.SignCount = uint32(signCount)
+	if lastUsedAt != nil {
+		passkey.LastUsedAt = *lastUsedAt
+	}
+
+	return passkey, nil
+}
+
+// TouchPasskey records use of the passkey with the new signature counter.
+func (s *Storage) TouchPasskey(ctx context.Context, id []byte, signCount uint32) error {
+	const op = "storage.postgres.TouchPasskey"
+
+	res, err := s.db(ctx).Exec(ctx,
+		`UPDATE passkeys SET sign_count = $2, last_used_at = now() WHERE id = $1`, id, int64(signCount),
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrPasskeyNotFound)
+	}
+
+	return nil
+}
+
+// SaveOTP stores a one-time code, replacing the previous one for the same key and purpose.
+func (s *Storage) SaveOTP(ctx context.Context, otp models.OTP) error {
+	const op = "storage.postgres.SaveOTP"
+
+	_, err := s.db(ctx).Exec(ctx,
+		`INSERT INTO otp_codes(key, purpose, code_hash, expires_at, attempts)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (key, purpose) DO UPDATE
+			SET code_hash = EXCLUDED.code_hash, expires_at = EXCLUDED.expires_at, attempts = EXCLUDED.attempts`,
+		otp.Key, otp.Purpose, otp.CodeHash, otp.ExpiresAt, otp.Attempts,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) OTP(ctx context.Context, key string, purpose string) (models.OTP, error) {
+	const op = "storage.postgres.OTP"
+
+	otp := models.OTP{Key: key, Purpose: purpose}
+
+	err := s.db(ctx).QueryRow(ctx,
+		`SELECT code_hash, expires_at, attempts FROM otp_codes WHERE key = $1 AND purpose = $2`,
+		key, purpose,
+	).Scan(&otp.CodeHash, &otp.ExpiresAt, &otp.Attempts)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.OTP{}, fmt.Errorf("%s: %w", op, storage.ErrOTPNotFound)
+		}
+
+		return models.OTP{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return otp, nil
+}
+
+func (s *Storage) IncrementOTPAttempts(ctx context.Context, key string, purpose string) error {
+	const op = "storage.postgres.IncrementOTPAttempts"
+
+	_, err := s.db(ctx).Exec(ctx,
+		`UPDATE otp_codes SET attempts = attempts + 1 WHERE key = $1 AND purpose = $2`, key, purpose,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// DeleteOTP removes the code. Returns storage.ErrOTPNotFound if it was already removed,
+// which lets concurrent consumers of the same code detect the loser.
+func (s *Storage) DeleteOTP(ctx context.Context, key string, purpose string) error {
+	const op = "storage.postgres.DeleteOTP"
+
+	res, err := s.db(ctx).Exec(ctx,
+		`DELETE FROM otp_codes WHERE key = $1 AND purpose = $2`, key, purpose,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrOTPNotFound)
+	}
+
+	return nil
+}
+
+func (s *Storage) SaveOneTimeToken(ctx context.Context, token models.OneTimeToken) error {
+	const op = "storage.postgres.SaveOneTimeToken"
+
+	_, err := s.db(ctx).Exec(ctx,
+		`INSERT INTO one_time_tokens(token_hash, purpose, subject, expires_at) VALUES ($1, $2, $3, $4)`,
+		token.Hash, token.Purpose, token.Subject, token.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ConsumeOneTimeToken marks the token consumed and returns it. The update is
+// conditional, so of concurrent consumers exactly one succeeds; the rest and
+// expired tokens get storage.ErrTokenNotFound.
+func (s *Storage) ConsumeOneTimeToken(ctx context.Context, hash []byte, purpose string) (models.OneTimeToken, error) {
+	const op = "storage.postgres.ConsumeOneTimeToken"
+
+	token := models.OneTimeToken{Hash: hash, Purpose: purpose}
+
+	err := s.db(ctx).QueryRow(ctx,
+		`UPDATE one_time_tokens SET consumed_at = now()
+			WHERE token_hash = $1 AND purpose = $2 AND consumed_at IS NULL AND expires_at > now()
+			RETURNING subject, expires_at, consumed_at`,
+		hash, purpose,
+	).Scan(&token.Subject, &token.ExpiresAt, &token.ConsumedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.OneTimeToken{}, fmt.Errorf("%s: %w", op, storage.ErrTokenNotFound)
+		}
+
+		return models.OneTimeToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return token, nil
+}
+
+func (s *Storage) SaveAuthorizationCode(ctx context.Context, code models.AuthorizationCode) error {
+	const op = "storage.postgres.SaveAuthorizationCode"
+
+	_, err := s.db(ctx).Exec(ctx,
+		`INSERT INTO authorization_codes(code_hash, app_id, user_id, redirect_uri, scope, nonce,
+			code_challenge, code_challenge_method, expires_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		code.Hash, code.AppID, code.UserID, code.RedirectURI, code.Scope, code.Nonce,
+		code.CodeChallenge, code.CodeChallengeMethod, code.ExpiresAt,
+	)
+	if err != nil {
+		var pgErr *pgconn.PgError
+
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ConsumeAuthorizationCode marks the code consumed and returns it, like ConsumeOneTimeToken.
+func (s *Storage) ConsumeAuthorizationCode(ctx context.Context, hash []byte) (models.AuthorizationCode, error) {
+	const op = "storage.postgres.ConsumeAuthorizationCode"
+
+	code := models.AuthorizationCode{Hash: hash}
+
+	err := s.db(ctx).QueryRow(ctx,
+		`UPDATE authorization_codes SET consumed_at = now()
+			WHERE code_hash = $1 AND consumed_at IS NULL AND expires_at > now()
+			RETURNING app_id, user_id, redirect_uri, scope, nonce, code_challenge, code_challenge_method, expires_at`,
+		hash,
+	).Scan(&code.AppID, &code.UserID, &code.RedirectURI, &code.Scope, &code.Nonce,
+		&code.CodeChallenge, &code.CodeChallengeMethod, &code.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.AuthorizationCode{}, fmt.Errorf("%s: %w", op, storage.ErrTokenNotFound)
+		}
+
+		return models.AuthorizationCode{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return code, nil
+}
+
+func (s *Storage) SaveRefreshToken(ctx context.Context, token models.RefreshToken) error {
+	const op = "storage.postgres.SaveRefreshToken"
+
+	_, err := s.db(ctx).Exec(ctx,
+		`INSERT INTO refresh_tokens(token_hash, user_id, app_id, session_id, expires_at) VALUES ($1, $2, $3, NULLIF($4, 0), $5)`,
+		token.Hash, token.UserID, token.AppID, token.SessionID, token.ExpiresAt,
+	)
+	if err != nil {
+		var pgErr *pgconn.PgError
+
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// UseRefreshToken revokes the token and returns it, so that every refresh token
+// is exchanged at most once. Unknown, expired and revoked tokens give storage.ErrTokenNotFound.
+func (s *Storage) UseRefreshToken(ctx context.Context, hash []byte) (models.RefreshToken, error) {
+	const op = "storage.postgres.UseRefreshToken"
+
+	token := models.RefreshToken{Hash: hash}
+
+	err := s.db(ctx).QueryRow(ctx,
+		`UPDATE refresh_tokens SET revoked_at = now()
+			WHERE token_hash = $1 AND revoked_at IS NULL AND expires_at > now()
+			RETURNING user_id, app_id, COALESCE(session_id, 0), expires_at`,
+		hash,
+	).Scan(&token.UserID, &token.AppID, &token.SessionID, &token.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.RefreshToken{}, fmt.Errorf("%s: %w", op, storage.ErrTokenNotFound)
+		}
+
+		return models.RefreshToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return token, nil
+}
+
+// RevokeToken revokes a single access token until it expires.
+func (s *Storage) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	const op = "storage.postgres.RevokeToken"
+
+	_, err := s.db(ctx).Exec(ctx,
+		`INSERT INTO revoked_tokens(jti, expires_at) VALUES ($1, $2) ON CONFLICT (jti) DO NOTHING`,
+		jti, expiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// RevokeUserTokens revokes access tokens of the user issued up to before
+// and all of the user's refresh tokens and sessions.
+func (s *Storage) RevokeUserTokens(ctx context.Context, userID int64, before time.Time) error {
+	const op = "storage.postgres.RevokeUserTokens"
+
+	tx, err := s.db(ctx).Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO user_token_revocations(user_id, revoked_before) VALUES (`+resolveUserID+`, $2)
+			ON CONFLICT (user_id) DO UPDATE
+			SET revoked_before = GREATEST(user_token_revocations.revoked_before, EXCLUDED.revoked_before)`,
+		userID, before,
+	)
+	if err != nil {
+		var pgErr *pgconn.PgError
+
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = tx.Exec(ctx,
+		`UPDATE refresh_tokens SET revoked_at = now() WHERE user_id = `+resolveUserID+` AND revoked_at IS NULL`,
+		userID,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = tx.Exec(ctx,
+		`UPDATE sessions SET revoked_at = now() WHERE user_id = `+resolveUserID+` AND revoked_at IS NULL`,
+		userID,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// TokenRevoked reports whether the access token with the given id, issued
+// to the user at issuedAt, was revoked by RevokeToken, RevokeUserTokens or
+// with its session. Tokens of deleted users are revoked as well. userID is zero
+// for service tokens, which have no user and are only checked by id.
+func (s *Storage) TokenRevoked(ctx context.Context, userID int64, sessionID int64, jti string, issuedAt time.Time) (bool, error) {
+	const op = "storage.postgres.TokenRevoked"
+
+	var revoked bool
+
+	err := s.db(ctx).QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $2)
+			OR EXISTS (SELECT 1 FROM user_token_revocations
+				WHERE user_id = `+resolveUserID+` AND revoked_before >= $3)
+			OR ($1 <> 0 AND NOT EXISTS (SELECT 1 FROM users WHERE id = `+resolveUserID+`))
+			OR EXISTS (SELECT 1 FROM sessions WHERE id = $4 AND revoked_at IS NOT NULL)`,
+		userID, jti, issuedAt, sessionID,
+	).Scan(&revoked)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return revoked, nil
+}
+
+// sessionColumns are selected by every query returning models.Session, see scanSession.
+const sessionColumns = `id, user_id, app_id, ip, user_agent, device, region, created_at, last_seen_at, expires_at`
+
+func (s *Storage) SaveSession(ctx context.Context, session models.Session) (int64, error) {
+	const op = "storage.postgres.SaveSession"
+
+	var id int64
+
+	err := s.db(ctx).QueryRow(ctx,
+		`INSERT INTO sessions(user_id, app_id, ip, user_agent, device, region, expires_at) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`,
+		session.UserID, session.AppID, session.IP, session.UserAgent, session.Device, session.Region, session.ExpiresAt,
+	).Scan(&id)
+	if err != nil {
+		var pgErr *pgconn.PgError
+
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return id, nil
+}
+
+// TouchSession records activity of an active session, giving storage.ErrSessionNotFound otherwise.
+func (s *Storage) TouchSession(ctx context.Context, id int64, ip string, expiresAt time.Time) error {
+	const op = "storage.postgres.TouchSession"
+
+	res, err := s.db(ctx).Exec(ctx,
+		`UPDATE sessions SET last_seen_at = now(), ip = COALESCE(NULLIF($2, ''), ip), expires_at = GREATEST(expires_at, $3)
+			WHERE id = $1 AND revoked_at IS NULL AND expires_at > now()`,
+		id, ip, expiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrSessionNotFound)
+	}
+
+	return nil
+}
+
+// Sessions returns active sessions of the user, most recently seen first.
+func (s *Storage) Sessions(ctx context.Context, userID int64) ([]models.Session, error) {
+	const op = "storage.postgres.Sessions"
+
+	rows, err := s.db(ctx).Query(ctx,
+		`SELECT `+sessionColumns+` FROM sessions
+			WHERE user_id = `+resolveUserID+` AND revoked_at IS NULL AND expires_at > now()
+			ORDER BY last_seen_at DESC, id DESC`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var sessions []models.Session
+
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession revokes the active session of the user with its refresh tokens.
+func (s *Storage) RevokeSession(ctx context.Context, userID int64, id int64) error {
+	const op = "storage.postgres.RevokeSession"
+
+	tx, err := s.db(ctx).Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback(ctx)
+
+	res, err := tx.Exec(ctx,
+		`UPDATE sessions SET revoked_at = now()
+			WHERE id = $2 AND user_id = `+resolveUserID+` AND revoked_at IS NULL AND expires_at > now()`,
+		userID, id,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrSessionNotFound)
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE refresh_tokens SET revoked_at = now() WHERE session_id = $1 AND revoked_at IS NULL`, id)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func scanSession(row pgx.Row) (models.Session, error) {
+	var session models.Session
+
+	err := row.Scan(
+		&session.ID, &session.UserID, &session.AppID, &session.IP, &session.UserAgent, &session.Device, &session.Region,
+		&session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt,
+	)
+
+	return session, err
+}
+
+// SaveLoginAttempt records the attempt; zero UserID is stored as NULL.
+func (s *Storage) SaveLoginAttempt(ctx context.Context, attempt models.LoginAttempt) error {
+	const op = "storage.postgres.SaveLoginAttempt"
+
+	_, err := s.db(ctx).Exec(ctx,
+		`INSERT INTO login_attempts(user_id, app_id, login, method, result, ip, user_agent)
+			VALUES (NULLIF($1, 0), $2, $3, $4, $5, $6, $7)`,
+		attempt.UserID, attempt.AppID, attempt.Login, attempt.Method, attempt.Result, attempt.IP, attempt.UserAgent,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) LoginAttempts(ctx context.Context, userID int64, beforeID int64, limit int) ([]models.LoginAttempt, error) {
+	const op = "storage.postgres.LoginAttempts"
+
+	rows, err := s.db(ctx).Query(ctx,
+		`SELECT id, user_id, app_id, login, method, result, ip, user_agent, created_at FROM login_attempts
+			WHERE user_id = `+resolveUserID+` AND ($2 = 0 OR id < $2)
+			ORDER BY id DESC LIMIT $3`,
+		userID, beforeID, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var attempts []models.LoginAttempt
+
+	for rows.Next() {
+		var a models.LoginAttempt
+
+		err := rows.Scan(&a.ID, &a.UserID, &a.AppID, &a.Login, &a.Method, &a.Result, &a.IP, &a.UserAgent, &a.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		attempts = append(attempts, a)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return attempts, nil
+}
+
+// SaveAuditEvent appends the event; zero actor and target ids are stored as NULL.
+func (s *Storage) SaveAuditEvent(ctx context.Context, event models.AuditEvent) error {
+	const op = "storage.postgres.SaveAuditEvent"
+
+	details := event.Details
+	if details == nil {
+		details = map[string]string{}
+	}
+
+	_, err := s.db(ctx).Exec(ctx,
+		`INSERT INTO audit_log(action, actor_user_id, actor_app_id, actor_api_key_id, target_user_id, target_app_id, reason, details)
+			VALUES ($1, NULLIF($2, 0), NULLIF($3, 0), NULLIF($4, 0), NULLIF($5, 0), NULLIF($6, 0), $7, $8)`,
+		event.Action, event.ActorUserID, event.ActorAppID, event.ActorAPIKeyID,
+		event.TargetUserID, event.TargetAppID, event.Reason, details,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) AuditEvents(ctx context.Context, filter models.AuditFilter, beforeID int64, limit int) ([]models.AuditEvent, error) {
+	const op = "storage.postgres.AuditEvents"
+
+	var since, until *time.Time
+	if !filter.Since.IsZero() {
+		since = &filter.Since
+	}
+	if !filter.Until.IsZero() {
+		until = &filter.Until
+	}
+
+	rows, err := s.db(ctx).Query(ctx,
+		`SELECT id, action, COALESCE(actor_user_id, 0), COALESCE(actor_app_id, 0), COALESCE(actor_api_key_id, 0),
+				COALESCE(target_user_id, 0), COALESCE(target_app_id, 0), reason, details, created_at
+			FROM audit_log
+			WHERE ($1 = '' OR action = $1)
+				AND ($2 = 0 OR actor_user_id = $2)
+				AND ($3 = 0 OR target_user_id = $3)
+				AND ($4::timestamptz IS NULL OR created_at >= $4)
+				AND ($5::timestamptz IS NULL OR created_at < $5)
+				AND ($6 = 0 OR id < $6)
+				AND ($8 = 0 OR actor_app_id = $8 OR target_app_id = $8)
+			ORDER BY id DESC LIMIT $7`,
+		filter.Action, filter.ActorUserID, filter.TargetUserID, since, until, beforeID, limit, filter.AppID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var events []models.AuditEvent
+
+	for rows.Next() {
+		var e models.AuditEvent
+
+		err := rows.Scan(
+			&e.ID, &e.Action, &e.ActorUserID, &e.ActorAppID, &e.ActorAPIKeyID,
+			&e.TargetUserID, &e.TargetAppID, &e.Reason, &e.Details, &e.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		events = append(events, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return events, nil
+}
+
+// FailLogin counts a failed login of the user. The failure reaching non-zero
+// threshold locks the account until lockUntil and starts counting anew.
+func (s *Storage) FailLogin(ctx context.Context, userID int64, threshold int, lockUntil time.Time) (bool, error) {
+	const op = "storage.postgres.FailLogin"
+
+	var failures int
+
+	err := s.db(ctx).QueryRow(ctx,
+		`INSERT INTO login_failures(user_id, failures) VALUES ($1, 1)
+			ON CONFLICT (user_id) DO UPDATE SET failures = login_failures.failures + 1
+			RETURNING failures`,
+		userID,
+	).Scan(&failures)
+	if err != nil {
+		var pgErr *pgconn.PgError
+
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			return false, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if threshold <= 0 || failures < threshold {
+		return false, nil
+	}
+
+	_, err = s.db(ctx).Exec(ctx,
+		`UPDATE login_failures SET failures = 0, locked_until = $2 WHERE user_id = $1`,
+		userID, lockUntil,
+	)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return true, nil
+}
+
+// FailedLogins returns the number of failed logins since the last success or lock.
+func (s *Storage) FailedLogins(ctx context.Context, userID int64) (int, error) {
+	const op = "storage.postgres.FailedLogins"
+
+	var failures int
+
+	err := s.db(ctx).QueryRow(ctx, `SELECT failures FROM login_failures WHERE user_id = $1`, userID).Scan(&failures)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, nil
+		}
+
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return failures, nil
+}
+
+// ResetFailedLogins clears the counter and the lock of the user.
+func (s *Storage) ResetFailedLogins(ctx context.Context, userID int64) error {
+	const op = "storage.postgres.ResetFailedLogins"
+
+	if _, err := s.db(ctx).Exec(ctx, `DELETE FROM login_failures WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// LockedUntil returns zero time if the user was never locked.
+func (s *Storage) LockedUntil(ctx context.Context, userID int64) (time.Time, error) {
+	const op = "storage.postgres.LockedUntil"
+
+	var until *time.Time
+
+	err := s.db(ctx).QueryRow(ctx, `SELECT locked_until FROM login_failures WHERE user_id = $1`, userID).Scan(&until)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return time.Time{}, nil
+		}
+
+		return time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if until == nil {
+		return time.Time{}, nil
+	}
+
+	return *until, nil
+}
+
+// FailUnknownLogin counts a failed login of no user by the hash of the login,
+// locking it like FailLogin.
+func (s *Storage) FailUnknownLogin(ctx context.Context, loginHash []byte, threshold int, lockUntil time.Time) (bool, error) {
+	const op = "storage.postgres.FailUnknownLogin"
+
+	var failures int
+
+	err := s.db(ctx).QueryRow(ctx,
+		`INSERT INTO unknown_login_failures(login_hash, failures) VALUES ($1, 1)
+			ON CONFLICT (login_hash) DO UPDATE SET failures = unknown_login_failures.failures + 1
+			RETURNING failures`,
+		loginHash,
+	).Scan(&failures)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if threshold <= 0 || failures < threshold {
+		return false, nil
+	}
+
+	_, err = s.db(ctx).Exec(ctx,
+		`UPDATE unknown_login_failures SET failures = 0, locked_until = $2 WHERE login_hash = $1`,
+		loginHash, lockUntil,
+	)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return true, nil
+}
+
+// UnknownLoginFailures returns the counter and the lock of the login of no user.
+func (s *Storage) UnknownLoginFailures(ctx context.Context, loginHash []byte) (int, time.Time, error) {
+	const op = "storage.postgres.UnknownLoginFailures"
+
+	var (
+		failures int
+		until    *time.Time
+	)
+
+	err := s.db(ctx).QueryRow(ctx,
+		`SELECT failures, locked_until FROM unknown_login_failures WHERE login_hash = $1`,
+		loginHash,
+	).Scan(&failures, &until)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, time.Time{}, nil
+		}
+
+		return 0, time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if until == nil {
+		return failures, time.Time{}, nil
+	}
+
+	return failures, *until, nil
+}
+
+// SaveGroup creates the group and returns its id.
+func (s *Storage) SaveGroup(ctx context.Context, group models.Group) (int64, error) {
+	const op = "storage.postgres.SaveGroup"
+
+	var id int64
+
+	err := s.db(ctx).QueryRow(ctx,
+		`INSERT INTO groups(name, description) VALUES ($1, $2) RETURNING id`,
+		group.Name, group.Description,
+	).Scan(&id)
+	if err != nil {
+		var pgErr *pgconn.PgError
+
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrGroupExists)
+		}
+
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return id, nil
+}
+
+// Group returns the group by id.
+func (s *Storage) Group(ctx context.Context, id int64) (models.Group, error) {
+	const op = "storage.postgres.Group"
+
+	var group models.Group
+
+	err := s.db(ctx).QueryRow(ctx,
+		`SELECT id, name, description, created_at FROM groups WHERE id = $1`, id,
+	).Scan(&group.ID, &group.Name, &group.Description, &group.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.Group{}, fmt.Errorf("%s: %w", op, storage.ErrGroupNotFound)
+		}
+
+		return models.Group{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return group, nil
+}
+
+// AddGroupMember adds the user to the group; adding a member again does nothing.
+func (s *Storage) AddGroupMember(ctx context.Context, groupID int64, userID int64) error {
+	const op = "storage.postgres.AddGroupMember"
+
+	_, err := s.db(ctx).Exec(ctx,
+		`INSERT INTO group_members(group_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
+		groupID, userID,
+	)
+	if err != nil {
+		var pgErr *pgconn.PgError
+
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			if pgErr.ConstraintName == "group_members_group_id_fkey" {
+				return fmt.Errorf("%s: %w", op, storage.ErrGroupNotFound)
+			}
+
+			return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// RemoveGroupMember removes the user from the group; removing a non-member does nothing.
+func (s *Storage) RemoveGroupMember(ctx context.Context, groupID int64, userID int64) error {
+	const op = "storage.postgres.RemoveGroupMember"
+
+	_, err := s.db(ctx).Exec(ctx,
+		`DELETE FROM group_members WHERE group_id = $1 AND user_id = $2`, groupID, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// GroupMembers returns up to limit members of the group with user id below
+// beforeUserID (any if zero), newest users first.
+func (s *Storage) GroupMembers(ctx context.Context, groupID int64, beforeUserID int64, limit int) ([]models.GroupMember, error) {
+	const op = "storage.postgres.GroupMembers"
+
+	rows, err := s.db(ctx).Query(ctx,
+		`SELECT m.user_id, u.email, m.added_at
+			FROM group_members m JOIN users u ON u.id = m.user_id
+			WHERE m.group_id = $1 AND ($2 = 0 OR m.user_id < $2)
+			ORDER BY m.user_id DESC
+			LIMIT $3`,
+		groupID, beforeUserID, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var members []models.GroupMember
+	for rows.Next() {
+		var m models.GroupMember
+		if err := rows.Scan(&m.UserID, &m.Email, &m.AddedAt); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		members = append(members, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return members, nil
+}
+
+// UserGroups returns names of the groups the user is in, sorted.
+func (s *Storage) UserGroups(ctx context.Context, userID int64) ([]string, error) {
+	const op = "storage.postgres.UserGroups"
+
+	rows, err := s.db(ctx).Query(ctx,
+		`SELECT g.name FROM group_members m JOIN groups g ON g.id = m.group_id
+			WHERE m.user_id = $1 ORDER BY g.name`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer rows.Close()
+
+	var groups []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		groups = append(groups, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return groups, nil
+}
+
+// SaveOrganization creates the organization and returns its id.
+func (s *Storage) SaveOrganization(ctx context.Context, org models.Organization) (int64, error) {
+	const op = "storage.postgres.SaveOrganization"
+
+	var id int64
+
+	err := s.db(ctx).QueryRow(ctx, `INSERT INTO organizations(name) VALUES ($1) RETURNING id`, org.Name).Scan(&id)
+	if err != nil {
+		var pgErr *pgconn.PgError
+
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrOrgExists)
+		}
+
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return id, nil
+}
+
+// Organization returns the organization by id.
+func (s *Storage) Organization(ctx context.Context, id int64) (models.Organization, error) {
+	const op = "storage.postgres.Organization"
+
+	var org models.Organization
+
+	err := s.db(ctx).QueryRow(ctx,
+		`SELECT id, name, created_at FROM organizations WHERE id = $1`, id,
+	).Scan(&org.ID, &org.Name, &org.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.Organization{}, fmt.Errorf("%s: %w", op, storage.ErrOrgNotFound)
+		}
+
+		return models.Organization{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return org, nil
+}
+
+// Organizations returns all organizations ordered by name.
+func (s *Storage) Organizations(ctx context.Context) ([]models.Organization, error) {
+	const op = "storage.postgres.Organizations"
+
+	rows, err := s.db(ctx).Query(ctx, `SELECT id, name, created_at FROM organizations ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var orgs []models.Organization
+	for rows.Next() {
+		var org models.Organization
+		if err := rows.Scan(&org.ID, &org.Name, &org.CreatedAt); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		orgs = append(orgs, org)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return orgs, nil
+}
+
+// SetUserOrg moves the user to the organization; zero orgID moves it back to the platform.
+func (s *Storage) SetUserOrg(ctx context.Context, userID int64, orgID int64) error {
+	const op = "storage.postgres.SetUserOrg"
+
+	res, err := s.db(ctx).Exec(ctx,
+		`UPDATE users SET org_id = NULLIF($1, 0) WHERE id = $2`, orgID, userID,
+	)
+	if err != nil {
+		var pgErr *pgconn.PgError
+
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			return fmt.Errorf("%s: %w", op, storage.ErrOrgNotFound)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+// apiKeyColumns are selected by every query returning models.APIKey, see scanAPIKey.
+const apiKeyColumns = `id, app_id, name, role, prefix, created_at, last_used_at, revoked_at,
+	COALESCE((SELECT org_id FROM apps WHERE apps.id = api_keys.app_id), 0)`
+
+func (s *Storage) SaveAPIKey(ctx context.Context, key models.APIKey, hash []byte) (int64, error) {
+	const op = "storage.postgres.SaveAPIKey"
+
+	var id int64
+
+	err := s.db(ctx).QueryRow(ctx,
+		`INSERT INTO api_keys(app_id, name, role, key_hash, prefix) VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+		key.AppID, key.Name, key.Role, hash, key.Prefix,
+	).Scan(&id)
+	if err != nil {
+		var pgErr *pgconn.PgError
+
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+		}
+
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return id, nil
+}
+
+// UseAPIKey returns the key that isn't revoked and records its use.
+func (s *Storage) UseAPIKey(ctx context.Context, hash []byte) (models.APIKey, error) {
+	const op = "storage.postgres.UseAPIKey"
+
+	key, err := scanAPIKey(s.db(ctx).QueryRow(ctx,
+		`UPDATE api_keys SET last_used_at = now() WHERE key_hash = $1 AND revoked_at IS NULL
+			RETURNING `+apiKeyColumns,
+		hash,
+	))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.APIKey{}, fmt.Errorf("%s: %w", op, storage.ErrAPIKeyNotFound)
+		}
+
+		return models.APIKey{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return key, nil
+}
+
+func (s *Storage) APIKey(ctx context.Context, id int64) (models.APIKey, error) {
+	const op = "storage.postgres.APIKey"
+
+	key, err := scanAPIKey(s.db(ctx).QueryRow(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE id = $1`, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.APIKey{}, fmt.Errorf("%s: %w", op, storage.ErrAPIKeyNotFound)
+		}
+
+		return models.APIKey{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return key, nil
+}
+
+// APIKeys returns keys of the app, including revoked ones, newest first.
+func (s *Storage) APIKeys(ctx context.Context, appID int) ([]models.APIKey, error) {
+	const op = "storage.postgres.APIKeys"
+
+	rows, err := s.db(ctx).Query(ctx,
+		`SELECT `+apiKeyColumns+` FROM api_keys WHERE app_id = $1 ORDER BY id DESC`, appID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var keys []models.APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return keys, nil
+}
+
+func (s *Storage) RevokeAPIKey(ctx context.Context, id int64) error {
+	const op = "storage.postgres.RevokeAPIKey"
+
+	res, err := s.db(ctx).Exec(ctx,
+		`UPDATE api_keys SET revoked_at = now() WHERE id = $1 AND revoked_at IS NULL`, id,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrAPIKeyNotFound)
+	}
+
+	return nil
+}
+
+func scanAPIKey(row pgx.Row) (models.APIKey, error) {
+	var (
+		key                   models.APIKey
+		lastUsedAt, revokedAt *time.Time
+	)
+
+	err := row.Scan(&key.ID, &key.AppID, &key.Name, &key.Role, &key.Prefix, &key.CreatedAt, &lastUsedAt, &revokedAt, &key.OrgID)
+	if err != nil {
+		return models.APIKey{}, err
+	}
+
+	if lastUsedAt != nil {
+		key.LastUsedAt = *lastUsedAt
+	}
+	if revokedAt != nil {
+		key.RevokedAt = *revokedAt
+	}
+
+	return key, nil
+}
+
+// webhookColumns are selected by every query returning models.Webhook, see scanWebhook.
+const webhookColumns = `id, app_id, url, events, secret, created_at`
+
+func (s *Storage) SaveWebhook(ctx context.Context, hook models.Webhook) (int64, error) {
+	const op = "storage.postgres.SaveWebhook"
+
+	var id int64
+
+	err := s.db(ctx).QueryRow(ctx,
+		`INSERT INTO webhooks(app_id, url, events, secret) VALUES ($1, $2, $3, $4) RETURNING id`,
+		hook.AppID, hook.URL, hook.Events, hook.Secret,
+	).Scan(&id)
+	if err != nil {
+		var pgErr *pgconn.PgError
+
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+		}
+
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return id, nil
+}
+
+func (s *Storage) Webhook(ctx context.Context, id int64) (models.Webhook, error) {
+	const op = "storage.postgres.Webhook"
+
+	hook, err := scanWebhook(s.db(ctx).QueryRow(ctx, `SELECT `+webhookColumns+` FROM webhooks WHERE id = $1`, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.Webhook{}, fmt.Errorf("%s: %w", op, storage.ErrWebhookNotFound)
+		}
+
+		return models.Webhook{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return hook, nil
+}
+
+// Webhooks returns webhooks of the app, newest first.
+func (s *Storage) Webhooks(ctx context.Context, appID int) ([]models.Webhook, error) {
+	const op = "storage.postgres.Webhooks"
+
+	rows, err := s.db(ctx).Query(ctx,
+		`SELECT `+webhookColumns+` FROM webhooks WHERE app_id = $1 ORDER BY id DESC`, appID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var hooks []models.Webhook
+	for rows.Next() {
+		hook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		hooks = append(hooks, hook)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return hooks, nil
+}
+
+// DeleteWebhook removes the webhook with its deliveries, pending ones included.
+func (s *Storage) DeleteWebhook(ctx context.Context, id int64) error {
+	const op = "storage.postgres.DeleteWebhook"
+
+	res, err := s.db(ctx).Exec(ctx, `DELETE FROM webhooks WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrWebhookNotFound)
+	}
+
+	return nil
+}
+
+// enqueueEvent writes the event to the outbox in the transaction of the change
+// it describes, so that the event is sent if and only if the change is committed.
+func enqueueEvent(ctx context.Context, tx pgx.Tx, eventType string, data models.EventUser) error {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+
+	event := models.Event{ID: hex.EncodeToString(b), Type: eventType, CreatedAt: time.Now().UTC(), Data: data}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO outbox(event_id, event, payload, app_id) VALUES ($1, $2, $3, NULLIF($4, 0))`,
+		event.ID, event.Type, payload, data.AppID,
+	)
+
+	return err
+}
+
+// EnqueueEvent writes an event that isn't part of a change of the storage,
+// such as a failed login, to the outbox.
+func (s *Storage) EnqueueEvent(ctx context.Context, eventType string, data models.EventUser) error {
+	const op = "storage.postgres.EnqueueEvent"
+
+	tx, err := s.db(ctx).Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := enqueueEvent(ctx, tx, eventType, data); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ClaimOutboxEvents returns up to limit unsent events, oldest first, and
+// postpones them by lease, so that other instances don't send them meanwhile.
+func (s *Storage) ClaimOutboxEvents(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxEvent, error) {
+	const op = "storage.postgres.ClaimOutboxEvents"
+
+	rows, err := s.db(ctx).Query(ctx,
+		`UPDATE outbox SET next_attempt_at = $2
+			WHERE id IN (
+				SELECT id FROM outbox WHERE next_attempt_at <= now()
+				ORDER BY id LIMIT $1
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING id, event_id, event, payload, COALESCE(app_id, 0), created_at`,
+		limit, time.Now().Add(lease),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var events []models.OutboxEvent
+	for rows.Next() {
+		var e models.OutboxEvent
+
+		if err := rows.Scan(&e.ID, &e.EventID, &e.Type, &e.Payload, &e.AppID, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// UPDATE ... RETURNING не сохраняет порядок подзапроса
+	slices.SortFunc(events, func(a, b models.OutboxEvent) int { return cmp.Compare(a.ID, b.ID) })
+
+	return events, nil
+}
+
+// CompleteOutboxEvent queues deliveries of the sent event to every webhook
+// subscribed to it, of its app if it has one, and removes the event from the outbox.
+func (s *Storage) CompleteOutboxEvent(ctx context.Context, id int64) error {
+	const op = "storage.postgres.CompleteOutboxEvent"
+
+	tx, err := s.db(ctx).Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx,
+		`INSERT INTO webhook_deliveries(webhook_id, event, payload)
+			SELECT w.id, o.event, o.payload FROM outbox o JOIN webhooks w ON o.event = ANY(w.events)
+			WHERE o.id = $1 AND (o.app_id IS NULL OR o.app_id = w.app_id)`,
+		id,
+	); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM outbox WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// webhookDeliveryColumns are selected by every query returning models.WebhookDelivery,
+// see collectWebhookDeliveries.
+const webhookDeliveryColumns = `id, webhook_id, event, payload, attempts, next_attempt_at,
+	last_status, last_error, created_at, delivered_at, failed_at`
+
+// WebhookDeliveries returns deliveries of the webhook, newest first, with ids below
+// beforeID unless it is zero.
+func (s *Storage) WebhookDeliveries(ctx context.Context, webhookID int64, beforeID int64, limit int) ([]models.WebhookDelivery, error) {
+	const op = "storage.postgres.WebhookDeliveries"
+
+	rows, err := s.db(ctx).Query(ctx,
+		`SELECT `+webhookDeliveryColumns+` FROM webhook_deliveries
+			WHERE webhook_id = $1 AND ($2 = 0 OR id < $2)
+			ORDER BY id DESC LIMIT $3`,
+		webhookID, beforeID, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	deliveries, err := collectWebhookDeliveries(rows)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return deliveries, nil
+}
+
+// ClaimWebhookDeliveries returns up to limit pending deliveries that are due and
+// postpones them by lease, so that other instances don't send them meanwhile.
+func (s *Storage) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]models.WebhookDelivery, error) {
+	const op = "storage.postgres.ClaimWebhookDeliveries"
+
+	rows, err := s.db(ctx).Query(ctx,
+		`UPDATE webhook_deliveries SET next_attempt_at = $2
+			WHERE id IN (
+				SELECT id FROM webhook_deliveries
+				WHERE delivered_at IS NULL AND failed_at IS NULL AND next_attempt_at <= now()
+				ORDER BY next_attempt_at LIMIT $1
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING `+webhookDeliveryColumns,
+		limit, time.Now().Add(lease),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	deliveries, err := collectWebhookDeliveries(rows)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return deliveries, nil
+}
+
+// UpdateWebhookDelivery records the outcome of an attempt.
+func (s *Storage) UpdateWebhookDelivery(ctx context.Context, d models.WebhookDelivery) error {
+	const op = "storage.postgres.UpdateWebhookDelivery"
+
+	var deliveredAt, failedAt *time.Time
+	if !d.DeliveredAt.IsZero() {
+		deliveredAt = &d.DeliveredAt
+	}
+	if !d.FailedAt.IsZero() {
+		failedAt = &d.FailedAt
+	}
+
+	_, err := s.db(ctx).Exec(ctx,
+		`UPDATE webhook_deliveries SET attempts = $2, next_attempt_at = $3, last_status = $4, last_error = $5,
+			delivered_at = $6, failed_at = $7
+			WHERE id = $1`,
+		d.ID, d.Attempts, d.NextAttemptAt, d.LastStatus, d.LastError,
+		deliveredAt, failedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func scanWebhook(row pgx.Row) (models.Webhook, error) {
+	var hook models.Webhook
+
+	err := row.Scan(&hook.ID, &hook.AppID, &hook.URL, &hook.Events, &hook.Secret, &hook.CreatedAt)
+	if err != nil {
+		return models.Webhook{}, err
+	}
+
+	return hook, nil
+}
+
+func collectWebhookDeliveries(rows pgx.Rows) ([]models.WebhookDelivery, error) {
+	defer rows.Close()
+
+	var deliveries []models.WebhookDelivery
+	for rows.Next() {
+		var (
+			d                     models.WebhookDelivery
+			deliveredAt, failedAt *time.Time
+		)
+
+		err := rows.Scan(&d.ID, &d.WebhookID, &d.Event, &d.Payload, &d.Attempts, &d.NextAttemptAt,
+			&d.LastStatus, &d.LastError, &d.CreatedAt, &deliveredAt, &failedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		if deliveredAt != nil {
+			d.DeliveredAt = *deliveredAt
+		}
+		if failedAt != nil {
+			d.FailedAt = *failedAt
+		}
+
+		deliveries = append(deliveries, d)
+	}
+
+	return deliveries, rows.Err()
+}
+
+func scanApp(row pgx.Row) (models.App, error) {
+	var (
+		app        models.App
+		expiresAt  *time.Time
+		ttlSeconds int
+	)
+
+	err := row.Scan(
+		&app.ID, &app.Name, &app.Secret, &app.RedirectURIs, &app.Public, &app.Scopes, &app.OrgID, &app.CreatedAt,
+		&app.PreviousSecret, &expiresAt, &ttlSeconds, &app.Audience, &app.CertSubject,
+	)
+	if expiresAt != nil {
+		app.PreviousSecretExpiresAt = *expiresAt
+	}
+	app.TokenTTL = time.Duration(ttlSeconds) * time.Second
+
+	return app, err
+}
+
+func scanUser(row pgx.Row) (models.User, error) {
+	var (
+		user        models.User
+		lastLoginAt *time.Time
+		deletedAt   *time.Time
+	)
+
+	err := row.Scan(
+		&user.ID, &user.Email, &user.Username, &user.Phone, &user.PhoneVerified,
+		&user.AvatarURL, &user.PassHash, &user.Role, &user.CreatedAt, &lastLoginAt, &user.OrgID, &deletedAt, &user.Status,
+	)
+	if lastLoginAt != nil {
+		user.LastLoginAt = *lastLoginAt
+	}
+	if deletedAt != nil {
+		user.DeletedAt = *deletedAt
+	}
+
+	return user, err
+}
+
+// userWhere builds WHERE clause for the filter, numbering placeholders after
+// the given number of already used arguments.
+func userWhere(filter models.UserFilter, used int) (string, []any) {
+	var (
+		conds []string
+		args  []any
+	)
+
+	if filter.Role != "" {
+		args = append(args, filter.Role)
+		conds = append(conds, fmt.Sprintf("role = $%d", used+len(args)))
+	}
+
+	if filter.EmailPrefix != "" {
+		args = append(args, likePrefix(filter.EmailPrefix))
+		conds = append(conds, fmt.Sprintf("email LIKE $%d", used+len(args)))
+	}
+
+	if filter.OrgID != 0 {
+		args = append(args, filter.OrgID)
+		conds = append(conds, fmt.Sprintf("org_id = $%d", used+len(args)))
+	}
+
+	if !filter.Deleted {
+		conds = append(conds, "deleted_at IS NULL")
+	}
+
+	if len(conds) == 0 {
+		return "", nil
+	}
+
+	return ` WHERE ` + strings.Join(conds, " AND "), args
+}
+
+// likePrefix escapes LIKE wildcards in s and turns it into a prefix pattern.
+func likePrefix(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+	return r.Replace(s) + "%"
+}
+
+// userSortColumns maps models.UserSort fields to ORDER BY expressions.
+// Only these are ever interpolated into queries.
+var userSortColumns = map[string]string{
+	"":                         "id",
+	models.UserSortID:          "id",
+	models.UserSortEmail:       "email",
+	models.UserSortRole:        "role",
+	models.UserSortCreatedAt:   "created_at",
+	models.UserSortLastLoginAt: "last_login_at",
+}
+
+// userSortTypes are SQL types of the sort columns, to cast cursor values to.
+var userSortTypes = map[string]string{
+	"email":         "text",
+	"role":          "text",
+	"created_at":    "timestamptz",
+	"last_login_at": "timestamptz",
+}
+
+// userKeyset builds the condition selecting users after the cursor in the
+// order of orderBy, where NULLs come last, numbering placeholders after used.
+func userKeyset(sort models.UserSort, c models.UserCursor, used int) (string, []any) {
+	column := userSortColumns[sort.Field]
+
+	cmp := ">"
+	if sort.Desc {
+		cmp = "<"
+	}
+
+	if column == "id" {
+		return fmt.Sprintf("id %s $%d", cmp, used+1), []any{c.ID}
+	}
+
+	if c.Null {
+		return fmt.Sprintf("(%s IS NULL AND id %s $%d)", column, cmp, used+1), []any{c.ID}
+	}
+
+	value := fmt.Sprintf("$%d::text::%s", used+1, userSortTypes[column])
+
+	return fmt.Sprintf("(%[1]s %[2]s %[3]s OR (%[1]s = %[3]s AND id %[2]s $%[4]d) OR %[1]s IS NULL)",
+		column, cmp, value, used+2,
+	), []any{c.Value, c.ID}
+}
+
+func orderBy(sort models.UserSort) (string, error) {
+	column, ok := userSortColumns[sort.Field]
+	if !ok {
+		return "", storage.ErrInvalidSort
+	}
+
+	dir := "ASC"
+	if sort.Desc {
+		dir = "DESC"
+	}
+
+	if column == "id" {
+		return ` ORDER BY id ` + dir, nil
+	}
 
+	return ` ORDER BY ` + column + ` ` + dir + ` NULLS LAST, id ` + dir, nil
 }
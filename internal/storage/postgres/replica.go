@@ -0,0 +1,100 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"sso/internal/lib/breaker"
+	"sso/internal/storage"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// querier runs statements outside of transactions.
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// WithReplica sends reads that tolerate replication lag to the replica at dsn.
+// They go to the primary while the replica is unavailable; b, if not nil,
+// makes them skip the replica at once while it's failing.
+func WithReplica(dsn string, b *breaker.Breaker) Option {
+	return func(cfg *config) {
+		cfg.replicaDSN = dsn
+		cfg.replicaBreaker = b
+	}
+}
+
+// newReplica opens the pool of the replica with the settings of the primary.
+func newReplica(cfg *config) (*pool, error) {
+	replicaCfg, err := pgxpool.ParseConfig(cfg.replicaDSN)
+	if err != nil {
+		return nil, err
+	}
+
+	replicaCfg.MinConns = cfg.MinConns
+	replicaCfg.MaxConns = cfg.MaxConns
+	replicaCfg.MaxConnLifetime = cfg.MaxConnLifetime
+	replicaCfg.HealthCheckPeriod = cfg.HealthCheckPeriod
+	replicaCfg.PrepareConn = cfg.PrepareConn
+	replicaCfg.ConnConfig.Tracer = cfg.ConnConfig.Tracer
+
+	p, err := pgxpool.NewWithConfig(context.Background(), replicaCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pool{Pool: p, acquireTimeout: cfg.acquireTimeout, read: cfg.read, write: cfg.write, breaker: cfg.replicaBreaker}, nil
+}
+
+// reader returns the replica if there is one, else the primary. Inside InTx
+// it returns the transaction, which must see its own writes, and with
+// storage.WithPrimary the primary.
+func (s *Storage) reader(ctx context.Context) querier {
+	if _, ok := ctx.Value(txKey{}).(pgx.Tx); ok || s.replica == nil || storage.Primary(ctx) {
+		return s.db(ctx)
+	}
+
+	return replicaPool{replica: s.replica, primary: s.pool}
+}
+
+// replicaPool runs queries on the replica and again on the primary if the
+// replica is unavailable.
+type replicaPool struct {
+	replica *pool
+	primary *pool
+}
+
+func (r replicaPool) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	rows, err := r.replica.Query(ctx, sql, args...)
+	if replicaDown(err) {
+		return r.primary.Query(ctx, sql, args...)
+	}
+
+	return rows, err
+}
+
+func (r replicaPool) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return replicaRow{r: r, ctx: ctx, sql: sql, args: args}
+}
+
+type replicaRow struct {
+	r    replicaPool
+	ctx  context.Context
+	sql  string
+	args []any
+}
+
+func (row replicaRow) Scan(dest ...any) error {
+	err := row.r.replica.QueryRow(row.ctx, row.sql, row.args...).Scan(dest...)
+	if replicaDown(err) {
+		return row.r.primary.QueryRow(row.ctx, row.sql, row.args...).Scan(dest...)
+	}
+
+	return err
+}
+
+func replicaDown(err error) bool {
+	return errors.Is(err, storage.ErrUnavailable) || unavailable(err)
+}
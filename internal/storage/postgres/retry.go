@@ -0,0 +1,93 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// RetryPolicy retries transient failures with exponential backoff and full
+// jitter: the n-th retry waits a random time up to BaseDelay * 2^(n-1),
+// capped by MaxDelay.
+type RetryPolicy struct {
+	// Attempts is the number of tries including the first; zero or one disables retries.
+	Attempts  int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// WithRetry retries statements run outside of transactions and Begin:
+// reads by the read policy, writes by the write policy.
+func WithRetry(read RetryPolicy, write RetryPolicy) Option {
+	return func(cfg *config) {
+		cfg.read = read
+		cfg.write = write
+	}
+}
+
+// do calls fn until it succeeds, fails for good or the attempts run out.
+// Lost connections are retried for reads only, as a write might have been
+// applied before the connection broke.
+func (p RetryPolicy) do(ctx context.Context, read bool, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= p.Attempts || !retryable(err, read) {
+			return err
+		}
+
+		delay := min(p.BaseDelay<<(attempt-1), p.MaxDelay)
+		if delay > 0 {
+			delay = rand.N(delay)
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
+
+func retryable(err error, read bool) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	// Запрос не ушёл на сервер, повтор ничего не задвоит
+	if pgconn.SafeToRetry(err) {
+		return true
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		// serialization_failure и deadlock_detected откатывают транзакцию целиком
+		case "40001", "40P01":
+			return true
+		// cannot_connect_now: сервер запускается или переключается после failover
+		case "57P03":
+			return true
+		// admin_shutdown, crash_shutdown и connection_exception: соединение потеряно
+		case "57P01", "57P02", "08000", "08003", "08006":
+			return read
+		}
+
+		return false
+	}
+
+	if !read {
+		return false
+	}
+
+	var netErr net.Error
+
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.As(err, &netErr)
+}
@@ -0,0 +1,77 @@
+package postgres
+
+import (
+	"context"
+	"log/slog"
+	"runtime"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/requestid"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// slowQueryTracer logs queries taking threshold or longer, without their
+// arguments, which may be secrets.
+type slowQueryTracer struct {
+	log       *slog.Logger
+	threshold time.Duration
+}
+
+type queryStartKey struct{}
+
+type queryStart struct {
+	sql string
+	at  time.Time
+}
+
+func (t *slowQueryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return context.WithValue(ctx, queryStartKey{}, queryStart{sql: data.SQL, at: time.Now()})
+}
+
+func (t *slowQueryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	start, ok := ctx.Value(queryStartKey{}).(queryStart)
+	if !ok {
+		return
+	}
+
+	took := time.Since(start.at)
+	if took < t.threshold {
+		return
+	}
+
+	attrs := []any{
+		slog.String("op", operation()),
+		slog.Duration("duration", took),
+		slog.String("sql", strings.Join(strings.Fields(start.sql), " ")),
+	}
+	if data.Err != nil {
+		attrs = append(attrs, sl.Err(data.Err))
+	} else {
+		attrs = append(attrs, slog.Int64("rows", data.CommandTag.RowsAffected()))
+	}
+
+	requestid.Logger(ctx, t.log).Warn("slow query", attrs...)
+}
+
+// operation names the Storage method running the query, e.g. storage.postgres.UserByID.
+// The tracer is called from within the method, so it is found up the stack.
+func operation() string {
+	const prefix = "sso/internal/storage/postgres.(*Storage)."
+
+	pcs := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	for {
+		frame, more := frames.Next()
+		if name, ok := strings.CutPrefix(frame.Function, prefix); ok {
+			// Замыкания внутри метода называются Method.func1
+			name, _, _ = strings.Cut(name, ".")
+
+			return "storage.postgres." + name
+		}
+		if !more {
+			return "unknown"
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+type txKey struct{}
+
+// db runs statements of a call: on the transaction of InTx carried by ctx,
+// otherwise on the pool. Transactions begun on it inside InTx are savepoints.
+type db interface {
+	querier
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+func (s *Storage) db(ctx context.Context) db {
+	if tx, ok := ctx.Value(txKey{}).(pgx.Tx); ok {
+		return tx
+	}
+
+	return s.pool
+}
+
+// InTx runs fn in a transaction: the calls made with the ctx passed to fn
+// commit together if fn returns nil and roll back otherwise. InTx called
+// inside fn joins the transaction.
+func (s *Storage) InTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	const op = "storage.postgres.InTx"
+
+	if _, ok := ctx.Value(txKey{}).(pgx.Tx); ok {
+		return fn(ctx)
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := fn(context.WithValue(ctx, txKey{}, tx)); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
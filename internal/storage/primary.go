@@ -0,0 +1,19 @@
+package storage
+
+import "context"
+
+type primaryKey struct{}
+
+// WithPrimary makes reads with ctx go to the primary even if they tolerate
+// replication lag otherwise, e.g. when the result is cached for longer than
+// the lag and must not be stale.
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// Primary reports whether reads with ctx must go to the primary, see WithPrimary.
+func Primary(ctx context.Context) bool {
+	primary, _ := ctx.Value(primaryKey{}).(bool)
+
+	return primary
+}
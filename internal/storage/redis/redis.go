@@ -0,0 +1,299 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sso/internal/domain/models"
+	"sso/internal/storage"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type Storage struct {
+	client *redis.Client
+}
+
+func New(addr string, password string, db int) (*Storage, error) {
+	const op = "storage.redis.New"
+
+	client := redis.NewClient(&redis.Options{
+		Addr:     addr,
+		Password: password,
+		DB:       db,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := client.Ping(ctx).Err(); err != nil {
+		return nil, fmt.Errorf("%s: cannot connect to redis: %w", op, err)
+	}
+
+	return &Storage{client: client}, nil
+}
+
+func (s *Storage) Close() error {
+	return s.client.Close()
+}
+
+// Ping checks that Redis is reachable.
+func (s *Storage) Ping(ctx context.Context) error {
+	return s.client.Ping(ctx).Err()
+}
+
+// Incr increments the counter of the fixed window containing the current time
+// and returns its value and the end of the window.
+func (s *Storage) Incr(ctx context.Context, key string, window time.Duration) (int64, time.Time, error) {
+	const op = "storage.redis.Incr"
+
+	start := time.Now().Truncate(window)
+	resetAt := start.Add(window)
+	windowKey := fmt.Sprintf("%s:%d", key, start.Unix())
+
+	var incr *redis.IntCmd
+
+	_, err := s.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		incr = p.Incr(ctx, windowKey)
+		p.ExpireAt(ctx, windowKey, resetAt.Add(time.Second))
+
+		return nil
+	})
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return incr.Val(), resetAt, nil
+}
+
+// failedLoginsTTL forgets failed logins of a user without new attempts,
+// Postgres keeps them until the next successful login instead.
+const failedLoginsTTL = 24 * time.Hour
+
+// failLoginScript counts the failure and, on reaching non-zero threshold, locks
+// the user and resets the counter, atomically for all replicas.
+// KEYS: failures, locked until. ARGV: threshold, lock until (unix ms), counter ttl (s).
+var failLoginScript = redis.NewScript(`
+local failures = redis.call("INCR", KEYS[1])
+redis.call("EXPIRE", KEYS[1], ARGV[3])
+local threshold = tonumber(ARGV[1])
+if threshold <= 0 or failures < threshold then
+	return 0
+end
+redis.call("DEL", KEYS[1])
+redis.call("SET", KEYS[2], ARGV[2], "PXAT", ARGV[2])
+return 1
+`)
+
+func failuresKey(userID int64) string {
+	return fmt.Sprintf("lockout:%d:failures", userID)
+}
+
+func lockedUntilKey(userID int64) string {
+	return fmt.Sprintf("lockout:%d:until", userID)
+}
+
+// FailLogin counts a failed login of the user. The failure reaching non-zero
+// threshold locks the account until lockUntil and starts counting anew.
+func (s *Storage) FailLogin(ctx context.Context, userID int64, threshold int, lockUntil time.Time) (bool, error) {
+	const op = "storage.redis.FailLogin"
+
+	locked, err := failLoginScript.Run(ctx, s.client,
+		[]string{failuresKey(userID), lockedUntilKey(userID)},
+		threshold, lockUntil.UnixMilli(), int(failedLoginsTTL.Seconds()),
+	).Int()
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return locked == 1, nil
+}
+
+// FailedLogins returns the number of failed logins since the last success or lock.
+func (s *Storage) FailedLogins(ctx context.Context, userID int64) (int, error) {
+	const op = "storage.redis.FailedLogins"
+
+	failures, err := s.client.Get(ctx, failuresKey(userID)).Int()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return 0, nil
+		}
+
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return failures, nil
+}
+
+// ResetFailedLogins clears the counter and the lock of the user.
+func (s *Storage) ResetFailedLogins(ctx context.Context, userID int64) error {
+	const op = "storage.redis.ResetFailedLogins"
+
+	if err := s.client.Del(ctx, failuresKey(userID), lockedUntilKey(userID)).Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// LockedUntil returns zero time if the user isn't locked; locks expire by themselves.
+func (s *Storage) LockedUntil(ctx context.Context, userID int64) (time.Time, error) {
+	const op = "storage.redis.LockedUntil"
+
+	ms, err := s.client.Get(ctx, lockedUntilKey(userID)).Int64()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return time.Time{}, nil
+		}
+
+		return time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return time.UnixMilli(ms), nil
+}
+
+func unknownLoginKey(loginHash []byte) string {
+	return fmt.Sprintf("lockout:unknown:%x", loginHash)
+}
+
+// FailUnknownLogin counts a failed login of no user by the hash of the login,
+// locking it like FailLogin.
+func (s *Storage) FailUnknownLogin(ctx context.Context, loginHash []byte, threshold int, lockUntil time.Time) (bool, error) {
+	const op = "storage.redis.FailUnknownLogin"
+
+	key := unknownLoginKey(loginHash)
+
+	locked, err := failLoginScript.Run(ctx, s.client,
+		[]string{key + ":failures", key + ":until"},
+		threshold, lockUntil.UnixMilli(), int(failedLoginsTTL.Seconds()),
+	).Int()
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return locked == 1, nil
+}
+
+// UnknownLoginFailures returns the counter and the lock of the login of no user.
+func (s *Storage) UnknownLoginFailures(ctx context.Context, loginHash []byte) (int, time.Time, error) {
+	const op = "storage.redis.UnknownLoginFailures"
+
+	key := unknownLoginKey(loginHash)
+
+	values, err := s.client.MGet(ctx, key+":failures", key+":until").Result()
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var (
+		failures int
+		until    time.Time
+	)
+
+	if v, ok := values[0].(string); ok {
+		if failures, err = strconv.Atoi(v); err != nil {
+			return 0, time.Time{}, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if v, ok := values[1].(string); ok {
+		ms, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0, time.Time{}, fmt.Errorf("%s: %w", op, err)
+		}
+
+		until = time.UnixMilli(ms)
+	}
+
+	return failures, until, nil
+}
+
+func appKey(appID int) string {
+	return fmt.Sprintf("app:%d", appID)
+}
+
+// CachedApp returns the app cached by CacheApp or storage.ErrAppNotFound.
+func (s *Storage) CachedApp(ctx context.Context, appID int) (models.App, error) {
+	const op = "storage.redis.CachedApp"
+
+	data, err := s.client.Get(ctx, appKey(appID)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return models.App{}, storage.ErrAppNotFound
+		}
+
+		return models.App{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var app models.App
+	if err := json.Unmarshal(data, &app); err != nil {
+		return models.App{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return app, nil
+}
+
+// CacheApp keeps the app for ttl. The record includes the secrets of the app,
+// so Redis must be as private as the database.
+func (s *Storage) CacheApp(ctx context.Context, app models.App, ttl time.Duration) error {
+	const op = "storage.redis.CacheApp"
+
+	data, err := json.Marshal(app)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := s.client.Set(ctx, appKey(app.ID), data, ttl).Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// UncacheApp drops the cached app, so all instances read the changed one.
+func (s *Storage) UncacheApp(ctx context.Context, appID int) error {
+	const op = "storage.redis.UncacheApp"
+
+	if err := s.client.Del(ctx, appKey(appID)).Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func deniedTokenKey(jti string) string {
+	return "denylist:" + jti
+}
+
+// DenyToken revokes the access token with the given id until expiresAt,
+// after which the token is rejected as expired anyway.
+func (s *Storage) DenyToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	const op = "storage.redis.DenyToken"
+
+	// Нулевой TTL в go-redis означает ключ без срока
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+
+	if err := s.client.Set(ctx, deniedTokenKey(jti), 1, ttl).Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// TokenDenied reports whether the access token was revoked by DenyToken.
+func (s *Storage) TokenDenied(ctx context.Context, jti string) (bool, error) {
+	const op = "storage.redis.TokenDenied"
+
+	n, err := s.client.Exists(ctx, deniedTokenKey(jti)).Result()
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return n == 1, nil
+}
@@ -3,7 +3,32 @@ package storage
 import "errors"
 
 var (
-	ErrUserExists   = errors.New("user already exists")
-	ErrUserNotFound = errors.New("user not found")
-	ErrAppNotFound  = errors.New("app not found")
+	ErrUserExists           = errors.New("user already exists")
+	ErrUserNotFound         = errors.New("user not found")
+	ErrAppNotFound          = errors.New("app not found")
+	ErrAppExists            = errors.New("app already exists")
+	ErrJTIUsed              = errors.New("jti already used")
+	ErrUsernameTaken        = errors.New("username already taken")
+	ErrPhoneTaken           = errors.New("phone already taken")
+	ErrOTPNotFound          = errors.New("otp not found")
+	ErrInvalidSort          = errors.New("invalid sort field")
+	ErrTokenNotFound        = errors.New("token not found, expired or already used")
+	ErrTOTPNotFound         = errors.New("totp not enrolled")
+	ErrTOTPStepUsed         = errors.New("totp code already used")
+	ErrPasskeyExists        = errors.New("passkey already registered")
+	ErrPasskeyNotFound      = errors.New("passkey not found")
+	ErrRecoveryCodeNotFound = errors.New("recovery code not found or already used")
+	ErrIdentityExists       = errors.New("identity already linked")
+	ErrAPIKeyNotFound       = errors.New("api key not found or revoked")
+	ErrSessionNotFound      = errors.New("session not found, expired or revoked")
+	ErrRoleExists           = errors.New("role already exists")
+	ErrRoleNotFound         = errors.New("role not found")
+	ErrRoleInUse            = errors.New("role is assigned to users or api keys")
+	ErrGroupExists          = errors.New("group already exists")
+	ErrGroupNotFound        = errors.New("group not found")
+	ErrOrgExists            = errors.New("organization already exists")
+	ErrOrgNotFound          = errors.New("organization not found")
+	ErrWebhookNotFound      = errors.New("webhook not found")
+	ErrIdempotencyKeyExists = errors.New("idempotency key already used")
+	ErrUnavailable          = errors.New("storage unavailable")
 )
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS used_jtis;
\ No newline at end of file
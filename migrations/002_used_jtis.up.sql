@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS used_jtis (
+    jti TEXT PRIMARY KEY,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_used_jtis_expires_at ON used_jtis (expires_at);
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS user_redirects;
\ No newline at end of file
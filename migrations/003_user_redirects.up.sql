@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS user_redirects (
+    old_id INTEGER PRIMARY KEY,
+    new_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    merged_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_user_redirects_new_id ON user_redirects (new_id);
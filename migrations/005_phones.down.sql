@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS otp_codes;
+ALTER TABLE users DROP COLUMN IF EXISTS phone_verified;
+ALTER TABLE users DROP COLUMN IF EXISTS phone;
+-- email stays nullable: users registered by phone have none.
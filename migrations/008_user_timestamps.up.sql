@@ -0,0 +1,6 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+ALTER TABLE users ADD COLUMN IF NOT EXISTS last_login_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_users_created_at ON users (created_at, id);
+CREATE INDEX IF NOT EXISTS idx_users_last_login_at ON users (last_login_at, id);
+CREATE INDEX IF NOT EXISTS idx_users_role ON users (role, id);
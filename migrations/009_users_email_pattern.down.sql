@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_users_email_pattern;
\ No newline at end of file
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS idx_users_email_pattern ON users (email text_pattern_ops);
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS user_totp (
+    user_id INTEGER PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    -- encrypted with the MFA key from config
+    secret BYTEA NOT NULL,
+    confirmed_at TIMESTAMPTZ,
+    -- last accepted time step, codes can't be replayed
+    last_step BIGINT NOT NULL DEFAULT 0
+);
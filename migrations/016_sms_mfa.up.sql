@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS sms_mfa BOOLEAN NOT NULL DEFAULT FALSE;
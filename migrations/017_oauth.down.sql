@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS authorization_codes;
+ALTER TABLE apps DROP COLUMN IF EXISTS redirect_uris;
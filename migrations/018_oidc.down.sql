@@ -0,0 +1,3 @@
+ALTER TABLE authorization_codes
+    DROP COLUMN IF EXISTS scope,
+    DROP COLUMN IF EXISTS nonce;
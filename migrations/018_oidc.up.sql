@@ -0,0 +1,3 @@
+ALTER TABLE authorization_codes
+    ADD COLUMN IF NOT EXISTS scope TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS nonce TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,5 @@
+ALTER TABLE authorization_codes
+    DROP COLUMN IF EXISTS code_challenge,
+    DROP COLUMN IF EXISTS code_challenge_method;
+
+ALTER TABLE apps DROP COLUMN IF EXISTS public;
@@ -0,0 +1,5 @@
+ALTER TABLE apps ADD COLUMN IF NOT EXISTS public BOOLEAN NOT NULL DEFAULT false;
+
+ALTER TABLE authorization_codes
+    ADD COLUMN IF NOT EXISTS code_challenge TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS code_challenge_method TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS login_attempts (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users (id) ON DELETE CASCADE,
+    app_id INTEGER NOT NULL,
+    login TEXT NOT NULL DEFAULT '',
+    method TEXT NOT NULL,
+    result TEXT NOT NULL,
+    ip TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_attempts_user_id ON login_attempts (user_id, id DESC);
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS login_failures (
+    user_id INTEGER PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    -- consecutive failed logins since the last success or lock
+    failures INTEGER NOT NULL DEFAULT 0,
+    locked_until TIMESTAMPTZ
+);
@@ -0,0 +1,4 @@
+ALTER TABLE api_keys DROP CONSTRAINT IF EXISTS api_keys_role_fkey;
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_role_fkey;
+DROP TABLE IF EXISTS role_permissions;
+DROP TABLE IF EXISTS roles;
@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS roles (
+    name TEXT PRIMARY KEY,
+    description TEXT NOT NULL DEFAULT '',
+    -- higher rank is more privileged, e.g. the merged user keeps the higher role
+    rank INTEGER NOT NULL DEFAULT 0,
+    -- whether users may pick the role on self-registration
+    self_assignable BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role TEXT NOT NULL REFERENCES roles (name) ON DELETE CASCADE,
+    permission TEXT NOT NULL,
+    PRIMARY KEY (role, permission)
+);
+
+-- roles previously hardcoded in the service
+INSERT INTO roles (name, rank, self_assignable) VALUES
+    ('user', 0, TRUE),
+    ('organizer', 1, TRUE),
+    ('admin', 2, FALSE)
+ON CONFLICT (name) DO NOTHING;
+
+-- keep any other roles already assigned, so the constraints below hold
+INSERT INTO roles (name)
+    SELECT role FROM users UNION SELECT role FROM api_keys
+ON CONFLICT (name) DO NOTHING;
+
+ALTER TABLE users ADD CONSTRAINT users_role_fkey FOREIGN KEY (role) REFERENCES roles (name);
+ALTER TABLE api_keys ADD CONSTRAINT api_keys_role_fkey FOREIGN KEY (role) REFERENCES roles (name);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_users_org_id;
+ALTER TABLE apps DROP COLUMN IF EXISTS org_id;
+ALTER TABLE users DROP COLUMN IF EXISTS org_id;
+DROP TABLE IF EXISTS organizations;
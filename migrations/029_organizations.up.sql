@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS organizations (
+    id BIGSERIAL PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- NULL org_id: the user or app belongs to the platform itself rather than an organization
+ALTER TABLE users ADD COLUMN IF NOT EXISTS org_id BIGINT REFERENCES organizations (id);
+ALTER TABLE apps ADD COLUMN IF NOT EXISTS org_id BIGINT REFERENCES organizations (id);
+
+CREATE INDEX IF NOT EXISTS idx_users_org_id ON users (org_id);
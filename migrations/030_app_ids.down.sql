@@ -0,0 +1,3 @@
+ALTER TABLE apps DROP COLUMN IF EXISTS created_at;
+ALTER TABLE apps ALTER COLUMN id DROP DEFAULT;
+DROP SEQUENCE IF EXISTS apps_id_seq;
@@ -0,0 +1,6 @@
+-- Apps were inserted by hand with explicit ids; new ones get them from a sequence
+CREATE SEQUENCE IF NOT EXISTS apps_id_seq OWNED BY apps.id;
+SELECT setval('apps_id_seq', COALESCE((SELECT max(id) FROM apps), 0) + 1, false);
+ALTER TABLE apps ALTER COLUMN id SET DEFAULT nextval('apps_id_seq');
+
+ALTER TABLE apps ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now();
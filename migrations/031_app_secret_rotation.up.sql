@@ -0,0 +1,3 @@
+-- The secret replaced by rotation still verifies tokens until previous_secret_expires_at
+ALTER TABLE apps ADD COLUMN IF NOT EXISTS previous_secret TEXT;
+ALTER TABLE apps ADD COLUMN IF NOT EXISTS previous_secret_expires_at TIMESTAMPTZ;
@@ -0,0 +1,2 @@
+ALTER TABLE apps DROP COLUMN IF EXISTS audience;
+ALTER TABLE apps DROP COLUMN IF EXISTS token_ttl_seconds;
@@ -0,0 +1,3 @@
+-- Zero token_ttl_seconds and empty audience fall back to the global token_ttl and no aud claim
+ALTER TABLE apps ADD COLUMN IF NOT EXISTS token_ttl_seconds INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE apps ADD COLUMN IF NOT EXISTS audience TEXT NOT NULL DEFAULT '';
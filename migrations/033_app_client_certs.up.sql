@@ -0,0 +1,3 @@
+-- Identity of the client certificate of a service calling over mTLS: a URI SAN
+-- (e.g. a SPIFFE ID) or the subject common name. NULL apps have no certificate
+ALTER TABLE apps ADD COLUMN IF NOT EXISTS client_cert_subject TEXT UNIQUE;
@@ -0,0 +1,3 @@
+-- pg_trgm stays installed, other objects may use it.
+DROP INDEX IF EXISTS idx_users_username_trgm;
+DROP INDEX IF EXISTS idx_users_email_trgm;
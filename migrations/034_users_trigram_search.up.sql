@@ -0,0 +1,6 @@
+-- Trigram indexes serve substring (ILIKE '%q%') and similarity (%) searches
+-- of users by email and username.
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX IF NOT EXISTS idx_users_email_trgm ON users USING GIN (email gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_users_username_trgm ON users USING GIN (username gin_trgm_ops);
@@ -0,0 +1,3 @@
+-- Custom attributes of the user set by integrating apps: one JSON object per
+-- app, keyed by the app id as text.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS metadata JSONB NOT NULL DEFAULT '{}';
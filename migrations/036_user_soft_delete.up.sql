@@ -0,0 +1,2 @@
+-- Soft-deleted users can't log in and are hidden from lookups until restored.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
@@ -0,0 +1,3 @@
+-- Suspended and banned users can't log in.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'active'
+    CHECK (status IN ('active', 'suspended', 'banned'));
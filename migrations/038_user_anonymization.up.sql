@@ -0,0 +1,2 @@
+-- Anonymized users keep only the row: id, role, org and timestamps.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS anonymized_at TIMESTAMPTZ;
@@ -0,0 +1,14 @@
+-- Events written in the transaction of the user change they describe. The
+-- relay publishes them to the broker, queues webhook deliveries and deletes
+-- them, so an event is never lost between commit and publish.
+CREATE TABLE IF NOT EXISTS outbox (
+    id BIGSERIAL PRIMARY KEY,
+    -- event_id is the id in the envelope, used by consumers to drop duplicates.
+    event_id TEXT NOT NULL,
+    event TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_outbox_next_attempt_at ON outbox (next_attempt_at);
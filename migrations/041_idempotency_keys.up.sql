@@ -0,0 +1,18 @@
+-- Outcomes of mutating calls by the idempotency key sent by the client, so
+-- that a retried call returns the first result instead of running again.
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    -- scope is the method and the caller the key belongs to.
+    scope TEXT NOT NULL,
+    key TEXT NOT NULL,
+    request_hash BYTEA NOT NULL,
+    -- code, message and response are set when the call completes.
+    code INTEGER,
+    message TEXT NOT NULL DEFAULT '',
+    response BYTEA,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    completed_at TIMESTAMPTZ,
+    -- locked_until lets another call take the key of a call that never completed.
+    locked_until TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (scope, key)
+);
@@ -0,0 +1,2 @@
+-- Deleted keys can't be restored.
+SELECT 1;
@@ -0,0 +1,9 @@
+-- Unverified duplicates of a number lose it to its verified or oldest holder.
+UPDATE users u SET phone = NULL
+WHERE NOT u.phone_verified AND EXISTS (
+    SELECT 1 FROM users o
+    WHERE o.phone = u.phone AND o.id <> u.id AND (o.phone_verified OR o.id < u.id)
+);
+DROP INDEX IF EXISTS idx_users_phone;
+DROP INDEX IF EXISTS idx_users_verified_phone;
+ALTER TABLE users ADD CONSTRAINT users_phone_key UNIQUE (phone);
@@ -0,0 +1,5 @@
+-- Unverified numbers no longer reserve them: several users may have set the
+-- same number, and whoever verifies it first keeps it.
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_phone_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_verified_phone ON users (phone) WHERE phone_verified;
+CREATE INDEX IF NOT EXISTS idx_users_phone ON users (phone);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_audit_log_target_app_id;
+DROP INDEX IF EXISTS idx_audit_log_actor_app_id;
@@ -0,0 +1,3 @@
+-- Apps query events they made or that concern them, see ListAppAuditEvents.
+CREATE INDEX IF NOT EXISTS idx_audit_log_actor_app_id ON audit_log (actor_app_id, id DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_log_target_app_id ON audit_log (target_app_id, id DESC);
@@ -0,0 +1,35 @@
+// Package migrations embeds the SQL migrations, so that the binary can
+// apply them without the files next to it.
+package migrations
+
+import (
+	"embed"
+	"io/fs"
+	"os"
+)
+
+// FS holds the NNN_name.up.sql and NNN_name.down.sql files of PostgreSQL.
+//
+//go:embed *.sql
+var FS embed.FS
+
+//go:embed mysql/*.sql
+var mysqlFS embed.FS
+
+// Source returns the migrations in dir, or the embedded ones of the storage
+// driver, postgres or mysql, if dir is empty. The memory driver has no schema
+// and gets no migrations.
+func Source(driver string, dir string) fs.FS {
+	switch {
+	case driver == "memory":
+		return embed.FS{}
+	case dir != "":
+		return os.DirFS(dir)
+	case driver == "mysql":
+		sub, _ := fs.Sub(mysqlFS, "mysql")
+
+		return sub
+	default:
+		return FS
+	}
+}
@@ -0,0 +1,29 @@
+DROP TABLE IF EXISTS idempotency_keys;
+DROP TABLE IF EXISTS outbox;
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS webhooks;
+DROP TABLE IF EXISTS group_members;
+DROP TABLE IF EXISTS `groups`;
+DROP TABLE IF EXISTS login_failures;
+DROP TABLE IF EXISTS audit_log;
+DROP TABLE IF EXISTS login_attempts;
+DROP TABLE IF EXISTS api_keys;
+DROP TABLE IF EXISTS user_identities;
+DROP TABLE IF EXISTS authorization_codes;
+DROP TABLE IF EXISTS recovery_codes;
+DROP TABLE IF EXISTS passkeys;
+DROP TABLE IF EXISTS user_totp;
+DROP TABLE IF EXISTS user_token_revocations;
+DROP TABLE IF EXISTS revoked_tokens;
+DROP TABLE IF EXISTS refresh_tokens;
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS one_time_tokens;
+DROP TABLE IF EXISTS user_preferences;
+DROP TABLE IF EXISTS otp_codes;
+DROP TABLE IF EXISTS user_redirects;
+DROP TABLE IF EXISTS used_jtis;
+DROP TABLE IF EXISTS apps;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS role_permissions;
+DROP TABLE IF EXISTS roles;
+DROP TABLE IF EXISTS organizations;
@@ -0,0 +1,338 @@
+-- The schema of migrations 001-041 for MySQL 8.0 and MariaDB 10.6, see the
+-- Postgres migrations for the comments on tables and columns. Times are UTC
+-- (the service connects with time_zone '+00:00'), arrays are JSON arrays and
+-- text compares byte-wise, like in Postgres.
+
+CREATE TABLE IF NOT EXISTS organizations (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(255) NOT NULL UNIQUE,
+    created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS roles (
+    name VARCHAR(64) PRIMARY KEY,
+    description TEXT NOT NULL,
+    `rank` INT NOT NULL DEFAULT 0,
+    self_assignable BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role VARCHAR(64) NOT NULL,
+    permission VARCHAR(255) NOT NULL,
+    PRIMARY KEY (role, permission),
+    CONSTRAINT role_permissions_role_fkey FOREIGN KEY (role) REFERENCES roles (name) ON DELETE CASCADE
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+INSERT INTO roles (name, description, `rank`, self_assignable) VALUES
+    ('user', '', 0, TRUE),
+    ('organizer', '', 1, TRUE),
+    ('admin', '', 2, FALSE);
+
+CREATE TABLE IF NOT EXISTS users (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    email VARCHAR(320) UNIQUE,
+    username VARCHAR(255) UNIQUE,
+    phone VARCHAR(32) UNIQUE,
+    phone_verified BOOLEAN NOT NULL DEFAULT FALSE,
+    avatar_url TEXT,
+    pass_hash VARBINARY(512) NOT NULL,
+    role VARCHAR(64) NOT NULL,
+    sms_mfa BOOLEAN NOT NULL DEFAULT FALSE,
+    org_id BIGINT,
+    metadata JSON NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'active',
+    created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    last_login_at DATETIME(6),
+    deleted_at DATETIME(6),
+    anonymized_at DATETIME(6),
+    INDEX idx_users_created_at (created_at, id),
+    INDEX idx_users_last_login_at (last_login_at, id),
+    INDEX idx_users_role (role, id),
+    CONSTRAINT users_role_fkey FOREIGN KEY (role) REFERENCES roles (name),
+    CONSTRAINT users_org_id_fkey FOREIGN KEY (org_id) REFERENCES organizations (id),
+    CONSTRAINT users_status_check CHECK (status IN ('active', 'suspended', 'banned'))
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS apps (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(255) NOT NULL UNIQUE,
+    secret VARCHAR(255) NOT NULL UNIQUE,
+    previous_secret VARCHAR(255),
+    previous_secret_expires_at DATETIME(6),
+    redirect_uris JSON NOT NULL,
+    public BOOLEAN NOT NULL DEFAULT FALSE,
+    scopes JSON NOT NULL,
+    org_id BIGINT,
+    token_ttl_seconds INT NOT NULL DEFAULT 0,
+    audience VARCHAR(255) NOT NULL DEFAULT '',
+    client_cert_subject VARCHAR(512) UNIQUE,
+    created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    CONSTRAINT apps_org_id_fkey FOREIGN KEY (org_id) REFERENCES organizations (id)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS used_jtis (
+    jti VARCHAR(255) PRIMARY KEY,
+    expires_at DATETIME(6) NOT NULL,
+    INDEX idx_used_jtis_expires_at (expires_at)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS user_redirects (
+    old_id INT PRIMARY KEY,
+    new_id INT NOT NULL,
+    merged_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    INDEX idx_user_redirects_new_id (new_id),
+    CONSTRAINT user_redirects_new_id_fkey FOREIGN KEY (new_id) REFERENCES users (id) ON DELETE CASCADE
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS otp_codes (
+    `key` VARCHAR(320) NOT NULL,
+    purpose VARCHAR(64) NOT NULL,
+    code_hash VARBINARY(128) NOT NULL,
+    expires_at DATETIME(6) NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    PRIMARY KEY (`key`, purpose)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS user_preferences (
+    user_id INT NOT NULL,
+    `key` VARCHAR(255) NOT NULL,
+    value TEXT NOT NULL,
+    updated_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    PRIMARY KEY (user_id, `key`),
+    CONSTRAINT user_preferences_user_id_fkey FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS one_time_tokens (
+    token_hash VARBINARY(64) PRIMARY KEY,
+    purpose VARCHAR(64) NOT NULL,
+    subject VARCHAR(320) NOT NULL,
+    expires_at DATETIME(6) NOT NULL,
+    consumed_at DATETIME(6),
+    created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    INDEX idx_one_time_tokens_expires_at (expires_at),
+    INDEX idx_one_time_tokens_subject (subject)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS sessions (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NOT NULL,
+    app_id INT NOT NULL,
+    ip VARCHAR(64) NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL,
+    device VARCHAR(255) NOT NULL DEFAULT '',
+    created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    last_seen_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    expires_at DATETIME(6) NOT NULL,
+    revoked_at DATETIME(6),
+    INDEX idx_sessions_user_id (user_id),
+    CONSTRAINT sessions_user_id_fkey FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    token_hash VARBINARY(64) PRIMARY KEY,
+    user_id INT NOT NULL,
+    app_id INT NOT NULL,
+    session_id BIGINT,
+    expires_at DATETIME(6) NOT NULL,
+    created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    revoked_at DATETIME(6),
+    INDEX idx_refresh_tokens_user_id (user_id),
+    CONSTRAINT refresh_tokens_user_id_fkey FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    CONSTRAINT refresh_tokens_session_id_fkey FOREIGN KEY (session_id) REFERENCES sessions (id) ON DELETE CASCADE
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    jti VARCHAR(255) PRIMARY KEY,
+    expires_at DATETIME(6) NOT NULL,
+    INDEX idx_revoked_tokens_expires_at (expires_at)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS user_token_revocations (
+    user_id INT PRIMARY KEY,
+    revoked_before DATETIME(6) NOT NULL,
+    CONSTRAINT user_token_revocations_user_id_fkey FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS user_totp (
+    user_id INT PRIMARY KEY,
+    secret VARBINARY(512) NOT NULL,
+    confirmed_at DATETIME(6),
+    last_step BIGINT NOT NULL DEFAULT 0,
+    CONSTRAINT user_totp_user_id_fkey FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS passkeys (
+    id VARBINARY(1023) NOT NULL,
+    user_id INT NOT NULL,
+    public_key BLOB NOT NULL,
+    sign_count BIGINT NOT NULL DEFAULT 0,
+    created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    last_used_at DATETIME(6),
+    PRIMARY KEY (id),
+    INDEX idx_passkeys_user_id (user_id),
+    CONSTRAINT passkeys_user_id_fkey FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    user_id INT NOT NULL,
+    code_hash VARBINARY(64) NOT NULL,
+    used_at DATETIME(6),
+    PRIMARY KEY (user_id, code_hash),
+    CONSTRAINT recovery_codes_user_id_fkey FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS authorization_codes (
+    code_hash VARBINARY(64) PRIMARY KEY,
+    app_id INT NOT NULL,
+    user_id INT NOT NULL,
+    redirect_uri TEXT NOT NULL,
+    scope TEXT NOT NULL,
+    nonce VARCHAR(255) NOT NULL DEFAULT '',
+    code_challenge VARCHAR(255) NOT NULL DEFAULT '',
+    code_challenge_method VARCHAR(16) NOT NULL DEFAULT '',
+    expires_at DATETIME(6) NOT NULL,
+    consumed_at DATETIME(6),
+    created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    INDEX idx_authorization_codes_expires_at (expires_at),
+    CONSTRAINT authorization_codes_app_id_fkey FOREIGN KEY (app_id) REFERENCES apps (id) ON DELETE CASCADE,
+    CONSTRAINT authorization_codes_user_id_fkey FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS user_identities (
+    provider VARCHAR(64) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    user_id INT NOT NULL,
+    created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    PRIMARY KEY (provider, subject),
+    INDEX idx_user_identities_user_id (user_id),
+    CONSTRAINT user_identities_user_id_fkey FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS api_keys (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    app_id INT NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    role VARCHAR(64) NOT NULL,
+    key_hash VARBINARY(64) NOT NULL UNIQUE,
+    prefix VARCHAR(32) NOT NULL,
+    created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    last_used_at DATETIME(6),
+    revoked_at DATETIME(6),
+    INDEX idx_api_keys_app_id (app_id),
+    CONSTRAINT api_keys_app_id_fkey FOREIGN KEY (app_id) REFERENCES apps (id) ON DELETE CASCADE,
+    CONSTRAINT api_keys_role_fkey FOREIGN KEY (role) REFERENCES roles (name)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS login_attempts (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id INT,
+    app_id INT NOT NULL,
+    login VARCHAR(320) NOT NULL DEFAULT '',
+    method VARCHAR(32) NOT NULL,
+    result VARCHAR(32) NOT NULL,
+    ip VARCHAR(64) NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL,
+    created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    INDEX idx_login_attempts_user_id (user_id, id DESC),
+    CONSTRAINT login_attempts_user_id_fkey FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    action VARCHAR(64) NOT NULL,
+    actor_user_id BIGINT,
+    actor_app_id INT,
+    actor_api_key_id BIGINT,
+    target_user_id BIGINT,
+    target_app_id INT,
+    reason TEXT NOT NULL,
+    details JSON NOT NULL,
+    created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    INDEX idx_audit_log_target_user_id (target_user_id, id DESC),
+    INDEX idx_audit_log_actor_user_id (actor_user_id, id DESC)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+-- TRUNCATE doesn't fire triggers in MySQL; revoke DROP from the service user
+-- to keep it out.
+CREATE TRIGGER audit_log_no_update BEFORE UPDATE ON audit_log
+    FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'audit_log is append-only';
+
+CREATE TRIGGER audit_log_no_delete BEFORE DELETE ON audit_log
+    FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'audit_log is append-only';
+
+CREATE TABLE IF NOT EXISTS login_failures (
+    user_id INT PRIMARY KEY,
+    failures INT NOT NULL DEFAULT 0,
+    locked_until DATETIME(6),
+    CONSTRAINT login_failures_user_id_fkey FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS `groups` (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(255) NOT NULL UNIQUE,
+    description TEXT NOT NULL,
+    created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS group_members (
+    group_id BIGINT NOT NULL,
+    user_id INT NOT NULL,
+    added_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    PRIMARY KEY (group_id, user_id),
+    INDEX idx_group_members_user_id (user_id),
+    CONSTRAINT group_members_group_id_fkey FOREIGN KEY (group_id) REFERENCES `groups` (id) ON DELETE CASCADE,
+    CONSTRAINT group_members_user_id_fkey FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS webhooks (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    app_id INT NOT NULL,
+    url TEXT NOT NULL,
+    events JSON NOT NULL,
+    secret VARCHAR(255) NOT NULL,
+    created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    INDEX idx_webhooks_app_id (app_id),
+    CONSTRAINT webhooks_app_id_fkey FOREIGN KEY (app_id) REFERENCES apps (id) ON DELETE CASCADE
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    webhook_id BIGINT NOT NULL,
+    event VARCHAR(64) NOT NULL,
+    payload JSON NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    last_status INT NOT NULL DEFAULT 0,
+    last_error TEXT NOT NULL,
+    created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    delivered_at DATETIME(6),
+    failed_at DATETIME(6),
+    INDEX idx_webhook_deliveries_webhook_id (webhook_id, id DESC),
+    INDEX idx_webhook_deliveries_next_attempt_at (next_attempt_at),
+    CONSTRAINT webhook_deliveries_webhook_id_fkey FOREIGN KEY (webhook_id) REFERENCES webhooks (id) ON DELETE CASCADE
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS outbox (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    event_id VARCHAR(64) NOT NULL,
+    event VARCHAR(64) NOT NULL,
+    payload JSON NOT NULL,
+    created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    next_attempt_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    INDEX idx_outbox_next_attempt_at (next_attempt_at)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    scope VARCHAR(255) NOT NULL,
+    `key` VARCHAR(255) NOT NULL,
+    request_hash VARBINARY(64) NOT NULL,
+    code INT,
+    message TEXT NOT NULL,
+    response MEDIUMBLOB,
+    created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    completed_at DATETIME(6),
+    locked_until DATETIME(6) NOT NULL,
+    expires_at DATETIME(6) NOT NULL,
+    PRIMARY KEY (scope, `key`)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
@@ -0,0 +1,2 @@
+-- Deleted keys can't be restored.
+DO 0;
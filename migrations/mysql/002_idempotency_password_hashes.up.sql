@@ -0,0 +1,3 @@
+-- Hashes of Register requests used to include the password, see
+-- interceptors.requestHash. Retries of these keys run the call again.
+DELETE FROM idempotency_keys WHERE scope = '/auth.Auth/Register' OR scope LIKE '/auth.Auth/Register:%';
@@ -0,0 +1,11 @@
+-- Unverified duplicates of a number lose it to its verified or oldest holder.
+UPDATE users u
+JOIN users o ON o.phone = u.phone AND o.id <> u.id AND (o.phone_verified OR o.id < u.id)
+SET u.phone = NULL
+WHERE NOT u.phone_verified;
+
+ALTER TABLE users
+    ADD UNIQUE INDEX phone (phone),
+    DROP INDEX idx_users_phone,
+    DROP INDEX idx_users_verified_phone,
+    DROP COLUMN verified_phone;
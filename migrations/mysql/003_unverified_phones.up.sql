@@ -0,0 +1,8 @@
+-- Unverified numbers no longer reserve them: several users may have set the
+-- same number, and whoever verifies it first keeps it. MySQL has no partial
+-- indexes, so uniqueness is kept on a column holding verified numbers only.
+ALTER TABLE users
+    ADD COLUMN verified_phone VARCHAR(32) GENERATED ALWAYS AS (IF(phone_verified, phone, NULL)) STORED,
+    ADD UNIQUE INDEX idx_users_verified_phone (verified_phone),
+    ADD INDEX idx_users_phone (phone),
+    DROP INDEX phone;
@@ -0,0 +1,2 @@
+-- Region of the instance that started the session, empty for a single region.
+ALTER TABLE sessions ADD COLUMN region VARCHAR(64) NOT NULL DEFAULT '' AFTER device;
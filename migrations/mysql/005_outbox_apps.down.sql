@@ -0,0 +1 @@
+ALTER TABLE outbox DROP COLUMN app_id;
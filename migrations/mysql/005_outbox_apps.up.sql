@@ -0,0 +1,2 @@
+-- App of events about a login to one app, delivered to webhooks of that app only.
+ALTER TABLE outbox ADD COLUMN app_id INT AFTER payload;
@@ -0,0 +1,7 @@
+-- Failed logins of no user, by SHA-256 of the lowercased login, so that
+-- unknown logins are locked and need a captcha like those of users.
+CREATE TABLE IF NOT EXISTS unknown_login_failures (
+    login_hash VARBINARY(32) PRIMARY KEY,
+    failures INT NOT NULL DEFAULT 0,
+    locked_until DATETIME(6)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
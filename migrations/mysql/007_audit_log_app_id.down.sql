@@ -0,0 +1,3 @@
+ALTER TABLE audit_log
+    DROP INDEX idx_audit_log_target_app_id,
+    DROP INDEX idx_audit_log_actor_app_id;
@@ -0,0 +1,4 @@
+-- Apps query events they made or that concern them, see ListAppAuditEvents.
+ALTER TABLE audit_log
+    ADD INDEX idx_audit_log_actor_app_id (actor_app_id, id DESC),
+    ADD INDEX idx_audit_log_target_app_id (target_app_id, id DESC);
@@ -0,0 +1,25 @@
+package ssotest
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// IncomingContext returns ctx of an incoming gRPC call of method with the
+// metadata, for calling interceptors and the service directly.
+func IncomingContext(ctx context.Context, method string, md metadata.MD) context.Context {
+	ctx = metadata.NewIncomingContext(ctx, md)
+
+	return grpc.NewContextWithServerTransportStream(ctx, &transportStream{method: method})
+}
+
+type transportStream struct {
+	method string
+}
+
+func (s *transportStream) Method() string               { return s.method }
+func (s *transportStream) SetHeader(metadata.MD) error  { return nil }
+func (s *transportStream) SendHeader(metadata.MD) error { return nil }
+func (s *transportStream) SetTrailer(metadata.MD) error { return nil }
@@ -0,0 +1,79 @@
+package ssotest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"sso/internal/lib/dpop"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// DPoPKey signs DPoP proofs (RFC 9449) of calls made in tests.
+type DPoPKey struct {
+	key  *ecdsa.PrivateKey
+	x, y string
+}
+
+// NewDPoPKey generates a P-256 key, failing the test on error.
+func NewDPoPKey(t testing.TB) *DPoPKey {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ssotest: generate dpop key: %v", err)
+	}
+
+	pub, err := key.PublicKey.ECDH()
+	if err != nil {
+		t.Fatalf("ssotest: dpop public key: %v", err)
+	}
+
+	// Несжатая точка: 0x04 || X || Y
+	point := pub.Bytes()
+
+	return &DPoPKey{
+		key: key,
+		x:   base64.RawURLEncoding.EncodeToString(point[1:33]),
+		y:   base64.RawURLEncoding.EncodeToString(point[33:]),
+	}
+}
+
+// JKT returns the JWK thumbprint (RFC 7638) that tokens bound to the key carry in cnf.jkt.
+func (k *DPoPKey) JKT() string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf(`{"crv":"P-256","kty":"EC","x":%q,"y":%q}`, k.x, k.y)))
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// Proof returns a fresh proof of the gRPC call of method, e.g. "/auth.Auth/Login",
+// to be sent in the dpop.Header metadata. Non-empty accessToken is hashed into ath.
+func (k *DPoPKey) Proof(t testing.TB, method string, accessToken string) string {
+	t.Helper()
+
+	claims := jwt.MapClaims{
+		"jti": rand.Text(),
+		"iat": time.Now().Unix(),
+		"htm": dpop.Method,
+		"htu": method,
+	}
+	if accessToken != "" {
+		claims["ath"] = dpop.AccessTokenHash(accessToken)
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["typ"] = "dpop+jwt"
+	token.Header["jwk"] = map[string]string{"kty": "EC", "crv": "P-256", "x": k.x, "y": k.y}
+
+	proof, err := token.SignedString(k.key)
+	if err != nil {
+		t.Fatalf("ssotest: sign dpop proof: %v", err)
+	}
+
+	return proof
+}
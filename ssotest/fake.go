@@ -0,0 +1,113 @@
+package ssotest
+
+import (
+	"context"
+	"sort"
+	"sso/internal/domain/models"
+	"sso/internal/services/auth"
+	"sso/internal/storage"
+	"sync"
+)
+
+// FakeAuth is a minimal stand-in for the auth service: passwords are kept
+// in plain text, tokens are minted with MintToken and there are no policies
+// (registration modes, email domains, role restrictions).
+type FakeAuth struct {
+	mu        sync.Mutex
+	users     []models.User
+	passwords map[int64]string
+}
+
+func NewFakeAuth() *FakeAuth {
+	return &FakeAuth{passwords: make(map[int64]string)}
+}
+
+// AddUser adds a user with the given password and returns its id.
+func (f *FakeAuth) AddUser(email string, password string, role string) int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	id := int64(len(f.users) + 1)
+	f.users = append(f.users, models.User{ID: id, Email: email, Role: role})
+	f.passwords[id] = password
+
+	return id
+}
+
+// Login issues no refresh tokens.
+func (f *FakeAuth) Login(_ context.Context, email string, password string, appID int) (string, string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, u := range f.users {
+		if u.Email == email {
+			if f.passwords[u.ID] != password {
+				return "", "", auth.ErrInvalidCredentials
+			}
+
+			token, err := MintToken(Claims{UserID: u.ID, Email: u.Email, Role: u.Role, AppID: appID}, AppSecret)
+
+			return token, "", err
+		}
+	}
+
+	return "", "", auth.ErrInvalidCredentials
+}
+
+func (f *FakeAuth) RegisterNewUser(_ context.Context, email string, password string, role string, _ string) (int64, error) {
+	f.mu.Lock()
+	exists := false
+	for _, u := range f.users {
+		exists = exists || u.Email == email
+	}
+	f.mu.Unlock()
+
+	if exists {
+		return 0, storage.ErrUserExists
+	}
+
+	if role == "" {
+		role = "user"
+	}
+
+	return f.AddUser(email, password, role), nil
+}
+
+func (f *FakeAuth) GetUserRole(_ context.Context, userID int64) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, u := range f.users {
+		if u.ID == userID {
+			return u.Role, nil
+		}
+	}
+
+	return "", auth.ErrUserNotFound
+}
+
+func (f *FakeAuth) UpdateRole(_ context.Context, userID int64, role string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i, u := range f.users {
+		if u.ID == userID {
+			f.users[i].Role = role
+			return nil
+		}
+	}
+
+	return auth.ErrUserNotFound
+}
+
+func (f *FakeAuth) ListUsers(_ context.Context, order models.UserSort) ([]models.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	users := append([]models.User(nil), f.users...)
+	if order.Desc {
+		sort.Slice(users, func(i, j int) bool { return users[i].ID > users[j].ID })
+	}
+
+	return users, nil
+}
@@ -0,0 +1,215 @@
+// Package ssotest helps services depending on SSO to test against it
+// without Postgres or the real binary.
+//
+// NewServer runs the real auth service over in-memory Storage,
+// NewServerWithAuth serves any implementation, e.g. FakeAuth.
+// Tokens for the default app can be minted with MustMintToken.
+package ssotest
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"sso/internal/domain/models"
+	authgrpc "sso/internal/grpc/auth"
+	"sso/internal/lib/secret"
+	"sso/internal/lib/webauthn"
+	"sso/internal/services/auth"
+	"sync"
+	"testing"
+	"time"
+
+	ssov1 "github.com/wadt3rr/city-events-auth-protos/gen/go/sso"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// Server is an in-process SSO gRPC server with a connected client.
+type Server struct {
+	Client ssov1.AuthClient
+	Conn   *grpc.ClientConn
+
+	// Auth and Storage are set by NewServer only.
+	Auth    *auth.Auth
+	Storage *Storage
+	SMS     *SMS
+	Mail    *Mail
+}
+
+// Option changes the options of the auth service started by NewServer,
+// e.g. to enable lockout.
+type Option func(*auth.Options)
+
+// NewServer starts the real auth service over in-memory storage
+// seeded with the default app (AppID, AppSecret).
+func NewServer(t testing.TB, opts ...Option) *Server {
+	t.Helper()
+
+	return NewServerWithDeps(t, nil, opts...)
+}
+
+// NewServerWithDeps is NewServer with dependencies the storage doesn't
+// provide, e.g. identity providers, set by setDeps.
+func NewServerWithDeps(t testing.TB, setDeps func(*auth.Deps), opts ...Option) *Server {
+	t.Helper()
+
+	st := NewStorage()
+	st.AddApp(models.App{ID: AppID, Name: AppName, Secret: AppSecret})
+
+	sms := &SMS{}
+	mail := &Mail{}
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	// Fixed key, secrets of the in-memory storage don't need protection.
+	box, _ := secret.NewBox(bytes.Repeat([]byte{1}, 32))
+
+	options := auth.Options{
+		TokenTTL:        time.Hour,
+		RefreshTTL:      30 * 24 * time.Hour,
+		ServiceTokenTTL: 15 * time.Minute,
+		TokenLeeway:     30 * time.Second,
+		AppSecretGrace:  24 * time.Hour,
+		MFABox:          box,
+		MFAIssuer:       AppName,
+		WebAuthn:        webauthn.Config{RPID: "localhost", Origins: []string{"http://localhost"}},
+		MagicLinkURL:    MagicLinkURL,
+		OIDCIssuer:      Issuer,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	deps := auth.Deps{
+		UserSaver:       st,
+		UserProvider:    st,
+		AppProvider:     st,
+		RoleManager:     st,
+		JTIStore:        st,
+		OTPStore:        st,
+		TokenStore:      st,
+		RefreshStore:    st,
+		RevocationStore: st,
+		MFAStore:        st,
+		PasskeyStore:    st,
+		OAuthStore:      st,
+		APIKeyStore:     st,
+		SessionStore:    st,
+		LoginHistory:    st,
+		Outbox:          st,
+		AuditLog:        st,
+		LockoutStore:    st,
+		GroupStore:      st,
+		OrgStore:        st,
+		WebhookStore:    st,
+		Transactor:      st,
+		SMS:             sms,
+		Mailer:          mail,
+	}
+	if setDeps != nil {
+		setDeps(&deps)
+	}
+
+	a := auth.New(log, deps, options)
+
+	srv := NewServerWithAuth(t, a)
+	srv.Auth = a
+	srv.Storage = st
+	srv.SMS = sms
+	srv.Mail = mail
+
+	return srv
+}
+
+// NewServerWithAuth serves the given Auth implementation. The server is stopped on test cleanup.
+func NewServerWithAuth(t testing.TB, a authgrpc.Auth) *Server {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+
+	gRPCServer := grpc.NewServer()
+	authgrpc.Register(gRPCServer, a)
+
+	go func() {
+		_ = gRPCServer.Serve(lis)
+	}()
+
+	conn, err := grpc.NewClient("passthrough:///ssotest",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("ssotest: dial: %v", err)
+	}
+
+	t.Cleanup(func() {
+		_ = conn.Close()
+		gRPCServer.Stop()
+	})
+
+	return &Server{
+		Client: ssov1.NewAuthClient(conn),
+		Conn:   conn,
+	}
+}
+
+// SMS records messages instead of sending them.
+type SMS struct {
+	mu       sync.Mutex
+	messages []SMSMessage
+}
+
+type SMSMessage struct {
+	Phone string
+	Text  string
+}
+
+func (s *SMS) Send(_ context.Context, phone string, text string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.messages = append(s.messages, SMSMessage{Phone: phone, Text: text})
+
+	return nil
+}
+
+// Messages returns messages sent so far.
+func (s *SMS) Messages() []SMSMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]SMSMessage(nil), s.messages...)
+}
+
+// Mail records emails instead of sending them.
+type Mail struct {
+	mu     sync.Mutex
+	emails []Email
+}
+
+type Email struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+func (m *Mail) Send(_ context.Context, to string, subject string, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.emails = append(m.emails, Email{To: to, Subject: subject, Body: body})
+
+	return nil
+}
+
+// Emails returns emails sent so far.
+func (m *Mail) Emails() []Email {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Email(nil), m.emails...)
+}
@@ -0,0 +1,11 @@
+package ssotest
+
+import "sso/internal/storage/memory"
+
+// Storage is the in-memory storage also served by the binary with storage: memory.
+// It is safe for concurrent use.
+type Storage = memory.Storage
+
+func NewStorage() *Storage {
+	return memory.New()
+}
@@ -0,0 +1,231 @@
+package ssotest
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+	"slices"
+	"sort"
+	"testing"
+)
+
+// COSE algorithms of the authenticator, see https://www.iana.org/assignments/cose.
+const (
+	ES256 = -7
+	EdDSA = -8
+	RS256 = -257
+)
+
+// Authenticator data flags.
+const (
+	FlagUserPresent  = 0x01
+	FlagUserVerified = 0x04
+	flagAttested     = 0x40
+)
+
+// Authenticator is a software passkey answering WebAuthn ceremonies the way
+// a browser and a platform authenticator together would.
+type Authenticator struct {
+	// RPID is hashed into authenticator data.
+	RPID string
+	// Origin is reported in clientDataJSON.
+	Origin string
+	// Flags of the authenticator data, user present and verified by default.
+	Flags byte
+	// SignCount is incremented before each assertion.
+	SignCount    uint32
+	CredentialID []byte
+
+	alg int
+	key crypto.Signer
+}
+
+// NewAuthenticator generates a key of the COSE algorithm alg (ES256, RS256
+// or EdDSA), failing the test on error.
+func NewAuthenticator(t testing.TB, alg int, rpID string, origin string) *Authenticator {
+	t.Helper()
+
+	var (
+		key crypto.Signer
+		err error
+	)
+
+	switch alg {
+	case ES256:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case RS256:
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case EdDSA:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		t.Fatalf("ssotest: unsupported algorithm %d", alg)
+	}
+	if err != nil {
+		t.Fatalf("ssotest: generate passkey: %v", err)
+	}
+
+	return &Authenticator{
+		RPID:         rpID,
+		Origin:       origin,
+		Flags:        FlagUserPresent | FlagUserVerified,
+		CredentialID: []byte(rand.Text()),
+		alg:          alg,
+		key:          key,
+	}
+}
+
+// Register answers navigator.credentials.create() with the challenge.
+func (a *Authenticator) Register(t testing.TB, challenge string) (clientDataJSON []byte, attestationObject []byte) {
+	t.Helper()
+
+	clientDataJSON = a.clientData(t, "webauthn.create", challenge)
+
+	// aaguid (16 bytes), credential id length (2 bytes), credential id, COSE key
+	authData := a.authData(a.Flags | flagAttested)
+	authData = append(authData, make([]byte, 16)...)
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(a.CredentialID)))
+	authData = append(authData, a.CredentialID...)
+	authData = append(authData, encodeCBOR(a.coseKey())...)
+
+	attestationObject = encodeCBOR(map[any]any{
+		"fmt":      "none",
+		"attStmt":  map[any]any{},
+		"authData": authData,
+	})
+
+	return clientDataJSON, attestationObject
+}
+
+// Assert answers navigator.credentials.get() with the challenge.
+func (a *Authenticator) Assert(t testing.TB, challenge string) (clientDataJSON []byte, authenticatorData []byte, signature []byte) {
+	t.Helper()
+
+	a.SignCount++
+
+	clientDataJSON = a.clientData(t, "webauthn.get", challenge)
+	authenticatorData = a.authData(a.Flags)
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(slices.Clone(authenticatorData), clientDataHash[:]...)
+
+	var err error
+	switch a.alg {
+	case EdDSA:
+		signature, err = a.key.Sign(rand.Reader, signed, crypto.Hash(0))
+	default:
+		sum := sha256.Sum256(signed)
+		signature, err = a.key.Sign(rand.Reader, sum[:], crypto.SHA256)
+	}
+	if err != nil {
+		t.Fatalf("ssotest: sign assertion: %v", err)
+	}
+
+	return clientDataJSON, authenticatorData, signature
+}
+
+func (a *Authenticator) clientData(t testing.TB, typ string, challenge string) []byte {
+	t.Helper()
+
+	b, err := json.Marshal(map[string]string{"type": typ, "challenge": challenge, "origin": a.Origin})
+	if err != nil {
+		t.Fatalf("ssotest: marshal client data: %v", err)
+	}
+
+	return b
+}
+
+// authData returns RP ID hash, flags and the signature counter.
+func (a *Authenticator) authData(flags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.RPID))
+
+	data := append(rpIDHash[:], flags)
+
+	return binary.BigEndian.AppendUint32(data, a.SignCount)
+}
+
+func (a *Authenticator) coseKey() map[any]any {
+	switch k := a.key.Public().(type) {
+	case *ecdsa.PublicKey:
+		return map[any]any{
+			int64(1):  int64(2), // kty: EC2
+			int64(3):  int64(ES256),
+			int64(-1): int64(1), // crv: P-256
+			int64(-2): k.X.FillBytes(make([]byte, 32)),
+			int64(-3): k.Y.FillBytes(make([]byte, 32)),
+		}
+	case *rsa.PublicKey:
+		return map[any]any{
+			int64(1):  int64(3), // kty: RSA
+			int64(3):  int64(RS256),
+			int64(-1): k.N.Bytes(),
+			int64(-2): big.NewInt(int64(k.E)).Bytes(),
+		}
+	case ed25519.PublicKey:
+		return map[any]any{
+			int64(1):  int64(1), // kty: OKP
+			int64(3):  int64(EdDSA),
+			int64(-1): int64(6), // crv: Ed25519
+			int64(-2): []byte(k),
+		}
+	}
+
+	return nil
+}
+
+// encodeCBOR encodes integers, strings, byte strings and maps of them.
+func encodeCBOR(v any) []byte {
+	switch v := v.(type) {
+	case int64:
+		if v < 0 {
+			return cborHead(1, uint64(-1-v))
+		}
+
+		return cborHead(0, uint64(v))
+	case []byte:
+		return append(cborHead(2, uint64(len(v))), v...)
+	case string:
+		return append(cborHead(3, uint64(len(v))), v...)
+	case map[any]any:
+		// Порядок ключей детерминированный, чтобы ответы были воспроизводимы
+		keys := make([][]byte, 0, len(v))
+		values := make(map[string][]byte, len(v))
+		for k, val := range v {
+			ek := encodeCBOR(k)
+			keys = append(keys, ek)
+			values[string(ek)] = encodeCBOR(val)
+		}
+		sort.Slice(keys, func(i, j int) bool { return string(keys[i]) < string(keys[j]) })
+
+		out := cborHead(5, uint64(len(v)))
+		for _, k := range keys {
+			out = append(out, k...)
+			out = append(out, values[string(k)]...)
+		}
+
+		return out
+	}
+
+	panic("ssotest: unsupported cbor value")
+}
+
+func cborHead(major byte, arg uint64) []byte {
+	switch {
+	case arg < 24:
+		return []byte{major<<5 | byte(arg)}
+	case arg <= 0xff:
+		return []byte{major<<5 | 24, byte(arg)}
+	case arg <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(arg))
+	case arg <= 0xffffffff:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(arg))
+	}
+
+	return binary.BigEndian.AppendUint64([]byte{major<<5 | 27}, arg)
+}